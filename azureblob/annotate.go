@@ -0,0 +1,140 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// transferFailures is returned by the multi-file commands when some of
+// their transfers failed, keeping the error of each. noun names the
+// transfers in the message, e.g. "downloads".
+type transferFailures struct {
+	errs  []error
+	total int
+	noun  string
+}
+
+func (e *transferFailures) Error() string {
+	return fmt.Sprintf("%d of %d %s failed", len(e.errs), e.total, e.noun)
+}
+
+// manifestError returns a *transferFailures for the failed results, or nil
+// if there are none.
+func manifestError(results []ManifestResult) error {
+	var errs []error
+	for _, r := range results {
+		if r.err != nil {
+			errs = append(errs, r.err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &transferFailures{errs: errs, total: len(results), noun: "transfers"}
+}
+
+// suggestedFix returns the message suggesting how to resolve err, if the
+// kind of failure has an obvious remedy.
+func suggestedFix(err error) (MessageID, bool) {
+	var be *BlobError
+	var checksumErr *ChecksumError
+	var spaceErr *DiskSpaceError
+	switch {
+	case isNotFound(err):
+		return MsgFixNotFound, true
+	case errors.As(err, &be) && be.StatusCode == http.StatusForbidden && be.ErrorCode == "AuthorizationPermissionMismatch":
+		return MsgFixPermission, true
+	case isThrottled(err):
+		return MsgFixThrottled, true
+	case errors.As(err, &checksumErr):
+		return MsgFixChecksum, true
+	case errors.As(err, &spaceErr):
+		return MsgFixDiskSpace, true
+	case isStaleClientError(err):
+		return MsgFixAuth, true
+	case errors.Is(err, context.DeadlineExceeded):
+		return MsgFixTimeout, true
+	}
+	return "", false
+}
+
+// failureDetails returns the lines describing a single failure: the error,
+// the request ID the service assigned, if any, and the suggested fix.
+func failureDetails(msgs Messages, err error) []string {
+	lines := []string{err.Error()}
+	var be *BlobError
+	if errors.As(err, &be) && be.RequestID != "" {
+		lines = append(lines, msgs.format(MsgFailureRequestID, be.RequestID))
+	}
+	if fix, ok := suggestedFix(err); ok {
+		lines = append(lines, msgs.format(MsgFailureFix, msgs.format(fix)))
+	}
+	return lines
+}
+
+// annotateBuildkite adds body as an error annotation of the build under
+// the annotation context name. It is a variable so tests can replace it.
+var annotateBuildkite = func(name, body string) error {
+	if _, err := exec.LookPath("buildkite-agent"); err != nil {
+		// Without the agent on the PATH the log group has to do.
+		return nil
+	}
+	cmd := exec.Command("buildkite-agent", "annotate", "--style", "error", "--context", name)
+	cmd.Stdin = strings.NewReader(body)
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// reportBuildkiteFailure writes err to w as an expanded Buildkite log group
+// and adds it to the build as an annotation, listing each failed transfer
+// with its request ID and suggested fix.
+func reportBuildkiteFailure(w io.Writer, msgs Messages, lookup func(string) (string, bool), err error) {
+	failures := []error{err}
+	var tf *transferFailures
+	if errors.As(err, &tf) {
+		failures = tf.errs
+	}
+	heading := msgs.format(MsgFailureHeading, err)
+	fmt.Fprintf(w, "+++ :x: %s\n", heading)
+	var body strings.Builder
+	fmt.Fprintf(&body, "**%s**\n\n", heading)
+	for _, f := range failures {
+		lines := failureDetails(msgs, f)
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+		}
+		fmt.Fprintf(&body, "- `%s`\n", strings.ReplaceAll(lines[0], "`", "'"))
+		for _, line := range lines[1:] {
+			fmt.Fprintf(&body, "  %s\n", line)
+		}
+	}
+	name := "bk_azureblob"
+	if job, ok := lookup("BUILDKITE_JOB_ID"); ok {
+		name += "-" + job
+	}
+	if err := annotateBuildkite(name, body.String()); err != nil {
+		log.Printf("buildkite-agent annotate: %v", err)
+	}
+}
+
+// fatal reports err and exits with its exitCode. Inside a Buildkite job
+// the failure is reported by reportBuildkiteFailure, and in GitHub Actions
+// by reportGitHubFailure, instead of a bare log line.
+func fatal(msgs Messages, err error) {
+	switch ciSystem {
+	case ciBuildkite:
+		reportBuildkiteFailure(os.Stderr, msgs, os.LookupEnv, err)
+	case ciGitHub:
+		reportGitHubFailure(os.Stdout, msgs, err)
+	default:
+		log.Print(err)
+	}
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,78 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSuggestedFix(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want MessageID
+	}{
+		{"not found", &BlobError{Op: "download", Blob: "a", StatusCode: 404, ErrorCode: "BlobNotFound"}, MsgFixNotFound},
+		{"permission", &BlobError{StatusCode: 403, ErrorCode: "AuthorizationPermissionMismatch"}, MsgFixPermission},
+		{"authentication", fmt.Errorf("stat: %w", &BlobError{StatusCode: 401}), MsgFixAuth},
+		{"throttled", &BlobError{StatusCode: 503, ErrorCode: "ServerBusy"}, MsgFixThrottled},
+		{"timeout", fmt.Errorf("stat: %w", context.DeadlineExceeded), MsgFixTimeout},
+		{"checksum", &ChecksumError{Path: "a", Algorithm: "md5"}, MsgFixChecksum},
+		{"disk space", fmt.Errorf("download: %w", &DiskSpaceError{Dir: "/", Need: 2, Free: 1}), MsgFixDiskSpace},
+		{"other", errors.New("disk full"), ""},
+	}
+	for _, tt := range tests {
+		if got, _ := suggestedFix(tt.err); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReportBuildkiteFailure(t *testing.T) {
+	var gotName, gotBody string
+	defer func(orig func(string, string) error) { annotateBuildkite = orig }(annotateBuildkite)
+	annotateBuildkite = func(name, body string) error {
+		gotName, gotBody = name, body
+		return nil
+	}
+	err := manifestError([]ManifestResult{
+		{Direction: "download", err: &BlobError{Op: "download", Blob: "missing`name", StatusCode: 404, ErrorCode: "BlobNotFound", RequestID: "req-1"}},
+		{Direction: "upload"},
+		{Direction: "upload", err: errors.New("disk full")},
+	})
+	if err == nil || err.Error() != "2 of 3 transfers failed" {
+		t.Fatalf("manifestError = %v", err)
+	}
+	var log strings.Builder
+	reportBuildkiteFailure(&log, nil, mapLookup(map[string]string{"BUILDKITE_JOB_ID": "job-1"}), err)
+
+	wantLog := "+++ :x: bk_azureblob failed: 2 of 3 transfers failed\n" +
+		"download \"missing`name\": HTTP 404 BlobNotFound (x-ms-request-id req-1)\n" +
+		"request ID: req-1\n" +
+		"suggested fix: " + defaultMessages[MsgFixNotFound].format + "\n" +
+		"disk full\n"
+	if log.String() != wantLog {
+		t.Errorf("log group:\n%s\nwant:\n%s", log.String(), wantLog)
+	}
+	if gotName != "bk_azureblob-job-1" {
+		t.Errorf("annotation context %q", gotName)
+	}
+	for _, want := range []string{
+		"**bk_azureblob failed: 2 of 3 transfers failed**",
+		"- `download \"missing'name\": HTTP 404",
+		"  request ID: req-1\n",
+		"- `disk full`\n",
+	} {
+		if !strings.Contains(gotBody, want) {
+			t.Errorf("annotation does not contain %q:\n%s", want, gotBody)
+		}
+	}
+}
+
+func TestManifestErrorNone(t *testing.T) {
+	if err := manifestError([]ManifestResult{{Direction: "upload"}}); err != nil {
+		t.Errorf("got %v", err)
+	}
+}
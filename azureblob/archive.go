@@ -0,0 +1,467 @@
+package azureblob
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// archiveBlockSize is the size of the blocks an archive is staged in. With
+// the service's limit of 50,000 blocks per blob, it bounds an archive to
+// about 195 GiB.
+const archiveBlockSize = 4 << 20
+
+// archiveBuffers is the number of blocks of an archive upload held in memory
+// and staged at once.
+const archiveBuffers = 4
+
+// ArchiveStats counts the regular files an archive holds and their bytes.
+type ArchiveStats struct {
+	Files int
+	Bytes int64
+}
+
+// archiveCompression returns the compression the name of an archive blob
+// implies: gzip for .tar.gz and .tgz, zstd for .tar.zst and .tzst, and none
+// for .tar.
+func archiveCompression(blob string) (string, error) {
+	name := strings.ToLower(blob)
+	switch {
+	case strings.HasSuffix(name, ".tar"):
+		return "", nil
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return compressionGzip, nil
+	case strings.HasSuffix(name, ".tar.zst"), strings.HasSuffix(name, ".tzst"):
+		return compressionZstd, nil
+	}
+	return "", fmt.Errorf("archive %q: name must end in .tar, .tar.gz, .tgz, .tar.zst or .tzst", blob)
+}
+
+// UploadArchive streams a tar of the directory dir to blobPath, compressed
+// as the blob's name implies, without staging the archive on disk. The tar
+// holds the regular files, directories and symlinks under dir, named
+// relative to it. ClientOptions apply as they do to Upload.
+func (c *AzureBlobClient) UploadArchive(ctx context.Context, dir, blobPath string) (ArchiveStats, error) {
+	compression, err := archiveCompression(blobPath)
+	if err != nil {
+		return ArchiveStats{}, err
+	}
+	if err := checkDir(dir); err != nil {
+		return ArchiveStats{}, err
+	}
+	var stats ArchiveStats
+	err = c.withRebuild(ctx, "upload", blobPath, func() error {
+		var err error
+		stats, err = c.uploadArchive(ctx, dir, blobPath, compression)
+		return err
+	})
+	return stats, err
+}
+
+func (c *AzureBlobClient) uploadArchive(ctx context.Context, dir, blobPath, compression string) (ArchiveStats, error) {
+	if err := c.init(ctx); err != nil {
+		return ArchiveStats{}, err
+	}
+	var stats ArchiveStats
+	pr, pw := io.Pipe()
+	written := make(chan error, 1)
+	go func() {
+		err := writeArchive(pw, dir, compression, c.clientOptions().Symlinks == symlinksFollow, &stats)
+		pw.CloseWithError(err)
+		written <- err
+	}()
+	err := c.uploadArchiveStream(ctx, blobPath, pr)
+	pr.Close()
+	// A failure to read dir surfaces in the upload as the pipe's error,
+	// which is the more useful one to report.
+	if werr := <-written; err != nil && werr != nil && !errors.Is(werr, io.ErrClosedPipe) {
+		return stats, werr
+	}
+	if err != nil {
+		return stats, err
+	}
+	return stats, c.protectUpload(ctx, blobPath)
+}
+
+// uploadArchiveStream uploads the tar read from src to blobPath, encoded as
+// ClientOptions asks.
+func (c *AzureBlobClient) uploadArchiveStream(ctx context.Context, blobPath string, src io.Reader) error {
+	encoded, metadata, headers, err := c.encodeUpload(ctx, src)
+	if err != nil {
+		return fmt.Errorf("encode %q: %w", blobPath, err)
+	}
+	if encoded != nil {
+		defer encoded.Close()
+		src = encoded
+	}
+	if headers == nil {
+		headers = &azblob.BlobHTTPHeaders{}
+	}
+	contentType := "application/x-tar"
+	switch compression, _ := archiveCompression(blobPath); compression {
+	case compressionGzip:
+		contentType = "application/gzip"
+	case compressionZstd:
+		contentType = "application/zstd"
+	}
+	headers.BlobContentType = &contentType
+	if err := c.uploadBlocks(ctx, blobPath, src, headers, metadata); err != nil {
+		return newBlobError("upload", blobPath, err)
+	}
+	return nil
+}
+
+// uploadBlocks uploads src to blobPath in blocks of archiveBlockSize,
+// staging up to archiveBuffers of them at once from pooled buffers, and
+// commits them with headers, the Content-MD5 of the bytes stored and
+// metadata. The SDK's UploadStreamToBlockBlob would do the same but drops
+// the headers, metadata and encryption scope.
+func (c *AzureBlobClient) uploadBlocks(ctx context.Context, blobPath string, src io.Reader, headers *azblob.BlobHTTPHeaders, metadata map[string]string) error {
+	blob := c.containerClient.NewBlockBlobClient(blobPath)
+	scope := c.clientOptions().cpkScopeInfo()
+	upload, err := newUploadID()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		ids      []string
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+		mu.Unlock()
+	}
+	h := md5.New()
+	slots := make(chan struct{}, archiveBuffers)
+	for i := 0; ctx.Err() == nil; i++ {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			continue
+		}
+		buf := getBuffer(archiveBlockSize)
+		n, err := io.ReadFull(src, *buf)
+		h.Write((*buf)[:n])
+		if n > 0 {
+			id := blockID(upload, i)
+			ids = append(ids, id)
+			wg.Add(1)
+			go func(buf *[]byte, n int) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				defer putBuffer(buf)
+				body := streaming.NopCloser(bytes.NewReader((*buf)[:n]))
+				if _, err := blob.StageBlock(ctx, id, body, &azblob.StageBlockOptions{CpkScopeInfo: scope}); err != nil {
+					fail(err)
+				}
+			}(buf, n)
+		} else {
+			putBuffer(buf)
+			<-slots
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			fail(err)
+		}
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	committed := azblob.BlobHTTPHeaders{}
+	if headers != nil {
+		committed = *headers
+	}
+	committed.BlobContentMD5 = h.Sum(nil)
+	_, err = blob.CommitBlockList(ctx, ids, &azblob.CommitBlockListOptions{
+		BlobHTTPHeaders: &committed,
+		Metadata:        metadata,
+		CpkScopeInfo:    scope,
+	})
+	return err
+}
+
+// writeArchive writes a tar of dir to w, compressed with compression if it
+// is set, counting the regular files it holds in stats. With follow, it
+// holds what symlinks point to instead of the links.
+func writeArchive(w io.Writer, dir, compression string, follow bool, stats *ArchiveStats) error {
+	var zw io.WriteCloser
+	if compression != "" {
+		var err error
+		if zw, err = compressWriter(w, compression); err != nil {
+			return err
+		}
+		w = zw
+	}
+	tw := tar.NewWriter(w)
+	dir = localRoot(dir)
+	err := walkTree(dir, follow, func(p string, info fs.FileInfo) error {
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return fmt.Errorf("archive %s: %w", p, err)
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		n, err := copyPooled(tw, f)
+		stats.Files++
+		stats.Bytes += n
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if zw != nil {
+		return zw.Close()
+	}
+	return nil
+}
+
+// DownloadArchive streams the tar blob, decompressed as its name implies,
+// and extracts it into dir, creating dir if needed, without staging the
+// archive on disk. Entries that would land outside dir or pass through a
+// symlink are refused. Fallback containers are not consulted.
+func (c *AzureBlobClient) DownloadArchive(ctx context.Context, blob, dir string) (ArchiveStats, error) {
+	compression, err := archiveCompression(blob)
+	if err != nil {
+		return ArchiveStats{}, err
+	}
+	var stats ArchiveStats
+	err = c.withRebuild(ctx, "download", blob, func() error {
+		var err error
+		stats, err = c.downloadArchive(ctx, blob, dir, compression)
+		return err
+	})
+	return stats, err
+}
+
+func (c *AzureBlobClient) downloadArchive(ctx context.Context, blob, dir, compression string) (ArchiveStats, error) {
+	props, err := c.stat(ctx, blob)
+	if err != nil {
+		return ArchiveStats{}, err
+	}
+	data, err := encryptionDataFromMetadata(props.Metadata)
+	if err != nil && !errors.Is(err, errNotEncrypted) {
+		return ArchiveStats{}, fmt.Errorf("download %q: %w", blob, err)
+	}
+	body, err := c.openRange(ctx, c.containerClient.NewBlobClient(blob), props.ETag, 0, props.Size)
+	if err != nil {
+		return ArchiveStats{}, newBlobError("download", blob, err)
+	}
+	defer body.Close()
+	content, err := c.decodeDownload(ctx, blob, data, c.clientOptions().downloadCompression(props), body)
+	if err != nil {
+		return ArchiveStats{}, err
+	}
+	defer content.Close()
+	var src io.Reader = content
+	if compression != "" {
+		zr, err := decompressReader(src, compression)
+		if err != nil {
+			return ArchiveStats{}, fmt.Errorf("decompress %q: %w", blob, err)
+		}
+		defer zr.Close()
+		src = zr
+	}
+	stats, err := extractArchive(src, dir)
+	if err != nil {
+		return stats, fmt.Errorf("extract %q: %w", blob, err)
+	}
+	return stats, nil
+}
+
+// extractArchive extracts the tar read from r into dir.
+func extractArchive(r io.Reader, dir string) (ArchiveStats, error) {
+	var stats ArchiveStats
+	dir = localRoot(dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return stats, err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, err
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		name := hdr.Name
+		if windowsPaths {
+			// Archives made on Windows may separate with backslashes,
+			// which would otherwise hide a ".." from the check below.
+			name = strings.ReplaceAll(name, `\`, "/")
+		}
+		name = path.Clean(name)
+		if name == "." {
+			continue
+		}
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return stats, fmt.Errorf("entry %q is outside the destination", hdr.Name)
+		}
+		if err := checkNoSymlink(dir, path.Dir(name)); err != nil {
+			return stats, fmt.Errorf("entry %q: %w", hdr.Name, err)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		mode := hdr.FileInfo().Mode().Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode|0700); err != nil {
+				return stats, err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return stats, err
+			}
+			n, err := extractFile(tr, target, mode)
+			if err != nil {
+				return stats, err
+			}
+			if err := os.Chtimes(target, hdr.ModTime, hdr.ModTime); err != nil {
+				return stats, err
+			}
+			stats.Files++
+			stats.Bytes += n
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return stats, err
+			}
+			if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return stats, err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return stats, err
+			}
+		default:
+			return stats, fmt.Errorf("entry %q has unsupported type %q", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+// extractFile writes the content of the current tar entry to target,
+// replacing rather than writing through anything already there.
+func extractFile(r io.Reader, target string, mode fs.FileMode) (int64, error) {
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return 0, err
+	}
+	n, err := copyPooled(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// checkNoSymlink fails if any existing directory on the slash-separated
+// path rel under dir is a symlink, which extracting through could escape
+// dir.
+func checkNoSymlink(dir, rel string) error {
+	if rel == "." {
+		return nil
+	}
+	p := dir
+	for _, part := range strings.Split(rel, "/") {
+		p = filepath.Join(p, part)
+		info, err := os.Lstat(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("%s is a symlink", p)
+		}
+	}
+	return nil
+}
+
+func runArchive(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("archive", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: archive upload <dir> <blob.tar[.gz|.zst]>\n       archive download <blob.tar[.gz|.zst]> <dir>\n\nThe blob name chooses the compression: .tar for none, .tar.gz or .tgz for gzip, and .tar.zst or .tzst for zstd.\n")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 3 {
+		fs.Usage()
+		return errors.New("archive takes upload <dir> <blob> or download <blob> <dir>")
+	}
+	switch fs.Arg(0) {
+	case "upload":
+		stats, err := az.UploadArchive(ctx, fs.Arg(1), fs.Arg(2))
+		if err != nil {
+			return err
+		}
+		fmt.Println(az.Messages.format(MsgArchived, fs.Arg(2), stats.Files, formatBytes(stats.Bytes)))
+	case "download":
+		stats, err := az.DownloadArchive(ctx, fs.Arg(1), fs.Arg(2))
+		if err != nil {
+			return err
+		}
+		fmt.Println(az.Messages.format(MsgExtracted, fs.Arg(1), stats.Files, formatBytes(stats.Bytes), fs.Arg(2)))
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown archive mode %q, want upload or download", fs.Arg(0))
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package azureblob
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArchiveCompression(t *testing.T) {
+	tests := []struct {
+		blob, want string
+		ok         bool
+	}{
+		{"snap.tar", "", true},
+		{"snap.TAR.GZ", compressionGzip, true},
+		{"snap.tgz", compressionGzip, true},
+		{"snap.tar.zst", compressionZstd, true},
+		{"snap.TZST", compressionZstd, true},
+		{"snap.zip", "", false},
+	}
+	for _, tt := range tests {
+		got, err := archiveCompression(tt.blob)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("archiveCompression(%q) = %q, %v", tt.blob, got, err)
+		}
+	}
+}
+
+// archiveTree creates a directory to archive, returning it and the content
+// of its regular files by relative path.
+func archiveTree(t *testing.T) (string, map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	big := make([]byte, 2*archiveBlockSize+100)
+	rand.New(rand.NewSource(1)).Read(big)
+	files := map[string]string{
+		"a.txt":         "alpha",
+		"sub/b.txt":     "beta",
+		"sub/deep/big":  string(big),
+		"sub/deep/zero": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, path, content)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub/b.txt", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	return dir, files
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	src, files := archiveTree(t)
+	ctx := context.Background()
+	for _, tt := range []struct {
+		blob, contentType string
+		compress          bool
+		encrypt           bool
+	}{
+		{blob: "snap.tar", contentType: "application/x-tar"},
+		{blob: "snap.tar.gz", contentType: "application/gzip"},
+		{blob: "snap.tar.zst", contentType: "application/zstd"},
+		{blob: "snap.tar", contentType: "application/x-tar", compress: true, encrypt: true},
+	} {
+		m := newMemContainer()
+		az := newTestClient(t, m)
+		if tt.compress {
+			az.ClientOptions.Compression = compressionGzip
+		}
+		if tt.encrypt {
+			az.ClientOptions.EncryptUploads = true
+			az.Keys = mustKeyRing(t, "k1", map[string][]byte{"k1": testKey(1)})
+		}
+		stats, err := az.UploadArchive(ctx, src, tt.blob)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.blob, err)
+		}
+		if stats.Files != len(files) || stats.Bytes != int64(2*archiveBlockSize+100+len("alpha")+len("beta")) {
+			t.Errorf("%s: uploaded %+v", tt.blob, stats)
+		}
+		stored := m.blobs[tt.blob]
+		if stored.contentType != tt.contentType {
+			t.Errorf("%s: Content-Type %q, want %q", tt.blob, stored.contentType, tt.contentType)
+		}
+		if _, _, ok := findEncryptionData(stored.metadata); ok != tt.encrypt {
+			t.Errorf("%s: encryption metadata %v", tt.blob, stored.metadata)
+		}
+		if tt.encrypt && bytes.Contains(stored.data, []byte("alpha")) {
+			t.Errorf("%s: the blob holds plaintext", tt.blob)
+		}
+
+		dest := filepath.Join(t.TempDir(), "out")
+		got, err := az.DownloadArchive(ctx, tt.blob, dest)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.blob, err)
+		}
+		if got != stats {
+			t.Errorf("%s: extracted %+v, uploaded %+v", tt.blob, got, stats)
+		}
+		for name, content := range files {
+			if readFile(t, filepath.Join(dest, filepath.FromSlash(name))) != content {
+				t.Errorf("%s: %s differs", tt.blob, name)
+			}
+		}
+		if info, err := os.Stat(filepath.Join(dest, "empty")); err != nil || !info.IsDir() {
+			t.Errorf("%s: empty directory not extracted: %v", tt.blob, err)
+		}
+		if link, err := os.Readlink(filepath.Join(dest, "link")); err != nil || link != "sub/b.txt" {
+			t.Errorf("%s: link = %q, %v", tt.blob, link, err)
+		}
+	}
+}
+
+func TestArchiveUploadMissingDir(t *testing.T) {
+	az := newTestClient(t, newMemContainer())
+	if _, err := az.UploadArchive(context.Background(), filepath.Join(t.TempDir(), "missing"), "snap.tar"); err == nil {
+		t.Error("UploadArchive of a missing directory succeeded")
+	}
+}
+
+func tarOf(t *testing.T, headers ...*tar.Header) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range headers {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write(make([]byte, hdr.Size))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractArchiveRefusesEscapes(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []*tar.Header
+	}{
+		{"parent", []*tar.Header{{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644, Size: 1}}},
+		{"absolute", []*tar.Header{{Name: "/etc/evil", Typeflag: tar.TypeReg, Mode: 0644, Size: 1}}},
+		{"through symlink", []*tar.Header{
+			{Name: "out", Typeflag: tar.TypeSymlink, Linkname: ".."},
+			{Name: "out/evil", Typeflag: tar.TypeReg, Mode: 0644, Size: 1},
+		}},
+		{"hard link", []*tar.Header{{Name: "hard", Typeflag: tar.TypeLink, Linkname: "a"}}},
+	}
+	for _, tt := range tests {
+		root := t.TempDir()
+		dir := filepath.Join(root, "dest")
+		_, err := extractArchive(bytes.NewReader(tarOf(t, tt.headers...)), dir)
+		if err == nil {
+			t.Errorf("%s: extracted", tt.name)
+		}
+		if _, err := os.Stat(filepath.Join(root, "evil")); err == nil {
+			t.Errorf("%s: wrote outside the destination", tt.name)
+		}
+	}
+}
+
+func TestExtractArchiveReplacesSymlink(t *testing.T) {
+	dir := t.TempDir()
+	outside := writeFile(t, filepath.Join(t.TempDir(), "outside"), "keep")
+	if err := os.Symlink(outside, filepath.Join(dir, "f")); err != nil {
+		t.Fatal(err)
+	}
+	archive := tarOf(t, &tar.Header{Name: "f", Typeflag: tar.TypeReg, Mode: 0644, Size: 3})
+	if _, err := extractArchive(bytes.NewReader(archive), dir); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, outside); got != "keep" {
+		t.Errorf("extraction wrote through a symlink: %q", got)
+	}
+	if info, err := os.Lstat(filepath.Join(dir, "f")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("f was not replaced by a regular file: %v", err)
+	}
+}
+
+func TestRunArchiveUsage(t *testing.T) {
+	az := newTestClient(t, newMemContainer())
+	for _, args := range [][]string{nil, {"upload", "dir"}, {"list", "a", "b"}} {
+		if err := runArchive(context.Background(), az, args); err == nil || !strings.Contains(err.Error(), "archive") {
+			t.Errorf("runArchive(%q) = %v", args, err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// artifactPrefix returns the blob prefix under which the artifacts of the
+// current Buildkite job are uploaded: the pipeline slug, build ID and job
+// ID, so artifacts of different jobs never overwrite each other.
+func artifactPrefix(lookup func(string) (string, bool)) (string, error) {
+	var parts []string
+	for _, name := range []string{"BUILDKITE_PIPELINE_SLUG", "BUILDKITE_BUILD_ID", "BUILDKITE_JOB_ID"} {
+		v, ok := lookup(name)
+		if !ok || v == "" {
+			return "", fmt.Errorf("%s is not set; run inside a Buildkite job or give -prefix", name)
+		}
+		parts = append(parts, v)
+	}
+	return path.Join(parts...), nil
+}
+
+// artifactItems returns an upload for each regular file matching the
+// semicolon-separated globs, with the blob name of each being its path
+// relative to dir joined to prefix, like buildkite-agent artifact upload.
+// Globs use / as the separator and are relative to dir unless absolute; a *
+// matches within a path element and ** matches any number of elements. A
+// file matched by several globs is uploaded once. If nothing matches at all,
+// a warning is logged, as buildkite-agent does, rather than failing.
+// symlinks is ClientOptions.Symlinks: matched symlinks are followed,
+// uploaded as links, or by default skipped with a warning.
+func artifactItems(msgs Messages, dir, globs, prefix, symlinks string) ([]ManifestItem, error) {
+	dir = localRoot(dir)
+	var items []ManifestItem
+	seen := map[string]bool{}
+	for _, glob := range strings.Split(globs, ";") {
+		glob = strings.TrimSpace(glob)
+		if glob == "" {
+			continue
+		}
+		matches, err := matchArtifactGlob(dir, filepath.ToSlash(glob), symlinks == symlinksFollow)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			if seen[m.path] {
+				continue
+			}
+			seen[m.path] = true
+			if m.symlink && symlinks != symlinksPreserve {
+				log.Print(msgs.format(MsgSkippedSymlink, m.path))
+				continue
+			}
+			items = append(items, ManifestItem{Blob: path.Join(prefix, m.name), Path: m.path})
+		}
+	}
+	if len(items) == 0 {
+		log.Print(msgs.format(MsgNoArtifacts, globs))
+	}
+	return items, nil
+}
+
+// artifactMatch is a file matched by an artifact glob: its local path, the
+// name it is uploaded as, and whether it is a symlink.
+type artifactMatch struct {
+	path    string
+	name    string
+	symlink bool
+}
+
+// matchArtifactGlob walks the part of the tree the glob can match, which
+// begins at its leading elements without wildcards. With follow, it walks
+// through symlinks and matches what they point to.
+func matchArtifactGlob(dir, glob string, follow bool) ([]artifactMatch, error) {
+	base := dir
+	if path.IsAbs(glob) || filepath.IsAbs(glob) {
+		base = filepath.VolumeName(glob) + "/"
+		glob = strings.TrimLeft(glob[len(filepath.VolumeName(glob)):], "/")
+	}
+	pattern := strings.Split(path.Clean(glob), "/")
+	for _, elem := range pattern {
+		if elem == ".." {
+			return nil, fmt.Errorf("artifact glob %q reaches outside %s", glob, dir)
+		}
+		if _, err := path.Match(elem, ""); err != nil {
+			return nil, fmt.Errorf("invalid artifact glob %q: %w", glob, err)
+		}
+	}
+	literal := 0
+	for literal < len(pattern)-1 && !hasGlobMeta(pattern[literal]) {
+		literal++
+	}
+	root := filepath.Join(base, filepath.FromSlash(path.Join(pattern[:literal]...)))
+	if _, err := os.Lstat(root); errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	var matches []artifactMatch
+	err := walkTree(root, follow, func(p string, info fs.FileInfo) error {
+		symlink := info.Mode()&fs.ModeSymlink != 0
+		if !info.Mode().IsRegular() && !symlink {
+			return nil
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if matchElements(pattern, strings.Split(name, "/")) {
+			matches = append(matches, artifactMatch{path: p, name: name, symlink: symlink})
+		}
+		return nil
+	})
+	return matches, err
+}
+
+func hasGlobMeta(elem string) bool {
+	return strings.ContainsAny(elem, `*?[\`)
+}
+
+// matchElements reports whether the path elements name match the glob
+// elements pattern, in which ** stands for zero or more elements.
+func matchElements(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchElements(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// runArtifactUpload uploads the files matching a Buildkite-style glob list
+// under the prefix of the current job.
+func runArtifactUpload(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("artifact-upload", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "upload under `prefix` instead of that of -path-template or else <pipeline>/<build>/<job>")
+	dir := fs.String("dir", "", "resolve relative globs against `dir` instead of the current directory")
+	dryRun := fs.Bool("dry-run", false, "print what would be uploaded and its size without uploading")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: artifact-upload [flags] <glob;glob...>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("artifact-upload takes a list of globs")
+	}
+	if t := az.clientOptions().PathTemplate; *prefix == "" && t != nil {
+		var err error
+		if *prefix, err = t.Prefix(); err != nil {
+			return err
+		}
+	} else if *prefix == "" {
+		var err error
+		if *prefix, err = artifactPrefix(os.LookupEnv); err != nil {
+			return err
+		}
+	}
+	items, err := artifactItems(az.Messages, *dir, fs.Arg(0), *prefix, az.clientOptions().Symlinks)
+	if err != nil || len(items) == 0 {
+		return err
+	}
+	if *dryRun {
+		return planTransfers(ctx, az, &Manifest{Uploads: items})
+	}
+	return runTransfers(ctx, az, &Manifest{Uploads: items})
+}
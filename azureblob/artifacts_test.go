@@ -0,0 +1,121 @@
+package azureblob
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMatchElements(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*.txt", "a.txt", true},
+		{"*.txt", "logs/a.txt", false},
+		{"logs/*.txt", "logs/a.txt", true},
+		{"dist/**/*.pkg", "dist/a.pkg", true},
+		{"dist/**/*.pkg", "dist/x/y/a.pkg", true},
+		{"dist/**/*.pkg", "dist/x/a.zip", false},
+		{"**", "a/b/c", true},
+		{"**/b", "a/b/c", false},
+		{"a/**", "a", true},
+	}
+	for _, tt := range tests {
+		if got := matchElements(strings.Split(tt.pattern, "/"), strings.Split(tt.name, "/")); got != tt.want {
+			t.Errorf("match %q against %q = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestArtifactItems(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"dist/a.pkg", "dist/sub/b.pkg", "dist/c.zip", "logs/run.txt", "logs/deep/skip.txt", "top.txt"} {
+		writeFile(t, filepath.Join(dir, filepath.FromSlash(name)), name)
+	}
+	items, err := artifactItems(nil, dir, "dist/**/*.pkg; logs/*.txt;dist/a.pkg;missing/*", "p/b/j", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, item := range items {
+		got[item.Blob] = item.Path
+	}
+	want := map[string]string{
+		"p/b/j/dist/a.pkg":     filepath.Join(dir, "dist", "a.pkg"),
+		"p/b/j/dist/sub/b.pkg": filepath.Join(dir, "dist", "sub", "b.pkg"),
+		"p/b/j/logs/run.txt":   filepath.Join(dir, "logs", "run.txt"),
+	}
+	if len(items) != len(want) || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", items, want)
+	}
+
+	abs := filepath.ToSlash(filepath.Join(dir, "logs")) + "/*.txt"
+	items, err = artifactItems(nil, "", abs, "p", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || !strings.HasSuffix(items[0].Blob, "/logs/run.txt") || strings.Contains(items[0].Blob, "//") {
+		t.Errorf("absolute glob: got %+v", items)
+	}
+
+	if items, err := artifactItems(nil, dir, "nothing/*", "p", ""); err != nil || len(items) != 0 {
+		t.Errorf("no matches: got %v, %v", items, err)
+	}
+	if _, err := artifactItems(nil, dir, "../*", "p", ""); err == nil {
+		t.Error("a glob reaching outside the directory was accepted")
+	}
+	if _, err := artifactItems(nil, dir, "dist/[", "p", ""); err == nil {
+		t.Error("an invalid glob was accepted")
+	}
+}
+
+func TestArtifactPrefix(t *testing.T) {
+	env := map[string]string{"BUILDKITE_PIPELINE_SLUG": "app", "BUILDKITE_BUILD_ID": "b1", "BUILDKITE_JOB_ID": "j1"}
+	if got, err := artifactPrefix(mapLookup(env)); err != nil || got != "app/b1/j1" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	delete(env, "BUILDKITE_JOB_ID")
+	if _, err := artifactPrefix(mapLookup(env)); err == nil || !strings.Contains(err.Error(), "BUILDKITE_JOB_ID") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestRunArtifactUpload(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "dist", "app.pkg"), "pkg")
+	writeFile(t, filepath.Join(dir, "logs", "build.txt"), "log")
+	t.Setenv("BUILDKITE_PIPELINE_SLUG", "app")
+	t.Setenv("BUILDKITE_BUILD_ID", "build")
+	t.Setenv("BUILDKITE_JOB_ID", "job")
+	if err := runArtifactUpload(context.Background(), az, []string{"-dir", dir, "dist/**/*.pkg;logs/*.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range m.blobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := []string{"app/build/job/dist/app.pkg", "app/build/job/logs/build.txt"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("uploaded %v, want %v", names, want)
+	}
+	if err := runArtifactUpload(context.Background(), az, []string{"-dir", dir, "-prefix", "custom", "logs/*"}); err != nil {
+		t.Fatal(err)
+	}
+	if b := m.blobs["custom/logs/build.txt"]; b == nil || string(b.data) != "log" {
+		t.Error("-prefix was not used")
+	}
+	out := captureStdout(t, func() {
+		if err := runArtifactUpload(context.Background(), az, []string{"-dir", dir, "-prefix", "dry", "-dry-run", "dist/*"}); err != nil {
+			t.Error(err)
+		}
+	})
+	if !strings.Contains(out, "-> dry/dist/app.pkg: 3 B") || m.blobs["dry/dist/app.pkg"] != nil {
+		t.Errorf("-dry-run uploaded or printed:\n%s", out)
+	}
+}
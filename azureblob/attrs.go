@@ -0,0 +1,73 @@
+package azureblob
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Metadata entries in which uploads record the attributes of their file, so
+// that downloads can restore them.
+const (
+	mtimeMetadataKey = "bkmtime"
+	modeMetadataKey  = "bkmode"
+	uidMetadataKey   = "bkuid"
+	gidMetadataKey   = "bkgid"
+)
+
+// fileAttributes returns the metadata recording the modification time and
+// permission bits of the file with info, and with owner its uid and gid
+// where the platform has them.
+func fileAttributes(info os.FileInfo, owner bool) map[string]string {
+	attrs := map[string]string{
+		mtimeMetadataKey: info.ModTime().UTC().Format(time.RFC3339Nano),
+		modeMetadataKey:  fmt.Sprintf("%04o", info.Mode().Perm()),
+	}
+	if owner {
+		if uid, gid, ok := fileOwner(info); ok {
+			attrs[uidMetadataKey] = strconv.Itoa(uid)
+			attrs[gidMetadataKey] = strconv.Itoa(gid)
+		}
+	}
+	return attrs
+}
+
+// restoreAttributes applies the attributes recorded in metadata to the file
+// at path, and with owner also its recorded uid and gid. Attributes the
+// metadata does not record are left alone.
+func restoreAttributes(path string, metadata map[string]string, owner bool) error {
+	if owner {
+		uid, gid := metadataValue(metadata, uidMetadataKey), metadataValue(metadata, gidMetadataKey)
+		if uid != "" && gid != "" {
+			u, uerr := strconv.Atoi(uid)
+			g, gerr := strconv.Atoi(gid)
+			if uerr != nil || gerr != nil {
+				return fmt.Errorf("%s: invalid recorded owner %s:%s", path, uid, gid)
+			}
+			// Chown clears setuid bits, so it comes before the mode.
+			if err := os.Lchown(path, u, g); err != nil {
+				return err
+			}
+		}
+	}
+	if mode := metadataValue(metadata, modeMetadataKey); mode != "" {
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || perm > 0777 {
+			return fmt.Errorf("%s: invalid recorded mode %q", path, mode)
+		}
+		if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+			return err
+		}
+	}
+	if mtime := metadataValue(metadata, mtimeMetadataKey); mtime != "" {
+		t, err := time.Parse(time.RFC3339Nano, mtime)
+		if err != nil {
+			return fmt.Errorf("%s: invalid recorded modification time %q", path, mtime)
+		}
+		if err := os.Chtimes(path, t, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package azureblob
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPreserveAttributesRoundTrip(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	az.ClientOptions.PreserveAttributes = true
+	path := writeFile(t, filepath.Join(t.TempDir(), "tool"), "#!/bin/sh\n")
+	if err := os.Chmod(path, 0750); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2021, 3, 4, 5, 6, 7, 800, time.UTC)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ctx := context.Background()
+	if _, err := az.Upload(ctx, f, "tool"); err != nil {
+		t.Fatal(err)
+	}
+	stored := m.blobs["tool"].metadata
+	if stored[modeMetadataKey] != "0750" || stored[mtimeMetadataKey] != "2021-03-04T05:06:07.0000008Z" {
+		t.Errorf("metadata = %v", stored)
+	}
+	if _, ok := stored[uidMetadataKey]; ok {
+		t.Errorf("owner recorded without PreserveOwner: %v", stored)
+	}
+
+	dir := t.TempDir()
+	for _, resumable := range []bool{false, true} {
+		dest := filepath.Join(dir, "out"+strconv.FormatBool(resumable))
+		if resumable {
+			err = az.DownloadResumable(ctx, "tool", dest, dest+".state")
+		} else {
+			_, err = az.Download(ctx, "tool", dest)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runtime.GOOS != "windows" && info.Mode().Perm() != 0750 {
+			t.Errorf("resumable=%v: mode = %v", resumable, info.Mode())
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Errorf("resumable=%v: mtime = %v, want %v", resumable, info.ModTime(), mtime)
+		}
+	}
+}
+
+func TestDownloadIgnoresAttributesUnlessPreserving(t *testing.T) {
+	m := newMemContainer()
+	m.put("blob", []byte("x"), map[string]string{mtimeMetadataKey: "2001-01-01T00:00:00Z"})
+	az := newTestClient(t, m)
+	dest := filepath.Join(t.TempDir(), "out")
+	if _, err := az.Download(context.Background(), "blob", dest); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(dest); err != nil || info.ModTime().Year() == 2001 {
+		t.Errorf("mtime restored without PreserveAttributes: %v", err)
+	}
+}
+
+func TestRestoreAttributesInvalid(t *testing.T) {
+	path := writeFile(t, filepath.Join(t.TempDir(), "f"), "x")
+	for _, metadata := range []map[string]string{
+		{modeMetadataKey: "rwx"},
+		{modeMetadataKey: "4755"},
+		{mtimeMetadataKey: "yesterday"},
+		{uidMetadataKey: "root", gidMetadataKey: "0"},
+	} {
+		if err := restoreAttributes(path, metadata, true); err == nil {
+			t.Errorf("restoreAttributes(%v) succeeded", metadata)
+		}
+	}
+	if err := restoreAttributes(path, map[string]string{"BKMODE": "0600"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); runtime.GOOS != "windows" && (err != nil || info.Mode().Perm() != 0600) {
+		t.Errorf("upper-case metadata was not restored: %v", err)
+	}
+}
+
+func TestFileAttributesOwner(t *testing.T) {
+	path := writeFile(t, filepath.Join(t.TempDir(), "f"), "x")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := fileAttributes(info, true)
+	if runtime.GOOS == "windows" {
+		if _, ok := attrs[uidMetadataKey]; ok {
+			t.Errorf("owner recorded on windows: %v", attrs)
+		}
+		return
+	}
+	if attrs[uidMetadataKey] != strconv.Itoa(os.Getuid()) || attrs[gidMetadataKey] == "" {
+		t.Errorf("attrs = %v, want uid %d", attrs, os.Getuid())
+	}
+	// Restoring the current owner needs no privilege.
+	if err := restoreAttributes(path, attrs, true); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,226 @@
+package azureblob
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// AuthMethod is a way of obtaining an Azure AD token.
+type AuthMethod string
+
+const (
+	AuthWorkloadIdentity   AuthMethod = "workload-identity"
+	AuthManagedIdentity    AuthMethod = "managed-identity"
+	AuthAzureCLI           AuthMethod = "azure-cli"
+	AuthInteractiveBrowser AuthMethod = "interactive-browser"
+	AuthDeviceCode         AuthMethod = "device-code"
+)
+
+// imdsEndpoint is the Azure Instance Metadata Service, which serves managed
+// identity tokens on Azure VMs.
+var imdsEndpoint = "http://169.254.169.254/metadata/instance?api-version=2021-02-01"
+
+// imdsProbeTimeout bounds the IMDS probe. Off Azure the address is simply
+// unroutable, so the probe would otherwise hang until the dial timeout.
+const imdsProbeTimeout = 500 * time.Millisecond
+
+// AuthCapability reports whether an auth method can work in the current
+// environment, and why.
+type AuthCapability struct {
+	Method    AuthMethod
+	Available bool
+	// Skipped is set when the method was not probed because a preferred
+	// non-interactive method is available.
+	Skipped bool
+	Reason  string
+}
+
+// authProbe checks whether one auth method can work.
+type authProbe struct {
+	method      AuthMethod
+	interactive bool
+	probe       func(ctx context.Context) AuthCapability
+}
+
+// authProbes are ranked from most to least preferred: non-interactive methods
+// first, then the browser, with device code as the last resort that works
+// anywhere.
+var authProbes = []authProbe{
+	{AuthWorkloadIdentity, false, func(context.Context) AuthCapability { return probeWorkloadIdentity() }},
+	{AuthManagedIdentity, false, probeManagedIdentity},
+	{AuthAzureCLI, false, func(context.Context) AuthCapability { return probeAzureCLI() }},
+	{AuthInteractiveBrowser, true, func(context.Context) AuthCapability { return probeBrowser() }},
+	{AuthDeviceCode, true, func(context.Context) AuthCapability {
+		return AuthCapability{Method: AuthDeviceCode, Available: true, Reason: "always available"}
+	}},
+}
+
+// ProbeAuth checks which auth methods the environment supports, in rank
+// order.
+func ProbeAuth(ctx context.Context) []AuthCapability {
+	return probeAuth(ctx, false)
+}
+
+// probeAuth runs the auth probes in rank order. With short set, the
+// non-interactive methods ranked below the first available one are skipped,
+// which spares the IMDS round trip wherever workload identity is set up.
+func probeAuth(ctx context.Context, short bool) []AuthCapability {
+	var (
+		caps      []AuthCapability
+		preferred AuthMethod
+	)
+	for _, p := range authProbes {
+		if short && preferred != "" && !p.interactive {
+			caps = append(caps, AuthCapability{Method: p.method, Skipped: true, Reason: string(preferred) + " is preferred"})
+			continue
+		}
+		capability := p.probe(ctx)
+		if capability.Available && !p.interactive && preferred == "" {
+			preferred = p.method
+		}
+		caps = append(caps, capability)
+	}
+	return caps
+}
+
+func probeWorkloadIdentity() AuthCapability {
+	capability := AuthCapability{Method: AuthWorkloadIdentity}
+	var missing []string
+	for _, name := range []string{"AZURE_FEDERATED_TOKEN_FILE", "AZURE_CLIENT_ID", "AZURE_TENANT_ID"} {
+		if os.Getenv(name) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		capability.Reason = "OIDC environment not set: " + strings.Join(missing, ", ")
+		return capability
+	}
+	capability.Available = true
+	capability.Reason = "OIDC federated token file " + os.Getenv("AZURE_FEDERATED_TOKEN_FILE")
+	return capability
+}
+
+func probeManagedIdentity(ctx context.Context) AuthCapability {
+	capability := AuthCapability{Method: AuthManagedIdentity}
+	// App Service, Functions and Arc expose their own endpoint instead of IMDS.
+	for _, name := range []string{"IDENTITY_ENDPOINT", "MSI_ENDPOINT"} {
+		if v := os.Getenv(name); v != "" {
+			capability.Available = true
+			capability.Reason = name + " is " + v
+			return capability
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, imdsProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imdsEndpoint, nil)
+	if err != nil {
+		capability.Reason = err.Error()
+		return capability
+	}
+	req.Header.Set("Metadata", "true")
+	// IMDS is link-local and must never be reached through a proxy.
+	hc := &http.Client{Transport: &http.Transport{}}
+	resp, err := hc.Do(req)
+	if err != nil {
+		capability.Reason = "IMDS not reachable"
+		return capability
+	}
+	resp.Body.Close()
+	capability.Available = true
+	capability.Reason = fmt.Sprintf("IMDS answered HTTP %d", resp.StatusCode)
+	return capability
+}
+
+func probeAzureCLI() AuthCapability {
+	capability := AuthCapability{Method: AuthAzureCLI}
+	path, err := exec.LookPath("az")
+	if err != nil {
+		capability.Reason = "az not found in PATH"
+		return capability
+	}
+	capability.Available = true
+	capability.Reason = "az at " + path
+	return capability
+}
+
+func probeBrowser() AuthCapability {
+	capability := AuthCapability{Method: AuthInteractiveBrowser}
+	switch runtime.GOOS {
+	case "windows", "darwin":
+		capability.Available = true
+		capability.Reason = "desktop OS"
+		return capability
+	}
+	if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+		capability.Reason = "no DISPLAY or WAYLAND_DISPLAY"
+		return capability
+	}
+	if _, err := exec.LookPath("xdg-open"); err != nil {
+		capability.Reason = "xdg-open not found in PATH"
+		return capability
+	}
+	capability.Available = true
+	capability.Reason = "display and xdg-open present"
+	return capability
+}
+
+// chooseAuth returns, in rank order, the methods of caps that are available
+// and enabled by credOpts, together with one line per capability explaining
+// whether it was chosen. The interactive browser is only chosen when
+// credOpts enables the interactive credential.
+func chooseAuth(caps []AuthCapability, credOpts *AzureBlobCredentialOptions) ([]AuthMethod, []string) {
+	var chosen []AuthMethod
+	var lines []string
+	for i, capability := range caps {
+		var status string
+		switch {
+		case capability.Skipped:
+			status = "skipped: " + capability.Reason
+		case !capability.Available:
+			status = "unavailable: " + capability.Reason
+		case capability.Method == AuthInteractiveBrowser && (credOpts == nil || !credOpts.InteractiveCredential):
+			status = "available but interactive credential not enabled: " + capability.Reason
+		default:
+			chosen = append(chosen, capability.Method)
+			status = "chosen: " + capability.Reason
+			if capability.Method == AuthManagedIdentity && credOpts != nil && credOpts.ManagedIdentityID != "" {
+				status += ", as user-assigned identity " + credOpts.ManagedIdentityID
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s %s", i+1, capability.Method, status))
+	}
+	return chosen, lines
+}
+
+// describeAuth renders a credential chain, e.g.
+// "interactive-browser, then device-code".
+func describeAuth(chain []AuthMethod) string {
+	names := make([]string, len(chain))
+	for i, method := range chain {
+		names[i] = string(method)
+	}
+	return strings.Join(names, ", then ")
+}
+
+func runAuthProbe(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("auth-probe", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: auth-probe\n")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	chosen, lines := chooseAuth(ProbeAuth(ctx), az.CredentialOptions)
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	fmt.Println(az.Messages.format(MsgAuthWouldUse, describeAuth(chosen)))
+	return nil
+}
@@ -0,0 +1,210 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// setIMDS points the IMDS probe at url for the duration of the test.
+func setIMDS(t *testing.T, url string) {
+	old := imdsEndpoint
+	imdsEndpoint = url
+	t.Cleanup(func() { imdsEndpoint = old })
+}
+
+// clearAuthEnv unsets the environment the auth probes look at.
+func clearAuthEnv(t *testing.T) {
+	for _, name := range []string{"AZURE_FEDERATED_TOKEN_FILE", "AZURE_CLIENT_ID", "AZURE_TENANT_ID", "IDENTITY_ENDPOINT", "MSI_ENDPOINT", "DISPLAY", "WAYLAND_DISPLAY"} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestProbeWorkloadIdentity(t *testing.T) {
+	clearAuthEnv(t)
+	t.Setenv("AZURE_FEDERATED_TOKEN_FILE", "/var/run/token")
+	t.Setenv("AZURE_CLIENT_ID", "client")
+	if c := probeWorkloadIdentity(); c.Available || c.Reason != "OIDC environment not set: AZURE_TENANT_ID" {
+		t.Errorf("with AZURE_TENANT_ID unset got %+v", c)
+	}
+	t.Setenv("AZURE_TENANT_ID", "tenant")
+	if c := probeWorkloadIdentity(); !c.Available {
+		t.Errorf("with the OIDC environment set got %+v", c)
+	}
+}
+
+func TestProbeManagedIdentity(t *testing.T) {
+	clearAuthEnv(t)
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" {
+			t.Error("IMDS probe is missing the Metadata header")
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer imds.Close()
+
+	setIMDS(t, imds.URL)
+	if c := probeManagedIdentity(context.Background()); !c.Available || c.Reason != "IMDS answered HTTP 400" {
+		t.Errorf("with IMDS answering got %+v", c)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if c := probeManagedIdentity(ctx); c.Available {
+		t.Errorf("with a cancelled context got %+v", c)
+	}
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	setIMDS(t, closed.URL)
+	if c := probeManagedIdentity(context.Background()); c.Available || c.Reason != "IMDS not reachable" {
+		t.Errorf("with IMDS unreachable got %+v", c)
+	}
+
+	t.Setenv("IDENTITY_ENDPOINT", "http://localhost:42356/msi/token")
+	if c := probeManagedIdentity(context.Background()); !c.Available {
+		t.Errorf("with IDENTITY_ENDPOINT set got %+v", c)
+	}
+}
+
+func TestProbeBrowserWithoutDisplay(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		t.Skip("desktop OSes always have a browser")
+	}
+	clearAuthEnv(t)
+	if c := probeBrowser(); c.Available {
+		t.Errorf("without a display got %+v", c)
+	}
+}
+
+func TestProbeAuthShortSkipsLowerRankedMethods(t *testing.T) {
+	clearAuthEnv(t)
+	t.Setenv("AZURE_FEDERATED_TOKEN_FILE", "/var/run/token")
+	t.Setenv("AZURE_CLIENT_ID", "client")
+	t.Setenv("AZURE_TENANT_ID", "tenant")
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("IMDS was probed although workload identity is available")
+	}))
+	defer imds.Close()
+	setIMDS(t, imds.URL)
+
+	caps := probeAuth(context.Background(), true)
+	var methods []AuthMethod
+	for _, c := range caps {
+		methods = append(methods, c.Method)
+	}
+	if want := []AuthMethod{AuthWorkloadIdentity, AuthManagedIdentity, AuthAzureCLI, AuthInteractiveBrowser, AuthDeviceCode}; !reflect.DeepEqual(methods, want) {
+		t.Fatalf("probed %v, want %v", methods, want)
+	}
+	for _, i := range []int{1, 2} {
+		if !caps[i].Skipped || caps[i].Reason != "workload-identity is preferred" {
+			t.Errorf("%s: got %+v, want it skipped", caps[i].Method, caps[i])
+		}
+	}
+	if caps[3].Skipped || !caps[4].Available {
+		t.Errorf("interactive methods were not probed: %+v", caps[3:])
+	}
+}
+
+func TestChooseAuth(t *testing.T) {
+	caps := []AuthCapability{
+		{Method: AuthWorkloadIdentity, Reason: "OIDC environment not set"},
+		{Method: AuthManagedIdentity, Available: true, Reason: "IMDS answered HTTP 400"},
+		{Method: AuthAzureCLI, Skipped: true, Reason: "managed-identity is preferred"},
+		{Method: AuthInteractiveBrowser, Available: true, Reason: "desktop OS"},
+		{Method: AuthDeviceCode, Available: true, Reason: "always available"},
+	}
+	tests := []struct {
+		name        string
+		interactive bool
+		browser     bool
+		want        []AuthMethod
+		wantLine    string
+	}{
+		{"interactive", true, true, []AuthMethod{AuthManagedIdentity, AuthInteractiveBrowser, AuthDeviceCode}, "4. interactive-browser chosen: desktop OS"},
+		{"interactive disabled", false, true, []AuthMethod{AuthManagedIdentity, AuthDeviceCode}, "4. interactive-browser available but interactive credential not enabled: desktop OS"},
+		{"no browser", true, false, []AuthMethod{AuthManagedIdentity, AuthDeviceCode}, "4. interactive-browser unavailable: desktop OS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probed := append([]AuthCapability(nil), caps...)
+			probed[3].Available = tt.browser
+			got, lines := chooseAuth(probed, &AzureBlobCredentialOptions{InteractiveCredential: tt.interactive})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chose %v, want %v", got, tt.want)
+			}
+			if len(lines) != len(caps) || lines[3] != tt.wantLine {
+				t.Errorf("lines = %q, want line 4 %q", lines, tt.wantLine)
+			}
+			if want := "3. azure-cli skipped: managed-identity is preferred"; lines[2] != want {
+				t.Errorf("line 3 = %q, want %q", lines[2], want)
+			}
+		})
+	}
+}
+
+func TestChooseAuthUserAssignedIdentity(t *testing.T) {
+	caps := []AuthCapability{{Method: AuthManagedIdentity, Available: true, Reason: "IMDS answered HTTP 400"}}
+	_, lines := chooseAuth(caps, &AzureBlobCredentialOptions{ManagedIdentityID: "7cf7db0d"})
+	if want := "1. managed-identity chosen: IMDS answered HTTP 400, as user-assigned identity 7cf7db0d"; lines[0] != want {
+		t.Errorf("line = %q, want %q", lines[0], want)
+	}
+}
+
+func TestManagedIdentityID(t *testing.T) {
+	if id := managedIdentityID(&AzureBlobCredentialOptions{}); id != nil {
+		t.Errorf("no ID selected %v, want the default identity", id)
+	}
+	if id, ok := managedIdentityID(&AzureBlobCredentialOptions{ManagedIdentityID: "7cf7db0d"}).(azidentity.ClientID); !ok || id != "7cf7db0d" {
+		t.Errorf("client ID selected %#v", id)
+	}
+	resource := "/subscriptions/s/resourceGroups/g/providers/Microsoft.ManagedIdentity/userAssignedIdentities/ci"
+	if id, ok := managedIdentityID(&AzureBlobCredentialOptions{ManagedIdentityID: resource}).(azidentity.ResourceID); !ok || string(id) != resource {
+		t.Errorf("resource ID selected %#v", id)
+	}
+}
+
+func TestDescribeAuth(t *testing.T) {
+	if got := describeAuth([]AuthMethod{AuthInteractiveBrowser, AuthDeviceCode}); got != "interactive-browser, then device-code" {
+		t.Errorf("describeAuth = %q", got)
+	}
+}
+
+func TestInitCredentialLogsChoice(t *testing.T) {
+	clearAuthEnv(t)
+	token := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(token, []byte("assertion"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AZURE_FEDERATED_TOKEN_FILE", token)
+	t.Setenv("AZURE_CLIENT_ID", "client")
+	t.Setenv("AZURE_TENANT_ID", "tenant")
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	az := NewAzureBlobClientDefault("client", "tenant", "container", "account")
+	if _, err := az.InitCredential(context.Background(), az.CredentialOptions); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"auth probe: 1. workload-identity chosen: OIDC federated token file " + token,
+		"auth probe: 2. managed-identity skipped: workload-identity is preferred",
+		"auth: using workload-identity, then device-code",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log is missing %q:\n%s", want, out)
+		}
+	}
+}
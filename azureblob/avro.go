@@ -0,0 +1,240 @@
+package azureblob
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// avroMagic starts every Avro object container file.
+var avroMagic = []byte("Obj\x01")
+
+// avroMaxBlockSize bounds the size of a block, and of a byte string, which
+// the service keeps far smaller, so that a corrupt size cannot make the
+// reader allocate gigabytes.
+const avroMaxBlockSize = 64 << 20
+
+// avroRecord is a record decoded from an Avro object container. Name is the
+// record's name without its namespace; fields of type bytes and string are
+// held as []byte, long and int as int64, and boolean as bool.
+type avroRecord struct {
+	Name   string
+	Fields map[string]interface{}
+}
+
+// avroSchema is a record schema, or a union of them.
+type avroSchema struct {
+	Name   string `json:"name"`
+	Fields []struct {
+		Name string          `json:"name"`
+		Type json.RawMessage `json:"type"`
+	} `json:"fields"`
+}
+
+// avroReader reads the records of an Avro object container file, the
+// format blob queries stream their results in. It decodes just what those
+// need: a schema that is a record or a union of records whose fields are
+// primitives, and the null and deflate codecs.
+type avroReader struct {
+	r       *bufio.Reader
+	schemas []avroSchema
+	union   bool
+	deflate bool
+	sync    [16]byte
+
+	block *bufio.Reader
+	left  int64
+}
+
+// newAvroReader reads the header of the container r.
+func newAvroReader(r io.Reader) (*avroReader, error) {
+	a := &avroReader{r: bufio.NewReader(r)}
+	magic := make([]byte, len(avroMagic))
+	if _, err := io.ReadFull(a.r, magic); err != nil || !bytes.Equal(magic, avroMagic) {
+		return nil, errors.New("avro: not an object container")
+	}
+	meta := map[string][]byte{}
+	for {
+		n, err := readAvroBlockCount(a.r)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+		for ; n > 0; n-- {
+			k, err := readAvroBytes(a.r)
+			if err != nil {
+				return nil, err
+			}
+			v, err := readAvroBytes(a.r)
+			if err != nil {
+				return nil, err
+			}
+			meta[string(k)] = v
+		}
+	}
+	if _, err := io.ReadFull(a.r, a.sync[:]); err != nil {
+		return nil, fmt.Errorf("avro: %w", err)
+	}
+	switch codec := string(meta["avro.codec"]); codec {
+	case "", "null":
+	case "deflate":
+		a.deflate = true
+	default:
+		return nil, fmt.Errorf("avro: unsupported codec %q", codec)
+	}
+	schema := bytes.TrimSpace(meta["avro.schema"])
+	a.union = len(schema) > 0 && schema[0] == '['
+	if !a.union {
+		schema = append(append([]byte("["), schema...), ']')
+	}
+	if err := json.Unmarshal(schema, &a.schemas); err != nil {
+		return nil, fmt.Errorf("avro: schema: %w", err)
+	}
+	if len(a.schemas) == 0 {
+		return nil, errors.New("avro: schema is an empty union")
+	}
+	return a, nil
+}
+
+// Next returns the next record, or io.EOF after the last.
+func (a *avroReader) Next() (*avroRecord, error) {
+	for a.left == 0 {
+		if err := a.nextBlock(); err != nil {
+			return nil, err
+		}
+	}
+	a.left--
+	s := &a.schemas[0]
+	if a.union {
+		i, err := readAvroLong(a.block)
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= int64(len(a.schemas)) {
+			return nil, fmt.Errorf("avro: union branch %d out of range", i)
+		}
+		s = &a.schemas[i]
+	}
+	rec := &avroRecord{Name: s.Name[strings.LastIndex(s.Name, ".")+1:], Fields: map[string]interface{}{}}
+	for _, f := range s.Fields {
+		var typ string
+		if err := json.Unmarshal(f.Type, &typ); err != nil {
+			return nil, fmt.Errorf("avro: field %s of %s is not a primitive", f.Name, s.Name)
+		}
+		var (
+			v   interface{}
+			err error
+		)
+		switch typ {
+		case "bytes", "string":
+			v, err = readAvroBytes(a.block)
+		case "long", "int":
+			v, err = readAvroLong(a.block)
+		case "boolean":
+			var b byte
+			b, err = a.block.ReadByte()
+			v = b != 0
+		case "null":
+		default:
+			err = fmt.Errorf("avro: unsupported type %q", typ)
+		}
+		if err != nil {
+			return nil, err
+		}
+		rec.Fields[f.Name] = v
+	}
+	return rec, nil
+}
+
+// nextBlock starts reading the next block of objects.
+func (a *avroReader) nextBlock() error {
+	n, err := readAvroLong(a.r)
+	if err != nil {
+		return err
+	}
+	size, err := readAvroLong(a.r)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if n < 0 || size < 0 {
+		return errors.New("avro: corrupt block")
+	}
+	if size > avroMaxBlockSize {
+		return fmt.Errorf("avro: block of %d bytes exceeds %d", size, avroMaxBlockSize)
+	}
+	// Read the block as it arrives rather than allocating its claimed size
+	// up front.
+	var data bytes.Buffer
+	if _, err := io.CopyN(&data, a.r, size); err != nil {
+		return unexpectedEOF(err)
+	}
+	var sync [16]byte
+	if _, err := io.ReadFull(a.r, sync[:]); err != nil {
+		return unexpectedEOF(err)
+	}
+	if sync != a.sync {
+		return errors.New("avro: sync marker mismatch")
+	}
+	var block io.Reader = &data
+	if a.deflate {
+		block = flate.NewReader(block)
+	}
+	a.block, a.left = bufio.NewReader(block), n
+	return nil
+}
+
+// readAvroLong reads a zigzag varint.
+func readAvroLong(r io.ByteReader) (int64, error) {
+	u, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	return int64(u>>1) ^ -int64(u&1), nil
+}
+
+// readAvroBlockCount reads the item count of a block of a map or array,
+// skipping the byte size that follows a negative count.
+func readAvroBlockCount(r *bufio.Reader) (int64, error) {
+	n, err := readAvroLong(r)
+	if err != nil || n >= 0 {
+		return n, unexpectedEOF(err)
+	}
+	if _, err := readAvroLong(r); err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	return -n, nil
+}
+
+// readAvroBytes reads a length-prefixed byte string.
+func readAvroBytes(r *bufio.Reader) ([]byte, error) {
+	n, err := readAvroLong(r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if n < 0 {
+		return nil, errors.New("avro: negative length")
+	}
+	if n > avroMaxBlockSize {
+		return nil, fmt.Errorf("avro: string of %d bytes exceeds %d", n, avroMaxBlockSize)
+	}
+	var b bytes.Buffer
+	_, err = io.CopyN(&b, r, n)
+	return b.Bytes(), unexpectedEOF(err)
+}
+
+// unexpectedEOF turns io.EOF, which only ends a container between blocks,
+// into io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,115 @@
+package azureblob
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+// querySchema is the schema blob queries stream their results with.
+const querySchema = `[
+{"type":"record","name":"com.microsoft.azure.storage.queryBlobContents.resultData","fields":[{"name":"data","type":"bytes"}]},
+{"type":"record","name":"com.microsoft.azure.storage.queryBlobContents.error","fields":[{"name":"fatal","type":"boolean"},{"name":"name","type":"string"},{"name":"description","type":"string"},{"name":"position","type":"long"}]},
+{"type":"record","name":"com.microsoft.azure.storage.queryBlobContents.progress","fields":[{"name":"bytesScanned","type":"long"},{"name":"totalBytes","type":"long"}]},
+{"type":"record","name":"com.microsoft.azure.storage.queryBlobContents.end","fields":[{"name":"totalBytes","type":"long"}]}]`
+
+func avroLong(n int64) []byte {
+	b := make([]byte, binary.MaxVarintLen64)
+	return b[:binary.PutUvarint(b, uint64(n<<1)^uint64(n>>63))]
+}
+
+func avroBytes(b string) []byte {
+	return append(avroLong(int64(len(b))), b...)
+}
+
+// avroContainer encodes an object container with the schema whose
+// objects, already encoded, are split into blocks.
+func avroContainer(schema string, blocks ...[][]byte) []byte {
+	sync := bytes.Repeat([]byte{0x5a}, 16)
+	var b bytes.Buffer
+	b.Write(avroMagic)
+	b.Write(avroLong(1))
+	b.Write(avroBytes("avro.schema"))
+	b.Write(avroBytes(schema))
+	b.Write(avroLong(0))
+	b.Write(sync)
+	for _, objects := range blocks {
+		data := bytes.Join(objects, nil)
+		b.Write(avroLong(int64(len(objects))))
+		b.Write(avroLong(int64(len(data))))
+		b.Write(data)
+		b.Write(sync)
+	}
+	return b.Bytes()
+}
+
+func TestAvroReader(t *testing.T) {
+	data := append(avroLong(0), avroBytes("a,1\n")...)
+	errRec := append(append(append(append(avroLong(1), 0), avroBytes("InvalidRow")...), avroBytes("bad")...), avroLong(300)...)
+	end := append(avroLong(3), avroLong(1<<40)...)
+	a, err := newAvroReader(bytes.NewReader(avroContainer(querySchema, [][]byte{data, errRec}, [][]byte{end})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	var recs []*avroRecord
+	for {
+		rec, err := a.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, rec.Name)
+		recs = append(recs, rec)
+	}
+	if len(recs) != 3 || names[0] != "resultData" || names[1] != "error" || names[2] != "end" {
+		t.Fatalf("records %v", names)
+	}
+	if got := string(recs[0].Fields["data"].([]byte)); got != "a,1\n" {
+		t.Errorf("data = %q", got)
+	}
+	if recs[1].Fields["fatal"] != false || recs[1].Fields["position"] != int64(300) || string(recs[1].Fields["name"].([]byte)) != "InvalidRow" {
+		t.Errorf("error record %v", recs[1].Fields)
+	}
+	if recs[2].Fields["totalBytes"] != int64(1<<40) {
+		t.Errorf("end record %v", recs[2].Fields)
+	}
+}
+
+func TestAvroReaderRejectsCorruptInput(t *testing.T) {
+	if _, err := newAvroReader(bytes.NewReader([]byte("PK\x03\x04"))); err == nil {
+		t.Error("read a container without the magic")
+	}
+	container := avroContainer(querySchema, [][]byte{append(avroLong(0), avroBytes("row")...)})
+	container[len(container)-1] ^= 0xff
+	a, err := newAvroReader(bytes.NewReader(container))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Next(); err == nil {
+		t.Error("read a block with the wrong sync marker")
+	}
+	a, _ = newAvroReader(bytes.NewReader(container[:len(container)-20]))
+	if _, err := a.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated block returned %v", err)
+	}
+
+	if _, err := newAvroReader(bytes.NewReader(avroContainer("[]"))); err == nil || !strings.Contains(err.Error(), "empty union") {
+		t.Errorf("empty union returned %v", err)
+	}
+	huge := avroContainer(querySchema)
+	huge = append(append(huge, avroLong(1)...), avroLong(1<<40)...)
+	a, _ = newAvroReader(bytes.NewReader(huge))
+	if _, err := a.Next(); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("block of 1 TiB returned %v", err)
+	}
+	long := avroContainer(querySchema, [][]byte{append(avroLong(0), avroLong(1<<40)...)})
+	a, _ = newAvroReader(bytes.NewReader(long))
+	if _, err := a.Next(); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("string of 1 TiB returned %v", err)
+	}
+}
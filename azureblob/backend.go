@@ -0,0 +1,154 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// azureScheme is the URL scheme of Azure containers, as in
+// azure://account/container.
+const azureScheme = "azure"
+
+// Backend is a storage provider the transfer engine runs on: a container or
+// bucket of named blobs. Manifests, verify and diff work with any Backend,
+// so they keep their semantics on other clouds. AzureBlobClient and
+// S3Backend are the providers so far; another, e.g. for GCS, implements
+// Backend and registers itself with RegisterBackend under its URL scheme.
+// Azure-only features, such as fallback containers, client-side encryption
+// and page or append blobs, stay on AzureBlobClient.
+type Backend interface {
+	BlobStorage
+	// Location returns the URL of the container or bucket, e.g.
+	// azure://account/container.
+	Location() string
+}
+
+var _ Backend = (*AzureBlobClient)(nil)
+
+// StreamBackend is a Backend that can also read blobs as streams and write
+// them from streams, so that copy moves blobs between two of them without
+// staging them on disk.
+type StreamBackend interface {
+	Backend
+	// OpenBlob returns a reader of the content of blob, as Download would
+	// write it, and the blob's properties. It returns errNotStreamable for
+	// blobs that only Download can decode.
+	OpenBlob(ctx context.Context, blob string) (io.ReadCloser, *BlobProperties, error)
+	// UploadFrom uploads the props.Size bytes read from r to blob, with the
+	// content type, content encoding and, where known, MD5 of props.
+	UploadFrom(ctx context.Context, r io.Reader, props *BlobProperties, blob string) error
+}
+
+var (
+	_ StreamBackend = (*AzureBlobClient)(nil)
+	_ StreamBackend = (*S3Backend)(nil)
+)
+
+// errNotStreamable is returned by OpenBlob for blobs that must be
+// downloaded to be decoded.
+var errNotStreamable = errors.New("blob cannot be streamed")
+
+// Location returns the URL of c's container.
+func (c *AzureBlobClient) Location() string {
+	return azureScheme + "://" + c.source()
+}
+
+// blobLocation returns the URL of the blob called name in b, e.g.
+// azure://account/container/name.
+func blobLocation(b Backend, name string) string {
+	u, err := url.Parse(b.Location())
+	if err != nil {
+		return b.Location() + "/" + name
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + name
+	return u.String()
+}
+
+// BackendOpener returns the backend of a URL of the scheme it is registered
+// for, configured like base: sharing its pool, progress, messages and
+// options where the provider has a use for them.
+type BackendOpener func(base *AzureBlobClient, u *url.URL) (Backend, error)
+
+var (
+	backendProvidersMu sync.Mutex
+	backendProviders   = map[string]BackendOpener{azureScheme: openAzureBackend, s3Scheme: openS3Backend}
+)
+
+// RegisterBackend makes Backends open URLs of scheme with open, replacing
+// any provider registered for it before. Providers call it from an init
+// function, like database/sql drivers.
+func RegisterBackend(scheme string, open BackendOpener) {
+	backendProvidersMu.Lock()
+	defer backendProvidersMu.Unlock()
+	backendProviders[scheme] = open
+}
+
+// openAzureBackend opens azure://account/container with a client of a
+// ClientRegistry based on base, so that it shares base's credential.
+func openAzureBackend(base *AzureBlobClient, u *url.URL) (Backend, error) {
+	container := strings.Trim(u.Path, "/")
+	if u.Host == "" || container == "" || strings.Contains(container, "/") {
+		return nil, fmt.Errorf("%s does not name a container; want azure://<account>/<container>", u)
+	}
+	return NewClientRegistry(base).Client(u.Host, container)
+}
+
+// Backends opens the backends of URLs for one base client, opening each
+// location once. base itself serves its own container. It is safe for
+// concurrent use.
+type Backends struct {
+	base *AzureBlobClient
+
+	mu     sync.Mutex
+	opened map[string]Backend
+}
+
+// NewBackends returns Backends configured like base.
+func NewBackends(base *AzureBlobClient) *Backends {
+	return &Backends{base: base, opened: map[string]Backend{base.Location(): base}}
+}
+
+// Open returns the backend of location, a URL such as
+// azure://account/container.
+func (b *Backends) Open(location string) (Backend, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := u.Scheme + "://" + u.Host + "/" + strings.Trim(u.Path, "/")
+	if u.RawQuery != "" {
+		key += "?" + u.RawQuery
+	}
+	if backend, ok := b.opened[key]; ok {
+		return backend, nil
+	}
+	backendProvidersMu.Lock()
+	open, ok := backendProviders[u.Scheme]
+	schemes := make([]string, 0, len(backendProviders))
+	for scheme := range backendProviders {
+		schemes = append(schemes, scheme)
+	}
+	backendProvidersMu.Unlock()
+	if !ok {
+		sort.Strings(schemes)
+		want := schemes[len(schemes)-1]
+		if len(schemes) > 1 {
+			want = strings.Join(schemes[:len(schemes)-1], ", ") + " or " + want
+		}
+		return nil, fmt.Errorf("unknown storage backend %q in %s, want %s", u.Scheme, location, want)
+	}
+	backend, err := open(b.base, u)
+	if err != nil {
+		return nil, err
+	}
+	b.opened[key] = backend
+	return backend, nil
+}
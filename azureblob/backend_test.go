@@ -0,0 +1,118 @@
+package azureblob
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// memBackend is a FakeBlobStorage serving a bucket of the mem scheme, which
+// the tests register as a second provider.
+type memBackend struct {
+	*FakeBlobStorage
+	location string
+}
+
+func (b *memBackend) Location() string {
+	return b.location
+}
+
+// memBuckets holds the buckets of the mem provider by name.
+var memBuckets = map[string]*memBackend{}
+
+func init() {
+	RegisterBackend("mem", func(base *AzureBlobClient, u *url.URL) (Backend, error) {
+		b := memBuckets[u.Host]
+		if b == nil {
+			b = &memBackend{NewFakeBlobStorage(), u.String()}
+			memBuckets[u.Host] = b
+		}
+		return b, nil
+	})
+}
+
+func TestBackendsOpen(t *testing.T) {
+	base := newTestClient(t, newMemContainer())
+	backends := NewBackends(base)
+	if b, err := backends.Open("azure://account/container/"); err != nil || b != base {
+		t.Errorf("the base's own container: got %v, %v", b, err)
+	}
+	other, err := backends.Open("azure://other/packages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	az, ok := other.(*AzureBlobClient)
+	if !ok || az.StorageAccount != "other" || az.ContainerName != "packages" || az.ClientOptions != base.ClientOptions {
+		t.Errorf("other container: %+v", other)
+	}
+	if again, _ := backends.Open("azure://other/packages"); again != other {
+		t.Error("a second Open created another backend")
+	}
+	if other.Location() != "azure://other/packages" {
+		t.Errorf("location = %s", other.Location())
+	}
+	for _, location := range []string{"azure://account", "azure://account/a/b", "s3://bucket/dir", "s3://bucket?acl=public"} {
+		if _, err := backends.Open(location); err == nil {
+			t.Errorf("opening %s succeeded", location)
+		}
+	}
+	if _, err := backends.Open("gs://bucket"); err == nil || !strings.Contains(err.Error(), "want azure, mem or s3") {
+		t.Errorf("unknown scheme = %v", err)
+	}
+}
+
+func TestManifestOnOtherBackend(t *testing.T) {
+	bucket := &memBackend{NewFakeBlobStorage(), "mem://manifest-bucket"}
+	memBuckets["manifest-bucket"] = bucket
+	bucket.Put("in.txt", []byte("from the bucket"), nil)
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	dir := t.TempDir()
+	src := writeFile(t, filepath.Join(dir, "src.txt"), "to the bucket")
+	results := az.RunManifest(context.Background(), &Manifest{
+		Downloads: []ManifestItem{{Blob: "in.txt", Path: filepath.Join(dir, "in.txt"), Backend: "mem://manifest-bucket"}},
+		Uploads:   []ManifestItem{{Blob: "out.txt", Path: src, Backend: "mem://manifest-bucket"}},
+	})
+	if err := manifestError(results); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(dir, "in.txt")); got != "from the bucket" {
+		t.Errorf("downloaded %q", got)
+	}
+	if len(m.blobs) != 0 {
+		t.Errorf("the Azure container was written: %v", m.blobs)
+	}
+	if err := az.verifyItems(context.Background(), []ManifestItem{{Blob: "out.txt", Path: src, Backend: "mem://manifest-bucket"}}, true); err != nil {
+		t.Errorf("verify against the bucket: %v", err)
+	}
+
+	diffDir := t.TempDir()
+	writeFile(t, filepath.Join(diffDir, "in.txt"), "from the bucket")
+	entries, err := diffBackend(context.Background(), bucket, az.clientOptions(), diffDir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != "out.txt" || entries[0].Status != DiffMissing {
+		t.Errorf("diff = %+v", entries)
+	}
+}
+
+func TestRunDiffBackend(t *testing.T) {
+	bucket := &memBackend{NewFakeBlobStorage(), "mem://diff-bucket"}
+	memBuckets["diff-bucket"] = bucket
+	bucket.Put("p/gone.txt", []byte("x"), nil)
+	az := newTestClient(t, newMemContainer())
+	dir := t.TempDir()
+	if err := runDiff(context.Background(), az, []string{"-backend", "gs://bucket", dir}); err == nil {
+		t.Error("diff against an unknown backend succeeded")
+	}
+	var err error
+	out := captureStdout(t, func() {
+		err = runDiff(context.Background(), az, []string{"-backend", "mem://diff-bucket", dir, "p"})
+	})
+	if err != nil || !strings.Contains(out, "gone.txt") {
+		t.Errorf("diff printed %q, %v", out, err)
+	}
+}
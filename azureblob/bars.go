@@ -0,0 +1,143 @@
+package azureblob
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	progressbar "github.com/schollz/progressbar/v3"
+)
+
+// barInterval is the least time between two renderings of the progress
+// bars, 10 a second. The SDK reports progress for every read of a response
+// or request body, far more often than a terminal can usefully show.
+const barInterval = 100 * time.Millisecond
+
+// barRenderer renders the progress bars of all transfers to one writer.
+// Updates arriving sooner than its interval after the last rendering only
+// cost a clock read, and renderings reuse one buffer. It is safe for
+// concurrent use.
+type barRenderer struct {
+	// last is when a bar was last rendered, in Unix nanoseconds. It is
+	// first for the alignment 64-bit atomics need on 32-bit platforms.
+	last     int64
+	interval time.Duration
+	// w is the writer bars are rendered to, os.Stdout at the time of
+	// writing if nil.
+	w io.Writer
+	// lines renders each bar as a timestamped line at every tenth of its
+	// transfer instead, for CI logs, which keep every rendering.
+	lines bool
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// stdoutBars renders the bars of downloads and uploads to stdout.
+var stdoutBars = &barRenderer{interval: barInterval}
+
+// setBarWriter makes stdoutBars render to w, returning the writer it
+// rendered to.
+func setBarWriter(w io.Writer) io.Writer {
+	stdoutBars.mu.Lock()
+	defer stdoutBars.mu.Unlock()
+	old := stdoutBars.w
+	stdoutBars.w = w
+	return old
+}
+
+// transferBar is the progress bar of one transfer.
+type transferBar struct {
+	*progressbar.ProgressBar
+	desc string
+	// tenths is how many tenths of the transfer have been reported as
+	// lines.
+	tenths int64
+}
+
+// newBar returns a bar for a transfer of size bytes described by desc. It
+// renders into its own string only, on every update, and leaves when to
+// write it out to a barRenderer.
+func newBar(size int64, desc string) *transferBar {
+	bar := progressbar.NewOptions64(size,
+		progressbar.OptionSetDescription(desc),
+		progressbar.OptionSetWriter(ioutil.Discard),
+		progressbar.OptionShowBytes(true),
+		progressbar.OptionSetWidth(10),
+		progressbar.OptionShowCount(),
+		progressbar.OptionSpinnerType(14),
+		progressbar.OptionFullWidth(),
+	)
+	bar.RenderBlank()
+	return &transferBar{ProgressBar: bar, desc: desc}
+}
+
+// bytesTransferredFn returns a progress callback for a transfer of size
+// bytes that renders progbar through stdoutBars.
+func bytesTransferredFn(size int64, progbar *transferBar) func(bytesTransferred int64) {
+	return func(bytesTransferred int64) {
+		stdoutBars.update(progbar, bytesTransferred, size)
+	}
+}
+
+// update sets bar to n of total bytes and renders it, unless any bar was
+// rendered less than the interval ago. A finished bar is always rendered,
+// so that it does not stay short of 100%.
+func (r *barRenderer) update(bar *transferBar, n, total int64) {
+	if r.lines {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.line(bar, n, total)
+		return
+	}
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&r.last)
+	if n < total && (now-last < int64(r.interval) || !atomic.CompareAndSwapInt64(&r.last, last, now)) {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	bar.Set64(n)
+	r.write(bar.String(), "")
+}
+
+// finish renders bar a last time and ends its line.
+func (r *barRenderer) finish(bar *transferBar) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.lines {
+		total := bar.GetMax64()
+		r.line(bar, total, total)
+		return
+	}
+	r.write(bar.String(), "\n")
+}
+
+// line writes a line for bar at n of total bytes if the transfer reached
+// a tenth it has not reported. r.mu must be held.
+func (r *barRenderer) line(bar *transferBar, n, total int64) {
+	tenths, percent := int64(10), int64(100)
+	if total > 0 {
+		tenths, percent = n*10/total, n*100/total
+	}
+	if tenths <= bar.tenths {
+		return
+	}
+	bar.tenths = tenths
+	r.write(fmt.Sprintf("%s %s %d%% (%s of %s)", time.Now().UTC().Format(time.RFC3339), bar.desc, percent, formatBytes(n), formatBytes(total)), "\n")
+}
+
+// write writes the bar s, which starts by returning to the start of the
+// line, followed by end, in one call. r.mu must be held.
+func (r *barRenderer) write(s, end string) {
+	r.buf = append(append(r.buf[:0], s...), end...)
+	w := r.w
+	if w == nil {
+		w = os.Stdout
+	}
+	w.Write(r.buf)
+}
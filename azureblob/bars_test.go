@@ -0,0 +1,66 @@
+package azureblob
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBarRendererRateLimits(t *testing.T) {
+	var out bytes.Buffer
+	r := &barRenderer{interval: time.Hour, w: &out}
+	bar := newBar(1000, "Downloading blob")
+	for n := int64(1); n < 1000; n++ {
+		r.update(bar, n, 1000)
+	}
+	if got := strings.Count(out.String(), "\r"); got != 1 {
+		t.Errorf("rendered %d times within the interval, want once", got)
+	}
+	// The last update renders however soon it comes.
+	r.update(bar, 1000, 1000)
+	r.finish(bar)
+	lines := strings.Split(out.String(), "\r")
+	if len(lines) != 4 || !strings.Contains(lines[2], "100%") || !strings.HasSuffix(out.String(), "\n") {
+		t.Errorf("output = %q", out.String())
+	}
+}
+
+func TestBarRendererInterval(t *testing.T) {
+	var out bytes.Buffer
+	r := &barRenderer{interval: time.Millisecond, w: &out}
+	bar := newBar(10, "Uploading to blob")
+	r.update(bar, 1, 10)
+	time.Sleep(2 * time.Millisecond)
+	r.update(bar, 2, 10)
+	if got := strings.Count(out.String(), "\r"); got != 2 {
+		t.Errorf("rendered %d times across intervals, want twice", got)
+	}
+}
+
+func TestBarRendererLines(t *testing.T) {
+	var out bytes.Buffer
+	r := &barRenderer{interval: time.Hour, w: &out, lines: true}
+	bar := newBar(1000, "Downloading blob")
+	for n := int64(1); n <= 1000; n++ {
+		r.update(bar, n, 1000)
+	}
+	r.finish(bar)
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 10 || strings.Contains(out.String(), "\r") {
+		t.Fatalf("output = %q", out.String())
+	}
+	if !strings.HasSuffix(lines[0], " Downloading blob 10% (100 B of 1000 B)") || !strings.HasSuffix(lines[9], " 100% (1000 B of 1000 B)") {
+		t.Errorf("lines = %q", lines)
+	}
+	if _, err := time.Parse(time.RFC3339, strings.Fields(lines[0])[0]); err != nil {
+		t.Errorf("line without a timestamp: %q", lines[0])
+	}
+
+	out.Reset()
+	empty := newBar(0, "Uploading to blob")
+	r.finish(empty)
+	if !strings.HasSuffix(out.String(), " Uploading to blob 100% (0 B of 0 B)\n") {
+		t.Errorf("empty transfer: %q", out.String())
+	}
+}
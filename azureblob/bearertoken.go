@@ -0,0 +1,150 @@
+package azureblob
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+const (
+	// storageScope is the default scope of blob tokens; see TokenScope.
+	storageScope = "https://storage.azure.com/.default"
+	// tokenRefreshWindow is how long before expiry a token is replaced.
+	tokenRefreshWindow = 2 * time.Minute
+)
+
+// bearerTokenPolicy authorizes blob requests with a token from cred. It
+// replaces the SDK's BearerTokenPolicy, which in this azcore version reads its
+// cached token without holding its lock and so races when several transfers
+// share one client.
+//
+// When the service rejects a cached token, typically because it expired or
+// was revoked before the expiry it was issued with, the policy gets a new
+// one and sends the request once more, so that long multi-file operations
+// outlive their tokens. A token fetched for the request itself is not
+// retried; withRebuild deals with a credential that no longer works.
+//
+// Tokens are requested from the tenant of the request's context, see
+// WithTenant, or else from the tenant the service last named in a bearer
+// challenge, so that one client ID can reach accounts homed in several
+// tenants.
+type bearerTokenPolicy struct {
+	cred     azcore.TokenCredential
+	scopes   []string
+	messages Messages
+
+	mu sync.Mutex
+	// tenant is the tenant the account's challenge named, if it was not
+	// the one a token was requested from.
+	tenant string
+	// tokens are the cached tokens by the tenant they were requested from,
+	// "" for the credential's own.
+	tokens map[string]*azcore.AccessToken
+}
+
+func newBearerTokenPolicy(cred azcore.TokenCredential, scopes []string, messages Messages) *bearerTokenPolicy {
+	return &bearerTokenPolicy{cred: cred, scopes: scopes, messages: messages}
+}
+
+// tokenScopes returns the scopes requested for blob tokens.
+func (o *AzureBlobClientOptions) tokenScopes() []string {
+	if o.TokenScope == "" {
+		return []string{storageScope}
+	}
+	return []string{o.TokenScope}
+}
+
+// tokenForgetter is implemented by credentials that cache tokens themselves,
+// such as sharedCredential and storedCredential, so a rejected token is not
+// handed out again.
+type tokenForgetter interface {
+	forget()
+}
+
+func (p *bearerTokenPolicy) Do(req *policy.Request) (*http.Response, error) {
+	override := tenantFrom(req.Raw().Context())
+	tenant := override
+	if tenant == "" {
+		p.mu.Lock()
+		tenant = p.tenant
+		p.mu.Unlock()
+	}
+	token, fresh, err := p.getToken(req, tenant)
+	if err != nil {
+		return nil, err
+	}
+	req.Raw().Header.Set("Authorization", "Bearer "+token)
+	resp, err := req.Next()
+	if err != nil || !isAuthRejected(resp.StatusCode, resp.Header.Get("x-ms-error-code")) {
+		return resp, err
+	}
+	switch challenged := challengeTenant(resp); {
+	case override == "" && challenged != "" && !strings.EqualFold(challenged, tenant) && !issuedBy(token, challenged):
+		log.Print(p.messages.format(MsgTenantChallenge, req.Raw().Method, req.Raw().URL.Path, challenged))
+		p.mu.Lock()
+		p.tenant = challenged
+		p.mu.Unlock()
+		tenant = challenged
+	case fresh:
+		return resp, nil
+	default:
+		log.Print(p.messages.format(MsgTokenRejected, req.Raw().Method, req.Raw().URL.Path, resp.StatusCode))
+		p.reject(tenant, token)
+	}
+	if err := req.RewindBody(); err != nil {
+		return resp, nil
+	}
+	resp.Body.Close()
+	token, _, err = p.getToken(req, tenant)
+	if err != nil {
+		return nil, err
+	}
+	req.Raw().Header.Set("Authorization", "Bearer "+token)
+	return req.Next()
+}
+
+// getToken returns the cached token of tenant, fetching a new one if it is
+// missing or about to expire, and whether it was fetched. Concurrent callers
+// wait for a single fetch.
+func (p *bearerTokenPolicy) getToken(req *policy.Request, tenant string) (string, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if token := p.tokens[tenant]; token != nil && time.Until(token.ExpiresOn) >= tokenRefreshWindow {
+		return token.Token, false, nil
+	}
+	token, err := p.cred.GetToken(req.Raw().Context(), policy.TokenRequestOptions{Scopes: p.scopes, TenantID: tenant})
+	if err != nil {
+		return "", false, err
+	}
+	if p.tokens == nil {
+		p.tokens = map[string]*azcore.AccessToken{}
+	}
+	p.tokens[tenant] = token
+	return token.Token, true, nil
+}
+
+// reject drops the cached token of tenant if it is still token, together
+// with the credential's own cache. Requests rejected together refresh it
+// only once.
+func (p *bearerTokenPolicy) reject(tenant, token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cached := p.tokens[tenant]; cached == nil || cached.Token != token {
+		return
+	}
+	delete(p.tokens, tenant)
+	if f, ok := p.cred.(tokenForgetter); ok {
+		f.forget()
+	}
+}
+
+// issuedBy reports whether token is a JWT issued by tenant.
+func issuedBy(token, tenant string) bool {
+	id, err := parseTokenIdentity(token)
+	return err == nil && strings.EqualFold(id.TenantID, tenant)
+}
@@ -0,0 +1,173 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// countingCredential hands out numbered tokens that expire after ttl.
+type countingCredential struct {
+	calls int32
+	ttl   time.Duration
+	err   error
+}
+
+func (c *countingCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (*azcore.AccessToken, error) {
+	n := atomic.AddInt32(&c.calls, 1)
+	if c.err != nil {
+		return nil, c.err
+	}
+	if len(opts.Scopes) != 1 || opts.Scopes[0] != storageScope {
+		return nil, errors.New("unexpected scopes " + strings.Join(opts.Scopes, " "))
+	}
+	return &azcore.AccessToken{Token: "token" + string(rune('0'+n)), ExpiresOn: time.Now().Add(c.ttl)}, nil
+}
+
+func authorizationRecorder(t *testing.T, cred azcore.TokenCredential) (*AzureBlobClient, func() []string) {
+	var mu sync.Mutex
+	var seen []string
+	az := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.Header().Set("Content-Length", "0")
+	}))
+	az.credential = &cred
+	return az, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), seen...)
+	}
+}
+
+func TestBearerTokenPolicyConcurrentRequests(t *testing.T) {
+	cred := &countingCredential{ttl: time.Hour}
+	az, seen := authorizationRecorder(t, cred)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := az.Stat(context.Background(), "blob"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if cred.calls != 1 {
+		t.Errorf("credential called %d times, want 1", cred.calls)
+	}
+	for _, got := range seen() {
+		if got != "Bearer token1" {
+			t.Errorf("Authorization = %q, want %q", got, "Bearer token1")
+		}
+	}
+}
+
+func TestBearerTokenPolicyRefreshesExpiringToken(t *testing.T) {
+	cred := &countingCredential{ttl: tokenRefreshWindow / 2}
+	az, seen := authorizationRecorder(t, cred)
+	for i := 0; i < 2; i++ {
+		if _, err := az.Stat(context.Background(), "blob"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := seen(); len(got) != 2 || got[0] != "Bearer token1" || got[1] != "Bearer token2" {
+		t.Errorf("Authorization headers = %q, want a fresh token per request", got)
+	}
+}
+
+func TestBearerTokenPolicyCredentialError(t *testing.T) {
+	cred := &countingCredential{err: errors.New("no identity")}
+	az, seen := authorizationRecorder(t, cred)
+	_, err := az.Stat(context.Background(), "blob")
+	if err == nil || !strings.Contains(err.Error(), "no identity") {
+		t.Errorf("got %v, want the credential error", err)
+	}
+	if len(seen()) != 0 {
+		t.Error("request was sent without a token")
+	}
+}
+
+func TestBearerTokenPolicyRetriesRejectedCachedToken(t *testing.T) {
+	m := newMemContainer()
+	m.put("blob", []byte("data"), nil)
+	var mu sync.Mutex
+	var seen []string
+	az := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.Header.Get("Authorization"))
+		reject := len(seen) == 2
+		mu.Unlock()
+		if reject {
+			w.Header().Set("x-ms-error-code", "InvalidAuthenticationInfo")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		m.ServeHTTP(w, r)
+	}))
+	var cred azcore.TokenCredential = &countingCredential{ttl: time.Hour}
+	az.credential = &cred
+	for i := 0; i < 2; i++ {
+		if _, err := az.Stat(context.Background(), "blob"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"Bearer token1", "Bearer token1", "Bearer token2"}
+	if strings.Join(seen, ",") != strings.Join(want, ",") {
+		t.Errorf("Authorization headers = %q, want %q", seen, want)
+	}
+	if az.generation != 0 {
+		t.Error("a rejected cached token rebuilt the client")
+	}
+}
+
+func TestBearerTokenPolicyForgetsSharedTokens(t *testing.T) {
+	cred := &countingCredential{ttl: time.Hour}
+	shared := &sharedCredential{cred: cred}
+	p := newBearerTokenPolicy(shared, []string{storageScope}, nil)
+	token, _ := shared.GetToken(context.Background(), policy.TokenRequestOptions{Scopes: []string{storageScope}})
+	p.tokens = map[string]*azcore.AccessToken{"": token}
+	p.reject("", "stale")
+	if p.tokens[""] == nil || shared.tokens == nil {
+		t.Error("rejecting a token no longer cached dropped the current one")
+	}
+	p.reject("", "token1")
+	if p.tokens[""] != nil || shared.tokens != nil {
+		t.Error("rejected token is still cached")
+	}
+}
+
+// scopeCredential records the scopes tokens are requested for.
+type scopeCredential struct {
+	mu     sync.Mutex
+	scopes []string
+}
+
+func (c *scopeCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (*azcore.AccessToken, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scopes = append(c.scopes, opts.Scopes...)
+	return &azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestBearerTokenPolicyTokenScope(t *testing.T) {
+	cred := &scopeCredential{}
+	az, _ := authorizationRecorder(t, cred)
+	az.ClientOptions.TokenScope = "https://storage.azure.us/.default"
+	if _, err := az.Stat(context.Background(), "blob"); err != nil {
+		t.Fatal(err)
+	}
+	if len(cred.scopes) != 1 || cred.scopes[0] != "https://storage.azure.us/.default" {
+		t.Errorf("token requested for %q, want the configured scope", cred.scopes)
+	}
+}
@@ -0,0 +1,285 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ContainerFS presents the blobs of a container as a read-only fs.FS. Blob
+// names are slash-separated paths and directories are implied by them: a
+// directory exists wherever some blob name has it as a prefix. Listing a
+// directory lists every blob beneath it, so ReadDir near the root of a large
+// container is expensive. The fallback container is not consulted.
+type ContainerFS struct {
+	ctx    context.Context
+	client *AzureBlobClient
+}
+
+var (
+	_ fs.StatFS    = (*ContainerFS)(nil)
+	_ fs.ReadDirFS = (*ContainerFS)(nil)
+)
+
+// FS returns an fs.FS over the container of c. Requests made through it use
+// ctx.
+func (c *AzureBlobClient) FS(ctx context.Context) *ContainerFS {
+	return &ContainerFS{ctx: ctx, client: c}
+}
+
+// Open opens the blob or directory name. Reads from a blob are ranged GETs
+// conditional on the ETag it had when it was opened, and files implement
+// io.Seeker and io.ReaderAt, so they can be served with http.FS or read
+// with archive/zip. Client-side encrypted blobs
+// cannot be opened.
+func (f *ContainerFS) Open(name string) (fs.File, error) {
+	info, err := f.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &blobDir{fsys: f, name: name, info: info}, nil
+	}
+	if _, _, ok := findEncryptionData(info.props.Metadata); ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("blob is client-side encrypted")}
+	}
+	return &blobFile{fsys: f, name: name, info: info, reader: f.client.newBlobReader(f.ctx, info.props)}, nil
+}
+
+// Stat returns a FileInfo for the blob or directory name. The Sys method of
+// a blob's FileInfo returns its *BlobProperties.
+func (f *ContainerFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := f.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (f *ContainerFS) stat(op, name string) (*blobInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &blobInfo{name: ".", dir: true}, nil
+	}
+	props, err := f.client.Stat(f.ctx, name)
+	if err == nil {
+		return &blobInfo{name: path.Base(name), props: props}, nil
+	}
+	if !isNotFound(err) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	blobs, err := f.client.List(f.ctx, name+"/")
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if len(blobs) == 0 {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return &blobInfo{name: path.Base(name), dir: true}, nil
+}
+
+// ReadDir lists the directory name, sorted by name. A blob whose name is
+// also the prefix of other blobs is listed as a file, matching Stat and
+// Open; the blobs beneath it can still be opened by name.
+func (f *ContainerFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	prefix := ""
+	if name != "." {
+		prefix = name + "/"
+	}
+	blobs, err := f.client.List(f.ctx, prefix)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	if len(blobs) == 0 && name != "." {
+		if _, err := f.stat("readdir", name); err != nil {
+			return nil, err
+		}
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	children := map[string]*blobInfo{}
+	for _, props := range blobs {
+		rest := strings.TrimPrefix(props.Name, prefix)
+		child, below := rest, false
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			child, below = rest[:i], true
+		}
+		if !fs.ValidPath(child) || child == "." {
+			// Names such as "a//b" or "a/" have no fs.FS equivalent.
+			continue
+		}
+		if !below {
+			children[child] = &blobInfo{name: child, props: props}
+		} else if _, ok := children[child]; !ok {
+			children[child] = &blobInfo{name: child, dir: true}
+		}
+	}
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, info := range children {
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// blobInfo is the fs.FileInfo of a blob, or of a directory if dir is set.
+type blobInfo struct {
+	name  string
+	dir   bool
+	props *BlobProperties
+}
+
+func (i *blobInfo) Name() string { return i.name }
+func (i *blobInfo) IsDir() bool  { return i.dir }
+func (i *blobInfo) Sys() interface{} {
+	if i.props == nil {
+		return nil
+	}
+	return i.props
+}
+
+func (i *blobInfo) Size() int64 {
+	if i.dir {
+		return 0
+	}
+	return i.props.Size
+}
+
+func (i *blobInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (i *blobInfo) ModTime() time.Time {
+	if i.dir {
+		return time.Time{}
+	}
+	return i.props.LastModified
+}
+
+// blobFile is an open blob. The body of a ranged GET is read until the file
+// is seeked elsewhere; ReadAt goes through a BlobReader instead.
+type blobFile struct {
+	fsys   *ContainerFS
+	name   string
+	info   *blobInfo
+	offset int64
+	body   io.ReadCloser
+	reader *BlobReader
+}
+
+func (f *blobFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+// ReadAt lets callers such as archive/zip read parts of the blob without
+// streaming it.
+func (f *blobFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.reader.ReadAt(p, off)
+	if err != nil && err != io.EOF {
+		err = &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	return n, err
+}
+
+func (f *blobFile) Read(p []byte) (int, error) {
+	size := f.info.props.Size
+	if f.offset >= size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		blob := f.fsys.client.containerClient.NewBlobClient(f.name)
+		body, err := f.fsys.client.openRange(f.fsys.ctx, blob, f.info.props.ETag, f.offset, size-f.offset)
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: newBlobError("download", f.name, err)}
+		}
+		f.body = body
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	if err == io.EOF && f.offset < size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (f *blobFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.props.Size
+	case io.SeekStart:
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fmt.Errorf("negative offset %d", offset)}
+	}
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *blobFile) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	err := f.body.Close()
+	f.body = nil
+	return err
+}
+
+// blobDir is an open directory. Its entries are listed on the first call to
+// ReadDir.
+type blobDir struct {
+	fsys    *ContainerFS
+	name    string
+	info    *blobInfo
+	entries []fs.DirEntry
+	listed  bool
+}
+
+func (d *blobDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *blobDir) Close() error               { return nil }
+
+func (d *blobDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *blobDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.listed {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.listed = entries, true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
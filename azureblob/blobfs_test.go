@@ -0,0 +1,104 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func newTestFS(t *testing.T) (*ContainerFS, *memContainer) {
+	m := newMemContainer()
+	m.put("README.md", []byte("# hello\n"), nil)
+	m.put("templates/base.html", []byte("<html>{{.}}</html>"), nil)
+	m.put("templates/partials/nav.html", []byte("<nav></nav>"), nil)
+	m.put("empty", nil, nil)
+	return newTestClient(t, m).FS(context.Background()), m
+}
+
+func TestContainerFS(t *testing.T) {
+	fsys, _ := newTestFS(t)
+	if err := fstest.TestFS(fsys, "README.md", "templates/base.html", "templates/partials/nav.html", "empty"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContainerFSErrors(t *testing.T) {
+	fsys, m := newTestFS(t)
+	r := mustKeyRing(t, "k1", map[string][]byte{"k1": testKey(1)})
+	ciphertext, metadata := encryptV2(t, r, keyWrapAES, []byte("secret"), 16)
+	m.put("secret", ciphertext, metadata)
+
+	tests := []struct {
+		name, path string
+		open       func(string) error
+		want       error
+	}{
+		{"missing", "missing", openErr(fsys), fs.ErrNotExist},
+		{"missing directory", "templates/missing", readDirErr(fsys), fs.ErrNotExist},
+		{"invalid", "/README.md", openErr(fsys), fs.ErrInvalid},
+		{"invalid directory", "templates/", readDirErr(fsys), fs.ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.open(tt.path); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+	if _, err := fsys.ReadDir("README.md"); err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Errorf("ReadDir of a blob: %v", err)
+	}
+	if _, err := fsys.Open("secret"); err == nil || !strings.Contains(err.Error(), "encrypted") {
+		t.Errorf("Open of an encrypted blob: %v", err)
+	}
+}
+
+func openErr(fsys fs.FS) func(string) error {
+	return func(name string) error {
+		_, err := fsys.Open(name)
+		return err
+	}
+}
+
+func readDirErr(fsys fs.ReadDirFS) func(string) error {
+	return func(name string) error {
+		_, err := fsys.ReadDir(name)
+		return err
+	}
+}
+
+func TestContainerFSChangedBlob(t *testing.T) {
+	fsys, m := newTestFS(t)
+	f, err := fsys.Open("README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m.put("README.md", []byte("# changed\n"), nil)
+	if _, err := io.ReadAll(f); err == nil || !strings.Contains(err.Error(), "ConditionNotMet") {
+		t.Errorf("read after the blob changed: %v", err)
+	}
+}
+
+func TestContainerFSFileServer(t *testing.T) {
+	fsys, _ := newTestFS(t)
+	srv := httptest.NewServer(http.FileServer(http.FS(fsys)))
+	defer srv.Close()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/templates/base.html", nil)
+	req.Header.Set("Range", "bytes=6-")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "{{.}}</html>" {
+		t.Errorf("got %s %q", resp.Status, body)
+	}
+}
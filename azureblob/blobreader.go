@@ -0,0 +1,170 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// defaultReadAheadSize is the size of the range a BlobReader fetches for a
+// small read.
+const defaultReadAheadSize = 1 << 20
+
+func (o *AzureBlobClientOptions) readAheadSize() int64 {
+	if o.ReadAheadSize > 0 {
+		return o.ReadAheadSize
+	}
+	return defaultReadAheadSize
+}
+
+// BlobReader gives random access to a blob through ranged GETs, without
+// downloading it. Reads smaller than the client's ReadAheadSize fetch that
+// much and serve following reads from the buffer, so small scattered reads,
+// such as of a zip central directory or a parquet footer, cost one request
+// each. All requests are conditional on the ETag the blob had when the
+// reader was created, so a blob that changes underneath it fails reads
+// instead of mixing versions. ReadAt may be called concurrently; Read and
+// Seek may not.
+type BlobReader struct {
+	c      *AzureBlobClient
+	ctx    context.Context
+	props  *BlobProperties
+	offset int64
+
+	mu       sync.Mutex
+	bufStart int64
+	buf      []byte
+}
+
+var (
+	_ io.ReaderAt   = (*BlobReader)(nil)
+	_ io.ReadSeeker = (*BlobReader)(nil)
+)
+
+// NewBlobReader returns a reader over the blob name. Requests made by the
+// reader use ctx. Client-side encrypted blobs cannot be read this way.
+func (c *AzureBlobClient) NewBlobReader(ctx context.Context, name string) (*BlobReader, error) {
+	props, err := c.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, ok := findEncryptionData(props.Metadata); ok {
+		return nil, fmt.Errorf("read %q: blob is client-side encrypted", name)
+	}
+	return c.newBlobReader(ctx, props), nil
+}
+
+func (c *AzureBlobClient) newBlobReader(ctx context.Context, props *BlobProperties) *BlobReader {
+	return &BlobReader{c: c, ctx: ctx, props: props}
+}
+
+// Size returns the size of the blob.
+func (r *BlobReader) Size() int64 {
+	return r.props.Size
+}
+
+// ReadAt reads len(p) bytes from offset off of the blob.
+func (r *BlobReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("read %q: negative offset %d", r.props.Name, off)
+	}
+	size := r.props.Size
+	if off >= size {
+		return 0, io.EOF
+	}
+	want := int64(len(p))
+	if off+want > size {
+		want = size - off
+	}
+	n := 0
+	if want > 0 {
+		var err error
+		if n, err = r.readAt(p[:want], off); err != nil {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readAt fills p, which lies within the blob, from the buffer or from a new
+// range.
+func (r *BlobReader) readAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	if off >= r.bufStart && off+int64(len(p)) <= r.bufStart+int64(len(r.buf)) {
+		n := copy(p, r.buf[off-r.bufStart:])
+		r.mu.Unlock()
+		return n, nil
+	}
+	r.mu.Unlock()
+
+	readAhead := r.c.clientOptions().readAheadSize()
+	if int64(len(p)) >= readAhead {
+		return r.fetch(p, off)
+	}
+	count := readAhead
+	if off+count > r.props.Size {
+		count = r.props.Size - off
+	}
+	buf := make([]byte, count)
+	if n, err := r.fetch(buf, off); err != nil {
+		return copy(p, buf[:n]), err
+	}
+	r.mu.Lock()
+	r.bufStart, r.buf = off, buf
+	r.mu.Unlock()
+	return copy(p, buf), nil
+}
+
+// fetch fills p with the range of the blob starting at off.
+func (r *BlobReader) fetch(p []byte, off int64) (int, error) {
+	name := r.props.Name
+	count := int64(len(p))
+	var n int
+	err := r.c.withTransferDeadline(r.ctx, "read", name, count, func(ctx context.Context) error {
+		blob := r.c.containerClient.NewBlobClient(name)
+		body, err := r.c.openRange(ctx, blob, r.props.ETag, off, count)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		n, err = io.ReadFull(body, p)
+		return err
+	})
+	if err != nil {
+		return n, newBlobError("read", name, err)
+	}
+	return n, nil
+}
+
+// Read reads from the current offset.
+func (r *BlobReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the offset of the next Read.
+func (r *BlobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.props.Size
+	default:
+		return 0, errors.New("seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("seek %q: negative offset %d", r.props.Name, offset)
+	}
+	r.offset = offset
+	return offset, nil
+}
@@ -0,0 +1,128 @@
+package azureblob
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestBlobReader(t *testing.T, data []byte, readAhead int64) (*BlobReader, *rangeHandler, *memContainer) {
+	m := newMemContainer()
+	m.put("blob", data, nil)
+	h := &rangeHandler{m: m, failFrom: -1}
+	az := newTestClient(t, h)
+	az.ClientOptions.ReadAheadSize = readAhead
+	r, err := az.NewBlobReader(context.Background(), "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, h, m
+}
+
+func TestBlobReaderReadAt(t *testing.T) {
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	r, h, _ := newTestBlobReader(t, data, 8)
+	tests := []struct {
+		off     int64
+		n       int
+		want    string
+		wantEOF bool
+		starts  []int
+	}{
+		{off: 10, n: 2, want: "ab", starts: []int{10}},
+		{off: 14, n: 4, want: "efgh", starts: []int{10}},
+		{off: 17, n: 3, want: "hij", starts: []int{10, 17}},
+		{off: 0, n: 10, want: "0123456789", starts: []int{10, 17, 0}},
+		{off: 34, n: 4, want: "yz", wantEOF: true, starts: []int{10, 17, 0, 34}},
+		{off: 36, n: 1, want: "", wantEOF: true, starts: []int{10, 17, 0, 34}},
+	}
+	for _, tt := range tests {
+		p := make([]byte, tt.n)
+		n, err := r.ReadAt(p, tt.off)
+		if string(p[:n]) != tt.want || (err == io.EOF) != tt.wantEOF || err != nil && err != io.EOF {
+			t.Errorf("ReadAt(%d, %d) = %q, %v; want %q", tt.n, tt.off, p[:n], err, tt.want)
+		}
+		if got := h.starts; !reflect.DeepEqual(got, tt.starts) {
+			t.Errorf("after ReadAt(%d, %d) ranges started at %v, want %v", tt.n, tt.off, got, tt.starts)
+		}
+	}
+}
+
+func TestBlobReaderSeek(t *testing.T) {
+	data := bytes.Repeat([]byte("seekable "), 100)
+	r, _, _ := newTestBlobReader(t, data, 64)
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("ReadAll = %d bytes, %v", len(got), err)
+	}
+	if pos, err := r.Seek(-9, io.SeekEnd); err != nil || pos != int64(len(data)-9) {
+		t.Fatalf("Seek = %d, %v", pos, err)
+	}
+	if got, _ := io.ReadAll(r); string(got) != "seekable " {
+		t.Errorf("read %q after seeking to the end", got)
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Error("seek to a negative offset succeeded")
+	}
+}
+
+func TestBlobReaderZip(t *testing.T) {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		w, _ := zw.Create(name)
+		io.WriteString(w, strings.Repeat(name, 1000))
+	}
+	zw.Close()
+	r, h, _ := newTestBlobReader(t, archive.Bytes(), 256)
+	zr, err := zip.NewReader(r, r.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := zr.File[1].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if got, _ := io.ReadAll(rc); string(got) != strings.Repeat("b.txt", 1000) {
+		t.Errorf("b.txt holds %q", got)
+	}
+	if len(h.starts) > 4 {
+		t.Errorf("%d ranged requests, want a few", len(h.starts))
+	}
+}
+
+func TestBlobReaderErrors(t *testing.T) {
+	r, _, m := newTestBlobReader(t, []byte("version one"), 4)
+	m.put("blob", []byte("version two"), nil)
+	if _, err := r.ReadAt(make([]byte, 2), 0); err == nil || !strings.Contains(err.Error(), "ConditionNotMet") {
+		t.Errorf("read after the blob changed: %v", err)
+	}
+
+	keys := mustKeyRing(t, "k1", map[string][]byte{"k1": testKey(1)})
+	ciphertext, metadata := encryptV2(t, keys, keyWrapAES, []byte("secret"), 16)
+	m.put("secret", ciphertext, metadata)
+	az := newTestClient(t, m)
+	if _, err := az.NewBlobReader(context.Background(), "secret"); err == nil || !strings.Contains(err.Error(), "encrypted") {
+		t.Errorf("reader over an encrypted blob: %v", err)
+	}
+	if _, err := az.NewBlobReader(context.Background(), "missing"); !isNotFound(err) {
+		t.Errorf("reader over a missing blob: %v", err)
+	}
+}
+
+func TestContainerFSReadAt(t *testing.T) {
+	fsys, _ := newTestFS(t)
+	f, err := fsys.Open("templates/base.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p := make([]byte, 5)
+	if n, err := f.(io.ReaderAt).ReadAt(p, 6); err != nil || string(p[:n]) != "{{.}}" {
+		t.Errorf("ReadAt = %q, %v", p[:n], err)
+	}
+}
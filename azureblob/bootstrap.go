@@ -0,0 +1,185 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// maxBootstrapManifestSize bounds the bootstrap manifests read into memory.
+const maxBootstrapManifestSize = 16 << 20
+
+// BootstrapManifest lists the files a bootstrap installs. It is a JSON blob,
+// which may be signed with minisign.
+type BootstrapManifest struct {
+	Files []BootstrapFile `json:"files"`
+}
+
+// BootstrapFile is a blob a bootstrap downloads, with the size and SHA-256
+// its download must have. Path is where it goes relative to the bootstrap
+// directory, and defaults to the blob name.
+type BootstrapFile struct {
+	Blob   string `json:"blob"`
+	Path   string `json:"path,omitempty"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BootstrapOptions configure Bootstrap.
+type BootstrapOptions struct {
+	// PublicKey, if set, is the key the manifest must be signed with.
+	// Without it the manifest is trusted as downloaded.
+	PublicKey *MinisignPublicKey
+	// Signature is the blob of the manifest's minisign signature, by
+	// default the manifest's name with .minisig appended.
+	Signature string
+}
+
+// parseBootstrapManifest parses and checks a bootstrap manifest.
+func parseBootstrapManifest(b []byte) (*BootstrapManifest, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	m := &BootstrapManifest{}
+	if err := dec.Decode(m); err != nil {
+		return nil, err
+	}
+	for _, f := range m.Files {
+		if f.Blob == "" {
+			return nil, errors.New("every file needs a blob")
+		}
+		if sum, err := hex.DecodeString(f.SHA256); err != nil || len(sum) != 32 {
+			return nil, fmt.Errorf("%s: sha256 %q is not a hex SHA-256", f.Blob, f.SHA256)
+		}
+		if f.Size < 0 {
+			return nil, fmt.Errorf("%s: negative size %d", f.Blob, f.Size)
+		}
+	}
+	return m, nil
+}
+
+// readSmallBlob returns the contents of the blob name, which may be at most
+// limit bytes long.
+func (c *AzureBlobClient) readSmallBlob(ctx context.Context, name string, limit int64) ([]byte, error) {
+	r, err := c.NewBlobReader(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if r.Size() > limit {
+		return nil, fmt.Errorf("%s is %s, more than the %s allowed", name, formatBytes(r.Size()), formatBytes(limit))
+	}
+	return io.ReadAll(r)
+}
+
+// Bootstrap downloads the bootstrap manifest manifestBlob, checks its
+// signature if opts has a public key, and then downloads every file it
+// lists into dir, as Pool allows. Each blob must have the listed size
+// before it is downloaded and the listed SHA-256 after; a download that
+// does not is removed again. Paths cannot leave dir. All files are attempted
+// even if some fail, and there is one result per file. The error is set if
+// the manifest cannot be read or verified, or any file fails.
+func (c *AzureBlobClient) Bootstrap(ctx context.Context, manifestBlob, dir string, opts BootstrapOptions) ([]ManifestResult, error) {
+	data, err := c.readSmallBlob(ctx, manifestBlob, maxBootstrapManifestSize)
+	if err != nil {
+		return nil, err
+	}
+	if opts.PublicKey != nil {
+		sigBlob := opts.Signature
+		if sigBlob == "" {
+			sigBlob = manifestBlob + ".minisig"
+		}
+		sig, err := c.readSmallBlob(ctx, sigBlob, 1<<10)
+		if err != nil {
+			return nil, err
+		}
+		comment, err := opts.PublicKey.Verify(data, sig)
+		if err != nil {
+			return nil, fmt.Errorf("manifest %s: %w", manifestBlob, err)
+		}
+		log.Print(c.Messages.format(MsgManifestVerified, manifestBlob, opts.PublicKey, comment))
+	} else {
+		log.Print(c.Messages.format(MsgManifestUnsigned, manifestBlob))
+	}
+	m, err := parseBootstrapManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse manifest %s: %w", manifestBlob, err)
+	}
+	results := make([]ManifestResult, len(m.Files))
+	for i, f := range m.Files {
+		p := f.Path
+		if p == "" {
+			p = f.Blob
+		}
+		results[i] = ManifestResult{Direction: "download", Item: ManifestItem{Blob: f.Blob, Path: downloadDestination(dir, p), SHA256: f.SHA256}}
+	}
+	errs := c.Pool.Run(ctx, len(results), func(ctx context.Context, i int) error {
+		item := results[i].Item
+		props, err := c.Stat(ctx, item.Blob)
+		if err != nil {
+			return err
+		}
+		if want := m.Files[i].Size; props.Size != want {
+			return &ChecksumError{Path: item.Blob, Algorithm: "size", Got: fmt.Sprint(props.Size), Want: fmt.Sprint(want)}
+		}
+		return downloadManifestItem(ctx, c, item)
+	})
+	for i, err := range errs {
+		if err != nil {
+			results[i].Error = err.Error()
+			results[i].err = err
+		}
+	}
+	return results, manifestError(results)
+}
+
+func runBootstrap(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("bootstrap", flag.ContinueOnError)
+	publicKey := fs.String("public-key", "", "minisign public `key`, or a file holding it, the manifest must be signed with (default the configuration's bootstrap_public_key)")
+	signature := fs.String("signature", "", "`blob` of the manifest's minisign signature (default <manifest>.minisig)")
+	insecure := fs.Bool("insecure-skip-signature", false, "trust the manifest without checking a signature, even if a public key is configured")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: bootstrap [flags] <manifest-blob> <directory>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("bootstrap takes a manifest blob and a directory")
+	}
+	key := *publicKey
+	if key == "" {
+		key = az.clientOptions().BootstrapPublicKey
+	}
+	var opts BootstrapOptions
+	switch {
+	case *insecure:
+	case key == "":
+		return errors.New("bootstrap needs a -public-key, or bootstrap_public_key in the configuration; pass -insecure-skip-signature to trust an unsigned manifest")
+	default:
+		k, err := loadMinisignPublicKey(key)
+		if err != nil {
+			return err
+		}
+		opts = BootstrapOptions{PublicKey: k, Signature: *signature}
+	}
+	if err := os.MkdirAll(fs.Arg(1), 0755); err != nil {
+		return err
+	}
+	az, manifest, err := az.resolveRemote(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	stop := reportProgress(os.Stderr, az.Messages, az.shareProgress(), progressInterval)
+	results, err := az.Bootstrap(ctx, manifest, fs.Arg(1), opts)
+	stop()
+	printManifestResults(az.Messages, results)
+	return err
+}
@@ -0,0 +1,90 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBootstrap(t *testing.T) {
+	priv, pubFile := testMinisignKey(t)
+	key, err := ParseMinisignPublicKey(pubFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newMemContainer()
+	m.put("tools/agent", []byte("agent-binary"), nil)
+	m.put("tools/agent.yaml", []byte("config"), nil)
+	m.put("tools/tampered", []byte("evil"), nil)
+	manifest := fmt.Sprintf(`{"files": [
+		{"blob": "tools/agent", "path": "bin/agent", "size": 12, "sha256": %q},
+		{"blob": "tools/agent.yaml", "path": "../../etc/agent.yaml", "size": 6, "sha256": %q},
+		{"blob": "tools/tampered", "size": 4, "sha256": %q}
+	]}`, sha256Hex([]byte("agent-binary")), sha256Hex([]byte("config")), sha256Hex([]byte("good")))
+	m.put("bootstrap.json", []byte(manifest), nil)
+	m.put("bootstrap.json.minisig", minisignSign(priv, []byte(manifest), false, "timestamp:1760486400"), nil)
+	az := newTestClient(t, m)
+	dir := t.TempDir()
+
+	results, err := az.Bootstrap(context.Background(), "bootstrap.json", dir, BootstrapOptions{PublicKey: key})
+	if err == nil || err.Error() != "1 of 3 transfers failed" || exitCode(err) != exitChecksum {
+		t.Errorf("Bootstrap = %v", err)
+	}
+	if len(results) != 3 || results[0].err != nil || results[1].err != nil {
+		t.Fatalf("results = %+v", results)
+	}
+	for path, want := range map[string]string{"bin/agent": "agent-binary", "etc/agent.yaml": "config"} {
+		if got, err := os.ReadFile(filepath.Join(dir, path)); err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", path, got, err, want)
+		}
+	}
+	var checksumErr *ChecksumError
+	if !errors.As(results[2].err, &checksumErr) || checksumErr.Algorithm != "sha256" {
+		t.Errorf("tampered file: %v", results[2].err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tools", "tampered")); !os.IsNotExist(err) {
+		t.Errorf("the tampered file was left behind: %v", err)
+	}
+}
+
+func TestBootstrapRefusesManifest(t *testing.T) {
+	priv, pubFile := testMinisignKey(t)
+	key, err := ParseMinisignPublicKey(pubFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := `{"files": [{"blob": "a", "size": 1, "sha256": "` + sha256Hex([]byte("a")) + `"}]}`
+	m := newMemContainer()
+	m.put("a", []byte("aa"), nil)
+	m.put("signed.json", []byte(signed), nil)
+	m.put("signed.json.minisig", minisignSign(priv, []byte(signed), true, ""), nil)
+	m.put("swapped.json", []byte(strings.Replace(signed, `"size": 1`, `"size": 2`, 1)), nil)
+	m.put("swapped.json.minisig", minisignSign(priv, []byte(signed), true, ""), nil)
+	m.put("unsigned.json", []byte(signed), nil)
+	m.put("bad.json", []byte(`{"files": [{"blob": "a", "sha256": "abc"}]}`), nil)
+	az := newTestClient(t, m)
+	dir := t.TempDir()
+
+	for _, tt := range []struct {
+		manifest string
+		opts     BootstrapOptions
+		want     string
+	}{
+		{"swapped.json", BootstrapOptions{PublicKey: key}, "manifest swapped.json: signature verification failed: the signature does not match"},
+		{"unsigned.json", BootstrapOptions{PublicKey: key}, `stat "unsigned.json.minisig": HTTP 404`},
+		{"bad.json", BootstrapOptions{}, `parse manifest bad.json: a: sha256 "abc" is not a hex SHA-256`},
+		{"signed.json", BootstrapOptions{PublicKey: key, Signature: "swapped.json.minisig"}, "a has size 2, want 1"},
+	} {
+		results, err := az.Bootstrap(context.Background(), tt.manifest, dir, tt.opts)
+		if err == nil || !strings.Contains(fmt.Sprint(err, results), tt.want) {
+			t.Errorf("%s: Bootstrap = %v, %+v; want %q", tt.manifest, err, results, tt.want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a")); !os.IsNotExist(err) {
+		t.Errorf("a blob of the wrong size was downloaded: %v", err)
+	}
+}
@@ -0,0 +1,695 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+const (
+	// browseWorkers is how many queued transfers browse runs at once.
+	browseWorkers = 4
+	// browseRefresh is how often browse redraws, for live progress.
+	browseRefresh = 250 * time.Millisecond
+	// browseTransferLines is how many transfers browse shows, the latest.
+	browseTransferLines = 5
+)
+
+// States of a transfer queued in browse.
+const (
+	browseQueued  = "queued"
+	browseRunning = "running"
+	browseDone    = "done"
+)
+
+const browseHelp = "↑/↓ move  enter open  ← up  i info  d download  u upload  r refresh  q quit"
+
+// escapeKeys names the keys terminals send as escape sequences, by the
+// sequence after ESC.
+var escapeKeys = map[string]string{
+	"[A": "up", "[B": "down", "[C": "right", "[D": "left",
+	"OA": "up", "OB": "down", "OC": "right", "OD": "left",
+	"[H": "home", "[F": "end", "OH": "home", "OF": "end",
+	"[1~": "home", "[4~": "end", "[5~": "pgup", "[6~": "pgdn",
+}
+
+// parseKeys splits input read from a terminal in raw mode into keys: the
+// names of escapeKeys, "enter", "backspace", "esc" and "ctrl-c", and other
+// characters as themselves. Other control characters and unknown escape
+// sequences are dropped.
+func parseKeys(b []byte) []string {
+	var keys []string
+	for len(b) > 0 {
+		switch c := b[0]; {
+		case c == 0x1b:
+			if len(b) > 2 && (b[1] == '[' || b[1] == 'O') {
+				// A sequence ends at its first byte from @ to ~.
+				end := 2
+				for end < len(b) && (b[end] < 0x40 || b[end] > 0x7e) {
+					end++
+				}
+				if end < len(b) {
+					if name, ok := escapeKeys[string(b[1:end+1])]; ok {
+						keys = append(keys, name)
+					}
+					b = b[end+1:]
+					continue
+				}
+			}
+			keys = append(keys, "esc")
+			b = b[1:]
+		case c == '\r' || c == '\n':
+			keys = append(keys, "enter")
+			b = b[1:]
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, "backspace")
+			b = b[1:]
+		case c == 0x03:
+			keys = append(keys, "ctrl-c")
+			b = b[1:]
+		case c < 0x20:
+			b = b[1:]
+		default:
+			r, size := utf8.DecodeRune(b)
+			keys = append(keys, string(r))
+			b = b[size:]
+		}
+	}
+	return keys
+}
+
+// readKeys sends the keys read from r to keys until reading fails or done
+// is closed.
+func readKeys(r io.Reader, keys chan<- string, done <-chan struct{}) {
+	defer close(keys)
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		for _, k := range parseKeys(buf[:n]) {
+			select {
+			case keys <- k:
+			case <-done:
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// sanitizeTerminal replaces the control characters in s, which blob names
+// and metadata may hold, so that they cannot move the cursor, clear the
+// screen or otherwise drive the terminal.
+func sanitizeTerminal(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return '\uFFFD'
+		}
+		return r
+	}, s)
+}
+
+// browseEntry is a line of the browse listing: a directory under the
+// prefix browsed, or a blob directly in it.
+type browseEntry struct {
+	// Name is relative to the prefix, and ends in a slash for directories.
+	Name  string
+	Dir   bool
+	Props *BlobProperties
+}
+
+// browseEntries returns the entries of a listing of prefix by directory:
+// dirs, the prefixes one level below it, first, then blobs, each in the
+// service's order, which is by name.
+func browseEntries(prefix string, dirs []string, blobs []*BlobProperties) []browseEntry {
+	var entries []browseEntry
+	for _, d := range dirs {
+		entries = append(entries, browseEntry{Name: strings.TrimPrefix(d, prefix), Dir: true})
+	}
+	for _, b := range blobs {
+		// A blob named like the prefix itself marks the directory.
+		if name := strings.TrimPrefix(b.Name, prefix); name != "" {
+			entries = append(entries, browseEntry{Name: name, Props: b})
+		}
+	}
+	return entries
+}
+
+// browseListing is a listing of a prefix, loaded off the key loop.
+type browseListing struct {
+	// seq tells the latest listing asked for from earlier ones.
+	seq     int
+	prefix  string
+	entries []browseEntry
+	err     error
+}
+
+// parentPrefix returns the prefix of the directory holding prefix.
+func parentPrefix(prefix string) string {
+	if i := strings.LastIndex(strings.TrimSuffix(prefix, "/"), "/"); i >= 0 {
+		return prefix[:i+1]
+	}
+	return ""
+}
+
+// browseTransfer is a transfer queued in browse.
+type browseTransfer struct {
+	ManifestResult
+	state    string
+	progress *ProgressAggregator
+}
+
+// browser is the state of the browse command: the listing of a prefix of
+// the container, the blob whose properties are shown, and the transfers
+// queued. Keys are handled, and the screen drawn, by one goroutine, while
+// listings load and transfers run in others.
+type browser struct {
+	az      *AzureBlobClient
+	dir     string
+	prefix  string
+	entries []browseEntry
+	cursor  int
+	top     int
+	details []string
+	// prompt holds the path of an upload being typed, if any.
+	prompt   *string
+	quitting bool
+	// loading is the seq of the latest listing asked for, and loaded
+	// receives listings once loaded.
+	loading int
+	loaded  chan browseListing
+
+	mu        sync.Mutex
+	status    string
+	transfers []*browseTransfer
+	slots     chan struct{}
+	wg        sync.WaitGroup
+}
+
+func newBrowser(az *AzureBlobClient, dir string) *browser {
+	return &browser{az: az, dir: dir, slots: make(chan struct{}, browseWorkers), loaded: make(chan browseListing)}
+}
+
+// list lists prefix by directory.
+func (b *browser) list(ctx context.Context, seq int, prefix string) browseListing {
+	dirs, blobs, err := b.az.listDir(ctx, prefix)
+	return browseListing{seq: seq, prefix: prefix, entries: browseEntries(prefix, dirs, blobs), err: err}
+}
+
+// open starts listing prefix in another goroutine, which sends the listing
+// to b.loaded for show to display. Only the latest listing asked for is
+// shown, so keys pressed while a slow listing loads are not lost to it.
+func (b *browser) open(ctx context.Context, prefix string) {
+	b.loading++
+	seq := b.loading
+	b.setStatus("listing %s...", prefix)
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		l := b.list(ctx, seq, prefix)
+		select {
+		case b.loaded <- l:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// show displays l, unless a later listing was asked for.
+func (b *browser) show(l browseListing) {
+	if l.seq != b.loading {
+		return
+	}
+	if l.err != nil {
+		b.setStatus("%v", l.err)
+		return
+	}
+	b.prefix, b.entries = l.prefix, l.entries
+	b.cursor, b.top, b.details = 0, 0, nil
+	b.setStatus("")
+}
+
+// selected returns the entry under the cursor, if any.
+func (b *browser) selected() (browseEntry, bool) {
+	if b.cursor < 0 || b.cursor >= len(b.entries) {
+		return browseEntry{}, false
+	}
+	return b.entries[b.cursor], true
+}
+
+func (b *browser) move(n int) {
+	b.cursor += n
+	if b.cursor >= len(b.entries) {
+		b.cursor = len(b.entries) - 1
+	}
+	if b.cursor < 0 {
+		b.cursor = 0
+	}
+}
+
+// Write shows what is logged while browsing, such as retries, on the
+// status line, instead of letting it garble the screen.
+func (b *browser) Write(p []byte) (int, error) {
+	lines := strings.Split(strings.TrimRight(string(p), "\n"), "\n")
+	b.setStatus("%s", lines[len(lines)-1])
+	return len(p), nil
+}
+
+func (b *browser) setStatus(format string, args ...interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.status = fmt.Sprintf(format, args...)
+}
+
+// key handles k, and reports whether it quits.
+func (b *browser) key(ctx context.Context, k string) bool {
+	if b.prompt != nil {
+		b.promptKey(ctx, k)
+		return false
+	}
+	if k != "q" && k != "ctrl-c" {
+		b.quitting = false
+	}
+	var err error
+	switch k {
+	case "up", "k":
+		b.move(-1)
+	case "down", "j":
+		b.move(1)
+	case "pgup":
+		b.move(-10)
+	case "pgdn":
+		b.move(10)
+	case "home", "g":
+		b.move(-len(b.entries))
+	case "end", "G":
+		b.move(len(b.entries))
+	case "enter", "right", "l":
+		if e, ok := b.selected(); ok && e.Dir {
+			b.open(ctx, b.prefix+e.Name)
+		} else if ok {
+			err = b.info(ctx, e)
+		}
+	case "left", "h", "backspace":
+		if b.prefix != "" {
+			b.open(ctx, parentPrefix(b.prefix))
+		}
+	case "i":
+		if e, ok := b.selected(); ok && !e.Dir {
+			err = b.info(ctx, e)
+		}
+	case "esc":
+		b.details = nil
+	case "r":
+		b.open(ctx, b.prefix)
+	case "d":
+		b.download(ctx)
+	case "u":
+		path := ""
+		b.prompt = &path
+	case "q", "ctrl-c":
+		if n := b.active(); n > 0 && !b.quitting {
+			b.quitting = true
+			b.setStatus("%d transfers have not finished; press q again to cancel them and quit", n)
+			return false
+		}
+		return true
+	}
+	if err != nil {
+		b.setStatus("%v", err)
+	}
+	return false
+}
+
+// promptKey edits the path of the upload being typed, queueing it on
+// enter.
+func (b *browser) promptKey(ctx context.Context, k string) {
+	switch k {
+	case "enter":
+		path := *b.prompt
+		b.prompt = nil
+		if err := b.upload(ctx, path); err != nil {
+			b.setStatus("%v", err)
+		}
+	case "esc", "ctrl-c":
+		b.prompt = nil
+	case "backspace":
+		if _, size := utf8.DecodeLastRuneInString(*b.prompt); size > 0 {
+			*b.prompt = (*b.prompt)[:len(*b.prompt)-size]
+		}
+	default:
+		if utf8.RuneCountInString(k) == 1 {
+			*b.prompt += k
+		}
+	}
+}
+
+// info shows the properties of the blob of e, including its metadata,
+// which listings leave out.
+func (b *browser) info(ctx context.Context, e browseEntry) error {
+	props, err := b.az.Stat(ctx, b.prefix+e.Name)
+	if err != nil {
+		return err
+	}
+	b.details = propertyLines(props)
+	return nil
+}
+
+// download queues the download of the blob under the cursor, or of every
+// blob under the directory under it, into the download directory. Blobs
+// keep their names relative to the prefix browsed. The blobs of a
+// directory are listed in another goroutine.
+func (b *browser) download(ctx context.Context) {
+	e, ok := b.selected()
+	if !ok {
+		return
+	}
+	prefix := b.prefix
+	queue := func(blobs []*BlobProperties) {
+		for _, blob := range blobs {
+			b.enqueue(ctx, "download", ManifestItem{Blob: blob.Name, Path: downloadDestination(b.dir, strings.TrimPrefix(blob.Name, prefix))})
+		}
+		b.setStatus("queued %d downloads into %s", len(blobs), b.dir)
+	}
+	if !e.Dir {
+		queue([]*BlobProperties{e.Props})
+		return
+	}
+	b.setStatus("listing %s...", prefix+e.Name)
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		blobs, err := b.az.List(ctx, prefix+e.Name)
+		if err != nil {
+			b.setStatus("%v", err)
+			return
+		}
+		queue(blobs)
+	}()
+}
+
+// upload queues the upload of the file at local, or of every file under
+// the directory there, into the prefix browsed, by their base name.
+func (b *browser) upload(ctx context.Context, local string) error {
+	local = strings.TrimSpace(local)
+	if local == "" {
+		return nil
+	}
+	info, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+	base := filepath.Base(local)
+	if !info.IsDir() {
+		b.enqueue(ctx, "upload", ManifestItem{Blob: b.prefix + base, Path: local})
+		b.setStatus("queued the upload of %s", local)
+		return nil
+	}
+	var items []ManifestItem
+	err = filepath.WalkDir(localRoot(local), func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(localRoot(local), p)
+		if err != nil {
+			return err
+		}
+		items = append(items, ManifestItem{Blob: b.prefix + path.Join(base, filepath.ToSlash(rel)), Path: p})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		b.enqueue(ctx, "upload", item)
+	}
+	b.setStatus("queued %d uploads from %s", len(items), local)
+	return nil
+}
+
+// enqueue queues a transfer, which runs as soon as fewer than
+// browseWorkers others are running.
+func (b *browser) enqueue(ctx context.Context, direction string, item ManifestItem) {
+	t := &browseTransfer{ManifestResult: ManifestResult{Direction: direction, Item: item}, state: browseQueued, progress: NewProgressAggregator()}
+	b.mu.Lock()
+	b.transfers = append(b.transfers, t)
+	b.mu.Unlock()
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		select {
+		case b.slots <- struct{}{}:
+		case <-ctx.Done():
+			b.finish(t, ctx.Err())
+			return
+		}
+		defer func() { <-b.slots }()
+		b.mu.Lock()
+		t.state = browseRunning
+		b.mu.Unlock()
+		b.finish(t, b.az.transferManifestItem(WithProgress(ctx, t.progress), nil, t.ManifestResult))
+	}()
+}
+
+func (b *browser) finish(t *browseTransfer, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t.state = browseDone
+	if err != nil {
+		t.Error, t.err = err.Error(), err
+	}
+}
+
+// active returns how many queued transfers have not finished.
+func (b *browser) active() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, t := range b.transfers {
+		if t.state != browseDone {
+			n++
+		}
+	}
+	return n
+}
+
+// results returns the results of the transfers queued, in order.
+func (b *browser) results() []ManifestResult {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	results := make([]ManifestResult, len(b.transfers))
+	for i, t := range b.transfers {
+		results[i] = t.ManifestResult
+	}
+	return results
+}
+
+// transferLine describes t as manifest results do, with its progress
+// while it runs.
+func (b *browser) transferLine(t *browseTransfer) string {
+	msgs := b.az.Messages
+	var status string
+	switch {
+	case t.state == browseQueued:
+		status = browseQueued
+	case t.state == browseRunning:
+		s := t.progress.Snapshot()
+		status = fmt.Sprintf("%s/%s, %s/s", formatBytes(s.BytesTransferred), formatBytes(s.BytesTotal), formatBytes(int64(s.BytesPerSecond)))
+	case t.Error != "":
+		status = msgs.format(MsgResultFailed, t.Error)
+	default:
+		status = msgs.format(MsgResultOK)
+	}
+	if t.Direction == "download" {
+		return msgs.format(MsgManifestDownload, t.Item.Blob, t.Item.Path, status)
+	}
+	return msgs.format(MsgManifestUpload, t.Item.Path, t.Item.Blob, status)
+}
+
+// render draws the screen, width by height characters, to w: the prefix
+// browsed, its listing, the properties shown, the latest transfers, a
+// status line and the keys.
+func (b *browser) render(w io.Writer, width, height int) {
+	var footer []string
+	if b.details != nil {
+		footer = append(footer, "")
+		footer = append(footer, b.details...)
+	}
+	b.mu.Lock()
+	if n := len(b.transfers); n > 0 {
+		footer = append(footer, "", fmt.Sprintf("Transfers (%d):", n))
+		shown := b.transfers
+		if len(shown) > browseTransferLines {
+			shown = shown[len(shown)-browseTransferLines:]
+		}
+		for _, t := range shown {
+			footer = append(footer, "  "+b.transferLine(t))
+		}
+	}
+	status := b.status
+	b.mu.Unlock()
+	if b.prompt != nil {
+		status = "upload file or directory: " + *b.prompt + "_"
+	}
+	footer = append(footer, "", status, browseHelp)
+
+	lines := []string{fmt.Sprintf("%s/%s/%s", b.az.StorageAccount, b.az.ContainerName, b.prefix), ""}
+	rows := height - len(lines) - len(footer)
+	if rows < 1 {
+		rows = 1
+	}
+	if b.cursor < b.top {
+		b.top = b.cursor
+	}
+	if b.cursor >= b.top+rows {
+		b.top = b.cursor - rows + 1
+	}
+	if len(b.entries) == 0 {
+		lines = append(lines, "  (no blobs)")
+	}
+	for i := b.top; i < len(b.entries) && i < b.top+rows; i++ {
+		e := b.entries[i]
+		marker := "  "
+		if i == b.cursor {
+			marker = "> "
+		}
+		if e.Dir {
+			lines = append(lines, fmt.Sprintf("%s%-40s %10s", marker, e.Name, "<dir>"))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s%-40s %10s  %s", marker, e.Name, formatBytes(e.Props.Size), e.Props.LastModified.Format("2006-01-02 15:04")))
+		}
+	}
+	for len(lines) < height-len(footer) {
+		lines = append(lines, "")
+	}
+	lines = append(lines, footer...)
+
+	var sb strings.Builder
+	sb.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i > 0 {
+			sb.WriteString("\r\n")
+		}
+		line = sanitizeTerminal(line)
+		if r := []rune(line); len(r) > width {
+			line = string(r[:width])
+		}
+		sb.WriteString(line)
+		sb.WriteString("\x1b[K")
+	}
+	sb.WriteString("\x1b[J")
+	io.WriteString(w, sb.String())
+}
+
+// loop handles keys and shows listings as they load, redrawing with draw
+// after each and every browseRefresh, until a key quits or keys is closed.
+func (b *browser) loop(ctx context.Context, keys <-chan string, draw func()) error {
+	ticker := time.NewTicker(browseRefresh)
+	defer ticker.Stop()
+	draw()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case k, ok := <-keys:
+			if !ok || b.key(ctx, k) {
+				return nil
+			}
+		case l := <-b.loaded:
+			b.show(l)
+		case <-ticker.C:
+		}
+		draw()
+	}
+}
+
+func runBrowse(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("browse", flag.ContinueOnError)
+	dir := fs.String("dir", ".", "`directory` downloads are saved in")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: browse [flags] [prefix]\n       browse [flags] <remote>:[prefix]\n\nBrowses the container in the terminal. Keys:\n  %s\n\nFlags:\n", browseHelp)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("browse takes at most one prefix")
+	}
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(in) || !term.IsTerminal(out) {
+		return errors.New("browse needs a terminal; scripts can use list, stat, download and upload")
+	}
+	az, prefix, err := az.resolveRemote(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	b := newBrowser(az, *dir)
+	// The first listing is loaded before taking over the terminal, so
+	// that a failure to sign in or list is reported as usual.
+	first := b.list(ctx, b.loading, prefix)
+	if first.err != nil {
+		return first.err
+	}
+	b.show(first)
+
+	input, err := openTerminalInput(in)
+	if err != nil {
+		return err
+	}
+	state, err := term.MakeRaw(in)
+	if err != nil {
+		input.Close()
+		return err
+	}
+	// The alternate screen keeps the shell's scrollback as it was.
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	// Progress bars and log lines would garble the screen, which shows
+	// progress itself and the latest log line as the status.
+	log.SetOutput(b)
+	bars := setBarWriter(ioutil.Discard)
+	ctx, cancel := context.WithCancel(ctx)
+	keys, done := make(chan string), make(chan struct{})
+	go readKeys(input, keys, done)
+	err = b.loop(ctx, keys, func() {
+		width, height, err := term.GetSize(out)
+		if err != nil {
+			width, height = 80, 24
+		}
+		b.render(os.Stdout, width, height)
+	})
+	cancel()
+	close(done)
+	input.Close()
+	b.wg.Wait()
+	setBarWriter(bars)
+	log.SetOutput(os.Stderr)
+	fmt.Print("\x1b[?25h\x1b[?1049l")
+	term.Restore(in, state)
+
+	results := b.results()
+	printManifestResults(az.Messages, results)
+	if err != nil {
+		return err
+	}
+	return manifestError(results)
+}
@@ -0,0 +1,150 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseKeys(t *testing.T) {
+	got := parseKeys([]byte("j\x1b[A\x1bOB\x1b[5~\r\x7f\x03é\x1b\x1b[99X\x01q"))
+	want := []string{"j", "up", "down", "pgup", "enter", "backspace", "ctrl-c", "é", "esc", "q"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseKeys = %q, want %q", got, want)
+	}
+}
+
+func TestReadKeysStops(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	input, err := openTerminalInput(int(r.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, done := make(chan string), make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		readKeys(input, keys, done)
+		close(stopped)
+	}()
+	w.WriteString("j")
+	if k := <-keys; k != "j" {
+		t.Errorf("read %q", k)
+	}
+	// readKeys is now blocked reading the next key.
+	close(done)
+	input.Close()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("readKeys did not stop")
+	}
+}
+
+func TestSanitizeTerminal(t *testing.T) {
+	if got, want := sanitizeTerminal("a\x1b[2Jb\r\n\u009bc\xffé"), "a\uFFFD[2Jb\uFFFD\uFFFD\uFFFDc\uFFFDé"; got != want {
+		t.Errorf("sanitizeTerminal = %q, want %q", got, want)
+	}
+}
+
+func TestBrowseEntries(t *testing.T) {
+	blobs := []*BlobProperties{{Name: "logs/", Size: 0}, {Name: "logs/a.txt", Size: 1}}
+	entries := browseEntries("logs/", []string{"logs/b/"}, blobs)
+	if len(entries) != 2 || entries[0].Name != "b/" || !entries[0].Dir || entries[1].Name != "a.txt" || entries[1].Dir || entries[1].Props != blobs[1] {
+		t.Errorf("browseEntries = %+v", entries)
+	}
+	for prefix, want := range map[string]string{"logs/b/d/": "logs/b/", "logs/": "", "": ""} {
+		if got := parentPrefix(prefix); got != want {
+			t.Errorf("parentPrefix(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+}
+
+func TestBrowser(t *testing.T) {
+	m := newMemContainer()
+	m.put("logs/a.txt", []byte("a"), map[string]string{"build": "7"})
+	m.put("logs/b/c.txt", []byte("c"), nil)
+	m.put("top.txt", []byte("top"), nil)
+	az := newTestClient(t, m)
+	ctx := context.Background()
+	dir := t.TempDir()
+	b := newBrowser(az, dir)
+	b.show(b.list(ctx, 0, ""))
+	// load shows the listing the last key asked for.
+	load := func() {
+		t.Helper()
+		l := <-b.loaded
+		if l.err != nil {
+			t.Fatal(l.err)
+		}
+		b.show(l)
+	}
+	keys := func(keys ...string) {
+		t.Helper()
+		for _, k := range keys {
+			if b.key(ctx, k) {
+				t.Fatalf("%q quit", k)
+			}
+		}
+	}
+
+	keys("enter")
+	load()
+	if b.prefix != "logs/" || len(b.entries) != 2 {
+		t.Fatalf("after opening logs/: prefix %q, entries %+v", b.prefix, b.entries)
+	}
+	keys("down", "i")
+	if !strings.Contains(strings.Join(b.details, "\n"), "Metadata:      Build=7") {
+		t.Errorf("details = %q", b.details)
+	}
+	keys("up", "d")
+	b.wg.Wait()
+	if readFile(t, filepath.Join(dir, "b", "c.txt")) != "c" {
+		t.Error("the directory was not downloaded")
+	}
+
+	local := writeFile(t, filepath.Join(t.TempDir(), "new.txt"), "new")
+	keys("u")
+	keys(strings.Split(local+"x", "")...)
+	keys("backspace", "enter")
+	b.wg.Wait()
+	if blob, ok := m.blobs["logs/new.txt"]; !ok || string(blob.data) != "new" {
+		t.Error("the file was not uploaded into the prefix")
+	}
+
+	var screen bytes.Buffer
+	b.render(&screen, 120, 30)
+	for _, want := range []string{"account/container/logs/", "> b/", "Transfers (2):", "upload " + local + " -> logs/new.txt: ok"} {
+		if !strings.Contains(screen.String(), want) {
+			t.Errorf("the screen lacks %q:\n%s", want, screen.String())
+		}
+	}
+
+	b.entries = append(b.entries, browseEntry{Name: "evil\x1b[2J", Props: &BlobProperties{}})
+	b.details = []string{"Metadata:      Note=\x1b]0;title\x07"}
+	screen.Reset()
+	b.render(&screen, 120, 30)
+	if strings.Contains(screen.String(), "\x1b[2J") || strings.Contains(screen.String(), "\x1b]") {
+		t.Errorf("the screen holds control sequences of names and metadata:\n%q", screen.String())
+	}
+
+	keys("left")
+	load()
+	if b.prefix != "" || len(b.entries) != 2 {
+		t.Errorf("after going up: prefix %q, entries %+v", b.prefix, b.entries)
+	}
+	if !b.key(ctx, "q") {
+		t.Error("q did not quit")
+	}
+	if err := manifestError(b.results()); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,80 @@
+package azureblob
+
+import (
+	"io"
+	"sync"
+)
+
+// copyBufferSize is the size of the buffers that stream blobs between
+// readers and writers, larger than io.Copy's so fewer reads reach the
+// network or disk.
+const copyBufferSize = 256 << 10
+
+// bufferClasses are the sizes of the buffers pooled, in ascending order:
+// those of tail reads, copies, hashing, pages and blocks, and resumable
+// chunks.
+var bufferClasses = [...]int{tailReadSize, copyBufferSize, hashBufferSize, pageChunkSize, defaultResumeChunkSize}
+
+// bufferPools holds a pool of *[]byte per class of bufferClasses. Blocks,
+// chunks and pages are staged in buffers of these few sizes, so buffers
+// freed by one block are reused by the next instead of each block
+// allocating megabytes for the garbage collector to reclaim. A pool keeps
+// its buffers only until a collection finds them unused, so the buffers of
+// a finished transfer do not stay resident. Other sizes, such as the
+// blocks of uploads too large for the default block size, are allocated
+// directly, so that every size asked for does not get a pool of its own.
+var bufferPools [len(bufferClasses)]sync.Pool
+
+// bufferClass returns the index of the class a buffer of size bytes is
+// taken from: the smallest that holds it, if that wastes at most half of
+// it. It returns -1 for a size allocated directly.
+func bufferClass(size int) int {
+	for i, class := range bufferClasses {
+		if size <= class {
+			if size > class/2 {
+				return i
+			}
+			return -1
+		}
+	}
+	return -1
+}
+
+// getBuffer returns a buffer of size bytes, reused if one was put back.
+// Its content is whatever it last held. Callers return it with putBuffer
+// once nothing refers to it any more, including requests sent from it.
+func getBuffer(size int) *[]byte {
+	i := bufferClass(size)
+	if i < 0 {
+		b := make([]byte, size)
+		return &b
+	}
+	b, ok := bufferPools[i].Get().(*[]byte)
+	if !ok {
+		buf := make([]byte, bufferClasses[i])
+		b = &buf
+	}
+	*b = (*b)[:size]
+	return b
+}
+
+// putBuffer makes b, which getBuffer returned, available for reuse if it
+// was taken from a pool.
+func putBuffer(b *[]byte) {
+	*b = (*b)[:cap(*b)]
+	for i, class := range bufferClasses {
+		if len(*b) == class {
+			bufferPools[i].Put(b)
+			return
+		}
+	}
+}
+
+// copyPooled copies src to dst like io.Copy, through a pooled buffer. dst
+// is wrapped so that the ReadFrom of files, which would allocate a buffer of
+// its own for network sources, is not used.
+func copyPooled(dst io.Writer, src io.Reader) (int64, error) {
+	buf := getBuffer(copyBufferSize)
+	defer putBuffer(buf)
+	return io.CopyBuffer(struct{ io.Writer }{dst}, src, *buf)
+}
@@ -0,0 +1,42 @@
+package azureblob
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBufferPool(t *testing.T) {
+	b := getBuffer(copyBufferSize)
+	if len(*b) != copyBufferSize {
+		t.Fatalf("got a buffer of %d bytes, want %d", len(*b), copyBufferSize)
+	}
+	// A buffer put back trimmed is handed out again at the size asked for.
+	*b = (*b)[:10]
+	putBuffer(b)
+	for _, size := range []int{copyBufferSize, copyBufferSize - 1, copyBufferSize/2 + 1} {
+		b := getBuffer(size)
+		if len(*b) != size || cap(*b) != copyBufferSize {
+			t.Fatalf("got a buffer of %d bytes of %d for %d", len(*b), cap(*b), size)
+		}
+		putBuffer(b)
+	}
+	// Sizes that would waste most of a pooled buffer, and those larger
+	// than any, are allocated as they are.
+	for _, size := range []int{10, copyBufferSize / 2, defaultResumeChunkSize + 1} {
+		b := getBuffer(size)
+		if len(*b) != size || cap(*b) != size {
+			t.Errorf("got a buffer of %d bytes of %d for %d", len(*b), cap(*b), size)
+		}
+		putBuffer(b)
+	}
+}
+
+func TestCopyPooled(t *testing.T) {
+	src := strings.Repeat("pooled ", 100000)
+	var dst bytes.Buffer
+	n, err := copyPooled(&dst, strings.NewReader(src))
+	if err != nil || n != int64(len(src)) || dst.String() != src {
+		t.Errorf("copied %d bytes, %v", n, err)
+	}
+}
@@ -0,0 +1,148 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+// buildkitePluginPrefix prefixes the variables Buildkite sets from the
+// configuration of this repository used as a plugin, e.g.
+// BUILDKITE_PLUGIN_BK_AZUREBLOB_DOWNLOAD_0 for the first entry of download.
+const buildkitePluginPrefix = "BUILDKITE_PLUGIN_BK_AZUREBLOB_"
+
+// buildkiteItems reads the list option key of the plugin configuration.
+// Each entry is either a string, the blob name relative to the prefix and
+// the local path at once, or an object with blob, path, sha256, md5,
+// account and container keys, whose blob and path default to each other. A
+// single string may be given instead of a list.
+func buildkiteItems(lookup func(string) (string, bool), key string) ([]ManifestItem, error) {
+	base := buildkitePluginPrefix + key
+	if v, ok := lookup(base); ok {
+		return []ManifestItem{{Blob: v, Path: v}}, nil
+	}
+	var items []ManifestItem
+	for i := 0; ; i++ {
+		entry := base + "_" + strconv.Itoa(i)
+		if v, ok := lookup(entry); ok {
+			items = append(items, ManifestItem{Blob: v, Path: v})
+			continue
+		}
+		var item ManifestItem
+		found := false
+		for _, field := range []struct {
+			name string
+			dst  *string
+		}{
+			{"BLOB", &item.Blob}, {"PATH", &item.Path}, {"SHA256", &item.SHA256}, {"MD5", &item.MD5},
+			{"ACCOUNT", &item.Account}, {"CONTAINER", &item.Container}, {"BACKEND", &item.Backend},
+		} {
+			if v, ok := lookup(entry + "_" + field.name); ok {
+				*field.dst, found = v, true
+			}
+		}
+		if !found {
+			return items, nil
+		}
+		if item.Blob == "" {
+			item.Blob = item.Path
+		}
+		if item.Path == "" {
+			item.Path = item.Blob
+		}
+		if item.Blob == "" {
+			return nil, fmt.Errorf("%s needs a blob or a path", entry)
+		}
+		if err := item.checkBackend(); err != nil {
+			return nil, fmt.Errorf("%s: %w", entry, err)
+		}
+		items = append(items, item)
+	}
+}
+
+// buildkiteManifest returns the transfers of the hook phase: the downloads
+// before the command runs and the uploads after it, including the files
+// matching the artifacts globs. Blob names are joined to the prefix option
+// and local paths resolved against the checkout. symlinks is
+// ClientOptions.Symlinks, applied to the artifacts.
+func buildkiteManifest(msgs Messages, symlinks string, lookup func(string) (string, bool), phase string) (*Manifest, error) {
+	key := map[string]string{"pre-command": "DOWNLOAD", "post-command": "UPLOAD"}[phase]
+	if key == "" {
+		return nil, fmt.Errorf("unsupported Buildkite hook %q, want pre-command or post-command", phase)
+	}
+	items, err := buildkiteItems(lookup, key)
+	if err != nil {
+		return nil, err
+	}
+	prefix, _ := lookup(buildkitePluginPrefix + "PREFIX")
+	dir, ok := lookup("BUILDKITE_BUILD_CHECKOUT_PATH")
+	if !ok {
+		if dir, err = os.Getwd(); err != nil {
+			return nil, err
+		}
+	}
+	for i := range items {
+		item := &items[i]
+		item.Blob = path.Join(prefix, item.Blob)
+		if !filepath.IsAbs(item.Path) {
+			item.Path = filepath.Join(dir, filepath.FromSlash(item.Path))
+		}
+	}
+	if key == "DOWNLOAD" {
+		return &Manifest{Downloads: items}, nil
+	}
+	if globs, ok := lookup(buildkitePluginPrefix + "ARTIFACTS"); ok {
+		job, err := artifactPrefix(lookup)
+		if err != nil {
+			return nil, err
+		}
+		artifacts, err := artifactItems(msgs, dir, globs, path.Join(prefix, job), symlinks)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, artifacts...)
+	}
+	return &Manifest{Uploads: items}, nil
+}
+
+// runBuildkiteHook lets the binary serve as the pre-command and post-command
+// hooks of a Buildkite plugin, transferring what the plugin configuration
+// lists. Settings such as the storage account are read from the plugin
+// configuration by applyEnv. The dry-run option of the plugin, like the
+// -dry-run flag, prints the transfers instead of performing them.
+func runBuildkiteHook(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("buildkite-hook", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "print what would be transferred and its size without transferring")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: buildkite-hook [flags] <pre-command|post-command>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("buildkite-hook takes the hook name")
+	}
+	m, err := buildkiteManifest(az.Messages, az.clientOptions().Symlinks, os.LookupEnv, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(m.Downloads)+len(m.Uploads) == 0 {
+		return nil
+	}
+	if v, ok := os.LookupEnv(buildkitePluginPrefix + "DRY_RUN"); ok && !*dryRun {
+		if *dryRun, err = strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("%sDRY_RUN: %w", buildkitePluginPrefix, err)
+		}
+	}
+	if *dryRun {
+		return planTransfers(ctx, az, m)
+	}
+	return runTransfers(ctx, az, m)
+}
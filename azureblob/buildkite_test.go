@@ -0,0 +1,160 @@
+package azureblob
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mapLookup(env map[string]string) func(string) (string, bool) {
+	return func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+}
+
+func TestBuildkiteManifest(t *testing.T) {
+	env := map[string]string{
+		"BUILDKITE_BUILD_CHECKOUT_PATH":                    "/checkout",
+		"BUILDKITE_PLUGIN_BK_AZUREBLOB_PREFIX":             "builds/42",
+		"BUILDKITE_PLUGIN_BK_AZUREBLOB_DOWNLOAD_0":         "deps.tar",
+		"BUILDKITE_PLUGIN_BK_AZUREBLOB_DOWNLOAD_1_BLOB":    "tools/lint",
+		"BUILDKITE_PLUGIN_BK_AZUREBLOB_DOWNLOAD_1_PATH":    "/usr/local/bin/lint",
+		"BUILDKITE_PLUGIN_BK_AZUREBLOB_DOWNLOAD_1_SHA256":  "abc",
+		"BUILDKITE_PLUGIN_BK_AZUREBLOB_DOWNLOAD_1_BACKEND": "azure://other/tools",
+		"BUILDKITE_PLUGIN_BK_AZUREBLOB_DOWNLOAD_2_PATH":    "out/report.txt",
+		"BUILDKITE_PLUGIN_BK_AZUREBLOB_DOWNLOAD_4":         "after a gap",
+		"BUILDKITE_PLUGIN_BK_AZUREBLOB_UPLOAD":             "dist/app",
+	}
+	m, err := buildkiteManifest(nil, "", mapLookup(env), "pre-command")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Manifest{Downloads: []ManifestItem{
+		{Blob: "builds/42/deps.tar", Path: filepath.Join("/checkout", "deps.tar")},
+		{Blob: "builds/42/tools/lint", Path: "/usr/local/bin/lint", SHA256: "abc", Backend: "azure://other/tools"},
+		{Blob: "builds/42/out/report.txt", Path: filepath.Join("/checkout", "out", "report.txt")},
+	}}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("pre-command manifest = %+v, want %+v", m, want)
+	}
+	m, err = buildkiteManifest(nil, "", mapLookup(env), "post-command")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = &Manifest{Uploads: []ManifestItem{{Blob: "builds/42/dist/app", Path: filepath.Join("/checkout", "dist", "app")}}}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("post-command manifest = %+v, want %+v", m, want)
+	}
+}
+
+func TestBuildkiteManifestArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "dist", "app.pkg"), "pkg")
+	env := map[string]string{
+		"BUILDKITE_BUILD_CHECKOUT_PATH":           dir,
+		"BUILDKITE_PIPELINE_SLUG":                 "app",
+		"BUILDKITE_BUILD_ID":                      "build",
+		"BUILDKITE_JOB_ID":                        "job",
+		"BUILDKITE_PLUGIN_BK_AZUREBLOB_PREFIX":    "ci",
+		"BUILDKITE_PLUGIN_BK_AZUREBLOB_ARTIFACTS": "dist/*.pkg",
+	}
+	m, err := buildkiteManifest(nil, "", mapLookup(env), "post-command")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ManifestItem{{Blob: "ci/app/build/job/dist/app.pkg", Path: filepath.Join(dir, "dist", "app.pkg")}}
+	if !reflect.DeepEqual(m.Uploads, want) {
+		t.Errorf("uploads = %+v, want %+v", m.Uploads, want)
+	}
+}
+
+func TestBuildkiteManifestErrors(t *testing.T) {
+	if _, err := buildkiteManifest(nil, "", mapLookup(nil), "pre-exit"); err == nil || !strings.Contains(err.Error(), "pre-exit") {
+		t.Errorf("unknown hook: got %v", err)
+	}
+	env := map[string]string{"BUILDKITE_PLUGIN_BK_AZUREBLOB_UPLOAD_0_MD5": "abc"}
+	if _, err := buildkiteManifest(nil, "", mapLookup(env), "post-command"); err == nil || !strings.Contains(err.Error(), "needs a blob or a path") {
+		t.Errorf("entry without blob or path: got %v", err)
+	}
+	env = map[string]string{
+		"BUILDKITE_PLUGIN_BK_AZUREBLOB_UPLOAD_0_BLOB":      "a",
+		"BUILDKITE_PLUGIN_BK_AZUREBLOB_UPLOAD_0_BACKEND":   "azure://other/tools",
+		"BUILDKITE_PLUGIN_BK_AZUREBLOB_UPLOAD_0_CONTAINER": "tools",
+	}
+	if _, err := buildkiteManifest(nil, "", mapLookup(env), "post-command"); err == nil || !strings.Contains(err.Error(), "both a backend") {
+		t.Errorf("entry with a backend and a container: got %v", err)
+	}
+}
+
+func TestRunBuildkiteHook(t *testing.T) {
+	m := newMemContainer()
+	m.put("ci/input", []byte("input"), nil)
+	az := newTestClient(t, m)
+	dir := t.TempDir()
+	t.Setenv("BUILDKITE_BUILD_CHECKOUT_PATH", dir)
+	t.Setenv("BUILDKITE_PLUGIN_BK_AZUREBLOB_PREFIX", "ci")
+	t.Setenv("BUILDKITE_PLUGIN_BK_AZUREBLOB_DOWNLOAD", "input")
+	t.Setenv("BUILDKITE_PLUGIN_BK_AZUREBLOB_UPLOAD_0_BLOB", "output")
+	t.Setenv("BUILDKITE_PLUGIN_BK_AZUREBLOB_UPLOAD_0_PATH", "build/out")
+	writeFile(t, filepath.Join(dir, "build", "out"), "output")
+
+	ctx := context.Background()
+	if err := runBuildkiteHook(ctx, az, []string{"pre-command"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "input")); string(got) != "input" {
+		t.Errorf("pre-command downloaded %q", got)
+	}
+	if b := m.blobs["ci/output"]; b != nil {
+		t.Error("pre-command uploaded")
+	}
+	if err := runBuildkiteHook(ctx, az, []string{"post-command"}); err != nil {
+		t.Fatal(err)
+	}
+	if b := m.blobs["ci/output"]; b == nil || string(b.data) != "output" {
+		t.Error("post-command did not upload ci/output")
+	}
+
+	t.Setenv("BUILDKITE_PLUGIN_BK_AZUREBLOB_DOWNLOAD", "missing")
+	if err := runBuildkiteHook(ctx, az, []string{"pre-command"}); err == nil || err.Error() != "1 of 1 transfers failed" {
+		t.Errorf("missing download: got %v", err)
+	}
+}
+
+func TestRunBuildkiteHookNothingToDo(t *testing.T) {
+	az := newTestClient(t, newMemContainer())
+	t.Setenv("BUILDKITE_BUILD_CHECKOUT_PATH", t.TempDir())
+	if err := runBuildkiteHook(context.Background(), az, []string{"post-command"}); err != nil {
+		t.Errorf("got %v, want nil without uploads configured", err)
+	}
+}
+
+func TestRunBuildkiteHookDryRun(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	dir := t.TempDir()
+	t.Setenv("BUILDKITE_BUILD_CHECKOUT_PATH", dir)
+	t.Setenv("BUILDKITE_PLUGIN_BK_AZUREBLOB_UPLOAD", "out")
+	t.Setenv("BUILDKITE_PLUGIN_BK_AZUREBLOB_DRY_RUN", "true")
+	writeFile(t, filepath.Join(dir, "out"), "output")
+
+	var err error
+	out := captureStdout(t, func() { err = runBuildkiteHook(context.Background(), az, []string{"post-command"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "would upload "+filepath.Join(dir, "out")+" -> out: 6 B") {
+		t.Errorf("output:\n%s", out)
+	}
+	if len(m.blobs) != 0 {
+		t.Error("dry-run option uploaded")
+	}
+	t.Setenv("BUILDKITE_PLUGIN_BK_AZUREBLOB_DRY_RUN", "maybe")
+	if err := runBuildkiteHook(context.Background(), az, []string{"post-command"}); err == nil {
+		t.Error("invalid dry-run option was accepted")
+	}
+}
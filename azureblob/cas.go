@@ -0,0 +1,97 @@
+package azureblob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// defaultCASPrefix is the prefix content-addressed blobs are stored under
+// unless another is given.
+const defaultCASPrefix = "cas"
+
+// casBlob returns the name of the blob holding the content with the hex
+// SHA-256 digest under prefix.
+func casBlob(prefix, digest string) string {
+	return path.Join(prefix, "sha256", digest)
+}
+
+// UploadCAS uploads file to the blob named by its SHA-256 digest,
+// <prefix>/sha256/<digest>, unless that blob already exists. It returns the
+// blob's name and whether it was uploaded. Identical files thus share one
+// blob, and the name always refers to the same content. The digest is of
+// the file as it is, before any client-side compression or encryption, so
+// a download can be checked against the name it was fetched by.
+func (c *AzureBlobClient) UploadCAS(ctx context.Context, file *os.File, prefix string) (string, bool, error) {
+	h := sha256.New()
+	if _, err := copyPooled(h, io.NewSectionReader(file, 0, 1<<63-1)); err != nil {
+		return "", false, err
+	}
+	blob := casBlob(prefix, hex.EncodeToString(h.Sum(nil)))
+	if _, err := c.Stat(ctx, blob); err == nil {
+		return blob, false, nil
+	} else if !isNotFound(err) {
+		return "", false, err
+	}
+	if _, err := c.Upload(ctx, file, blob); err != nil {
+		return "", false, err
+	}
+	return blob, true, nil
+}
+
+// uploadAllCAS uploads files by content concurrently, bounded by az.Pool,
+// printing the blob each is stored as. All files are attempted even if some
+// fail.
+func uploadAllCAS(ctx context.Context, az *AzureBlobClient, files []string, prefix string) error {
+	errs := az.Pool.Run(ctx, len(files), func(ctx context.Context, i int) error {
+		f, err := os.Open(files[i])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		blob, uploaded, err := az.UploadCAS(ctx, f, prefix)
+		if err != nil {
+			return err
+		}
+		msg := MsgCASExists
+		if uploaded {
+			msg = MsgCASUploaded
+		}
+		fmt.Println(az.Messages.format(msg, files[i], blob))
+		return nil
+	})
+	failures := &transferFailures{total: len(files), noun: "uploads"}
+	for _, err := range errs {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failures.errs = append(failures.errs, err)
+		}
+	}
+	if len(failures.errs) > 0 {
+		return failures
+	}
+	return nil
+}
+
+func runCASUpload(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("cas-upload", flag.ContinueOnError)
+	prefix := fs.String("prefix", defaultCASPrefix, "store blobs as `prefix`/sha256/<digest>")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: cas-upload [-prefix <prefix>] <file>...\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("cas-upload takes one or more files")
+	}
+	return uploadAllCAS(ctx, az, fs.Args(), *prefix)
+}
@@ -0,0 +1,89 @@
+package azureblob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUploadCAS(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	dir := t.TempDir()
+	sum := sha256.Sum256([]byte("same content"))
+	want := "cas/sha256/" + hex.EncodeToString(sum[:])
+	ctx := context.Background()
+
+	blob, uploaded, err := az.UploadCAS(ctx, openFile(t, writeFile(t, filepath.Join(dir, "a"), "same content")), defaultCASPrefix)
+	if err != nil || blob != want || !uploaded {
+		t.Fatalf("UploadCAS = %q, %v, %v; want %q uploaded", blob, uploaded, err, want)
+	}
+	if got := string(m.blobs[want].data); got != "same content" {
+		t.Errorf("stored %q", got)
+	}
+	etag := m.blobs[want].etag
+
+	blob, uploaded, err = az.UploadCAS(ctx, openFile(t, writeFile(t, filepath.Join(dir, "b"), "same content")), defaultCASPrefix)
+	if err != nil || blob != want || uploaded {
+		t.Errorf("second UploadCAS = %q, %v, %v; want the existing %q", blob, uploaded, err, want)
+	}
+	if m.blobs[want].etag != etag {
+		t.Error("identical content was uploaded again")
+	}
+
+	blob, _, err = az.UploadCAS(ctx, openFile(t, writeFile(t, filepath.Join(dir, "c"), "other")), "store/")
+	if err != nil || !strings.HasPrefix(blob, "store/sha256/") || m.blobs[blob] == nil {
+		t.Errorf("UploadCAS under another prefix = %q, %v", blob, err)
+	}
+}
+
+func TestUploadCASEncrypted(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	az.ClientOptions.EncryptUploads = true
+	az.Keys = mustKeyRing(t, "k1", map[string][]byte{"k1": testKey(1)})
+	blob, _, err := az.UploadCAS(context.Background(), openFile(t, writeFile(t, filepath.Join(t.TempDir(), "a"), "secret")), defaultCASPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The name is the digest of the plaintext, which a download recovers.
+	dest := filepath.Join(t.TempDir(), "out")
+	if _, err := az.Download(context.Background(), blob, dest); err != nil {
+		t.Fatal(err)
+	}
+	sums, err := fileDigests(dest, []string{"sha256"})
+	digest := sums["sha256"]
+	if err != nil || blob != casBlob(defaultCASPrefix, digest) {
+		t.Errorf("%s holds content with digest %s, %v", blob, digest, err)
+	}
+}
+
+func TestRunCASUpload(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	dir := t.TempDir()
+	a := writeFile(t, filepath.Join(dir, "a"), "one")
+	b := writeFile(t, filepath.Join(dir, "b"), "one")
+	var err error
+	out := captureStdout(t, func() {
+		err = runCASUpload(context.Background(), az, []string{a})
+	})
+	if err != nil || !strings.Contains(out, "uploaded as cas/sha256/") {
+		t.Errorf("cas-upload printed %q, %v", out, err)
+	}
+	out = captureStdout(t, func() {
+		err = runCASUpload(context.Background(), az, []string{b, filepath.Join(dir, "missing")})
+	})
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 uploads failed") || !strings.Contains(out, "already stored as") {
+		t.Errorf("cas-upload printed %q, %v", out, err)
+	}
+	if len(m.blobs) != 1 {
+		t.Errorf("stored %d blobs, want 1", len(m.blobs))
+	}
+	if err := runCASUpload(context.Background(), az, nil); err == nil {
+		t.Error("cas-upload without files succeeded")
+	}
+}
@@ -0,0 +1,68 @@
+package azureblob
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// chainAttempt is the outcome of one member of a credential chain.
+type chainAttempt struct {
+	method AuthMethod
+	err    error
+}
+
+type chainAttemptsKey struct{}
+
+// chainMember records the outcome of each token request of one member of a
+// credential chain with the reportingChain that made it.
+type chainMember struct {
+	method AuthMethod
+	cred   azcore.TokenCredential
+}
+
+func (m chainMember) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (*azcore.AccessToken, error) {
+	token, err := m.cred.GetToken(ctx, opts)
+	if attempts, ok := ctx.Value(chainAttemptsKey{}).(*[]chainAttempt); ok {
+		*attempts = append(*attempts, chainAttempt{m.method, err})
+	}
+	return token, err
+}
+
+// reportingChain wraps a chain of chainMembers and logs which member
+// produced its token, after the failures of the members tried before it,
+// whenever that changes: on the first token, and when a member stops
+// working and a later one takes over. A failure of the whole chain is left
+// to its error.
+type reportingChain struct {
+	chain    azcore.TokenCredential
+	messages Messages
+
+	mu   sync.Mutex
+	last AuthMethod
+}
+
+func (r *reportingChain) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (*azcore.AccessToken, error) {
+	// Members are tried one after the other, so the attempts of one
+	// request need no lock.
+	var attempts []chainAttempt
+	token, err := r.chain.GetToken(context.WithValue(ctx, chainAttemptsKey{}, &attempts), opts)
+	if err != nil || len(attempts) == 0 {
+		return token, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	succeeded := attempts[len(attempts)-1].method
+	if succeeded == r.last {
+		return token, nil
+	}
+	r.last = succeeded
+	for _, a := range attempts[:len(attempts)-1] {
+		log.Print(r.messages.format(MsgAuthMemberFailed, a.method, a.err))
+	}
+	log.Print(r.messages.format(MsgAuthTokenFrom, succeeded))
+	return token, nil
+}
@@ -0,0 +1,67 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// toggleCredential gets a token unless unavailable is set.
+type toggleCredential struct {
+	unavailable bool
+}
+
+func (c *toggleCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (*azcore.AccessToken, error) {
+	if c.unavailable {
+		return nil, credentialUnavailable{}
+	}
+	return &azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestReportingChainLogsSucceedingMember(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	mi, cli := &toggleCredential{unavailable: true}, &toggleCredential{}
+	chain, err := azidentity.NewChainedTokenCredential([]azcore.TokenCredential{
+		chainMember{AuthManagedIdentity, mi},
+		chainMember{AuthAzureCLI, cli},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &reportingChain{chain: chain}
+	get := func() string {
+		t.Helper()
+		buf.Reset()
+		if _, err := r.GetToken(context.Background(), policy.TokenRequestOptions{Scopes: []string{storageScope}}); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+	out := get()
+	if !strings.Contains(out, "auth: managed-identity failed: no credential") || !strings.Contains(out, "auth: token from azure-cli") {
+		t.Errorf("first token logged %q", out)
+	}
+	if out := get(); out != "" {
+		t.Errorf("token from the same member logged %q", out)
+	}
+	mi.unavailable = false
+	if out := get(); !strings.Contains(out, "auth: token from managed-identity") || strings.Contains(out, "failed") {
+		t.Errorf("change of member logged %q", out)
+	}
+
+	mi.unavailable, cli.unavailable = true, true
+	buf.Reset()
+	if _, err := r.GetToken(context.Background(), policy.TokenRequestOptions{}); err == nil {
+		t.Error("chain without a working member got a token")
+	}
+}
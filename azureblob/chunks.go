@@ -0,0 +1,246 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+const (
+	// defaultChunkRetries is how many times a failed block or range is
+	// sent again on its own before its transfer fails.
+	defaultChunkRetries = 3
+	// chunkParallelism is how many blocks or ranges of one transfer are in
+	// flight at once, as in the SDK's transfers. The pool's block slots
+	// bound them across transfers.
+	chunkParallelism = 5
+)
+
+// singleShotUploadMax is the size up to which a file is uploaded in a
+// single request rather than in blocks.
+var singleShotUploadMax int64 = azblob.BlockBlobMaxUploadBlobBytes
+
+func (o *AzureBlobClientOptions) chunkRetries() int {
+	switch {
+	case o.ChunkRetries < 0:
+		return 0
+	case o.ChunkRetries == 0:
+		return defaultChunkRetries
+	}
+	return o.ChunkRetries
+}
+
+// uploadBlockSize returns the block size of an upload of size bytes: 4 MiB,
+// or more if that would take more blocks than a blob may have, as the SDK
+// chooses.
+func uploadBlockSize(size int64) int64 {
+	if bs := (size + azblob.BlockBlobMaxBlocks - 1) / azblob.BlockBlobMaxBlocks; bs > azblob.BlobDefaultDownloadBlockSize {
+		return bs
+	}
+	return azblob.BlobDefaultDownloadBlockSize
+}
+
+// ChunkRetry records a block of an upload or a range of a download that
+// failed and was sent again on its own.
+type ChunkRetry struct {
+	Offset  int64 `json:"offset"`
+	Length  int64 `json:"length"`
+	Retries int   `json:"retries"`
+}
+
+// retryableChunk reports whether a chunk that failed with err may succeed
+// if sent again: the connection failed, or the service timed out, shed
+// load or failed itself. Chunks of a cancelled transfer, rejected requests,
+// local file errors, a spent retry budget and an expired SAS are not
+// retried.
+func retryableChunk(ctx context.Context, err error) bool {
+	var pathErr *fs.PathError
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.As(err, &pathErr) {
+		return false
+	}
+	var be *BlobError
+	if !errors.As(newBlobError("", "", err), &be) || errors.Is(be.Err, ErrRetryBudgetExhausted) || errors.Is(be.Err, ErrSASExpired) {
+		return false
+	}
+	switch {
+	case be.StatusCode == 0, be.StatusCode == http.StatusRequestTimeout, be.StatusCode == http.StatusTooManyRequests:
+		return true
+	}
+	return be.StatusCode >= 500
+}
+
+// describeChunkError returns err as MsgChunkRetry shows it: the status and
+// error code the service answered with, or else err itself.
+func describeChunkError(err error) string {
+	var be *BlobError
+	if errors.As(newBlobError("", "", err), &be) && be.StatusCode != 0 {
+		if be.ErrorCode != "" {
+			return fmt.Sprintf("HTTP %d %s", be.StatusCode, be.ErrorCode)
+		}
+		return fmt.Sprintf("HTTP %d", be.StatusCode)
+	}
+	return err.Error()
+}
+
+// transferChunks calls send for every chunk of chunkSize bytes of an op of
+// name of size bytes, chunkParallelism at a time, reporting the bytes sent
+// so far to progress unless it is nil. send reports the bytes of its chunk
+// sent so far to sent. A chunk that fails for a reason retryableChunk
+// accepts is sent again on its own, up to ChunkRetries times, while the
+// others carry on, and send must start it over. Once a chunk fails for
+// good the others are cancelled, and its error is returned.
+func (c *AzureBlobClient) transferChunks(ctx context.Context, op, name string, size, chunkSize int64, progress func(int64), send func(ctx context.Context, offset, count int64, sent func(int64)) error) error {
+	chunks := int((size + chunkSize - 1) / chunkSize)
+	retries := c.clientOptions().chunkRetries()
+	stats := transferStatsFrom(ctx)
+	noun := "range"
+	if op == "upload" {
+		noun = "block"
+	}
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		total    int64
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	work := make(chan int)
+	workers := chunkParallelism
+	if workers > chunks {
+		workers = chunks
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				offset := int64(i) * chunkSize
+				count := chunkSize
+				if offset+count > size {
+					count = size - offset
+				}
+				// counted is how much of the chunk has been reported, so a
+				// retry reports only what its attempts before did not.
+				var counted int64
+				sent := func(n int64) {
+					mu.Lock()
+					defer mu.Unlock()
+					if n > counted {
+						total += n - counted
+						counted = n
+						if progress != nil {
+							progress(total)
+						}
+					}
+				}
+				var err error
+				for retry := 1; ; retry++ {
+					err = send(ctx, offset, count, sent)
+					if err == nil || retry > retries || !retryableChunk(ctx, err) {
+						break
+					}
+					log.Print(c.Messages.format(MsgChunkRetry, op, name, noun, offset, retry, retries, describeChunkError(err)))
+					stats.chunkRetried(offset, count)
+				}
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+						cancel()
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+dispatch:
+	for i := 0; i < chunks; i++ {
+		select {
+		case work <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(work)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return parent.Err()
+}
+
+// fetchChunks downloads the size bytes of blob, as of etag, to w in ranges
+// retried on their own.
+func (c *AzureBlobClient) fetchChunks(ctx context.Context, blob azblob.BlobClient, asset, etag string, size int64, w io.WriterAt, progress func(int64)) error {
+	return c.transferChunks(ctx, "download", asset, size, azblob.BlobDefaultDownloadBlockSize, progress, func(ctx context.Context, offset, count int64, sent func(int64)) error {
+		body, err := c.openRange(ctx, blob, etag, offset, count)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		n, err := copyPooled(&sectionWriter{w: w, offset: offset, sent: sent}, io.LimitReader(body, count))
+		if err == nil && n != count {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	})
+}
+
+// sectionWriter writes sequentially to w from offset, reporting the bytes
+// written so far to sent.
+type sectionWriter struct {
+	w       io.WriterAt
+	offset  int64
+	written int64
+	sent    func(int64)
+}
+
+func (s *sectionWriter) Write(p []byte) (int, error) {
+	n, err := s.w.WriteAt(p, s.offset+s.written)
+	s.written += int64(n)
+	s.sent(s.written)
+	return n, err
+}
+
+// stageAndCommit stages file of size bytes as the blocks of blob, each
+// retried on its own, and commits them with opts. It returns the response
+// of the commit.
+func (c *AzureBlobClient) stageAndCommit(ctx context.Context, blob azblob.BlockBlobClient, blobPath string, file *os.File, size int64, opts *azblob.CommitBlockListOptions, progress func(int64)) (*http.Response, error) {
+	upload, err := newUploadID()
+	if err != nil {
+		return nil, err
+	}
+	blockSize := uploadBlockSize(size)
+	ids := make([]string, (size+blockSize-1)/blockSize)
+	for i := range ids {
+		ids[i] = blockID(upload, i)
+	}
+	err = c.transferChunks(ctx, "upload", blobPath, size, blockSize, progress, func(ctx context.Context, offset, count int64, sent func(int64)) error {
+		pooled := getBuffer(int(count))
+		defer putBuffer(pooled)
+		buf := (*pooled)[:count]
+		if _, err := file.ReadAt(buf, offset); err != nil {
+			return err
+		}
+		body := streaming.NewRequestProgress(streaming.NopCloser(bytes.NewReader(buf)), sent)
+		_, err := blob.StageBlock(ctx, ids[offset/blockSize], body, &azblob.StageBlockOptions{CpkScopeInfo: opts.CpkScopeInfo})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := blob.CommitBlockList(ctx, ids, opts)
+	return resp.RawResponse, err
+}
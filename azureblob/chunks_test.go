@@ -0,0 +1,164 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// flakyChunks fails the requests for some ranges or blocks of m's blobs
+// with status, fails[key] times each, and counts the requests for each.
+// Ranges are keyed by their x-ms-range header, and blocks by their index.
+type flakyChunks struct {
+	m      *memContainer
+	status int
+
+	mu       sync.Mutex
+	fails    map[string]int
+	requests map[string]int
+}
+
+func (h *flakyChunks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var key string
+	switch q := r.URL.Query(); {
+	case r.Method == http.MethodGet && r.Header.Get("x-ms-range") != "":
+		key = r.Header.Get("x-ms-range")
+	case r.Method == http.MethodPut && q.Get("comp") == "block":
+		id, _ := base64.StdEncoding.DecodeString(q.Get("blockid"))
+		i, _ := strconv.Atoi(string(id[len(id)-8:]))
+		key = fmt.Sprintf("block %d", i)
+	}
+	h.mu.Lock()
+	h.requests[key]++
+	fail := h.fails[key] > 0
+	if fail {
+		h.fails[key]--
+	}
+	h.mu.Unlock()
+	if fail {
+		w.Header().Set("x-ms-error-code", "ServerBusy")
+		w.WriteHeader(h.status)
+		return
+	}
+	h.m.ServeHTTP(w, r)
+}
+
+func chunkRange(offset, count int64) string {
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+count-1)
+}
+
+func TestDownloadRetriesFailedRanges(t *testing.T) {
+	m := newMemContainer()
+	data := bytes.Repeat([]byte("0123456789abcdef"), 10<<20/16)
+	m.put("image.vhd", data, nil)
+	const mib = 1 << 20
+	h := &flakyChunks{m: m, status: http.StatusServiceUnavailable, fails: map[string]int{chunkRange(4*mib, 4*mib): 2}, requests: map[string]int{}}
+	az := newTestClient(t, h)
+	dest := filepath.Join(t.TempDir(), "image.vhd")
+
+	result, err := az.Download(context.Background(), "image.vhd", dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(dest); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("downloaded %d bytes, %v", len(got), err)
+	}
+	want := []ChunkRetry{{Offset: 4 * mib, Length: 4 * mib, Retries: 2}}
+	if !reflect.DeepEqual(result.ChunkRetries, want) || result.Retries != 2 {
+		t.Errorf("ChunkRetries %+v, Retries %d; want %+v, 2", result.ChunkRetries, result.Retries, want)
+	}
+	wantRequests := map[string]int{"": 1, chunkRange(0, 4*mib): 1, chunkRange(4*mib, 4*mib): 3, chunkRange(8*mib, 2*mib): 1}
+	if !reflect.DeepEqual(h.requests, wantRequests) {
+		t.Errorf("requests %v, want %v", h.requests, wantRequests)
+	}
+}
+
+func TestDownloadChunkRetryLimits(t *testing.T) {
+	const mib = 1 << 20
+	for _, tc := range []struct {
+		name     string
+		status   int
+		retries  int
+		requests int
+	}{
+		{"exhausted", http.StatusInternalServerError, 2, 3},
+		{"disabled", http.StatusInternalServerError, -1, 1},
+		{"rejected", http.StatusForbidden, 3, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMemContainer()
+			m.put("image.vhd", make([]byte, 6*mib), nil)
+			h := &flakyChunks{m: m, status: tc.status, fails: map[string]int{chunkRange(4*mib, 2*mib): 10}, requests: map[string]int{}}
+			az := newTestClient(t, h)
+			az.ClientOptions.ChunkRetries = tc.retries
+			_, err := az.Download(context.Background(), "image.vhd", filepath.Join(t.TempDir(), "image.vhd"))
+			var be *BlobError
+			if !errors.As(err, &be) || be.StatusCode != tc.status {
+				t.Errorf("Download = %v", err)
+			}
+			if n := h.requests[chunkRange(4*mib, 2*mib)]; n != tc.requests {
+				t.Errorf("%d requests for the failing range, want %d", n, tc.requests)
+			}
+		})
+	}
+}
+
+func TestUploadRetriesFailedBlocks(t *testing.T) {
+	defer func(max int64) { singleShotUploadMax = max }(singleShotUploadMax)
+	singleShotUploadMax = 1 << 20
+	m := newMemContainer()
+	h := &flakyChunks{m: m, status: http.StatusServiceUnavailable, fails: map[string]int{"block 1": 1}, requests: map[string]int{}}
+	az := newTestClient(t, h)
+	az.ClientOptions.PreserveAttributes = true
+	data := bytes.Repeat([]byte("disk image "), 9<<20/11)
+	f, err := os.Open(writeFile(t, filepath.Join(t.TempDir(), "disk.img"), string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	result, err := az.Upload(context.Background(), f, "disk.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := m.blobs["disk.img"]
+	if b == nil || !bytes.Equal(b.data, data) || len(b.blockIDs) != 3 {
+		t.Fatalf("uploaded blob %+v", b)
+	}
+	if b.metadata[mtimeMetadataKey] == "" {
+		t.Errorf("metadata %v lacks the preserved attributes", b.metadata)
+	}
+	want := []ChunkRetry{{Offset: 4 << 20, Length: 4 << 20, Retries: 1}}
+	if !reflect.DeepEqual(result.ChunkRetries, want) || result.Retries != 1 || result.Bytes != int64(len(data)) {
+		t.Errorf("result %+v", result)
+	}
+	for key, want := range map[string]int{"block 0": 1, "block 1": 2, "block 2": 1} {
+		if h.requests[key] != want {
+			t.Errorf("%d requests for %s, want %d", h.requests[key], key, want)
+		}
+	}
+}
+
+func TestUploadBlockSize(t *testing.T) {
+	for size, want := range map[int64]int64{
+		1:                    4 << 20,
+		100 << 30:            4 << 20,
+		50000 * (4 << 20):    4 << 20,
+		50000*(4<<20) + 1:    4<<20 + 1,
+		50000 * (100 << 20):  100 << 20,
+		50000*(100<<20) - 17: 100 << 20,
+	} {
+		if got := uploadBlockSize(size); got != want {
+			t.Errorf("uploadBlockSize(%d) = %d, want %d", size, got, want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package azureblob
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// CI systems whose log markup the output uses, as -ci names them.
+const (
+	ciAuto      = "auto"
+	ciNone      = "none"
+	ciBuildkite = "buildkite"
+	ciGitHub    = "github"
+)
+
+// ciProgressInterval is how often multi-file commands report combined
+// progress in CI logs, which keep every line.
+const ciProgressInterval = 10 * time.Second
+
+// ciSystem is the CI system the output is formatted for, which main sets
+// from -ci.
+var ciSystem = ciNone
+
+// detectCI returns the CI system the process runs in, judging by the
+// variables its agent sets, or ciNone.
+func detectCI(lookup func(string) (string, bool)) string {
+	if v, _ := lookup("BUILDKITE"); v == "true" {
+		return ciBuildkite
+	}
+	if v, _ := lookup("GITHUB_ACTIONS"); v == "true" {
+		return ciGitHub
+	}
+	return ciNone
+}
+
+// parseCI returns the CI system -ci selects, detecting it for "auto".
+func parseCI(s string, lookup func(string) (string, bool)) (string, error) {
+	switch s {
+	case "", ciAuto:
+		return detectCI(lookup), nil
+	case ciNone, ciBuildkite, ciGitHub:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown CI system %q; use auto, buildkite, github or none", s)
+}
+
+// setCI formats the output for system: progress bars become timestamped
+// lines and combined progress is reported less often.
+func setCI(system string) {
+	ciSystem = system
+	stdoutBars.lines = system != ciNone
+	if system != ciNone {
+		progressInterval = ciProgressInterval
+	}
+}
+
+// startGroup begins a collapsed log group titled title, in the markup of
+// system. Without a CI system it writes nothing.
+func startGroup(w io.Writer, system, title string) {
+	switch system {
+	case ciBuildkite:
+		fmt.Fprintf(w, "--- %s\n", title)
+	case ciGitHub:
+		fmt.Fprintf(w, "::group::%s\n", githubEscape(title))
+	}
+}
+
+// endGroup ends the group startGroup began. Buildkite groups end where the
+// next one starts.
+func endGroup(w io.Writer, system string) {
+	if system == ciGitHub {
+		fmt.Fprintln(w, "::endgroup::")
+	}
+}
+
+// reportGitHubFailure writes err to w as GitHub Actions error annotations,
+// one per failed transfer, each with its request ID and suggested fix.
+func reportGitHubFailure(w io.Writer, msgs Messages, err error) {
+	failures := []error{err}
+	var tf *transferFailures
+	if errors.As(err, &tf) {
+		failures = tf.errs
+	}
+	title := msgs.format(MsgFailureHeading, err)
+	for _, f := range failures {
+		fmt.Fprintf(w, "::error title=%s::%s\n", githubEscapeProperty(title), githubEscape(strings.Join(failureDetails(msgs, f), "\n")))
+	}
+}
+
+// githubEscape escapes the message of a GitHub Actions workflow command.
+func githubEscape(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// githubEscapeProperty escapes a property of a workflow command, which
+// additionally may not contain its separators.
+func githubEscapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
@@ -0,0 +1,59 @@
+package azureblob
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDetectCI(t *testing.T) {
+	for env, want := range map[string]string{
+		"":               ciNone,
+		"BUILDKITE":      ciBuildkite,
+		"GITHUB_ACTIONS": ciGitHub,
+	} {
+		lookup := mapLookup(map[string]string{env: "true"})
+		if got := detectCI(lookup); got != want {
+			t.Errorf("with %s set: %q, want %q", env, got, want)
+		}
+		if got, err := parseCI(ciAuto, lookup); err != nil || got != want {
+			t.Errorf("parseCI(auto) with %s set: %q, %v", env, got, err)
+		}
+	}
+	if got, err := parseCI(ciNone, mapLookup(map[string]string{"BUILDKITE": "true"})); err != nil || got != ciNone {
+		t.Errorf("parseCI(none) = %q, %v", got, err)
+	}
+	if _, err := parseCI("jenkins", mapLookup(nil)); err == nil {
+		t.Error("parsed an unknown CI system")
+	}
+}
+
+func TestLogGroups(t *testing.T) {
+	for system, want := range map[string]string{
+		ciNone:      "output\n",
+		ciBuildkite: "--- bk_azureblob download a\noutput\n",
+		ciGitHub:    "::group::bk_azureblob download a\noutput\n::endgroup::\n",
+	} {
+		var out strings.Builder
+		startGroup(&out, system, "bk_azureblob download a")
+		out.WriteString("output\n")
+		endGroup(&out, system)
+		if out.String() != want {
+			t.Errorf("%s: %q, want %q", system, out.String(), want)
+		}
+	}
+}
+
+func TestReportGitHubFailure(t *testing.T) {
+	err := manifestError([]ManifestResult{
+		{Direction: "download", err: &BlobError{Op: "download", Blob: "a", StatusCode: 404, ErrorCode: "BlobNotFound", RequestID: "req-1"}},
+		{Direction: "upload", err: errors.New("100% disk full")},
+	})
+	var out strings.Builder
+	reportGitHubFailure(&out, nil, err)
+	want := "::error title=bk_azureblob failed%3A 2 of 2 transfers failed::download \"a\": HTTP 404 BlobNotFound (x-ms-request-id req-1)%0Arequest ID: req-1%0Asuggested fix: " + githubEscape(defaultMessages[MsgFixNotFound].format) + "\n" +
+		"::error title=bk_azureblob failed%3A 2 of 2 transfers failed::100%25 disk full\n"
+	if out.String() != want {
+		t.Errorf("annotations:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
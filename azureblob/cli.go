@@ -0,0 +1,736 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Main runs the bk_azureblob command line on the arguments of the process
+// and exits with its exit code on failure. builtin holds the built-in
+// defaults of the binary, which profiles and flags override.
+func Main(builtin Profile) {
+	configPath := flag.String("config", "", "configuration `file` defining profiles (default ~/.config/bk_azureblob/config.yaml)")
+	profileName := flag.String("profile", "", "profile of the configuration file to use (default: its default profile)")
+	flagProfile := Profile{}
+	flag.StringVar(&flagProfile.TenantID, "tenant-id", "", "Azure AD tenant ID")
+	flag.StringVar(&flagProfile.AdditionallyAllowedTenants, "additionally-allowed-tenants", "", "comma-separated `tenants` besides -tenant-id that storage accounts may be homed in, or * for any")
+	flag.StringVar(&flagProfile.ClientID, "client-id", "", "application (client) ID to authenticate as")
+	flag.StringVar(&flagProfile.StorageAccount, "storage-account", "", "storage account name")
+	flag.StringVar(&flagProfile.Container, "container", "", "container name")
+	flag.StringVar(&flagProfile.EncryptionScope, "encryption-scope", "", "server-side encryption scope of uploaded blobs (default: the account's)")
+	flag.StringVar(&flagProfile.ManagedIdentityID, "managed-identity-id", "", "client ID or resource ID of the user-assigned managed identity to use (default: the environment's)")
+	flag.StringVar(&flagProfile.Credential, "credential", "", "credential mode, "+credentialDefault+" or "+credentialInteractive+" (default "+credentialDefault+")")
+	tokenCache := flag.String("token-cache", tokenCacheMemory, "where tokens are cached: "+tokenCacheMemory+" (for the life of the process) or "+tokenCacheKeychain+" (the OS keychain, shared by later runs)")
+	deviceCodeTimeout := flag.Duration("device-code-timeout", defaultDeviceCodeTimeout, "how long to wait for a device code sign-in before failing; 0 waits until the code expires")
+	proxyURL := flag.String("proxy", "", "http://, https:// or socks5:// proxy URL (default: HTTP_PROXY/HTTPS_PROXY/NO_PROXY)")
+	caBundle := flag.String("ca-bundle", "", "PEM file of additional root CAs to trust")
+	tlsMinVersion := flag.String("tls-min-version", "", "minimum TLS version, 1.2 or 1.3 (default 1.2)")
+	headers := headerFlag{}
+	flag.Var(headers, "header", "`Name: value` header added to every blob request (repeatable)")
+	query := queryFlag{}
+	flag.Var(query, "query", "`key=value` query parameter added to every blob request (repeatable)")
+	metadataTimeout := flag.Duration("metadata-timeout", defaultMetadataTimeout, "time limit for a metadata operation such as stat, including retries")
+	metadataRetries := flag.Int("metadata-retries", int(defaultMetadataRetry.MaxRetries), "retries of a failed metadata request")
+	transferRetries := flag.Int("transfer-retries", 3, "retries of a failed upload or download request")
+	chunkRetries := flag.Int("chunk-retries", defaultChunkRetries, "times a block or range that still fails after -transfer-retries is sent again on its own")
+	maxTransfers := flag.Int("max-transfers", defaultMaxTransfers, "maximum number of files transferred at once by multi-file operations")
+	maxBlocks := flag.Int("max-blocks", defaultMaxBlocks, "maximum number of block requests in flight across all transfers")
+	limitRate := flag.String("limit-rate", "", "cap transfer throughput, e.g. 10MB/s or 512k")
+	minThroughput := flag.String("min-throughput", "", "restart transfers slower than this rate, e.g. 100KB/s")
+	throughputGrace := flag.Duration("throughput-grace", defaultThroughputGrace, "time allowed on top of a transfer's size at -min-throughput")
+	keks := kekFlag{}
+	flag.Var(keks, "kek", "`id=file` or id=env:NAME key encryption key for client-side encryption, 32 raw or base64 bytes (repeatable)")
+	kekCurrent := flag.String("kek-current", "", "ID of the key encryption key content keys are wrapped under by -encrypt and rewrap (default: the only -kek)")
+	encrypt := flag.Bool("encrypt", false, "encrypt uploads client-side under the current -kek")
+	compress := flag.String("compress", "", "compress uploads with `algorithm` (gzip or zstd); downloads decompress them")
+	contentEncoding := flag.String("content-encoding", contentEncodingAuto, "which downloads to decompress: auto (those made with -compress), decode (also any gzip or zstd Content-Encoding) or raw (none)")
+	preserve := flag.Bool("preserve", false, "record modification times and permission bits on upload and restore them on download")
+	preserveOwner := flag.Bool("preserve-owner", false, "with -preserve, also record and restore the uid and gid")
+	followSymlinks := flag.Bool("follow-symlinks", false, "upload what symlinks in directory uploads point to")
+	preserveSymlinks := flag.Bool("preserve-symlinks", false, "upload symlinks in directory uploads as links, which downloads recreate")
+	preallocate := flag.Bool("preallocate", false, "reserve the disk space of downloads before fetching them, where supported")
+	immutableFor := flag.Duration("immutable-for", 0, "make uploads immutable for `duration`, e.g. 8760h")
+	immutabilityLocked := flag.Bool("immutability-locked", false, "lock the -immutable-for policy of uploads, so it can only be extended")
+	legalHold := flag.Bool("legal-hold", false, "place a legal hold on uploads")
+	skipUnchanged := flag.Bool("skip-unchanged", false, "leave blobs that already have the size and MD5 of the file to upload, reporting them up to date")
+	stateDir := flag.String("state-dir", "", "`directory` of state files named without a directory, such as -state files (default ~/.local/state/bk_azureblob)")
+	dedupIndex := flag.String("dedup-index", "", "`file` recording downloaded files, so identical downloads are hardlinked or cloned instead of fetched again")
+	messagesFile := flag.String("messages", "", "JSON `file` replacing the wording of progress and log messages")
+	tokenScope := flag.String("token-scope", "", "OAuth `scope` of blob tokens, for sovereign clouds, Azure Stack or custom audiences (default "+storageScope+")")
+	ci := flag.String("ci", ciAuto, "format output for a CI `system`: auto (detect it), buildkite, github or none")
+	priority := flag.String("priority", PriorityNormal.String(), "`priority` of the command's block requests against others sharing the pool: critical, normal or background")
+	retryBudget := flag.Int("retry-budget", -1, "retries allowed across all the command's requests before they fail; negative for no budget")
+	hooks := hookFlag{}
+	flag.Var(hooks, "hook", "run a `point=command` hook, or point=go:name for a registered Go hook, at pre-upload, post-upload, pre-download or post-download of every transfer (repeatable)")
+	pathTemplate := flag.String("path-template", "", "name blobs by a `template` such as {pipeline}/{build}/{os}/{arch}/{filename} in upload, download, artifact-upload and diff")
+	pathVars := pathVarFlag{}
+	flag.Var(pathVars, "path-var", "`name=value` of a -path-template placeholder (repeatable)")
+	sas := flag.String("sas", "", "authorize blob requests with this shared access signature `query` of an account or container SAS instead of Azure AD")
+	jobID := flag.String("job", "", "journal the transfers of bulk commands such as manifest and multi-file upload as job `id`, which -resume-job resumes (default a new ID)")
+	resumeJob := flag.String("resume-job", "", "run the transfers of the job `id` that an earlier run did not complete, instead of a command")
+	appID := flag.String("app-id", "", "application ID reported in the User-Agent of every request (default "+defaultApplicationID+")")
+	flag.Usage = func() { printUsage(flag.CommandLine.Output()) }
+	flag.Parse()
+	if err := applyEnv(flag.CommandLine, os.LookupEnv); err != nil {
+		fatal(nil, err)
+	}
+	ciName, err := parseCI(*ci, os.LookupEnv)
+	if err != nil {
+		fatal(nil, err)
+	}
+	setCI(ciName)
+	prio, err := ParsePriority(*priority)
+	if err != nil {
+		fatal(nil, err)
+	}
+	tlsVersion, err := parseTLSVersion(*tlsMinVersion)
+	if err != nil {
+		fatal(nil, err)
+	}
+	keys, err := loadKeyRing(*kekCurrent, keks)
+	if err != nil {
+		fatal(nil, err)
+	}
+	if *encrypt && keys == nil {
+		fatal(nil, errors.New("-encrypt needs a key encryption key, pass -kek"))
+	}
+	compression, err := parseCompression(*compress)
+	if err != nil {
+		fatal(nil, err)
+	}
+	tokenStore, err := parseTokenCache(*tokenCache)
+	if err != nil {
+		fatal(nil, err)
+	}
+	encodingMode, err := parseContentEncoding(*contentEncoding)
+	if err != nil {
+		fatal(nil, err)
+	}
+	if *preserveOwner && !*preserve {
+		fatal(nil, errors.New("-preserve-owner needs -preserve"))
+	}
+	var symlinks string
+	switch {
+	case *followSymlinks && *preserveSymlinks:
+		fatal(nil, errors.New("-follow-symlinks and -preserve-symlinks cannot be combined"))
+	case *followSymlinks:
+		symlinks = symlinksFollow
+	case *preserveSymlinks:
+		symlinks = symlinksPreserve
+	}
+	if *immutabilityLocked && *immutableFor <= 0 {
+		fatal(nil, errors.New("-immutability-locked needs a positive -immutable-for"))
+	}
+	var rate int64
+	if *limitRate != "" {
+		if rate, err = parseByteRate(*limitRate); err != nil {
+			fatal(nil, err)
+		}
+		if rate <= 0 {
+			fatal(nil, fmt.Errorf("-limit-rate must be positive, got %q; omit it for no limit", *limitRate))
+		}
+	}
+	var minRate int64
+	if *minThroughput != "" {
+		if minRate, err = parseByteRate(*minThroughput); err != nil {
+			fatal(nil, err)
+		}
+		if minRate <= 0 {
+			fatal(nil, fmt.Errorf("-min-throughput must be positive, got %q; omit it for no minimum", *minThroughput))
+		}
+	}
+
+	profile, cfg, err := resolveProfile(*configPath, *profileName, builtin, flagProfile)
+	if err != nil {
+		fatal(nil, err)
+	}
+	az, err := profile.client()
+	if err != nil {
+		fatal(nil, err)
+	}
+	az.CredentialOptions.DeviceCodeTimeout = *deviceCodeTimeout
+	az.CredentialOptions.TokenStore = tokenStore
+	az.ClientOptions.ProxyURL = *proxyURL
+	az.ClientOptions.CABundle = *caBundle
+	az.ClientOptions.TLSMinVersion = tlsVersion
+	az.ClientOptions.Headers = http.Header(headers)
+	if len(hooks) > 0 {
+		az.ClientOptions.Hooks = hooks
+	}
+	az.ClientOptions.Query = url.Values(query)
+	if *pathTemplate != "" {
+		if az.ClientOptions.PathTemplate, err = ParsePathTemplate(*pathTemplate, pathVars, os.LookupEnv); err != nil {
+			fatal(nil, err)
+		}
+	}
+	if *sas != "" {
+		if az.ClientOptions.SAS, err = ParseSAS(*sas); err != nil {
+			fatal(nil, err)
+		}
+	}
+	az.ClientOptions.ApplicationID = *appID
+	az.ClientOptions.TokenScope = *tokenScope
+	az.ClientOptions.MetadataTimeout = *metadataTimeout
+	az.ClientOptions.MetadataRetry = defaultMetadataRetry
+	az.ClientOptions.MetadataRetry.MaxRetries = retryCount(*metadataRetries)
+	az.ClientOptions.TransferRetry.MaxRetries = retryCount(*transferRetries)
+	az.ClientOptions.ChunkRetries = int(retryCount(*chunkRetries))
+	az.ClientOptions.LimitRate = rate
+	az.ClientOptions.MinThroughput = minRate
+	az.ClientOptions.ThroughputGrace = *throughputGrace
+	az.ClientOptions.EncryptUploads = *encrypt
+	az.ClientOptions.Compression = compression
+	az.ClientOptions.ContentEncoding = encodingMode
+	az.ClientOptions.PreserveAttributes = *preserve
+	az.ClientOptions.PreserveOwner = *preserveOwner
+	az.ClientOptions.Symlinks = symlinks
+	az.ClientOptions.Preallocate = *preallocate
+	az.ClientOptions.ImmutableFor = *immutableFor
+	az.ClientOptions.ImmutabilityLocked = *immutabilityLocked
+	az.ClientOptions.LegalHold = *legalHold
+	az.ClientOptions.SkipUnchanged = *skipUnchanged
+	az.ClientOptions.StateDir = *stateDir
+	az.ClientOptions.JobID = *jobID
+	if az.ClientOptions.StateDir == "" {
+		az.ClientOptions.StateDir = cfg.StateDir
+	}
+	az.ClientOptions.BootstrapPublicKey = cfg.BootstrapPublicKey
+	az.Pool = NewTransferPool(*maxTransfers, *maxBlocks)
+	az.Keys = keys
+	if *messagesFile != "" {
+		if az.Messages, err = LoadMessages(*messagesFile); err != nil {
+			fatal(az.Messages, err)
+		}
+	}
+	if *dedupIndex != "" {
+		path, err := az.ClientOptions.statePath(*dedupIndex)
+		if err != nil {
+			fatal(az.Messages, err)
+		}
+		if az.Dedup, err = LoadDedupIndex(path); err != nil {
+			fatal(az.Messages, err)
+		}
+	}
+	if len(cfg.Remotes) > 0 {
+		az.Remotes = NewRemotes(az, profile, cfg.Remotes)
+	}
+
+	// Cancelling on a signal lets transfers stop cleanly and the run exit
+	// with exitCancelled.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx = WithPriority(ctx, prio)
+	if *retryBudget >= 0 {
+		ctx = WithRetryBudget(ctx, NewRetryBudget(*retryBudget))
+	}
+	if *resumeJob != "" {
+		if flag.NArg() > 0 {
+			fatal(az.Messages, fmt.Errorf("-resume-job runs job %s and takes no command", *resumeJob))
+		}
+		err := az.resumeJob(ctx, *resumeJob)
+		if saveErr := az.Dedup.Save(); err == nil {
+			err = saveErr
+		}
+		if err != nil {
+			fatal(az.Messages, commandError(ctx, err))
+		}
+		return
+	}
+	if flag.NArg() > 0 {
+		err := runCommand(ctx, az, flag.Arg(0), flag.Args()[1:])
+		if saveErr := az.Dedup.Save(); err == nil {
+			err = saveErr
+		}
+		if err != nil {
+			fatal(az.Messages, commandError(ctx, err))
+		}
+		return
+	}
+	testFileName := "azureblobtest.txt"
+
+	if _, err := az.Download(ctx, testFileName, testFileName); err != nil {
+		fatal(az.Messages, commandError(ctx, err))
+	}
+}
+
+// command is a CLI subcommand. args excludes the subcommand name itself.
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, az *AzureBlobClient, args []string) error
+}
+
+func commands() []command {
+	return []command{
+		{
+			name:    "download",
+			summary: "download a blob to a local file",
+			run:     runDownload,
+		},
+		{
+			name:    "upload",
+			summary: "upload a local file to a blob",
+			run:     runUpload,
+		},
+		{
+			name:    "cas-upload",
+			summary: "upload files under their SHA-256, skipping content already stored",
+			run:     runCASUpload,
+		},
+		{
+			name:    "delta-upload",
+			summary: "update a large blob by sending only the blocks that changed",
+			run:     runDeltaUpload,
+		},
+		{
+			name:    "page-upload",
+			summary: "upload a VHD or other disk image as a sparse page blob",
+			run:     runPageUpload,
+		},
+		{
+			name:    "manifest",
+			summary: "download and upload everything listed in a manifest file",
+			run:     runManifest,
+		},
+		{
+			name:    "jobs",
+			summary: "list or discard the journals of unfinished bulk jobs, which -resume-job resumes",
+			run:     runJobs,
+		},
+		{
+			name:    "browse",
+			summary: "browse the container in the terminal, viewing blob properties and queueing transfers",
+			run:     runBrowse,
+		},
+		{
+			name:    "bootstrap",
+			summary: "download a signed manifest and every file it lists, verifying their hashes",
+			run:     runBootstrap,
+		},
+		{
+			name:    "artifact-upload",
+			summary: "upload files matching Buildkite-style globs under the job's prefix",
+			run:     runArtifactUpload,
+		},
+		{
+			name:    "archive",
+			summary: "upload a directory as a streamed tar, or extract one",
+			run:     runArchive,
+		},
+		{
+			name:    "deploy-site",
+			summary: "sync a directory to the static website container with web headers",
+			run:     runDeploySite,
+		},
+		{
+			name:    "tail-to-blob",
+			summary: "stream stdin or a growing file to an append blob",
+			run:     runTailToBlob,
+		},
+		{
+			name:    "watch",
+			summary: "poll a prefix and download new or modified blobs",
+			run:     runWatch,
+		},
+		{
+			name:    "watch-queue",
+			summary: "download blobs as Event Grid delivers their Blob Created events to a queue",
+			run:     runWatchQueue,
+		},
+		{
+			name:    "daemon",
+			summary: "serve transfers to local processes over HTTP with one credential",
+			run:     runDaemon,
+		},
+		{
+			name:    "serve",
+			summary: "serve blobs read-only over plain HTTP to local tools, with ranges and caching",
+			run:     runServe,
+		},
+		{
+			name:    "grpc",
+			summary: "serve the gRPC transfer service for orchestrators",
+			run:     runGRPC,
+		},
+		{
+			name:    "copy",
+			summary: "copy blobs between containers, buckets and remotes, streaming them",
+			run:     runCopy,
+		},
+		{
+			name:    "delete",
+			summary: "delete blobs by name or prefix, with their snapshots",
+			run:     runDelete,
+		},
+		{
+			name:    "restore",
+			summary: "restore a container or prefix to an earlier time with point-in-time restore",
+			run:     runRestore,
+		},
+		{
+			name:    "immutability",
+			summary: "set or clear a time-based immutability policy on blobs",
+			run:     runImmutability,
+		},
+		{
+			name:    "legal-hold",
+			summary: "place or lift a legal hold on blobs",
+			run:     runLegalHold,
+		},
+		{
+			name:    "public-access",
+			summary: "print or set the container's public access level",
+			run:     runPublicAccess,
+		},
+		{
+			name:    "access-policy",
+			summary: "list, create or delete the container's stored access policies",
+			run:     runAccessPolicy,
+		},
+		{
+			name:    "diff",
+			summary: "compare a local directory with a blob prefix without transferring",
+			run:     runDiff,
+		},
+		{
+			name:    "verify",
+			summary: "re-check local files against blob MD5s or manifest digests",
+			run:     runVerify,
+		},
+		{
+			name:    "du",
+			summary: "summarize storage used per prefix directory",
+			run:     runDu,
+		},
+		{
+			name:    "buildkite-hook",
+			summary: "run as a Buildkite plugin pre-command or post-command hook",
+			run:     runBuildkiteHook,
+		},
+		{
+			name:    "list",
+			summary: "list blobs under a prefix with their properties",
+			run:     runList,
+		},
+		{
+			name:    "find",
+			summary: "find blobs by name, size, age, tier, metadata or tags, and delete or download them",
+			run:     runFind,
+		},
+		{
+			name:    "stat",
+			summary: "print the properties of a blob",
+			run:     runStat,
+		},
+		{
+			name:    "query",
+			summary: "filter a CSV or JSON blob with SQL on the service, printing matching records",
+			run:     runQuery,
+		},
+		{
+			name:    "whoami",
+			summary: "show which credential authenticates, as whom, and what it may do",
+			run:     runWhoAmI,
+		},
+		{
+			name:    "auth-probe",
+			summary: "rank the auth methods this environment supports",
+			run:     runAuthProbe,
+		},
+		{
+			name:    "rewrap",
+			summary: "re-wrap content keys of encrypted blobs under the current key",
+			run:     runRewrap,
+		},
+		{
+			name:    "version",
+			summary: "print the version, build metadata and SDK versions",
+			run:     runVersion,
+		},
+		{
+			name:    "examples",
+			summary: "run end-to-end example scenarios against the container",
+			run:     runExamples,
+		},
+	}
+}
+
+// runCommand dispatches to the subcommand called name.
+func runCommand(ctx context.Context, az *AzureBlobClient, name string, args []string) error {
+	switch name {
+	case "help", "-h", "-help", "--help":
+		printUsage(os.Stdout)
+		return nil
+	}
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			startGroup(os.Stdout, ciSystem, strings.TrimSpace("bk_azureblob "+name+" "+strings.Join(args, " ")))
+			err := cmd.run(ctx, az, args)
+			endGroup(os.Stdout, ciSystem)
+			if errors.Is(err, flag.ErrHelp) {
+				return nil
+			}
+			return err
+		}
+	}
+	printUsage(os.Stderr)
+	return fmt.Errorf("unknown command %q", name)
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [global flags] <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "  %-16s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nGlobal flags, which can also be set with %s<NAME> environment variables, e.g. %s:\n", envPrefix, flagEnvName("storage-account"))
+	out := flag.CommandLine.Output()
+	flag.CommandLine.SetOutput(w)
+	flag.PrintDefaults()
+	flag.CommandLine.SetOutput(out)
+}
+
+func runDownload(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("download", flag.ContinueOnError)
+	fallbackAccount := fs.String("fallback-account", "", "storage account to read from when the blob is missing (default: same account)")
+	fallbackContainer := fs.String("fallback-container", "", "container to read from when the blob is missing (default: same container)")
+	state := fs.String("state", "", "download a single blob in resumable chunks, recording progress in `file`")
+	chunkSize := fs.String("chunk-size", "", "chunk size of a new resumable download, e.g. 16MiB (default 8MiB)")
+	dryRun := fs.Bool("dry-run", false, "print what would be downloaded and its size without downloading")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: download [flags] <blob> <destination>\n       download [flags] <blob>... <directory>\n       download -state <file> [-chunk-size <size>] <blob> <destination>\n\nA blob can be named remote:blob to download from a remote of the configuration file.\nWith -path-template, blobs are named by the file names the template completes.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return errors.New("download takes a blob name and a destination")
+	}
+	names := fs.Args()[:fs.NArg()-1]
+	refs := make([]string, len(names))
+	for i, name := range names {
+		var err error
+		if refs[i], err = az.templateBlob(name); err != nil {
+			return err
+		}
+	}
+	az, blobs, err := az.resolveRemotes(refs)
+	if err != nil {
+		return err
+	}
+	// Downloads into a directory are stored under the blob names, or under
+	// the file names a template completes.
+	if az.clientOptions().PathTemplate == nil {
+		names = blobs
+	}
+	if *state != "" {
+		if fs.NArg() != 2 || *fallbackAccount != "" || *fallbackContainer != "" || *dryRun {
+			return errors.New("-state takes a single blob and destination and no fallback or -dry-run")
+		}
+		if err := setResumeChunkSize(az, *chunkSize); err != nil {
+			return err
+		}
+		statePath, err := az.clientOptions().statePath(*state)
+		if err != nil {
+			return err
+		}
+		return az.DownloadResumable(ctx, blobs[0], fs.Arg(1), statePath)
+	}
+	if *chunkSize != "" {
+		return errors.New("-chunk-size needs -state")
+	}
+	if *fallbackAccount != "" || *fallbackContainer != "" {
+		account, container := *fallbackAccount, *fallbackContainer
+		if account == "" {
+			account = az.StorageAccount
+		}
+		if container == "" {
+			container = az.ContainerName
+		}
+		az.WithFallback(account, container)
+	}
+	if fs.NArg() == 2 {
+		if info, err := os.Stat(fs.Arg(1)); err != nil || !info.IsDir() {
+			if *dryRun {
+				return planTransfers(ctx, az, &Manifest{Downloads: []ManifestItem{{Blob: blobs[0], Path: fs.Arg(1)}}})
+			}
+			result, err := az.Download(ctx, blobs[0], fs.Arg(1))
+			if err != nil {
+				return err
+			}
+			az.logTransfer("download", result)
+			return nil
+		}
+	}
+	dir := fs.Arg(fs.NArg() - 1)
+	if *dryRun {
+		if err := checkDir(dir); err != nil {
+			return err
+		}
+		items := make([]ManifestItem, len(blobs))
+		for i, blob := range blobs {
+			items[i] = ManifestItem{Blob: blob, Path: downloadDestination(dir, names[i])}
+		}
+		return planTransfers(ctx, az, &Manifest{Downloads: items})
+	}
+	return downloadAllAs(ctx, az, blobs, names, dir)
+}
+
+// setResumeChunkSize applies the -chunk-size of a resumable transfer, if
+// given, to az.
+func setResumeChunkSize(az *AzureBlobClient, size string) error {
+	if size == "" {
+		return nil
+	}
+	n, err := parseByteSize(size)
+	if err != nil {
+		return err
+	}
+	if n <= 0 {
+		return fmt.Errorf("-chunk-size must be positive, got %q", size)
+	}
+	if az.ClientOptions == nil {
+		az.ClientOptions = &AzureBlobClientOptions{}
+	}
+	az.ClientOptions.ResumeChunkSize = n
+	return nil
+}
+
+// checkDir returns an error unless dir is an existing directory.
+func checkDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
+
+// downloadDestination returns where downloadAll stores blob under dir, at
+// the localName of blob.
+func downloadDestination(dir, blob string) string {
+	return filepath.Join(localRoot(dir), filepath.FromSlash(localName(blob, windowsPaths)))
+}
+
+// progressInterval is how often downloadAll reports combined progress. In
+// CI logs it is ciProgressInterval.
+var progressInterval = time.Second
+
+// downloadAll downloads blobs into dir with DownloadAll. Each blob keeps its
+// path relative to dir. The combined throughput and number of downloads in
+// flight are printed to stderr while it runs.
+func downloadAll(ctx context.Context, az *AzureBlobClient, blobs []string, dir string) error {
+	return downloadAllAs(ctx, az, blobs, blobs, dir)
+}
+
+// downloadAllAs is downloadAll storing each blob under the path of the
+// same index in names instead.
+func downloadAllAs(ctx context.Context, az *AzureBlobClient, blobs, names []string, dir string) error {
+	if err := checkDir(dir); err != nil {
+		return err
+	}
+	downloads := make(map[string]string, len(blobs))
+	for i, blob := range blobs {
+		downloads[blob] = downloadDestination(dir, names[i])
+	}
+	stop := reportProgress(os.Stderr, az.Messages, az.shareProgress(), progressInterval)
+	_, err := az.DownloadAll(withDownloadRoot(ctx, dir), downloads)
+	stop()
+	return err
+}
+
+func runUpload(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("upload", flag.ContinueOnError)
+	state := fs.String("state", "", "upload in resumable blocks, recording the staged blocks in `file`")
+	chunkSize := fs.String("chunk-size", "", "block size of a new resumable upload, e.g. 64MiB (default 8MiB)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: upload [flags] <file> <blob>\n       upload -state <file> [-chunk-size <size>] <file> <blob>\n       upload [flags] <file>...   (with -path-template)\n\nThe blob can be named remote:blob to upload to a remote of the configuration file.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *state == "" && *chunkSize != "" {
+		return errors.New("-chunk-size needs -state")
+	}
+	file, ref := fs.Arg(0), fs.Arg(1)
+	if t := az.clientOptions().PathTemplate; t != nil {
+		if fs.NArg() == 0 {
+			fs.Usage()
+			return errors.New("upload with -path-template takes the files to upload")
+		}
+		if fs.NArg() > 1 {
+			if *state != "" {
+				return errors.New("-state takes a single file")
+			}
+			return uploadTemplated(ctx, az, t, fs.Args())
+		}
+		var err error
+		if ref, err = t.Blob(filepath.Base(file)); err != nil {
+			return err
+		}
+	} else if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("upload takes a file and a blob name")
+	}
+	az, blob, err := az.resolveRemote(ref)
+	if err != nil {
+		return err
+	}
+	if err := setResumeChunkSize(az, *chunkSize); err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if *state != "" {
+		statePath, err := az.clientOptions().statePath(*state)
+		if err != nil {
+			return err
+		}
+		return az.UploadResumable(ctx, f, blob, statePath)
+	}
+	result, err := az.Upload(ctx, f, blob)
+	if err != nil {
+		return err
+	}
+	az.logTransfer("upload", result)
+	return nil
+}
+
+// uploadTemplated uploads files to the blobs t names after their base
+// names, as a batch.
+func uploadTemplated(ctx context.Context, az *AzureBlobClient, t *PathTemplate, files []string) error {
+	refs := make([]string, len(files))
+	for i, file := range files {
+		var err error
+		if refs[i], err = t.Blob(filepath.Base(file)); err != nil {
+			return err
+		}
+	}
+	az, blobs, err := az.resolveRemotes(refs)
+	if err != nil {
+		return err
+	}
+	items := make([]ManifestItem, len(files))
+	for i, file := range files {
+		items[i] = ManifestItem{Blob: blobs[i], Path: file}
+	}
+	return runTransfers(ctx, az, &Manifest{Uploads: items})
+}
@@ -0,0 +1,723 @@
+// Package azureblob is a client for Azure Blob Storage, with the transfers,
+// credentials and test doubles of the bk_azureblob command, which Main runs.
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+type AzureBlobCredentialOptions struct {
+	InteractiveCredential bool
+	// ManagedIdentityID selects a user-assigned managed identity by its
+	// client ID, or by its resource ID, which starts with /subscriptions/.
+	// The environment's default identity is used if it is empty.
+	ManagedIdentityID string
+	// DeviceCodeTimeout limits how long a device code sign-in waits for
+	// somebody to complete it, after which requests fail with
+	// ErrAuthTimedOut. Zero waits until the context is done or the code
+	// expires.
+	DeviceCodeTimeout time.Duration
+	// TokenStore, if set, keeps the tokens of the credential beyond the
+	// process, such as KeychainTokenStore in the OS keychain. Tokens are
+	// only cached in memory otherwise.
+	TokenStore TokenStore
+}
+
+// AzureBlobClient is an abstraction of the various clients needed for Blob downloads
+type AzureBlobClient struct {
+	ClientID       string
+	TenantID       string
+	StorageAccount string
+	ContainerName  string
+	// AdditionallyAllowedTenants are the tenants other than TenantID that
+	// tokens may be requested from, for storage accounts homed in them; "*"
+	// allows any tenant. See WithTenant.
+	AdditionallyAllowedTenants []string
+	// initMu guards lazy initialisation, which concurrent transfers on one
+	// client may race to perform.
+	initMu          sync.Mutex
+	containerClient *azblob.ContainerClient
+	credential      *azcore.TokenCredential
+	// builtCredential is set when init built credential, so a rebuild
+	// replaces it; credentials supplied by the caller are kept.
+	builtCredential bool
+	// generation counts rebuilds; see withRebuild.
+	generation        int
+	CredentialOptions *AzureBlobCredentialOptions
+	ClientOptions     *AzureBlobClientOptions
+	// client is the HTTP client shared by identity and blob requests.
+	client  *http.Client
+	limiter *rateLimiter
+	// Fallback, when set, serves downloads of blobs that are missing from this
+	// client's container. This allows consumers to keep working while blobs are
+	// migrated between containers or storage accounts.
+	Fallback *AzureBlobClient
+	// Progress, when set, receives the progress of every transfer made by
+	// this client. Several clients may share one aggregator.
+	Progress *ProgressAggregator
+	// Pool, when set, bounds the number of concurrent transfers of multi-file
+	// operations and of block requests in flight. Several clients may share
+	// one pool.
+	Pool *TransferPool
+	// Keys holds the KEKs protecting the content keys of client-side
+	// encrypted blobs, which are decrypted on download, and of uploads
+	// encrypted with ClientOptions.EncryptUploads.
+	Keys *KeyRing
+	// Dedup, if set, lets downloads of content already on disk link to the
+	// existing copy instead of fetching it again.
+	Dedup *DedupIndex
+	// Messages replaces the default wording of the messages the client
+	// prints.
+	Messages Messages
+	// Remotes, when set, resolves the remote:path references that commands
+	// accept in place of blob names.
+	Remotes *Remotes
+}
+
+// InitCredential returns a chain of the credentials the environment
+// supports, in the order ranked by the auth probe: workload identity, managed
+// identity and the Azure CLI, then the interactive browser if enabled, with
+// device code as the last resort. The probe results and the chosen chain are
+// logged. Tokens from AdditionallyAllowedTenants come from a chain of the
+// same credentials built for that tenant on first use.
+func (c *AzureBlobClient) InitCredential(ctx context.Context, credOpts *AzureBlobCredentialOptions) (*azcore.TokenCredential, error) {
+	clientOpts, err := c.identityClientOptions()
+	if err != nil {
+		return nil, err
+	}
+	chosen, lines := chooseAuth(probeAuth(ctx, true), credOpts)
+	for _, line := range lines {
+		log.Print(c.Messages.format(MsgAuthProbe, line))
+	}
+	chain, err := c.credentialChain(chosen, c.TenantID, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+	log.Print(c.Messages.format(MsgAuthUsing, describeAuth(chosen)))
+	tokenCred := azcore.TokenCredential(&tenantCredential{
+		home:       chain,
+		homeTenant: c.TenantID,
+		allowed:    c.AdditionallyAllowedTenants,
+		build: func(tenantID string) (azcore.TokenCredential, error) {
+			return c.credentialChain(chosen, tenantID, clientOpts)
+		},
+	})
+	if credOpts != nil && credOpts.TokenStore != nil {
+		tokenCred = &storedCredential{
+			cred:     tokenCred,
+			store:    credOpts.TokenStore,
+			identity: strings.Join([]string{c.TenantID, c.ClientID, credOpts.ManagedIdentityID, describeAuth(chosen)}, "\x00"),
+			messages: c.Messages,
+		}
+	}
+	return &tokenCred, nil
+}
+
+// credentialChain returns a chain of the credentials of methods, signing in
+// to tenantID, that logs which of them got its token.
+func (c *AzureBlobClient) credentialChain(methods []AuthMethod, tenantID string, clientOpts azcore.ClientOptions) (azcore.TokenCredential, error) {
+	credList := []azcore.TokenCredential{}
+	for _, method := range methods {
+		cred, err := c.newCredential(method, tenantID, clientOpts)
+		if err != nil {
+			return nil, err
+		}
+		credList = append(credList, chainMember{method, cred})
+	}
+	chain, err := azidentity.NewChainedTokenCredential(
+		credList,
+		&azidentity.ChainedTokenCredentialOptions{},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &reportingChain{chain: chain, messages: c.Messages}, nil
+}
+
+// newCredential returns the credential for method, signing in to tenantID.
+// Managed identities only get tokens from their own tenant.
+func (c *AzureBlobClient) newCredential(method AuthMethod, tenantID string, clientOpts azcore.ClientOptions) (azcore.TokenCredential, error) {
+	switch method {
+	case AuthWorkloadIdentity:
+		return newWorkloadIdentityCredential(clientOpts), nil
+	case AuthManagedIdentity:
+		return azidentity.NewManagedIdentityCredential(&azidentity.ManagedIdentityCredentialOptions{
+			ClientOptions: clientOpts,
+			ID:            managedIdentityID(c.CredentialOptions),
+		})
+	case AuthAzureCLI:
+		return azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{
+			TenantID: tenantID,
+		})
+	case AuthInteractiveBrowser:
+		return azidentity.NewInteractiveBrowserCredential(&azidentity.InteractiveBrowserCredentialOptions{
+			ClientOptions: clientOpts,
+			TenantID:      tenantID,
+			ClientID:      c.ClientID,
+			RedirectURL:   "http://localhost:9090",
+		})
+	case AuthDeviceCode:
+		// https://github.com/Azure/azure-sdk-for-go/blob/main/sdk/azidentity/device_code_credential.go
+		cred, err := azidentity.NewDeviceCodeCredential(&azidentity.DeviceCodeCredentialOptions{
+			ClientOptions: clientOpts,
+			TenantID:      tenantID,
+			ClientID:      c.ClientID,
+			// Customizes the UserPrompt. Replaces VerificationURL with shortlink.
+			// Providing a custom UserPrompt can also allow the URL to be rewritten anywhere, instead of just stdout
+			UserPrompt: func(ctx context.Context, deviceCodeMessage azidentity.DeviceCodeMessage) error {
+				msg := strings.Replace(deviceCodeMessage.Message, "https://microsoft.com/devicelogin", "https://aka.ms/devicelogin", 1)
+				fmt.Println(msg)
+				return nil
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return deviceCodeWait{cred, deviceCodeTimeout(c.CredentialOptions)}, nil
+	}
+	return nil, fmt.Errorf("unknown auth method %q", method)
+}
+
+// managedIdentityID returns the user-assigned managed identity credOpts
+// select, or nil for the environment's default identity.
+func managedIdentityID(credOpts *AzureBlobCredentialOptions) azidentity.ManagedIDKind {
+	if credOpts == nil || credOpts.ManagedIdentityID == "" {
+		return nil
+	}
+	if strings.HasPrefix(credOpts.ManagedIdentityID, "/") {
+		return azidentity.ResourceID(credOpts.ManagedIdentityID)
+	}
+	return azidentity.ClientID(credOpts.ManagedIdentityID)
+}
+
+// identityClientOptions returns the options of identity requests, which share
+// the HTTP client and application ID of blob requests.
+func (c *AzureBlobClient) identityClientOptions() (azcore.ClientOptions, error) {
+	hc, err := c.httpClient()
+	if err != nil {
+		return azcore.ClientOptions{}, err
+	}
+	telemetry, err := c.clientOptions().telemetry()
+	if err != nil {
+		return azcore.ClientOptions{}, err
+	}
+	return azcore.ClientOptions{Transport: hc, Telemetry: telemetry}, nil
+}
+
+func (c *AzureBlobClient) InitContainerClient(tokenCred *azcore.TokenCredential) (*azblob.ContainerClient, error) {
+	transport, err := c.blobTransporter()
+	if err != nil {
+		return nil, err
+	}
+	telemetry, err := c.clientOptions().telemetry()
+	if err != nil {
+		return nil, err
+	}
+	container, err := azblob.NewContainerClientWithNoCredential(
+		// Construct container url
+		fmt.Sprintf("https://%s.blob.core.windows.net/%s", c.StorageAccount, c.ContainerName),
+		&azblob.ClientOptions{
+			Transporter: transport,
+			Retry:       c.clientOptions().TransferRetry,
+			Telemetry:   telemetry,
+			PerCallOptions: []policy.Policy{
+				countingPolicy{},
+				retryBudgetPolicy{},
+				newRequestExtrasPolicy(c.clientOptions()),
+				c.authPolicy(tokenCred),
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &container, nil
+}
+
+// init sets the container client and creates a context if these aren't already initialized
+func (c *AzureBlobClient) init(ctx context.Context) error {
+	c.initMu.Lock()
+	defer c.initMu.Unlock()
+	if c.containerClient == nil {
+		if c.credential == nil && c.clientOptions().SAS == nil {
+			credential, err := c.InitCredential(ctx, c.CredentialOptions)
+			if err != nil {
+				return err
+			}
+			c.credential = credential
+			c.builtCredential = true
+		}
+		client, err := c.InitContainerClient(c.credential)
+		if err != nil {
+			return err
+		}
+		// save client in c for reuse
+		c.containerClient = client
+	}
+	return nil
+}
+
+// Download downloads a blob to a local file. If AzureBlobDownloader is not yet authenticated, Download will execute authentication flow.
+// The result is nil if the download fails.
+func (c *AzureBlobClient) Download(ctx context.Context, asset, destination string) (*TransferResult, error) {
+	return c.withHooks(ctx, HookPreDownload, HookPostDownload, asset, destination, func() (*TransferResult, error) {
+		ctx, stats := withTransferStats(ctx)
+		start := time.Now()
+		var err error
+		if c.Fallback != nil {
+			err = c.downloadWithFallback(ctx, asset, destination)
+		} else {
+			err = c.downloadRebuilding(ctx, asset, destination)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return stats.result(asset, start), nil
+	})
+}
+
+// downloadRebuilding is download, rebuilding the client once if it has
+// become unusable.
+func (c *AzureBlobClient) downloadRebuilding(ctx context.Context, asset, destination string) error {
+	return c.withRebuild(ctx, "download", asset, func() error {
+		return c.download(ctx, asset, destination)
+	})
+}
+
+func (c *AzureBlobClient) download(ctx context.Context, asset, destination string) error {
+	if err := c.init(ctx); err != nil {
+		return err
+	}
+	props, err := c.stat(ctx, asset)
+	if err != nil {
+		return err
+	}
+	stats := transferStatsFrom(ctx)
+	stats.record(0, props.ETag, props.ContentMD5)
+	if target := metadataValue(props.Metadata, linkTargetMetadataKey); target != "" {
+		return c.restoreSymlink(ctx, asset, destination, target)
+	}
+	data, err := encryptionDataFromMetadata(props.Metadata)
+	if err != nil && !errors.Is(err, errNotEncrypted) {
+		return fmt.Errorf("download %q: %w", asset, err)
+	}
+	o := c.clientOptions()
+	compression := o.downloadCompression(props)
+	key := dedupKey(props, compression)
+	if c.Dedup != nil {
+		// Restoring the attributes of a hardlink would change those of
+		// every copy sharing it.
+		linked, err := c.Dedup.link(key, destination, !o.PreserveAttributes)
+		if err != nil {
+			return err
+		}
+		if linked != "" {
+			log.Print(c.Messages.format(MsgLinkedCopy, asset, linked))
+			return c.finishDownload(props, key, destination)
+		}
+		// destination may be hardlinked to other downloads, which
+		// truncating it would overwrite.
+		if err := os.Remove(destination); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	// A decoded download stages the blob as stored next to its destination,
+	// and its content is at least as large. The file being replaced frees
+	// its space.
+	need := props.Size
+	if data != nil || compression != "" {
+		need *= 2
+	}
+	if err := checkSpace(filepath.Dir(destination), need-regularSize(destination)); err != nil {
+		return fmt.Errorf("download %q: %w", asset, err)
+	}
+	digests := digestRequestFrom(ctx)
+	h, err := digests.hash()
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if data != nil || compression != "" {
+		err = c.downloadDecoded(ctx, asset, props.ETag, props.Size, data, compression, f, h)
+	} else {
+		err = c.fetch(ctx, asset, props.ETag, props.Size, f, h)
+	}
+	if err != nil {
+		return err
+	}
+	digests.answer(h)
+	stats.record(props.Size, props.ETag, props.ContentMD5)
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return c.finishDownload(props, key, destination)
+}
+
+// finishDownload restores the recorded attributes of the blob of props on
+// destination, if asked to, and records destination in the dedup index
+// under key.
+func (c *AzureBlobClient) finishDownload(props *BlobProperties, key, destination string) error {
+	if o := c.clientOptions(); o.PreserveAttributes {
+		if err := restoreAttributes(destination, props.Metadata, o.PreserveOwner); err != nil {
+			return err
+		}
+	}
+	return c.Dedup.add(key, destination)
+}
+
+// fetch downloads the size bytes of asset, as of etag, into f, hashing them
+// into h as they arrive unless h is nil. Ranges that fail are retried on
+// their own; see ClientOptions.ChunkRetries.
+func (c *AzureBlobClient) fetch(ctx context.Context, asset, etag string, size int64, f *os.File, h *multiHash) error {
+	blob := c.containerClient.NewBlobClient(asset)
+	if err := c.allocate(f, size); err != nil {
+		return err
+	}
+	desc := c.Messages.format(MsgDownloading, asset)
+	progbar := newBar(size, desc)
+	tracker := c.beginTransfer(ctx, size)
+	defer tracker.finish()
+	err := c.withTransferDeadline(ctx, "download", asset, size, func(ctx context.Context) error {
+		progress := tracker.wrap(bytesTransferredFn(size, progbar))
+		if h == nil {
+			return c.fetchChunks(ctx, blob, asset, etag, size, f, progress)
+		}
+		// The chunks arrive out of order; hash them in order as they do.
+		h.Reset()
+		hasher := newOrderedHasher(f, h, size)
+		err := c.fetchChunks(ctx, blob, asset, etag, size, hasher, progress)
+		if hashErr := hasher.finish(); err == nil {
+			err = hashErr
+		}
+		return err
+	})
+	if err != nil {
+		return newBlobError("download", asset, err)
+	}
+	stdoutBars.finish(progbar)
+	return nil
+}
+
+// Upload uploads file to blobPath, rebuilding the client once if it has
+// become unusable. The result is nil if the upload fails.
+func (c *AzureBlobClient) Upload(ctx context.Context, file *os.File, blobPath string) (*TransferResult, error) {
+	// The hooks are passed the file's name.
+	if file == nil {
+		return nil, errors.New("file cannot be nil")
+	}
+	return c.withHooks(ctx, HookPreUpload, HookPostUpload, blobPath, file.Name(), func() (*TransferResult, error) {
+		return c.uploadUnhooked(ctx, file, blobPath)
+	})
+}
+
+// uploadUnhooked is Upload without the hooks.
+func (c *AzureBlobClient) uploadUnhooked(ctx context.Context, file *os.File, blobPath string) (*TransferResult, error) {
+	ctx, stats := withTransferStats(ctx)
+	start := time.Now()
+	var sum []byte
+	if c.clientOptions().SkipUnchanged {
+		props, fileMD5, err := c.uploadedAlready(ctx, file, blobPath)
+		if err != nil {
+			return nil, err
+		}
+		if props != nil {
+			return &TransferResult{Blob: blobPath, Duration: time.Since(start), ETag: props.ETag, ContentMD5: props.ContentMD5, UpToDate: true}, nil
+		}
+		sum = fileMD5
+	}
+	err := c.withRebuild(ctx, "upload", blobPath, func() error {
+		return c.upload(ctx, file, blobPath, sum)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats.result(blobPath, start), nil
+}
+
+// upload uploads file to blobPath. A contentMD5 of the file is stored as
+// the blob's Content-MD5 if the file is stored as it is, so that blobs
+// committed from blocks have one too.
+func (c *AzureBlobClient) upload(ctx context.Context, file *os.File, blobPath string, contentMD5 []byte) error {
+	if err := c.init(ctx); err != nil {
+		return err
+	}
+	newBlob := c.containerClient.NewBlockBlobClient(blobPath)
+	if file == nil {
+		return errors.New("file cannot be nil")
+	}
+	var attrs map[string]string
+	if o := c.clientOptions(); o.PreserveAttributes {
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		attrs = fileAttributes(info, o.PreserveOwner)
+	}
+	encoded, metadata, headers, err := c.encodeUpload(ctx, io.NewSectionReader(file, 0, 1<<63-1))
+	if err != nil {
+		return fmt.Errorf("encode %q: %w", blobPath, err)
+	}
+	if attrs != nil {
+		if metadata == nil {
+			metadata = map[string]string{}
+		}
+		for k, v := range attrs {
+			metadata[k] = v
+		}
+	}
+	if encoded == nil && len(contentMD5) > 0 {
+		headers = &azblob.BlobHTTPHeaders{BlobContentMD5: contentMD5}
+	}
+	if encoded != nil {
+		defer encoded.Close()
+		staged, err := spoolTemp(func(w io.Writer) error {
+			_, err := copyPooled(w, encoded)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("encode %q: %w", blobPath, err)
+		}
+		defer os.Remove(staged.Name())
+		defer staged.Close()
+		file = staged
+	}
+	fileStats, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := fileStats.Size()
+	desc := c.Messages.format(MsgUploading, blobPath)
+	progbar := newBar(size, desc)
+	tracker := c.beginTransfer(ctx, size)
+	defer tracker.finish()
+	var resp *http.Response
+	err = c.withTransferDeadline(ctx, "upload", blobPath, size, func(ctx context.Context) error {
+		progress := tracker.wrap(bytesTransferredFn(size, progbar))
+		if size > singleShotUploadMax {
+			var err error
+			resp, err = c.stageAndCommit(ctx, newBlob, blobPath, file, size, &azblob.CommitBlockListOptions{
+				BlobHTTPHeaders: headers,
+				Metadata:        metadata,
+				CpkScopeInfo:    c.clientOptions().cpkScopeInfo(),
+			}, progress)
+			return err
+		}
+		var err error
+		resp, err = newBlob.UploadFileToBlockBlob(ctx, file, azblob.HighLevelUploadToBlockBlobOption{
+			Progress:     progress,
+			HTTPHeaders:  headers,
+			Metadata:     metadata,
+			CpkScopeInfo: c.clientOptions().cpkScopeInfo(),
+		})
+		return err
+	})
+	if err != nil {
+		return newBlobError("upload", blobPath, err)
+	}
+	stdoutBars.finish(progbar)
+	// Larger files are committed from blocks, and the Content-MD5 of the
+	// commit is that of the block list.
+	var sum []byte
+	if size <= singleShotUploadMax {
+		sum = responseMD5(resp)
+	}
+	transferStatsFrom(ctx).record(size, resp.Header.Get("ETag"), sum)
+	return c.protectUpload(ctx, blobPath)
+}
+
+// cpkScopeInfo returns the encryption scope uploads request, or nil for the
+// account's default.
+func (o *AzureBlobClientOptions) cpkScopeInfo() *azblob.CpkScopeInfo {
+	if o.EncryptionScope == "" {
+		return nil
+	}
+	return &azblob.CpkScopeInfo{EncryptionScope: &o.EncryptionScope}
+}
+
+// List returns the properties of the blobs in the container whose names
+// start with prefix. The SDK does not decode metadata in listings, so
+// Metadata is always empty; use Stat or ListWith for it.
+func (c *AzureBlobClient) List(ctx context.Context, prefix string) ([]*BlobProperties, error) {
+	return c.ListWith(ctx, prefix, ListOptions{})
+}
+
+// ListOptions asks ListWith for properties that listings leave out by
+// default.
+type ListOptions struct {
+	// Metadata looks up the metadata of every blob listed, a request per
+	// blob, made concurrently within the client's Pool.
+	Metadata bool
+	// Tags lists the index tags of the blobs, which needs permission to
+	// read tags, such as Storage Blob Data Owner grants.
+	Tags bool
+}
+
+// ListWith is List with the properties that opts asks for.
+func (c *AzureBlobClient) ListWith(ctx context.Context, prefix string, opts ListOptions) ([]*BlobProperties, error) {
+	var blobs []*BlobProperties
+	err := c.withRebuild(ctx, "list", prefix, func() error {
+		var err error
+		blobs, err = c.list(ctx, prefix, opts.Tags)
+		return err
+	})
+	if err != nil || !opts.Metadata {
+		return blobs, err
+	}
+	errs := c.Pool.Run(ctx, len(blobs), func(ctx context.Context, i int) error {
+		props, err := c.Stat(ctx, blobs[i].Name)
+		if err != nil {
+			return err
+		}
+		blobs[i].Metadata = props.Metadata
+		return nil
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return blobs, nil
+}
+
+func (c *AzureBlobClient) list(ctx context.Context, prefix string, tags bool) ([]*BlobProperties, error) {
+	if err := c.init(ctx); err != nil {
+		return nil, err
+	}
+	var (
+		blobs  []*BlobProperties
+		marker *string
+	)
+	for {
+		// The pager returned by ListBlobsFlat drops Prefix when it advances,
+		// so each page is requested with a fresh pager carrying the marker.
+		opts := &azblob.ContainerListBlobFlatSegmentOptions{
+			Prefix: &prefix,
+			Marker: marker,
+		}
+		if tags {
+			opts.Include = []azblob.ListBlobsIncludeItem{azblob.ListBlobsIncludeItemTags}
+		}
+		pager := c.containerClient.ListBlobsFlat(opts)
+		pageCtx, cancel := c.metadataContext(ctx)
+		ok := pager.NextPage(pageCtx)
+		cancel()
+		if !ok {
+			return blobs, newBlobError("list", prefix, pager.Err())
+		}
+		resp := pager.PageResponse()
+		if resp.Segment != nil {
+			for _, item := range resp.Segment.BlobItems {
+				blobs = append(blobs, blobPropertiesFromItem(item))
+			}
+		}
+		marker = resp.NextMarker
+		if marker == nil || *marker == "" {
+			return blobs, nil
+		}
+	}
+}
+
+// listDir lists the blobs directly under prefix, and the prefixes one
+// level below it that hold others, as the service groups names by "/". Unlike
+// List it makes one request per page of what is shown, however many blobs
+// lie deeper.
+func (c *AzureBlobClient) listDir(ctx context.Context, prefix string) ([]string, []*BlobProperties, error) {
+	var (
+		dirs  []string
+		blobs []*BlobProperties
+	)
+	err := c.withRebuild(ctx, "list", prefix, func() error {
+		if err := c.init(ctx); err != nil {
+			return err
+		}
+		dirs, blobs = nil, nil
+		var marker *string
+		for {
+			// As with ListBlobsFlat, the pager drops Prefix when it
+			// advances.
+			pager := c.containerClient.ListBlobsHierarchy("/", &azblob.ContainerListBlobHierarchySegmentOptions{
+				Prefix: &prefix,
+				Marker: marker,
+			})
+			pageCtx, cancel := c.metadataContext(ctx)
+			ok := pager.NextPage(pageCtx)
+			cancel()
+			if !ok {
+				return newBlobError("list", prefix, pager.Err())
+			}
+			resp := pager.PageResponse()
+			if resp.Segment != nil {
+				for _, p := range resp.Segment.BlobPrefixes {
+					if p.Name != nil {
+						dirs = append(dirs, *p.Name)
+					}
+				}
+				for _, item := range resp.Segment.BlobItems {
+					blobs = append(blobs, blobPropertiesFromItem(item))
+				}
+			}
+			marker = resp.NextMarker
+			if marker == nil || *marker == "" {
+				return nil
+			}
+		}
+	})
+	return dirs, blobs, err
+}
+
+// Delete removes a blob and any snapshots it has.
+func (c *AzureBlobClient) Delete(ctx context.Context, blobPath string) error {
+	return c.withRebuild(ctx, "delete", blobPath, func() error {
+		return c.deleteBlob(ctx, blobPath)
+	})
+}
+
+func (c *AzureBlobClient) deleteBlob(ctx context.Context, blobPath string) error {
+	if err := c.init(ctx); err != nil {
+		return err
+	}
+	blob := c.containerClient.NewBlobClient(blobPath)
+	snapshots := azblob.DeleteSnapshotsOptionTypeInclude
+	_, err := blob.Delete(ctx, &azblob.DeleteBlobOptions{
+		DeleteSnapshots: &snapshots,
+	})
+	return newBlobError("delete", blobPath, err)
+}
+
+func NewAzureBlobClientDefault(clientID, tenantID, containerName, storageAccount string) *AzureBlobClient {
+	return &AzureBlobClient{
+		ClientID:       clientID,
+		TenantID:       tenantID,
+		ContainerName:  containerName,
+		StorageAccount: storageAccount,
+		CredentialOptions: &AzureBlobCredentialOptions{
+			InteractiveCredential: false,
+		},
+		ClientOptions: &AzureBlobClientOptions{},
+	}
+}
+
+func NewAzureBlobClientInteractive(clientID, tenantID, containerName, storageAccount string) *AzureBlobClient {
+	client := NewAzureBlobClientDefault(clientID, tenantID, containerName, storageAccount)
+	client.CredentialOptions.InteractiveCredential = true
+	return client
+}
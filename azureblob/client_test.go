@@ -0,0 +1,383 @@
+package azureblob
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// staticCredential hands out a fixed token so tests never reach Azure AD.
+type staticCredential struct{}
+
+func (staticCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (*azcore.AccessToken, error) {
+	return &azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// newTestClient returns a client whose blob requests are served by handler
+// instead of the storage account.
+func newTestClient(t *testing.T, handler http.Handler) *AzureBlobClient {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		req.URL.Scheme = u.Scheme
+		req.URL.Host = u.Host
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+	credential := azcore.TokenCredential(staticCredential{})
+	return &AzureBlobClient{
+		StorageAccount: "account",
+		ContainerName:  "container",
+		credential:     &credential,
+		ClientOptions: &AzureBlobClientOptions{
+			HTTPClient:    hc,
+			TransferRetry: policy.RetryOptions{MaxRetries: -1},
+			MetadataRetry: policy.RetryOptions{MaxRetries: -1},
+			StateDir:      t.TempDir(),
+		},
+	}
+}
+
+// memBlob is a blob held by memContainer.
+type memBlob struct {
+	data            []byte
+	metadata        map[string]string
+	etag            string
+	encryptionScope string
+	contentEncoding string
+	contentType     string
+	cacheControl    string
+	tags            map[string]string
+	// contentMD5 is the x-ms-blob-content-md5 the blob was uploaded with.
+	contentMD5 string
+	// tier and modified are the access tier and last-modified time
+	// listings report; a zero modified lists as the Unix epoch.
+	tier     string
+	modified time.Time
+	// blockIDs and committed are the block list of a blob committed from
+	// blocks, and the content of each block.
+	blockIDs  []string
+	committed map[string][]byte
+}
+
+// memContainer is an in-memory stand-in for a container that serves the
+// requests the client makes: single-shot and block uploads, lists of staged
+// blocks, appends, page writes, properties, ranged downloads, metadata
+// updates, deletes and flat listings. stages, appends and pageWrites count
+// those requests.
+type memContainer struct {
+	mu         sync.Mutex
+	blobs      map[string]*memBlob
+	blocks     map[string]map[string][]byte
+	version    int
+	stages     int
+	appends    int
+	pageWrites int
+}
+
+func newMemContainer() *memContainer {
+	return &memContainer{blobs: map[string]*memBlob{}, blocks: map[string]map[string][]byte{}}
+}
+
+// put stores data as name, returning the new blob.
+func (m *memContainer) put(name string, data []byte, metadata map[string]string) *memBlob {
+	m.version++
+	b := &memBlob{data: data, metadata: metadata, etag: fmt.Sprintf(`"0x%d"`, m.version)}
+	m.blobs[name] = b
+	return b
+}
+
+func requestMetadata(h http.Header) map[string]string {
+	metadata := map[string]string{}
+	for k, v := range h {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-ms-meta-") {
+			metadata[strings.TrimPrefix(lk, "x-ms-meta-")] = v[0]
+		}
+	}
+	return metadata
+}
+
+func (m *memContainer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("x-ms-request-id", "request-1")
+	q := r.URL.Query()
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/container"), "/")
+	notFound := func() {
+		w.Header().Set("x-ms-error-code", "BlobNotFound")
+		w.WriteHeader(http.StatusNotFound)
+	}
+	switch {
+	case q.Get("restype") == "container" && q.Get("comp") == "list":
+		m.list(w, q.Get("prefix"), q.Get("delimiter"), strings.Contains(q.Get("include"), "tags"))
+	case q.Get("restype") == "container":
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPut && q.Get("comp") == "block":
+		body, _ := io.ReadAll(r.Body)
+		if m.blocks[name] == nil {
+			m.blocks[name] = map[string][]byte{}
+		}
+		m.blocks[name][q.Get("blockid")] = body
+		m.stages++
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet && q.Get("comp") == "blocklist":
+		blocks, ok := m.blocks[name]
+		b, exists := m.blobs[name]
+		if !ok && !exists {
+			notFound()
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><BlockList><CommittedBlocks>`)
+		if exists && q.Get("blocklisttype") != "uncommitted" {
+			for _, id := range b.blockIDs {
+				fmt.Fprintf(w, "<Block><Name>%s</Name><Size>%d</Size></Block>", id, len(b.committed[id]))
+			}
+		}
+		fmt.Fprint(w, "</CommittedBlocks><UncommittedBlocks>")
+		if q.Get("blocklisttype") != "committed" {
+			ids := make([]string, 0, len(blocks))
+			for id := range blocks {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			for _, id := range ids {
+				fmt.Fprintf(w, "<Block><Name>%s</Name><Size>%d</Size></Block>", id, len(blocks[id]))
+			}
+		}
+		fmt.Fprint(w, "</UncommittedBlocks></BlockList>")
+	case r.Method == http.MethodPut && q.Get("comp") == "blocklist":
+		body, _ := io.ReadAll(r.Body)
+		var list struct {
+			Latest []string `xml:"Latest"`
+		}
+		xml.Unmarshal(body, &list)
+		var data []byte
+		committed := map[string][]byte{}
+		for _, id := range list.Latest {
+			block, ok := m.blocks[name][id]
+			if !ok && m.blobs[name] != nil {
+				block, ok = m.blobs[name].committed[id]
+			}
+			if !ok {
+				w.Header().Set("x-ms-error-code", "InvalidBlockList")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			data = append(data, block...)
+			committed[id] = block
+		}
+		delete(m.blocks, name)
+		b := m.put(name, data, requestMetadata(r.Header))
+		b.blockIDs, b.committed = list.Latest, committed
+		b.encryptionScope = r.Header.Get("x-ms-encryption-scope")
+		b.contentEncoding = r.Header.Get("x-ms-blob-content-encoding")
+		b.contentType = r.Header.Get("x-ms-blob-content-type")
+		b.cacheControl = r.Header.Get("x-ms-blob-cache-control")
+		b.contentMD5 = r.Header.Get("x-ms-blob-content-md5")
+		w.Header().Set("ETag", b.etag)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && q.Get("comp") == "appendblock":
+		b, ok := m.blobs[name]
+		if !ok {
+			notFound()
+			return
+		}
+		if pos := r.Header.Get("x-ms-blob-condition-appendpos"); pos != "" && pos != strconv.Itoa(len(b.data)) {
+			w.Header().Set("x-ms-error-code", "AppendPositionConditionNotMet")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		b.data = append(b.data, body...)
+		m.appends++
+		m.version++
+		b.etag = fmt.Sprintf(`"0x%d"`, m.version)
+		w.Header().Set("ETag", b.etag)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && q.Get("comp") == "page":
+		b, ok := m.blobs[name]
+		if !ok {
+			notFound()
+			return
+		}
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("x-ms-range"), "bytes=%d-%d", &start, &end); err != nil || end >= len(b.data) {
+			w.Header().Set("x-ms-error-code", "InvalidPageRange")
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		copy(b.data[start:end+1], body)
+		m.pageWrites++
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet && q.Get("comp") == "tags":
+		b, ok := m.blobs[name]
+		if !ok {
+			notFound()
+			return
+		}
+		fmt.Fprintf(w, "<Tags><TagSet>%s</TagSet></Tags>", tagSet(b.tags))
+	case r.Method == http.MethodPut && q.Get("comp") == "metadata":
+		b, ok := m.blobs[name]
+		if !ok {
+			notFound()
+			return
+		}
+		if match := r.Header.Get("If-Match"); match != "" && match != b.etag {
+			w.Header().Set("x-ms-error-code", "ConditionNotMet")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		old := b
+		b = m.put(name, b.data, requestMetadata(r.Header))
+		b.encryptionScope, b.blockIDs, b.committed, b.tags = old.encryptionScope, old.blockIDs, old.committed, old.tags
+		w.Header().Set("ETag", b.etag)
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("x-ms-blob-type") == "PageBlob" {
+			size, _ := strconv.Atoi(r.Header.Get("x-ms-blob-content-length"))
+			body = make([]byte, size)
+		}
+		b := m.put(name, body, requestMetadata(r.Header))
+		b.encryptionScope = r.Header.Get("x-ms-encryption-scope")
+		b.contentEncoding = r.Header.Get("x-ms-blob-content-encoding")
+		b.contentMD5 = r.Header.Get("x-ms-blob-content-md5")
+		sum := md5.Sum(body)
+		w.Header().Set("ETag", b.etag)
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodDelete:
+		if _, ok := m.blobs[name]; !ok {
+			notFound()
+			return
+		}
+		delete(m.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		b, ok := m.blobs[name]
+		if !ok {
+			notFound()
+			return
+		}
+		if match := r.Header.Get("If-Match"); match != "" && match != b.etag {
+			w.Header().Set("x-ms-error-code", "ConditionNotMet")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		for k, v := range b.metadata {
+			w.Header().Set("x-ms-meta-"+k, v)
+		}
+		w.Header().Set("ETag", b.etag)
+		if !b.modified.IsZero() {
+			w.Header().Set("Last-Modified", b.modified.UTC().Format(http.TimeFormat))
+		}
+		if b.encryptionScope != "" {
+			w.Header().Set("x-ms-encryption-scope", b.encryptionScope)
+		}
+		if b.contentEncoding != "" {
+			w.Header().Set("Content-Encoding", b.contentEncoding)
+		}
+		if b.contentType != "" {
+			w.Header().Set("Content-Type", b.contentType)
+		}
+		if b.cacheControl != "" {
+			w.Header().Set("Cache-Control", b.cacheControl)
+		}
+		if r.Method == http.MethodHead {
+			sum := md5.Sum(b.data)
+			w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+		}
+		serveBlob(w, r, b.data)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// list lists the blobs under prefix. With a delimiter, names that have it
+// after the prefix are listed as the BlobPrefix up to it instead.
+func (m *memContainer) list(w http.ResponseWriter, prefix, delimiter string, tags bool) {
+	var names []string
+	prefixes := map[string]bool{}
+	for name := range m.blobs {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if i := strings.Index(name[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			prefixes[name[:len(prefix)+i+len(delimiter)]] = true
+		} else {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults ServiceEndpoint="x" ContainerName="container"><Blobs>`)
+	var dirs []string
+	for p := range prefixes {
+		dirs = append(dirs, p)
+	}
+	sort.Strings(dirs)
+	for _, p := range dirs {
+		fmt.Fprintf(w, "<BlobPrefix><Name>%s</Name></BlobPrefix>", p)
+	}
+	for _, name := range names {
+		b := m.blobs[name]
+		extra := ""
+		if b.encryptionScope != "" {
+			extra = "<EncryptionScope>" + b.encryptionScope + "</EncryptionScope>"
+		}
+		if b.tier != "" {
+			extra += "<AccessTier>" + b.tier + "</AccessTier>"
+		}
+		modified := time.Unix(0, 0)
+		if !b.modified.IsZero() {
+			modified = b.modified
+		}
+		blobTags := ""
+		if tags && len(b.tags) > 0 {
+			blobTags = "<Tags><TagSet>" + tagSet(b.tags) + "</TagSet></Tags>"
+		}
+		fmt.Fprintf(w, "<Blob><Name>%s</Name><Properties><Content-Length>%d</Content-Length><Etag>%s</Etag><Last-Modified>%s</Last-Modified><BlobType>BlockBlob</BlobType>%s</Properties>%s</Blob>", name, len(b.data), b.etag, modified.UTC().Format(http.TimeFormat), extra, blobTags)
+	}
+	fmt.Fprint(w, "</Blobs><NextMarker></NextMarker></EnumerationResults>")
+}
+
+// tagSet returns the Tag elements of tags, sorted by key.
+func tagSet(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "<Tag><Key>%s</Key><Value>%s</Value></Tag>", k, tags[k])
+	}
+	return b.String()
+}
@@ -0,0 +1,210 @@
+package azureblob
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressionMetadataKey is the metadata entry in which uploads record how
+// they were compressed, so that downloads can decompress them again.
+const compressionMetadataKey = "bkcompression"
+
+// Compression algorithms, as recorded in compressionMetadataKey and the
+// blob's Content-Encoding.
+const (
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+)
+
+// parseCompression checks the value of -compress.
+func parseCompression(s string) (string, error) {
+	switch s {
+	case "", compressionGzip, compressionZstd:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown compression %q, want %s or %s", s, compressionGzip, compressionZstd)
+}
+
+// findCompression returns the compression recorded in metadata, or "" for
+// blobs uploaded uncompressed.
+func findCompression(metadata map[string]string) string {
+	return metadataValue(metadata, compressionMetadataKey)
+}
+
+// metadataValue returns the value of the metadata entry key, or "" if there
+// is none. Like findEncryptionData it ignores the case of metadata names,
+// which the service does not preserve.
+func metadataValue(metadata map[string]string, key string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+// Download modes for Content-Encoding; see ClientOptions.ContentEncoding.
+const (
+	contentEncodingAuto   = "auto"
+	contentEncodingDecode = "decode"
+	contentEncodingRaw    = "raw"
+)
+
+// parseContentEncoding checks the value of -content-encoding.
+func parseContentEncoding(s string) (string, error) {
+	switch s {
+	case contentEncodingAuto, contentEncodingDecode, contentEncodingRaw:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown content encoding mode %q, want %s, %s or %s", s, contentEncodingAuto, contentEncodingDecode, contentEncodingRaw)
+}
+
+// downloadCompression returns the compression downloads of the blob with
+// props undo, or "" if they write its bytes as stored.
+func (o *AzureBlobClientOptions) downloadCompression(props *BlobProperties) string {
+	compression := findCompression(props.Metadata)
+	switch o.ContentEncoding {
+	case contentEncodingRaw:
+		return ""
+	case contentEncodingDecode:
+		if compression != "" {
+			return compression
+		}
+		switch strings.ToLower(strings.TrimSpace(props.ContentEncoding)) {
+		case "gzip", "x-gzip":
+			return compressionGzip
+		case "zstd":
+			return compressionZstd
+		}
+	}
+	return compression
+}
+
+// compressReader returns a reader of src compressed with algorithm. The
+// compression runs as src is read; closing the reader stops it.
+func compressReader(src io.Reader, algorithm string) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+	zw, err := compressWriter(pw, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		_, err := copyPooled(zw, src)
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// compressWriter returns a writer compressing with algorithm into dst.
+// Closing it flushes the compressed stream but does not close dst.
+func compressWriter(dst io.Writer, algorithm string) (io.WriteCloser, error) {
+	switch algorithm {
+	case compressionGzip:
+		return gzip.NewWriter(dst), nil
+	case compressionZstd:
+		return zstd.NewWriter(dst)
+	}
+	return nil, fmt.Errorf("unsupported compression %q", algorithm)
+}
+
+// decompressReader returns a reader of the content src holds compressed
+// with algorithm.
+func decompressReader(src io.Reader, algorithm string) (io.ReadCloser, error) {
+	switch algorithm {
+	case compressionGzip:
+		return gzip.NewReader(src)
+	case compressionZstd:
+		zr, err := zstd.NewReader(src)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unsupported compression %q", algorithm)
+}
+
+// encodeUpload returns a reader of src compressed and then encrypted as
+// ClientOptions asks, with the metadata and headers that record how, or a nil
+// reader if src is stored as it is. The encoding runs as the reader is read;
+// closing it stops the encoding.
+func (c *AzureBlobClient) encodeUpload(ctx context.Context, src io.Reader) (io.ReadCloser, map[string]string, *azblob.BlobHTTPHeaders, error) {
+	o := c.clientOptions()
+	if o.Compression == "" && !o.EncryptUploads {
+		return nil, nil, nil, nil
+	}
+	metadata := map[string]string{}
+	var headers *azblob.BlobHTTPHeaders
+	chain := &readerChain{Reader: src}
+	if o.Compression != "" {
+		compression := o.Compression
+		zr, err := compressReader(chain.Reader, compression)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		chain.push(zr)
+		metadata[compressionMetadataKey] = compression
+		headers = &azblob.BlobHTTPHeaders{BlobContentEncoding: &compression}
+	}
+	if o.EncryptUploads {
+		key, m, err := c.uploadEncryption(ctx)
+		if err != nil {
+			chain.Close()
+			return nil, nil, nil, err
+		}
+		chain.push(encryptReader(chain.Reader, key))
+		for k, v := range m {
+			metadata[k] = v
+		}
+	}
+	return chain, metadata, headers, nil
+}
+
+// readerChain reads from the last of a chain of readers each consuming the
+// one before, and closes them all.
+type readerChain struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *readerChain) push(rc io.ReadCloser) {
+	r.Reader = rc
+	r.closers = append(r.closers, rc)
+}
+
+func (r *readerChain) Close() error {
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		r.closers[i].Close()
+	}
+	return nil
+}
+
+// spoolTemp runs write on a buffered temporary file and returns the file.
+// The caller closes and removes it.
+func spoolTemp(write func(w io.Writer) error) (*os.File, error) {
+	tmp, err := os.CreateTemp("", ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(tmp)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return tmp, nil
+}
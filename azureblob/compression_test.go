@@ -0,0 +1,202 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseCompression(t *testing.T) {
+	for _, s := range []string{"", "gzip", "zstd"} {
+		if got, err := parseCompression(s); err != nil || got != s {
+			t.Errorf("parseCompression(%q) = %q, %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"brotli", "ZSTD"} {
+		if _, err := parseCompression(s); err == nil {
+			t.Errorf("parseCompression(%q) succeeded", s)
+		}
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	plain := bytes.Repeat([]byte("compressible "), 1000)
+	for _, algorithm := range []string{compressionGzip, compressionZstd} {
+		t.Run(algorithm, func(t *testing.T) {
+			zr, err := compressReader(bytes.NewReader(plain), algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compressed, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(compressed) >= len(plain) {
+				t.Errorf("compressed %d bytes to %d", len(plain), len(compressed))
+			}
+			dr, err := decompressReader(bytes.NewReader(compressed), algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dr.Close()
+			if got, err := io.ReadAll(dr); err != nil || !bytes.Equal(got, plain) {
+				t.Errorf("decompressed %d bytes, %v", len(got), err)
+			}
+		})
+	}
+	if _, err := compressReader(bytes.NewReader(plain), "brotli"); err == nil {
+		t.Error("compressReader accepted brotli")
+	}
+}
+
+func TestFindCompression(t *testing.T) {
+	if got := findCompression(map[string]string{"Bkcompression": "gzip"}); got != "gzip" {
+		t.Errorf("findCompression = %q, want gzip", got)
+	}
+	if got := findCompression(map[string]string{"other": "x"}); got != "" {
+		t.Errorf("findCompression = %q, want none", got)
+	}
+}
+
+func TestUploadCompresses(t *testing.T) {
+	for _, tt := range []struct {
+		algorithm string
+		encrypt   bool
+	}{{compressionGzip, false}, {compressionGzip, true}, {compressionZstd, false}, {compressionZstd, true}} {
+		algorithm, encrypt := tt.algorithm, tt.encrypt
+		m := newMemContainer()
+		az := newTestClient(t, m)
+		az.ClientOptions.Compression = algorithm
+		if encrypt {
+			az.ClientOptions.EncryptUploads = true
+			az.Keys = mustKeyRing(t, "k1", map[string][]byte{"k1": testKey(1)})
+		}
+		plain := bytes.Repeat([]byte("compressible "), 10000)
+		path := writeFile(t, filepath.Join(t.TempDir(), "plain"), string(plain))
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		ctx := context.Background()
+		if _, err := az.Upload(ctx, f, "blob"); err != nil {
+			t.Fatal(err)
+		}
+		stored := m.blobs["blob"]
+		if len(stored.data) >= len(plain)/2 {
+			t.Errorf("%s, encrypt=%v: stored %d bytes of %d", algorithm, encrypt, len(stored.data), len(plain))
+		}
+		if findCompression(stored.metadata) != algorithm || stored.contentEncoding != algorithm {
+			t.Errorf("%s, encrypt=%v: metadata %v, Content-Encoding %q", algorithm, encrypt, stored.metadata, stored.contentEncoding)
+		}
+		if _, _, ok := findEncryptionData(stored.metadata); ok != encrypt {
+			t.Errorf("%s, encrypt=%v: encryption metadata %v", algorithm, encrypt, stored.metadata)
+		}
+
+		dest := filepath.Join(t.TempDir(), "out")
+		if _, err := az.Download(ctx, "blob", dest); err != nil {
+			t.Fatal(err)
+		}
+		if got := readFile(t, dest); got != string(plain) {
+			t.Errorf("%s, encrypt=%v: downloaded %d bytes that differ from the upload", algorithm, encrypt, len(got))
+		}
+	}
+}
+
+func TestDownloadCorruptCompressed(t *testing.T) {
+	m := newMemContainer()
+	m.put("blob", []byte("not gzip"), map[string]string{compressionMetadataKey: compressionGzip})
+	az := newTestClient(t, m)
+	_, err := az.Download(context.Background(), "blob", filepath.Join(t.TempDir(), "out"))
+	if err == nil || !strings.Contains(err.Error(), "decompress") {
+		t.Errorf("Download = %v, want a decompression error", err)
+	}
+}
+
+func TestResumableRejectsCompressed(t *testing.T) {
+	m := newMemContainer()
+	m.put("blob", []byte("x"), map[string]string{compressionMetadataKey: compressionGzip})
+	az := newTestClient(t, m)
+	dir := t.TempDir()
+	err := az.DownloadResumable(context.Background(), "blob", filepath.Join(dir, "out"), filepath.Join(dir, "state"))
+	if err == nil || !strings.Contains(err.Error(), "compressed") {
+		t.Errorf("DownloadResumable = %v, want it rejected", err)
+	}
+}
+
+func gzipBytes(t *testing.T, data string) []byte {
+	t.Helper()
+	zr, err := compressReader(strings.NewReader(data), compressionGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDownloadContentEncoding(t *testing.T) {
+	m := newMemContainer()
+	// Uploaded by another tool: only the Content-Encoding says gzip.
+	foreign := gzipBytes(t, "served as gzip")
+	m.put("foreign", foreign, nil).contentEncoding = "gzip"
+	ours := gzipBytes(t, "compressed here")
+	m.put("ours", ours, map[string]string{compressionMetadataKey: compressionGzip}).contentEncoding = "gzip"
+	tests := []struct {
+		mode          string
+		foreign, ours string
+	}{
+		{"", string(foreign), "compressed here"},
+		{contentEncodingAuto, string(foreign), "compressed here"},
+		{contentEncodingDecode, "served as gzip", "compressed here"},
+		{contentEncodingRaw, string(foreign), string(ours)},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		az := newTestClient(t, m)
+		az.ClientOptions.ContentEncoding = tt.mode
+		for blob, want := range map[string]string{"foreign": tt.foreign, "ours": tt.ours} {
+			dest := filepath.Join(t.TempDir(), blob)
+			if _, err := az.Download(ctx, blob, dest); err != nil {
+				t.Fatalf("%q %s: %v", tt.mode, blob, err)
+			}
+			if got := readFile(t, dest); got != want {
+				t.Errorf("%q %s: downloaded %q, want %q", tt.mode, blob, got, want)
+			}
+		}
+	}
+}
+
+func TestParseContentEncoding(t *testing.T) {
+	for _, s := range []string{"auto", "decode", "raw"} {
+		if got, err := parseContentEncoding(s); err != nil || got != s {
+			t.Errorf("parseContentEncoding(%q) = %q, %v", s, got, err)
+		}
+	}
+	if _, err := parseContentEncoding("gzip"); err == nil {
+		t.Error("parseContentEncoding accepted gzip")
+	}
+}
+
+func TestIdentityTransport(t *testing.T) {
+	var got string
+	next := transporterFunc(func(req *http.Request) (*http.Response, error) {
+		got = req.Header.Get("Accept-Encoding")
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+	req, _ := http.NewRequest(http.MethodGet, "https://account.blob.core.windows.net/c/b", nil)
+	if _, err := (identityTransport{next: next}).Do(req); err != nil {
+		t.Fatal(err)
+	}
+	if got != "identity" {
+		t.Errorf("Accept-Encoding = %q, want identity", got)
+	}
+}
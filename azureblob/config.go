@@ -0,0 +1,188 @@
+package azureblob
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	credentialDefault     = "default"
+	credentialInteractive = "interactive"
+)
+
+// Profile names the identity and container a client uses. Empty fields are
+// left to lower-precedence sources.
+type Profile struct {
+	TenantID string `yaml:"tenant_id"`
+	// AdditionallyAllowedTenants lists, separated by commas, the tenants
+	// besides TenantID that storage accounts may be homed in, or is "*".
+	AdditionallyAllowedTenants string `yaml:"additionally_allowed_tenants"`
+	ClientID                   string `yaml:"client_id"`
+	StorageAccount             string `yaml:"storage_account"`
+	Container                  string `yaml:"container"`
+	// Credential is "default" for the non-interactive credential chain or
+	// "interactive" to also allow the interactive browser.
+	Credential string `yaml:"credential"`
+	// ManagedIdentityID selects a user-assigned managed identity by client
+	// ID or resource ID.
+	ManagedIdentityID string `yaml:"managed_identity_id"`
+	// EncryptionScope is the server-side encryption scope of uploads.
+	EncryptionScope string `yaml:"encryption_scope"`
+}
+
+// merge overrides the fields of p with the non-empty fields of o.
+func (p *Profile) merge(o Profile) {
+	override(&p.TenantID, o.TenantID)
+	override(&p.AdditionallyAllowedTenants, o.AdditionallyAllowedTenants)
+	override(&p.ClientID, o.ClientID)
+	override(&p.StorageAccount, o.StorageAccount)
+	override(&p.Container, o.Container)
+	override(&p.Credential, o.Credential)
+	override(&p.ManagedIdentityID, o.ManagedIdentityID)
+	override(&p.EncryptionScope, o.EncryptionScope)
+}
+
+func override(dst *string, src string) {
+	if src != "" {
+		*dst = src
+	}
+}
+
+// client returns a client for the account, container and identity of p,
+// uploading with its encryption scope.
+func (p Profile) client() (*AzureBlobClient, error) {
+	interactive, err := p.interactive()
+	if err != nil {
+		return nil, err
+	}
+	c := NewAzureBlobClientDefault(p.ClientID, p.TenantID, p.Container, p.StorageAccount)
+	if interactive {
+		c = NewAzureBlobClientInteractive(p.ClientID, p.TenantID, p.Container, p.StorageAccount)
+	}
+	c.AdditionallyAllowedTenants = parseTenants(p.AdditionallyAllowedTenants)
+	c.CredentialOptions.ManagedIdentityID = p.ManagedIdentityID
+	c.ClientOptions.EncryptionScope = p.EncryptionScope
+	return c, nil
+}
+
+// interactive reports whether the credential mode of p allows the
+// interactive browser.
+func (p Profile) interactive() (bool, error) {
+	switch p.Credential {
+	case "", credentialDefault:
+		return false, nil
+	case credentialInteractive:
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown credential mode %q, want %s or %s", p.Credential, credentialDefault, credentialInteractive)
+}
+
+// Config is the configuration file: named profiles and the one used when
+// -profile is not given, and named remotes, which commands refer to as
+// remote:path.
+type Config struct {
+	Default  string             `yaml:"default"`
+	Profiles map[string]Profile `yaml:"profiles"`
+	Remotes  map[string]Profile `yaml:"remotes"`
+	// StateDir is the directory state files are kept in, unless -state-dir
+	// overrides it.
+	StateDir string `yaml:"state_dir"`
+	// BootstrapPublicKey is the minisign public key that bootstrap
+	// manifests must be signed with, unless -public-key overrides it.
+	BootstrapPublicKey string `yaml:"bootstrap_public_key"`
+}
+
+// defaultConfigPath returns config.yaml in the bk_azureblob directory of the
+// user's configuration directory, e.g. ~/.config/bk_azureblob/config.yaml.
+func defaultConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "bk_azureblob", "config.yaml"), nil
+}
+
+// LoadConfig reads the configuration file at path. Unknown keys are
+// rejected so that typos do not silently fall back to other settings.
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(b, cfg); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	if cfg.Default != "" {
+		if _, ok := cfg.Profiles[cfg.Default]; !ok {
+			return nil, fmt.Errorf("config %s: default profile %q is not defined", path, cfg.Default)
+		}
+	}
+	for name, remote := range cfg.Remotes {
+		if err := checkRemote(name, remote); err != nil {
+			return nil, fmt.Errorf("config %s: %w", path, err)
+		}
+	}
+	return cfg, nil
+}
+
+// Profile returns the profile called name, or the default profile if name
+// is empty. Without a default, an empty name selects an empty profile.
+func (c *Config) Profile(name string) (Profile, error) {
+	if name == "" {
+		name = c.Default
+	}
+	if name == "" {
+		return Profile{}, nil
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(c.Profiles))
+		for n := range c.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Profile{}, fmt.Errorf("unknown profile %q; defined profiles: %s", name, strings.Join(names, ", "))
+	}
+	return p, nil
+}
+
+// resolveProfile combines, in increasing precedence, the built-in settings
+// passed to Main, the selected profile of the configuration file and the
+// settings given as flags. It also returns the configuration file, which is
+// empty if the file is missing; it may be missing unless configPath or
+// profile is given explicitly.
+func resolveProfile(configPath, profile string, builtin, flags Profile) (Profile, *Config, error) {
+	explicit := configPath != ""
+	if !explicit {
+		var err error
+		if configPath, err = defaultConfigPath(); err != nil && profile != "" {
+			return Profile{}, nil, err
+		}
+	}
+	cfg := &Config{}
+	if configPath != "" {
+		loaded, err := LoadConfig(configPath)
+		switch {
+		case err == nil:
+			cfg = loaded
+		case errors.Is(err, os.ErrNotExist) && !explicit && profile == "":
+		default:
+			return Profile{}, nil, err
+		}
+	}
+	selected, err := cfg.Profile(profile)
+	if err != nil {
+		return Profile{}, nil, err
+	}
+	resolved := builtin
+	resolved.merge(selected)
+	resolved.merge(flags)
+	return resolved, cfg, nil
+}
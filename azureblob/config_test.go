@@ -0,0 +1,118 @@
+package azureblob
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+default: work
+profiles:
+  work:
+    tenant_id: work-tenant
+    client_id: work-client
+    storage_account: workaccount
+    container: artifacts
+  personal:
+    storage_account: homeaccount
+    container: backups
+    credential: interactive
+    encryption_scope: personal-scope
+`
+
+func TestResolveProfile(t *testing.T) {
+	path := writeFile(t, filepath.Join(t.TempDir(), "config.yaml"), testConfig)
+	builtin := Profile{TenantID: "builtin-tenant", ClientID: "builtin-client", StorageAccount: "builtin", Container: "builtin"}
+	tests := []struct {
+		name    string
+		profile string
+		flags   Profile
+		want    Profile
+	}{
+		{"default profile", "", Profile{}, Profile{TenantID: "work-tenant", ClientID: "work-client", StorageAccount: "workaccount", Container: "artifacts"}},
+		{"named profile over builtin", "personal", Profile{}, Profile{TenantID: "builtin-tenant", ClientID: "builtin-client", StorageAccount: "homeaccount", Container: "backups", Credential: "interactive", EncryptionScope: "personal-scope"}},
+		{"flags over profile", "personal", Profile{Container: "scratch", Credential: "default"}, Profile{TenantID: "builtin-tenant", ClientID: "builtin-client", StorageAccount: "homeaccount", Container: "scratch", Credential: "default", EncryptionScope: "personal-scope"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := resolveProfile(path, tt.profile, builtin, tt.flags)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveProfileDefaultPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", home)
+	t.Setenv("HOME", home)
+	builtin := Profile{StorageAccount: "builtin"}
+	if got, _, err := resolveProfile("", "", builtin, Profile{}); err != nil || got != builtin {
+		t.Errorf("without a config file: %+v, %v", got, err)
+	}
+	if _, _, err := resolveProfile("", "work", builtin, Profile{}); !os.IsNotExist(err) {
+		t.Errorf("-profile without a config file: %v", err)
+	}
+	path, err := defaultConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Dir(path), 0755)
+	writeFile(t, path, testConfig)
+	if got, _, err := resolveProfile("", "", builtin, Profile{}); err != nil || got.StorageAccount != "workaccount" {
+		t.Errorf("with %s: %+v, %v", path, got, err)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name, config, profile, wantErr string
+	}{
+		{"unknown key", "profiles:\n  work:\n    acount: x\n", "", "field acount not found"},
+		{"undefined default", "default: missing\nprofiles:\n  work: {}\n", "", `default profile "missing" is not defined`},
+		{"unknown profile", testConfig, "typo", `unknown profile "typo"; defined profiles: personal, work`},
+		{"remote without container", "remotes:\n  prod:\n    storage_account: x\n", "", `remote "prod" needs a storage_account and a container`},
+		{"remote name with colon", "remotes:\n  \"a:b\":\n    storage_account: x\n    container: y\n", "", `remote name "a:b"`},
+		{"remote credential", "remotes:\n  prod:\n    storage_account: x\n    container: y\n    credential: browser\n", "", `remote "prod": unknown credential mode "browser"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, filepath.Join(dir, tt.name+".yaml"), tt.config)
+			_, _, err := resolveProfile(path, tt.profile, Profile{}, Profile{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+	if _, _, err := resolveProfile(filepath.Join(dir, "missing.yaml"), "", Profile{}, Profile{}); !os.IsNotExist(err) {
+		t.Errorf("explicit missing config: %v", err)
+	}
+}
+
+func TestProfileClient(t *testing.T) {
+	p := Profile{TenantID: "t", ClientID: "c", StorageAccount: "acct", Container: "cont"}
+	az, err := p.client()
+	if err != nil || az.CredentialOptions.InteractiveCredential || az.StorageAccount != "acct" || az.ContainerName != "cont" || az.TenantID != "t" || az.ClientID != "c" {
+		t.Errorf("default client: %+v, %v", az, err)
+	}
+	if az.ClientOptions.EncryptionScope != "" {
+		t.Errorf("encryption scope %q without one configured", az.ClientOptions.EncryptionScope)
+	}
+	p.Credential = credentialInteractive
+	p.EncryptionScope = "scope"
+	p.ManagedIdentityID = "7cf7db0d"
+	if az, err := p.client(); err != nil || !az.CredentialOptions.InteractiveCredential || az.ClientOptions.EncryptionScope != "scope" || az.CredentialOptions.ManagedIdentityID != "7cf7db0d" {
+		t.Errorf("interactive client: %v", err)
+	}
+	p.Credential = "browser"
+	if _, err := p.client(); err == nil {
+		t.Error("unknown credential mode accepted")
+	}
+}
@@ -0,0 +1,337 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// Public access levels of a container: off keeps it private, blob lets
+// anyone read its blobs by name, and container also lets anyone list them.
+const (
+	PublicAccessOff       = "off"
+	PublicAccessBlob      = "blob"
+	PublicAccessContainer = "container"
+)
+
+// maxStoredAccessPolicies is how many stored access policies the service
+// allows a container.
+const maxStoredAccessPolicies = 5
+
+// StoredAccessPolicy is a stored access policy of a container. SAS tokens
+// that name its ID take the permissions and validity it sets, and are
+// revoked by deleting it.
+type StoredAccessPolicy struct {
+	ID string `json:"id"`
+	// Permissions are the permission letters of the policy, e.g. "rl", or
+	// empty if the tokens set them.
+	Permissions string `json:"permissions,omitempty"`
+	// Start and Expiry bound when the tokens are valid, unless zero, in
+	// which case the tokens set them.
+	Start  time.Time `json:"start"`
+	Expiry time.Time `json:"expiry"`
+}
+
+// ContainerAccess is the public access level and the stored access
+// policies of a container.
+type ContainerAccess struct {
+	PublicAccess string               `json:"publicAccess"`
+	Policies     []StoredAccessPolicy `json:"policies"`
+
+	// lastModified is when the container was last changed, which updates
+	// must not have happened since.
+	lastModified time.Time
+}
+
+// ContainerAccess returns the public access level and the stored access
+// policies of the container.
+func (c *AzureBlobClient) ContainerAccess(ctx context.Context) (*ContainerAccess, error) {
+	var access *ContainerAccess
+	err := c.withRebuild(ctx, "get container access policy", c.ContainerName, func() error {
+		if err := c.init(ctx); err != nil {
+			return err
+		}
+		ctx, cancel := c.metadataContext(ctx)
+		defer cancel()
+		resp, err := c.containerClient.GetAccessPolicy(ctx, nil)
+		if err != nil {
+			return newBlobError("get container access policy", c.ContainerName, err)
+		}
+		access = &ContainerAccess{PublicAccess: PublicAccessOff, Policies: []StoredAccessPolicy{}}
+		if resp.BlobPublicAccess != nil {
+			access.PublicAccess = string(*resp.BlobPublicAccess)
+		}
+		if resp.LastModified != nil {
+			access.lastModified = *resp.LastModified
+		}
+		for _, si := range resp.SignedIdentifiers {
+			p := StoredAccessPolicy{ID: stringValue(si.ID)}
+			if ap := si.AccessPolicy; ap != nil {
+				p.Permissions = stringValue(ap.Permission)
+				if ap.Start != nil {
+					p.Start = *ap.Start
+				}
+				if ap.Expiry != nil {
+					p.Expiry = *ap.Expiry
+				}
+			}
+			access.Policies = append(access.Policies, p)
+		}
+		return nil
+	})
+	return access, err
+}
+
+// SetPublicAccess sets the public access level of the container to level,
+// one of PublicAccessOff, PublicAccessBlob and PublicAccessContainer,
+// keeping its stored access policies.
+func (c *AzureBlobClient) SetPublicAccess(ctx context.Context, level string) error {
+	switch level {
+	case PublicAccessOff, PublicAccessBlob, PublicAccessContainer:
+	default:
+		return fmt.Errorf("unknown public access level %q; use off, blob or container", level)
+	}
+	return c.updateContainerAccess(ctx, "set public access", func(a *ContainerAccess) error {
+		a.PublicAccess = level
+		return nil
+	})
+}
+
+// CreateAccessPolicy adds p to the stored access policies of the
+// container. It fails if the container already has a policy with p's ID,
+// or has as many policies as the service allows.
+func (c *AzureBlobClient) CreateAccessPolicy(ctx context.Context, p StoredAccessPolicy) error {
+	if p.ID == "" || len(p.ID) > 64 {
+		return fmt.Errorf("stored access policy ID %q must be 1 to 64 characters", p.ID)
+	}
+	if !p.Start.IsZero() && !p.Expiry.IsZero() && !p.Expiry.After(p.Start) {
+		return fmt.Errorf("stored access policy %q expires before it starts", p.ID)
+	}
+	return c.updateContainerAccess(ctx, "create stored access policy", func(a *ContainerAccess) error {
+		for _, q := range a.Policies {
+			if q.ID == p.ID {
+				return fmt.Errorf("stored access policy %q already exists", p.ID)
+			}
+		}
+		if len(a.Policies) >= maxStoredAccessPolicies {
+			return fmt.Errorf("container %s already has %d stored access policies, the most allowed", c.ContainerName, len(a.Policies))
+		}
+		a.Policies = append(a.Policies, p)
+		return nil
+	})
+}
+
+// DeleteAccessPolicies removes the stored access policies with the given
+// IDs from the container, revoking the SAS tokens that name them. It
+// fails, deleting none, if any of them does not exist.
+func (c *AzureBlobClient) DeleteAccessPolicies(ctx context.Context, ids ...string) error {
+	return c.updateContainerAccess(ctx, "delete stored access policy", func(a *ContainerAccess) error {
+		remove := map[string]bool{}
+		for _, id := range ids {
+			remove[id] = true
+		}
+		kept := a.Policies[:0]
+		for _, p := range a.Policies {
+			if remove[p.ID] {
+				delete(remove, p.ID)
+				continue
+			}
+			kept = append(kept, p)
+		}
+		for _, id := range ids {
+			if remove[id] {
+				return fmt.Errorf("no stored access policy %q", id)
+			}
+		}
+		a.Policies = kept
+		return nil
+	})
+}
+
+// updateContainerAccess reads the container's access policy, changes it
+// with update and writes it back. The service replaces the public access
+// level and all stored access policies at once, so the write is made only
+// if the container has not changed since it was read.
+func (c *AzureBlobClient) updateContainerAccess(ctx context.Context, op string, update func(*ContainerAccess) error) error {
+	access, err := c.ContainerAccess(ctx)
+	if err != nil {
+		return err
+	}
+	if err := update(access); err != nil {
+		return err
+	}
+	opts := azblob.ContainerSetAccessPolicyOptions{ContainerACL: []*azblob.SignedIdentifier{}}
+	if access.PublicAccess != PublicAccessOff {
+		opts.Access = azblob.PublicAccessType(access.PublicAccess).ToPtr()
+	}
+	for _, p := range access.Policies {
+		p := p
+		ap := &azblob.AccessPolicy{}
+		if p.Permissions != "" {
+			ap.Permission = &p.Permissions
+		}
+		if !p.Start.IsZero() {
+			ap.Start = &p.Start
+		}
+		if !p.Expiry.IsZero() {
+			ap.Expiry = &p.Expiry
+		}
+		opts.ContainerACL = append(opts.ContainerACL, &azblob.SignedIdentifier{ID: &p.ID, AccessPolicy: ap})
+	}
+	var conditions *azblob.ContainerAccessConditions
+	if !access.lastModified.IsZero() {
+		conditions = &azblob.ContainerAccessConditions{
+			ModifiedAccessConditions: &azblob.ModifiedAccessConditions{IfUnmodifiedSince: &access.lastModified},
+		}
+	}
+	return c.withRebuild(ctx, op, c.ContainerName, func() error {
+		if err := c.init(ctx); err != nil {
+			return err
+		}
+		ctx, cancel := c.metadataContext(ctx)
+		defer cancel()
+		_, err := c.containerClient.SetAccessPolicy(ctx, &azblob.SetAccessPolicyOptions{
+			ContainerSetAccessPolicyOptions: opts,
+			AccessConditions:                conditions,
+		})
+		return newBlobError(op, c.ContainerName, err)
+	})
+}
+
+func runPublicAccess(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("public-access", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: public-access [off|blob|container]\n\nWithout a level, prints the container's public access level.\n")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch fs.NArg() {
+	case 0:
+		access, err := az.ContainerAccess(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Println(access.PublicAccess)
+		return nil
+	case 1:
+		return az.SetPublicAccess(ctx, fs.Arg(0))
+	}
+	fs.Usage()
+	return errors.New("public-access takes at most one access level")
+}
+
+func runAccessPolicy(ctx context.Context, az *AzureBlobClient, args []string) error {
+	usage := "Usage: access-policy list [-output format]\n       access-policy create [-permissions letters] [-start time] [-expiry time | -for duration] <id>\n       access-policy delete <id>...\n"
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return errors.New("access-policy takes list, create or delete")
+	}
+	fs := flag.NewFlagSet("access-policy "+args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "%s\nFlags:\n", usage)
+		fs.PrintDefaults()
+	}
+	switch args[0] {
+	case "list":
+		output := outputFlag(fs)
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 0 {
+			fs.Usage()
+			return errors.New("access-policy list takes no arguments")
+		}
+		if err := checkOutput(*output); err != nil {
+			return err
+		}
+		access, err := az.ContainerAccess(ctx)
+		if err != nil {
+			return err
+		}
+		return printAccessPolicies(*output, access.Policies)
+	case "create":
+		permissions := fs.String("permissions", "", "grant the permission `letters` of SAS permissions, e.g. rl to read and list")
+		start := fs.String("start", "", "make tokens valid from an RFC 3339 `time`")
+		expiry := fs.String("expiry", "", "make tokens expire at an RFC 3339 `time`")
+		period := fs.Duration("for", 0, "make tokens expire `duration` from now, e.g. 720h")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return errors.New("access-policy create takes a policy ID")
+		}
+		if *period != 0 && *expiry != "" {
+			return errors.New("access-policy create takes either -for or -expiry")
+		}
+		p := StoredAccessPolicy{ID: fs.Arg(0), Permissions: *permissions}
+		var err error
+		if *start != "" {
+			if p.Start, err = time.Parse(time.RFC3339, *start); err != nil {
+				return fmt.Errorf("-start: %w", err)
+			}
+		}
+		switch {
+		case *expiry != "":
+			if p.Expiry, err = time.Parse(time.RFC3339, *expiry); err != nil {
+				return fmt.Errorf("-expiry: %w", err)
+			}
+		case *period < 0:
+			return errors.New("-for must be positive")
+		case *period > 0:
+			p.Expiry = time.Now().Add(*period).Truncate(time.Second)
+		}
+		return az.CreateAccessPolicy(ctx, p)
+	case "delete":
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() == 0 {
+			fs.Usage()
+			return errors.New("access-policy delete takes policy IDs")
+		}
+		return az.DeleteAccessPolicies(ctx, fs.Args()...)
+	}
+	fmt.Fprint(os.Stderr, usage)
+	return fmt.Errorf("unknown access-policy subcommand %q; use list, create or delete", args[0])
+}
+
+// printAccessPolicies prints policies in format: a line of ID, start,
+// expiry and permissions per policy for a table, with "-" for what the
+// tokens set.
+func printAccessPolicies(format string, policies []StoredAccessPolicy) error {
+	switch format {
+	case outputJSON:
+		return printJSON(policies)
+	case outputCSV:
+		rows := make([][]string, len(policies))
+		for i, p := range policies {
+			rows[i] = []string{p.ID, policyTime(p.Start, ""), policyTime(p.Expiry, ""), p.Permissions}
+		}
+		return printCSV([]string{"id", "start", "expiry", "permissions"}, rows)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, p := range policies {
+		permissions := p.Permissions
+		if permissions == "" {
+			permissions = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.ID, policyTime(p.Start, "-"), policyTime(p.Expiry, "-"), permissions)
+	}
+	return w.Flush()
+}
+
+// policyTime formats t in RFC 3339, or returns unset if t is zero.
+func policyTime(t time.Time, unset string) string {
+	if t.IsZero() {
+		return unset
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,158 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// aclContainer answers container ACL requests, keeping the public access
+// level and the signed identifiers as the service would, and passes
+// everything else on to m.
+type aclContainer struct {
+	m *memContainer
+
+	mu       sync.Mutex
+	access   string
+	acl      []byte
+	modified time.Time
+	writes   int
+}
+
+func (h *aclContainer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("restype") != "container" || q.Get("comp") != "acl" {
+		h.m.ServeHTTP(w, r)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w.Header().Set("x-ms-request-id", "request-1")
+	switch r.Method {
+	case http.MethodGet:
+		if h.access != "" {
+			w.Header().Set("x-ms-blob-public-access", h.access)
+		}
+		w.Header().Set("Last-Modified", h.modified.Format(http.TimeFormat))
+		w.Header().Set("Content-Type", "application/xml")
+		acl := h.acl
+		if acl == nil {
+			acl = []byte("<SignedIdentifiers></SignedIdentifiers>")
+		}
+		w.Write(acl)
+	case http.MethodPut:
+		if since, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err != nil || h.modified.After(since) {
+			w.Header().Set("x-ms-error-code", "ConditionNotMet")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		h.access = r.Header.Get("x-ms-blob-public-access")
+		h.acl, _ = io.ReadAll(r.Body)
+		h.writes++
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func TestContainerAccess(t *testing.T) {
+	h := &aclContainer{m: newMemContainer(), modified: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	az := newTestClient(t, h)
+	ctx := context.Background()
+
+	access, err := az.ContainerAccess(ctx)
+	if err != nil || access.PublicAccess != PublicAccessOff || len(access.Policies) != 0 {
+		t.Fatalf("ContainerAccess = %+v, %v", access, err)
+	}
+	if err := az.SetPublicAccess(ctx, PublicAccessBlob); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	expiry := start.Add(30 * 24 * time.Hour)
+	if err := az.CreateAccessPolicy(ctx, StoredAccessPolicy{ID: "ci-read", Permissions: "rl", Start: start, Expiry: expiry}); err != nil {
+		t.Fatal(err)
+	}
+	if err := az.CreateAccessPolicy(ctx, StoredAccessPolicy{ID: "ci-write", Permissions: "cw"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := az.CreateAccessPolicy(ctx, StoredAccessPolicy{ID: "ci-read"}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("creating a duplicate = %v", err)
+	}
+	access, err = az.ContainerAccess(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access.PublicAccess != PublicAccessBlob || len(access.Policies) != 2 {
+		t.Fatalf("ContainerAccess = %+v", access)
+	}
+	if p := access.Policies[0]; p.ID != "ci-read" || p.Permissions != "rl" || !p.Start.Equal(start) || !p.Expiry.Equal(expiry) {
+		t.Errorf("policy %+v", p)
+	}
+	if p := access.Policies[1]; p.ID != "ci-write" || p.Permissions != "cw" || !p.Start.IsZero() || !p.Expiry.IsZero() {
+		t.Errorf("policy %+v", p)
+	}
+
+	if err := az.DeleteAccessPolicies(ctx, "ci-write", "missing"); err == nil || !strings.Contains(err.Error(), `"missing"`) {
+		t.Errorf("deleting a missing policy = %v", err)
+	}
+	if err := az.DeleteAccessPolicies(ctx, "ci-write"); err != nil {
+		t.Fatal(err)
+	}
+	if err := az.SetPublicAccess(ctx, PublicAccessOff); err != nil {
+		t.Fatal(err)
+	}
+	if err := az.SetPublicAccess(ctx, "everyone"); err == nil {
+		t.Error("an unknown access level was accepted")
+	}
+	access, err = az.ContainerAccess(ctx)
+	if err != nil || access.PublicAccess != PublicAccessOff || len(access.Policies) != 1 || access.Policies[0].ID != "ci-read" {
+		t.Errorf("ContainerAccess = %+v, %v", access, err)
+	}
+	var acl struct {
+		IDs []string `xml:"SignedIdentifier>Id"`
+	}
+	if err := xml.Unmarshal(h.acl, &acl); err != nil || strings.Join(acl.IDs, ",") != "ci-read" {
+		t.Errorf("stored ACL %s: %v", h.acl, err)
+	}
+	if h.writes != 5 {
+		t.Errorf("%d ACL writes, want 5", h.writes)
+	}
+}
+
+func TestContainerAccessConflict(t *testing.T) {
+	h := &aclContainer{m: newMemContainer(), modified: time.Now().Add(time.Hour)}
+	az := newTestClient(t, h)
+	// The container seems to have changed after it was read, as when
+	// another process updated it in between.
+	err := az.SetPublicAccess(context.Background(), PublicAccessContainer)
+	if err == nil || !strings.Contains(err.Error(), "412") {
+		t.Errorf("SetPublicAccess = %v", err)
+	}
+	if h.writes != 0 {
+		t.Errorf("%d ACL writes", h.writes)
+	}
+}
+
+func TestCreateAccessPolicyLimits(t *testing.T) {
+	h := &aclContainer{m: newMemContainer(), modified: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	az := newTestClient(t, h)
+	ctx := context.Background()
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		if err := az.CreateAccessPolicy(ctx, StoredAccessPolicy{ID: id, Permissions: "r"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := az.CreateAccessPolicy(ctx, StoredAccessPolicy{ID: "f"}); err == nil || !strings.Contains(err.Error(), "most allowed") {
+		t.Errorf("a sixth policy = %v", err)
+	}
+	if err := az.CreateAccessPolicy(ctx, StoredAccessPolicy{ID: strings.Repeat("x", 65)}); err == nil {
+		t.Error("a 65-character ID was accepted")
+	}
+	start := time.Now()
+	if err := az.CreateAccessPolicy(ctx, StoredAccessPolicy{ID: "g", Start: start, Expiry: start.Add(-time.Hour)}); err == nil {
+		t.Error("a policy expiring before it starts was accepted")
+	}
+}
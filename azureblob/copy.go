@@ -0,0 +1,251 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// OpenBlob returns a reader of blobPath and its properties. Blobs that
+// downloads decrypt or decompress are not streamed, and preserved symlinks
+// cannot be copied.
+func (c *AzureBlobClient) OpenBlob(ctx context.Context, blobPath string) (io.ReadCloser, *BlobProperties, error) {
+	props, err := c.Stat(ctx, blobPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if metadataValue(props.Metadata, linkTargetMetadataKey) != "" {
+		return nil, nil, fmt.Errorf("copy %q: preserved symlinks cannot be copied", blobPath)
+	}
+	if _, err := encryptionDataFromMetadata(props.Metadata); !errors.Is(err, errNotEncrypted) || c.clientOptions().downloadCompression(props) != "" {
+		return nil, nil, errNotStreamable
+	}
+	body, err := c.openRange(ctx, c.containerClient.NewBlobClient(blobPath), props.ETag, 0, props.Size)
+	if err != nil {
+		return nil, nil, newBlobError("download", blobPath, err)
+	}
+	return body, props, nil
+}
+
+// UploadFrom uploads what r reads to blobPath in blocks, encoded as
+// ClientOptions asks, with the content type and encoding of props.
+func (c *AzureBlobClient) UploadFrom(ctx context.Context, r io.Reader, props *BlobProperties, blobPath string) error {
+	if err := c.init(ctx); err != nil {
+		return err
+	}
+	tracker := c.beginTransfer(ctx, props.Size)
+	defer tracker.finish()
+	var src io.Reader = &progressReader{r: r, tracker: tracker}
+	encoded, metadata, headers, err := c.encodeUpload(ctx, src)
+	if err != nil {
+		return fmt.Errorf("encode %q: %w", blobPath, err)
+	}
+	if encoded != nil {
+		defer encoded.Close()
+		src = encoded
+	}
+	if headers == nil {
+		headers = &azblob.BlobHTTPHeaders{}
+	}
+	if props.ContentType != "" {
+		headers.BlobContentType = &props.ContentType
+	}
+	if headers.BlobContentEncoding == nil && props.ContentEncoding != "" {
+		headers.BlobContentEncoding = &props.ContentEncoding
+	}
+	if props.CacheControl != "" {
+		headers.BlobCacheControl = &props.CacheControl
+	}
+	if err := c.uploadBlocks(ctx, blobPath, src, headers, metadata); err != nil {
+		return newBlobError("upload", blobPath, err)
+	}
+	return c.protectUpload(ctx, blobPath)
+}
+
+// copyBlob copies blob src of from to dst of to. The blob is streamed if
+// both backends can, and staged in a temporary file otherwise. A streamed
+// blob whose content does not match its MD5 is deleted from to again.
+func copyBlob(ctx context.Context, from, to Backend, src, dst string) error {
+	fromStream, ok := from.(StreamBackend)
+	toStream, toOK := to.(StreamBackend)
+	if ok && toOK {
+		r, props, err := fromStream.OpenBlob(ctx, src)
+		if err == nil {
+			defer r.Close()
+			h := md5.New()
+			if err := toStream.UploadFrom(ctx, io.TeeReader(r, h), props, dst); err != nil {
+				return err
+			}
+			if len(props.ContentMD5) > 0 && !bytes.Equal(h.Sum(nil), props.ContentMD5) {
+				to.Delete(ctx, dst)
+				return fmt.Errorf("copy %q: the content read does not match its MD5; the copy was deleted", src)
+			}
+			return nil
+		}
+		if !errors.Is(err, errNotStreamable) {
+			return err
+		}
+	}
+	tmp, err := os.CreateTemp("", ".copy-*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if _, err := from.Download(ctx, src, tmp.Name()); err != nil {
+		return err
+	}
+	f, err := os.Open(tmp.Name())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = to.Upload(ctx, f, dst)
+	return err
+}
+
+// sameBlob reports whether a and b have the same size and, where both have
+// one, the same MD5.
+func sameBlob(a, b *BlobProperties) bool {
+	if a.Size != b.Size {
+		return false
+	}
+	return len(a.ContentMD5) == 0 || len(b.ContentMD5) == 0 || bytes.Equal(a.ContentMD5, b.ContentMD5)
+}
+
+// CopyOptions configures Copy.
+type CopyOptions struct {
+	// Prefix copies every blob whose name starts with the source, replacing
+	// the source by the destination at the start of its name.
+	Prefix bool
+	// SkipExisting leaves destination blobs that match their source by
+	// sameBlob, so an interrupted or repeated migration only copies what is
+	// missing or changed.
+	SkipExisting bool
+}
+
+// Copy copies blob src of from to dst of to, or every blob under the prefix
+// src with opts.Prefix, concurrently as bounded by c.Pool. Messages are
+// printed with c.Messages. All blobs are attempted even if some fail; the
+// failures are printed to stderr.
+func (c *AzureBlobClient) Copy(ctx context.Context, from Backend, src string, to Backend, dst string, opts CopyOptions) error {
+	srcs, dsts := []string{src}, []string{dst}
+	var listed []*BlobProperties
+	if opts.Prefix {
+		blobs, err := from.List(ctx, src)
+		if err != nil {
+			return err
+		}
+		srcs, dsts = nil, nil
+		for _, b := range blobs {
+			// Skip directory markers, as watch does.
+			if strings.HasSuffix(b.Name, "/") {
+				continue
+			}
+			srcs = append(srcs, b.Name)
+			dsts = append(dsts, dst+strings.TrimPrefix(b.Name, src))
+			listed = append(listed, b)
+		}
+	}
+	if from.Location() == to.Location() {
+		for i := range srcs {
+			if srcs[i] == dsts[i] {
+				return fmt.Errorf("copy %q: source and destination are the same blob", srcs[i])
+			}
+		}
+	}
+	errs := c.Pool.Run(ctx, len(srcs), func(ctx context.Context, i int) error {
+		srcName, dstName := blobLocation(from, srcs[i]), blobLocation(to, dsts[i])
+		if opts.SkipExisting {
+			var srcProps *BlobProperties
+			if listed != nil && len(listed[i].ContentMD5) > 0 {
+				srcProps = listed[i]
+			} else {
+				var err error
+				if srcProps, err = from.Stat(ctx, srcs[i]); err != nil {
+					return err
+				}
+			}
+			dstProps, err := to.Stat(ctx, dsts[i])
+			if err != nil && !isNotFound(err) {
+				return err
+			}
+			if err == nil && sameBlob(srcProps, dstProps) {
+				log.Print(c.Messages.format(MsgCopySkipped, srcName, dstName))
+				return nil
+			}
+		}
+		if err := copyBlob(ctx, from, to, srcs[i], dsts[i]); err != nil {
+			return err
+		}
+		log.Print(c.Messages.format(MsgCopied, srcName, dstName))
+		return nil
+	})
+	failures := &transferFailures{total: len(srcs), noun: "copies"}
+	for _, err := range errs {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failures.errs = append(failures.errs, err)
+		}
+	}
+	if len(failures.errs) > 0 {
+		return failures
+	}
+	return nil
+}
+
+// copyEnd returns the backend and blob name of a source or destination of
+// copy: ref in the backend at location, if given, or else ref resolved as a
+// remote:path.
+func copyEnd(az *AzureBlobClient, backends *Backends, location, ref string) (Backend, string, error) {
+	if location != "" {
+		b, err := backends.Open(location)
+		return b, ref, err
+	}
+	return az.resolveRemote(ref)
+}
+
+func runCopy(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("copy", flag.ContinueOnError)
+	from := fs.String("from", "", "read the source from the container or bucket at `url`, e.g. s3://bucket (default: the configured container)")
+	to := fs.String("to", "", "write the destination to the container or bucket at `url`, e.g. azure://account/container (default: the configured container)")
+	prefix := fs.Bool("prefix", false, "copy every blob whose name starts with the source, renaming that part to the destination")
+	skipExisting := fs.Bool("skip-existing", false, "skip blobs whose destination has the same size and, where both record one, MD5")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: copy [flags] <source> <destination>\n       copy -prefix [flags] <source-prefix> <destination-prefix>\n\nWithout -from or -to, the source or destination can be named remote:blob.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("copy takes a source and a destination")
+	}
+	// Share the aggregator before opening other backends, so that their
+	// transfers are reported too.
+	stop := reportProgress(os.Stderr, az.Messages, az.shareProgress(), progressInterval)
+	defer stop()
+	backends := NewBackends(az)
+	src, srcName, err := copyEnd(az, backends, *from, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	dst, dstName, err := copyEnd(az, backends, *to, fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	if !*prefix && (srcName == "" || dstName == "") {
+		return errors.New("copy takes blob names; pass -prefix to copy a prefix")
+	}
+	return az.Copy(ctx, src, srcName, dst, dstName, CopyOptions{Prefix: *prefix, SkipExisting: *skipExisting})
+}
@@ -0,0 +1,154 @@
+package azureblob
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// copyTestClient returns a client of the tools container of srv whose
+// requests to hosts other than Azure's, such as a fake S3 endpoint, go
+// where they are addressed.
+func copyTestClient(t *testing.T, srv http.Handler) *AzureBlobClient {
+	t.Helper()
+	az := newTestClient(t, srv)
+	az.ContainerName = "tools"
+	azure := az.ClientOptions.HTTPClient.Transport
+	az.ClientOptions.HTTPClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if strings.HasSuffix(req.URL.Hostname(), ".core.windows.net") {
+			return azure.RoundTrip(req)
+		}
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+	return az
+}
+
+func TestCopyStreamsBetweenBackends(t *testing.T) {
+	tools, packages := newMemContainer(), newMemContainer()
+	tools.put("app.pkg", []byte("from azure"), nil)
+	srv := &containers{byName: map[string]*memContainer{"tools": tools, "packages": packages}, accounts: map[string]bool{}}
+	az := copyTestClient(t, srv)
+	s := newFakeS3("releases")
+	location := s3TestLocation(t, s)
+	s.objects["v1/"] = &s3Object{}
+	s.objects["v1/app.pkg"] = &s3Object{data: []byte("app 1.0")}
+	s.objects["v1/lib/core.so"] = &s3Object{data: []byte("core")}
+	ctx := context.Background()
+
+	if err := runCopy(ctx, az, []string{"-from", location, "-to", "azure://account/packages", "-prefix", "v1/", "mirror/"}); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"mirror/app.pkg": "app 1.0", "mirror/lib/core.so": "core"} {
+		if b := packages.blobs[name]; b == nil || string(b.data) != want {
+			t.Errorf("%s = %v, want %q", name, b, want)
+		}
+	}
+	if _, ok := packages.blobs["mirror/"]; ok || len(packages.blobs) != 2 {
+		t.Errorf("copied %d blobs, want the two files without the directory marker", len(packages.blobs))
+	}
+
+	etag := packages.blobs["mirror/app.pkg"].etag
+	s.objects["v1/lib/core.so"] = &s3Object{data: []byte("core 2")}
+	if err := runCopy(ctx, az, []string{"-from", location, "-to", "azure://account/packages", "-prefix", "-skip-existing", "v1/", "mirror/"}); err != nil {
+		t.Fatal(err)
+	}
+	if packages.blobs["mirror/app.pkg"].etag != etag {
+		t.Error("-skip-existing copied an unchanged blob again")
+	}
+	if got := string(packages.blobs["mirror/lib/core.so"].data); got != "core 2" {
+		t.Errorf("-skip-existing left a changed blob as %q", got)
+	}
+
+	if err := runCopy(ctx, az, []string{"-to", location, "app.pkg", "from-azure/app.pkg"}); err != nil {
+		t.Fatal(err)
+	}
+	o := s.objects["from-azure/app.pkg"]
+	sum := md5.Sum([]byte("from azure"))
+	if o == nil || string(o.data) != "from azure" || o.metadata[md5MetadataKey] == "" {
+		t.Fatalf("object = %+v", o)
+	}
+	b, _ := NewBackends(az).Open(location)
+	if props, err := b.Stat(ctx, "from-azure/app.pkg"); err != nil || !bytes.Equal(props.ContentMD5, sum[:]) {
+		t.Errorf("the copy does not record the source's MD5: %+v, %v", props, err)
+	}
+}
+
+func TestCopyStagesWhatCannotStream(t *testing.T) {
+	m := newMemContainer()
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	io.WriteString(zw, "decompressed")
+	zw.Close()
+	m.put("logs.txt", zipped.Bytes(), map[string]string{compressionMetadataKey: compressionGzip})
+	az := newTestClient(t, m)
+	bucket := &memBackend{NewFakeBlobStorage(), "mem://copy-bucket"}
+	memBuckets["copy-bucket"] = bucket
+	bucket.Put("in.txt", []byte("from the bucket"), nil)
+	ctx := context.Background()
+
+	if _, _, err := az.OpenBlob(ctx, "logs.txt"); err != errNotStreamable {
+		t.Errorf("opening a compressed blob = %v, want errNotStreamable", err)
+	}
+	if err := runCopy(ctx, az, []string{"-to", "mem://copy-bucket", "logs.txt", "logs.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(bucket.data["logs.txt"]); got != "decompressed" {
+		t.Errorf("copied %q", got)
+	}
+	if err := runCopy(ctx, az, []string{"-from", "mem://copy-bucket", "in.txt", "copied.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(m.blobs["copied.txt"].data); got != "from the bucket" {
+		t.Errorf("copied %q", got)
+	}
+
+	if err := runCopy(ctx, az, []string{"copied.txt", "copied.txt"}); err == nil || !strings.Contains(err.Error(), "the same blob") {
+		t.Errorf("copy onto itself = %v", err)
+	}
+	if err := runCopy(ctx, az, []string{"-to", "mem://copy-bucket", "copied.txt", ""}); err == nil {
+		t.Error("copy to an empty name succeeded")
+	}
+}
+
+// corruptingBackend streams the blobs of a memBackend with the wrong
+// content for their MD5.
+type corruptingBackend struct {
+	*memBackend
+}
+
+func (b corruptingBackend) OpenBlob(ctx context.Context, blob string) (io.ReadCloser, *BlobProperties, error) {
+	props, err := b.Stat(ctx, blob)
+	if err != nil {
+		return nil, nil, err
+	}
+	return io.NopCloser(strings.NewReader("corrupted")), props, nil
+}
+
+func (b corruptingBackend) UploadFrom(ctx context.Context, r io.Reader, props *BlobProperties, blob string) error {
+	return nil
+}
+
+func TestCopyChecksMD5(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	bucket := &memBackend{NewFakeBlobStorage(), "mem://corrupt"}
+	bucket.Put("a.txt", []byte("original"), nil)
+	err := az.Copy(context.Background(), corruptingBackend{bucket}, "a.txt", az, "a.txt", CopyOptions{})
+	if err == nil {
+		t.Fatal("a corrupted copy succeeded")
+	}
+	if _, ok := m.blobs["a.txt"]; ok {
+		t.Error("the corrupted copy was kept")
+	}
+	if got := blobLocation(az, "a.txt"); got != "azure://account/container/a.txt" {
+		t.Errorf("blob location = %s", got)
+	}
+	if got := blobLocation(&memBackend{location: "s3://b?region=x"}, "p/a"); got != "s3://b/p/a?region=x" {
+		t.Errorf("blob location with a query = %s", got)
+	}
+}
@@ -0,0 +1,349 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// defaultDaemonAddress is where the daemon listens without -listen or
+// -socket.
+const defaultDaemonAddress = "127.0.0.1:8765"
+
+// Daemon serves transfers requested over a small HTTP API through one
+// client, so that short-lived processes on a machine share its credential
+// and token cache instead of each authenticating. It handles:
+//
+//	POST /download  {"blob": ..., "path": ...}  download blob to path
+//	POST /upload    {"blob": ..., "path": ...}  upload path to blob
+//	GET  /status    the daemon's container and transfers in flight
+//	GET  /progress  the combined progress of all transfers
+//
+// Transfer requests return once the transfer finishes, with its
+// DaemonTransfer. Paths are on the daemon's machine and must be absolute.
+// Every request must carry the daemon's token, as a bearer token or as the
+// password of basic authentication, and transfer requests must be sent as
+// application/json, which browsers do not send across origins without
+// asking first. Anyone with the token can use the client's credential and
+// read or write any file the daemon can, so it must only be given to
+// processes trusted with both.
+type Daemon struct {
+	client  *AzureBlobClient
+	token   string
+	started time.Time
+	mux     *http.ServeMux
+
+	mu        sync.Mutex
+	nextID    int
+	transfers map[int]*DaemonTransfer
+}
+
+// DaemonRequest is the body of a transfer request. Priority, "critical",
+// "normal" or "background", ranks its block requests against those of other
+// transfers, and RetryBudget, if set, bounds its retries; see WithPriority
+// and WithRetryBudget.
+type DaemonRequest struct {
+	Blob        string `json:"blob"`
+	Path        string `json:"path"`
+	Priority    string `json:"priority,omitempty"`
+	RetryBudget *int   `json:"retryBudget,omitempty"`
+}
+
+// DaemonTransfer describes a transfer requested from a Daemon.
+type DaemonTransfer struct {
+	ID       int        `json:"id"`
+	Op       string     `json:"op"`
+	Blob     string     `json:"blob"`
+	Path     string     `json:"path"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// DaemonStatus is the response of GET /status.
+type DaemonStatus struct {
+	Version        string           `json:"version"`
+	StorageAccount string           `json:"storageAccount"`
+	Container      string           `json:"container"`
+	Started        time.Time        `json:"started"`
+	Transfers      []DaemonTransfer `json:"transfers"`
+}
+
+// DaemonProgress is the response of GET /progress, a ProgressSnapshot.
+type DaemonProgress struct {
+	Outstanding      int     `json:"outstanding"`
+	Completed        int     `json:"completed"`
+	BytesTransferred int64   `json:"bytesTransferred"`
+	BytesTotal       int64   `json:"bytesTotal"`
+	BytesPerSecond   float64 `json:"bytesPerSecond"`
+}
+
+// NewDaemon returns a Daemon making transfers with c, whose progress it
+// aggregates in c.Progress, for requests that carry token.
+func NewDaemon(c *AzureBlobClient, token string) *Daemon {
+	c.shareProgress()
+	d := &Daemon{client: c, token: token, started: time.Now(), mux: http.NewServeMux(), transfers: map[int]*DaemonTransfer{}}
+	d.mux.HandleFunc("/download", d.handleTransfer("download"))
+	d.mux.HandleFunc("/upload", d.handleTransfer("upload"))
+	d.mux.HandleFunc("/status", d.handleStatus)
+	d.mux.HandleFunc("/progress", d.handleProgress)
+	return d
+}
+
+func (d *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !tokenAuthorized(r, d.token) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="bk_azureblob"`)
+		writeDaemonError(w, http.StatusUnauthorized, errors.New("missing or wrong token"))
+		return
+	}
+	d.mux.ServeHTTP(w, r)
+}
+
+// Serve authenticates, so that an interactive credential prompts before
+// any request arrives, and then serves requests on l until ctx is done.
+// Transfers in flight are cancelled then, and Serve returns once their
+// requests have been answered.
+func (d *Daemon) Serve(ctx context.Context, l net.Listener) error {
+	if err := d.client.authenticate(ctx); err != nil {
+		l.Close()
+		return err
+	}
+	srv := &http.Server{Handler: d, BaseContext: func(net.Listener) context.Context { return ctx }}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(l) }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	return srv.Shutdown(context.Background())
+}
+
+// authenticate gets a storage token with c's credential, building it if
+// needed. A client using a SAS has no token to get.
+func (c *AzureBlobClient) authenticate(ctx context.Context) error {
+	if err := c.init(ctx); err != nil || c.clientOptions().SAS != nil {
+		return err
+	}
+	_, err := (*c.credential).GetToken(ctx, policy.TokenRequestOptions{Scopes: c.clientOptions().tokenScopes()})
+	return err
+}
+
+func (d *Daemon) handleTransfer(op string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeDaemonError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s needs POST", r.URL.Path))
+			return
+		}
+		// A cross-origin form or text/plain POST would otherwise be
+		// decoded as well.
+		if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+			writeDaemonError(w, http.StatusUnsupportedMediaType, errors.New("transfer requests must be sent as application/json"))
+			return
+		}
+		var req DaemonRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeDaemonError(w, http.StatusBadRequest, fmt.Errorf("parse request: %w", err))
+			return
+		}
+		if req.Blob == "" || !filepath.IsAbs(req.Path) {
+			writeDaemonError(w, http.StatusBadRequest, errors.New("request needs a blob and an absolute path"))
+			return
+		}
+		ctx := r.Context()
+		if req.Priority != "" {
+			prio, err := ParsePriority(req.Priority)
+			if err != nil {
+				writeDaemonError(w, http.StatusBadRequest, err)
+				return
+			}
+			ctx = WithPriority(ctx, prio)
+		}
+		if req.RetryBudget != nil {
+			ctx = WithRetryBudget(ctx, NewRetryBudget(*req.RetryBudget))
+		}
+		t := d.begin(op, req)
+		err := d.transfer(ctx, op, req)
+		result := d.finish(t, err)
+		status := http.StatusOK
+		if err != nil {
+			status = http.StatusInternalServerError
+			if isNotFound(err) || errors.Is(err, os.ErrNotExist) {
+				status = http.StatusNotFound
+			}
+		}
+		writeJSON(w, status, result)
+	}
+}
+
+func (d *Daemon) transfer(ctx context.Context, op string, req DaemonRequest) error {
+	if op == "download" {
+		_, err := d.client.Download(ctx, req.Blob, req.Path)
+		return err
+	}
+	return d.client.uploadFile(ctx, req.Path, req.Blob)
+}
+
+// uploadFile uploads the file at path to blobPath.
+func (c *AzureBlobClient) uploadFile(ctx context.Context, path, blobPath string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = c.Upload(ctx, f, blobPath)
+	return err
+}
+
+// begin records a transfer as in flight.
+func (d *Daemon) begin(op string, req DaemonRequest) *DaemonTransfer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nextID++
+	t := &DaemonTransfer{ID: d.nextID, Op: op, Blob: req.Blob, Path: req.Path, Started: time.Now()}
+	d.transfers[t.ID] = t
+	return t
+}
+
+// finish removes t from the transfers in flight and returns its outcome.
+func (d *Daemon) finish(t *DaemonTransfer, err error) DaemonTransfer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.transfers, t.ID)
+	result := *t
+	now := time.Now()
+	result.Finished = &now
+	if err != nil {
+		result.Error = err.Error()
+	}
+	log.Print(d.client.Messages.format(MsgDaemonTransfer, result.Op, result.Blob, result.Path, daemonOutcome(err)))
+	return result
+}
+
+func daemonOutcome(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "done"
+}
+
+func (d *Daemon) handleStatus(w http.ResponseWriter, r *http.Request) {
+	info, _ := debug.ReadBuildInfo()
+	status := DaemonStatus{
+		Version:        collectVersion(info).Version,
+		StorageAccount: d.client.StorageAccount,
+		Container:      d.client.ContainerName,
+		Started:        d.started,
+		Transfers:      []DaemonTransfer{},
+	}
+	d.mu.Lock()
+	for _, t := range d.transfers {
+		status.Transfers = append(status.Transfers, *t)
+	}
+	d.mu.Unlock()
+	sort.Slice(status.Transfers, func(i, j int) bool { return status.Transfers[i].ID < status.Transfers[j].ID })
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (d *Daemon) handleProgress(w http.ResponseWriter, r *http.Request) {
+	s := d.client.Progress.Snapshot()
+	writeJSON(w, http.StatusOK, DaemonProgress{
+		Outstanding:      s.Outstanding,
+		Completed:        s.Completed,
+		BytesTransferred: s.BytesTransferred,
+		BytesTotal:       s.BytesTotal,
+		BytesPerSecond:   s.BytesPerSecond,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeDaemonError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// daemonListener listens on the unix socket at socket if given, readable
+// only by the current user, and otherwise on the TCP address addr, which
+// must be a loopback address.
+func daemonListener(addr, socket string) (net.Listener, error) {
+	if socket == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return nil, fmt.Errorf("-listen %s is not a loopback address; anyone reaching the daemon can use its credential", addr)
+		}
+		return net.Listen("tcp", addr)
+	}
+	// A socket left behind by a daemon that did not exit cleanly would make
+	// listening fail.
+	if info, err := os.Lstat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(socket); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func runDaemon(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	addr := fs.String("listen", defaultDaemonAddress, "serve the API on this loopback `address`")
+	socket := fs.String("socket", "", "serve the API on a unix socket at `path` instead, accessible only to the current user")
+	tokenFile := fs.String("token-file", "", "read the token requests must carry from `file` (default: a random token, which is logged)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: daemon [-token-file <file>] [-listen <address> | -socket <path>]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("daemon takes no arguments")
+	}
+	listenSet := false
+	fs.Visit(func(f *flag.Flag) { listenSet = listenSet || f.Name == "listen" })
+	if listenSet && *socket != "" {
+		return errors.New("-listen and -socket cannot be combined")
+	}
+	token, err := serveToken(*tokenFile)
+	if err != nil {
+		return err
+	}
+	l, err := daemonListener(*addr, *socket)
+	if err != nil {
+		return err
+	}
+	log.Print(az.Messages.format(MsgDaemonListening, l.Addr()))
+	if *tokenFile == "" {
+		log.Print(az.Messages.format(MsgServeToken, token))
+	}
+	return NewDaemon(az, token).Serve(ctx, l)
+}
@@ -0,0 +1,222 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// daemonToken is the token of the daemons of tests.
+const daemonToken = "daemon-token"
+
+func postDaemon(t *testing.T, url string, req DaemonRequest) (int, DaemonTransfer) {
+	t.Helper()
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer "+daemonToken)
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var result DaemonTransfer
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, result
+}
+
+func getDaemon(t *testing.T, client *http.Client, url string, v interface{}) {
+	t.Helper()
+	r, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Authorization", "Bearer "+daemonToken)
+	resp, err := client.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDaemonTransfers(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	srv := httptest.NewServer(NewDaemon(az, daemonToken))
+	defer srv.Close()
+	dir := t.TempDir()
+	src := writeFile(t, filepath.Join(dir, "src.txt"), "hello")
+
+	status, result := postDaemon(t, srv.URL+"/upload", DaemonRequest{Blob: "b.txt", Path: src})
+	if status != http.StatusOK || result.Error != "" || result.Op != "upload" || result.Finished == nil {
+		t.Fatalf("upload = %d %+v", status, result)
+	}
+	if got := string(m.blobs["b.txt"].data); got != "hello" {
+		t.Errorf("uploaded %q", got)
+	}
+	dest := filepath.Join(dir, "dest.txt")
+	budget := 0
+	if status, result := postDaemon(t, srv.URL+"/download", DaemonRequest{Blob: "b.txt", Path: dest, Priority: "critical", RetryBudget: &budget}); status != http.StatusOK {
+		t.Fatalf("download = %d %+v", status, result)
+	}
+	if got := readFile(t, dest); got != "hello" {
+		t.Errorf("downloaded %q", got)
+	}
+
+	for _, tt := range []struct {
+		endpoint string
+		req      DaemonRequest
+		want     int
+	}{
+		{"/download", DaemonRequest{Blob: "missing", Path: dest}, http.StatusNotFound},
+		{"/upload", DaemonRequest{Blob: "b.txt", Path: filepath.Join(dir, "missing")}, http.StatusNotFound},
+		{"/download", DaemonRequest{Blob: "b.txt", Path: "relative.txt"}, http.StatusBadRequest},
+		{"/upload", DaemonRequest{Path: src}, http.StatusBadRequest},
+		{"/upload", DaemonRequest{Blob: "b.txt", Path: src, Priority: "urgent"}, http.StatusBadRequest},
+	} {
+		if status, result := postDaemon(t, srv.URL+tt.endpoint, tt.req); status != tt.want || result.Error == "" && tt.want != http.StatusBadRequest {
+			t.Errorf("%s %+v = %d %+v, want %d", tt.endpoint, tt.req, status, result, tt.want)
+		}
+	}
+	get, _ := http.NewRequest(http.MethodGet, srv.URL+"/download", nil)
+	get.SetBasicAuth("", daemonToken)
+	if resp, err := http.DefaultClient.Do(get); err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /download = %v, %v", resp, err)
+	} else {
+		resp.Body.Close()
+	}
+
+	var st DaemonStatus
+	getDaemon(t, http.DefaultClient, srv.URL+"/status", &st)
+	if st.Container != "container" || len(st.Transfers) != 0 {
+		t.Errorf("status = %+v", st)
+	}
+	var progress DaemonProgress
+	getDaemon(t, http.DefaultClient, srv.URL+"/progress", &progress)
+	if progress.Outstanding != 0 || progress.Completed < 2 || progress.BytesTransferred < 10 {
+		t.Errorf("progress = %+v", progress)
+	}
+}
+
+func TestDaemonRejectsUnauthorizedRequests(t *testing.T) {
+	m := newMemContainer()
+	m.put("secret.txt", []byte("secret"), nil)
+	srv := httptest.NewServer(NewDaemon(newTestClient(t, m), daemonToken))
+	defer srv.Close()
+	dest := filepath.Join(t.TempDir(), "stolen.txt")
+	body := `{"blob":"secret.txt","path":"` + filepath.ToSlash(dest) + `"}`
+
+	for _, tt := range []struct {
+		name, auth, contentType string
+		want                    int
+	}{
+		{"no token", "", "application/json", http.StatusUnauthorized},
+		{"wrong token", "Bearer guess", "application/json", http.StatusUnauthorized},
+		// What a cross-origin form or fetch can send without a preflight.
+		{"text/plain", "Bearer " + daemonToken, "text/plain", http.StatusUnsupportedMediaType},
+		{"form", "Bearer " + daemonToken, "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"no content type", "Bearer " + daemonToken, "", http.StatusUnsupportedMediaType},
+	} {
+		r, err := http.NewRequest(http.MethodPost, srv.URL+"/download", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+	}
+	if resp, err := http.Get(srv.URL + "/status"); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET /status without a token = %v, %v", resp, err)
+	} else {
+		resp.Body.Close()
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("an unauthorized request downloaded the blob: %v", err)
+	}
+}
+
+func TestDaemonServeSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets")
+	}
+	m := newMemContainer()
+	m.put("a.txt", []byte("a"), nil)
+	az := newTestClient(t, m)
+	socket := filepath.Join(t.TempDir(), "d.sock")
+	l, err := daemonListener(defaultDaemonAddress, socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- NewDaemon(az, daemonToken).Serve(ctx, l) }()
+	client := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", socket)
+	}}}
+	var st DaemonStatus
+	getDaemon(t, client, "http://daemon/status", &st)
+	if st.StorageAccount != "account" {
+		t.Errorf("status = %+v", st)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Serve = %v", err)
+	}
+}
+
+func TestDaemonListener(t *testing.T) {
+	for _, addr := range []string{"0.0.0.0:0", "example.com:80", "8765"} {
+		if l, err := daemonListener(addr, ""); err == nil {
+			l.Close()
+			t.Errorf("listening on %s succeeded", addr)
+		}
+	}
+	l, err := daemonListener("127.0.0.1:0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+}
+
+func TestRunDaemonArgs(t *testing.T) {
+	az := newTestClient(t, newMemContainer())
+	for _, args := range [][]string{{"extra"}, {"-listen", "127.0.0.1:0", "-socket", "d.sock"}} {
+		if err := runDaemon(context.Background(), az, args); err == nil {
+			t.Errorf("daemon %q succeeded", args)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	defaultThroughputGrace = 30 * time.Second
+	// slowTransferAttempts is how many times a transfer is started before
+	// missing its deadline fails it.
+	slowTransferAttempts = 3
+)
+
+// transferDeadline returns the time a transfer of size bytes may take at
+// o.MinThroughput, plus the grace period, or zero if no minimum is set.
+func (o *AzureBlobClientOptions) transferDeadline(size int64) time.Duration {
+	if o.MinThroughput <= 0 {
+		return 0
+	}
+	grace := o.ThroughputGrace
+	if grace == 0 {
+		grace = defaultThroughputGrace
+	} else if grace < 0 {
+		grace = 0
+	}
+	return time.Duration(float64(size)/float64(o.MinThroughput)*float64(time.Second)) + grace
+}
+
+// withTransferDeadline runs the transfer fn of size bytes under the deadline
+// of c's minimum throughput. A transfer that misses it is cancelled and
+// started again, typically on a fresh connection, up to
+// slowTransferAttempts times in all. fn must restart the transfer from
+// scratch on each call.
+func (c *AzureBlobClient) withTransferDeadline(ctx context.Context, op, name string, size int64, fn func(ctx context.Context) error) error {
+	opts := c.clientOptions()
+	deadline := opts.transferDeadline(size)
+	if deadline <= 0 {
+		return fn(ctx)
+	}
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, deadline)
+		err := fn(attemptCtx)
+		slow := attemptCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil
+		cancel()
+		if err == nil || !slow {
+			return err
+		}
+		if attempt == slowTransferAttempts {
+			return fmt.Errorf("%s %q: not finished within %s in any of %d attempts, below the minimum throughput of %s/s",
+				op, name, deadline, attempt, formatBytes(opts.MinThroughput))
+		}
+		log.Print(c.Messages.format(MsgSlowTransfer, op, name, deadline, formatBytes(opts.MinThroughput), attempt+1, slowTransferAttempts))
+		transferStatsFrom(ctx).restarted()
+	}
+}
@@ -0,0 +1,133 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransferDeadline(t *testing.T) {
+	tests := []struct {
+		name string
+		opts AzureBlobClientOptions
+		size int64
+		want time.Duration
+	}{
+		{"disabled", AzureBlobClientOptions{}, 1 << 30, 0},
+		{"default grace", AzureBlobClientOptions{MinThroughput: 1 << 20}, 10 << 20, 10*time.Second + defaultThroughputGrace},
+		{"custom grace", AzureBlobClientOptions{MinThroughput: 1000, ThroughputGrace: time.Second}, 500, 1500 * time.Millisecond},
+		{"no grace", AzureBlobClientOptions{MinThroughput: 1000, ThroughputGrace: -1}, 2000, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.transferDeadline(tt.size); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// stallingTransfer blocks until its context is done for the first stalls
+// calls and succeeds afterwards.
+func stallingTransfer(stalls int, calls *int) func(context.Context) error {
+	return func(ctx context.Context) error {
+		*calls++
+		if *calls <= stalls {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}
+}
+
+func TestWithTransferDeadline(t *testing.T) {
+	az := &AzureBlobClient{ClientOptions: &AzureBlobClientOptions{MinThroughput: 1 << 30, ThroughputGrace: 10 * time.Millisecond}}
+	tests := []struct {
+		name      string
+		stalls    int
+		wantCalls int
+		wantErr   string
+	}{
+		{"fast", 0, 1, ""},
+		{"restarted", slowTransferAttempts - 1, slowTransferAttempts, ""},
+		{"too slow", slowTransferAttempts, slowTransferAttempts, "below the minimum throughput"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := az.withTransferDeadline(context.Background(), "download", "blob", 1, stallingTransfer(tt.stalls, &calls))
+			if calls != tt.wantCalls {
+				t.Errorf("%d calls, want %d", calls, tt.wantCalls)
+			}
+			if (err == nil) != (tt.wantErr == "") || err != nil && !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want error %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithTransferDeadlineKeepsCallerErrors(t *testing.T) {
+	az := &AzureBlobClient{ClientOptions: &AzureBlobClientOptions{MinThroughput: 1 << 30, ThroughputGrace: time.Hour}}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	calls := 0
+	if err := az.withTransferDeadline(ctx, "download", "blob", 1, stallingTransfer(1, &calls)); !errors.Is(err, context.DeadlineExceeded) || calls != 1 {
+		t.Errorf("caller deadline: %v after %d calls", err, calls)
+	}
+	failure := errors.New("boom")
+	if err := az.withTransferDeadline(context.Background(), "download", "blob", 1, func(context.Context) error { return failure }); err != failure {
+		t.Errorf("transfer error: %v", err)
+	}
+
+	unlimited := &AzureBlobClient{}
+	if err := unlimited.withTransferDeadline(context.Background(), "download", "blob", 1, func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); ok {
+			t.Error("deadline set although MinThroughput is zero")
+		}
+		return nil
+	}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDownloadRestartsStalledTransfer(t *testing.T) {
+	m := newMemContainer()
+	data := []byte("eventually downloaded")
+	m.put("blob", data, nil)
+	var (
+		mu   sync.Mutex
+		gets int
+	)
+	az := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			gets++
+			stall := gets == 1
+			mu.Unlock()
+			if stall {
+				<-r.Context().Done()
+				return
+			}
+		}
+		m.ServeHTTP(w, r)
+	}))
+	az.ClientOptions.MinThroughput = 1 << 30
+	az.ClientOptions.ThroughputGrace = 200 * time.Millisecond
+	dest := filepath.Join(t.TempDir(), "blob")
+	if _, err := az.Download(context.Background(), "blob", dest); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Errorf("downloaded %q, want %q", got, data)
+	}
+	if gets != 2 {
+		t.Errorf("%d GETs, want the stalled one and its restart", gets)
+	}
+}
@@ -0,0 +1,199 @@
+package azureblob
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DedupIndex remembers the local files a client has downloaded by their
+// Content-MD5, so that downloading an identical blob again can link to an
+// existing copy instead of writing a second one. A copy-on-write clone
+// (reflink on Linux, clonefile on macOS) is made where the file system
+// supports it, and a hardlink otherwise. Hardlinked copies share their
+// contents: a file modified in place changes every copy linked to it. A
+// file is only reused while its size and modification time match what was
+// recorded. An index may be shared by several clients by assigning it to
+// their Dedup fields.
+type DedupIndex struct {
+	mu    sync.Mutex
+	path  string
+	files map[string][]dedupFile
+}
+
+// dedupFile is a downloaded file recorded in a DedupIndex.
+type dedupFile struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// NewDedupIndex returns an empty index kept in memory only.
+func NewDedupIndex() *DedupIndex {
+	return &DedupIndex{files: map[string][]dedupFile{}}
+}
+
+// LoadDedupIndex reads the index saved at path by Save, or returns an empty
+// index that Save will write there if path does not exist yet.
+func LoadDedupIndex(path string) (*DedupIndex, error) {
+	idx := NewDedupIndex()
+	idx.path = path
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &idx.files); err != nil {
+		return nil, fmt.Errorf("parse dedup index %s: %w", path, err)
+	}
+	return idx, nil
+}
+
+// Save writes the index back to the path it was loaded from, dropping files
+// that no longer match their records. It does nothing for an index created
+// with NewDedupIndex.
+func (d *DedupIndex) Save() error {
+	if d == nil || d.path == "" {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, files := range d.files {
+		kept := files[:0]
+		for _, f := range files {
+			if f.unchanged() {
+				kept = append(kept, f)
+			}
+		}
+		if len(kept) == 0 {
+			delete(d.files, key)
+		} else {
+			d.files[key] = kept
+		}
+	}
+	b, err := json.Marshal(d.files)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(d.path, b)
+}
+
+// dedupKey identifies the file a download of props writes by the blob's
+// Content-MD5 and size, and by the compression the download decodes, if
+// any, since the same blob is stored differently on disk whether it is
+// decoded or not. It returns "" for blobs without a Content-MD5.
+func dedupKey(props *BlobProperties, decoded string) string {
+	if len(props.ContentMD5) == 0 {
+		return ""
+	}
+	key := hex.EncodeToString(props.ContentMD5) + "-" + strconv.FormatInt(props.Size, 10)
+	if decoded != "" {
+		key += "-" + decoded
+	}
+	return key
+}
+
+func (f dedupFile) unchanged() bool {
+	info, err := os.Stat(f.Path)
+	return err == nil && info.Mode().IsRegular() && info.Size() == f.Size && info.ModTime().Equal(f.ModTime)
+}
+
+// add records destination as holding the content of key.
+func (d *DedupIndex) add(key, destination string) error {
+	if d == nil || key == "" {
+		return nil
+	}
+	abs, err := filepath.Abs(destination)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	files := d.files[key]
+	for i, f := range files {
+		if f.Path == abs {
+			files = append(files[:i], files[i+1:]...)
+			break
+		}
+	}
+	d.files[key] = append(files, dedupFile{Path: abs, Size: info.Size(), ModTime: info.ModTime()})
+	return nil
+}
+
+// link places a copy of the content of key already on disk at destination,
+// returning the file it was made from, or "" if there is no usable copy.
+// Unless hardlink is set, only a clone is made, whose attributes can be
+// changed without changing those of the file it was made from. Files that
+// no longer match their record are forgotten.
+func (d *DedupIndex) link(key, destination string, hardlink bool) (string, error) {
+	if d == nil || key == "" {
+		return "", nil
+	}
+	d.mu.Lock()
+	candidates := append([]dedupFile(nil), d.files[key]...)
+	d.mu.Unlock()
+	abs, err := filepath.Abs(destination)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range candidates {
+		if !f.unchanged() {
+			d.forget(key, f.Path)
+			continue
+		}
+		if f.Path == abs {
+			return f.Path, nil
+		}
+		// Links and clones fail across file systems; try the next copy.
+		if err := linkReplacing(f.Path, abs, hardlink); err == nil {
+			return f.Path, nil
+		}
+	}
+	return "", nil
+}
+
+func (d *DedupIndex) forget(key, path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	files := d.files[key]
+	for i, f := range files {
+		if f.Path == path {
+			d.files[key] = append(files[:i:i], files[i+1:]...)
+			return
+		}
+	}
+}
+
+// linkReplacing clones src, or hardlinks it if that fails and hardlink is
+// set, to a temporary name next to dst and renames it over dst, so an
+// existing dst is replaced rather than written through.
+func linkReplacing(src, dst string, hardlink bool) error {
+	tmp := filepath.Join(filepath.Dir(dst), fmt.Sprintf(".link-%d-%s", os.Getpid(), filepath.Base(dst)))
+	os.Remove(tmp)
+	if err := cloneFile(src, tmp); err != nil {
+		os.Remove(tmp)
+		if !hardlink {
+			return err
+		}
+		if err := os.Link(src, tmp); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package azureblob
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// getCounter counts the GET requests m serves.
+type getCounter struct {
+	m    *memContainer
+	mu   sync.Mutex
+	gets int
+}
+
+func (h *getCounter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && r.URL.Query().Get("comp") == "" {
+		h.mu.Lock()
+		h.gets++
+		h.mu.Unlock()
+	}
+	h.m.ServeHTTP(w, r)
+}
+
+func newDedupClient(t *testing.T, blobs map[string]string) (*AzureBlobClient, *getCounter, *memContainer) {
+	m := newMemContainer()
+	for name, data := range blobs {
+		m.put(name, []byte(data), nil)
+	}
+	h := &getCounter{m: m}
+	az := newTestClient(t, h)
+	az.Dedup = NewDedupIndex()
+	return az, h, m
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestDownloadLinksIdenticalContent(t *testing.T) {
+	az, h, _ := newDedupClient(t, map[string]string{"one/lib.so": "shared", "two/lib.so": "shared", "other": "different"})
+	dir := t.TempDir()
+	ctx := context.Background()
+	for _, name := range []string{"one/lib.so", "two/lib.so", "other"} {
+		if _, err := az.Download(ctx, name, filepath.Join(dir, filepath.Base(filepath.Dir(name))+"-"+filepath.Base(name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if h.gets != 2 {
+		t.Errorf("%d GETs, want 2: the identical blob should have been linked", h.gets)
+	}
+	if got := readFile(t, filepath.Join(dir, "two-lib.so")); got != "shared" {
+		t.Errorf("linked copy holds %q", got)
+	}
+}
+
+func TestDownloadSkipsChangedCopies(t *testing.T) {
+	az, h, _ := newDedupClient(t, map[string]string{"a": "content", "b": "content"})
+	dir := t.TempDir()
+	ctx := context.Background()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	if _, err := az.Download(ctx, "a", a); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, a, "edited!")
+	if _, err := az.Download(ctx, "b", b); err != nil {
+		t.Fatal(err)
+	}
+	if h.gets != 2 || readFile(t, b) != "content" {
+		t.Errorf("%d GETs, b holds %q; the edited copy must not be reused", h.gets, readFile(t, b))
+	}
+}
+
+func TestDownloadReplacesLinkedDestination(t *testing.T) {
+	az, _, m := newDedupClient(t, map[string]string{"a": "v1", "b": "v1"})
+	dir := t.TempDir()
+	ctx := context.Background()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	for _, args := range [][2]string{{"a", a}, {"b", b}} {
+		if _, err := az.Download(ctx, args[0], args[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.put("b", []byte("v2"), nil)
+	if _, err := az.Download(ctx, "b", b); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, a); got != "v1" {
+		t.Errorf("redownloading b changed a to %q", got)
+	}
+	if got := readFile(t, b); got != "v2" {
+		t.Errorf("b holds %q, want v2", got)
+	}
+}
+
+func TestDedupIndexPersistence(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "index.json")
+	idx, err := LoadDedupIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, stale := writeFile(t, filepath.Join(dir, "kept"), "x"), writeFile(t, filepath.Join(dir, "stale"), "y")
+	idx.add(dedupKey(&BlobProperties{Size: 1, ContentMD5: []byte{1}}, ""), kept)
+	idx.add(dedupKey(&BlobProperties{Size: 1, ContentMD5: []byte{2}}, ""), stale)
+	idx.add(dedupKey(&BlobProperties{Size: 1}, ""), kept)
+	os.Chtimes(stale, time.Now(), time.Now().Add(time.Hour))
+	if err := idx.Save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadDedupIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.files) != 1 || len(loaded.files["01-1"]) != 1 || loaded.files["01-1"][0].Path != kept {
+		t.Errorf("loaded index %v, want only %s", loaded.files, kept)
+	}
+
+	writeFile(t, path, "{not json")
+	if _, err := LoadDedupIndex(path); err == nil {
+		t.Error("corrupt index loaded")
+	}
+	if err := NewDedupIndex().Save(); err != nil {
+		t.Errorf("saving an in-memory index: %v", err)
+	}
+}
+
+func TestLinkReplacing(t *testing.T) {
+	dir := t.TempDir()
+	src := writeFile(t, filepath.Join(dir, "src"), "source")
+	dst := writeFile(t, filepath.Join(dir, "dst"), "old")
+	if err := linkReplacing(src, dst, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, dst); got != "source" {
+		t.Errorf("dst holds %q", got)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("%d files left behind, want src and dst", len(entries))
+	}
+}
+
+func TestDownloadLinkedCopyRestoresAttributes(t *testing.T) {
+	az, _, m := newDedupClient(t, nil)
+	az.ClientOptions.PreserveAttributes = true
+	m.put("a", []byte("same"), map[string]string{modeMetadataKey: "600", mtimeMetadataKey: "2020-01-02T03:04:05Z"})
+	m.put("b", []byte("same"), map[string]string{modeMetadataKey: "755", mtimeMetadataKey: "2021-06-07T08:09:10Z"})
+	dir := t.TempDir()
+	ctx := context.Background()
+	for _, name := range []string{"a", "b"} {
+		if _, err := az.Download(ctx, name, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]struct {
+		mode  os.FileMode
+		mtime string
+	}{"a": {0600, "2020-01-02T03:04:05Z"}, "b": {0755, "2021-06-07T08:09:10Z"}} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != want.mode || info.ModTime().UTC().Format(time.RFC3339) != want.mtime {
+			t.Errorf("%s: mode %v, modified %v, want %v and %s", name, info.Mode().Perm(), info.ModTime().UTC(), want.mode, want.mtime)
+		}
+	}
+	// Downloading b over the copy of a reuses the file, with b's
+	// attributes.
+	if _, err := az.Download(ctx, "b", filepath.Join(dir, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "a")); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("the reused copy has mode %v, %v", info.Mode().Perm(), err)
+	}
+}
+
+func TestDedupKeyIncludesDecoding(t *testing.T) {
+	az, _, m := newDedupClient(t, nil)
+	az.ClientOptions.Compression = compressionGzip
+	dir := t.TempDir()
+	ctx := context.Background()
+	f, err := os.Open(writeFile(t, filepath.Join(dir, "src"), "plain text"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := az.Upload(ctx, f, "a"); err != nil {
+		t.Fatal(err)
+	}
+	m.put("b", m.blobs["a"].data, m.blobs["a"].metadata)
+	if _, err := az.Download(ctx, "a", filepath.Join(dir, "a")); err != nil {
+		t.Fatal(err)
+	}
+	az.ClientOptions.ContentEncoding = contentEncodingRaw
+	if _, err := az.Download(ctx, "b", filepath.Join(dir, "b")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(dir, "a")); got != "plain text" {
+		t.Errorf("decoded download holds %q", got)
+	}
+	if got := readFile(t, filepath.Join(dir, "b")); got != string(m.blobs["b"].data) {
+		t.Errorf("raw download holds %q, want the stored bytes", got)
+	}
+}
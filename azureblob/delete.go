@@ -0,0 +1,109 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// planDeletes returns what deleting blobs would remove, looking up the size
+// of each blob whose properties are not already known from a listing.
+func (c *AzureBlobClient) planDeletes(ctx context.Context, blobs []string, listed map[string]*BlobProperties) []PlannedTransfer {
+	plans := make([]PlannedTransfer, len(blobs))
+	errs := c.Pool.Run(ctx, len(blobs), func(ctx context.Context, i int) error {
+		plans[i] = PlannedTransfer{Direction: "delete", Item: ManifestItem{Blob: blobs[i]}}
+		props, ok := listed[blobs[i]]
+		if !ok {
+			var err error
+			if props, err = c.Stat(ctx, blobs[i]); err != nil {
+				return err
+			}
+		}
+		plans[i].Size = props.Size
+		return nil
+	})
+	for i, err := range errs {
+		if err != nil {
+			plans[i].Error = err.Error()
+			plans[i].err = err
+		}
+	}
+	return plans
+}
+
+// deleteAll deletes blobs concurrently, bounded by az.Pool, printing a line
+// per deleted blob. All blobs are attempted even if some fail.
+func deleteAll(ctx context.Context, az *AzureBlobClient, blobs []string) error {
+	errs := az.Pool.Run(ctx, len(blobs), func(ctx context.Context, i int) error {
+		if err := az.Delete(ctx, blobs[i]); err != nil {
+			return err
+		}
+		fmt.Println(az.Messages.format(MsgDeleted, blobs[i]))
+		return nil
+	})
+	failures := &transferFailures{total: len(blobs), noun: "deletions"}
+	for _, err := range errs {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failures.errs = append(failures.errs, err)
+		}
+	}
+	if len(failures.errs) > 0 {
+		return failures
+	}
+	return nil
+}
+
+func runDelete(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "delete every blob whose name starts with `prefix`")
+	dryRun := fs.Bool("dry-run", false, "print what would be deleted and its size without deleting")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: delete [flags] [blob...]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	refs := fs.Args()
+	if *prefix != "" {
+		refs = append(refs, *prefix)
+	}
+	az, blobs, err := az.resolveRemotes(refs)
+	if err != nil {
+		return err
+	}
+	if *prefix != "" {
+		*prefix, blobs = blobs[len(blobs)-1], blobs[:len(blobs)-1]
+	}
+	listed := map[string]*BlobProperties{}
+	if *prefix != "" {
+		items, err := az.List(ctx, *prefix)
+		if err != nil {
+			return err
+		}
+		named := map[string]bool{}
+		for _, blob := range blobs {
+			named[blob] = true
+		}
+		for _, item := range items {
+			if !named[item.Name] {
+				blobs = append(blobs, item.Name)
+			}
+			listed[item.Name] = item
+		}
+	}
+	if len(blobs) == 0 {
+		if *prefix != "" {
+			return nil
+		}
+		fs.Usage()
+		return errors.New("delete takes blob names or -prefix")
+	}
+	if *dryRun {
+		return printPlan(az.Messages, az.planDeletes(ctx, blobs, listed), "planned deletions")
+	}
+	return deleteAll(ctx, az, blobs)
+}
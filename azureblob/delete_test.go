@@ -0,0 +1,51 @@
+package azureblob
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRunDelete(t *testing.T) {
+	m := newMemContainer()
+	m.put("logs/a", []byte("aa"), nil)
+	m.put("logs/b", []byte("bbbb"), nil)
+	m.put("keep", []byte("k"), nil)
+	az := newTestClient(t, m)
+	ctx := context.Background()
+
+	var err error
+	out := captureStdout(t, func() { err = runDelete(ctx, az, []string{"-dry-run", "-prefix", "logs/", "logs/a", "keep"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"would delete logs/a: 2 B", "would delete logs/b: 4 B", "would delete keep: 1 B", "dry run: 3 planned deletions, 7 B in total"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("output lacks %q:\n%s", line, out)
+		}
+	}
+	if strings.Count(out, "logs/a") != 1 {
+		t.Errorf("logs/a is listed twice:\n%s", out)
+	}
+	if len(m.blobs) != 3 {
+		t.Fatalf("dry run deleted blobs, %d left", len(m.blobs))
+	}
+
+	out = captureStdout(t, func() { err = runDelete(ctx, az, []string{"-prefix", "logs/", "missing"}) })
+	if err == nil || err.Error() != "1 of 3 deletions failed" || exitCode(err) != exitNotFound {
+		t.Errorf("got %v (exit code %d)", err, exitCode(err))
+	}
+	if !strings.Contains(out, "logs/b: deleted") {
+		t.Errorf("output:\n%s", out)
+	}
+	if _, ok := m.blobs["keep"]; len(m.blobs) != 1 || !ok {
+		t.Errorf("left %d blobs", len(m.blobs))
+	}
+
+	if err := runDelete(ctx, az, nil); err == nil {
+		t.Error("delete without blobs succeeded")
+	}
+	if err := runDelete(ctx, az, []string{"-prefix", "logs/"}); err != nil {
+		t.Errorf("empty prefix: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUploadDelta(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	data := bytes.Repeat([]byte("0123456789"), 10)[:95]
+	src := writeFile(t, filepath.Join(t.TempDir(), "disk.img"), string(data))
+	ctx := context.Background()
+	upload := func(blockSize int64, want DeltaStats) {
+		t.Helper()
+		m.stages = 0
+		stats, err := az.UploadDelta(ctx, openFile(t, src), "disk.img", blockSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats != want || m.stages != want.Sent {
+			t.Errorf("stats = %+v after %d stages, want %+v", stats, m.stages, want)
+		}
+		if got := m.blobs["disk.img"].data; !bytes.Equal(got, data) {
+			t.Errorf("blob holds %q, want %q", got, data)
+		}
+	}
+
+	upload(10, DeltaStats{Blocks: 10, Sent: 10, Bytes: 95})
+	upload(0, DeltaStats{Blocks: 10, Sent: 0, Bytes: 0})
+
+	// Changes in place send the blocks they touch.
+	data[3], data[42] = 'x', 'y'
+	writeFile(t, src, string(data))
+	upload(0, DeltaStats{Blocks: 10, Sent: 2, Bytes: 20})
+
+	// Growing the file sends the old last block and the new ones.
+	data = append(data, []byte("0123456789")...)
+	writeFile(t, src, string(data))
+	upload(0, DeltaStats{Blocks: 11, Sent: 2, Bytes: 15})
+
+	// Another block size cannot reuse any block.
+	upload(25, DeltaStats{Blocks: 5, Sent: 5, Bytes: 105})
+}
+
+func TestUploadDeltaOverPlainUpload(t *testing.T) {
+	m := newMemContainer()
+	m.put("blob", []byte("uploaded otherwise"), nil)
+	az := newTestClient(t, m)
+	src := writeFile(t, filepath.Join(t.TempDir(), "f"), "uploaded otherwise")
+	stats, err := az.UploadDelta(context.Background(), openFile(t, src), "blob", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Blocks != 1 || stats.Sent != 1 {
+		t.Errorf("stats = %+v, want the whole file sent", stats)
+	}
+	if got := m.blobs["blob"].metadata[deltaBlockSizeMetadataKey]; got != "8388608" {
+		t.Errorf("recorded block size %q", got)
+	}
+}
+
+func TestUploadDeltaRejectsEncryption(t *testing.T) {
+	az := newTestClient(t, newMemContainer())
+	az.ClientOptions.EncryptUploads = true
+	src := writeFile(t, filepath.Join(t.TempDir(), "f"), "x")
+	if _, err := az.UploadDelta(context.Background(), openFile(t, src), "blob", 0); err == nil || !strings.Contains(err.Error(), "encrypted") {
+		t.Errorf("UploadDelta = %v, want it rejected", err)
+	}
+}
+
+func TestRunDeltaUpload(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	src := writeFile(t, filepath.Join(t.TempDir(), "f"), "0123456789")
+	for _, args := range [][]string{nil, {src}, {"-block-size", "0", src, "blob"}} {
+		if err := runDeltaUpload(context.Background(), az, args); err == nil {
+			t.Errorf("delta-upload %q succeeded", args)
+		}
+	}
+	var err error
+	out := captureStdout(t, func() {
+		err = runDeltaUpload(context.Background(), az, []string{"-block-size", "4", src, "blob"})
+	})
+	if err != nil || !strings.Contains(out, "sent 3 of 3 blocks") {
+		t.Errorf("delta-upload printed %q, %v", out, err)
+	}
+}
@@ -0,0 +1,67 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// defaultDeviceCodeTimeout is how long the CLI waits for somebody to
+// complete a device code sign-in. Azure AD expires device codes after 15
+// minutes as well.
+const defaultDeviceCodeTimeout = 15 * time.Minute
+
+// ErrAuthTimedOut is returned, wrapped, when nobody completes a device code
+// sign-in within AzureBlobCredentialOptions.DeviceCodeTimeout.
+var ErrAuthTimedOut = errors.New("device code sign-in timed out")
+
+// deviceCodeTimeout returns the device code timeout credOpts configure, or 0
+// for none.
+func deviceCodeTimeout(credOpts *AzureBlobCredentialOptions) time.Duration {
+	if credOpts == nil {
+		return 0
+	}
+	return credOpts.DeviceCodeTimeout
+}
+
+// deviceCodeWait bounds the wait of a device code credential for the sign-in
+// by timeout, if positive, and returns as soon as its context is done. The
+// SDK's credential only notices cancellation at its next poll, which may be
+// seconds away; it is left to finish in the background.
+type deviceCodeWait struct {
+	cred    azcore.TokenCredential
+	timeout time.Duration
+}
+
+func (d deviceCodeWait) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (*azcore.AccessToken, error) {
+	waitCtx := ctx
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+	type result struct {
+		token *azcore.AccessToken
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		token, err := d.cred.GetToken(waitCtx, opts)
+		done <- result{token, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err == nil || waitCtx.Err() == nil {
+			return r.token, r.err
+		}
+	case <-waitCtx.Done():
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%w after %s", ErrAuthTimedOut, d.timeout)
+}
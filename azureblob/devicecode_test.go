@@ -0,0 +1,61 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// pollingCredential imitates the SDK's device code credential, which only
+// notices that its context is done when it next polls.
+type pollingCredential struct {
+	signedIn chan struct{}
+	poll     time.Duration
+}
+
+func (c pollingCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (*azcore.AccessToken, error) {
+	for {
+		select {
+		case <-c.signedIn:
+			return &azcore.AccessToken{Token: "token"}, nil
+		default:
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		time.Sleep(c.poll)
+	}
+}
+
+func TestDeviceCodeWait(t *testing.T) {
+	signedIn := make(chan struct{})
+	close(signedIn)
+	cred := deviceCodeWait{pollingCredential{signedIn, time.Hour}, time.Minute}
+	if token, err := cred.GetToken(context.Background(), policy.TokenRequestOptions{}); err != nil || token.Token != "token" {
+		t.Errorf("signed in: GetToken = %v, %v", token, err)
+	}
+
+	pending := pollingCredential{make(chan struct{}), time.Hour}
+	start := time.Now()
+	_, err := deviceCodeWait{pending, 20 * time.Millisecond}.GetToken(context.Background(), policy.TokenRequestOptions{})
+	if !errors.Is(err, ErrAuthTimedOut) || err.Error() != "device code sign-in timed out after 20ms" {
+		t.Errorf("timed out: GetToken = %v", err)
+	}
+	if exitCode(err) != exitAuth {
+		t.Errorf("exitCode(%v) = %d, want %d", err, exitCode(err), exitAuth)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err = deviceCodeWait{pending, time.Minute}.GetToken(ctx, policy.TokenRequestOptions{})
+	if !errors.Is(err, context.Canceled) || errors.Is(err, ErrAuthTimedOut) {
+		t.Errorf("cancelled: GetToken = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("GetToken waited %s for the next poll", elapsed)
+	}
+}
@@ -0,0 +1,237 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DiffStatus says how a local file and the blob of the same name differ.
+type DiffStatus string
+
+const (
+	// DiffAdded is a local file with no blob.
+	DiffAdded DiffStatus = "added"
+	// DiffChanged is a file whose size or MD5 differs from its blob's.
+	DiffChanged DiffStatus = "changed"
+	// DiffMissing is a blob with no local file.
+	DiffMissing DiffStatus = "missing"
+	// DiffUnchecked is a file of the same size as its blob whose content
+	// cannot be compared: the blob has no Content-MD5, or is client-side
+	// encrypted, whatever its size.
+	DiffUnchecked DiffStatus = "unchecked"
+)
+
+// diffMarks prefix the entries of the text output.
+var diffMarks = map[DiffStatus]string{DiffAdded: "+", DiffChanged: "~", DiffMissing: "-", DiffUnchecked: "?"}
+
+// DiffEntry is a difference between a local tree and a blob prefix. Name is
+// the path relative to both. Sizes are -1 where there is no file or blob.
+type DiffEntry struct {
+	Name       string     `json:"name"`
+	Status     DiffStatus `json:"status"`
+	LocalSize  int64      `json:"localSize"`
+	RemoteSize int64      `json:"remoteSize"`
+}
+
+// Diff compares the regular files under dir with the blobs under prefix,
+// matching each file to the blob named prefix plus its slash-separated path
+// relative to dir. Files and blobs of the same size are compared by MD5
+// where the blob has a Content-MD5, which takes a request per file. Nothing
+// is transferred. Identical files are not reported; the entries are sorted
+// by name.
+func (c *AzureBlobClient) Diff(ctx context.Context, dir, prefix string) ([]DiffEntry, error) {
+	return diffBackend(ctx, c, c.clientOptions(), dir, prefix)
+}
+
+// diffBackend is Diff against the blobs of backend, which downloads decode
+// as o asks. The files matching a blob are compared concurrently, a core
+// each, as comparing is mostly hashing.
+func diffBackend(ctx context.Context, backend Backend, o *AzureBlobClientOptions, dir, prefix string) ([]DiffEntry, error) {
+	blobs, err := backend.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	dir = localRoot(dir)
+	remote := map[string]*BlobProperties{}
+	for _, b := range blobs {
+		remote[strings.TrimPrefix(b.Name, prefix)] = b
+	}
+	type comparison struct {
+		entry DiffEntry
+		path  string
+		blob  *BlobProperties
+	}
+	var (
+		entries []DiffEntry
+		matched []comparison
+	)
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		entry := DiffEntry{Name: name, LocalSize: info.Size(), RemoteSize: -1}
+		b, ok := remote[name]
+		delete(remote, name)
+		if !ok {
+			entry.Status = DiffAdded
+			entries = append(entries, entry)
+			return nil
+		}
+		entry.RemoteSize = b.Size
+		matched = append(matched, comparison{entry, p, b})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	errs := hashPool.Run(ctx, len(matched), func(ctx context.Context, i int) error {
+		m := &matched[i]
+		var err error
+		m.entry.Status, err = compareFile(ctx, backend, o, m.path, m.entry.LocalSize, m.blob)
+		return err
+	})
+	for i, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+		if matched[i].entry.Status != "" {
+			entries = append(entries, matched[i].entry)
+		}
+	}
+	for name, b := range remote {
+		entries = append(entries, DiffEntry{Name: name, Status: DiffMissing, LocalSize: -1, RemoteSize: b.Size})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// compareFile returns how the file at path, of size bytes, differs from
+// blob b of backend, as listed, or "" if it does not.
+func compareFile(ctx context.Context, backend Backend, o *AzureBlobClientOptions, path string, size int64, b *BlobProperties) (DiffStatus, error) {
+	if size != b.Size || len(b.ContentMD5) == 0 {
+		// Listings carry no metadata, and this SDK version drops their
+		// Content-MD5, so the blob's own properties are needed to tell
+		// whether it is client-side encrypted or compressed, making its size
+		// that of the stored bytes, and to compare content. Encryption always changes
+		// the size, so a blob of the same size listed with an MD5 is not
+		// encrypted.
+		var err error
+		if b, err = backend.Stat(ctx, b.Name); err != nil {
+			return "", err
+		}
+	}
+	if _, _, encrypted := findEncryptionData(b.Metadata); encrypted || o.downloadCompression(b) != "" {
+		return DiffUnchecked, nil
+	}
+	if size != b.Size {
+		return DiffChanged, nil
+	}
+	if len(b.ContentMD5) == 0 {
+		return DiffUnchecked, nil
+	}
+	sums, err := fileDigests(path, []string{"md5"})
+	if err != nil {
+		return "", err
+	}
+	if sums["md5"] != hex.EncodeToString(b.ContentMD5) {
+		return DiffChanged, nil
+	}
+	return "", nil
+}
+
+// errDifferences is returned by diff -exit-code when there are differences.
+var errDifferences = errors.New("the local tree and the blobs differ")
+
+func runDiff(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the differences as JSON; the same as -output json")
+	output := outputFlag(fs)
+	exitCode := fs.Bool("exit-code", false, "fail if there are differences, as diff(1) does")
+	location := fs.String("backend", "", "compare with the container or bucket at `url`, e.g. azure://account/container")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: diff [flags] <directory> [prefix]\n\nThe prefix defaults to that of -path-template, if given.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return errors.New("diff takes a directory and optionally a prefix")
+	}
+	if *asJSON {
+		*output = outputJSON
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	ref := fs.Arg(1)
+	if t := az.clientOptions().PathTemplate; ref == "" && t != nil {
+		var err error
+		if ref, err = t.Prefix(); err != nil {
+			return err
+		}
+	}
+	remote, prefix, err := az.resolveRemote(ref)
+	if err != nil {
+		return err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	var backend Backend = remote
+	if *location != "" {
+		if remote != az {
+			return errors.New("diff takes either -backend or a remote:prefix")
+		}
+		if backend, err = NewBackends(az).Open(*location); err != nil {
+			return err
+		}
+	}
+	entries, err := diffBackend(ctx, backend, az.clientOptions(), fs.Arg(0), prefix)
+	if err != nil {
+		return err
+	}
+	switch *output {
+	case outputJSON:
+		if entries == nil {
+			entries = []DiffEntry{}
+		}
+		if err := printJSON(entries); err != nil {
+			return err
+		}
+	case outputCSV:
+		rows := make([][]string, len(entries))
+		for i, e := range entries {
+			rows[i] = []string{e.Name, string(e.Status), strconv.FormatInt(e.LocalSize, 10), strconv.FormatInt(e.RemoteSize, 10)}
+		}
+		if err := printCSV([]string{"name", "status", "localSize", "remoteSize"}, rows); err != nil {
+			return err
+		}
+	default:
+		for _, e := range entries {
+			fmt.Printf("%s %s\n", diffMarks[e.Status], e.Name)
+		}
+	}
+	if *exitCode && len(entries) > 0 {
+		return errDifferences
+	}
+	return nil
+}
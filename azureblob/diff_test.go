@@ -0,0 +1,107 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	m := newMemContainer()
+	m.put("site/same.txt", []byte("same"), nil)
+	m.put("site/sub/edited.txt", []byte("before"), nil)
+	m.put("site/resized.txt", []byte("short"), nil)
+	m.put("site/gone.txt", []byte("gone"), nil)
+	m.put("site/secret.bin", []byte("ciphertext and tag"), map[string]string{"encryptiondata": "{}"})
+	m.put("site/no-md5.txt", []byte("abc"), nil)
+	m.put("other/ignored.txt", []byte("x"), nil)
+	az := newTestClient(t, withoutMD5(m, "site/no-md5.txt"))
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "same.txt"), "same")
+	writeFile(t, filepath.Join(dir, "sub", "edited.txt"), "after!")
+	writeFile(t, filepath.Join(dir, "resized.txt"), "longer content")
+	writeFile(t, filepath.Join(dir, "new.txt"), "new")
+	writeFile(t, filepath.Join(dir, "secret.bin"), "plain")
+	writeFile(t, filepath.Join(dir, "no-md5.txt"), "xyz")
+
+	entries, err := az.Diff(context.Background(), dir, "site/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]DiffStatus{}
+	for _, e := range entries {
+		got[e.Name] = e.Status
+	}
+	want := map[string]DiffStatus{
+		"gone.txt":       DiffMissing,
+		"new.txt":        DiffAdded,
+		"resized.txt":    DiffChanged,
+		"secret.bin":     DiffUnchecked,
+		"sub/edited.txt": DiffChanged,
+		"no-md5.txt":     DiffUnchecked,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i-1].Name > entries[i].Name {
+			t.Errorf("entries not sorted: %v", entries)
+		}
+	}
+}
+
+// withoutMD5 serves m, but without the Content-MD5 of blob, as for blobs
+// uploaded in blocks.
+func withoutMD5(m *memContainer, blob string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.TrimPrefix(r.URL.Path, "/container/") != blob {
+			m.ServeHTTP(w, r)
+			return
+		}
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, r)
+		for k, v := range rec.Header() {
+			if k != "Content-Md5" {
+				w.Header()[k] = v
+			}
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	})
+}
+
+func TestRunDiff(t *testing.T) {
+	m := newMemContainer()
+	m.put("p/a", []byte("a"), nil)
+	az := newTestClient(t, m)
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a"), "a")
+	writeFile(t, filepath.Join(dir, "b"), "b")
+	var err error
+	out := captureStdout(t, func() { err = runDiff(context.Background(), az, []string{dir, "p"}) })
+	if err != nil || out != "+ b\n" {
+		t.Errorf("text: %q, %v", out, err)
+	}
+	out = captureStdout(t, func() { err = runDiff(context.Background(), az, []string{"-json", "-exit-code", dir, "p"}) })
+	if err != errDifferences {
+		t.Errorf("-exit-code: got %v", err)
+	}
+	var entries []DiffEntry
+	if jsonErr := json.Unmarshal([]byte(out), &entries); jsonErr != nil {
+		t.Fatal(jsonErr)
+	}
+	if len(entries) != 1 || entries[0] != (DiffEntry{Name: "b", Status: DiffAdded, LocalSize: 1, RemoteSize: -1}) {
+		t.Errorf("json: %+v", entries)
+	}
+	writeFile(t, filepath.Join(dir, "b"), "")
+	m.put("p/b", nil, nil)
+	out = captureStdout(t, func() { err = runDiff(context.Background(), az, []string{"-json", "-exit-code", dir, "p"}) })
+	if err != nil || strings.TrimSpace(out) != "[]" {
+		t.Errorf("identical trees: %q, %v", out, err)
+	}
+}
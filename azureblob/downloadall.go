@@ -0,0 +1,64 @@
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DownloadResult is the outcome of one download of DownloadAll.
+type DownloadResult struct {
+	// Destination is the file the blob was downloaded to.
+	Destination string
+	// Result describes the transfer if it succeeded.
+	Result *TransferResult
+	// Err is why the download failed, or nil.
+	Err error
+}
+
+// DownloadAll downloads every blob of downloads, a map of blob names to
+// destination files, concurrently as bounded by c.Pool, creating missing
+// parent directories. The progress of all of them is combined in
+// c.Progress, which is created if unset. Each download is logged as it
+// finishes, and each failure printed to stderr; all blobs are attempted
+// even if some fail. It returns the outcome of every download by blob
+// name, and an error counting the failures if there are any.
+func (c *AzureBlobClient) DownloadAll(ctx context.Context, downloads map[string]string) (map[string]*DownloadResult, error) {
+	blobs := make([]string, 0, len(downloads))
+	for blob := range downloads {
+		blobs = append(blobs, blob)
+	}
+	sort.Strings(blobs)
+	results := make(map[string]*DownloadResult, len(blobs))
+	for _, blob := range blobs {
+		results[blob] = &DownloadResult{Destination: downloads[blob]}
+	}
+	c.shareProgress()
+	errs := c.Pool.Run(ctx, len(blobs), func(ctx context.Context, i int) error {
+		r := results[blobs[i]]
+		if err := os.MkdirAll(filepath.Dir(r.Destination), 0755); err != nil {
+			return err
+		}
+		result, err := c.Download(ctx, blobs[i], r.Destination)
+		if err != nil {
+			return err
+		}
+		r.Result = result
+		c.logTransfer("download", result)
+		return nil
+	})
+	failures := &transferFailures{total: len(blobs), noun: "downloads"}
+	for i, err := range errs {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			results[blobs[i]].Err = err
+			failures.errs = append(failures.errs, err)
+		}
+	}
+	if len(failures.errs) > 0 {
+		return results, failures
+	}
+	return results, nil
+}
@@ -0,0 +1,43 @@
+package azureblob
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDownloadAllDestinations(t *testing.T) {
+	m := newMemContainer()
+	m.put("config/app.yaml", []byte("config"), nil)
+	m.put("bin/tool", []byte("tool-binary"), nil)
+	az := newTestClient(t, m)
+	az.Pool = NewTransferPool(2, 2)
+	root := t.TempDir()
+	downloads := map[string]string{
+		"config/app.yaml": filepath.Join(root, "etc", "app.yaml"),
+		"bin/tool":        filepath.Join(root, "usr", "local", "bin", "tool"),
+		"missing":         filepath.Join(root, "missing"),
+	}
+
+	results, err := az.DownloadAll(context.Background(), downloads)
+	if err == nil || err.Error() != "1 of 3 downloads failed" || exitCode(err) != exitNotFound {
+		t.Errorf("DownloadAll = %v", err)
+	}
+	for blob, want := range map[string]string{"config/app.yaml": "config", "bin/tool": "tool-binary"} {
+		r := results[blob]
+		if r == nil || r.Err != nil || r.Result == nil || r.Result.Bytes != int64(len(want)) || r.Destination != downloads[blob] {
+			t.Errorf("%s: %+v", blob, r)
+			continue
+		}
+		if got, err := os.ReadFile(downloads[blob]); err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", downloads[blob], got, err, want)
+		}
+	}
+	if r := results["missing"]; r == nil || !isNotFound(r.Err) || r.Result != nil {
+		t.Errorf("missing: %+v", r)
+	}
+	if s := az.Progress.Snapshot(); s.Completed != 2 || s.Outstanding != 0 || s.BytesTransferred != int64(len("config")+len("tool-binary")) {
+		t.Errorf("combined progress %+v", s)
+	}
+}
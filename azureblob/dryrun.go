@@ -0,0 +1,118 @@
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// PlannedTransfer is what a dry run found one transfer or deletion would do.
+// Size is the number of bytes the file or blob holds; Error is set for items
+// that would fail, e.g. because the file or blob does not exist.
+type PlannedTransfer struct {
+	Direction string       `json:"direction"`
+	Item      ManifestItem `json:"item"`
+	Size      int64        `json:"size"`
+	Error     string       `json:"error,omitempty"`
+	err       error
+}
+
+// PlanManifest returns what RunManifest would do for m without transferring
+// anything, one entry per item, downloads first. Uploads are sized and
+// checked against their digests locally; downloads are sized by looking the
+// blob up, in the fallback too if it is missing from the item's Azure
+// container.
+func (c *AzureBlobClient) PlanManifest(ctx context.Context, m *Manifest) []PlannedTransfer {
+	plans := make([]PlannedTransfer, 0, len(m.Downloads)+len(m.Uploads))
+	for _, item := range m.Downloads {
+		plans = append(plans, PlannedTransfer{Direction: "download", Item: item})
+	}
+	for _, item := range m.Uploads {
+		plans = append(plans, PlannedTransfer{Direction: "upload", Item: item})
+	}
+	backends := c.manifestBackends(append(append([]ManifestItem(nil), m.Downloads...), m.Uploads...))
+	errs := c.Pool.Run(ctx, len(plans), func(ctx context.Context, i int) error {
+		p := &plans[i]
+		if p.Direction == "upload" {
+			if err := p.Item.verify(); err != nil {
+				return err
+			}
+			info, err := os.Stat(p.Item.Path)
+			if err != nil {
+				return err
+			}
+			p.Size = info.Size()
+			return nil
+		}
+		b, err := c.itemBackend(backends, p.Item)
+		if err != nil {
+			return err
+		}
+		if az, ok := b.(*AzureBlobClient); ok {
+			p.Size, err = az.blobSize(ctx, p.Item.Blob)
+			return err
+		}
+		props, err := b.Stat(ctx, p.Item.Blob)
+		if err != nil {
+			return err
+		}
+		p.Size = props.Size
+		return nil
+	})
+	for i, err := range errs {
+		if err != nil {
+			plans[i].Error = err.Error()
+			plans[i].err = err
+		}
+	}
+	return plans
+}
+
+// blobSize returns the size of blob, looking in the chain of fallbacks like
+// Download if it is missing from c's container.
+func (c *AzureBlobClient) blobSize(ctx context.Context, blob string) (int64, error) {
+	props, err := c.Stat(ctx, blob)
+	if isNotFound(err) && c.Fallback != nil {
+		c.Fallback.inherit(c)
+		return c.Fallback.blobSize(ctx, blob)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return props.Size, nil
+}
+
+// printPlan prints a line per planned item and the number and total size of
+// those that would succeed, returning a *transferFailures naming noun if any
+// would fail.
+func printPlan(msgs Messages, plans []PlannedTransfer, noun string) error {
+	failures := &transferFailures{total: len(plans), noun: noun}
+	var total int64
+	for _, p := range plans {
+		status := formatBytes(p.Size)
+		if p.err != nil {
+			status = msgs.format(MsgResultFailed, p.Error)
+			failures.errs = append(failures.errs, p.err)
+		} else {
+			total += p.Size
+		}
+		switch p.Direction {
+		case "download":
+			fmt.Println(msgs.format(MsgWouldDownload, p.Item.Blob, p.Item.Path, status))
+		case "upload":
+			fmt.Println(msgs.format(MsgWouldUpload, p.Item.Path, p.Item.Blob, status))
+		default:
+			fmt.Println(msgs.format(MsgWouldDelete, p.Item.Blob, status))
+		}
+	}
+	fmt.Println(msgs.format(MsgDryRunTotal, len(plans)-len(failures.errs), noun, formatBytes(total)))
+	if len(failures.errs) > 0 {
+		return failures
+	}
+	return nil
+}
+
+// planTransfers prints what runTransfers would do for m.
+func planTransfers(ctx context.Context, az *AzureBlobClient, m *Manifest) error {
+	return printPlan(az.Messages, az.PlanManifest(ctx, m), "planned transfers")
+}
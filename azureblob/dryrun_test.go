@@ -0,0 +1,116 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlanManifest(t *testing.T) {
+	current, old := newMemContainer(), newMemContainer()
+	current.put("a", []byte("abc"), nil)
+	old.put("b", []byte("abcdefgh"), nil)
+	az := newTestClient(t, &containers{byName: map[string]*memContainer{"container": current, "old": old}, accounts: map[string]bool{}})
+	az.WithFallback(az.StorageAccount, "old")
+	dir := t.TempDir()
+	upload := writeFile(t, filepath.Join(dir, "upload"), "hello")
+	m := &Manifest{
+		Downloads: []ManifestItem{
+			{Blob: "a", Path: filepath.Join(dir, "out", "a")},
+			{Blob: "b", Path: filepath.Join(dir, "out", "b")},
+			{Blob: "missing", Path: filepath.Join(dir, "out", "missing")},
+		},
+		Uploads: []ManifestItem{
+			{Blob: "up", Path: upload},
+			{Blob: "bad", Path: upload, SHA256: sha256Hex([]byte("other"))},
+			{Blob: "gone", Path: filepath.Join(dir, "gone")},
+		},
+	}
+	plans := az.PlanManifest(context.Background(), m)
+	want := []struct {
+		size   int64
+		failed bool
+	}{{3, false}, {8, false}, {0, true}, {5, false}, {0, true}, {0, true}}
+	if len(plans) != len(want) {
+		t.Fatalf("got %d plans, want %d", len(plans), len(want))
+	}
+	for i, w := range want {
+		if plans[i].Size != w.size || (plans[i].err != nil) != w.failed {
+			t.Errorf("%s %s: got size %d, error %v", plans[i].Direction, plans[i].Item.Blob, plans[i].Size, plans[i].err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out")); !os.IsNotExist(err) {
+		t.Error("dry run created the download directory")
+	}
+	if len(current.blobs) != 1 {
+		t.Error("dry run uploaded blobs")
+	}
+}
+
+func TestRunManifestDryRun(t *testing.T) {
+	m := newMemContainer()
+	m.put("a", make([]byte, 2048), nil)
+	az := newTestClient(t, m)
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "up"), "hello")
+	manifest := writeFile(t, filepath.Join(dir, "m.yaml"), "downloads:\n- blob: a\n  path: a\nuploads:\n- blob: up\n  path: up\n")
+	report := filepath.Join(dir, "report.json")
+
+	var err error
+	out := captureStdout(t, func() {
+		err = runManifest(context.Background(), az, []string{"-dry-run", "-report", report, manifest})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"would download a -> " + filepath.Join(dir, "a") + ": 2.0 KiB",
+		"would upload " + filepath.Join(dir, "up") + " -> up: 5 B",
+		"dry run: 2 planned transfers, 2.0 KiB in total; nothing was changed",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("output lacks %q:\n%s", line, out)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a")); !os.IsNotExist(err) {
+		t.Error("dry run downloaded a")
+	}
+	if _, ok := m.blobs["up"]; ok {
+		t.Error("dry run uploaded up")
+	}
+	var plans []PlannedTransfer
+	if err := json.Unmarshal([]byte(readFile(t, report)), &plans); err != nil {
+		t.Fatal(err)
+	}
+	if len(plans) != 2 || plans[0].Size != 2048 || plans[1].Size != 5 {
+		t.Errorf("report: %+v", plans)
+	}
+}
+
+func TestRunDownloadDryRun(t *testing.T) {
+	m := newMemContainer()
+	m.put("x/a", []byte("a"), nil)
+	az := newTestClient(t, m)
+	dir := t.TempDir()
+
+	var err error
+	out := captureStdout(t, func() {
+		err = runDownload(context.Background(), az, []string{"-dry-run", "x/a", "../missing", dir})
+	})
+	if err == nil || err.Error() != "1 of 2 planned transfers failed" || exitCode(err) != exitNotFound {
+		t.Errorf("got %v (exit code %d)", err, exitCode(err))
+	}
+	if !strings.Contains(out, "would download x/a -> "+filepath.Join(dir, "x", "a")+": 1 B") ||
+		!strings.Contains(out, "would download ../missing -> "+filepath.Join(dir, "missing")+": FAILED") {
+		t.Errorf("output:\n%s", out)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("dry run wrote %d entries", len(entries))
+	}
+	if err := runDownload(context.Background(), az, []string{"-dry-run", "-state", "s", "x/a", "a"}); err == nil {
+		t.Error("-dry-run was accepted with -state")
+	}
+}
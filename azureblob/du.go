@@ -0,0 +1,120 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// UsageEntry is the storage used under one prefix "directory".
+type UsageEntry struct {
+	// Prefix ends in a slash, except for the entry of the whole listing,
+	// whose prefix is the one listed.
+	Prefix string `json:"prefix"`
+	Size   int64  `json:"size"`
+	Blobs  int    `json:"blobs"`
+}
+
+// summarizeUsage totals the sizes of blobs, listed under prefix, for the
+// prefix itself and every directory up to depth levels below it, like du.
+// Each directory includes everything under it. Entries are sorted by prefix,
+// with the total first.
+func summarizeUsage(blobs []*BlobProperties, prefix string, depth int) []UsageEntry {
+	total := &UsageEntry{Prefix: prefix}
+	dirs := map[string]*UsageEntry{}
+	for _, b := range blobs {
+		total.Size += b.Size
+		total.Blobs++
+		elems := strings.Split(strings.TrimPrefix(b.Name, prefix), "/")
+		// The last element is the blob itself.
+		for i := 1; i < len(elems) && i <= depth; i++ {
+			dir := prefix + strings.Join(elems[:i], "/") + "/"
+			e := dirs[dir]
+			if e == nil {
+				e = &UsageEntry{Prefix: dir}
+				dirs[dir] = e
+			}
+			e.Size += b.Size
+			e.Blobs++
+		}
+	}
+	entries := []UsageEntry{*total}
+	for _, e := range dirs {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries[1:], func(i, j int) bool { return entries[i+1].Prefix < entries[j+1].Prefix })
+	return entries
+}
+
+func runDu(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("du", flag.ContinueOnError)
+	depth := fs.Int("depth", 1, "number of directory levels below the prefix to report")
+	human := fs.Bool("human", false, "print sizes with units, e.g. 1.5 GiB")
+	bySize := fs.Bool("sort-size", false, "sort directories by size, largest first, instead of by name")
+	output := outputFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: du [flags] [prefix]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("du takes at most one prefix")
+	}
+	if *depth < 0 {
+		return fmt.Errorf("-depth must not be negative, got %d", *depth)
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	az, prefix, err := az.resolveRemote(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	// Like du on a directory, a prefix names a directory, so "logs" does not
+	// also count "logs-old/".
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	blobs, err := az.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	entries := summarizeUsage(blobs, prefix, *depth)
+	if *bySize {
+		sort.SliceStable(entries[1:], func(i, j int) bool { return entries[i+1].Size > entries[j+1].Size })
+	}
+	switch *output {
+	case outputJSON:
+		// The total comes first, as it is listed first.
+		return printJSON(entries)
+	case outputCSV:
+		rows := make([][]string, len(entries))
+		for i, e := range entries {
+			rows[i] = []string{e.Prefix, strconv.FormatInt(e.Size, 10), strconv.Itoa(e.Blobs)}
+		}
+		return printCSV([]string{"prefix", "size", "blobs"}, rows)
+	}
+	size := func(n int64) string { return strconv.FormatInt(n, 10) }
+	if *human {
+		size = formatBytes
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	// Directories first, then the total, as du prints them.
+	for _, e := range append(entries[1:], entries[0]) {
+		name := e.Prefix
+		if name == "" {
+			name = "."
+		}
+		fmt.Fprintf(w, "%s\t%d blobs\t  %s\n", size(e.Size), e.Blobs, name)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,92 @@
+package azureblob
+
+import (
+	"context"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSummarizeUsage(t *testing.T) {
+	blobs := []*BlobProperties{
+		{Name: "builds/1/app.pkg", Size: 100},
+		{Name: "builds/1/logs/build.log", Size: 10},
+		{Name: "builds/2/app.pkg", Size: 200},
+		{Name: "builds/README", Size: 1},
+	}
+	tests := []struct {
+		depth int
+		want  []UsageEntry
+	}{
+		{0, []UsageEntry{{"builds/", 311, 4}}},
+		{1, []UsageEntry{{"builds/", 311, 4}, {"builds/1/", 110, 2}, {"builds/2/", 200, 1}}},
+		{2, []UsageEntry{{"builds/", 311, 4}, {"builds/1/", 110, 2}, {"builds/1/logs/", 10, 1}, {"builds/2/", 200, 1}}},
+	}
+	for _, tt := range tests {
+		if got := summarizeUsage(blobs, "builds/", tt.depth); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("depth %d: got %v, want %v", tt.depth, got, tt.want)
+		}
+	}
+	if got := summarizeUsage(nil, "", 1); !reflect.DeepEqual(got, []UsageEntry{{"", 0, 0}}) {
+		t.Errorf("empty listing: got %v", got)
+	}
+}
+
+// captureStdout returns what fn writes to os.Stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	done := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- string(b)
+	}()
+	fn()
+	w.Close()
+	return <-done
+}
+
+func TestRunDu(t *testing.T) {
+	m := newMemContainer()
+	m.put("logs/a.txt", []byte("aaaa"), nil)
+	m.put("pkgs/big.pkg", make([]byte, 2048), nil)
+	m.put("pkgs/small/x.pkg", []byte("x"), nil)
+	m.put("pkgsold/y", []byte("y"), nil)
+	az := newTestClient(t, m)
+	var err error
+	out := captureStdout(t, func() { err = runDu(context.Background(), az, []string{"-human", "-sort-size"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	want := [][]string{
+		{"2.0 KiB", "2 blobs", "pkgs/"},
+		{"4 B", "1 blobs", "logs/"},
+		{"1 B", "1 blobs", "pkgsold/"},
+		{"2.0 KiB", "4 blobs", "."},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("output:\n%s", out)
+	}
+	for i, line := range lines {
+		if fields := strings.Fields(line); strings.Join(fields, " ") != strings.Join(want[i], " ") {
+			t.Errorf("line %d = %q, want %q", i, line, strings.Join(want[i], " "))
+		}
+	}
+
+	out = captureStdout(t, func() { err = runDu(context.Background(), az, []string{"-depth", "0", "pkgs"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields := strings.Fields(out); strings.Join(fields, " ") != "2049 2 blobs pkgs/" {
+		t.Errorf("prefix total: %q", out)
+	}
+}
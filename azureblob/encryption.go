@@ -0,0 +1,364 @@
+package azureblob
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// encryptionMetadataKey is the metadata entry in which the Azure Storage SDKs
+// record how a client-side encrypted blob was encrypted.
+const encryptionMetadataKey = "encryptiondata"
+
+// errNotEncrypted is returned for blobs that carry no encryption metadata.
+var errNotEncrypted = errors.New("blob is not client-side encrypted")
+
+// encryptionData is the JSON stored under encryptionMetadataKey. Protocol 1.0
+// encrypts the whole blob with AES-CBC; protocol 2.0 encrypts it in regions of
+// EncryptedRegionInfo.DataLength bytes with AES-GCM.
+type encryptionData struct {
+	EncryptionMode      string               `json:"EncryptionMode,omitempty"`
+	WrappedContentKey   wrappedContentKey    `json:"WrappedContentKey"`
+	EncryptionAgent     encryptionAgent      `json:"EncryptionAgent"`
+	ContentEncryptionIV []byte               `json:"ContentEncryptionIV,omitempty"`
+	EncryptedRegionInfo *encryptedRegionInfo `json:"EncryptedRegionInfo,omitempty"`
+}
+
+type encryptionAgent struct {
+	Protocol            string `json:"Protocol"`
+	EncryptionAlgorithm string `json:"EncryptionAlgorithm"`
+}
+
+type encryptedRegionInfo struct {
+	DataLength  int `json:"DataLength"`
+	NonceLength int `json:"NonceLength"`
+}
+
+// maxRegionLength is the region length of protocol 2.0, and the largest
+// that decryptGCMRegions accepts from a blob's metadata.
+const maxRegionLength = 4 << 20
+
+// uploadRegionInfo is the region layout of uploads encrypted by this tool:
+// the 4 MiB regions and 12-byte nonces the Azure Storage SDKs write.
+var uploadRegionInfo = encryptedRegionInfo{DataLength: maxRegionLength, NonceLength: 12}
+
+// protocolV2Prefix is prepended to protocol 2.0 content keys before they are
+// wrapped, binding the protocol version to the key.
+var protocolV2Prefix = []byte("2.0\x00\x00\x00\x00\x00")
+
+// findEncryptionData returns the name and value of the encryption metadata
+// entry. The service may return metadata names in any case, so the lookup
+// ignores it.
+func findEncryptionData(metadata map[string]string) (name, value string, ok bool) {
+	for k, v := range metadata {
+		if strings.EqualFold(k, encryptionMetadataKey) {
+			return k, v, true
+		}
+	}
+	return "", "", false
+}
+
+// encryptionDataFromMetadata parses the encryption metadata of a blob, or
+// returns errNotEncrypted if there is none.
+func encryptionDataFromMetadata(metadata map[string]string) (*encryptionData, error) {
+	_, value, ok := findEncryptionData(metadata)
+	if !ok {
+		return nil, errNotEncrypted
+	}
+	data := &encryptionData{}
+	if err := json.Unmarshal([]byte(value), data); err != nil {
+		return nil, fmt.Errorf("parse %s metadata: %w", encryptionMetadataKey, err)
+	}
+	return data, nil
+}
+
+// contentKey unwraps the content key of data with a KEK from r.
+func (r *KeyRing) contentKey(ctx context.Context, data *encryptionData) ([]byte, error) {
+	key, err := r.unwrap(ctx, &data.WrappedContentKey)
+	if err != nil {
+		return nil, err
+	}
+	if data.EncryptionAgent.Protocol == "2.0" {
+		if !bytes.HasPrefix(key, protocolV2Prefix) {
+			return nil, errors.New("content key does not carry the protocol 2.0 prefix")
+		}
+		key = key[len(protocolV2Prefix):]
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("content key is %d bytes, want 32", len(key))
+	}
+	return key, nil
+}
+
+// decryptContent writes the plaintext of the encrypted blob content src to
+// dst.
+func decryptContent(dst io.Writer, src io.Reader, data *encryptionData, key []byte) error {
+	if data.EncryptionMode != "" && data.EncryptionMode != "FullBlob" {
+		return fmt.Errorf("unsupported encryption mode %q", data.EncryptionMode)
+	}
+	agent := data.EncryptionAgent
+	switch {
+	case agent.Protocol == "1.0" && agent.EncryptionAlgorithm == "AES_CBC_256":
+		return decryptCBC(dst, src, key, data.ContentEncryptionIV)
+	case agent.Protocol == "2.0" && agent.EncryptionAlgorithm == "AES_GCM_256":
+		if data.EncryptedRegionInfo == nil {
+			return errors.New("protocol 2.0 encryption metadata has no EncryptedRegionInfo")
+		}
+		return decryptGCMRegions(dst, src, key, *data.EncryptedRegionInfo)
+	}
+	return fmt.Errorf("unsupported encryption protocol %q with %q", agent.Protocol, agent.EncryptionAlgorithm)
+}
+
+// decryptCBC decrypts AES-CBC ciphertext with PKCS#7 padding. The last block
+// is held back until the end of src so its padding can be removed.
+func decryptCBC(dst io.Writer, src io.Reader, key, iv []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	if len(iv) != aes.BlockSize {
+		return fmt.Errorf("content IV is %d bytes, want %d", len(iv), aes.BlockSize)
+	}
+	mode := cipher.NewCBCDecrypter(block, iv)
+	pooled := getBuffer(copyBufferSize)
+	defer putBuffer(pooled)
+	buf := *pooled
+	var last []byte
+	for {
+		n, err := io.ReadFull(src, buf)
+		if n%aes.BlockSize != 0 {
+			return errors.New("ciphertext is not a whole number of blocks")
+		}
+		if n > 0 {
+			mode.CryptBlocks(buf[:n], buf[:n])
+			if _, err := dst.Write(last); err != nil {
+				return err
+			}
+			if _, err := dst.Write(buf[:n-aes.BlockSize]); err != nil {
+				return err
+			}
+			last = append(last[:0], buf[n-aes.BlockSize:n]...)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(last) == 0 {
+		return errors.New("ciphertext is empty")
+	}
+	pad := int(last[aes.BlockSize-1])
+	if pad == 0 || pad > aes.BlockSize || !bytes.Equal(last[aes.BlockSize-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return errors.New("ciphertext has invalid padding")
+	}
+	_, err = dst.Write(last[:aes.BlockSize-pad])
+	return err
+}
+
+// decryptGCMRegions decrypts content encrypted in regions of
+// info.DataLength plaintext bytes, each stored as nonce || ciphertext || tag.
+func decryptGCMRegions(dst io.Writer, src io.Reader, key []byte, info encryptedRegionInfo) error {
+	// The layout comes from the blob's metadata, which must not choose
+	// how much to allocate.
+	if info.DataLength <= 0 || info.DataLength > maxRegionLength {
+		return fmt.Errorf("invalid encrypted region length %d, want at most %d", info.DataLength, maxRegionLength)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	if info.NonceLength != aead.NonceSize() {
+		return fmt.Errorf("invalid encrypted region nonce length %d, want %d", info.NonceLength, aead.NonceSize())
+	}
+	n := info.NonceLength
+	pooled := getBuffer(n + info.DataLength + aead.Overhead())
+	defer putBuffer(pooled)
+	region := *pooled
+	src = bufio.NewReader(src)
+	for i := 0; ; i++ {
+		size, err := io.ReadFull(src, region)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if size < n+aead.Overhead() {
+			return fmt.Errorf("encrypted region %d is truncated", i)
+		}
+		plain, openErr := aead.Open(region[n:n], region[:n], region[n:size], nil)
+		if openErr != nil {
+			return fmt.Errorf("encrypted region %d: %w", i, openErr)
+		}
+		if _, err := dst.Write(plain); err != nil {
+			return err
+		}
+		if err == io.ErrUnexpectedEOF {
+			return nil
+		}
+	}
+}
+
+// encryptGCMRegions encrypts src into regions of info.DataLength plaintext
+// bytes, each written as nonce || ciphertext || tag with a random nonce, the
+// layout decryptGCMRegions reads.
+func encryptGCMRegions(dst io.Writer, src io.Reader, key []byte, info encryptedRegionInfo) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCMWithNonceSize(block, info.NonceLength)
+	if err != nil {
+		return err
+	}
+	n := info.NonceLength
+	pooledPlain := getBuffer(info.DataLength)
+	defer putBuffer(pooledPlain)
+	pooledRegion := getBuffer(n + info.DataLength + aead.Overhead())
+	defer putBuffer(pooledRegion)
+	plain, region := *pooledPlain, (*pooledRegion)[:n]
+	for {
+		size, err := io.ReadFull(src, plain)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if _, err := io.ReadFull(rand.Reader, region[:n]); err != nil {
+			return err
+		}
+		if _, err := dst.Write(aead.Seal(region[:n], region[:n], plain[:size], nil)); err != nil {
+			return err
+		}
+		if err == io.ErrUnexpectedEOF {
+			return nil
+		}
+	}
+}
+
+// downloadDecoded downloads asset to a temporary file next to f and writes
+// its content to f, decoded as decodeDownload does, hashing the content into
+// h unless h is nil.
+func (c *AzureBlobClient) downloadDecoded(ctx context.Context, asset, etag string, size int64, data *encryptionData, compression string, f *os.File, h *multiHash) error {
+	if data != nil && c.Keys == nil {
+		return fmt.Errorf("download %q: blob is client-side encrypted and no key ring is configured", asset)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.Name()), ".download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := c.fetch(ctx, asset, etag, size, tmp, nil); err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	content, err := c.decodeDownload(ctx, asset, data, compression, tmp)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	w := bufio.NewWriter(f)
+	var dst io.Writer = w
+	if h != nil {
+		dst = io.MultiWriter(w, h)
+	}
+	if _, err := copyPooled(dst, content); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// decodeDownload returns a reader of the content of asset as read from src,
+// decrypted with data if it is client-side encrypted and then decompressed
+// if it was compressed on upload. The decoding runs as the reader is read;
+// closing it stops the decoding.
+func (c *AzureBlobClient) decodeDownload(ctx context.Context, asset string, data *encryptionData, compression string, src io.Reader) (io.ReadCloser, error) {
+	chain := &readerChain{Reader: src}
+	if data != nil {
+		if c.Keys == nil {
+			return nil, fmt.Errorf("download %q: blob is client-side encrypted and no key ring is configured", asset)
+		}
+		key, err := c.Keys.contentKey(ctx, data)
+		if err != nil {
+			return nil, fmt.Errorf("download %q: %w", asset, err)
+		}
+		pr, pw := io.Pipe()
+		go func() {
+			if err := decryptContent(pw, src, data, key); err != nil {
+				pw.CloseWithError(fmt.Errorf("decrypt %q: %w", asset, err))
+				return
+			}
+			pw.Close()
+		}()
+		chain.push(pr)
+	}
+	if compression != "" {
+		zr, err := decompressReader(chain.Reader, compression)
+		if err != nil {
+			chain.Close()
+			return nil, fmt.Errorf("decompress %q: %w", asset, err)
+		}
+		chain.push(zr)
+	}
+	return chain, nil
+}
+
+// uploadEncryption returns a new content key for an upload and the
+// metadata that records it. The content key is wrapped under the current KEK
+// of c.Keys with AES key wrap, so the blob can also be decrypted by the Azure
+// Storage SDKs.
+func (c *AzureBlobClient) uploadEncryption(ctx context.Context) ([]byte, map[string]string, error) {
+	if c.Keys == nil {
+		return nil, nil, errors.New("client-side encryption needs a key ring")
+	}
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := c.Keys.wrap(ctx, keyWrapAES, append(append([]byte(nil), protocolV2Prefix...), key...))
+	if err != nil {
+		return nil, nil, err
+	}
+	info := uploadRegionInfo
+	b, err := json.Marshal(&encryptionData{
+		EncryptionMode:      "FullBlob",
+		WrappedContentKey:   *wrapped,
+		EncryptionAgent:     encryptionAgent{Protocol: "2.0", EncryptionAlgorithm: "AES_GCM_256"},
+		EncryptedRegionInfo: &info,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, map[string]string{encryptionMetadataKey: string(b)}, nil
+}
+
+// encryptReader returns a reader of src encrypted with key in the regions of
+// uploadRegionInfo. The encryption runs as src is read; closing the reader
+// stops it.
+func encryptReader(src io.Reader, key []byte) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(encryptGCMRegions(pw, src, key, uploadRegionInfo))
+	}()
+	return pr
+}
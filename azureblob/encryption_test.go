@@ -0,0 +1,281 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func encryptionMetadata(t *testing.T, data *encryptionData) map[string]string {
+	t.Helper()
+	b, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Other SDKs add fields this tool does not read.
+	var fields map[string]json.RawMessage
+	json.Unmarshal(b, &fields)
+	fields["KeyWrappingMetadata"] = json.RawMessage(`{"EncryptionLibrary":"Test 1.0"}`)
+	b, _ = json.Marshal(fields)
+	return map[string]string{encryptionMetadataKey: string(b)}
+}
+
+// encryptV1 encrypts plain the way protocol 1.0 of the Azure Storage SDKs
+// does, under the current KEK of r.
+func encryptV1(t *testing.T, r *KeyRing, alg string, plain []byte) ([]byte, map[string]string) {
+	t.Helper()
+	cek, iv := testKey(7), bytes.Repeat([]byte{3}, aes.BlockSize)
+	block, _ := aes.NewCipher(cek)
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	ciphertext := append(append([]byte(nil), plain...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+	wrapped, err := r.wrap(context.Background(), alg, cek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ciphertext, encryptionMetadata(t, &encryptionData{
+		EncryptionMode:      "FullBlob",
+		WrappedContentKey:   *wrapped,
+		EncryptionAgent:     encryptionAgent{Protocol: "1.0", EncryptionAlgorithm: "AES_CBC_256"},
+		ContentEncryptionIV: iv,
+	})
+}
+
+// encryptV2 encrypts plain the way protocol 2.0 of the Azure Storage SDKs
+// does, in regions of regionSize bytes.
+func encryptV2(t *testing.T, r *KeyRing, alg string, plain []byte, regionSize int) ([]byte, map[string]string) {
+	t.Helper()
+	cek := testKey(8)
+	aead, _ := newGCM(cek)
+	var ciphertext []byte
+	for i := 0; i < len(plain); i += regionSize {
+		end := i + regionSize
+		if end > len(plain) {
+			end = len(plain)
+		}
+		nonce := bytes.Repeat([]byte{byte(i / regionSize)}, aead.NonceSize())
+		ciphertext = append(ciphertext, nonce...)
+		ciphertext = aead.Seal(ciphertext, nonce, plain[i:end], nil)
+	}
+	wrapped, err := r.wrap(context.Background(), alg, append(append([]byte(nil), protocolV2Prefix...), cek...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ciphertext, encryptionMetadata(t, &encryptionData{
+		WrappedContentKey:   *wrapped,
+		EncryptionAgent:     encryptionAgent{Protocol: "2.0", EncryptionAlgorithm: "AES_GCM_256"},
+		EncryptedRegionInfo: &encryptedRegionInfo{DataLength: regionSize, NonceLength: aead.NonceSize()},
+	})
+}
+
+func TestDownloadDecrypts(t *testing.T) {
+	old := mustKeyRing(t, "k1", map[string][]byte{"k1": testKey(1)})
+	plain := []byte(strings.Repeat("client-side encrypted ", 7))
+	tests := []struct {
+		name      string
+		plain     []byte
+		encrypted func([]byte) ([]byte, map[string]string)
+	}{
+		{"v1", plain, func(p []byte) ([]byte, map[string]string) { return encryptV1(t, old, keyWrapAES, p) }},
+		{"v1 empty", nil, func(p []byte) ([]byte, map[string]string) { return encryptV1(t, old, keyWrapAES, p) }},
+		{"v1 block aligned", plain[:32], func(p []byte) ([]byte, map[string]string) { return encryptV1(t, old, keyWrapAES, p) }},
+		{"v2 one region", plain, func(p []byte) ([]byte, map[string]string) { return encryptV2(t, old, keyWrapAES, p, 4<<20) }},
+		{"v2 several regions", plain, func(p []byte) ([]byte, map[string]string) { return encryptV2(t, old, keyWrapAES, p, 16) }},
+		{"v2 gcm key wrap", plain, func(p []byte) ([]byte, map[string]string) { return encryptV2(t, old, keyWrapGCM, p, 64) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMemContainer()
+			ciphertext, metadata := tt.encrypted(tt.plain)
+			m.put("blob", ciphertext, metadata)
+			az := newTestClient(t, m)
+			// A rotated ring still reads blobs wrapped under the old KEK.
+			az.Keys = mustKeyRing(t, "k2", map[string][]byte{"k1": testKey(1), "k2": testKey(2)})
+			dir := t.TempDir()
+			dest := filepath.Join(dir, "blob")
+			if _, err := az.Download(context.Background(), "blob", dest); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.plain) {
+				t.Errorf("downloaded %q, want %q", got, tt.plain)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+				t.Errorf("%d files left in the destination directory, want 1", len(entries))
+			}
+		})
+	}
+}
+
+func TestDownloadEncryptedErrors(t *testing.T) {
+	old := mustKeyRing(t, "k1", map[string][]byte{"k1": testKey(1)})
+	plain := []byte("secret")
+	ciphertext, metadata := encryptV2(t, old, keyWrapAES, plain, 16)
+	tests := []struct {
+		name    string
+		keys    *KeyRing
+		data    []byte
+		wantErr string
+	}{
+		{"no key ring", nil, ciphertext, "no key ring"},
+		{"unknown key", mustKeyRing(t, "k2", map[string][]byte{"k2": testKey(2)}), ciphertext, `"k1", which is not in the key ring`},
+		{"truncated content", old, ciphertext[:20], "truncated"},
+		{"tampered content", old, append(append([]byte(nil), ciphertext[:len(ciphertext)-1]...), ^ciphertext[len(ciphertext)-1]), "authentication failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMemContainer()
+			m.put("blob", tt.data, metadata)
+			az := newTestClient(t, m)
+			az.Keys = tt.keys
+			_, err := az.Download(context.Background(), "blob", filepath.Join(t.TempDir(), "blob"))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestContentKeyProtocolPrefix(t *testing.T) {
+	r := mustKeyRing(t, "k1", map[string][]byte{"k1": testKey(1)})
+	wrapped, err := r.wrap(context.Background(), keyWrapAES, testKey(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := &encryptionData{WrappedContentKey: *wrapped, EncryptionAgent: encryptionAgent{Protocol: "2.0"}}
+	if _, err := r.contentKey(context.Background(), data); err == nil || !strings.Contains(err.Error(), "prefix") {
+		t.Errorf("got %v, want a protocol prefix error", err)
+	}
+	data.EncryptionAgent.Protocol = "1.0"
+	if key, err := r.contentKey(context.Background(), data); err != nil || !bytes.Equal(key, testKey(8)) {
+		t.Errorf("contentKey = %X, %v", key, err)
+	}
+}
+
+func TestDecryptCBCRejectsCorruptContent(t *testing.T) {
+	key, iv := testKey(7), bytes.Repeat([]byte{3}, aes.BlockSize)
+	block, _ := aes.NewCipher(key)
+	badPadding := bytes.Repeat([]byte{0}, aes.BlockSize)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(badPadding, badPadding)
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"empty", nil, "empty"},
+		{"partial block", make([]byte, 20), "whole number of blocks"},
+		{"bad padding", badPadding, "padding"},
+	}
+	for _, tt := range tests {
+		err := decryptCBC(&bytes.Buffer{}, bytes.NewReader(tt.data), key, iv)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDecryptContentUnsupported(t *testing.T) {
+	tests := []*encryptionData{
+		{EncryptionMode: "PartialBlob"},
+		{EncryptionAgent: encryptionAgent{Protocol: "3.0", EncryptionAlgorithm: "AES_GCM_256"}},
+		{EncryptionAgent: encryptionAgent{Protocol: "2.0", EncryptionAlgorithm: "AES_GCM_256"}},
+	}
+	for _, data := range tests {
+		if err := decryptContent(&bytes.Buffer{}, bytes.NewReader(nil), data, testKey(1)); err == nil {
+			t.Errorf("decrypted with %+v", data)
+		}
+	}
+}
+
+func TestEncryptGCMRegionsRoundTrip(t *testing.T) {
+	key := testKey(3)
+	info := encryptedRegionInfo{DataLength: 16, NonceLength: 12}
+	for _, n := range []int{0, 1, 16, 32, 40} {
+		plain := bytes.Repeat([]byte{'p'}, n)
+		var ciphertext, got bytes.Buffer
+		if err := encryptGCMRegions(&ciphertext, bytes.NewReader(plain), key, info); err != nil {
+			t.Fatal(err)
+		}
+		regions := (n + info.DataLength - 1) / info.DataLength
+		if want := n + regions*(info.NonceLength+16); ciphertext.Len() != want {
+			t.Errorf("%d bytes encrypted to %d, want %d", n, ciphertext.Len(), want)
+		}
+		if err := decryptGCMRegions(&got, &ciphertext, key, info); err != nil || !bytes.Equal(got.Bytes(), plain) {
+			t.Errorf("%d bytes: decrypted %d bytes, %v", n, got.Len(), err)
+		}
+	}
+}
+
+func TestDecryptGCMRegionsRejectsLayout(t *testing.T) {
+	for _, info := range []encryptedRegionInfo{
+		{DataLength: 1 << 40, NonceLength: 12},
+		{DataLength: maxRegionLength + 1, NonceLength: 12},
+		{DataLength: 0, NonceLength: 12},
+		{DataLength: 16, NonceLength: 0},
+		{DataLength: 16, NonceLength: 1 << 30},
+	} {
+		if err := decryptGCMRegions(io.Discard, bytes.NewReader(make([]byte, 64)), testKey(3), info); err == nil || !strings.Contains(err.Error(), "invalid encrypted region") {
+			t.Errorf("layout %+v: %v", info, err)
+		}
+	}
+}
+
+func TestUploadEncrypts(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	az.ClientOptions.EncryptUploads = true
+	az.Keys = mustKeyRing(t, "k1", map[string][]byte{"k1": testKey(1)})
+	// More than one region, ending in a partial one.
+	plain := bytes.Repeat([]byte("secret "), (uploadRegionInfo.DataLength+100)/7)
+	path := filepath.Join(t.TempDir(), "plain")
+	if err := os.WriteFile(path, plain, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ctx := context.Background()
+	if _, err := az.Upload(ctx, f, "blob"); err != nil {
+		t.Fatal(err)
+	}
+	stored := m.blobs["blob"]
+	if bytes.Contains(stored.data, []byte("secret")) {
+		t.Fatal("the blob holds plaintext")
+	}
+	data, err := encryptionDataFromMetadata(stored.metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.EncryptionAgent.Protocol != "2.0" || data.WrappedContentKey.KeyID != "k1" || data.WrappedContentKey.Algorithm != keyWrapAES {
+		t.Errorf("encryption metadata %+v", data)
+	}
+
+	// A ring that resolves its KEKs on demand reads it back.
+	az.Keys = NewResolvingKeyRing("k2", func(ctx context.Context, id string) ([]byte, error) {
+		return testKey(id[1] - '0'), nil
+	})
+	dest := filepath.Join(t.TempDir(), "out")
+	if _, err := az.Download(ctx, "blob", dest); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, plain) {
+		t.Errorf("downloaded %d bytes that differ from the upload", len(got))
+	}
+
+	az.Keys = nil
+	if _, err := az.Upload(ctx, f, "blob"); err == nil || !strings.Contains(err.Error(), "key ring") {
+		t.Errorf("upload without a key ring: got %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package azureblob
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// envPrefix prefixes the environment variable of every global flag, e.g.
+// BK_AZUREBLOB_STORAGE_ACCOUNT for -storage-account.
+const envPrefix = "BK_AZUREBLOB_"
+
+// standardEnv maps flags to the variables the Azure SDKs and CLI read for
+// the same setting, which are used when the prefixed variable is unset.
+var standardEnv = map[string]string{
+	"tenant-id":                    "AZURE_TENANT_ID",
+	"additionally-allowed-tenants": "AZURE_ADDITIONALLY_ALLOWED_TENANTS",
+	"client-id":                    "AZURE_CLIENT_ID",
+	"storage-account":              "AZURE_STORAGE_ACCOUNT",
+}
+
+// flagEnvName returns the prefixed environment variable of the flag name.
+func flagEnvName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// flagEnvNames returns the variables that can set the flag name, in order of
+// precedence: the Buildkite plugin configuration, for a step using this
+// repository as a plugin, then the prefixed variable and the standard one.
+func flagEnvNames(name string) []string {
+	names := []string{
+		buildkitePluginPrefix + strings.TrimPrefix(flagEnvName(name), envPrefix),
+		flagEnvName(name),
+	}
+	if std, ok := standardEnv[name]; ok {
+		names = append(names, std)
+	}
+	return names
+}
+
+// applyEnv sets each flag of fs that was not given on the command line from
+// its environment variable, if that is set, as if it had been given. Flags
+// therefore take precedence over the environment, and the environment over
+// the configuration file, whose profile fields only fill settings no flag
+// set. A repeatable flag takes a single value from the environment.
+func applyEnv(fs *flag.FlagSet, lookup func(string) (string, bool)) error {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] {
+			return
+		}
+		for _, name := range flagEnvNames(f.Name) {
+			value, ok := lookup(name)
+			if !ok {
+				continue
+			}
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %w", value, name, setErr)
+			}
+			return
+		}
+	})
+	return err
+}
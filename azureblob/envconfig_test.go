@@ -0,0 +1,94 @@
+package azureblob
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newEnvFlagSet() (*flag.FlagSet, *string, *string, *time.Duration) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	account := fs.String("storage-account", "", "")
+	container := fs.String("container", "", "")
+	timeout := fs.Duration("metadata-timeout", time.Second, "")
+	return fs, account, container, timeout
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"AZURE_STORAGE_ACCOUNT":         "standard",
+		"BK_AZUREBLOB_CONTAINER":        "from-env",
+		"BK_AZUREBLOB_METADATA_TIMEOUT": "5s",
+	}
+	lookup := func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+	tests := []struct {
+		name          string
+		args          []string
+		env           map[string]string
+		wantAccount   string
+		wantContainer string
+		wantTimeout   time.Duration
+	}{
+		{"environment", nil, nil, "standard", "from-env", 5 * time.Second},
+		{"flags win", []string{"-container", "flag", "-metadata-timeout", "1m"}, nil, "standard", "flag", time.Minute},
+		{"prefixed over standard", nil, map[string]string{"BK_AZUREBLOB_STORAGE_ACCOUNT": "prefixed"}, "prefixed", "from-env", 5 * time.Second},
+		{"plugin over prefixed", nil, map[string]string{"BUILDKITE_PLUGIN_BK_AZUREBLOB_CONTAINER": "plugin"}, "standard", "plugin", 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				env[k] = v
+				defer delete(env, k)
+			}
+			fs, account, container, timeout := newEnvFlagSet()
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if err := applyEnv(fs, lookup); err != nil {
+				t.Fatal(err)
+			}
+			if *account != tt.wantAccount || *container != tt.wantContainer || *timeout != tt.wantTimeout {
+				t.Errorf("got %q, %q, %s; want %q, %q, %s", *account, *container, *timeout, tt.wantAccount, tt.wantContainer, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestApplyEnvInvalidValue(t *testing.T) {
+	fs, _, _, _ := newEnvFlagSet()
+	err := applyEnv(fs, func(k string) (string, bool) {
+		return "soon", k == "BK_AZUREBLOB_METADATA_TIMEOUT"
+	})
+	if err == nil || !strings.Contains(err.Error(), `invalid value "soon" for BK_AZUREBLOB_METADATA_TIMEOUT`) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestEnvOverridesProfile(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := Profile{}
+	fs.StringVar(&flags.StorageAccount, "storage-account", "", "")
+	fs.StringVar(&flags.Container, "container", "", "")
+	fs.Parse(nil)
+	applyEnv(fs, func(k string) (string, bool) { return "env-account", k == "AZURE_STORAGE_ACCOUNT" })
+	cfg := &Config{Profiles: map[string]Profile{"p": {StorageAccount: "profile-account", Container: "profile-container"}}}
+	selected, _ := cfg.Profile("p")
+	resolved := Profile{}
+	resolved.merge(selected)
+	resolved.merge(flags)
+	if resolved.StorageAccount != "env-account" || resolved.Container != "profile-container" {
+		t.Errorf("resolved %+v", resolved)
+	}
+}
+
+func TestFlagEnvName(t *testing.T) {
+	if got := flagEnvName("max-transfers"); got != "BK_AZUREBLOB_MAX_TRANSFERS" {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,135 @@
+package azureblob
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// BlobError is returned when a storage operation fails. It carries the
+// identifiers Azure support asks for when tracing a failed request.
+type BlobError struct {
+	// Op is the client operation that failed, e.g. "download".
+	Op string
+	// Blob is the blob (or prefix) the operation targeted.
+	Blob string
+	// StatusCode is the HTTP status of the failed response, or 0 if the
+	// request never got a response.
+	StatusCode int
+	// ErrorCode is the storage error code, e.g. "BlobNotFound".
+	ErrorCode string
+	// RequestID is the x-ms-request-id assigned by the service.
+	RequestID string
+	Err       error
+}
+
+func (e *BlobError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %q", e.Op, e.Blob)
+	if e.StatusCode != 0 {
+		fmt.Fprintf(&b, ": HTTP %d", e.StatusCode)
+		if e.ErrorCode != "" {
+			fmt.Fprintf(&b, " %s", e.ErrorCode)
+		}
+		if e.RequestID != "" {
+			fmt.Fprintf(&b, " (x-ms-request-id %s)", e.RequestID)
+		}
+	}
+	if e.Err != nil {
+		if msg := strings.TrimSpace(e.Err.Error()); msg != "" {
+			fmt.Fprintf(&b, ": %s", msg)
+		}
+	}
+	return b.String()
+}
+
+func (e *BlobError) Unwrap() error {
+	return e.Err
+}
+
+// newBlobError wraps err from an SDK call with the response details the
+// service returned, if any. It returns nil when err is nil.
+func newBlobError(op, blob string, err error) error {
+	if err == nil {
+		return nil
+	}
+	be := &BlobError{Op: op, Blob: blob, Err: err}
+	// The SDK's InternalError hides what it wraps from errors.Is.
+	var budgetErr retryBudgetError
+	if errors.As(err, &budgetErr) {
+		be.Err = budgetErr
+	}
+	var sasErr sasExpiredError
+	if errors.As(err, &sasErr) {
+		be.Err = sasErr
+	}
+	// Transport errors quote the request URL, which can hold a SAS.
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		be.Err = redactURLError(urlErr)
+	}
+	// Depending on the operation the SDK returns either a *StorageError that
+	// holds the response, or a ResponseError wrapping a bare *StorageError.
+	var resp *http.Response
+	var storageErr *azblob.StorageError
+	if errors.As(err, &storageErr) {
+		resp = storageErr.Response()
+		be.ErrorCode = string(storageErr.ErrorCode)
+	}
+	var httpErr azcore.HTTPResponse
+	if resp == nil && errors.As(err, &httpErr) {
+		resp = httpErr.RawResponse()
+	}
+	if resp != nil {
+		be.StatusCode = resp.StatusCode
+		be.RequestID = resp.Header.Get("x-ms-request-id")
+		if be.ErrorCode == "" {
+			be.ErrorCode = resp.Header.Get("x-ms-error-code")
+		}
+	}
+	return be
+}
+
+// isThrottled reports whether err is the service shedding load: 429, or 503
+// as returned for ServerBusy.
+func isThrottled(err error) bool {
+	var be *BlobError
+	return errors.As(err, &be) && (be.StatusCode == http.StatusTooManyRequests || be.StatusCode == http.StatusServiceUnavailable)
+}
+
+// ChecksumError reports a local file whose digest is not the expected one.
+type ChecksumError struct {
+	Path string
+	// Algorithm is the digest compared, e.g. "sha256".
+	Algorithm string
+	Got       string
+	Want      string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s has %s %s, want %s", e.Path, e.Algorithm, e.Got, e.Want)
+}
+
+// DiskSpaceError reports a download refused before it started because the
+// file system of its destination directory has too little space available.
+type DiskSpaceError struct {
+	Dir  string
+	Need int64
+	Free int64
+}
+
+func (e *DiskSpaceError) Error() string {
+	return fmt.Sprintf("%s has %s available, need %s", e.Dir, formatBytes(e.Free), formatBytes(e.Need))
+}
+
+// isNotFound reports whether err is a storage error for a missing blob or
+// container.
+func isNotFound(err error) bool {
+	var be *BlobError
+	return errors.As(err, &be) && be.StatusCode == http.StatusNotFound
+}
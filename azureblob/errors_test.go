@@ -0,0 +1,124 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// storageErrorHandler fails every request with status, setting only the
+// headers the service always sends and, unless body is empty, an XML error.
+func storageErrorHandler(status int, code, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-request-id", "request-1")
+		w.Header().Set("x-ms-error-code", code)
+		if body != "" && r.Method != http.MethodHead {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+			return
+		}
+		w.WriteHeader(status)
+	}
+}
+
+func TestNewBlobErrorFromSDK(t *testing.T) {
+	const notFoundBody = `<?xml version="1.0" encoding="utf-8"?><Error><Code>BlobNotFound</Code><Message>The specified blob does not exist.</Message></Error>`
+	tests := []struct {
+		name     string
+		status   int
+		code     string
+		body     string
+		call     func(ctx context.Context, az *AzureBlobClient) error
+		notFound bool
+	}{
+		{
+			// HEAD responses carry no body, so only x-ms-error-code is set.
+			name:   "stat",
+			status: http.StatusNotFound,
+			code:   "BlobNotFound",
+			call: func(ctx context.Context, az *AzureBlobClient) error {
+				_, err := az.Stat(ctx, "missing")
+				return err
+			},
+			notFound: true,
+		},
+		{
+			name:   "delete",
+			status: http.StatusNotFound,
+			code:   "BlobNotFound",
+			body:   notFoundBody,
+			call: func(ctx context.Context, az *AzureBlobClient) error {
+				return az.Delete(ctx, "missing")
+			},
+			notFound: true,
+		},
+		{
+			// Pager errors wrap a StorageError that has no response.
+			name:   "list",
+			status: http.StatusForbidden,
+			code:   "AuthorizationFailure",
+			body:   `<?xml version="1.0" encoding="utf-8"?><Error><Code>AuthorizationFailure</Code><Message>denied</Message></Error>`,
+			call: func(ctx context.Context, az *AzureBlobClient) error {
+				_, err := az.List(ctx, "prefix")
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			az := newTestClient(t, storageErrorHandler(tt.status, tt.code, tt.body))
+			err := tt.call(context.Background(), az)
+			var be *BlobError
+			if !errors.As(err, &be) {
+				t.Fatalf("got %T %v, want *BlobError", err, err)
+			}
+			if be.StatusCode != tt.status || be.ErrorCode != tt.code || be.RequestID != "request-1" {
+				t.Errorf("got status %d, code %q, request ID %q; want %d, %q, %q",
+					be.StatusCode, be.ErrorCode, be.RequestID, tt.status, tt.code, "request-1")
+			}
+			if got := isNotFound(err); got != tt.notFound {
+				t.Errorf("isNotFound = %v, want %v", got, tt.notFound)
+			}
+		})
+	}
+}
+
+func TestNewBlobErrorFromResponseError(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusNotFound,
+		Header: http.Header{
+			"X-Ms-Error-Code": {"BlobNotFound"},
+			"X-Ms-Request-Id": {"request-2"},
+		},
+		Request: &http.Request{Method: http.MethodHead},
+	}
+	err := newBlobError("stat", "missing", runtime.NewResponseError(errors.New(""), resp))
+	want := `stat "missing": HTTP 404 BlobNotFound (x-ms-request-id request-2)`
+	if err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+	if !isNotFound(err) {
+		t.Error("isNotFound = false, want true")
+	}
+}
+
+func TestNewBlobErrorWithoutResponse(t *testing.T) {
+	if err := newBlobError("stat", "blob", nil); err != nil {
+		t.Errorf("got %v for a nil error, want nil", err)
+	}
+	cause := errors.New("connection refused")
+	err := newBlobError("download", "blob", cause)
+	if want := `download "blob": connection refused`; err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+	if !errors.Is(err, cause) {
+		t.Error("error does not wrap its cause")
+	}
+	if isNotFound(err) {
+		t.Error("isNotFound = true for an error without a response")
+	}
+}
@@ -0,0 +1,183 @@
+package azureblob
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// exampleScenario is a small end-to-end exercise of AzureBlobClient. Scenarios
+// double as smoke tests for a newly configured storage account.
+type exampleScenario struct {
+	name        string
+	description string
+	run         func(ctx context.Context, az *AzureBlobClient, opts exampleOptions) error
+}
+
+type exampleOptions struct {
+	// Prefix is the virtual directory that scenarios write their blobs under.
+	Prefix string
+	// Size is the number of random bytes used by the round-trip scenario.
+	Size int64
+}
+
+// withStorage adapts a scenario that needs only the BlobStorage operations.
+func withStorage(run func(ctx context.Context, bs BlobStorage, opts exampleOptions) error) func(context.Context, *AzureBlobClient, exampleOptions) error {
+	return func(ctx context.Context, az *AzureBlobClient, opts exampleOptions) error {
+		return run(ctx, az, opts)
+	}
+}
+
+func exampleScenarios() []exampleScenario {
+	return []exampleScenario{
+		{
+			name:        "auth",
+			description: "authenticate and read the container properties",
+			run:         exampleAuth,
+		},
+		{
+			name:        "roundtrip",
+			description: "upload random data, download it again and compare hashes",
+			run:         withStorage(exampleRoundTrip),
+		},
+		{
+			name:        "list",
+			description: "list the blobs under the example prefix",
+			run:         withStorage(exampleList),
+		},
+	}
+}
+
+// runExamples runs the named scenarios, or all of them when none are given.
+func runExamples(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("examples", flag.ContinueOnError)
+	opts := exampleOptions{}
+	fs.StringVar(&opts.Prefix, "prefix", "bk_azureblob-examples", "blob prefix the scenarios write under")
+	fs.Int64Var(&opts.Size, "size", 1<<20, "size in bytes of the round-trip payload")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: examples [flags] [scenario...]\n\nScenarios:\n")
+		for _, s := range exampleScenarios() {
+			fmt.Fprintf(fs.Output(), "  %-10s %s\n", s.name, s.description)
+		}
+		fmt.Fprintf(fs.Output(), "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if opts.Size < 0 {
+		return fmt.Errorf("-size must not be negative, got %d", opts.Size)
+	}
+
+	selected := exampleScenarios()
+	if fs.NArg() > 0 {
+		selected = nil
+		for _, name := range fs.Args() {
+			s, err := findExampleScenario(name)
+			if err != nil {
+				return err
+			}
+			selected = append(selected, s)
+		}
+	}
+
+	failed := 0
+	for _, s := range selected {
+		start := time.Now()
+		err := s.run(ctx, az, opts)
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			failed++
+			fmt.Println(az.Messages.format(MsgExampleFail, s.name, elapsed, err))
+			continue
+		}
+		fmt.Println(az.Messages.format(MsgExamplePass, s.name, elapsed))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d example scenarios failed", failed, len(selected))
+	}
+	return nil
+}
+
+func findExampleScenario(name string) (exampleScenario, error) {
+	for _, s := range exampleScenarios() {
+		if s.name == name {
+			return s, nil
+		}
+	}
+	return exampleScenario{}, fmt.Errorf("unknown example scenario %q", name)
+}
+
+func exampleAuth(ctx context.Context, az *AzureBlobClient, opts exampleOptions) error {
+	return az.HealthCheck(ctx)
+}
+
+func exampleRoundTrip(ctx context.Context, bs BlobStorage, opts exampleOptions) (err error) {
+	dir, err := os.MkdirTemp("", "bk_azureblob-examples")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	payload := make([]byte, opts.Size)
+	if _, err := io.ReadFull(rand.Reader, payload); err != nil {
+		return err
+	}
+	src := filepath.Join(dir, "upload.bin")
+	if err := os.WriteFile(src, payload, 0600); err != nil {
+		return err
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	blobPath := path.Join(opts.Prefix, fmt.Sprintf("roundtrip-%d.bin", time.Now().UnixNano()))
+	if _, err := bs.Upload(ctx, f, blobPath); err != nil {
+		return err
+	}
+	// Leave nothing behind in the container, even when verification fails.
+	// A failed cleanup fails an otherwise passing scenario.
+	defer func() {
+		if derr := bs.Delete(ctx, blobPath); err == nil {
+			err = derr
+		}
+	}()
+
+	dst := filepath.Join(dir, "download.bin")
+	if _, err := bs.Download(ctx, blobPath, dst); err != nil {
+		return err
+	}
+	downloaded, err := os.ReadFile(dst)
+	if err != nil {
+		return err
+	}
+	want, got := sha256.Sum256(payload), sha256.Sum256(downloaded)
+	if want != got {
+		return fmt.Errorf("downloaded %s has sha256 %x, uploaded %x", blobPath, got, want)
+	}
+	return nil
+}
+
+func exampleList(ctx context.Context, bs BlobStorage, opts exampleOptions) error {
+	blobs, err := bs.List(ctx, opts.Prefix)
+	if err != nil {
+		return err
+	}
+	for _, b := range blobs {
+		if b.Name == "" {
+			return errors.New("listing returned a blob without a name")
+		}
+		fmt.Printf("  %s\n", b.Name)
+	}
+	return nil
+}
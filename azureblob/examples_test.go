@@ -0,0 +1,52 @@
+package azureblob
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRunExamplesArguments(t *testing.T) {
+	az := newTestClient(t, newMemContainer())
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"nope"}, `unknown example scenario "nope"`},
+		{[]string{"-size", "-1", "roundtrip"}, "-size must not be negative, got -1"},
+	}
+	for _, tt := range tests {
+		err := runExamples(context.Background(), az, tt.args)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("runExamples(%q) = %v, want %q", tt.args, err, tt.want)
+		}
+	}
+}
+
+func TestRunExamplesAgainstContainer(t *testing.T) {
+	container := newMemContainer()
+	az := newTestClient(t, container)
+	if err := runExamples(context.Background(), az, []string{"-size", "4096", "auth", "roundtrip", "list"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(container.blobs) != 0 {
+		t.Errorf("round trip left %d blobs behind", len(container.blobs))
+	}
+}
+
+func TestExampleRoundTripReportsFailedCleanup(t *testing.T) {
+	container := newMemContainer()
+	az := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			w.Header().Set("x-ms-error-code", "AuthorizationPermissionMismatch")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		container.ServeHTTP(w, r)
+	}))
+	err := exampleRoundTrip(context.Background(), az, exampleOptions{Prefix: "examples", Size: 16})
+	if err == nil || !strings.Contains(err.Error(), "AuthorizationPermissionMismatch") {
+		t.Errorf("round trip with a failing delete = %v, want the delete error", err)
+	}
+}
@@ -0,0 +1,86 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// Exit codes of the CLI, so scripts can branch on the kind of failure.
+// Invalid global flags exit with 2, as the flag package does.
+const (
+	exitGeneric   = 1
+	exitAuth      = 3
+	exitNotFound  = 4
+	exitChecksum  = 5
+	exitThrottled = 6
+	exitCancelled = 130
+)
+
+// exitCode returns the exit code for a command that failed with err. A
+// multi-file command whose failed transfers all have the same code exits
+// with it, and with exitGeneric if they differ.
+func exitCode(err error) int {
+	var ce cancelledError
+	if errors.As(err, &ce) {
+		return exitCancelled
+	}
+	var tf *transferFailures
+	if errors.As(err, &tf) && len(tf.errs) > 0 {
+		code := exitCode(tf.errs[0])
+		for _, err := range tf.errs[1:] {
+			if exitCode(err) != code {
+				return exitGeneric
+			}
+		}
+		return code
+	}
+	var checksumErr *ChecksumError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return exitCancelled
+	case errors.As(err, &checksumErr), errors.Is(err, ErrBadSignature):
+		return exitChecksum
+	case isNotFound(err):
+		return exitNotFound
+	case isThrottled(err):
+		return exitThrottled
+	case isAuthFailure(err):
+		return exitAuth
+	}
+	return exitGeneric
+}
+
+// isAuthFailure reports whether err is the service refusing the request's
+// authentication or authorization, or the credential failing to get a
+// token at all, including a device code sign-in nobody completed, or its
+// SAS having expired.
+func isAuthFailure(err error) bool {
+	var be *BlobError
+	if errors.As(err, &be) && (be.StatusCode == http.StatusUnauthorized || be.StatusCode == http.StatusForbidden) {
+		return true
+	}
+	var authErr azidentity.AuthenticationFailedError
+	var unavailableErr azidentity.CredentialUnavailableError
+	return errors.As(err, &authErr) || errors.As(err, &unavailableErr) || errors.Is(err, ErrAuthTimedOut) || errors.Is(err, ErrSASExpired)
+}
+
+// cancelledError is an error of a command whose context was cancelled.
+type cancelledError struct{ err error }
+
+func (e cancelledError) Error() string { return e.err.Error() }
+func (e cancelledError) Unwrap() error { return e.err }
+
+// commandError returns err, the failure of a command run under ctx, marked
+// as a cancellation if ctx was cancelled, so that it exits with
+// exitCancelled. The SDK wraps what fails its calls in an InternalError
+// that hides context.Canceled from errors.Is, and a multi-file command
+// fails with the errors of its transfers.
+func commandError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		return cancelledError{err}
+	}
+	return err
+}
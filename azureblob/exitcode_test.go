@@ -0,0 +1,62 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type credentialUnavailable struct{}
+
+func (credentialUnavailable) Error() string          { return "no credential" }
+func (credentialUnavailable) NonRetriable()          {}
+func (credentialUnavailable) CredentialUnavailable() {}
+
+func TestExitCode(t *testing.T) {
+	notFound := &BlobError{Op: "download", Blob: "a", StatusCode: 404, ErrorCode: "BlobNotFound"}
+	checksum := &ChecksumError{Path: "a", Algorithm: "sha256", Got: "1", Want: "2"}
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"generic", errors.New("disk full"), exitGeneric},
+		{"not found", fmt.Errorf("fetch: %w", notFound), exitNotFound},
+		{"unauthorized", &BlobError{StatusCode: 401}, exitAuth},
+		{"forbidden", &BlobError{StatusCode: 403, ErrorCode: "AuthorizationPermissionMismatch"}, exitAuth},
+		{"no credential", fmt.Errorf("token: %w", credentialUnavailable{}), exitAuth},
+		{"sign-in timed out", fmt.Errorf("token: %w", ErrAuthTimedOut), exitAuth},
+		{"throttled", &BlobError{StatusCode: 429}, exitThrottled},
+		{"server busy", &BlobError{StatusCode: 503, ErrorCode: "ServerBusy"}, exitThrottled},
+		{"checksum", checksum, exitChecksum},
+		{"cancelled", &BlobError{Op: "download", Err: context.Canceled}, exitCancelled},
+		{"same failures", &transferFailures{errs: []error{notFound, notFound}, total: 3}, exitNotFound},
+		{"mixed failures", &transferFailures{errs: []error{notFound, checksum}, total: 3}, exitGeneric},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("%s: exitCode(%v) = %d, want %d", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestExitCodeCancelledCall(t *testing.T) {
+	m := newMemContainer()
+	m.put("blob", []byte("b"), nil)
+	az := newTestClient(t, m)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, args := range [][]string{{"stat", "blob"}, {"download", "blob", t.TempDir()}} {
+		err := runCommand(ctx, az, args[0], args[1:])
+		if err == nil {
+			t.Fatalf("%s succeeded after cancelling", args[0])
+		}
+		if code := exitCode(commandError(ctx, err)); code != exitCancelled {
+			t.Errorf("%s: exit code %d, want %d, for %v", args[0], code, exitCancelled, err)
+		}
+	}
+	if err := commandError(context.Background(), errors.New("disk full")); exitCode(err) != exitGeneric {
+		t.Errorf("exit code %d without cancelling", exitCode(err))
+	}
+}
@@ -0,0 +1,164 @@
+package azureblob
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeBlobStorage is an in-memory BlobStorage for hermetic tests. It keeps
+// the semantics of AzureBlobClient that callers rely on: every write gives
+// the blob a new ETag, missing blobs fail with a *BlobError for which
+// isNotFound holds, Stat returns metadata and a Content-MD5, and List, as
+// with the service, returns no metadata.
+type FakeBlobStorage struct {
+	mu      sync.Mutex
+	blobs   map[string]*BlobProperties
+	data    map[string][]byte
+	version int
+}
+
+var _ BlobStorage = (*FakeBlobStorage)(nil)
+
+// NewFakeBlobStorage returns an empty fake container.
+func NewFakeBlobStorage() *FakeBlobStorage {
+	return &FakeBlobStorage{blobs: map[string]*BlobProperties{}, data: map[string][]byte{}}
+}
+
+// Put stores data as blobPath with metadata, as an upload would, and
+// returns the new blob's properties.
+func (f *FakeBlobStorage) Put(blobPath string, data []byte, metadata map[string]string) *BlobProperties {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.putLocked(blobPath, data, metadata)
+}
+
+func (f *FakeBlobStorage) putLocked(blobPath string, data []byte, metadata map[string]string) *BlobProperties {
+	f.version++
+	sum := md5.Sum(data)
+	props := &BlobProperties{
+		Name:         blobPath,
+		Size:         int64(len(data)),
+		ETag:         fmt.Sprintf(`"0x%X"`, f.version),
+		LastModified: time.Now().UTC().Truncate(time.Second),
+		ContentType:  "application/octet-stream",
+		ContentMD5:   sum[:],
+		AccessTier:   "Hot",
+		Metadata:     copyMetadata(metadata),
+	}
+	f.blobs[blobPath] = props
+	f.data[blobPath] = append([]byte(nil), data...)
+	return props
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	if metadata == nil {
+		return nil
+	}
+	c := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		c[k] = v
+	}
+	return c
+}
+
+func fakeNotFound(op, blobPath string) error {
+	return &BlobError{Op: op, Blob: blobPath, StatusCode: http.StatusNotFound, ErrorCode: "BlobNotFound"}
+}
+
+// fakeResult returns the result of transferring the blob with props. The
+// fake takes no time and never retries.
+func fakeResult(props *BlobProperties) *TransferResult {
+	return &TransferResult{Blob: props.Name, Bytes: props.Size, ETag: props.ETag, ContentMD5: props.ContentMD5}
+}
+
+func (f *FakeBlobStorage) Download(ctx context.Context, asset, destination string) (*TransferResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	data, ok := f.data[asset]
+	props := f.blobs[asset]
+	f.mu.Unlock()
+	if !ok {
+		return nil, fakeNotFound("stat", asset)
+	}
+	if err := os.WriteFile(destination, data, 0644); err != nil {
+		return nil, err
+	}
+	return fakeResult(props), nil
+}
+
+func (f *FakeBlobStorage) Upload(ctx context.Context, file *os.File, blobPath string) (*TransferResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, errors.New("file cannot be nil")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// Like the real upload, read the whole file whatever its offset.
+	data, err := io.ReadAll(io.NewSectionReader(file, 0, info.Size()))
+	if err != nil {
+		return nil, err
+	}
+	return fakeResult(f.Put(blobPath, data, nil)), nil
+}
+
+func (f *FakeBlobStorage) List(ctx context.Context, prefix string) ([]*BlobProperties, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var blobs []*BlobProperties
+	for name, props := range f.blobs {
+		if strings.HasPrefix(name, prefix) {
+			listed := *props
+			listed.Metadata = nil
+			blobs = append(blobs, &listed)
+		}
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Name < blobs[j].Name })
+	return blobs, nil
+}
+
+func (f *FakeBlobStorage) Delete(ctx context.Context, blobPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.blobs[blobPath]; !ok {
+		return fakeNotFound("delete", blobPath)
+	}
+	delete(f.blobs, blobPath)
+	delete(f.data, blobPath)
+	return nil
+}
+
+func (f *FakeBlobStorage) Stat(ctx context.Context, blobPath string) (*BlobProperties, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	props, ok := f.blobs[blobPath]
+	if !ok {
+		return nil, fakeNotFound("stat", blobPath)
+	}
+	stat := *props
+	stat.Metadata = copyMetadata(props.Metadata)
+	return &stat, nil
+}
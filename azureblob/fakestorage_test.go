@@ -0,0 +1,144 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// checkBlobStorage exercises the semantics callers rely on. put seeds a blob
+// with metadata, which Upload cannot set.
+func checkBlobStorage(t *testing.T, bs BlobStorage, put func(name string, data []byte, metadata map[string]string)) {
+	t.Helper()
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	if _, err := bs.Stat(ctx, "missing"); !isNotFound(err) {
+		t.Errorf("Stat of a missing blob: %v", err)
+	}
+	if _, err := bs.Download(ctx, "missing", filepath.Join(dir, "missing")); !isNotFound(err) {
+		t.Errorf("Download of a missing blob: %v", err)
+	}
+	if err := bs.Delete(ctx, "missing"); !isNotFound(err) {
+		t.Errorf("Delete of a missing blob: %v", err)
+	}
+
+	upload := func(content string) *BlobProperties {
+		f, err := os.Open(writeFile(t, filepath.Join(dir, "upload"), content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := bs.Upload(ctx, f, "dir/a"); err != nil {
+			t.Fatal(err)
+		}
+		props, err := bs.Stat(ctx, "dir/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return props
+	}
+	first := upload("first")
+	second := upload("second!")
+	if second.Size != 7 || second.ETag == "" || second.ETag == first.ETag || len(second.ContentMD5) == 0 {
+		t.Errorf("properties after overwriting: %+v (before: %+v)", second, first)
+	}
+
+	put("dir/b", []byte("with metadata"), map[string]string{"owner": "ci"})
+	props, err := bs.Stat(ctx, "dir/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := ""
+	for k, v := range props.Metadata {
+		if strings.EqualFold(k, "owner") {
+			owner = v
+		}
+	}
+	if owner != "ci" {
+		t.Errorf("Stat metadata %v, want owner=ci", props.Metadata)
+	}
+
+	put("other", []byte("outside the prefix"), nil)
+	listed, err := bs.List(ctx, "dir/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, b := range listed {
+		names = append(names, b.Name)
+		if len(b.Metadata) != 0 {
+			t.Errorf("List returned metadata for %s", b.Name)
+		}
+	}
+	if want := []string{"dir/a", "dir/b"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("List = %v, want %v", names, want)
+	}
+
+	dest := filepath.Join(dir, "download")
+	if _, err := bs.Download(ctx, "dir/a", dest); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, []byte("second!")) {
+		t.Errorf("downloaded %q", got)
+	}
+
+	if err := bs.Delete(ctx, "dir/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bs.Stat(ctx, "dir/a"); !isNotFound(err) {
+		t.Errorf("Stat after Delete: %v", err)
+	}
+}
+
+func TestFakeBlobStorage(t *testing.T) {
+	f := NewFakeBlobStorage()
+	checkBlobStorage(t, f, func(name string, data []byte, metadata map[string]string) {
+		f.Put(name, data, metadata)
+	})
+}
+
+// TestAzureBlobClientMatchesFake runs the same checks against the real
+// client, so the fake cannot drift from it.
+func TestAzureBlobClientMatchesFake(t *testing.T) {
+	m := newMemContainer()
+	checkBlobStorage(t, newTestClient(t, m), func(name string, data []byte, metadata map[string]string) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.put(name, data, metadata)
+	})
+}
+
+func TestFakeBlobStorageCopiesData(t *testing.T) {
+	f := NewFakeBlobStorage()
+	data, metadata := []byte("original"), map[string]string{"k": "v"}
+	f.Put("blob", data, metadata)
+	data[0], metadata["k"] = 'X', "changed"
+	props, _ := f.Stat(context.Background(), "blob")
+	props.Metadata["k"] = "changed via Stat"
+	dest := filepath.Join(t.TempDir(), "blob")
+	f.Download(context.Background(), "blob", dest)
+	again, _ := f.Stat(context.Background(), "blob")
+	if got, _ := os.ReadFile(dest); string(got) != "original" || again.Metadata["k"] != "v" {
+		t.Errorf("stored blob is %q with metadata %v; callers' copies leaked into it", got, again.Metadata)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := f.Stat(ctx, "blob"); err != context.Canceled {
+		t.Errorf("Stat with a cancelled context: %v", err)
+	}
+}
+
+func TestExampleRoundTripHermetic(t *testing.T) {
+	f := NewFakeBlobStorage()
+	if err := exampleRoundTrip(context.Background(), f, exampleOptions{Prefix: "examples", Size: 1024}); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := f.List(context.Background(), ""); len(left) != 0 {
+		t.Errorf("round trip left %d blobs behind", len(left))
+	}
+}
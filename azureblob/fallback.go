@@ -0,0 +1,80 @@
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// WithFallback configures c to download blobs that are missing from its own
+// container from containerName in storageAccount instead. The fallback
+// authenticates as the same identity, shares c's configuration and, once c is
+// initialised, its credential, HTTP client and rate limiter.
+func (c *AzureBlobClient) WithFallback(storageAccount, containerName string) *AzureBlobClient {
+	c.Fallback = &AzureBlobClient{
+		ClientID:                   c.ClientID,
+		TenantID:                   c.TenantID,
+		AdditionallyAllowedTenants: c.AdditionallyAllowedTenants,
+		StorageAccount:             storageAccount,
+		ContainerName:              containerName,
+		CredentialOptions:          c.CredentialOptions,
+		ClientOptions:              c.ClientOptions,
+		Progress:                   c.Progress,
+		Pool:                       c.Pool,
+		Keys:                       c.Keys,
+		Dedup:                      c.Dedup,
+		Messages:                   c.Messages,
+	}
+	return c
+}
+
+// inherit shares parent's credential, HTTP client and rate limiter with c
+// where c has none of its own, so a fallback authenticates once, goes through
+// the same proxy and TLS settings and counts against the same bandwidth limit.
+// The credential is only shared when both authenticate as the same identity.
+func (c *AzureBlobClient) inherit(parent *AzureBlobClient) {
+	parent.initMu.Lock()
+	credential, client, limiter := parent.credential, parent.client, parent.limiter
+	parent.initMu.Unlock()
+	c.initMu.Lock()
+	defer c.initMu.Unlock()
+	if c.credential == nil && c.ClientID == parent.ClientID && c.TenantID == parent.TenantID {
+		c.credential = credential
+	}
+	if c.client == nil && c.ClientOptions == parent.ClientOptions {
+		c.client = client
+	}
+	if c.limiter == nil && c.clientOptions().LimitRate == parent.clientOptions().LimitRate {
+		c.limiter = limiter
+	}
+}
+
+// source identifies the container c reads from in log messages.
+func (c *AzureBlobClient) source() string {
+	return fmt.Sprintf("%s/%s", c.StorageAccount, c.ContainerName)
+}
+
+// downloadWithFallback downloads asset from c's container, falling back to
+// c.Fallback if the blob does not exist there. The source that served the blob
+// is logged so cutover progress can be followed.
+func (c *AzureBlobClient) downloadWithFallback(ctx context.Context, asset, destination string) error {
+	err := c.downloadRebuilding(ctx, asset, destination)
+	if err == nil {
+		log.Print(c.Messages.format(MsgServedBy, asset, c.source()))
+		return nil
+	}
+	if !isNotFound(err) {
+		return err
+	}
+	fb := c.Fallback
+	log.Print(c.Messages.format(MsgFallbackTrying, asset, c.source(), fb.source()))
+	fb.inherit(c)
+	if fb.Fallback != nil {
+		return fb.downloadWithFallback(ctx, asset, destination)
+	}
+	if err := fb.downloadRebuilding(ctx, asset, destination); err != nil {
+		return err
+	}
+	log.Print(fb.Messages.format(MsgServedByFallback, asset, fb.source()))
+	return nil
+}
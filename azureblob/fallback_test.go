@@ -0,0 +1,91 @@
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serveBlob answers property and (ranged) download requests for data.
+func serveBlob(w http.ResponseWriter, r *http.Request, data []byte) {
+	if w.Header().Get("ETag") == "" {
+		w.Header().Set("ETag", `"etag"`)
+	}
+	if w.Header().Get("Last-Modified") == "" {
+		w.Header().Set("Last-Modified", time.Unix(0, 0).UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("x-ms-blob-type", "BlockBlob")
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		return
+	}
+	var start, end int
+	if _, err := fmt.Sscanf(r.Header.Get("x-ms-range"), "bytes=%d-%d", &start, &end); err != nil || end >= len(data) {
+		end = len(data) - 1
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+	w.Header().Set("Content-Length", fmt.Sprint(end-start+1))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(data[start : end+1])
+}
+
+func TestFallbackSharesConfiguration(t *testing.T) {
+	data := []byte("served by the old container")
+	var mu sync.Mutex
+	var fallbackRequests []*http.Request
+	az := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/old/") {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mu.Lock()
+		fallbackRequests = append(fallbackRequests, r)
+		mu.Unlock()
+		serveBlob(w, r, data)
+	}))
+	az.ClientOptions.Headers = http.Header{"X-Tag": {"migration"}}
+	az.ClientOptions.LimitRate = 1 << 20
+	az.Progress = NewProgressAggregator()
+	az.Pool = NewTransferPool(1, 1)
+	az.Keys = mustKeyRing(t, "k1", map[string][]byte{"k1": testKey(1)})
+	az.Dedup = NewDedupIndex()
+	az.WithFallback(az.StorageAccount, "old")
+
+	dest := filepath.Join(t.TempDir(), "blob")
+	if _, err := az.Download(context.Background(), "blob", dest); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("downloaded %q, want %q", got, data)
+	}
+
+	fb := az.Fallback
+	if fb.ClientOptions != az.ClientOptions || fb.Progress != az.Progress || fb.Pool != az.Pool || fb.Keys != az.Keys || fb.Dedup != az.Dedup {
+		t.Error("fallback does not share the client options, progress aggregator, pool and key ring")
+	}
+	if fb.credential != az.credential || fb.client != az.client || fb.limiter != az.limiter {
+		t.Error("fallback does not share the credential, HTTP client and rate limiter")
+	}
+	if len(fallbackRequests) == 0 {
+		t.Fatal("no requests reached the fallback container")
+	}
+	for _, r := range fallbackRequests {
+		if r.Header.Get("X-Tag") != "migration" {
+			t.Errorf("%s %s is missing the client-wide header", r.Method, r.URL)
+		}
+	}
+	if s := az.Progress.Snapshot(); s.BytesTransferred != int64(len(data)) {
+		t.Errorf("aggregator saw %d bytes, want %d", s.BytesTransferred, len(data))
+	}
+}
@@ -0,0 +1,226 @@
+package azureblob
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultServeAddress is where serve listens without -listen or -socket.
+const defaultServeAddress = "127.0.0.1:8767"
+
+// FileServer serves the blobs of a container read-only over plain HTTP, for
+// tools that cannot authenticate to storage themselves. GET and HEAD of
+// /<name> return the blob name, with Range requests, and ETag and
+// Last-Modified validators so that conditional requests are answered with
+// 304 Not Modified. Every request must carry the server's token, as a bearer
+// token or as the password of basic authentication, since the server makes
+// its requests with the client's credential.
+type FileServer struct {
+	client *AzureBlobClient
+	token  string
+	// Prefix, if set, is the blob prefix served at the root, without a
+	// trailing slash.
+	Prefix string
+	// MaxAge, if positive, is sent as the max-age of the Cache-Control of
+	// blobs without a Cache-Control of their own.
+	MaxAge time.Duration
+	// Listing enables directory listings, which list every blob beneath the
+	// directory.
+	Listing bool
+}
+
+// NewFileServer returns a FileServer of c's container that accepts requests
+// with token.
+func NewFileServer(c *AzureBlobClient, token string) *FileServer {
+	return &FileServer{client: c, token: token}
+}
+
+// authorized reports whether r carries the server's token.
+func (s *FileServer) authorized(r *http.Request) bool {
+	return tokenAuthorized(r, s.token)
+}
+
+// tokenAuthorized reports whether r carries token, as a bearer token or as
+// the password of basic authentication. An empty token authorizes nothing.
+func tokenAuthorized(r *http.Request, token string) bool {
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if _, password, ok := r.BasicAuth(); ok {
+		got = password
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+func (s *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="bk_azureblob", charset="UTF-8"`)
+		http.Error(w, "missing or wrong token", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "the server is read-only", http.StatusMethodNotAllowed)
+		return
+	}
+	var fsys fs.FS = s.client.FS(r.Context())
+	if s.Prefix != "" {
+		var err error
+		if fsys, err = fs.Sub(fsys, s.Prefix); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	name := strings.Trim(r.URL.Path, "/")
+	if name == "" {
+		name = "."
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		s.serveError(w, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		s.serveError(w, err)
+		return
+	}
+	if info.IsDir() {
+		if !s.Listing {
+			http.Error(w, "directory listings are disabled", http.StatusForbidden)
+			return
+		}
+		http.FileServer(http.FS(fsys)).ServeHTTP(w, r)
+		return
+	}
+	props := info.Sys().(*BlobProperties)
+	h := w.Header()
+	h.Set("ETag", props.ETag)
+	if props.ContentType != "" {
+		h.Set("Content-Type", props.ContentType)
+	}
+	if props.ContentEncoding != "" {
+		h.Set("Content-Encoding", props.ContentEncoding)
+	}
+	switch {
+	case props.CacheControl != "":
+		h.Set("Cache-Control", props.CacheControl)
+	case s.MaxAge > 0:
+		h.Set("Cache-Control", fmt.Sprintf("max-age=%d", int(s.MaxAge.Seconds())))
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f.(io.ReadSeeker))
+}
+
+// serveError answers a request whose blob could not be opened.
+func (s *FileServer) serveError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), isNotFound(err):
+		http.Error(w, "not found", http.StatusNotFound)
+	case errors.Is(err, fs.ErrInvalid):
+		http.Error(w, "invalid path", http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
+// Serve authenticates, so that an interactive credential prompts before
+// any request arrives, and then serves requests on l until ctx is done.
+func (s *FileServer) Serve(ctx context.Context, l net.Listener) error {
+	if err := s.client.authenticate(ctx); err != nil {
+		l.Close()
+		return err
+	}
+	srv := &http.Server{Handler: s, BaseContext: func(net.Listener) context.Context { return ctx }}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(l) }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	return srv.Shutdown(context.Background())
+}
+
+// newServeToken returns a random token for a FileServer or Daemon.
+func newServeToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// serveToken returns the token held by tokenFile, or a random one if
+// tokenFile is empty.
+func serveToken(tokenFile string) (string, error) {
+	if tokenFile == "" {
+		return newServeToken()
+	}
+	b, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("%s holds no token", tokenFile)
+	}
+	return token, nil
+}
+
+func runServe(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("listen", defaultServeAddress, "serve on this loopback `address`")
+	socket := fs.String("socket", "", "serve on a unix socket at `path` instead, accessible only to the current user")
+	tokenFile := fs.String("token-file", "", "read the token requests must carry from `file` (default: a random token, which is logged)")
+	maxAge := fs.Duration("max-age", 0, "Cache-Control max-age of blobs without a Cache-Control of their own")
+	listing := fs.Bool("list", false, "serve directory listings, which list every blob beneath the directory")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: serve [flags] [prefix]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("serve takes at most one prefix")
+	}
+	listenSet := false
+	fs.Visit(func(f *flag.Flag) { listenSet = listenSet || f.Name == "listen" })
+	if listenSet && *socket != "" {
+		return errors.New("-listen and -socket cannot be combined")
+	}
+	az, prefix, err := az.resolveRemote(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	token, err := serveToken(*tokenFile)
+	if err != nil {
+		return err
+	}
+	l, err := daemonListener(*addr, *socket)
+	if err != nil {
+		return err
+	}
+	s := NewFileServer(az, token)
+	s.Prefix = strings.Trim(prefix, "/")
+	s.MaxAge = *maxAge
+	s.Listing = *listing
+	log.Print(az.Messages.format(MsgServeListening, l.Addr()))
+	if *tokenFile == "" {
+		log.Print(az.Messages.format(MsgServeToken, token))
+	}
+	return s.Serve(ctx, l)
+}
@@ -0,0 +1,94 @@
+package azureblob
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileServer(t *testing.T) {
+	m := newMemContainer()
+	m.put("tools/agent.sh", []byte("#!/bin/sh\necho agent\n"), nil).modified = time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	m.put("tools/conf/agent.yaml", []byte("level: debug\n"), nil)
+	m.put("other", []byte("outside the prefix"), nil)
+	s := NewFileServer(newTestClient(t, m), "secret")
+	s.Prefix = "tools"
+	s.MaxAge = time.Hour
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	get := func(path string, header http.Header) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = header
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+	auth := func(extra ...string) http.Header {
+		h := http.Header{"Authorization": {"Bearer secret"}}
+		for i := 0; i < len(extra); i += 2 {
+			h.Set(extra[i], extra[i+1])
+		}
+		return h
+	}
+
+	resp, body := get("/agent.sh", auth())
+	if resp.StatusCode != http.StatusOK || body != "#!/bin/sh\necho agent\n" {
+		t.Fatalf("GET = %s %q", resp.Status, body)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag != m.blobs["tools/agent.sh"].etag || resp.Header.Get("Cache-Control") != "max-age=3600" || resp.Header.Get("Last-Modified") != "Fri, 02 Jan 2026 15:04:05 GMT" {
+		t.Errorf("headers = %v", resp.Header)
+	}
+	if resp, body := get("/agent.sh", auth("Range", "bytes=10-14")); resp.StatusCode != http.StatusPartialContent || body != "echo " {
+		t.Errorf("ranged GET = %s %q", resp.Status, body)
+	}
+	if resp, _ := get("/agent.sh", auth("If-None-Match", etag)); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("conditional GET = %s", resp.Status)
+	}
+
+	basic := http.Header{}
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("anyone", "secret")
+	basic.Set("Authorization", req.Header.Get("Authorization"))
+	if resp, body := get("/conf/agent.yaml", basic); resp.StatusCode != http.StatusOK || body != "level: debug\n" {
+		t.Errorf("GET with basic auth = %s %q", resp.Status, body)
+	}
+
+	for _, tt := range []struct {
+		path   string
+		header http.Header
+		want   int
+	}{
+		{"/agent.sh", nil, http.StatusUnauthorized},
+		{"/agent.sh", http.Header{"Authorization": {"Bearer wrong"}}, http.StatusUnauthorized},
+		{"/missing", auth(), http.StatusNotFound},
+		{"/../other", auth(), http.StatusBadRequest},
+		{"/conf/", auth(), http.StatusForbidden},
+	} {
+		if resp, _ := get(tt.path, tt.header); resp.StatusCode != tt.want {
+			t.Errorf("GET %s = %s, want %d", tt.path, resp.Status, tt.want)
+		}
+	}
+	req, _ = http.NewRequest(http.MethodPut, srv.URL+"/agent.sh", strings.NewReader("x"))
+	req.Header = auth()
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("PUT = %v, %v", resp, err)
+	}
+
+	s.Listing = true
+	if resp, body := get("/conf/", auth()); resp.StatusCode != http.StatusOK || !strings.Contains(body, `href="agent.yaml"`) {
+		t.Errorf("listing = %s %q", resp.Status, body)
+	}
+}
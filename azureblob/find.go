@@ -0,0 +1,242 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// FindFilter selects the blobs Find returns. A blob must match every field
+// that is set; the zero FindFilter matches every blob.
+type FindFilter struct {
+	// Name is a path.Match pattern, such as "*.pkg", matched against the
+	// last element of the blob name.
+	Name string
+	// MinSize and MaxSize bound the size of the blob, inclusively. A zero
+	// MaxSize means no upper bound.
+	MinSize int64
+	MaxSize int64
+	// ModifiedBefore and ModifiedAfter bound the last-modified time of the
+	// blob, exclusively.
+	ModifiedBefore time.Time
+	ModifiedAfter  time.Time
+	// Tier is the access tier of the blob, such as Cool, in any case.
+	Tier string
+	// Metadata and Tags are values the blob's metadata and index tags must
+	// have. Metadata keys are compared in any case, as the service treats
+	// them, and tag keys exactly. Filtering on metadata looks up every blob
+	// that matches the other fields, a request per blob; filtering on tags
+	// needs permission to read them.
+	Metadata map[string]string
+	Tags     map[string]string
+}
+
+// matchListed reports whether p, as listed, matches everything in f except
+// its metadata.
+func (f *FindFilter) matchListed(p *BlobProperties) bool {
+	if f.Name != "" {
+		if ok, _ := path.Match(f.Name, path.Base(p.Name)); !ok {
+			return false
+		}
+	}
+	switch {
+	case p.Size < f.MinSize,
+		f.MaxSize > 0 && p.Size > f.MaxSize,
+		!f.ModifiedBefore.IsZero() && !p.LastModified.Before(f.ModifiedBefore),
+		!f.ModifiedAfter.IsZero() && !p.LastModified.After(f.ModifiedAfter),
+		f.Tier != "" && !strings.EqualFold(p.AccessTier, f.Tier):
+		return false
+	}
+	for k, v := range f.Tags {
+		if got, ok := p.Tags[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// matchMetadata reports whether metadata has the values f asks for.
+func (f *FindFilter) matchMetadata(metadata map[string]string) bool {
+	for k, v := range f.Metadata {
+		found := false
+		for mk, mv := range metadata {
+			if strings.EqualFold(mk, k) && mv == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Find returns the blobs under prefix that match f, in name order. Blobs
+// that match on metadata have their metadata filled in, and blobs that
+// match on tags have their tags.
+func (c *AzureBlobClient) Find(ctx context.Context, prefix string, f FindFilter) ([]*BlobProperties, error) {
+	if _, err := path.Match(f.Name, ""); err != nil {
+		return nil, fmt.Errorf("name pattern %q: %w", f.Name, err)
+	}
+	listed, err := c.ListWith(ctx, prefix, ListOptions{Tags: len(f.Tags) > 0})
+	if err != nil {
+		return nil, err
+	}
+	var blobs []*BlobProperties
+	for _, b := range listed {
+		if f.matchListed(b) {
+			blobs = append(blobs, b)
+		}
+	}
+	if len(f.Metadata) == 0 {
+		return blobs, nil
+	}
+	matched := make([]bool, len(blobs))
+	errs := c.Pool.Run(ctx, len(blobs), func(ctx context.Context, i int) error {
+		props, err := c.Stat(ctx, blobs[i].Name)
+		if isNotFound(err) {
+			// Deleted since it was listed.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		blobs[i].Metadata = props.Metadata
+		matched[i] = f.matchMetadata(props.Metadata)
+		return nil
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	found := blobs[:0]
+	for i, b := range blobs {
+		if matched[i] {
+			found = append(found, b)
+		}
+	}
+	return found, nil
+}
+
+// keyValueFlag collects repeated "key=value" flags into a map.
+type keyValueFlag map[string]string
+
+func (m keyValueFlag) String() string {
+	return keyValues(m)
+}
+
+func (m keyValueFlag) Set(v string) error {
+	parts := strings.SplitN(v, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("%q is not in key=value form", v)
+	}
+	m[parts[0]] = parts[1]
+	return nil
+}
+
+func runFind(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("find", flag.ContinueOnError)
+	name := fs.String("name", "", "match the last element of blob names against a `pattern`, e.g. '*.pkg'")
+	minSize := fs.String("min-size", "", "match blobs of at least `size`, e.g. 10MiB")
+	maxSize := fs.String("max-size", "", "match blobs of at most `size`")
+	olderThan := fs.String("older-than", "", "match blobs last modified longer than `age` ago, e.g. 90d or 36h")
+	newerThan := fs.String("newer-than", "", "match blobs last modified less than `age` ago")
+	tier := fs.String("tier", "", "match blobs in an access `tier`: Hot, Cool or Archive")
+	metadata := keyValueFlag{}
+	fs.Var(metadata, "metadata", "match blobs with a metadata `key=value`; repeatable, and each costs a request per blob")
+	tags := keyValueFlag{}
+	fs.Var(tags, "tag", "match blobs with an index tag `key=value`; repeatable")
+	output := outputFlag(fs)
+	del := fs.Bool("delete", false, "delete the matching blobs instead of printing them")
+	download := fs.String("download", "", "download the matching blobs into `directory` instead of printing them")
+	dryRun := fs.Bool("dry-run", false, "with -delete or -download, print what would be done without doing it")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: find [flags] [prefix]\n\nEvery filter given must match, e.g.\n  find -name '*.pkg' -older-than 90d -tier Cool releases/\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("find takes at most one prefix")
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	if *del && *download != "" {
+		return errors.New("-delete and -download cannot be combined")
+	}
+	if *dryRun && !*del && *download == "" {
+		return errors.New("-dry-run needs -delete or -download")
+	}
+	filter := FindFilter{Name: *name, Tier: *tier, Metadata: metadata, Tags: tags}
+	var err error
+	if *minSize != "" {
+		if filter.MinSize, err = parseByteSize(*minSize); err != nil {
+			return fmt.Errorf("-min-size: %w", err)
+		}
+	}
+	if *maxSize != "" {
+		if filter.MaxSize, err = parseByteSize(*maxSize); err != nil {
+			return fmt.Errorf("-max-size: %w", err)
+		}
+		if filter.MaxSize == 0 {
+			return errors.New("-max-size must be positive")
+		}
+	}
+	now := time.Now()
+	if *olderThan != "" {
+		age, err := parseAge(*olderThan)
+		if err != nil {
+			return fmt.Errorf("-older-than: %w", err)
+		}
+		filter.ModifiedBefore = now.Add(-age)
+	}
+	if *newerThan != "" {
+		age, err := parseAge(*newerThan)
+		if err != nil {
+			return fmt.Errorf("-newer-than: %w", err)
+		}
+		filter.ModifiedAfter = now.Add(-age)
+	}
+	az, prefix, err := az.resolveRemote(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	blobs, err := az.Find(ctx, prefix, filter)
+	if err != nil {
+		return err
+	}
+	if !*del && *download == "" {
+		return printProperties(*output, blobs)
+	}
+	names := make([]string, len(blobs))
+	listed := map[string]*BlobProperties{}
+	for i, b := range blobs {
+		names[i] = b.Name
+		listed[b.Name] = b
+	}
+	switch {
+	case *del && *dryRun:
+		return printPlan(az.Messages, az.planDeletes(ctx, names, listed), "planned deletions")
+	case *del:
+		return deleteAll(ctx, az, names)
+	case *dryRun:
+		if err := checkDir(*download); err != nil {
+			return err
+		}
+		items := make([]ManifestItem, len(names))
+		for i, blob := range names {
+			items[i] = ManifestItem{Blob: blob, Path: downloadDestination(*download, blob)}
+		}
+		return planTransfers(ctx, az, &Manifest{Downloads: items})
+	}
+	return downloadAll(ctx, az, names, *download)
+}
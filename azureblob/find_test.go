@@ -0,0 +1,106 @@
+package azureblob
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// findContainer holds blobs of every kind find filters on.
+func findContainer() *memContainer {
+	m := newMemContainer()
+	old := time.Now().Add(-100 * 24 * time.Hour)
+	b := m.put("releases/1.0/app.pkg", []byte("old cool pkg"), map[string]string{"channel": "stable"})
+	b.tier, b.modified, b.tags = "Cool", old, map[string]string{"team": "infra"}
+	b = m.put("releases/1.1/app.pkg", []byte("new pkg"), map[string]string{"channel": "beta"})
+	b.tier, b.modified = "Cool", time.Now()
+	b = m.put("releases/1.0/notes.txt", []byte("old cool text"), nil)
+	b.tier, b.modified = "Cool", old
+	b = m.put("releases/0.9/app.pkg", []byte("old hot pkg"), nil)
+	b.tier, b.modified = "Hot", old
+	return m
+}
+
+func findNames(blobs []*BlobProperties) string {
+	names := make([]string, len(blobs))
+	for i, b := range blobs {
+		names[i] = b.Name
+	}
+	return strings.Join(names, " ")
+}
+
+func TestFind(t *testing.T) {
+	az := newTestClient(t, findContainer())
+	ctx := context.Background()
+	monthAgo := time.Now().Add(-30 * 24 * time.Hour)
+	for _, tc := range []struct {
+		filter FindFilter
+		want   string
+	}{
+		{FindFilter{}, "releases/0.9/app.pkg releases/1.0/app.pkg releases/1.0/notes.txt releases/1.1/app.pkg"},
+		{FindFilter{Name: "*.pkg", ModifiedBefore: monthAgo, Tier: "cool"}, "releases/1.0/app.pkg"},
+		{FindFilter{ModifiedAfter: monthAgo}, "releases/1.1/app.pkg"},
+		{FindFilter{MinSize: 12}, "releases/1.0/app.pkg releases/1.0/notes.txt"},
+		{FindFilter{MaxSize: 11}, "releases/0.9/app.pkg releases/1.1/app.pkg"},
+		{FindFilter{Metadata: map[string]string{"Channel": "beta"}}, "releases/1.1/app.pkg"},
+		{FindFilter{Tags: map[string]string{"team": "infra"}}, "releases/1.0/app.pkg"},
+		{FindFilter{Name: "*.txt", Tags: map[string]string{"team": "infra"}}, ""},
+	} {
+		blobs, err := az.Find(ctx, "releases/", tc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := findNames(blobs); got != tc.want {
+			t.Errorf("Find(%+v) = %q, want %q", tc.filter, got, tc.want)
+		}
+	}
+	if _, err := az.Find(ctx, "", FindFilter{Name: "["}); err == nil {
+		t.Error("found with a bad name pattern")
+	}
+}
+
+func TestRunFindActions(t *testing.T) {
+	m := findContainer()
+	az := newTestClient(t, m)
+	ctx := context.Background()
+	var err error
+	out := captureStdout(t, func() {
+		err = runFind(ctx, az, []string{"-name", "*.pkg", "-older-than", "90d", "-tier", "Cool", "-output", "csv", "releases/"})
+	})
+	if err != nil || strings.Count(out, "\n") != 2 || !strings.Contains(out, "\nreleases/1.0/app.pkg,12,") {
+		t.Errorf("csv: %q, %v", out, err)
+	}
+
+	dir := t.TempDir()
+	captureStdout(t, func() { err = runFind(ctx, az, []string{"-tag", "team=infra", "-download", dir}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "releases", "1.0", "app.pkg")); err != nil || string(b) != "old cool pkg" {
+		t.Errorf("downloaded %q, %v", b, err)
+	}
+
+	captureStdout(t, func() { err = runFind(ctx, az, []string{"-older-than", "90d", "-delete", "-dry-run"}) })
+	if err != nil || len(m.blobs) != 4 {
+		t.Errorf("dry run: %v, %d blobs left", err, len(m.blobs))
+	}
+	captureStdout(t, func() { err = runFind(ctx, az, []string{"-older-than", "90d", "-delete"}) })
+	if _, ok := m.blobs["releases/1.1/app.pkg"]; err != nil || len(m.blobs) != 1 || !ok {
+		t.Errorf("delete: %v, left %v", err, m.blobs)
+	}
+
+	for _, args := range [][]string{
+		{"-dry-run"},
+		{"-delete", "-download", dir},
+		{"-older-than", "soon"},
+		{"-max-size", "0"},
+		{"-metadata", "novalue"},
+	} {
+		if err := runFind(ctx, az, args); err == nil {
+			t.Errorf("find %v succeeded", args)
+		}
+	}
+}
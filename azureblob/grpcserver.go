@@ -0,0 +1,315 @@
+package azureblob
+
+//go:generate protoc -I .. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative ../transferpb/transfer.proto
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/discentem/bk_azureblob/transferpb"
+)
+
+// defaultGRPCAddress is where the grpc command listens without -listen or
+// -socket.
+const defaultGRPCAddress = "127.0.0.1:8766"
+
+// grpcProgressInterval is how often Transfer streams progress.
+const grpcProgressInterval = time.Second
+
+// TransferServer implements the TransferService of transferpb with one
+// client, so that an orchestrator can drive transfers on an endpoint. Every
+// call must carry the server's token in its authorization metadata, as
+// GRPCToken sends it, since the server makes transfers with the client's
+// credential for anyone who can reach it.
+type TransferServer struct {
+	transferpb.UnimplementedTransferServiceServer
+	client *AzureBlobClient
+	token  string
+	// transfers counts the Transfer calls in flight, which Serve waits for.
+	transfers sync.WaitGroup
+}
+
+// NewTransferServer returns a TransferServer making transfers with c for
+// calls that carry token.
+func NewTransferServer(c *AzureBlobClient, token string) *TransferServer {
+	return &TransferServer{client: c, token: token}
+}
+
+// ServerOptions returns the options that make a grpc.Server reject calls
+// without the server's token. Serve uses them; programs registering s on
+// their own grpc.Server must pass them to grpc.NewServer.
+func (s *TransferServer) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := s.authorize(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := s.authorize(stream.Context()); err != nil {
+				return err
+			}
+			return handler(srv, stream)
+		}),
+	}
+}
+
+// authorize returns an Unauthenticated error unless the call of ctx
+// carries the server's token. An empty token authorizes nothing.
+func (s *TransferServer) authorize(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		got := strings.TrimPrefix(v, "Bearer ")
+		if s.token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or wrong token")
+}
+
+// GRPCToken returns call credentials that send token to a TransferServer,
+// for use with grpc.WithPerRPCCredentials.
+func GRPCToken(token string) credentials.PerRPCCredentials {
+	return grpcToken(token)
+}
+
+type grpcToken string
+
+func (t grpcToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity is false so the token can be sent over the
+// unix socket and loopback addresses, which are served without TLS.
+func (grpcToken) RequireTransportSecurity() bool { return false }
+
+// Transfer makes the transfer req asks for, streaming its progress.
+func (s *TransferServer) Transfer(req *transferpb.TransferRequest, stream transferpb.TransferService_TransferServer) error {
+	s.transfers.Add(1)
+	defer s.transfers.Done()
+	if req.Blob == "" || !filepath.IsAbs(req.Path) {
+		return status.Error(codes.InvalidArgument, "request needs a blob and an absolute path")
+	}
+	var run func(ctx context.Context) error
+	switch req.Direction {
+	case transferpb.TransferRequest_DOWNLOAD:
+		run = func(ctx context.Context) error {
+			_, err := s.client.Download(ctx, req.Blob, req.Path)
+			return err
+		}
+	case transferpb.TransferRequest_UPLOAD:
+		run = func(ctx context.Context) error { return s.client.uploadFile(ctx, req.Path, req.Blob) }
+	default:
+		return status.Error(codes.InvalidArgument, "request needs a direction")
+	}
+	progress := NewProgressAggregator()
+	done := make(chan error, 1)
+	go func() { done <- run(WithProgress(stream.Context(), progress)) }()
+	ticker := time.NewTicker(grpcProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				return grpcError(err)
+			}
+			return stream.Send(progressMessage(progress.Snapshot(), true))
+		case <-ticker.C:
+			if err := stream.Send(progressMessage(progress.Snapshot(), false)); err != nil {
+				// The stream failed because the caller went away, which
+				// cancels the transfer too.
+				<-done
+				return err
+			}
+		}
+	}
+}
+
+func progressMessage(s ProgressSnapshot, done bool) *transferpb.TransferProgress {
+	return &transferpb.TransferProgress{
+		BytesTransferred: s.BytesTransferred,
+		BytesTotal:       s.BytesTotal,
+		BytesPerSecond:   s.BytesPerSecond,
+		Done:             done,
+	}
+}
+
+// List streams the blobs under req.Prefix.
+func (s *TransferServer) List(req *transferpb.ListRequest, stream transferpb.TransferService_ListServer) error {
+	blobs, err := s.client.List(stream.Context(), req.Prefix)
+	if err != nil {
+		return grpcError(err)
+	}
+	for _, b := range blobs {
+		if err := stream.Send(blobMessage(b)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stat returns the properties of req.Blob.
+func (s *TransferServer) Stat(ctx context.Context, req *transferpb.StatRequest) (*transferpb.Blob, error) {
+	if req.Blob == "" {
+		return nil, status.Error(codes.InvalidArgument, "request needs a blob")
+	}
+	props, err := s.client.Stat(ctx, req.Blob)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return blobMessage(props), nil
+}
+
+func blobMessage(p *BlobProperties) *transferpb.Blob {
+	return &transferpb.Blob{
+		Name:         p.Name,
+		Size:         p.Size,
+		Etag:         p.ETag,
+		LastModified: timestamppb.New(p.LastModified),
+		ContentType:  p.ContentType,
+		Metadata:     p.Metadata,
+	}
+}
+
+// grpcError returns err with the gRPC status code closest to its cause.
+func grpcError(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case isNotFound(err) || errors.Is(err, os.ErrNotExist):
+		code = codes.NotFound
+	case isAuthFailure(err):
+		code = codes.PermissionDenied
+	}
+	return status.Error(code, err.Error())
+}
+
+// Serve authenticates, so that an interactive credential prompts before
+// any call arrives, and then serves the TransferService on l until ctx is
+// done. Transfers in flight are cancelled then, and Serve returns once they
+// have stopped.
+func (s *TransferServer) Serve(ctx context.Context, l net.Listener, opts ...grpc.ServerOption) error {
+	if err := s.client.authenticate(ctx); err != nil {
+		l.Close()
+		return err
+	}
+	srv := grpc.NewServer(append(s.ServerOptions(), opts...)...)
+	transferpb.RegisterTransferServiceServer(srv, s)
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(l) }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	srv.Stop()
+	s.transfers.Wait()
+	return nil
+}
+
+// grpcTLS returns the TLS configuration serving certFile and keyFile, which
+// also requires clients to present a certificate signed by a CA in
+// clientCAFile if it is given.
+func grpcTLS(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+func runGRPC(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("grpc", flag.ContinueOnError)
+	addr := fs.String("listen", defaultGRPCAddress, "serve on this `address`, which must be a loopback address without -client-ca")
+	socket := fs.String("socket", "", "serve on a unix socket at `path` instead, accessible only to the current user")
+	certFile := fs.String("tls-cert", "", "serve TLS with the certificate in `file`")
+	keyFile := fs.String("tls-key", "", "private key of -tls-cert in `file`")
+	clientCA := fs.String("client-ca", "", "require client certificates signed by a CA in `file`")
+	tokenFile := fs.String("token-file", "", "read the token calls must carry from `file` (default: a random token, which is logged)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: grpc [-token-file <file>] [-listen <address> | -socket <path>] [-tls-cert <file> -tls-key <file> [-client-ca <file>]]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("grpc takes no arguments")
+	}
+	listenSet := false
+	fs.Visit(func(f *flag.Flag) { listenSet = listenSet || f.Name == "listen" })
+	if listenSet && *socket != "" {
+		return errors.New("-listen and -socket cannot be combined")
+	}
+	if (*certFile == "") != (*keyFile == "") {
+		return errors.New("-tls-cert and -tls-key must be given together")
+	}
+	if *clientCA != "" && *certFile == "" {
+		return errors.New("-client-ca needs -tls-cert and -tls-key")
+	}
+	token, err := serveToken(*tokenFile)
+	if err != nil {
+		return err
+	}
+	var opts []grpc.ServerOption
+	if *certFile != "" {
+		config, err := grpcTLS(*certFile, *keyFile, *clientCA)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+	}
+	var l net.Listener
+	// Client certificates authenticate callers, so only then may the
+	// service be reachable from other machines.
+	if *clientCA != "" && *socket == "" {
+		l, err = net.Listen("tcp", *addr)
+	} else {
+		l, err = daemonListener(*addr, *socket)
+	}
+	if err != nil {
+		return err
+	}
+	log.Print(az.Messages.format(MsgGRPCListening, l.Addr()))
+	if *tokenFile == "" {
+		log.Print(az.Messages.format(MsgServeToken, token))
+	}
+	return NewTransferServer(az, token).Serve(ctx, l, opts...)
+}
@@ -0,0 +1,241 @@
+package azureblob
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/discentem/bk_azureblob/transferpb"
+)
+
+// testGRPCToken is the token of the TransferServers of tests.
+const testGRPCToken = "grpc-token"
+
+// serveTransfers serves a TransferServer for az in memory and returns a
+// client of it sending token, or no token if it is empty. The server stops
+// when the test ends.
+func serveTransfers(t *testing.T, az *AzureBlobClient, token string) transferpb.TransferServiceClient {
+	t.Helper()
+	l := bufconn.Listen(1 << 20)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- NewTransferServer(az, testGRPCToken).Serve(ctx, l) }()
+	opts := []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return l.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}
+	if token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(GRPCToken(token)))
+	}
+	conn, err := grpc.Dial("bufconn", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve = %v", err)
+		}
+	})
+	return transferpb.NewTransferServiceClient(conn)
+}
+
+// transfer runs req and returns its last progress message.
+func transfer(client transferpb.TransferServiceClient, req *transferpb.TransferRequest) (*transferpb.TransferProgress, error) {
+	stream, err := client.Transfer(context.Background(), req)
+	if err != nil {
+		return nil, err
+	}
+	var last *transferpb.TransferProgress
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return last, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		last = msg
+	}
+}
+
+func TestTransferServer(t *testing.T) {
+	m := newMemContainer()
+	client := serveTransfers(t, newTestClient(t, m), testGRPCToken)
+	ctx := context.Background()
+	dir := t.TempDir()
+	src := writeFile(t, filepath.Join(dir, "src.txt"), "hello")
+
+	last, err := transfer(client, &transferpb.TransferRequest{Direction: transferpb.TransferRequest_UPLOAD, Blob: "p/b.txt", Path: src})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !last.Done || last.BytesTransferred != 5 || last.BytesTotal != 5 {
+		t.Errorf("upload ended with %v", last)
+	}
+	dest := filepath.Join(dir, "dest.txt")
+	if _, err := transfer(client, &transferpb.TransferRequest{Direction: transferpb.TransferRequest_DOWNLOAD, Blob: "p/b.txt", Path: dest}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, dest); got != "hello" {
+		t.Errorf("downloaded %q", got)
+	}
+
+	blob, err := client.Stat(ctx, &transferpb.StatRequest{Blob: "p/b.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob.Name != "p/b.txt" || blob.Size != 5 || blob.Etag == "" || blob.LastModified == nil {
+		t.Errorf("stat = %v", blob)
+	}
+	m.put("q/c.txt", []byte("c"), nil)
+	stream, err := client.List(ctx, &transferpb.ListRequest{Prefix: "p/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for {
+		b, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, b.Name)
+	}
+	if len(names) != 1 || names[0] != "p/b.txt" {
+		t.Errorf("list = %v", names)
+	}
+
+	for _, tt := range []struct {
+		req  *transferpb.TransferRequest
+		want codes.Code
+	}{
+		{&transferpb.TransferRequest{Direction: transferpb.TransferRequest_DOWNLOAD, Blob: "missing", Path: dest}, codes.NotFound},
+		{&transferpb.TransferRequest{Direction: transferpb.TransferRequest_UPLOAD, Blob: "b", Path: filepath.Join(dir, "missing")}, codes.NotFound},
+		{&transferpb.TransferRequest{Direction: transferpb.TransferRequest_DOWNLOAD, Blob: "p/b.txt", Path: "relative.txt"}, codes.InvalidArgument},
+		{&transferpb.TransferRequest{Blob: "p/b.txt", Path: dest}, codes.InvalidArgument},
+	} {
+		if _, err := transfer(client, tt.req); status.Code(err) != tt.want {
+			t.Errorf("transfer %v = %v, want %s", tt.req, err, tt.want)
+		}
+	}
+	if _, err := client.Stat(ctx, &transferpb.StatRequest{Blob: "missing"}); status.Code(err) != codes.NotFound {
+		t.Errorf("stat of a missing blob = %v", err)
+	}
+}
+
+func TestTransferServerRequiresToken(t *testing.T) {
+	m := newMemContainer()
+	m.put("p/b.txt", []byte("b"), nil)
+	az := newTestClient(t, m)
+	ctx := context.Background()
+	for _, token := range []string{"", "wrong"} {
+		client := serveTransfers(t, az, token)
+		if _, err := client.Stat(ctx, &transferpb.StatRequest{Blob: "p/b.txt"}); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("stat with token %q = %v", token, err)
+		}
+		stream, err := client.List(ctx, &transferpb.ListRequest{Prefix: "p/"})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("list with token %q = %v", token, err)
+		}
+		dest := filepath.Join(t.TempDir(), "b.txt")
+		if _, err := transfer(client, &transferpb.TransferRequest{Direction: transferpb.TransferRequest_DOWNLOAD, Blob: "p/b.txt", Path: dest}); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("transfer with token %q = %v", token, err)
+		}
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and
+// its key to dir.
+func writeTestCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "bk_azureblob test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = writeFile(t, filepath.Join(dir, "cert.pem"), string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	keyFile = writeFile(t, filepath.Join(dir, "key.pem"), string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+	return certFile, keyFile
+}
+
+func TestGRPCTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir)
+	config, err := grpcTLS(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Certificates) != 1 || config.ClientAuth != tls.NoClientCert {
+		t.Errorf("config without client CA = %+v", config)
+	}
+	if config, err = grpcTLS(certFile, keyFile, certFile); err != nil {
+		t.Fatal(err)
+	}
+	if config.ClientAuth != tls.RequireAndVerifyClientCert || config.ClientCAs == nil {
+		t.Errorf("client certificates are not required: %+v", config)
+	}
+	if _, err := grpcTLS(certFile, keyFile, keyFile); err == nil {
+		t.Error("client CA file without certificates accepted")
+	}
+}
+
+func TestRunGRPCArgs(t *testing.T) {
+	az := newTestClient(t, newMemContainer())
+	dir := t.TempDir()
+	certFile, _ := writeTestCertificate(t, dir)
+	for _, args := range [][]string{
+		{"extra"},
+		{"-listen", "127.0.0.1:0", "-socket", "g.sock"},
+		{"-tls-cert", certFile},
+		{"-client-ca", certFile},
+		{"-listen", "0.0.0.0:0"},
+		{"-token-file", filepath.Join(dir, "missing")},
+	} {
+		if err := runGRPC(context.Background(), az, args); err == nil {
+			t.Errorf("grpc %q succeeded", args)
+		}
+	}
+}
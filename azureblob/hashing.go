@@ -0,0 +1,274 @@
+package azureblob
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// hashBufferSize is the size of the reads that hash files. Large reads make
+// handing each to the goroutines of a multiHash cheap next to hashing it.
+const hashBufferSize = 1 << 20
+
+// hashPool runs the hashing of many files, one file per core. Hashing is
+// bound by the CPU rather than the network, so it does not share the limits
+// of c.Pool.
+var hashPool = NewTransferPool(runtime.NumCPU(), 0)
+
+// newDigest returns a hash of the algorithm a manifest names, sha256 or md5.
+func newDigest(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "sha256":
+		return sha256.New(), nil
+	case "md5":
+		return md5.New(), nil
+	}
+	return nil, fmt.Errorf("unknown digest %q", algorithm)
+}
+
+// multiHash computes several digests of one stream in a single pass. Each
+// digest is computed on a goroutine of its own, so checking a file against
+// both SHA-256 and MD5 takes as long as the slower of the two rather than
+// both.
+type multiHash struct {
+	algorithms []string
+	hashes     []hash.Hash
+}
+
+// newMultiHash returns a multiHash of algorithms, or nil if there are none.
+func newMultiHash(algorithms []string) (*multiHash, error) {
+	if len(algorithms) == 0 {
+		return nil, nil
+	}
+	m := &multiHash{algorithms: algorithms}
+	for _, algorithm := range algorithms {
+		h, err := newDigest(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		m.hashes = append(m.hashes, h)
+	}
+	return m, nil
+}
+
+// Write hashes p with every digest, concurrently if there are several. It
+// returns once all have consumed p, so p may be reused as io.Copy does.
+func (m *multiHash) Write(p []byte) (int, error) {
+	if len(m.hashes) == 1 {
+		return m.hashes[0].Write(p)
+	}
+	var wg sync.WaitGroup
+	for _, h := range m.hashes {
+		wg.Add(1)
+		go func(h hash.Hash) {
+			defer wg.Done()
+			h.Write(p)
+		}(h)
+	}
+	wg.Wait()
+	return len(p), nil
+}
+
+// Reset discards what was hashed, for a transfer that starts over.
+func (m *multiHash) Reset() {
+	for _, h := range m.hashes {
+		h.Reset()
+	}
+}
+
+// sums returns the hex digests by algorithm.
+func (m *multiHash) sums() map[string]string {
+	sums := make(map[string]string, len(m.hashes))
+	for i, h := range m.hashes {
+		sums[m.algorithms[i]] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums
+}
+
+// fileDigests returns the hex digests of the file at path by algorithm,
+// reading it once whatever the number of algorithms.
+func fileDigests(path string, algorithms []string) (map[string]string, error) {
+	m, err := newMultiHash(algorithms)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := getBuffer(hashBufferSize)
+	defer putBuffer(buf)
+	if _, err := io.CopyBuffer(m, f, *buf); err != nil {
+		return nil, err
+	}
+	return m.sums(), nil
+}
+
+// digestRequest asks the downloads run under a context to hash the content
+// they write as they write it, so that checking it afterwards does not read
+// the file a second time. Downloads that do not write the content
+// themselves, such as dedup links, leave it unanswered.
+type digestRequest struct {
+	algorithms []string
+
+	mu     sync.Mutex
+	result map[string]string
+}
+
+type digestRequestKey struct{}
+
+// withDigests returns a copy of ctx asking downloads for the digests of
+// algorithms. It returns ctx and a nil request if there are none.
+func withDigests(ctx context.Context, algorithms []string) (context.Context, *digestRequest) {
+	if len(algorithms) == 0 {
+		return ctx, nil
+	}
+	r := &digestRequest{algorithms: algorithms}
+	return context.WithValue(ctx, digestRequestKey{}, r), r
+}
+
+// digestRequestFrom returns the digests requested under ctx, or nil.
+func digestRequestFrom(ctx context.Context) *digestRequest {
+	r, _ := ctx.Value(digestRequestKey{}).(*digestRequest)
+	return r
+}
+
+// hash returns a multiHash for a download to write the content to, or nil
+// if r is nil.
+func (r *digestRequest) hash() (*multiHash, error) {
+	if r == nil {
+		return nil, nil
+	}
+	return newMultiHash(r.algorithms)
+}
+
+// answer records the digests of a download that wrote all of its content
+// to m. It does nothing if m is nil.
+func (r *digestRequest) answer(m *multiHash) {
+	if m == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.result = m.sums()
+}
+
+// sums returns the digests by algorithm, or nil if no download answered.
+func (r *digestRequest) sums() map[string]string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.result
+}
+
+// orderedHasher hashes a file that a parallel download writes in chunks
+// out of order. It reads back each stretch of the file, from the page cache,
+// as soon as everything before it has been written, so the hashing keeps up
+// with the download instead of following it.
+type orderedHasher struct {
+	f    *os.File
+	h    io.Writer
+	size int64
+
+	mu sync.Mutex
+	// written is where the written prefix of the file ends, and pending
+	// maps the start of each stretch written past it to its end.
+	written int64
+	pending map[int64]int64
+	stopped bool
+	wake    *sync.Cond
+
+	done chan struct{}
+	err  error
+}
+
+// newOrderedHasher starts hashing the size bytes that will be written to f
+// into h.
+func newOrderedHasher(f *os.File, h io.Writer, size int64) *orderedHasher {
+	o := &orderedHasher{f: f, h: h, size: size, pending: map[int64]int64{}, done: make(chan struct{})}
+	o.wake = sync.NewCond(&o.mu)
+	go o.run()
+	return o
+}
+
+// WriteAt writes p to the file at off and lets the hashing catch up with
+// it.
+func (o *orderedHasher) WriteAt(p []byte, off int64) (int, error) {
+	n, err := o.f.WriteAt(p, off)
+	if n > 0 {
+		o.mu.Lock()
+		o.pending[off] = off + int64(n)
+		for end, ok := o.pending[o.written]; ok; end, ok = o.pending[o.written] {
+			delete(o.pending, o.written)
+			o.written = end
+		}
+		o.wake.Signal()
+		o.mu.Unlock()
+	}
+	return n, err
+}
+
+func (o *orderedHasher) run() {
+	defer close(o.done)
+	pooled := getBuffer(hashBufferSize)
+	defer putBuffer(pooled)
+	buf := *pooled
+	var hashed int64
+	for hashed < o.size {
+		o.mu.Lock()
+		for o.written == hashed && !o.stopped {
+			o.wake.Wait()
+		}
+		end, stopped := o.written, o.stopped
+		o.mu.Unlock()
+		if stopped && end == hashed {
+			return
+		}
+		for hashed < end {
+			n := int64(len(buf))
+			if end-hashed < n {
+				n = end - hashed
+			}
+			if _, err := o.f.ReadAt(buf[:n], hashed); err != nil {
+				o.err = err
+				o.stop()
+				return
+			}
+			o.h.Write(buf[:n])
+			hashed += n
+		}
+	}
+}
+
+func (o *orderedHasher) stop() {
+	o.mu.Lock()
+	o.stopped = true
+	o.wake.Signal()
+	o.mu.Unlock()
+}
+
+// finish waits until the hashing has caught up with the download. It
+// returns an error if not all size bytes were written.
+func (o *orderedHasher) finish() error {
+	o.stop()
+	<-o.done
+	if o.err != nil {
+		return o.err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.written != o.size {
+		return fmt.Errorf("hash %s: %d of %d bytes written", o.f.Name(), o.written, o.size)
+	}
+	return nil
+}
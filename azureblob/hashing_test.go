@@ -0,0 +1,146 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func md5Hex(b []byte) string {
+	sum := md5.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestMultiHash(t *testing.T) {
+	data := bytes.Repeat([]byte("multi-core hashing "), 10000)
+	m, err := newMultiHash([]string{"sha256", "md5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p := data; len(p) > 0; {
+		n := len(p)
+		if n > 7000 {
+			n = 7000
+		}
+		m.Write(p[:n])
+		p = p[n:]
+	}
+	want := map[string]string{"sha256": sha256Hex(data), "md5": md5Hex(data)}
+	if got := m.sums(); got["sha256"] != want["sha256"] || got["md5"] != want["md5"] {
+		t.Errorf("sums = %v, want %v", got, want)
+	}
+	m.Reset()
+	if got := m.sums(); got["md5"] != md5Hex(nil) {
+		t.Errorf("md5 after Reset = %s", got["md5"])
+	}
+
+	if _, err := newMultiHash([]string{"crc32"}); err == nil {
+		t.Error("an unknown algorithm was accepted")
+	}
+	path := writeFile(t, filepath.Join(t.TempDir(), "f"), string(data))
+	if sums, err := fileDigests(path, []string{"md5"}); err != nil || sums["md5"] != want["md5"] {
+		t.Errorf("fileDigests = %v, %v", sums, err)
+	}
+}
+
+func TestOrderedHasher(t *testing.T) {
+	data := make([]byte, 3*hashBufferSize+123)
+	rand.New(rand.NewSource(1)).Read(data)
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m, err := newMultiHash([]string{"sha256"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher := newOrderedHasher(f, m, int64(len(data)))
+	// Write the chunks concurrently, the last first, in pieces as a chunk of
+	// a parallel download arrives.
+	const chunk = 500000
+	var wg sync.WaitGroup
+	for start := (len(data) - 1) / chunk * chunk; start >= 0; start -= chunk {
+		end := start + chunk
+		if end > len(data) {
+			end = len(data)
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for off := start; off < end; off += 32 << 10 {
+				n := end - off
+				if n > 32<<10 {
+					n = 32 << 10
+				}
+				if _, err := hasher.WriteAt(data[off:off+n], int64(off)); err != nil {
+					t.Error(err)
+				}
+			}
+		}(start, end)
+	}
+	wg.Wait()
+	if err := hasher.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.sums()["sha256"]; got != sha256Hex(data) {
+		t.Errorf("sha256 = %s, want %s", got, sha256Hex(data))
+	}
+
+	// A download that stops short leaves the hash unfinished.
+	m.Reset()
+	hasher = newOrderedHasher(f, m, int64(len(data)))
+	hasher.WriteAt(data[:10], 0)
+	if err := hasher.finish(); err == nil {
+		t.Error("finish of a partial download succeeded")
+	}
+}
+
+func TestDownloadHashesWhileWriting(t *testing.T) {
+	plain := make([]byte, 9<<20)
+	rand.New(rand.NewSource(2)).Read(plain)
+	m := newMemContainer()
+	m.put("plain", plain, nil)
+	az := newTestClient(t, m)
+	az.ClientOptions.Compression = compressionGzip
+	ctx := context.Background()
+	dir := t.TempDir()
+	f, err := os.Open(writeFile(t, filepath.Join(dir, "src"), "compressed content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := az.Upload(ctx, f, "compressed"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string][]byte{"plain": plain, "compressed": []byte("compressed content")} {
+		ctx, digests := withDigests(ctx, []string{"sha256", "md5"})
+		if _, err := az.Download(ctx, name, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+		sums := digests.sums()
+		if sums["sha256"] != sha256Hex(content) || sums["md5"] != md5Hex(content) {
+			t.Errorf("%s: digests = %v", name, sums)
+		}
+	}
+
+	// A manifest download checks the digests computed on the way.
+	item := ManifestItem{Blob: "plain", Path: filepath.Join(dir, "checked"), SHA256: sha256Hex(plain), MD5: md5Hex(plain)}
+	if err := downloadManifestItem(ctx, az, item); err != nil {
+		t.Fatal(err)
+	}
+	item.MD5 = md5Hex(nil)
+	if err := downloadManifestItem(ctx, az, item); err == nil {
+		t.Error("a download with the wrong MD5 passed")
+	}
+	if _, err := os.Stat(item.Path); !os.IsNotExist(err) {
+		t.Errorf("a download that failed verification was left behind: %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	azruntime "github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// immutabilityVersion is the first service version with blob-level
+// immutability policies and legal holds, which the SDK does not cover yet.
+const immutabilityVersion = "2020-10-02"
+
+// SetImmutabilityPolicy makes blobPath immutable until until: it can be
+// neither changed nor deleted before then. An unlocked policy can still be
+// shortened or cleared, while a locked one can only be extended. The
+// container must have version-level immutability support enabled.
+func (c *AzureBlobClient) SetImmutabilityPolicy(ctx context.Context, blobPath string, until time.Time, locked bool) error {
+	mode := "Unlocked"
+	if locked {
+		mode = "Locked"
+	}
+	return c.immutabilityRequest(ctx, "set immutability policy", http.MethodPut, blobPath, "immutabilityPolicies", http.Header{
+		"x-ms-immutability-policy-until-date": {until.UTC().Format(http.TimeFormat)},
+		"x-ms-immutability-policy-mode":       {mode},
+	})
+}
+
+// ClearImmutabilityPolicy removes the unlocked immutability policy of
+// blobPath.
+func (c *AzureBlobClient) ClearImmutabilityPolicy(ctx context.Context, blobPath string) error {
+	return c.immutabilityRequest(ctx, "clear immutability policy", http.MethodDelete, blobPath, "immutabilityPolicies", nil)
+}
+
+// SetLegalHold places or, if hold is false, lifts a legal hold on blobPath.
+// A blob under a legal hold cannot be changed or deleted, regardless of its
+// immutability policy, until the hold is lifted.
+func (c *AzureBlobClient) SetLegalHold(ctx context.Context, blobPath string, hold bool) error {
+	return c.immutabilityRequest(ctx, "set legal hold", http.MethodPut, blobPath, "legalhold", http.Header{
+		"x-ms-legal-hold": {strconv.FormatBool(hold)},
+	})
+}
+
+// immutabilityRequest sends a request with the query comp to blobPath
+// through a pipeline configured like the container client's.
+func (c *AzureBlobClient) immutabilityRequest(ctx context.Context, op, method, blobPath, comp string, header http.Header) error {
+	return c.withRebuild(ctx, op, blobPath, func() error {
+		if err := c.init(ctx); err != nil {
+			return err
+		}
+		pl, err := c.metadataPipeline()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := c.metadataContext(ctx)
+		defer cancel()
+		u, err := url.Parse(c.containerClient.NewBlobClient(blobPath).URL())
+		if err != nil {
+			return err
+		}
+		q := u.Query()
+		q.Set("comp", comp)
+		u.RawQuery = q.Encode()
+		req, err := azruntime.NewRequest(ctx, method, u.String())
+		if err != nil {
+			return err
+		}
+		for k, v := range header {
+			req.Raw().Header[k] = v
+		}
+		req.Raw().Header.Set("x-ms-version", immutabilityVersion)
+		resp, err := pl.Do(req)
+		if err == nil && !azruntime.HasStatusCode(resp, http.StatusOK, http.StatusAccepted) {
+			err = azruntime.NewResponseError(errors.New(http.StatusText(resp.StatusCode)), resp)
+		}
+		return newBlobError(op, blobPath, err)
+	})
+}
+
+// metadataPipeline returns a pipeline for requests the SDK has no method
+// for, with the transport, telemetry and policies of the container client
+// and the metadata retry policy. c must be initialised.
+func (c *AzureBlobClient) metadataPipeline() (azruntime.Pipeline, error) {
+	transport, err := c.blobTransporter()
+	if err != nil {
+		return azruntime.Pipeline{}, err
+	}
+	telemetry, err := c.clientOptions().telemetry()
+	if err != nil {
+		return azruntime.Pipeline{}, err
+	}
+	c.initMu.Lock()
+	credential := c.credential
+	c.initMu.Unlock()
+	perCall := []policy.Policy{newRequestExtrasPolicy(c.clientOptions()), c.authPolicy(credential)}
+	return azruntime.NewPipeline("bk_azureblob", "v1", perCall, nil, &policy.ClientOptions{
+		Transport: transport,
+		Retry:     c.clientOptions().MetadataRetry,
+		Telemetry: telemetry,
+	}), nil
+}
+
+// protectUpload applies the immutability policy and legal hold that
+// ClientOptions asks for to the newly uploaded blobPath.
+func (c *AzureBlobClient) protectUpload(ctx context.Context, blobPath string) error {
+	o := c.clientOptions()
+	if o.ImmutableFor > 0 {
+		if err := c.SetImmutabilityPolicy(ctx, blobPath, time.Now().Add(o.ImmutableFor), o.ImmutabilityLocked); err != nil {
+			return err
+		}
+	}
+	if o.LegalHold {
+		return c.SetLegalHold(ctx, blobPath, true)
+	}
+	return nil
+}
+
+func runImmutability(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("immutability", flag.ContinueOnError)
+	period := fs.Duration("for", 0, "keep the blobs immutable for `duration` from now, e.g. 8760h")
+	until := fs.String("until", "", "keep the blobs immutable until an RFC 3339 `time`")
+	locked := fs.Bool("locked", false, "lock the policy, so it can only be extended")
+	remove := fs.Bool("clear", false, "remove the unlocked policy instead")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: immutability (-for <duration> | -until <time>) [-locked] <blob>...\n       immutability -clear <blob>...\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("immutability takes blob names")
+	}
+	var expiry time.Time
+	switch {
+	case *remove:
+		if *period != 0 || *until != "" || *locked {
+			return errors.New("-clear takes no -for, -until or -locked")
+		}
+	case (*period > 0) == (*until != ""):
+		return errors.New("immutability takes either a positive -for or -until")
+	case *period > 0:
+		expiry = time.Now().Add(*period)
+	default:
+		var err error
+		if expiry, err = time.Parse(time.RFC3339, *until); err != nil {
+			return fmt.Errorf("-until: %w", err)
+		}
+	}
+	return eachBlob(fs.Args(), func(blob string) error {
+		if *remove {
+			return az.ClearImmutabilityPolicy(ctx, blob)
+		}
+		return az.SetImmutabilityPolicy(ctx, blob, expiry, *locked)
+	})
+}
+
+func runLegalHold(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("legal-hold", flag.ContinueOnError)
+	lift := fs.Bool("clear", false, "lift the legal hold instead of placing it")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: legal-hold [-clear] <blob>...\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("legal-hold takes blob names")
+	}
+	return eachBlob(fs.Args(), func(blob string) error {
+		return az.SetLegalHold(ctx, blob, !*lift)
+	})
+}
+
+// eachBlob runs fn for every blob, printing the errors, and fails if any
+// call did.
+func eachBlob(blobs []string, fn func(blob string) error) error {
+	failures := &transferFailures{total: len(blobs), noun: "blobs"}
+	for _, blob := range blobs {
+		if err := fn(blob); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failures.errs = append(failures.errs, err)
+		}
+	}
+	if len(failures.errs) > 0 {
+		return failures
+	}
+	return nil
+}
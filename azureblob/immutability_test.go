@@ -0,0 +1,118 @@
+package azureblob
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// immutabilityRecorder answers immutability and legal hold requests for the
+// blobs of m, recording them, and passes everything else on to m.
+type immutabilityRecorder struct {
+	m *memContainer
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (h *immutabilityRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	comp := r.URL.Query().Get("comp")
+	if comp != "immutabilityPolicies" && comp != "legalhold" {
+		h.m.ServeHTTP(w, r)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/container/")
+	h.m.mu.Lock()
+	_, ok := h.m.blobs[name]
+	h.m.mu.Unlock()
+	if !ok {
+		w.Header().Set("x-ms-error-code", "BlobNotFound")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	line := r.Method + " " + name + " " + comp + " " + r.Header.Get("x-ms-version")
+	for _, k := range []string{"x-ms-immutability-policy-mode", "x-ms-legal-hold"} {
+		if v := r.Header.Get(k); v != "" {
+			line += " " + v
+		}
+	}
+	if until := r.Header.Get("x-ms-immutability-policy-until-date"); until != "" {
+		if _, err := time.Parse(http.TimeFormat, until); err != nil {
+			line += " bad-date"
+		}
+	}
+	h.mu.Lock()
+	h.requests = append(h.requests, line)
+	h.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *immutabilityRecorder) take() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r := h.requests
+	h.requests = nil
+	return r
+}
+
+func TestUploadProtection(t *testing.T) {
+	h := &immutabilityRecorder{m: newMemContainer()}
+	az := newTestClient(t, h)
+	az.ClientOptions.ImmutableFor = 24 * time.Hour
+	az.ClientOptions.ImmutabilityLocked = true
+	az.ClientOptions.LegalHold = true
+	f, err := os.Open(writeFile(t, filepath.Join(t.TempDir(), "release"), "release"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := az.Upload(context.Background(), f, "release.tar"); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(h.take(), "\n")
+	want := "PUT release.tar immutabilityPolicies 2020-10-02 Locked\nPUT release.tar legalhold 2020-10-02 true"
+	if got != want {
+		t.Errorf("requests:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRunImmutabilityAndLegalHold(t *testing.T) {
+	h := &immutabilityRecorder{m: newMemContainer()}
+	h.m.put("a", []byte("a"), nil)
+	az := newTestClient(t, h)
+	ctx := context.Background()
+	tests := []struct {
+		run  func(context.Context, *AzureBlobClient, []string) error
+		args []string
+		want string
+	}{
+		{runImmutability, []string{"-for", "1h", "a"}, "PUT a immutabilityPolicies 2020-10-02 Unlocked"},
+		{runImmutability, []string{"-until", "2030-01-01T00:00:00Z", "-locked", "a"}, "PUT a immutabilityPolicies 2020-10-02 Locked"},
+		{runImmutability, []string{"-clear", "a"}, "DELETE a immutabilityPolicies 2020-10-02"},
+		{runLegalHold, []string{"a"}, "PUT a legalhold 2020-10-02 true"},
+		{runLegalHold, []string{"-clear", "a"}, "PUT a legalhold 2020-10-02 false"},
+	}
+	for _, tt := range tests {
+		if err := tt.run(ctx, az, tt.args); err != nil {
+			t.Errorf("%v: %v", tt.args, err)
+		}
+		if got := strings.Join(h.take(), "\n"); got != tt.want {
+			t.Errorf("%v sent %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	err := runLegalHold(ctx, az, []string{"a", "missing"})
+	if err == nil || err.Error() != "1 of 2 blobs failed" || exitCode(err) != exitNotFound {
+		t.Errorf("missing blob: got %v", err)
+	}
+	for _, args := range [][]string{{"a"}, {"-for", "1h", "-until", "2030-01-01T00:00:00Z", "a"}, {"-clear", "-locked", "a"}, {"-until", "tomorrow", "a"}, {"-for", "1h"}} {
+		if err := runImmutability(ctx, az, args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Statuses of the transfers of a job.
+const (
+	JobPending = "pending"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// jobJournalPrefix and jobJournalExt name the journal files of jobs in the
+// state directory.
+const (
+	jobJournalPrefix = "job-"
+	jobJournalExt    = ".jsonl"
+)
+
+var jobIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,99}$`)
+
+// JobJournal records the transfers of a bulk job, such as a manifest or an
+// artifact upload, and the outcome of each as it finishes, so that a run
+// that crashed or was interrupted can be resumed with the transfers it did
+// not complete, without planning or hashing the others again. It is kept in
+// the state directory as JSON lines: the job, then an entry per finished
+// transfer. Each outcome thus costs a single append, and a crash in the
+// middle of one loses only that outcome.
+type JobJournal struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	// Account and Container are those of the client that ran the job,
+	// which transfers without their own default to.
+	Account   string         `json:"account"`
+	Container string         `json:"container"`
+	Downloads []ManifestItem `json:"downloads,omitempty"`
+	Uploads   []ManifestItem `json:"uploads,omitempty"`
+
+	path string
+
+	mu     sync.Mutex
+	f      *os.File
+	status []string
+	errs   []string
+}
+
+// journalEntry is the outcome of the transfer of index Item, counting the
+// downloads of the job first.
+type journalEntry struct {
+	Item   int    `json:"item"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// newJobID returns an ID for a job started now that sorts by its start.
+func newJobID() (string, error) {
+	suffix, err := newUploadID()
+	if err != nil {
+		return "", err
+	}
+	return time.Now().UTC().Format("20060102-150405") + "-" + suffix[:6], nil
+}
+
+// jobJournalPath returns where the journal of job id is kept.
+func (o *AzureBlobClientOptions) jobJournalPath(id string) (string, error) {
+	if !jobIDPattern.MatchString(id) {
+		return "", fmt.Errorf("job ID %q must be letters, digits, '.', '_' and '-' only", id)
+	}
+	return o.statePath(jobJournalPrefix + id + jobJournalExt)
+}
+
+// createJobJournal starts the journal of job id, running m as a client of
+// account and container. The job must not have a journal already.
+func (o *AzureBlobClientOptions) createJobJournal(id, account, container string, m *Manifest) (*JobJournal, error) {
+	path, err := o.jobJournalPath(id)
+	if err != nil {
+		return nil, err
+	}
+	j := &JobJournal{ID: id, Created: time.Now().UTC(), Account: account, Container: container, Downloads: m.Downloads, Uploads: m.Uploads, path: path}
+	header, err := json.Marshal(j)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("job %s already has a journal; resume it with -resume-job %[1]s, or discard it with jobs -discard %[1]s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(append(header, '\n')); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	j.f = f
+	j.status = make([]string, len(m.Downloads)+len(m.Uploads))
+	j.errs = make([]string, len(j.status))
+	for i := range j.status {
+		j.status[i] = JobPending
+	}
+	return j, nil
+}
+
+// readJobJournal reads the journal at path. An entry cut short by a crash
+// at the end is ignored; end is the offset of the data before it.
+func readJobJournal(path string) (j *JobJournal, end int64, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	header := b
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		header = b[:i]
+	}
+	j = &JobJournal{path: path}
+	if err := json.Unmarshal(header, j); err != nil || len(header) == len(b) {
+		return nil, 0, fmt.Errorf("job journal %s has no job", path)
+	}
+	j.status = make([]string, len(j.Downloads)+len(j.Uploads))
+	j.errs = make([]string, len(j.status))
+	for i := range j.status {
+		j.status[i] = JobPending
+	}
+	end = int64(len(header) + 1)
+	for _, line := range bytes.SplitAfter(b[end:], []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var e journalEntry
+		if err := json.Unmarshal(line, &e); err != nil || line[len(line)-1] != '\n' {
+			if int(end)+len(line) == len(b) {
+				break
+			}
+			return nil, 0, fmt.Errorf("job journal %s has a malformed entry at byte %d", path, end)
+		}
+		if e.Item < 0 || e.Item >= len(j.status) || (e.Status != JobDone && e.Status != JobFailed) {
+			return nil, 0, fmt.Errorf("job journal %s has an invalid entry at byte %d", path, end)
+		}
+		j.status[e.Item], j.errs[e.Item] = e.Status, e.Error
+		end += int64(len(line))
+	}
+	return j, end, nil
+}
+
+// openJobJournal opens the journal of job id to resume it.
+func (o *AzureBlobClientOptions) openJobJournal(id string) (*JobJournal, error) {
+	path, err := o.jobJournalPath(id)
+	if err != nil {
+		return nil, err
+	}
+	j, end, err := readJobJournal(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("job %s has no journal; it finished, was discarded, or ran with another -state-dir", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	// Appends go after the last whole entry.
+	if err := f.Truncate(end); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	j.f = f
+	return j, nil
+}
+
+// manifest returns the transfers of the job.
+func (j *JobJournal) manifest() *Manifest {
+	return &Manifest{Downloads: j.Downloads, Uploads: j.Uploads}
+}
+
+// Counts returns how many transfers of the job are done, failed and
+// pending.
+func (j *JobJournal) Counts() (done, failed, pending int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, s := range j.status {
+		switch s {
+		case JobDone:
+			done++
+		case JobFailed:
+			failed++
+		default:
+			pending++
+		}
+	}
+	return done, failed, pending
+}
+
+// done reports whether transfer i completed in an earlier run.
+func (j *JobJournal) done(i int) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status[i] == JobDone
+}
+
+// record appends the outcome of transfer i. A transfer cut short by
+// cancellation stays pending.
+func (j *JobJournal) record(i int, err error) error {
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	e := journalEntry{Item: i, Status: JobDone}
+	if err != nil {
+		e.Status, e.Error = JobFailed, err.Error()
+	}
+	b, merr := json.Marshal(e)
+	if merr != nil {
+		return merr
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status[i], j.errs[i] = e.Status, e.Error
+	_, werr := j.f.Write(append(b, '\n'))
+	return werr
+}
+
+// finish closes the journal, removing it if every transfer is done. It
+// reports whether it did.
+func (j *JobJournal) finish() (bool, error) {
+	err := j.f.Close()
+	if _, failed, pending := j.Counts(); failed > 0 || pending > 0 {
+		return false, err
+	}
+	if rerr := os.Remove(j.path); err == nil {
+		err = rerr
+	}
+	return true, err
+}
+
+// startJob starts the journal of the bulk job m run by c, named by
+// ClientOptions.JobID or else a new ID. Without an explicit ID, a journal
+// that cannot be written only costs the job its resumability, and the job
+// runs without one.
+func (c *AzureBlobClient) startJob(m *Manifest) (*JobJournal, error) {
+	o := c.clientOptions()
+	id := o.JobID
+	if id == "" {
+		var err error
+		if id, err = newJobID(); err != nil {
+			return nil, err
+		}
+	}
+	j, err := o.createJobJournal(id, c.StorageAccount, c.ContainerName, m)
+	if err != nil && o.JobID == "" {
+		log.Print(c.Messages.format(MsgJobNotJournaled, id, err))
+		return nil, nil
+	}
+	return j, err
+}
+
+// runJob runs m, with progress reporting and a result line per item, as
+// the job of j, unless j is nil. A job left unfinished is logged with how
+// to resume it.
+func runJob(ctx context.Context, az *AzureBlobClient, m *Manifest, j *JobJournal) []ManifestResult {
+	stop := reportProgress(os.Stderr, az.Messages, az.shareProgress(), progressInterval)
+	results := az.runManifest(ctx, m, j)
+	stop()
+	printManifestResults(az.Messages, results)
+	if j == nil {
+		return results
+	}
+	finished, err := j.finish()
+	if err != nil {
+		log.Print(az.Messages.format(MsgJobJournalFailed, j.ID, err))
+	}
+	if !finished {
+		done, failed, pending := j.Counts()
+		log.Print(az.Messages.format(MsgJobUnfinished, j.ID, failed+pending, done+failed+pending, j.ID))
+	}
+	return results
+}
+
+// resumeJob runs the transfers of job id that its journal does not record
+// as done, in a client of the account and container the job ran in.
+// It fails if any of them does.
+func (c *AzureBlobClient) resumeJob(ctx context.Context, id string) error {
+	j, err := c.clientOptions().openJobJournal(id)
+	if err != nil {
+		return err
+	}
+	client := c
+	if j.Account != c.StorageAccount || j.Container != c.ContainerName {
+		if client, err = NewClientRegistry(c).Client(j.Account, j.Container); err != nil {
+			j.f.Close()
+			return err
+		}
+	}
+	return manifestError(runJob(ctx, client, j.manifest(), j))
+}
+
+// jobJournals returns the journals of the unfinished jobs in the state
+// directory, oldest first.
+func (o *AzureBlobClientOptions) jobJournals() ([]*JobJournal, error) {
+	probe, err := o.statePath(jobJournalPrefix + "probe" + jobJournalExt)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(filepath.Dir(probe), jobJournalPrefix+"*"+jobJournalExt))
+	if err != nil {
+		return nil, err
+	}
+	var journals []*JobJournal
+	for _, path := range paths {
+		j, _, err := readJobJournal(path)
+		if err != nil {
+			return nil, err
+		}
+		journals = append(journals, j)
+	}
+	sort.Slice(journals, func(a, b int) bool { return journals[a].Created.Before(journals[b].Created) })
+	return journals, nil
+}
+
+func runJobs(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("jobs", flag.ContinueOnError)
+	discard := fs.String("discard", "", "remove the journal of job `id`, which can then no longer be resumed")
+	output := outputFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: jobs [flags]\n\nLists the unfinished jobs, which -resume-job <id> resumes.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("jobs takes no arguments")
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	o := az.clientOptions()
+	if *discard != "" {
+		path, err := o.jobJournalPath(*discard)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("job %s has no journal", *discard)
+		} else if err != nil {
+			return err
+		}
+		return nil
+	}
+	journals, err := o.jobJournals()
+	if err != nil {
+		return err
+	}
+	type jobRow struct {
+		ID        string    `json:"id"`
+		Created   time.Time `json:"created"`
+		Account   string    `json:"account"`
+		Container string    `json:"container"`
+		Done      int       `json:"done"`
+		Failed    int       `json:"failed"`
+		Pending   int       `json:"pending"`
+	}
+	rows := make([]jobRow, len(journals))
+	for i, j := range journals {
+		done, failed, pending := j.Counts()
+		rows[i] = jobRow{j.ID, j.Created, j.Account, j.Container, done, failed, pending}
+	}
+	switch *output {
+	case outputJSON:
+		return printJSON(rows)
+	case outputCSV:
+		records := make([][]string, len(rows))
+		for i, r := range rows {
+			records[i] = []string{r.ID, r.Created.Format(time.RFC3339), r.Account + "/" + r.Container, strconv.Itoa(r.Done), strconv.Itoa(r.Failed), strconv.Itoa(r.Pending)}
+		}
+		return printCSV([]string{"id", "created", "container", "done", "failed", "pending"}, records)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, r := range rows {
+		fmt.Fprintf(w, "%s\t%s\t%s/%s\t%d done, %d failed, %d pending\n", r.ID, r.Created.Format(time.RFC3339), r.Account, r.Container, r.Done, r.Failed, r.Pending)
+	}
+	return w.Flush()
+}
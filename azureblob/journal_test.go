@@ -0,0 +1,164 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResumeJob(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	az.ClientOptions.JobID = "nightly-1"
+	ctx := context.Background()
+	dir := t.TempDir()
+	a := writeFile(t, filepath.Join(dir, "a.zip"), "a")
+	b := filepath.Join(dir, "b.zip")
+	job := &Manifest{Uploads: []ManifestItem{{Blob: "a.zip", Path: a}, {Blob: "b.zip", Path: b}}}
+
+	var err error
+	captureStdout(t, func() { err = runTransfers(ctx, az, job) })
+	if err == nil {
+		t.Fatal("uploading a missing file succeeded")
+	}
+	path, err := az.clientOptions().jobJournalPath("nightly-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("the failed job left no journal: %v", err)
+	}
+	if err := runTransfers(ctx, az, job); err == nil || !strings.Contains(err.Error(), "-resume-job nightly-1") {
+		t.Errorf("starting the job again = %v", err)
+	}
+
+	delete(m.blobs, "a.zip")
+	writeFile(t, b, "b")
+	out := captureStdout(t, func() { err = az.resumeJob(ctx, "nightly-1") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.blobs["a.zip"]; ok {
+		t.Error("a transfer done in the first run was made again")
+	}
+	if _, ok := m.blobs["b.zip"]; !ok {
+		t.Error("the failed transfer was not made")
+	}
+	if !strings.Contains(out, "a.zip: ok (in an earlier run)") {
+		t.Errorf("results:\n%s", out)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the finished job kept its journal: %v", err)
+	}
+	if err := az.resumeJob(ctx, "nightly-1"); err == nil {
+		t.Error("a finished job was resumed")
+	}
+}
+
+func TestJobJournalTornEntry(t *testing.T) {
+	o := &AzureBlobClientOptions{StateDir: t.TempDir()}
+	m := &Manifest{Downloads: []ManifestItem{{Blob: "a", Path: "a"}}, Uploads: []ManifestItem{{Blob: "b", Path: "b"}, {Blob: "c", Path: "c"}}}
+	j, err := o.createJobJournal("torn", "account", "container", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.record(0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.record(1, context.Canceled); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.f.WriteString(`{"item":2,"sta`); err != nil {
+		t.Fatal(err)
+	}
+	if finished, err := j.finish(); finished || err != nil {
+		t.Fatalf("finish = %v, %v", finished, err)
+	}
+	if _, err := o.createJobJournal("torn", "account", "container", m); err == nil {
+		t.Error("a second journal of the job was started")
+	}
+
+	j, err = o.openJobJournal("torn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done, failed, pending := j.Counts(); done != 1 || failed != 0 || pending != 2 {
+		t.Errorf("Counts = %d, %d, %d", done, failed, pending)
+	}
+	if j.Account != "account" || len(j.manifest().Uploads) != 2 {
+		t.Errorf("journal = %+v", j)
+	}
+	if err := j.record(2, os.ErrNotExist); err != nil {
+		t.Fatal(err)
+	}
+	j.f.Close()
+	b, err := os.ReadFile(j.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	var last journalEntry
+	if len(lines) != 3 || json.Unmarshal([]byte(lines[2]), &last) != nil || last.Item != 2 || last.Status != JobFailed {
+		t.Errorf("journal after resuming:\n%s", b)
+	}
+
+	if _, err := o.jobJournalPath("../escape"); err == nil {
+		t.Error("a job ID with a path was accepted")
+	}
+}
+
+func TestJobsCommand(t *testing.T) {
+	az := newTestClient(t, newMemContainer())
+	o := az.clientOptions()
+	j, err := o.createJobJournal("nightly-2", "account", "container", &Manifest{Uploads: []ManifestItem{{Blob: "a", Path: "a"}, {Blob: "b", Path: "b"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.record(0, nil)
+	j.f.Close()
+
+	ctx := context.Background()
+	out := captureStdout(t, func() { err = runJobs(ctx, az, []string{"-output", "json"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []struct {
+		ID      string `json:"id"`
+		Done    int    `json:"done"`
+		Pending int    `json:"pending"`
+	}
+	if err := json.Unmarshal([]byte(out), &rows); err != nil || len(rows) != 1 || rows[0].ID != "nightly-2" || rows[0].Done != 1 || rows[0].Pending != 1 {
+		t.Errorf("jobs = %v:\n%s", err, out)
+	}
+	if err := runJobs(ctx, az, []string{"-discard", "nightly-2"}); err != nil {
+		t.Fatal(err)
+	}
+	if journals, err := o.jobJournals(); len(journals) != 0 || err != nil {
+		t.Errorf("jobs after discarding = %v, %v", journals, err)
+	}
+	if err := runJobs(ctx, az, []string{"-discard", "nightly-2"}); err == nil {
+		t.Error("discarding a missing job succeeded")
+	}
+}
+
+func TestStartJobUsesMessages(t *testing.T) {
+	az := newTestClient(t, newMemContainer())
+	// A state directory that is a file cannot hold the journal.
+	az.ClientOptions.StateDir = writeFile(t, filepath.Join(t.TempDir(), "state"), "")
+	az.Messages = Messages{MsgJobNotJournaled: "[%s unjournaled: %v]"}
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	j, err := az.startJob(&Manifest{Uploads: []ManifestItem{{Blob: "a", Path: "a"}}})
+	if j != nil || err != nil {
+		t.Fatalf("startJob = %v, %v", j, err)
+	}
+	if !strings.Contains(logs.String(), " unjournaled: ") {
+		t.Errorf("log %q does not use the catalog", logs.String())
+	}
+}
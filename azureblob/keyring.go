@@ -0,0 +1,424 @@
+package azureblob
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+const (
+	// keyWrapAES is AES key wrap (RFC 3394), which the Azure Storage SDKs
+	// use for local key encryption keys.
+	keyWrapAES = "A256KW"
+	// keyWrapGCM seals the content key with AES-256-GCM and authenticates
+	// the key ID as additional data, so a wrapped key cannot be relabelled
+	// to another KEK.
+	keyWrapGCM = "A256GCM-KW"
+)
+
+// KeyRing holds the key encryption keys (KEKs) that protect the content keys
+// of client-side encrypted blobs, by key ID. Content keys are wrapped under
+// the current KEK, while any KEK in the ring can unwrap one, so blobs written
+// before a key rotation remain readable.
+type KeyRing struct {
+	current string
+	resolve KeyResolver
+
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+// KeyResolver returns the 256-bit KEK with key ID id. It lets a key ring
+// fetch its KEKs from a KMS or secret store when they are first needed
+// instead of holding them from the start.
+type KeyResolver func(ctx context.Context, id string) ([]byte, error)
+
+// NewKeyRing returns a key ring of 256-bit KEKs by key ID, wrapping content
+// keys under the KEK called current.
+func NewKeyRing(current string, keys map[string][]byte) (*KeyRing, error) {
+	r := &KeyRing{current: current, keys: map[string][]byte{}}
+	for id, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %q is %d bytes, want 32", id, len(key))
+		}
+		r.keys[id] = append([]byte(nil), key...)
+	}
+	if _, ok := r.keys[current]; !ok {
+		return nil, fmt.Errorf("current key %q is not in the key ring", current)
+	}
+	return r, nil
+}
+
+// NewResolvingKeyRing returns a key ring that wraps content keys under the KEK
+// called current and obtains every KEK from resolve, once per key ID.
+func NewResolvingKeyRing(current string, resolve KeyResolver) *KeyRing {
+	return &KeyRing{current: current, resolve: resolve, keys: map[string][]byte{}}
+}
+
+// kek returns the KEK called id, resolving it if the ring does not hold it
+// yet.
+func (r *KeyRing) kek(ctx context.Context, id string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if key, ok := r.keys[id]; ok {
+		return key, nil
+	}
+	if r.resolve == nil {
+		return nil, fmt.Errorf("content key is wrapped under key %q, which is not in the key ring", id)
+	}
+	key, err := r.resolve(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("resolve key %q: %w", id, err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key %q is %d bytes, want 32", id, len(key))
+	}
+	r.keys[id] = append([]byte(nil), key...)
+	return r.keys[id], nil
+}
+
+// Current returns the ID of the KEK that content keys are wrapped under.
+func (r *KeyRing) Current() string {
+	return r.current
+}
+
+// wrappedContentKey is the WrappedContentKey of encryptionData.
+type wrappedContentKey struct {
+	KeyID        string `json:"KeyId"`
+	EncryptedKey []byte `json:"EncryptedKey"`
+	Algorithm    string `json:"Algorithm"`
+}
+
+// wrap wraps key under the current KEK with algorithm.
+func (r *KeyRing) wrap(ctx context.Context, algorithm string, key []byte) (*wrappedContentKey, error) {
+	kek, err := r.kek(ctx, r.current)
+	if err != nil {
+		return nil, err
+	}
+	var wrapped []byte
+	switch algorithm {
+	case keyWrapAES:
+		wrapped, err = aesKeyWrap(kek, key)
+	case keyWrapGCM:
+		wrapped, err = gcmKeyWrap(kek, key, r.current)
+	default:
+		err = fmt.Errorf("unsupported key wrap algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedContentKey{KeyID: r.current, EncryptedKey: wrapped, Algorithm: algorithm}, nil
+}
+
+// unwrap returns the key wrapped in w, using whichever KEK it names.
+func (r *KeyRing) unwrap(ctx context.Context, w *wrappedContentKey) ([]byte, error) {
+	kek, err := r.kek(ctx, w.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	var key []byte
+	switch w.Algorithm {
+	case keyWrapAES:
+		key, err = aesKeyUnwrap(kek, w.EncryptedKey)
+	case keyWrapGCM:
+		key, err = gcmKeyUnwrap(kek, w.EncryptedKey, w.KeyID)
+	default:
+		return nil, fmt.Errorf("unsupported key wrap algorithm %q", w.Algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unwrap content key with key %q: %w", w.KeyID, err)
+	}
+	return key, nil
+}
+
+var (
+	keyWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+	errWrappedKeyTruncated = errors.New("wrapped key is truncated")
+	errWrappedKeyIntegrity = errors.New("wrapped key failed its integrity check")
+)
+
+// aesKeyWrap wraps key under kek as specified by RFC 3394 section 2.2.1.
+func aesKeyWrap(kek, key []byte) ([]byte, error) {
+	if len(key) < 16 || len(key)%8 != 0 {
+		return nil, fmt.Errorf("cannot wrap a %d byte key", len(key))
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(key) / 8
+	out := make([]byte, 8+len(key))
+	copy(out, keyWrapIV)
+	copy(out[8:], key)
+	var b [16]byte
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(b[:8], out[:8])
+			copy(b[8:], out[8*i:])
+			block.Encrypt(b[:], b[:])
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(out[:8], binary.BigEndian.Uint64(b[:8])^t)
+			copy(out[8*i:], b[8:])
+		}
+	}
+	return out, nil
+}
+
+// aesKeyUnwrap reverses aesKeyWrap as specified by RFC 3394 section 2.2.2.
+func aesKeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, errWrappedKeyTruncated
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(wrapped)/8 - 1
+	out := append([]byte(nil), wrapped...)
+	var b [16]byte
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(b[:8], binary.BigEndian.Uint64(out[:8])^t)
+			copy(b[8:], out[8*i:])
+			block.Decrypt(b[:], b[:])
+			copy(out[:8], b[:8])
+			copy(out[8*i:], b[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(out[:8], keyWrapIV) != 1 {
+		return nil, errWrappedKeyIntegrity
+	}
+	return out[8:], nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// gcmKeyWrap seals key under kek as nonce || ciphertext || tag, with keyID as
+// additional data.
+func gcmKeyWrap(kek, key []byte, keyID string) ([]byte, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, key, []byte(keyID)), nil
+}
+
+func gcmKeyUnwrap(kek, wrapped []byte, keyID string) ([]byte, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := aead.NonceSize()
+	if len(wrapped) < n+aead.Overhead() {
+		return nil, errWrappedKeyTruncated
+	}
+	key, err := aead.Open(nil, wrapped[:n], wrapped[n:], []byte(keyID))
+	if err != nil {
+		return nil, errWrappedKeyIntegrity
+	}
+	return key, nil
+}
+
+// Rewrap re-wraps the content key of blobPath under the current KEK of
+// c.Keys, in place, keeping its wrap algorithm. The blob content is untouched,
+// so rotating a KEK costs one metadata request per blob. It reports whether
+// the blob was changed; blobs already wrapped under the current KEK are left
+// alone.
+func (c *AzureBlobClient) Rewrap(ctx context.Context, blobPath string) (bool, error) {
+	if c.Keys == nil {
+		return false, errors.New("no key ring configured")
+	}
+	props, err := c.Stat(ctx, blobPath)
+	if err != nil {
+		return false, err
+	}
+	name, value, ok := findEncryptionData(props.Metadata)
+	if !ok {
+		return false, fmt.Errorf("rewrap %q: %w", blobPath, errNotEncrypted)
+	}
+	// Decode only the wrapped key so that fields this tool does not know
+	// about survive the rewrite.
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return false, fmt.Errorf("rewrap %q: parse %s metadata: %w", blobPath, encryptionMetadataKey, err)
+	}
+	wrapped := &wrappedContentKey{}
+	if err := json.Unmarshal(fields["WrappedContentKey"], wrapped); err != nil {
+		return false, fmt.Errorf("rewrap %q: parse wrapped content key: %w", blobPath, err)
+	}
+	if wrapped.KeyID == c.Keys.Current() {
+		return false, nil
+	}
+	key, err := c.Keys.unwrap(ctx, wrapped)
+	if err != nil {
+		return false, fmt.Errorf("rewrap %q: %w", blobPath, err)
+	}
+	if wrapped, err = c.Keys.wrap(ctx, wrapped.Algorithm, key); err != nil {
+		return false, err
+	}
+	if fields["WrappedContentKey"], err = json.Marshal(wrapped); err != nil {
+		return false, err
+	}
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return false, err
+	}
+	metadata := map[string]string{}
+	for k, v := range props.Metadata {
+		metadata[k] = v
+	}
+	metadata[name] = string(b)
+	ctx, cancel := c.metadataContext(ctx)
+	defer cancel()
+	// Setting metadata replaces all of it, so guard against a concurrent
+	// writer changing the blob since it was read.
+	_, err = c.containerClient.NewBlobClient(blobPath).SetMetadata(ctx, metadata, &azblob.SetBlobMetadataOptions{
+		ModifiedAccessConditions: &azblob.ModifiedAccessConditions{IfMatch: &props.ETag},
+	})
+	if err != nil {
+		return false, newBlobError("rewrap", blobPath, err)
+	}
+	return true, nil
+}
+
+// kekFlag collects repeated "id=source" flags naming the file or environment
+// variable holding each KEK by key ID.
+type kekFlag map[string]string
+
+func (k kekFlag) String() string {
+	return fmt.Sprint(map[string]string(k))
+}
+
+func (k kekFlag) Set(v string) error {
+	parts := strings.SplitN(v, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("key %q is not in id=file or id=env:NAME form", v)
+	}
+	k[parts[0]] = parts[1]
+	return nil
+}
+
+// kekEnvPrefix marks a KEK source as the name of an environment variable
+// rather than a file.
+const kekEnvPrefix = "env:"
+
+// readKEK returns the KEK held by source: the file it names or, with
+// kekEnvPrefix, the environment variable.
+func readKEK(source string) ([]byte, error) {
+	name := strings.TrimPrefix(source, kekEnvPrefix)
+	if name == source {
+		return os.ReadFile(source)
+	}
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("key variable %s is not set", name)
+	}
+	return []byte(v), nil
+}
+
+// loadKeyRing reads the KEKs of sources, files or environment variables
+// each holding 32 raw bytes or their base64 encoding. It returns nil when
+// sources is empty. current may be empty when there is only one key.
+func loadKeyRing(current string, sources map[string]string) (*KeyRing, error) {
+	if len(sources) == 0 {
+		if current != "" {
+			return nil, fmt.Errorf("current key %q is not in the key ring", current)
+		}
+		return nil, nil
+	}
+	keys := map[string][]byte{}
+	for id, source := range sources {
+		b, err := readKEK(source)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) != 32 {
+			if b, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(b))); err != nil {
+				return nil, fmt.Errorf("key %s is neither 32 raw bytes nor base64: %w", source, err)
+			}
+		}
+		keys[id] = b
+		if len(sources) == 1 && current == "" {
+			current = id
+		}
+	}
+	if current == "" {
+		return nil, errors.New("several keys given, choose the current one with -kek-current")
+	}
+	return NewKeyRing(current, keys)
+}
+
+func runRewrap(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("rewrap", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "rewrap every encrypted blob whose name starts with `prefix`")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: rewrap [flags] [blob...]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if az.Keys == nil {
+		return errors.New("rewrap needs the key ring, pass -kek")
+	}
+	blobs := fs.Args()
+	listed := *prefix != ""
+	if listed {
+		items, err := az.List(ctx, *prefix)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			blobs = append(blobs, item.Name)
+		}
+	}
+	if len(blobs) == 0 {
+		fs.Usage()
+		return errors.New("rewrap takes blob names or -prefix")
+	}
+	failed := 0
+	for _, blob := range blobs {
+		changed, err := az.Rewrap(ctx, blob)
+		switch {
+		case errors.Is(err, errNotEncrypted) && listed:
+			// Listing a prefix may well turn up plain blobs.
+		case err != nil:
+			fmt.Fprintln(os.Stderr, err)
+			failed++
+		case changed:
+			fmt.Println(az.Messages.format(MsgRewrapped, blob, az.Keys.Current()))
+		default:
+			fmt.Println(az.Messages.format(MsgAlreadyWrapped, blob, az.Keys.Current()))
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d blobs failed to rewrap", failed, len(blobs))
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func mustKeyRing(t *testing.T, current string, keys map[string][]byte) *KeyRing {
+	t.Helper()
+	r, err := NewKeyRing(current, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestAESKeyWrapRFC3394 uses the 256-bit key data with 256-bit KEK vector of
+// RFC 3394 section 4.6.
+func TestAESKeyWrapRFC3394(t *testing.T) {
+	kek := mustHex(t, "000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F")
+	key := mustHex(t, "00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F")
+	want := mustHex(t, "28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21")
+	wrapped, err := aesKeyWrap(kek, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(wrapped, want) {
+		t.Errorf("wrap = %X, want %X", wrapped, want)
+	}
+	unwrapped, err := aesKeyUnwrap(kek, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unwrapped, key) {
+		t.Errorf("unwrap = %X, want %X", unwrapped, key)
+	}
+	if _, err := aesKeyUnwrap(testKey(1), want); !errors.Is(err, errWrappedKeyIntegrity) {
+		t.Errorf("unwrap with the wrong KEK: got %v, want an integrity error", err)
+	}
+}
+
+func TestNewKeyRing(t *testing.T) {
+	if _, err := NewKeyRing("a", map[string][]byte{"a": make([]byte, 16)}); err == nil {
+		t.Error("accepted a 16 byte key")
+	}
+	if _, err := NewKeyRing("b", map[string][]byte{"a": testKey(1)}); err == nil {
+		t.Error("accepted a current key that is not in the ring")
+	}
+}
+
+func TestLoadKeyRing(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	raw := write("raw", testKey(1))
+	b64 := write("b64", []byte(base64.StdEncoding.EncodeToString(testKey(2))+"\n"))
+	short := write("short", []byte(base64.StdEncoding.EncodeToString(make([]byte, 16))))
+	garbage := write("garbage", []byte("not a key"))
+	t.Setenv("TEST_KEK", base64.StdEncoding.EncodeToString(testKey(3)))
+
+	tests := []struct {
+		name    string
+		current string
+		files   map[string]string
+		want    string // current key of the ring, or "" for none
+		wantKey []byte
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "current without keys", current: "a", wantErr: "not in the key ring"},
+		{name: "raw", files: map[string]string{"a": raw}, want: "a", wantKey: testKey(1)},
+		{name: "base64", files: map[string]string{"b": b64}, want: "b", wantKey: testKey(2)},
+		{name: "wrong length", files: map[string]string{"a": short}, wantErr: "16 bytes"},
+		{name: "neither raw nor base64", files: map[string]string{"a": garbage}, wantErr: "neither"},
+		{name: "missing file", files: map[string]string{"a": filepath.Join(dir, "missing")}, wantErr: "no such file"},
+		{name: "environment", files: map[string]string{"e": "env:TEST_KEK"}, want: "e", wantKey: testKey(3)},
+		{name: "unset variable", files: map[string]string{"e": "env:TEST_KEK_UNSET"}, wantErr: "TEST_KEK_UNSET is not set"},
+		{name: "several without current", files: map[string]string{"a": raw, "b": b64}, wantErr: "-kek-current"},
+		{name: "several with current", current: "b", files: map[string]string{"a": raw, "b": b64}, want: "b", wantKey: testKey(2)},
+		{name: "unknown current", current: "c", files: map[string]string{"a": raw, "b": b64}, wantErr: "not in the key ring"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := loadKeyRing(tt.current, tt.files)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == "" {
+				if r != nil {
+					t.Fatalf("got a key ring, want none")
+				}
+				return
+			}
+			if r.Current() != tt.want || !bytes.Equal(r.keys[tt.want], tt.wantKey) {
+				t.Errorf("current key %q = %X, want %q = %X", r.Current(), r.keys[r.Current()], tt.want, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestKeyRingWrapUnwrap(t *testing.T) {
+	cek := testKey(9)
+	for _, alg := range []string{keyWrapAES, keyWrapGCM} {
+		t.Run(alg, func(t *testing.T) {
+			old := mustKeyRing(t, "k1", map[string][]byte{"k1": testKey(1)})
+			rotated := mustKeyRing(t, "k2", map[string][]byte{"k1": testKey(1), "k2": testKey(2)})
+			retired := mustKeyRing(t, "k2", map[string][]byte{"k2": testKey(2)})
+
+			// Rotation: a key wrapped under k1 stays readable once k2 is
+			// current, and rewrapping it under k2 lets k1 be retired.
+			w1, err := old.wrap(context.Background(), alg, cek)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := rotated.unwrap(context.Background(), w1)
+			if err != nil || !bytes.Equal(got, cek) {
+				t.Fatalf("unwrap after rotation = %X, %v", got, err)
+			}
+			if _, err := retired.unwrap(context.Background(), w1); err == nil {
+				t.Error("unwrapped a key whose KEK was retired")
+			}
+			w2, err := rotated.wrap(context.Background(), alg, got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w2.KeyID != "k2" || w2.Algorithm != alg {
+				t.Errorf("rewrapped under %q with %q, want k2 with %q", w2.KeyID, w2.Algorithm, alg)
+			}
+			if got, err := retired.unwrap(context.Background(), w2); err != nil || !bytes.Equal(got, cek) {
+				t.Errorf("unwrap after rewrap = %X, %v", got, err)
+			}
+
+			// Truncated envelopes are rejected before any decryption.
+			for _, n := range []int{0, 7, 16} {
+				short := *w1
+				short.EncryptedKey = w1.EncryptedKey[:n]
+				if _, err := rotated.unwrap(context.Background(), &short); !errors.Is(err, errWrappedKeyTruncated) {
+					t.Errorf("unwrap of %d bytes: got %v, want errWrappedKeyTruncated", n, err)
+				}
+			}
+
+			unsupported := *w1
+			unsupported.Algorithm = "RSA-OAEP"
+			if _, err := rotated.unwrap(context.Background(), &unsupported); err == nil {
+				t.Error("unwrapped a key with an unsupported algorithm")
+			}
+		})
+	}
+}
+
+func TestResolvingKeyRing(t *testing.T) {
+	calls := map[string]int{}
+	r := NewResolvingKeyRing("k1", func(ctx context.Context, id string) ([]byte, error) {
+		calls[id]++
+		switch id {
+		case "k1":
+			return testKey(1), nil
+		case "short":
+			return make([]byte, 16), nil
+		}
+		return nil, errors.New("no such key")
+	})
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		w, err := r.wrap(ctx, keyWrapAES, testKey(9))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := r.unwrap(ctx, w); err != nil || !bytes.Equal(got, testKey(9)) {
+			t.Fatalf("unwrap = %X, %v", got, err)
+		}
+	}
+	if calls["k1"] != 1 {
+		t.Errorf("k1 resolved %d times, want once", calls["k1"])
+	}
+	for _, id := range []string{"short", "missing"} {
+		if _, err := r.unwrap(ctx, &wrappedContentKey{KeyID: id, Algorithm: keyWrapAES}); err == nil || !strings.Contains(err.Error(), id) {
+			t.Errorf("%s: got %v", id, err)
+		}
+	}
+}
+
+func TestKeyRingRelabelledKeyID(t *testing.T) {
+	// Both IDs name the same key material, so only the authenticated key ID
+	// can tell a relabelled envelope apart.
+	r := mustKeyRing(t, "k1", map[string][]byte{"k1": testKey(1), "k2": testKey(1)})
+	w, err := r.wrap(context.Background(), keyWrapGCM, testKey(9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.KeyID = "k2"
+	if _, err := r.unwrap(context.Background(), w); !errors.Is(err, errWrappedKeyIntegrity) {
+		t.Errorf("got %v, want the relabelled key to fail its integrity check", err)
+	}
+}
+
+func decodeEncryptionData(t *testing.T, m *memContainer, name string) map[string]json.RawMessage {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(m.blobs[name].metadata[encryptionMetadataKey]), &fields); err != nil {
+		t.Fatal(err)
+	}
+	return fields
+}
+
+func TestRewrap(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	old := mustKeyRing(t, "k1", map[string][]byte{"k1": testKey(1)})
+	plain := bytes.Repeat([]byte("rotate me "), 10)
+	ciphertext, metadata := encryptV2(t, old, keyWrapAES, plain, 32)
+	metadata["owner"] = "team"
+	m.put("secret", ciphertext, metadata)
+	m.put("plain", []byte("plain"), nil)
+	before := decodeEncryptionData(t, m, "secret")
+
+	az.Keys = mustKeyRing(t, "k2", map[string][]byte{"k1": testKey(1), "k2": testKey(2)})
+	ctx := context.Background()
+	changed, err := az.Rewrap(ctx, "secret")
+	if err != nil || !changed {
+		t.Fatalf("Rewrap = %v, %v; want true, nil", changed, err)
+	}
+	after := decodeEncryptionData(t, m, "secret")
+	var wrapped wrappedContentKey
+	if err := json.Unmarshal(after["WrappedContentKey"], &wrapped); err != nil {
+		t.Fatal(err)
+	}
+	if wrapped.KeyID != "k2" || wrapped.Algorithm != keyWrapAES {
+		t.Errorf("rewrapped under %q with %q, want k2 with %s", wrapped.KeyID, wrapped.Algorithm, keyWrapAES)
+	}
+	for _, field := range []string{"EncryptionAgent", "EncryptedRegionInfo", "KeyWrappingMetadata"} {
+		if !bytes.Equal(after[field], before[field]) {
+			t.Errorf("%s changed from %s to %s", field, before[field], after[field])
+		}
+	}
+	if m.blobs["secret"].metadata["owner"] != "team" {
+		t.Error("other metadata was not preserved")
+	}
+
+	if changed, err := az.Rewrap(ctx, "secret"); err != nil || changed {
+		t.Errorf("second Rewrap = %v, %v; want false, nil", changed, err)
+	}
+	if _, err := az.Rewrap(ctx, "plain"); !errors.Is(err, errNotEncrypted) {
+		t.Errorf("Rewrap of a plain blob: got %v, want errNotEncrypted", err)
+	}
+
+	// Once rewrapped, the old KEK is no longer needed to read the blob.
+	az.Keys = mustKeyRing(t, "k2", map[string][]byte{"k2": testKey(2)})
+	dest := filepath.Join(t.TempDir(), "secret")
+	if _, err := az.Download(ctx, "secret", dest); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, plain) {
+		t.Errorf("downloaded %q, want %q", got, plain)
+	}
+}
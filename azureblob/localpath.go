@@ -0,0 +1,71 @@
+package azureblob
+
+import (
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// windowsPaths reports whether local paths follow Windows rules.
+const windowsPaths = runtime.GOOS == "windows"
+
+// localRoot returns dir as the root of a tree that is walked for upload or
+// downloaded into. Elsewhere it is dir itself. On Windows it is made
+// absolute, since Go only reaches files more than MAX_PATH deep by their
+// \\?\ extended-length form when their path is absolute. A UNC share, for
+// which Go does not do that, is given the extended form itself.
+func localRoot(dir string) string {
+	if !windowsPaths {
+		return dir
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return dir
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return extendedPath(abs)
+	}
+	return abs
+}
+
+// extendedPath returns the \\?\ extended-length form of the absolute
+// Windows path abs, which is not limited to MAX_PATH: \\?\C:\dir for
+// C:\dir, and \\?\UNC\server\share for \\server\share. Paths already in
+// that form, or naming a device, are returned as they are.
+func extendedPath(abs string) string {
+	p := strings.ReplaceAll(abs, "/", `\`)
+	switch {
+	case strings.HasPrefix(p, `\\?\`), strings.HasPrefix(p, `\\.\`):
+		return p
+	case strings.HasPrefix(p, `\\`):
+		return `\\?\UNC\` + p[2:]
+	}
+	return `\\?\` + p
+}
+
+// localName returns the slash-separated path, relative to the directory it
+// is downloaded into, at which blob is stored. Rooting the name before
+// cleaning it keeps ".." inside the directory. With windows, backslashes
+// separate elements too, as in the names of blobs some Windows tools
+// upload, since file names cannot hold them. A drive letter element such
+// as C: loses its colon, and any other colon becomes _, so that it names
+// neither a drive nor an alternate data stream.
+func localName(blob string, windows bool) string {
+	if windows {
+		blob = strings.ReplaceAll(blob, `\`, "/")
+	}
+	name := path.Clean("/" + blob)[1:]
+	if !windows || !strings.Contains(name, ":") {
+		return name
+	}
+	elems := strings.Split(name, "/")
+	for i, elem := range elems {
+		if len(elem) == 2 && elem[1] == ':' && ('a' <= elem[0]|0x20 && elem[0]|0x20 <= 'z') {
+			elems[i] = elem[:1]
+			continue
+		}
+		elems[i] = strings.ReplaceAll(elem, ":", "_")
+	}
+	return strings.Join(elems, "/")
+}
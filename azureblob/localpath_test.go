@@ -0,0 +1,58 @@
+package azureblob
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtendedPath(t *testing.T) {
+	for abs, want := range map[string]string{
+		`C:\builds\agent`:          `\\?\C:\builds\agent`,
+		`C:/builds/agent`:          `\\?\C:\builds\agent`,
+		`\\fileserver\artifacts\a`: `\\?\UNC\fileserver\artifacts\a`,
+		`\\?\C:\builds`:            `\\?\C:\builds`,
+		`\\?\UNC\fileserver\share`: `\\?\UNC\fileserver\share`,
+		`\\.\PhysicalDrive0`:       `\\.\PhysicalDrive0`,
+		`D:\` + strings.Repeat(`nested\`, 40) + `artifact.zip`: `\\?\D:\` + strings.Repeat(`nested\`, 40) + `artifact.zip`,
+	} {
+		if got := extendedPath(abs); got != want {
+			t.Errorf("extendedPath(%q) = %q, want %q", abs, got, want)
+		}
+	}
+}
+
+func TestLocalName(t *testing.T) {
+	for _, tc := range []struct {
+		blob    string
+		windows bool
+		want    string
+	}{
+		{"builds/1/agent.zip", false, "builds/1/agent.zip"},
+		{"../../etc/passwd", false, "etc/passwd"},
+		{`builds\1\agent.zip`, false, `builds\1\agent.zip`},
+		{"logs/10:30.txt", false, "logs/10:30.txt"},
+		{`builds\1\agent.zip`, true, "builds/1/agent.zip"},
+		{`..\..\Windows\System32\evil.dll`, true, "Windows/System32/evil.dll"},
+		{`mixed/sep\arated//name`, true, "mixed/sep/arated/name"},
+		{`C:\agent\work\out.log`, true, "C/agent/work/out.log"},
+		{"backup/d:/data", true, "backup/d/data"},
+		{"logs/10:30.txt", true, "logs/10_30.txt"},
+		{"report.txt:hidden", true, "report.txt_hidden"},
+	} {
+		if got := localName(tc.blob, tc.windows); got != tc.want {
+			t.Errorf("localName(%q, %v) = %q, want %q", tc.blob, tc.windows, got, tc.want)
+		}
+	}
+}
+
+func TestDownloadDestinationStaysInside(t *testing.T) {
+	dir := t.TempDir()
+	for _, blob := range []string{"../escape.txt", `..\..\escape.txt`, "/abs/file", "a/../../b"} {
+		got := downloadDestination(dir, blob)
+		rel, err := filepath.Rel(localRoot(dir), got)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			t.Errorf("downloadDestination(%q, %q) = %q, outside the directory", dir, blob, got)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Manifest lists blobs to download and files to upload as one batch.
+type Manifest struct {
+	Downloads []ManifestItem `json:"downloads" yaml:"downloads"`
+	Uploads   []ManifestItem `json:"uploads" yaml:"uploads"`
+}
+
+// ManifestItem pairs a blob with a local file: the destination of a download
+// or the source of an upload. Relative paths are resolved against the
+// directory of the manifest file. The optional hex digests are checked
+// against the local file, after a download or before an upload. Account and
+// Container select another container than the client's; either defaults to
+// the client's. Backend, the URL of a container or bucket such as
+// azure://account/container, selects one of any Backend instead.
+type ManifestItem struct {
+	Blob      string `json:"blob" yaml:"blob"`
+	Path      string `json:"path" yaml:"path"`
+	SHA256    string `json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	MD5       string `json:"md5,omitempty" yaml:"md5,omitempty"`
+	Account   string `json:"account,omitempty" yaml:"account,omitempty"`
+	Container string `json:"container,omitempty" yaml:"container,omitempty"`
+	Backend   string `json:"backend,omitempty" yaml:"backend,omitempty"`
+}
+
+// ManifestResult is the outcome of one manifest item.
+type ManifestResult struct {
+	Direction string       `json:"direction"`
+	Item      ManifestItem `json:"item"`
+	Error     string       `json:"error,omitempty"`
+	// Resumed is set if the item was done by an earlier run of its job,
+	// and so not transferred again.
+	Resumed bool `json:"resumed,omitempty"`
+	err     error
+}
+
+// LoadManifest reads a manifest from file, as YAML if its extension is .yaml
+// or .yml and as JSON otherwise.
+func LoadManifest(file string) (*Manifest, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(b, m)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse manifest %s: %w", file, err)
+	}
+	dir := filepath.Dir(file)
+	for _, items := range [][]ManifestItem{m.Downloads, m.Uploads} {
+		for i := range items {
+			item := &items[i]
+			if item.Blob == "" || item.Path == "" {
+				return nil, fmt.Errorf("manifest %s: every item needs a blob and a path", file)
+			}
+			if err := item.checkBackend(); err != nil {
+				return nil, fmt.Errorf("manifest %s: %w", file, err)
+			}
+			if !filepath.IsAbs(item.Path) {
+				item.Path = filepath.Join(dir, item.Path)
+			}
+		}
+	}
+	return m, nil
+}
+
+// RunManifest performs every transfer in m concurrently, bounded by c.Pool,
+// and returns one result per item, downloads first. All items are attempted
+// even if some fail. Items in other containers or backends are transferred
+// by the Backends of c, so all Azure clients authenticate once.
+func (c *AzureBlobClient) RunManifest(ctx context.Context, m *Manifest) []ManifestResult {
+	return c.runManifest(ctx, m, nil)
+}
+
+// runManifest is RunManifest as the job of j, unless j is nil: items j
+// records as done are skipped, and the outcome of every other item is
+// recorded in j as it finishes.
+func (c *AzureBlobClient) runManifest(ctx context.Context, m *Manifest, j *JobJournal) []ManifestResult {
+	results := make([]ManifestResult, 0, len(m.Downloads)+len(m.Uploads))
+	for _, item := range m.Downloads {
+		results = append(results, ManifestResult{Direction: "download", Item: item})
+	}
+	for _, item := range m.Uploads {
+		results = append(results, ManifestResult{Direction: "upload", Item: item})
+	}
+	backends := c.manifestBackends(append(append([]ManifestItem(nil), m.Downloads...), m.Uploads...))
+	if j != nil {
+		for i := range results {
+			results[i].Resumed = j.done(i)
+		}
+	}
+	errs := c.Pool.Run(ctx, len(results), func(ctx context.Context, i int) error {
+		if results[i].Resumed {
+			return nil
+		}
+		err := c.transferManifestItem(ctx, backends, results[i])
+		if j != nil {
+			if jerr := j.record(i, err); jerr != nil {
+				log.Print(c.Messages.format(MsgJobJournalFailed, j.ID, jerr))
+			}
+		}
+		return err
+	})
+	for i, err := range errs {
+		if err != nil {
+			results[i].Error = err.Error()
+			results[i].err = err
+		}
+	}
+	return results
+}
+
+// transferManifestItem performs the transfer of r.
+func (c *AzureBlobClient) transferManifestItem(ctx context.Context, backends *Backends, r ManifestResult) error {
+	b, err := c.itemBackend(backends, r.Item)
+	if err != nil {
+		return err
+	}
+	if r.Direction == "download" {
+		return downloadManifestItem(ctx, b, r.Item)
+	}
+	return c.uploadManifestItem(ctx, b, r.Item)
+}
+
+// manifestBackends returns the Backends of c if any of items names another
+// container or a backend, and nil otherwise.
+func (c *AzureBlobClient) manifestBackends(items []ManifestItem) *Backends {
+	for _, item := range items {
+		if item.Account != "" || item.Container != "" || item.Backend != "" {
+			return NewBackends(c)
+		}
+	}
+	return nil
+}
+
+// itemBackend returns the backend of item: c, or one of backends for items
+// naming another container or a backend.
+func (c *AzureBlobClient) itemBackend(backends *Backends, item ManifestItem) (Backend, error) {
+	if backends == nil {
+		return c, nil
+	}
+	if item.Backend != "" {
+		return backends.Open(item.Backend)
+	}
+	account, container := c.StorageAccount, c.ContainerName
+	override(&account, item.Account)
+	override(&container, item.Container)
+	return backends.Open(azureScheme + "://" + account + "/" + container)
+}
+
+func downloadManifestItem(ctx context.Context, b Backend, item ManifestItem) error {
+	if err := os.MkdirAll(filepath.Dir(item.Path), 0755); err != nil {
+		return err
+	}
+	ctx, digests := withDigests(ctx, item.digestAlgorithms())
+	if _, err := b.Download(ctx, item.Blob, item.Path); err != nil {
+		return err
+	}
+	// Backends that hash while downloading spare reading the file again.
+	var err error
+	if sums := digests.sums(); sums != nil {
+		err = item.checkDigests(sums)
+	} else {
+		err = item.verify()
+	}
+	if err != nil {
+		// Do not leave a file that failed verification where it would be used.
+		os.Remove(item.Path)
+		return err
+	}
+	return nil
+}
+
+// uploadManifestItem uploads item to b, preserving a symlink as c's options
+// ask, which only Azure backends can.
+func (c *AzureBlobClient) uploadManifestItem(ctx context.Context, b Backend, item ManifestItem) error {
+	if c.clientOptions().Symlinks == symlinksPreserve && isSymlink(item.Path) {
+		az, ok := b.(*AzureBlobClient)
+		if !ok {
+			return fmt.Errorf("upload %s: symlinks cannot be preserved in %s", item.Path, b.Location())
+		}
+		return az.UploadSymlink(ctx, item.Path, item.Blob)
+	}
+	if err := item.verify(); err != nil {
+		return err
+	}
+	f, err := os.Open(item.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	result, err := b.Upload(ctx, f, item.Blob)
+	if err == nil && result != nil && result.UpToDate {
+		log.Print(c.Messages.format(MsgUpToDate, item.Blob))
+	}
+	return err
+}
+
+// checkBackend returns an error if item selects its container both by
+// backend URL and by account or container name.
+func (item ManifestItem) checkBackend() error {
+	if item.Backend != "" && (item.Account != "" || item.Container != "") {
+		return fmt.Errorf("%s names both a backend and an account or container", item.Blob)
+	}
+	return nil
+}
+
+// expectedDigests returns the hex digests item expects by algorithm.
+func (item ManifestItem) expectedDigests() map[string]string {
+	want := map[string]string{}
+	if item.SHA256 != "" {
+		want["sha256"] = item.SHA256
+	}
+	if item.MD5 != "" {
+		want["md5"] = item.MD5
+	}
+	return want
+}
+
+// digestAlgorithms returns the algorithms of the digests item expects, in
+// the order they are checked.
+func (item ManifestItem) digestAlgorithms() []string {
+	var algorithms []string
+	if item.SHA256 != "" {
+		algorithms = append(algorithms, "sha256")
+	}
+	if item.MD5 != "" {
+		algorithms = append(algorithms, "md5")
+	}
+	return algorithms
+}
+
+// verify checks the local file of item against its expected digests,
+// computing all of them in one read of the file.
+func (item ManifestItem) verify() error {
+	sums, err := fileDigests(item.Path, item.digestAlgorithms())
+	if err != nil {
+		return err
+	}
+	return item.checkDigests(sums)
+}
+
+// checkDigests compares the hex digests of item's file, by algorithm, with
+// those item expects.
+func (item ManifestItem) checkDigests(sums map[string]string) error {
+	want := item.expectedDigests()
+	for _, algorithm := range item.digestAlgorithms() {
+		if got := sums[algorithm]; !strings.EqualFold(got, want[algorithm]) {
+			return &ChecksumError{Path: item.Path, Algorithm: algorithm, Got: got, Want: want[algorithm]}
+		}
+	}
+	return nil
+}
+
+// printManifestResults prints a line per result.
+func printManifestResults(msgs Messages, results []ManifestResult) {
+	for _, r := range results {
+		status := msgs.format(MsgResultOK)
+		switch {
+		case r.Error != "":
+			status = msgs.format(MsgResultFailed, r.Error)
+		case r.Resumed:
+			status = msgs.format(MsgResultResumed)
+		}
+		if r.Direction == "download" {
+			fmt.Println(msgs.format(MsgManifestDownload, r.Item.Blob, r.Item.Path, status))
+		} else {
+			fmt.Println(msgs.format(MsgManifestUpload, r.Item.Path, r.Item.Blob, status))
+		}
+	}
+}
+
+// runTransfers runs m as a journaled job with progress reporting and prints
+// a result line per item, failing if any transfer did.
+func runTransfers(ctx context.Context, az *AzureBlobClient, m *Manifest) error {
+	j, err := az.startJob(m)
+	if err != nil {
+		return err
+	}
+	return manifestError(runJob(ctx, az, m, j))
+}
+
+func runManifest(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("manifest", flag.ContinueOnError)
+	report := fs.String("report", "", "also write the per-item results, or the plan with -dry-run, as JSON to `file`")
+	dryRun := fs.Bool("dry-run", false, "print what would be transferred and its size without transferring")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: manifest [flags] <manifest.json|manifest.yaml>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("manifest takes a manifest file")
+	}
+	m, err := LoadManifest(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		plans := az.PlanManifest(ctx, m)
+		err := printPlan(az.Messages, plans, "planned transfers")
+		if *report != "" {
+			if werr := writeReport(*report, plans); werr != nil {
+				return werr
+			}
+		}
+		return err
+	}
+	j, err := az.startJob(m)
+	if err != nil {
+		return err
+	}
+	results := runJob(ctx, az, m, j)
+	if *report != "" {
+		if err := writeReport(*report, results); err != nil {
+			return err
+		}
+	}
+	return manifestError(results)
+}
+
+// writeReport writes v to file as indented JSON.
+func writeReport(file string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(b, '\n'), 0644)
+}
@@ -0,0 +1,144 @@
+package azureblob
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func writeFile(t *testing.T, path, data string) string {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadManifest(t *testing.T) {
+	dir := t.TempDir()
+	want := &Manifest{
+		Downloads: []ManifestItem{{Blob: "tools/a", Path: filepath.Join(dir, "out/a"), SHA256: "ab"}},
+		Uploads:   []ManifestItem{{Blob: "logs/b", Path: "/abs/b", MD5: "cd"}},
+	}
+	tests := []struct {
+		name, file, data string
+	}{
+		{"json", "m.json", `{"downloads":[{"blob":"tools/a","path":"out/a","sha256":"ab"}],"uploads":[{"blob":"logs/b","path":"/abs/b","md5":"cd"}]}`},
+		{"yaml", "m.yaml", "downloads:\n- blob: tools/a\n  path: out/a\n  sha256: ab\nuploads:\n- blob: logs/b\n  path: /abs/b\n  md5: cd\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := LoadManifest(writeFile(t, filepath.Join(dir, tt.file), tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m, want) {
+				t.Errorf("got %+v, want %+v", m, want)
+			}
+		})
+	}
+}
+
+func TestLoadManifestErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name, file, data, wantErr string
+	}{
+		{"unknown json field", "m.json", `{"downloads":[{"blob":"a","path":"a","sha1":"x"}]}`, "unknown field"},
+		{"unknown yaml field", "m.yml", "downloads:\n- blob: a\n  path: a\n  sha1: x\n", "sha1"},
+		{"missing path", "m.json", `{"uploads":[{"blob":"a"}]}`, "needs a blob and a path"},
+		{"backend and container", "m.json", `{"uploads":[{"blob":"a","path":"a","backend":"azure://x/y","container":"z"}]}`, "both a backend"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadManifest(writeFile(t, filepath.Join(dir, tt.file), tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunManifest(t *testing.T) {
+	m := newMemContainer()
+	m.put("good", []byte("good data"), nil)
+	m.put("corrupt", []byte("corrupt data"), nil)
+	az := newTestClient(t, m)
+	dir := t.TempDir()
+	md5sum := md5.Sum([]byte("upload me"))
+	manifest := &Manifest{
+		Downloads: []ManifestItem{
+			{Blob: "good", Path: filepath.Join(dir, "sub/good"), SHA256: sha256Hex([]byte("good data"))},
+			{Blob: "corrupt", Path: filepath.Join(dir, "corrupt"), SHA256: sha256Hex([]byte("expected"))},
+			{Blob: "missing", Path: filepath.Join(dir, "missing")},
+		},
+		Uploads: []ManifestItem{
+			{Blob: "up/ok", Path: writeFile(t, filepath.Join(dir, "up-ok"), "upload me"), MD5: hex.EncodeToString(md5sum[:])},
+			{Blob: "up/changed", Path: writeFile(t, filepath.Join(dir, "up-changed"), "changed"), SHA256: sha256Hex([]byte("original"))},
+		},
+	}
+	results := az.RunManifest(context.Background(), manifest)
+	wantOK := []bool{true, false, false, true, false}
+	if len(results) != len(wantOK) {
+		t.Fatalf("got %d results, want %d", len(results), len(wantOK))
+	}
+	for i, r := range results {
+		if ok := r.Error == ""; ok != wantOK[i] {
+			t.Errorf("%s %s: error %q, want ok = %v", r.Direction, r.Item.Blob, r.Error, wantOK[i])
+		}
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "sub/good")); string(got) != "good data" {
+		t.Errorf("good was downloaded as %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "corrupt")); !os.IsNotExist(err) {
+		t.Error("a download that failed verification was left in place")
+	}
+	if !strings.Contains(results[1].Error, "sha256") {
+		t.Errorf("corrupt download error %q does not mention the digest", results[1].Error)
+	}
+	if b := m.blobs["up/ok"]; b == nil || string(b.data) != "upload me" {
+		t.Error("up/ok was not uploaded")
+	}
+	if m.blobs["up/changed"] != nil {
+		t.Error("an upload that failed verification was performed")
+	}
+}
+
+func TestRunManifestCommandReport(t *testing.T) {
+	m := newMemContainer()
+	m.put("a", []byte("a"), nil)
+	az := newTestClient(t, m)
+	dir := t.TempDir()
+	file := writeFile(t, filepath.Join(dir, "m.json"), `{"downloads":[{"blob":"a","path":"a"},{"blob":"b","path":"b"}]}`)
+	report := filepath.Join(dir, "report.json")
+	err := runManifest(context.Background(), az, []string{"-report", report, file})
+	if err == nil || err.Error() != "1 of 2 transfers failed" {
+		t.Errorf("got %v, want 1 of 2 transfers failed", err)
+	}
+	b, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []ManifestResult
+	if err := json.Unmarshal(b, &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Error != "" || results[1].Error == "" {
+		t.Errorf("report = %+v", results)
+	}
+}
@@ -0,0 +1,241 @@
+package azureblob
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MessageID identifies a user-facing message in a Messages catalog.
+type MessageID string
+
+const (
+	MsgDownloading      MessageID = "downloading"
+	MsgUploading        MessageID = "uploading"
+	MsgServedBy         MessageID = "served_by"
+	MsgFallbackTrying   MessageID = "fallback_trying"
+	MsgServedByFallback MessageID = "served_by_fallback"
+	MsgLinkedCopy       MessageID = "linked_copy"
+	MsgSlowTransfer     MessageID = "slow_transfer"
+	MsgChunkRetry       MessageID = "chunk_retry"
+	MsgRebuild          MessageID = "rebuild"
+	MsgTokenRejected    MessageID = "token_rejected"
+	MsgTenantChallenge  MessageID = "tenant_challenge"
+	MsgAuthProbe        MessageID = "auth_probe"
+	MsgAuthUsing        MessageID = "auth_using"
+	MsgAuthWouldUse     MessageID = "auth_would_use"
+	MsgAuthTokenFrom    MessageID = "auth_token_from"
+	MsgAuthMemberFailed MessageID = "auth_member_failed"
+	MsgProgress         MessageID = "progress"
+	MsgManifestDownload MessageID = "manifest_download"
+	MsgManifestUpload   MessageID = "manifest_upload"
+	MsgResultOK         MessageID = "result_ok"
+	MsgResultFailed     MessageID = "result_failed"
+	MsgRewrapped        MessageID = "rewrapped"
+	MsgAlreadyWrapped   MessageID = "already_wrapped"
+	MsgExamplePass      MessageID = "example_pass"
+	MsgExampleFail      MessageID = "example_fail"
+	MsgNoArtifacts      MessageID = "no_artifacts"
+	MsgFailureHeading   MessageID = "failure_heading"
+	MsgFailureRequestID MessageID = "failure_request_id"
+	MsgFailureFix       MessageID = "failure_fix"
+	MsgFixNotFound      MessageID = "fix_not_found"
+	MsgFixPermission    MessageID = "fix_permission"
+	MsgFixAuth          MessageID = "fix_auth"
+	MsgFixThrottled     MessageID = "fix_throttled"
+	MsgFixTimeout       MessageID = "fix_timeout"
+	MsgFixChecksum      MessageID = "fix_checksum"
+	MsgFixDiskSpace     MessageID = "fix_disk_space"
+	MsgVerify           MessageID = "verify"
+	MsgUnverified       MessageID = "unverified"
+	MsgWouldDownload    MessageID = "would_download"
+	MsgWouldUpload      MessageID = "would_upload"
+	MsgWouldDelete      MessageID = "would_delete"
+	MsgDryRunTotal      MessageID = "dry_run_total"
+	MsgDeleted          MessageID = "deleted"
+	MsgArchived         MessageID = "archived"
+	MsgExtracted        MessageID = "extracted"
+	MsgAppended         MessageID = "appended"
+	MsgPageUploaded     MessageID = "page_uploaded"
+	MsgSkippedSymlink   MessageID = "skipped_symlink"
+	MsgCASUploaded      MessageID = "cas_uploaded"
+	MsgCASExists        MessageID = "cas_exists"
+	MsgDeltaUploaded    MessageID = "delta_uploaded"
+	MsgWatchDownloaded  MessageID = "watch_downloaded"
+	MsgDaemonListening  MessageID = "daemon_listening"
+	MsgDaemonTransfer   MessageID = "daemon_transfer"
+	MsgGRPCListening    MessageID = "grpc_listening"
+	MsgCopied           MessageID = "copied"
+	MsgCopySkipped      MessageID = "copy_skipped"
+	MsgUpToDate         MessageID = "up_to_date"
+	MsgTransferSummary  MessageID = "transfer_summary"
+	MsgSiteUploaded     MessageID = "site_uploaded"
+	MsgSiteDeployed     MessageID = "site_deployed"
+	MsgSiteKept         MessageID = "site_kept"
+	MsgQueuePoisoned    MessageID = "queue_poisoned"
+	MsgQueueDuplicate   MessageID = "queue_duplicate"
+	MsgRestoreStarted   MessageID = "restore_started"
+	MsgRestoreComplete  MessageID = "restore_complete"
+	MsgQueryRecordError MessageID = "query_record_error"
+	MsgManifestVerified MessageID = "manifest_verified"
+	MsgManifestUnsigned MessageID = "manifest_unsigned"
+	MsgServeListening   MessageID = "serve_listening"
+	MsgServeToken       MessageID = "serve_token"
+	MsgResultResumed    MessageID = "result_resumed"
+	MsgJobUnfinished    MessageID = "job_unfinished"
+	MsgJobNotJournaled  MessageID = "job_not_journaled"
+	MsgJobJournalFailed MessageID = "job_journal_failed"
+	MsgResumeDiscarded  MessageID = "resume_discarded"
+	MsgResumeRefetch    MessageID = "resume_refetch"
+	MsgTokenStoreFailed MessageID = "token_store_failed"
+)
+
+// defaultMessage is the English text of a message and an example of the
+// arguments it is formatted with, used to check replacements.
+type defaultMessage struct {
+	format  string
+	example []interface{}
+}
+
+var defaultMessages = map[MessageID]defaultMessage{
+	MsgDownloading:      {"Downloading %s", []interface{}{"blob"}},
+	MsgUploading:        {"Uploading to %s", []interface{}{"blob"}},
+	MsgServedBy:         {"%s served by %s", []interface{}{"blob", "account/container"}},
+	MsgFallbackTrying:   {"%s not found in %s, trying %s", []interface{}{"blob", "account/new", "account/old"}},
+	MsgServedByFallback: {"%s served by %s (fallback)", []interface{}{"blob", "account/old"}},
+	MsgLinkedCopy:       {"%s linked to existing copy %s", []interface{}{"blob", "/path"}},
+	MsgSlowTransfer: {"%s %q: not finished within %s at the minimum throughput of %s/s, restarting (attempt %d of %d)",
+		[]interface{}{"download", "blob", time.Minute, "1.0 MiB", 2, 3}},
+	MsgChunkRetry: {"%s %q: the %s at offset %d failed, sending it again (retry %d of %d): %v",
+		[]interface{}{"download", "blob", "range", int64(4 << 20), 1, 3, "HTTP 503"}},
+	MsgRebuild:          {"%s %q failed, rebuilding the client and retrying once: %v", []interface{}{"download", "blob", "HTTP 401"}},
+	MsgTokenRejected:    {"%s %s: token rejected with HTTP %d, getting a new one and retrying once", []interface{}{"GET", "/container/blob", 401}},
+	MsgTenantChallenge:  {"%s %s: the account trusts tenant %s, getting a token from it and retrying once", []interface{}{"GET", "/container/blob", "tenant"}},
+	MsgAuthProbe:        {"auth probe: %s", []interface{}{"managed identity: chosen"}},
+	MsgAuthUsing:        {"auth: using %s", []interface{}{"managed identity"}},
+	MsgAuthWouldUse:     {"auth would use %s", []interface{}{"managed identity"}},
+	MsgAuthTokenFrom:    {"auth: token from %s", []interface{}{"azure-cli"}},
+	MsgAuthMemberFailed: {"auth: %s failed: %v", []interface{}{"managed-identity", "no identity assigned"}},
+	MsgProgress: {"progress: %d transfers in flight, %d done, %s/%s, %s/s",
+		[]interface{}{1, 2, "1.0 MiB", "2.0 MiB", "512 B"}},
+	MsgManifestDownload: {"download %s -> %s: %s", []interface{}{"blob", "path", "ok"}},
+	MsgManifestUpload:   {"upload %s -> %s: %s", []interface{}{"path", "blob", "ok"}},
+	MsgResultOK:         {"ok", nil},
+	MsgResultFailed:     {"FAILED: %s", []interface{}{"error"}},
+	MsgUnverified:       {"unverified: %s", []interface{}{"no expected digest"}},
+	MsgWouldDownload:    {"would download %s -> %s: %s", []interface{}{"blob", "path", "1.5 MiB"}},
+	MsgWouldUpload:      {"would upload %s -> %s: %s", []interface{}{"path", "blob", "1.5 MiB"}},
+	MsgWouldDelete:      {"would delete %s: %s", []interface{}{"blob", "1.5 MiB"}},
+	MsgDryRunTotal:      {"dry run: %d %s, %s in total; nothing was changed", []interface{}{2, "planned transfers", "3.0 MiB"}},
+	MsgDeleted:          {"%s: deleted", []interface{}{"blob"}},
+	MsgArchived:         {"%s: archived %d files, %s", []interface{}{"blob.tar.gz", 3, "1.5 MiB"}},
+	MsgExtracted:        {"%s: extracted %d files, %s into %s", []interface{}{"blob.tar.gz", 3, "1.5 MiB", "dir"}},
+	MsgAppended:         {"%s: appended %s", []interface{}{"log.txt", "1.5 MiB"}},
+	MsgCASUploaded:      {"%s: uploaded as %s", []interface{}{"dist/app.pkg", "cas/sha256/9f86d081884c7d65"}},
+	MsgCASExists:        {"%s: already stored as %s", []interface{}{"dist/app.pkg", "cas/sha256/9f86d081884c7d65"}},
+	MsgDeltaUploaded:    {"%s: sent %d of %d blocks, %s", []interface{}{"disk.img", 3, 1280, "24.0 MiB"}},
+	MsgWatchDownloaded:  {"%s: downloaded to %s", []interface{}{"releases/app.pkg", "/srv/app.pkg"}},
+	MsgDaemonListening:  {"serving transfers on %s", []interface{}{"127.0.0.1:8765"}},
+	MsgDaemonTransfer:   {"%s %s %s: %s", []interface{}{"download", "releases/app.pkg", "/srv/app.pkg", "done"}},
+	MsgGRPCListening:    {"serving the gRPC transfer service on %s", []interface{}{"127.0.0.1:8766"}},
+	MsgCopied:           {"%s: copied to %s", []interface{}{"s3://releases/app.pkg", "azure://account/artifacts/app.pkg"}},
+	MsgCopySkipped:      {"%s: skipped, %s is the same", []interface{}{"s3://releases/app.pkg", "azure://account/artifacts/app.pkg"}},
+	MsgUpToDate:         {"%s: up to date, not uploaded", []interface{}{"releases/app.pkg"}},
+	MsgTransferSummary:  {"%s %s: %s in %s at %s/s, %d retries", []interface{}{"download", "releases/app.pkg", "12.0 MiB", "1.5s", "8.0 MiB", 0}},
+	MsgSiteUploaded:     {"%s: uploaded to %s as %s", []interface{}{"public/index.html", "index.html", "text/html; charset=utf-8"}},
+	MsgSiteDeployed:     {"site: %d files uploaded, %d unchanged, %d to delete", []interface{}{3, 120, 1}},
+	MsgSiteKept:         {"would keep %s, which has no local file; pass -delete to delete it", []interface{}{"old.html"}},
+	MsgQueuePoisoned:    {"message %s moved to %s: %v", []interface{}{"7b1a9f3e", "blob-events-poison", "received 6 times"}},
+	MsgQueueDuplicate:   {"%s: already downloaded at %s, skipping the event", []interface{}{"releases/app.pkg", "0x8D9A1B2C3D4E5F6"}},
+	MsgRestoreStarted:   {"restore %s to %s: started restore %s", []interface{}{"container/releases/", "2026-01-02T15:04:05Z", "8f1c2d3e-0000-0000-0000-000000000000"}},
+	MsgRestoreComplete:  {"restore %s: complete", []interface{}{"container/releases/"}},
+	MsgQueryRecordError: {"query %s: skipped a record at byte %d: %s", []interface{}{"logs/app.csv", 1024, "InvalidColumnOrdinal: column _9 does not exist"}},
+	MsgPageUploaded:     {"%s: sent %s of data for a %s disk", []interface{}{"disk.vhd", "1.5 MiB", "30.0 GiB"}},
+	MsgManifestVerified: {"manifest %s: signed by key %s, trusted comment: %s", []interface{}{"releases/bootstrap.json", "E7620F1842B4E81F", "timestamp:1760486400\tfile:bootstrap.json"}},
+	MsgManifestUnsigned: {"manifest %s: signature not checked", []interface{}{"releases/bootstrap.json"}},
+	MsgServeListening:   {"serving blobs over HTTP on %s", []interface{}{"127.0.0.1:8767"}},
+	MsgServeToken:       {"requests must carry the token %s", []interface{}{"3f9a0c6e1b7d"}},
+	MsgResultResumed:    {"ok (in an earlier run)", nil},
+	MsgJobUnfinished: {"job %s did not finish: %d of %d transfers left; resume it with -resume-job %s",
+		[]interface{}{"20261015-120000-3f9a0c", 2, 5, "20261015-120000-3f9a0c"}},
+	MsgJobNotJournaled:  {"job %s: not journaled, so it cannot be resumed: %v", []interface{}{"20261015-120000-3f9a0c", "no space left on device"}},
+	MsgJobJournalFailed: {"job %s: %v", []interface{}{"20261015-120000-3f9a0c", "write journal: no space left on device"}},
+	MsgResumeDiscarded: {"%s: discarding the download state %s, since %s, and downloading from the start",
+		[]interface{}{"blob", "blob.state", "the destination does not exist"}},
+	MsgResumeRefetch:    {"%s: the destination no longer holds %d chunks marked as done; fetching them again", []interface{}{"blob", 2}},
+	MsgTokenStoreFailed: {"token cache: the OS keychain cannot be used, so tokens are only cached in memory: %v", []interface{}{"no keychain available"}},
+	MsgRewrapped:        {"%s: rewrapped under %s", []interface{}{"blob", "kek"}},
+	MsgAlreadyWrapped:   {"%s: already wrapped under %s", []interface{}{"blob", "kek"}},
+	MsgExamplePass:      {"PASS %s (%s)", []interface{}{"auth", time.Second}},
+	MsgExampleFail:      {"FAIL %s (%s): %v", []interface{}{"auth", time.Second, "error"}},
+	MsgNoArtifacts:      {"no files match %s, nothing to upload", []interface{}{"dist/*.pkg"}},
+	MsgSkippedSymlink:   {"skipping symlink %s; pass -follow-symlinks or -preserve-symlinks to upload it", []interface{}{"dist/latest"}},
+	MsgFailureHeading:   {"bk_azureblob failed: %v", []interface{}{"1 of 2 transfers failed"}},
+	MsgFailureRequestID: {"request ID: %s", []interface{}{"00000000-0000-0000-0000-000000000000"}},
+	MsgFailureFix:       {"suggested fix: %s", []interface{}{"retry later"}},
+	MsgFixNotFound:      {"check the blob name and the -storage-account and -container it is looked up in", nil},
+	MsgFixPermission:    {"grant the identity a Storage Blob Data role on the container", nil},
+	MsgFixAuth:          {"check the credential; auth-probe shows which one is used", nil},
+	MsgFixThrottled:     {"the account is throttling requests; lower -max-transfers or -max-blocks, or retry later", nil},
+	MsgFixTimeout:       {"raise -metadata-timeout, or -throughput-grace for slow transfers", nil},
+	MsgFixChecksum:      {"the blob no longer has the expected content; update the expected digest or upload the blob again", nil},
+	MsgFixDiskSpace:     {"free up space on the destination's file system, or download somewhere with more room", nil},
+	MsgVerify:           {"verify %s against %s: %s", []interface{}{"path", "blob", "ok"}},
+}
+
+// Messages replaces the user-facing messages the client prints, such as
+// progress descriptions and log lines, so embedders can localize or rebrand
+// them. Each value is a fmt format string taking the same arguments as the
+// default; explicit argument indexes such as %[2]s allow reordering them.
+// Messages that are not set, and every message of a nil catalog, use the
+// English defaults. Errors are not part of the catalog.
+type Messages map[MessageID]string
+
+// LoadMessages reads a catalog from a JSON object mapping message IDs to
+// format strings, rejecting unknown IDs and formats that do not accept the
+// default's arguments.
+func LoadMessages(path string) (Messages, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := Messages{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("parse messages %s: %w", path, err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("messages %s: %w", path, err)
+	}
+	return m, nil
+}
+
+func (m Messages) validate() error {
+	ids := make([]string, 0, len(m))
+	for id := range m {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		def, ok := defaultMessages[MessageID(id)]
+		if !ok {
+			return fmt.Errorf("unknown message %q", id)
+		}
+		// fmt reports mismatched verbs and arguments inline as %!.
+		if out := fmt.Sprintf(m[MessageID(id)], def.example...); strings.Contains(out, "%!") {
+			return fmt.Errorf("message %q does not take the arguments of %q: %s", id, def.format, out)
+		}
+	}
+	return nil
+}
+
+// format returns message id formatted with args.
+func (m Messages) format(id MessageID, args ...interface{}) string {
+	format, ok := m[id]
+	if !ok {
+		format = defaultMessages[id].format
+	}
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,85 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMessagesFormat(t *testing.T) {
+	var none Messages
+	if got := none.format(MsgDownloading, "blob"); got != "Downloading blob" {
+		t.Errorf("default message = %q", got)
+	}
+	m := Messages{MsgDownloading: "Lade %s herunter", MsgServedBy: "%[2]s lieferte %[1]s"}
+	if got := m.format(MsgDownloading, "blob"); got != "Lade blob herunter" {
+		t.Errorf("replaced message = %q", got)
+	}
+	if got := m.format(MsgServedBy, "blob", "acct/c"); got != "acct/c lieferte blob" {
+		t.Errorf("reordered message = %q", got)
+	}
+	if got := m.format(MsgUploading, "blob"); got != "Uploading to blob" {
+		t.Errorf("message missing from the catalog = %q", got)
+	}
+}
+
+func TestDefaultMessagesTakeTheirExamples(t *testing.T) {
+	for id, def := range defaultMessages {
+		if err := (Messages{id: def.format}).validate(); err != nil {
+			t.Errorf("default %s: %v", id, err)
+		}
+	}
+}
+
+func TestLoadMessages(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name, json, wantErr string
+	}{
+		{"valid", `{"downloading": "Fetching %s", "progress": "%[3]s/%[4]s"}`, ""},
+		{"unknown id", `{"downloadin": "Fetching %s"}`, `unknown message "downloadin"`},
+		{"missing argument", `{"served_by": "%s served by %s from %s"}`, "does not take the arguments"},
+		{"extra argument", `{"downloading": "Fetching"}`, "does not take the arguments"},
+		{"wrong verb", `{"example_pass": "PASS %d"}`, "does not take the arguments"},
+		{"not json", `downloading: x`, "parse messages"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, filepath.Join(dir, tt.name+".json"), tt.json)
+			m, err := LoadMessages(path)
+			if tt.wantErr == "" {
+				if err != nil || m[MsgDownloading] != "Fetching %s" {
+					t.Errorf("got %v, %v", m, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFallbackUsesMessages(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	az.Messages = Messages{MsgServedBy: "[%s via %s]"}
+	m.put("blob", []byte("data"), nil)
+	az.WithFallback(az.StorageAccount, "old")
+	if az.Fallback.Messages == nil {
+		t.Fatal("fallback did not get the message catalog")
+	}
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	if _, err := az.Download(context.Background(), "blob", filepath.Join(t.TempDir(), "blob")); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), "[blob via account/container]") {
+		t.Errorf("log %q does not use the catalog", logs.String())
+	}
+}
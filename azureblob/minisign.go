@@ -0,0 +1,117 @@
+package azureblob
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// ErrBadSignature is returned, wrapped, when a signed file does not match
+// its minisign signature.
+var ErrBadSignature = errors.New("signature verification failed")
+
+// Minisign signature algorithms: Ed25519 over the file itself, as legacy
+// minisign signatures are, or over its BLAKE2b-512 hash, as current ones
+// are.
+var (
+	minisignLegacy    = [2]byte{'E', 'd'}
+	minisignPrehashed = [2]byte{'E', 'D'}
+)
+
+const (
+	minisignUntrustedPrefix = "untrusted comment:"
+	minisignTrustedPrefix   = "trusted comment: "
+)
+
+// MinisignPublicKey is an Ed25519 public key in the format of minisign.
+type MinisignPublicKey struct {
+	KeyID [8]byte
+	Key   ed25519.PublicKey
+}
+
+// ParseMinisignPublicKey parses a minisign public key: the base64 line of a
+// minisign.pub file, such as "RWQf6LRCGA9i53mlYecO4IzT51TGPpvWucNSCh1CBM0QTaLn73Y7GFO3",
+// or the whole file with its comment line.
+func ParseMinisignPublicKey(s string) (*MinisignPublicKey, error) {
+	var line string
+	for _, l := range strings.Split(strings.TrimSpace(s), "\n") {
+		if l = strings.TrimSpace(l); l != "" && !strings.HasPrefix(l, minisignUntrustedPrefix) {
+			line = l
+		}
+	}
+	b, err := base64.StdEncoding.DecodeString(line)
+	if err != nil || len(b) != 2+8+ed25519.PublicKeySize || !bytes.Equal(b[:2], minisignLegacy[:]) {
+		return nil, errors.New("not a minisign public key")
+	}
+	k := &MinisignPublicKey{Key: ed25519.PublicKey(b[10:])}
+	copy(k.KeyID[:], b[2:10])
+	return k, nil
+}
+
+// loadMinisignPublicKey parses s as a public key, or reads it from the file
+// s names.
+func loadMinisignPublicKey(s string) (*MinisignPublicKey, error) {
+	if k, err := ParseMinisignPublicKey(s); err == nil {
+		return k, nil
+	}
+	b, err := os.ReadFile(s)
+	if err != nil {
+		return nil, fmt.Errorf("public key %q is neither a minisign key nor a readable file: %w", s, err)
+	}
+	k, err := ParseMinisignPublicKey(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s, err)
+	}
+	return k, nil
+}
+
+// String returns the key ID as minisign prints it.
+func (k *MinisignPublicKey) String() string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(k.KeyID[:]))
+}
+
+// Verify checks that sig, the contents of a .minisig file, is a signature
+// of data by k, including the signature of its trusted comment, which it
+// returns.
+func (k *MinisignPublicKey) Verify(data, sig []byte) (string, error) {
+	lines := strings.Split(strings.TrimRight(string(sig), "\r\n"), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], "\r")
+	}
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], minisignUntrustedPrefix) || !strings.HasPrefix(lines[2], minisignTrustedPrefix) {
+		return "", fmt.Errorf("%w: not a minisign signature", ErrBadSignature)
+	}
+	b, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(b) != 2+8+ed25519.SignatureSize {
+		return "", fmt.Errorf("%w: malformed signature", ErrBadSignature)
+	}
+	if !bytes.Equal(b[2:10], k.KeyID[:]) {
+		var id MinisignPublicKey
+		copy(id.KeyID[:], b[2:10])
+		return "", fmt.Errorf("%w: signed by key %s, not %s", ErrBadSignature, &id, k)
+	}
+	signature := b[10:]
+	switch {
+	case bytes.Equal(b[:2], minisignPrehashed[:]):
+		sum := blake2b.Sum512(data)
+		data = sum[:]
+	case !bytes.Equal(b[:2], minisignLegacy[:]):
+		return "", fmt.Errorf("%w: unknown algorithm %q", ErrBadSignature, b[:2])
+	}
+	if !ed25519.Verify(k.Key, data, signature) {
+		return "", fmt.Errorf("%w: the signature does not match", ErrBadSignature)
+	}
+	comment := strings.TrimPrefix(lines[2], minisignTrustedPrefix)
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || !ed25519.Verify(k.Key, append(append([]byte(nil), signature...), comment...), global) {
+		return "", fmt.Errorf("%w: the trusted comment does not match", ErrBadSignature)
+	}
+	return comment, nil
+}
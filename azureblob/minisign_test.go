@@ -0,0 +1,103 @@
+package azureblob
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// testMinisignKey returns a key pair with the key ID 0102030405060708 and
+// the public key as minisign.pub holds it.
+func testMinisignKey(t *testing.T) (ed25519.PrivateKey, string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := append([]byte("Ed\x08\x07\x06\x05\x04\x03\x02\x01"), pub...)
+	return priv, "untrusted comment: minisign public key 0102030405060708\n" + base64.StdEncoding.EncodeToString(b) + "\n"
+}
+
+// minisignSign signs data as minisign does, prehashing it unless legacy.
+func minisignSign(priv ed25519.PrivateKey, data []byte, legacy bool, comment string) []byte {
+	alg := "ED"
+	if legacy {
+		alg = "Ed"
+	} else {
+		sum := blake2b.Sum512(data)
+		data = sum[:]
+	}
+	sig := ed25519.Sign(priv, data)
+	global := ed25519.Sign(priv, append(append([]byte(nil), sig...), comment...))
+	line := append([]byte(alg+"\x08\x07\x06\x05\x04\x03\x02\x01"), sig...)
+	return []byte(fmt.Sprintf("untrusted comment: signature from minisign secret key\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(line), comment, base64.StdEncoding.EncodeToString(global)))
+}
+
+func TestMinisignVerify(t *testing.T) {
+	priv, pubFile := testMinisignKey(t)
+	key, err := ParseMinisignPublicKey(pubFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.String() != "0102030405060708" {
+		t.Errorf("key ID = %s", key)
+	}
+	data := []byte(`{"files":[]}`)
+	for _, legacy := range []bool{false, true} {
+		comment, err := key.Verify(data, minisignSign(priv, data, legacy, "timestamp:1760486400"))
+		if err != nil || comment != "timestamp:1760486400" {
+			t.Errorf("legacy %v: Verify = %q, %v", legacy, comment, err)
+		}
+	}
+
+	sig := minisignSign(priv, data, false, "timestamp:1760486400")
+	forged := strings.Replace(string(sig), "timestamp:1760486400", "timestamp:1900000000", 1)
+	otherPriv, otherPub := testMinisignKey(t)
+	otherKey, _ := ParseMinisignPublicKey(otherPub)
+	otherKey.KeyID[0] = 9
+	for name, tt := range map[string]struct {
+		key  *MinisignPublicKey
+		data []byte
+		sig  []byte
+		want string
+	}{
+		"tampered data":    {key, []byte(`{"files":[{}]}`), sig, "the signature does not match"},
+		"forged comment":   {key, data, []byte(forged), "the trusted comment does not match"},
+		"another key":      {otherKey, data, sig, "signed by key 0102030405060708, not 0102030405060709"},
+		"another key's ID": {key, data, minisignSign(otherPriv, data, false, ""), "the signature does not match"},
+		"not a signature":  {key, data, []byte("hello"), "not a minisign signature"},
+	} {
+		_, err := tt.key.Verify(tt.data, tt.sig)
+		if !errors.Is(err, ErrBadSignature) || !strings.HasSuffix(err.Error(), tt.want) {
+			t.Errorf("%s: Verify = %v, want %q", name, err, tt.want)
+		}
+	}
+	if exitCode(fmt.Errorf("manifest: %w", ErrBadSignature)) != exitChecksum {
+		t.Error("a bad signature does not exit with exitChecksum")
+	}
+}
+
+func TestLoadMinisignPublicKey(t *testing.T) {
+	_, pubFile := testMinisignKey(t)
+	line := strings.Split(strings.TrimSpace(pubFile), "\n")[1]
+	path := writeFile(t, filepath.Join(t.TempDir(), "minisign.pub"), pubFile)
+	for _, s := range []string{line, path} {
+		if k, err := loadMinisignPublicKey(s); err != nil || k.String() != "0102030405060708" {
+			t.Errorf("loadMinisignPublicKey(%q) = %v, %v", s, k, err)
+		}
+	}
+	if _, err := loadMinisignPublicKey("RWQnotakey"); err == nil || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("loadMinisignPublicKey of garbage = %v", err)
+	}
+	if _, err := ParseMinisignPublicKey(base64.StdEncoding.EncodeToString(make([]byte, 42))); err == nil {
+		t.Error("a key of another algorithm parsed")
+	}
+}
@@ -0,0 +1,84 @@
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// MockBlobStorage is a BlobStorage for tests. Each method calls the
+// matching func field, or fails if it is nil, and is recorded in Calls.
+// It is safe for concurrent use as long as the func fields are.
+type MockBlobStorage struct {
+	DownloadFunc func(ctx context.Context, asset, destination string) (*TransferResult, error)
+	UploadFunc   func(ctx context.Context, file *os.File, blobPath string) (*TransferResult, error)
+	ListFunc     func(ctx context.Context, prefix string) ([]*BlobProperties, error)
+	DeleteFunc   func(ctx context.Context, blobPath string) error
+	StatFunc     func(ctx context.Context, blobPath string) (*BlobProperties, error)
+
+	mu    sync.Mutex
+	calls []MockCall
+}
+
+// MockCall is a call made to a MockBlobStorage.
+type MockCall struct {
+	// Method is the name of the method called, e.g. "Download".
+	Method string
+	// Blob is the blob name or, for List, the prefix the call was for.
+	Blob string
+}
+
+var _ BlobStorage = (*MockBlobStorage)(nil)
+
+// Calls returns the calls made so far, in order.
+func (m *MockBlobStorage) Calls() []MockCall {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]MockCall(nil), m.calls...)
+}
+
+func (m *MockBlobStorage) record(method, blob string, set bool) error {
+	m.mu.Lock()
+	m.calls = append(m.calls, MockCall{Method: method, Blob: blob})
+	m.mu.Unlock()
+	if !set {
+		return fmt.Errorf("MockBlobStorage: unexpected call to %s(%q)", method, blob)
+	}
+	return nil
+}
+
+func (m *MockBlobStorage) Download(ctx context.Context, asset, destination string) (*TransferResult, error) {
+	if err := m.record("Download", asset, m.DownloadFunc != nil); err != nil {
+		return nil, err
+	}
+	return m.DownloadFunc(ctx, asset, destination)
+}
+
+func (m *MockBlobStorage) Upload(ctx context.Context, file *os.File, blobPath string) (*TransferResult, error) {
+	if err := m.record("Upload", blobPath, m.UploadFunc != nil); err != nil {
+		return nil, err
+	}
+	return m.UploadFunc(ctx, file, blobPath)
+}
+
+func (m *MockBlobStorage) List(ctx context.Context, prefix string) ([]*BlobProperties, error) {
+	if err := m.record("List", prefix, m.ListFunc != nil); err != nil {
+		return nil, err
+	}
+	return m.ListFunc(ctx, prefix)
+}
+
+func (m *MockBlobStorage) Delete(ctx context.Context, blobPath string) error {
+	if err := m.record("Delete", blobPath, m.DeleteFunc != nil); err != nil {
+		return err
+	}
+	return m.DeleteFunc(ctx, blobPath)
+}
+
+func (m *MockBlobStorage) Stat(ctx context.Context, blobPath string) (*BlobProperties, error) {
+	if err := m.record("Stat", blobPath, m.StatFunc != nil); err != nil {
+		return nil, err
+	}
+	return m.StatFunc(ctx, blobPath)
+}
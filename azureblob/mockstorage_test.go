@@ -0,0 +1,83 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMockBlobStorageUnexpectedCall(t *testing.T) {
+	m := &MockBlobStorage{}
+	ctx := context.Background()
+	_, downloadErr := m.Download(ctx, "a", "dest")
+	_, uploadErr := m.Upload(ctx, nil, "b")
+	errs := []error{downloadErr, uploadErr, m.Delete(ctx, "c")}
+	_, err := m.List(ctx, "d/")
+	errs = append(errs, err)
+	_, err = m.Stat(ctx, "e")
+	errs = append(errs, err)
+	for _, err := range errs {
+		if err == nil || !strings.Contains(err.Error(), "unexpected call") {
+			t.Errorf("got %v, want an unexpected call error", err)
+		}
+	}
+	want := []MockCall{{"Download", "a"}, {"Upload", "b"}, {"Delete", "c"}, {"List", "d/"}, {"Stat", "e"}}
+	if got := m.Calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("calls %v, want %v", got, want)
+	}
+}
+
+func TestExampleRoundTripWithMock(t *testing.T) {
+	var uploaded []byte
+	m := &MockBlobStorage{
+		UploadFunc: func(ctx context.Context, file *os.File, blobPath string) (*TransferResult, error) {
+			var err error
+			uploaded, err = io.ReadAll(file)
+			return &TransferResult{Blob: blobPath, Bytes: int64(len(uploaded))}, err
+		},
+		DownloadFunc: func(ctx context.Context, asset, destination string) (*TransferResult, error) {
+			return &TransferResult{Blob: asset, Bytes: int64(len(uploaded))}, os.WriteFile(destination, uploaded, 0600)
+		},
+		DeleteFunc: func(ctx context.Context, blobPath string) error { return nil },
+	}
+	if err := exampleRoundTrip(context.Background(), m, exampleOptions{Prefix: "examples", Size: 64}); err != nil {
+		t.Fatal(err)
+	}
+	var methods []string
+	for _, c := range m.Calls() {
+		methods = append(methods, c.Method)
+		if !strings.HasPrefix(c.Blob, "examples/roundtrip-") {
+			t.Errorf("%s of %q, want a blob under the example prefix", c.Method, c.Blob)
+		}
+	}
+	if want := []string{"Upload", "Download", "Delete"}; !reflect.DeepEqual(methods, want) {
+		t.Errorf("calls %v, want %v", methods, want)
+	}
+
+	m.DownloadFunc = func(ctx context.Context, asset, destination string) (*TransferResult, error) {
+		return &TransferResult{Blob: asset, Bytes: 9}, os.WriteFile(destination, []byte("corrupted"), 0600)
+	}
+	if err := exampleRoundTrip(context.Background(), m, exampleOptions{Prefix: "examples", Size: 64}); err == nil || !strings.Contains(err.Error(), "sha256") {
+		t.Errorf("round trip of corrupted data = %v, want a hash mismatch", err)
+	}
+}
+
+func TestExampleListWithMock(t *testing.T) {
+	m := &MockBlobStorage{
+		ListFunc: func(ctx context.Context, prefix string) ([]*BlobProperties, error) {
+			return []*BlobProperties{{Name: prefix + "a"}, {}}, nil
+		},
+	}
+	if err := exampleList(context.Background(), m, exampleOptions{Prefix: "p/"}); err == nil || !strings.Contains(err.Error(), "without a name") {
+		t.Errorf("listing with a nameless blob = %v", err)
+	}
+	failure := errors.New("listing failed")
+	m.ListFunc = func(ctx context.Context, prefix string) ([]*BlobProperties, error) { return nil, failure }
+	if err := exampleList(context.Background(), m, exampleOptions{}); err != failure {
+		t.Errorf("got %v, want %v", err, failure)
+	}
+}
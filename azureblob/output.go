@@ -0,0 +1,115 @@
+package azureblob
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats of the commands that print blob properties or summaries.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputCSV   = "csv"
+)
+
+// outputFlag defines the -output flag of fs.
+func outputFlag(fs *flag.FlagSet) *string {
+	return fs.String("output", outputTable, "print the results as a `format`: table, json or csv")
+}
+
+// checkOutput returns an error unless format is one of the output formats.
+func checkOutput(format string) error {
+	switch format {
+	case outputTable, outputJSON, outputCSV:
+		return nil
+	}
+	return fmt.Errorf("-output must be table, json or csv, got %q", format)
+}
+
+// printJSON prints v to stdout as indented JSON.
+func printJSON(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(append(b, '\n'))
+	return err
+}
+
+// printCSV prints a CSV table with a header row to stdout.
+func printCSV(header []string, rows [][]string) error {
+	w := csv.NewWriter(os.Stdout)
+	w.Write(header)
+	w.WriteAll(rows)
+	return w.Error()
+}
+
+// printProperties prints blobs in format: a line of size, last-modified
+// time, access tier and name per blob for a table, or all their properties
+// for JSON and CSV.
+func printProperties(format string, blobs []*BlobProperties) error {
+	switch format {
+	case outputJSON:
+		if blobs == nil {
+			blobs = []*BlobProperties{}
+		}
+		return printJSON(blobs)
+	case outputCSV:
+		rows := make([][]string, len(blobs))
+		for i, b := range blobs {
+			rows[i] = propertiesRow(b)
+		}
+		return printCSV(propertiesHeader, rows)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, b := range blobs {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", b.Size, b.LastModified.UTC().Format(time.RFC3339), b.AccessTier, b.Name)
+	}
+	return w.Flush()
+}
+
+// propertiesHeader names the columns of propertiesRow.
+var propertiesHeader = []string{"name", "size", "etag", "lastModified", "contentType", "contentEncoding", "cacheControl", "contentMD5", "accessTier", "encryptionScope", "metadata", "tags"}
+
+// propertiesRow returns the CSV columns of p. Metadata and tags are each
+// one column of semicolon-separated key=value pairs, sorted by key.
+func propertiesRow(p *BlobProperties) []string {
+	var md5 string
+	if len(p.ContentMD5) > 0 {
+		md5 = base64.StdEncoding.EncodeToString(p.ContentMD5)
+	}
+	return []string{
+		p.Name,
+		strconv.FormatInt(p.Size, 10),
+		p.ETag,
+		p.LastModified.UTC().Format(time.RFC3339),
+		p.ContentType,
+		p.ContentEncoding,
+		p.CacheControl,
+		md5,
+		p.AccessTier,
+		p.EncryptionScope,
+		keyValues(p.Metadata),
+		keyValues(p.Tags),
+	}
+}
+
+// keyValues formats m as key=value pairs sorted by key and separated by
+// semicolons.
+func keyValues(m map[string]string) string {
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ";")
+}
@@ -0,0 +1,109 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyValues(t *testing.T) {
+	if got := keyValues(map[string]string{"b": "2", "a": "1"}); got != "a=1;b=2" {
+		t.Errorf("keyValues = %q", got)
+	}
+	if got := keyValues(nil); got != "" {
+		t.Errorf("keyValues(nil) = %q", got)
+	}
+}
+
+func TestRunListOutput(t *testing.T) {
+	m := newMemContainer()
+	m.put("pkgs/a.pkg", []byte("aa"), map[string]string{"build": "7"}).tags = map[string]string{"team": "infra"}
+	m.put("pkgs/b.pkg", []byte("b"), nil)
+	m.put("logs/x", []byte("x"), nil)
+	az := newTestClient(t, m)
+	ctx := context.Background()
+	var err error
+
+	out := captureStdout(t, func() { err = runList(ctx, az, []string{"-output", "json", "-metadata", "-tags", "pkgs/"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blobs []BlobProperties
+	if err := json.Unmarshal([]byte(out), &blobs); err != nil {
+		t.Fatal(err)
+	}
+	if len(blobs) != 2 || blobs[0].Name != "pkgs/a.pkg" || blobs[0].Size != 2 || blobs[0].ETag == "" ||
+		blobs[0].Metadata["Build"] != "7" || blobs[0].Tags["team"] != "infra" || len(blobs[1].Tags) != 0 {
+		t.Errorf("json:\n%s", out)
+	}
+
+	out = captureStdout(t, func() { err = runList(ctx, az, []string{"-output", "csv", "-tags", "pkgs/"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || strings.Join(rows[0], ",") != strings.Join(propertiesHeader, ",") ||
+		rows[1][0] != "pkgs/a.pkg" || rows[1][1] != "2" || rows[1][len(rows[1])-1] != "team=infra" {
+		t.Errorf("csv: %q", rows)
+	}
+
+	out = captureStdout(t, func() { err = runList(ctx, az, []string{"-output", "json", "none/"}) })
+	if err != nil || strings.TrimSpace(out) != "[]" {
+		t.Errorf("empty listing: %q, %v", out, err)
+	}
+	if err := runList(ctx, az, []string{"-output", "yaml"}); err == nil {
+		t.Error("listed with an unknown output format")
+	}
+}
+
+func TestRunStatOutput(t *testing.T) {
+	m := newMemContainer()
+	m.put("a.pkg", []byte("aa"), map[string]string{"build": "7"}).tags = map[string]string{"team": "infra"}
+	az := newTestClient(t, m)
+	ctx := context.Background()
+	var err error
+
+	out := captureStdout(t, func() { err = runStat(ctx, az, []string{"-output", "json", "-tags", "a.pkg"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var props BlobProperties
+	if err := json.Unmarshal([]byte(out), &props); err != nil {
+		t.Fatal(err)
+	}
+	if props.Name != "a.pkg" || props.Size != 2 || props.Metadata["Build"] != "7" || props.Tags["team"] != "infra" {
+		t.Errorf("json:\n%s", out)
+	}
+	out = captureStdout(t, func() { err = runStat(ctx, az, []string{"-tags", "a.pkg"}) })
+	if err != nil || !strings.Contains(out, "Tag:           team=infra\n") {
+		t.Errorf("table: %q, %v", out, err)
+	}
+}
+
+func TestRunDuAndDiffCSV(t *testing.T) {
+	m := newMemContainer()
+	m.put("p/a", []byte("a"), nil)
+	m.put("p/d/b", []byte("bb"), nil)
+	az := newTestClient(t, m)
+	ctx := context.Background()
+	var err error
+
+	out := captureStdout(t, func() { err = runDu(ctx, az, []string{"-output", "csv", "p"}) })
+	if err != nil || out != "prefix,size,blobs\np/,3,2\np/d/,2,1\n" {
+		t.Errorf("du csv: %q, %v", out, err)
+	}
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a"), "a")
+	writeFile(t, filepath.Join(dir, "c"), "ccc")
+	out = captureStdout(t, func() { err = runDiff(ctx, az, []string{"-output", "csv", dir, "p"}) })
+	if err != nil || out != "name,status,localSize,remoteSize\nc,added,3,-1\nd/b,missing,-1,2\n" {
+		t.Errorf("diff csv: %q, %v", out, err)
+	}
+}
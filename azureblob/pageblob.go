@@ -0,0 +1,150 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+const (
+	// pageSize is the unit page blobs are sized and written in.
+	pageSize = azblob.PageBlobPageBytes
+	// pageChunkSize is the most a single Put Page request may write.
+	pageChunkSize = 4 << 20
+)
+
+// UploadPageBlob uploads file to blobPath as a page blob, the blob type VM
+// disk images (VHDs) must be stored as. A file whose size is not a whole
+// number of 512-byte pages is refused, unless pad is set, in which case the
+// blob is rounded up and its tail reads as zeros. Pages holding only zeros
+// are not sent, because a new page blob reads as zeros, so sparse images
+// only cost the transfer of their data. It returns the number of bytes
+// sent. Client-side compression and encryption do not apply, as Azure must
+// be able to read the disk.
+func (c *AzureBlobClient) UploadPageBlob(ctx context.Context, file *os.File, blobPath string, pad bool) (int64, error) {
+	if o := c.clientOptions(); o.Compression != "" || o.EncryptUploads {
+		return 0, fmt.Errorf("upload %q: page blobs cannot be compressed or encrypted client-side", blobPath)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	if size%pageSize != 0 && !pad {
+		return 0, fmt.Errorf("upload %q: %s is %d bytes, not a whole number of %d-byte pages", blobPath, file.Name(), size, pageSize)
+	}
+	size = (size + pageSize - 1) / pageSize * pageSize
+	var sent int64
+	err = c.withRebuild(ctx, "upload", blobPath, func() error {
+		var err error
+		sent, err = c.uploadPageBlob(ctx, file, blobPath, size)
+		return err
+	})
+	return sent, err
+}
+
+func (c *AzureBlobClient) uploadPageBlob(ctx context.Context, file *os.File, blobPath string, size int64) (int64, error) {
+	if err := c.init(ctx); err != nil {
+		return 0, err
+	}
+	blob := c.containerClient.NewPageBlobClient(blobPath)
+	scope := c.clientOptions().cpkScopeInfo()
+	_, err := blob.Create(ctx, size, &azblob.CreatePageBlobOptions{CpkScopeInfo: scope})
+	if err != nil {
+		return 0, newBlobError("create", blobPath, err)
+	}
+	var sent int64
+	chunks := int((size + pageChunkSize - 1) / pageChunkSize)
+	errs := c.Pool.Run(ctx, chunks, func(ctx context.Context, i int) error {
+		offset := int64(i) * pageChunkSize
+		n := size - offset
+		if n > pageChunkSize {
+			n = pageChunkSize
+		}
+		pooled := getBuffer(pageChunkSize)
+		defer putBuffer(pooled)
+		buf := (*pooled)[:n]
+		read, err := file.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		// Past the end of an unaligned file the buffer is zeroed, which
+		// pads the last page.
+		for j := read; j < len(buf); j++ {
+			buf[j] = 0
+		}
+		for _, r := range dataPages(buf) {
+			start, end := offset+int64(r[0]), offset+int64(r[1])
+			ctx := WithRequestHeader(ctx, "x-ms-range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+			body := streaming.NopCloser(bytes.NewReader(buf[r[0]:r[1]]))
+			if _, err := blob.UploadPages(ctx, body, &azblob.UploadPagesOptions{CpkScopeInfo: scope}); err != nil {
+				return newBlobError("upload", blobPath, err)
+			}
+			atomic.AddInt64(&sent, end-start)
+		}
+		return nil
+	})
+	for _, err := range errs {
+		if err != nil {
+			return atomic.LoadInt64(&sent), err
+		}
+	}
+	return sent, c.protectUpload(ctx, blobPath)
+}
+
+// dataPages returns the [start, end) byte ranges of the runs of pages in
+// buf that hold anything but zeros. buf is a whole number of pages.
+func dataPages(buf []byte) [][2]int {
+	var runs [][2]int
+	zero := make([]byte, pageSize)
+	for off := 0; off < len(buf); off += pageSize {
+		if bytes.Equal(buf[off:off+pageSize], zero) {
+			continue
+		}
+		if n := len(runs); n > 0 && runs[n-1][1] == off {
+			runs[n-1][1] = off + pageSize
+		} else {
+			runs = append(runs, [2]int{off, off + pageSize})
+		}
+	}
+	return runs
+}
+
+func runPageUpload(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("page-upload", flag.ContinueOnError)
+	pad := fs.Bool("pad", false, "pad a file that is not a whole number of 512-byte pages with zeros")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: page-upload [-pad] <file> <blob>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("page-upload takes a file and a blob name")
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sent, err := az.UploadPageBlob(ctx, f, fs.Arg(1), *pad)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fmt.Println(az.Messages.format(MsgPageUploaded, fs.Arg(1), formatBytes(sent), formatBytes(info.Size())))
+	return nil
+}
@@ -0,0 +1,85 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDataPages(t *testing.T) {
+	buf := make([]byte, 6*pageSize)
+	buf[0] = 1
+	buf[pageSize+10] = 1
+	buf[4*pageSize+pageSize-1] = 1
+	want := [][2]int{{0, 2 * pageSize}, {4 * pageSize, 5 * pageSize}}
+	if got := dataPages(buf); !reflect.DeepEqual(got, want) {
+		t.Errorf("dataPages = %v, want %v", got, want)
+	}
+	if got := dataPages(make([]byte, 3*pageSize)); len(got) != 0 {
+		t.Errorf("dataPages of zeros = %v", got)
+	}
+}
+
+func writeImage(t *testing.T, content []byte) *os.File {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "disk.vhd")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestUploadPageBlobSparse(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	// A mostly empty image spanning several chunks, as disk images are.
+	image := make([]byte, 3*pageChunkSize)
+	copy(image, "boot sector")
+	copy(image[2*pageChunkSize+pageSize:], "data")
+	sent, err := az.UploadPageBlob(context.Background(), writeImage(t, image), "disk.vhd", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 2*pageSize || m.pageWrites != 2 {
+		t.Errorf("sent %d bytes in %d writes, want the 2 pages holding data", sent, m.pageWrites)
+	}
+	if !bytes.Equal(m.blobs["disk.vhd"].data, image) {
+		t.Error("the page blob differs from the image")
+	}
+}
+
+func TestUploadPageBlobAlignment(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	ctx := context.Background()
+	image := bytes.Repeat([]byte{7}, pageSize+3)
+	if _, err := az.UploadPageBlob(ctx, writeImage(t, image), "disk.vhd", false); err == nil {
+		t.Fatal("uploaded an unaligned image without pad")
+	}
+	if _, ok := m.blobs["disk.vhd"]; ok {
+		t.Error("an unaligned image created the blob")
+	}
+	if _, err := az.UploadPageBlob(ctx, writeImage(t, image), "disk.vhd", true); err != nil {
+		t.Fatal(err)
+	}
+	want := append(append([]byte(nil), image...), make([]byte, pageSize-3)...)
+	if !bytes.Equal(m.blobs["disk.vhd"].data, want) {
+		t.Errorf("padded blob is %d bytes, want %d ending in zeros", len(m.blobs["disk.vhd"].data), len(want))
+	}
+}
+
+func TestUploadPageBlobRejectsEncoding(t *testing.T) {
+	az := newTestClient(t, newMemContainer())
+	az.ClientOptions.EncryptUploads = true
+	if _, err := az.UploadPageBlob(context.Background(), writeImage(t, make([]byte, pageSize)), "disk.vhd", false); err == nil {
+		t.Error("UploadPageBlob encrypted a page blob")
+	}
+}
@@ -0,0 +1,158 @@
+package azureblob
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// filenamePlaceholder stands for the name of the file a blob holds in a
+// PathTemplate.
+const filenamePlaceholder = "{filename}"
+
+// pathTemplateEnv maps the placeholders a PathTemplate fills from the
+// Buildkite job, when neither -path-var nor their own variable sets them.
+var pathTemplateEnv = map[string]string{
+	"pipeline": "BUILDKITE_PIPELINE_SLUG",
+	"build":    "BUILDKITE_BUILD_NUMBER",
+	"job":      "BUILDKITE_JOB_ID",
+	"branch":   "BUILDKITE_BRANCH",
+	"commit":   "BUILDKITE_COMMIT",
+}
+
+var (
+	placeholderPattern = regexp.MustCompile(`\{([a-z][a-z0-9_]*)\}`)
+	placeholderName    = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+)
+
+// PathTemplate names blobs by a scheme such as
+// {pipeline}/{build}/{os}/{arch}/{filename}, so that every command that
+// uploads or downloads a file computes the same blob name for it. Its
+// placeholders take their values, in order, from the vars it was parsed
+// with, from BK_AZUREBLOB_PATH_VAR_<NAME>, and for {pipeline}, {build},
+// {job}, {branch} and {commit} from the Buildkite job. {os} and {arch} are
+// those of the running binary, as Go names them. {filename} must end the
+// template as its last path element, so that the blobs of a directory
+// share the prefix before it.
+type PathTemplate struct {
+	text   string
+	vars   map[string]string
+	lookup func(string) (string, bool)
+}
+
+// ParsePathTemplate parses text, which lookup and vars complete as
+// PathTemplate describes. Placeholders without a value are only reported
+// once a blob is named with the template.
+func ParsePathTemplate(text string, vars map[string]string, lookup func(string) (string, bool)) (*PathTemplate, error) {
+	if text != filenamePlaceholder && !strings.HasSuffix(text, "/"+filenamePlaceholder) {
+		return nil, fmt.Errorf("path template %q must end with /%s", text, filenamePlaceholder)
+	}
+	dir := strings.TrimSuffix(text, filenamePlaceholder)
+	if rest := placeholderPattern.ReplaceAllString(dir, ""); strings.ContainsAny(rest, "{}") {
+		return nil, fmt.Errorf("path template %q has a malformed placeholder; names are lower case, e.g. {pipeline}", text)
+	}
+	for _, m := range placeholderPattern.FindAllStringSubmatch(dir, -1) {
+		if m[0] == filenamePlaceholder {
+			return nil, fmt.Errorf("path template %q has %s before its end", text, filenamePlaceholder)
+		}
+	}
+	return &PathTemplate{text: text, vars: vars, lookup: lookup}, nil
+}
+
+func (t *PathTemplate) String() string {
+	return t.text
+}
+
+// Prefix returns the directory of the blobs the template names, ending in
+// a slash unless it is empty.
+func (t *PathTemplate) Prefix() (string, error) {
+	var missing []string
+	prefix := placeholderPattern.ReplaceAllStringFunc(strings.TrimSuffix(t.text, filenamePlaceholder), func(p string) string {
+		name := p[1 : len(p)-1]
+		v, ok := t.value(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return "", fmt.Errorf("path template %q has no value for %s; give -path-var %s=<value>", t.text, strings.Join(missing, ", "), missing[0])
+	}
+	for _, elem := range strings.Split(strings.TrimSuffix(prefix, "/"), "/") {
+		if prefix != "" && (elem == "" || elem == "." || elem == "..") {
+			return "", fmt.Errorf("path template %q names the prefix %q, which has an empty, . or .. element", t.text, prefix)
+		}
+	}
+	return prefix, nil
+}
+
+// Blob returns the blob name of the file name, a slash-separated path
+// relative to the prefix such as the base name of an uploaded file.
+func (t *PathTemplate) Blob(name string) (string, error) {
+	prefix, err := t.Prefix()
+	if err != nil {
+		return "", err
+	}
+	clean := path.Clean(name)
+	if name == "" || clean == "." || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("%q cannot be named by a path template; give a relative path without ..", name)
+	}
+	return prefix + clean, nil
+}
+
+// value returns the value of the placeholder name.
+func (t *PathTemplate) value(name string) (string, bool) {
+	if v, ok := t.vars[name]; ok {
+		return v, true
+	}
+	if v, ok := t.lookup(envPrefix + "PATH_VAR_" + strings.ToUpper(name)); ok && v != "" {
+		return v, true
+	}
+	switch name {
+	case "os":
+		return runtime.GOOS, true
+	case "arch":
+		return runtime.GOARCH, true
+	}
+	if env, ok := pathTemplateEnv[name]; ok {
+		if v, ok := t.lookup(env); ok && v != "" {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// templateBlob returns the blob name the PathTemplate of ClientOptions
+// gives the file name, or name itself if there is none.
+func (c *AzureBlobClient) templateBlob(name string) (string, error) {
+	if t := c.clientOptions().PathTemplate; t != nil {
+		return t.Blob(name)
+	}
+	return name, nil
+}
+
+// pathVarFlag collects repeated "name=value" flags giving the values of
+// PathTemplate placeholders.
+type pathVarFlag map[string]string
+
+func (v pathVarFlag) String() string {
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func (v pathVarFlag) Set(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || !placeholderName.MatchString(parts[0]) || "{"+parts[0]+"}" == filenamePlaceholder {
+		return fmt.Errorf("path variable %q is not in name=value form with a lower-case name other than filename", s)
+	}
+	v[parts[0]] = parts[1]
+	return nil
+}
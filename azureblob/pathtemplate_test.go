@@ -0,0 +1,124 @@
+package azureblob
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPathTemplate(t *testing.T) {
+	env := mapLookup(map[string]string{
+		"BUILDKITE_PIPELINE_SLUG":    "agent",
+		"BUILDKITE_BUILD_NUMBER":     "1234",
+		"BUILDKITE_BRANCH":           "",
+		"BK_AZUREBLOB_PATH_VAR_TEAM": "infra",
+	})
+	tmpl, err := ParsePathTemplate("releases/{team}/{pipeline}/{build}/{os}-{arch}/{filename}", map[string]string{"build": "1235"}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := "releases/infra/agent/1235/" + runtime.GOOS + "-" + runtime.GOARCH + "/"
+	if got, err := tmpl.Prefix(); got != prefix || err != nil {
+		t.Errorf("Prefix = %q, %v", got, err)
+	}
+	for name, want := range map[string]string{"agent.zip": prefix + "agent.zip", "bin/./agent": prefix + "bin/agent"} {
+		if got, err := tmpl.Blob(name); got != want || err != nil {
+			t.Errorf("Blob(%q) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"", ".", "../escape", "/etc/passwd"} {
+		if got, err := tmpl.Blob(name); err == nil {
+			t.Errorf("Blob(%q) = %q", name, got)
+		}
+	}
+
+	missing, err := ParsePathTemplate("{pipeline}/{branch}/{commit}/{filename}", nil, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := missing.Blob("a"); err == nil || !strings.Contains(err.Error(), "no value for branch, commit") {
+		t.Errorf("Blob with missing values = %v", err)
+	}
+	empty, _ := ParsePathTemplate("{pipeline}/{stage}/{filename}", map[string]string{"stage": ""}, env)
+	if _, err := empty.Blob("a"); err == nil {
+		t.Error("a template with an empty element was accepted")
+	}
+	if got, err := (&PathTemplate{text: filenamePlaceholder}).Blob("a/b"); got != "a/b" || err != nil {
+		t.Errorf("Blob = %q, %v", got, err)
+	}
+
+	for _, bad := range []string{"{pipeline}/{build}", "{pipeline}/{build}-{filename}", "{filename}/{filename}", "{Pipeline}/{filename}", "{pipeline/{filename}"} {
+		if _, err := ParsePathTemplate(bad, nil, env); err == nil {
+			t.Errorf("ParsePathTemplate(%q) was accepted", bad)
+		}
+	}
+}
+
+func TestPathVarFlag(t *testing.T) {
+	v := pathVarFlag{}
+	for _, s := range []string{"team=infra", "channel=beta=2"} {
+		if err := v.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v["channel"] != "beta=2" || v.String() != "channel,team" {
+		t.Errorf("pathVarFlag = %v", v)
+	}
+	for _, s := range []string{"team", "Team=x", "filename=x", "a}{b=x"} {
+		if err := v.Set(s); err == nil {
+			t.Errorf("-path-var %q was accepted", s)
+		}
+	}
+}
+
+func TestPathTemplateCommands(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	tmpl, err := ParsePathTemplate("{pipeline}/{build}/{filename}", map[string]string{"pipeline": "agent", "build": "7"}, mapLookup(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	az.ClientOptions.PathTemplate = tmpl
+	ctx := context.Background()
+	src := t.TempDir()
+	a := writeFile(t, filepath.Join(src, "a.zip"), "a")
+	b := writeFile(t, filepath.Join(src, "nested", "b.txt"), "b")
+
+	if err := runUpload(ctx, az, []string{a}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runUpload(ctx, az, []string{a, b}); err != nil {
+		t.Fatal(err)
+	}
+	for _, blob := range []string{"agent/7/a.zip", "agent/7/b.txt"} {
+		if _, ok := m.blobs[blob]; !ok {
+			t.Errorf("%s was not uploaded", blob)
+		}
+	}
+	if err := runUpload(ctx, az, []string{"-state", "s", a, b}); err == nil {
+		t.Error("-state with several files was accepted")
+	}
+
+	dest := t.TempDir()
+	if err := runDownload(ctx, az, []string{"a.zip", "b.txt", dest}); err != nil {
+		t.Fatal(err)
+	}
+	if readFile(t, filepath.Join(dest, "a.zip")) != "a" || readFile(t, filepath.Join(dest, "b.txt")) != "b" {
+		t.Error("downloads were not stored under their file names")
+	}
+	single := filepath.Join(dest, "single.zip")
+	if err := runDownload(ctx, az, []string{"a.zip", single}); err != nil || readFile(t, single) != "a" {
+		t.Errorf("downloading a single file: %v", err)
+	}
+	if err := os.Remove(single); err != nil {
+		t.Fatal(err)
+	}
+
+	out := captureStdout(t, func() { err = runDiff(ctx, az, []string{"-exit-code", dest}) })
+	if err != nil {
+		t.Errorf("diff against the template's prefix = %v:\n%s", err, out)
+	}
+}
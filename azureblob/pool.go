@@ -0,0 +1,229 @@
+package azureblob
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+const (
+	defaultMaxTransfers = 4
+	defaultMaxBlocks    = 16
+)
+
+// TransferPool bounds multi-file operations: at most MaxTransfers files are
+// transferred at once, and at most MaxBlocks block requests are in flight
+// across all of them. A pool may be shared by several clients by assigning it
+// to their Pool field. Block slots are handed out by the Priority of the
+// requests; see WithPriority.
+type TransferPool struct {
+	maxTransfers int
+	maxBlocks    int
+	// transfers holds a slot for each item of a Run in progress, so that
+	// concurrent Runs share MaxTransfers.
+	transfers chan struct{}
+
+	mu       sync.Mutex
+	inFlight int
+	// waiting holds a queue of waiting requests per priority, critical
+	// first.
+	waiting [3][]chan struct{}
+}
+
+// NewTransferPool returns a pool with the given limits. Values below one use
+// the defaults.
+func NewTransferPool(maxTransfers, maxBlocks int) *TransferPool {
+	if maxTransfers < 1 {
+		maxTransfers = defaultMaxTransfers
+	}
+	if maxBlocks < 1 {
+		maxBlocks = defaultMaxBlocks
+	}
+	return &TransferPool{
+		maxTransfers: maxTransfers,
+		maxBlocks:    maxBlocks,
+		transfers:    make(chan struct{}, maxTransfers),
+	}
+}
+
+// transferSlotKey marks the context of an item of a Run with its pool.
+type transferSlotKey struct{}
+
+// Run calls fn for each index in [0, n) using a fixed number of workers, so
+// large batches do not spawn a goroutine per item. Items of all the Runs of
+// a pool in progress share its MaxTransfers slots, except those of a Run
+// nested in an item of another, such as the chunks of a download in a
+// manifest, which are part of that item's transfer. It returns the error of
+// each call by index. Items not yet started when ctx is done fail with the
+// context's error. A nil pool runs the default number of workers.
+func (p *TransferPool) Run(ctx context.Context, n int, fn func(ctx context.Context, i int) error) []error {
+	errs := make([]error, n)
+	work := make(chan int)
+	workers := defaultMaxTransfers
+	var slots chan struct{}
+	if p != nil {
+		workers = p.maxTransfers
+		if ctx.Value(transferSlotKey{}) != p {
+			slots = p.transfers
+			ctx = context.WithValue(ctx, transferSlotKey{}, p)
+		}
+	}
+	if workers > n {
+		workers = n
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				if slots != nil {
+					select {
+					case slots <- struct{}{}:
+					case <-ctx.Done():
+						errs[i] = ctx.Err()
+						continue
+					}
+				}
+				if err := ctx.Err(); err != nil {
+					errs[i] = err
+				} else {
+					errs[i] = fn(ctx, i)
+				}
+				if slots != nil {
+					<-slots
+				}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	return errs
+}
+
+// queue returns the index in p.waiting of requests of priority prio.
+func queue(prio Priority) int {
+	switch {
+	case prio > PriorityNormal:
+		return 0
+	case prio < PriorityNormal:
+		return 2
+	}
+	return 1
+}
+
+// limit returns how many block slots may be in flight for requests of the
+// queue q to start. Background requests leave a quarter of the slots to the
+// others, so that urgent requests rarely wait for them.
+func (p *TransferPool) limit(q int) int {
+	if q == 2 {
+		return p.maxBlocks - p.maxBlocks/4
+	}
+	return p.maxBlocks
+}
+
+// acquireBlock blocks until a block slot is free for a request of the
+// priority of ctx, or ctx is done. Requests of a priority start in the order
+// they asked, after those of higher priorities.
+func (p *TransferPool) acquireBlock(ctx context.Context) error {
+	q := queue(priorityFrom(ctx))
+	p.mu.Lock()
+	if p.inFlight < p.limit(q) && p.waitingAhead(q) == 0 {
+		p.inFlight++
+		p.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	p.waiting[q] = append(p.waiting[q], ready)
+	p.mu.Unlock()
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+	p.mu.Lock()
+	for i, ch := range p.waiting[q] {
+		if ch == ready {
+			p.waiting[q] = append(p.waiting[q][:i], p.waiting[q][i+1:]...)
+			p.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+	// The slot was granted as ctx was done; pass it on.
+	p.mu.Unlock()
+	p.releaseBlock()
+	return ctx.Err()
+}
+
+// waitingAhead returns the number of requests waiting in the queues up to
+// and including q. It is called with p.mu held.
+func (p *TransferPool) waitingAhead(q int) int {
+	n := 0
+	for _, w := range p.waiting[:q+1] {
+		n += len(w)
+	}
+	return n
+}
+
+func (p *TransferPool) releaseBlock() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inFlight--
+	for q := range p.waiting {
+		for len(p.waiting[q]) > 0 && p.inFlight < p.limit(q) {
+			close(p.waiting[q][0])
+			p.waiting[q] = p.waiting[q][1:]
+			p.inFlight++
+		}
+		if len(p.waiting[q]) > 0 {
+			// Lower priorities wait until these have started.
+			return
+		}
+	}
+}
+
+// pooledTransport holds a block slot of its pool for each request, from
+// sending it until the response body has been consumed or closed.
+type pooledTransport struct {
+	next policy.Transporter
+	pool *TransferPool
+}
+
+func (t *pooledTransport) Do(req *http.Request) (*http.Response, error) {
+	if err := t.pool.acquireBlock(req.Context()); err != nil {
+		return nil, err
+	}
+	resp, err := t.next.Do(req)
+	if err != nil {
+		t.pool.releaseBlock()
+		return resp, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: t.pool.releaseBlock}
+	return resp, nil
+}
+
+// releasingBody calls release once, at EOF, on a read error or on Close.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releasingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.once.Do(b.release)
+	}
+	return n, err
+}
+
+func (b *releasingBody) Close() error {
+	b.once.Do(b.release)
+	return b.ReadCloser.Close()
+}
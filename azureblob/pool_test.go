@@ -0,0 +1,244 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransferPoolRunBoundsWorkers(t *testing.T) {
+	p := NewTransferPool(3, 1)
+	var running, peak int32
+	errs := p.Run(context.Background(), 20, func(ctx context.Context, i int) error {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			old := atomic.LoadInt32(&peak)
+			if n <= old || atomic.CompareAndSwapInt32(&peak, old, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		if i%4 == 0 {
+			return fmt.Errorf("item %d", i)
+		}
+		return nil
+	})
+	if peak != 3 {
+		t.Errorf("peak concurrency = %d, want 3", peak)
+	}
+	if len(errs) != 20 {
+		t.Fatalf("got %d errors, want one per item", len(errs))
+	}
+	for i, err := range errs {
+		if i%4 == 0 {
+			if err == nil || err.Error() != fmt.Sprintf("item %d", i) {
+				t.Errorf("errs[%d] = %v, want the error of item %d", i, err, i)
+			}
+		} else if err != nil {
+			t.Errorf("errs[%d] = %v, want nil", i, err)
+		}
+	}
+}
+
+func TestTransferPoolRunSharesSlots(t *testing.T) {
+	p := NewTransferPool(3, 1)
+	var running, peak int32
+	item := func(ctx context.Context, i int) error {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			old := atomic.LoadInt32(&peak)
+			if n <= old || atomic.CompareAndSwapInt32(&peak, old, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil
+	}
+	var wg sync.WaitGroup
+	for r := 0; r < 2; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Run(context.Background(), 10, item)
+		}()
+	}
+	wg.Wait()
+	if peak != 3 {
+		t.Errorf("peak concurrency of two Runs = %d, want 3", peak)
+	}
+
+	// A Run nested in an item takes no slots, or it would wait for its
+	// own.
+	done := make(chan []error, 1)
+	go func() {
+		done <- p.Run(context.Background(), 3, func(ctx context.Context, i int) error {
+			for _, err := range p.Run(ctx, 2, item) {
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}()
+	select {
+	case errs := <-done:
+		for _, err := range errs {
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a nested Run deadlocked")
+	}
+}
+
+func TestTransferPoolRunCancelled(t *testing.T) {
+	p := NewTransferPool(1, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var started int32
+	errs := p.Run(ctx, 5, func(ctx context.Context, i int) error {
+		atomic.AddInt32(&started, 1)
+		cancel()
+		return nil
+	})
+	if started != 1 {
+		t.Errorf("%d items started, want 1", started)
+	}
+	if errs[0] != nil {
+		t.Errorf("errs[0] = %v, want nil", errs[0])
+	}
+	for i, err := range errs[1:] {
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("errs[%d] = %v, want context.Canceled", i+1, err)
+		}
+	}
+}
+
+func TestTransferPoolRunNilPool(t *testing.T) {
+	var p *TransferPool
+	errs := p.Run(context.Background(), 2, func(ctx context.Context, i int) error { return nil })
+	if len(errs) != 2 || errs[0] != nil || errs[1] != nil {
+		t.Errorf("Run on a nil pool = %v, want two nil errors", errs)
+	}
+}
+
+func TestNewTransferPoolDefaults(t *testing.T) {
+	p := NewTransferPool(0, -1)
+	if p.maxTransfers != defaultMaxTransfers || p.maxBlocks != defaultMaxBlocks {
+		t.Errorf("limits = %d, %d; want %d, %d", p.maxTransfers, p.maxBlocks, defaultMaxTransfers, defaultMaxBlocks)
+	}
+}
+
+func TestReleasingBodyReleasesOnce(t *testing.T) {
+	tests := []struct {
+		name string
+		use  func(body io.ReadCloser)
+	}{
+		{"read to EOF then close", func(body io.ReadCloser) {
+			io.ReadAll(body)
+			body.Read(make([]byte, 1))
+			body.Close()
+		}},
+		{"close early", func(body io.ReadCloser) {
+			body.Read(make([]byte, 1))
+			body.Close()
+			body.Close()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			released := 0
+			body := &releasingBody{
+				ReadCloser: io.NopCloser(strings.NewReader("body")),
+				release:    func() { released++ },
+			}
+			tt.use(body)
+			if released != 1 {
+				t.Errorf("released %d times, want 1", released)
+			}
+		})
+	}
+}
+
+func TestPooledTransportHoldsBlockUntilBodyDone(t *testing.T) {
+	p := NewTransferPool(1, 1)
+	transport := &pooledTransport{
+		pool: p,
+		next: transporterFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("data"))}, nil
+		}),
+	}
+	req, err := http.NewRequest(http.MethodGet, "https://account.blob.core.windows.net/container/blob", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := transport.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := transport.Do(req.WithContext(ctx)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("second request with the only slot held = %v, want context.DeadlineExceeded", err)
+	}
+	resp.Body.Close()
+	if _, err := transport.Do(req); err != nil {
+		t.Errorf("request after the body was closed = %v, want nil", err)
+	}
+}
+
+func TestDownloadAll(t *testing.T) {
+	blobs := map[string]string{"/container/a.txt": "a", "/container/nested/b.txt": "b"}
+	var mu sync.Mutex
+	var inFlight, peak int
+	az := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := blobs[r.URL.Path]
+		if !ok {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mu.Lock()
+		inFlight++
+		if inFlight > peak {
+			peak = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		serveBlob(w, r, []byte(data))
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	az.Pool = NewTransferPool(2, 1)
+	dir := t.TempDir()
+
+	err := downloadAll(context.Background(), az, []string{"a.txt", "nested/b.txt", "missing", "../escape.txt"}, dir)
+	if err == nil || err.Error() != "2 of 4 downloads failed" {
+		t.Errorf("downloadAll = %v, want 2 of 4 failures", err)
+	}
+	for name, want := range map[string]string{"a.txt": "a", "nested/b.txt": "b"} {
+		got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escape.txt")); err == nil {
+		t.Error("a blob name with .. was written outside the directory")
+	}
+	if peak > 1 {
+		t.Errorf("%d block requests in flight, want at most the pool's 1", peak)
+	}
+}
@@ -0,0 +1,150 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// Priority ranks the requests of an operation against those of others
+// sharing a TransferPool. Block slots that free up go to waiting critical
+// requests first and background requests last, and background requests
+// never take the slots reserved for the others.
+type Priority int
+
+const (
+	PriorityBackground Priority = -1
+	PriorityNormal     Priority = 0
+	PriorityCritical   Priority = 1
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityBackground:
+		return "background"
+	case PriorityNormal:
+		return "normal"
+	case PriorityCritical:
+		return "critical"
+	}
+	return fmt.Sprintf("Priority(%d)", int(p))
+}
+
+// ParsePriority parses the name of a priority, as String returns it.
+func ParsePriority(s string) (Priority, error) {
+	for _, p := range []Priority{PriorityBackground, PriorityNormal, PriorityCritical} {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q; use critical, normal or background", s)
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a copy of ctx under which blob requests have
+// priority p.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// priorityFrom returns the priority of requests under ctx, PriorityNormal by
+// default.
+func priorityFrom(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
+
+// ErrRetryBudgetExhausted is returned, wrapped, by requests that failed
+// after their RetryBudget ran out, instead of being retried.
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// RetryBudget bounds the retries of every request made under the contexts
+// it is attached to with WithRetryBudget, however many operations these
+// run. Giving background work a small budget makes it give up when the
+// service or network is struggling, rather than compete with urgent work
+// by retrying. A budget may be shared by several operations at once.
+type RetryBudget struct {
+	left int64
+}
+
+// NewRetryBudget returns a budget allowing n retries.
+func NewRetryBudget(n int) *RetryBudget {
+	return &RetryBudget{left: int64(n)}
+}
+
+// Remaining returns the number of retries left.
+func (b *RetryBudget) Remaining() int {
+	if left := atomic.LoadInt64(&b.left); left > 0 {
+		return int(left)
+	}
+	return 0
+}
+
+// spend takes a retry from b, reporting whether there was one left.
+func (b *RetryBudget) spend() bool {
+	return atomic.AddInt64(&b.left, -1) >= 0
+}
+
+type retryBudgetKey struct{}
+
+// WithRetryBudget returns a copy of ctx under which retries of blob
+// requests are taken from b.
+func WithRetryBudget(ctx context.Context, b *RetryBudget) context.Context {
+	return context.WithValue(ctx, retryBudgetKey{}, b)
+}
+
+// retryBudgetFrom returns the retry budget of ctx, or nil.
+func retryBudgetFrom(ctx context.Context) *RetryBudget {
+	b, _ := ctx.Value(retryBudgetKey{}).(*RetryBudget)
+	return b
+}
+
+// requestTries counts the tries of one request against its budget.
+type requestTries struct {
+	budget *RetryBudget
+	tries  int32
+}
+
+type requestTriesKey struct{}
+
+// retryBudgetPolicy gives each request under a retry budget a count of its
+// tries, which budgetTransport charges to the budget. It runs once per
+// request, before the retry policy.
+type retryBudgetPolicy struct{}
+
+func (retryBudgetPolicy) Do(req *policy.Request) (*http.Response, error) {
+	ctx := req.Raw().Context()
+	b := retryBudgetFrom(ctx)
+	if b == nil {
+		return req.Next()
+	}
+	return req.Clone(context.WithValue(ctx, requestTriesKey{}, &requestTries{budget: b})).Next()
+}
+
+// budgetTransport fails the retries of requests whose budget has run out,
+// which the retry policy then gives up on.
+type budgetTransport struct {
+	next policy.Transporter
+}
+
+func (t budgetTransport) Do(req *http.Request) (*http.Response, error) {
+	if r, ok := req.Context().Value(requestTriesKey{}).(*requestTries); ok {
+		if atomic.AddInt32(&r.tries, 1) > 1 && !r.budget.spend() {
+			return nil, retryBudgetError{}
+		}
+	}
+	return t.next.Do(req)
+}
+
+// retryBudgetError is ErrRetryBudgetExhausted, marked as not retriable for
+// the retry policy.
+type retryBudgetError struct{}
+
+func (retryBudgetError) Error() string { return ErrRetryBudgetExhausted.Error() }
+func (retryBudgetError) Unwrap() error { return ErrRetryBudgetExhausted }
+func (retryBudgetError) NonRetriable() {}
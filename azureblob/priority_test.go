@@ -0,0 +1,128 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+func TestParsePriority(t *testing.T) {
+	for _, p := range []Priority{PriorityBackground, PriorityNormal, PriorityCritical} {
+		if got, err := ParsePriority(p.String()); err != nil || got != p {
+			t.Errorf("ParsePriority(%q) = %v, %v", p, got, err)
+		}
+	}
+	if _, err := ParsePriority("urgent"); err == nil {
+		t.Error("ParsePriority accepted urgent")
+	}
+	if p := priorityFrom(context.Background()); p != PriorityNormal {
+		t.Errorf("default priority = %v", p)
+	}
+}
+
+// acquireAsync acquires a block slot of p at prio in the background,
+// reporting on the channel returned once it has.
+func acquireAsync(p *TransferPool, prio Priority) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		if err := p.acquireBlock(WithPriority(context.Background(), prio)); err == nil {
+			close(done)
+		}
+	}()
+	return done
+}
+
+// waitForWaiters waits until n requests wait for a slot of p.
+func waitForWaiters(t *testing.T, p *TransferPool, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		p.mu.Lock()
+		waiting := p.waitingAhead(len(p.waiting) - 1)
+		p.mu.Unlock()
+		if waiting == n {
+			return
+		}
+	}
+	t.Fatalf("%d requests never waited", n)
+}
+
+func TestTransferPoolPriorities(t *testing.T) {
+	p := NewTransferPool(1, 4)
+	background := WithPriority(context.Background(), PriorityBackground)
+	for i := 0; i < 3; i++ {
+		if err := p.acquireBlock(background); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The last slot is reserved for the other priorities.
+	queued := acquireAsync(p, PriorityBackground)
+	waitForWaiters(t, p, 1)
+	if err := p.acquireBlock(WithPriority(context.Background(), PriorityCritical)); err != nil {
+		t.Fatal(err)
+	}
+
+	normal := acquireAsync(p, PriorityNormal)
+	waitForWaiters(t, p, 2)
+	critical := acquireAsync(p, PriorityCritical)
+	waitForWaiters(t, p, 3)
+	p.releaseBlock()
+	<-critical
+	select {
+	case <-normal:
+		t.Error("a normal request started before a critical one")
+	default:
+	}
+	p.releaseBlock()
+	<-normal
+	select {
+	case <-queued:
+		t.Error("a background request took a reserved slot")
+	default:
+	}
+	p.releaseBlock()
+	p.releaseBlock()
+	<-queued
+
+	ctx, cancel := context.WithCancel(background)
+	cancel()
+	if err := p.acquireBlock(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled acquireBlock = %v", err)
+	}
+	waitForWaiters(t, p, 0)
+}
+
+func TestRetryBudget(t *testing.T) {
+	m := newMemContainer()
+	m.put("blob", []byte("data"), nil)
+	var failures int32 = 2
+	az := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		m.ServeHTTP(w, r)
+	}))
+	az.ClientOptions.MetadataRetry = policy.RetryOptions{MaxRetries: 5, RetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond}
+	budget := NewRetryBudget(1)
+	ctx := WithRetryBudget(context.Background(), budget)
+
+	if _, err := az.Stat(ctx, "blob"); !errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Errorf("Stat past the budget = %v", err)
+	}
+	if budget.Remaining() != 0 {
+		t.Errorf("%d retries remain", budget.Remaining())
+	}
+	// Requests that need no retry still succeed.
+	if _, err := az.Stat(ctx, "blob"); err != nil {
+		t.Errorf("Stat without retries = %v", err)
+	}
+	atomic.StoreInt32(&failures, 2)
+	if _, err := az.Stat(context.Background(), "blob"); err != nil {
+		t.Errorf("Stat without a budget = %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// rateWindow is the period over which ProgressAggregator averages throughput.
+const rateWindow = 5 * time.Second
+
+// ProgressAggregator combines the progress of transfers made by any number of
+// AzureBlobClient instances, e.g. one per container, so a single UI can show
+// their total throughput. Share one aggregator by assigning it to the
+// Progress field of each client. It is safe for concurrent use.
+type ProgressAggregator struct {
+	mu          sync.Mutex
+	outstanding int
+	completed   int
+	transferred int64
+	total       int64
+	samples     []progressSample
+}
+
+type progressSample struct {
+	at          time.Time
+	transferred int64
+}
+
+// ProgressSnapshot is the combined state of all transfers at one moment.
+type ProgressSnapshot struct {
+	// Outstanding is the number of transfers in flight.
+	Outstanding int
+	// Completed is the number of transfers that have finished, successfully
+	// or not.
+	Completed int
+	// BytesTransferred and BytesTotal cover every transfer started so far.
+	BytesTransferred int64
+	BytesTotal       int64
+	// BytesPerSecond is the combined throughput over the last few seconds.
+	BytesPerSecond float64
+}
+
+func (s ProgressSnapshot) String() string {
+	return fmt.Sprintf("%d transfers in flight, %d done, %s/%s, %s/s",
+		s.Outstanding, s.Completed,
+		formatBytes(s.BytesTransferred), formatBytes(s.BytesTotal),
+		formatBytes(int64(s.BytesPerSecond)))
+}
+
+// NewProgressAggregator returns an empty aggregator.
+func NewProgressAggregator() *ProgressAggregator {
+	return &ProgressAggregator{}
+}
+
+// Snapshot returns the current combined progress.
+func (a *ProgressAggregator) Snapshot() ProgressSnapshot {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.snapshotLocked(time.Now())
+}
+
+func (a *ProgressAggregator) snapshotLocked(now time.Time) ProgressSnapshot {
+	a.sampleLocked(now)
+	s := ProgressSnapshot{
+		Outstanding:      a.outstanding,
+		Completed:        a.completed,
+		BytesTransferred: a.transferred,
+		BytesTotal:       a.total,
+	}
+	if n := len(a.samples); n > 1 {
+		first, last := a.samples[0], a.samples[n-1]
+		if elapsed := last.at.Sub(first.at).Seconds(); elapsed > 0 {
+			s.BytesPerSecond = float64(last.transferred-first.transferred) / elapsed
+		}
+	}
+	return s
+}
+
+// sampleLocked records the current byte count and drops samples that have
+// fallen out of the rate window.
+func (a *ProgressAggregator) sampleLocked(now time.Time) {
+	a.samples = append(a.samples, progressSample{at: now, transferred: a.transferred})
+	i := 0
+	for i < len(a.samples)-1 && now.Sub(a.samples[i].at) > rateWindow {
+		i++
+	}
+	a.samples = a.samples[i:]
+}
+
+// begin registers a transfer of size bytes. It is safe to call on a nil
+// aggregator, in which case the returned tracker does nothing.
+func (a *ProgressAggregator) begin(size int64) *transferTracker {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.outstanding++
+	a.total += size
+	return &transferTracker{agg: a}
+}
+
+// progressContextKey is the context key of the aggregator set by
+// WithProgress.
+type progressContextKey struct{}
+
+// WithProgress returns a copy of ctx whose transfers also report their
+// progress to a, besides the Progress of the client making them. A caller
+// sharing a client with others can then follow its own transfers.
+func WithProgress(ctx context.Context, a *ProgressAggregator) context.Context {
+	return context.WithValue(ctx, progressContextKey{}, a)
+}
+
+// beginTransfer registers a transfer of size bytes with c.Progress and with
+// the aggregator of ctx, if any.
+func (c *AzureBlobClient) beginTransfer(ctx context.Context, size int64) *transferTracker {
+	t := c.Progress.begin(size)
+	a, _ := ctx.Value(progressContextKey{}).(*ProgressAggregator)
+	if a == nil || a == c.Progress {
+		return t
+	}
+	extra := a.begin(size)
+	if t == nil {
+		return extra
+	}
+	t.next = extra
+	return t
+}
+
+// transferTracker feeds the progress of one transfer into its aggregator,
+// and into those of the trackers chained after it.
+type transferTracker struct {
+	agg  *ProgressAggregator
+	last int64
+	done bool
+	next *transferTracker
+}
+
+// wrap returns a progress callback that reports to the tracker before
+// calling fn.
+func (t *transferTracker) wrap(fn func(bytesTransferred int64)) func(bytesTransferred int64) {
+	if t == nil {
+		return fn
+	}
+	return func(bytesTransferred int64) {
+		t.update(bytesTransferred)
+		fn(bytesTransferred)
+	}
+}
+
+func (t *transferTracker) update(bytesTransferred int64) {
+	for ; t != nil; t = t.next {
+		a := t.agg
+		a.mu.Lock()
+		a.transferred += bytesTransferred - t.last
+		t.last = bytesTransferred
+		a.sampleLocked(time.Now())
+		a.mu.Unlock()
+	}
+}
+
+// finish marks the transfer as no longer outstanding.
+func (t *transferTracker) finish() {
+	for ; t != nil; t = t.next {
+		if t.done {
+			continue
+		}
+		t.done = true
+		a := t.agg
+		a.mu.Lock()
+		a.outstanding--
+		a.completed++
+		a.mu.Unlock()
+	}
+}
+
+// shareProgress returns c.Progress, first creating it if unset, and shares it
+// with the fallback clients that have none of their own so the combined
+// progress of a batch covers every source.
+func (c *AzureBlobClient) shareProgress() *ProgressAggregator {
+	if c.Progress == nil {
+		c.Progress = NewProgressAggregator()
+	}
+	for fb := c.Fallback; fb != nil; fb = fb.Fallback {
+		if fb.Progress == nil {
+			fb.Progress = c.Progress
+		}
+	}
+	return c.Progress
+}
+
+// reportProgress prints a snapshot of a to w every interval until the
+// returned function is called, which prints a final snapshot and waits for the
+// reporter to exit.
+func reportProgress(w io.Writer, msgs Messages, a *ProgressAggregator, interval time.Duration) (stop func()) {
+	report := func() {
+		s := a.Snapshot()
+		fmt.Fprintln(w, msgs.format(MsgProgress, s.Outstanding, s.Completed,
+			formatBytes(s.BytesTransferred), formatBytes(s.BytesTotal), formatBytes(int64(s.BytesPerSecond))))
+	}
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				report()
+			case <-done:
+				report()
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// progressReader reports the bytes read through it to a tracker. Uploads
+// reading their blocks through it into buffers are reported as blocks are
+// queued rather than as they are sent.
+type progressReader struct {
+	r       io.Reader
+	tracker *transferTracker
+	n       int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.n += int64(n)
+	p.tracker.update(p.n)
+	return n, err
+}
@@ -0,0 +1,142 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProgressAggregatorConcurrentClients(t *testing.T) {
+	agg := NewProgressAggregator()
+	clients := make([]*AzureBlobClient, 4)
+	for i := range clients {
+		clients[i] = &AzureBlobClient{Progress: agg}
+	}
+	const transfers, size = 8, 1000
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		for i := 0; i < transfers; i++ {
+			wg.Add(1)
+			go func(c *AzureBlobClient) {
+				defer wg.Done()
+				tracker := c.Progress.begin(size)
+				defer tracker.finish()
+				progress := tracker.wrap(func(int64) {})
+				for n := int64(100); n <= size; n += 100 {
+					progress(n)
+				}
+				_ = agg.Snapshot()
+			}(c)
+		}
+	}
+	wg.Wait()
+	s := agg.Snapshot()
+	want := int64(len(clients) * transfers * size)
+	if s.Outstanding != 0 || s.Completed != len(clients)*transfers {
+		t.Errorf("outstanding %d, completed %d; want 0, %d", s.Outstanding, s.Completed, len(clients)*transfers)
+	}
+	if s.BytesTransferred != want || s.BytesTotal != want {
+		t.Errorf("transferred %d of %d, want %d of %d", s.BytesTransferred, s.BytesTotal, want, want)
+	}
+}
+
+func TestWithProgress(t *testing.T) {
+	for _, shared := range []*ProgressAggregator{nil, NewProgressAggregator()} {
+		c := &AzureBlobClient{Progress: shared}
+		own := NewProgressAggregator()
+		tracker := c.beginTransfer(WithProgress(context.Background(), own), 100)
+		tracker.update(40)
+		tracker.finish()
+		tracker.finish()
+		for _, agg := range []*ProgressAggregator{shared, own} {
+			if agg == nil {
+				continue
+			}
+			if s := agg.Snapshot(); s.BytesTransferred != 40 || s.BytesTotal != 100 || s.Completed != 1 || s.Outstanding != 0 {
+				t.Errorf("shared=%v: got %+v", shared != nil, s)
+			}
+		}
+	}
+	c := &AzureBlobClient{Progress: NewProgressAggregator()}
+	c.beginTransfer(WithProgress(context.Background(), c.Progress), 10).finish()
+	if s := c.Progress.Snapshot(); s.BytesTotal != 10 || s.Completed != 1 {
+		t.Errorf("the client's own aggregator counted twice: %+v", s)
+	}
+}
+
+func TestProgressAggregatorOutstanding(t *testing.T) {
+	agg := NewProgressAggregator()
+	a, b := agg.begin(10), agg.begin(20)
+	if s := agg.Snapshot(); s.Outstanding != 2 || s.BytesTotal != 30 {
+		t.Errorf("got %+v, want 2 outstanding of 30 bytes", s)
+	}
+	a.finish()
+	a.finish()
+	if s := agg.Snapshot(); s.Outstanding != 1 || s.Completed != 1 {
+		t.Errorf("got %+v, want 1 outstanding and 1 completed", s)
+	}
+	b.finish()
+	if s := agg.Snapshot(); s.Outstanding != 0 || s.Completed != 2 {
+		t.Errorf("got %+v, want 0 outstanding and 2 completed", s)
+	}
+}
+
+func TestProgressAggregatorRateWindow(t *testing.T) {
+	agg := NewProgressAggregator()
+	t0 := time.Now()
+	agg.sampleLocked(t0)
+	agg.transferred = 1000
+	agg.sampleLocked(t0.Add(time.Second))
+	if s := agg.snapshotLocked(t0.Add(2 * time.Second)); s.BytesPerSecond != 500 {
+		t.Errorf("rate = %v, want 500", s.BytesPerSecond)
+	}
+	// Samples that have fallen out of the window no longer count: only the
+	// one taken at t0+2s, with 1000 bytes, remains.
+	agg.transferred = 4000
+	now := t0.Add(2*time.Second + rateWindow - 500*time.Millisecond)
+	want := 3000 / (rateWindow - 500*time.Millisecond).Seconds()
+	if s := agg.snapshotLocked(now); s.BytesPerSecond != want {
+		t.Errorf("rate = %v, want %v", s.BytesPerSecond, want)
+	}
+	// With nothing left in the window there is no rate.
+	if s := agg.snapshotLocked(t0.Add(10 * rateWindow)); s.BytesPerSecond != 0 {
+		t.Errorf("rate = %v, want 0", s.BytesPerSecond)
+	}
+}
+
+func TestProgressAggregatorNil(t *testing.T) {
+	var agg *ProgressAggregator
+	tracker := agg.begin(100)
+	if tracker != nil {
+		t.Fatalf("nil aggregator returned tracker %v", tracker)
+	}
+	var got int64
+	tracker.wrap(func(n int64) { got = n })(42)
+	tracker.finish()
+	if got != 42 {
+		t.Errorf("wrapped callback got %d, want 42", got)
+	}
+}
+
+func TestReportProgress(t *testing.T) {
+	agg := NewProgressAggregator()
+	tracker := agg.begin(2048)
+	tracker.update(1024)
+	var buf bytes.Buffer
+	stop := reportProgress(&buf, nil, agg, time.Hour)
+	tracker.finish()
+	stop()
+	if got := buf.String(); !strings.HasPrefix(got, "progress: 0 transfers in flight, 1 done, ") {
+		t.Errorf("got %q", got)
+	}
+
+	buf.Reset()
+	msgs := Messages{MsgProgress: "%[3]s of %[4]s (%[2]d finished)"}
+	reportProgress(&buf, msgs, agg, time.Hour)()
+	if got, want := buf.String(), "1.0 KiB of 2.0 KiB (1 finished)\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
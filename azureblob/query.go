@@ -0,0 +1,265 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	azruntime "github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+)
+
+// queryVersion is the service version of blob queries, which the SDK does
+// not expose.
+const queryVersion = "2020-10-02"
+
+// Formats of the blobs a query reads and of the rows it returns.
+const (
+	QueryCSV  = "csv"
+	QueryJSON = "json"
+)
+
+// QueryFormat describes the records of a blob or of a query's results.
+type QueryFormat struct {
+	// Type is QueryCSV, for delimited text, or QueryJSON, for lines of
+	// JSON objects.
+	Type string
+	// Separator separates the fields of delimited text. Zero means a
+	// comma.
+	Separator rune
+	// HasHeaders says the first line of delimited text names its fields,
+	// so the query can refer to them by name. In the results it asks for
+	// a line of the names of the selected fields.
+	HasHeaders bool
+}
+
+// QueryOptions configures Query.
+type QueryOptions struct {
+	// Input is the format of the blob. Its Type defaults to QueryJSON for
+	// blobs ending in .json, .jsonl or .ndjson, and to QueryCSV otherwise.
+	Input QueryFormat
+	// Output is the format of the results. Its Type defaults to that of
+	// Input.
+	Output QueryFormat
+}
+
+// QueryStats are the totals a query reports when it ends.
+type QueryStats struct {
+	// BytesScanned is the size of the blob the query read.
+	BytesScanned int64
+}
+
+// queryRequest is the body of a blob query.
+type queryRequest struct {
+	XMLName    xml.Name    `xml:"QueryRequest"`
+	QueryType  string      `xml:"QueryType"`
+	Expression string      `xml:"Expression"`
+	Input      queryFormat `xml:"InputSerialization>Format"`
+	Output     queryFormat `xml:"OutputSerialization>Format"`
+}
+
+type queryFormat struct {
+	Type      string              `xml:"Type"`
+	Delimited *queryDelimitedText `xml:"DelimitedTextConfiguration,omitempty"`
+	JSON      *queryJSONText      `xml:"JsonTextConfiguration,omitempty"`
+}
+
+type queryDelimitedText struct {
+	ColumnSeparator string `xml:"ColumnSeparator"`
+	FieldQuote      string `xml:"FieldQuote"`
+	RecordSeparator string `xml:"RecordSeparator"`
+	EscapeChar      string `xml:"EscapeChar"`
+	HasHeaders      bool   `xml:"HasHeaders"`
+}
+
+type queryJSONText struct {
+	RecordSeparator string `xml:"RecordSeparator"`
+}
+
+// queryFormatFor returns f in the form of the request.
+func queryFormatFor(f QueryFormat) (queryFormat, error) {
+	switch f.Type {
+	case QueryCSV:
+		sep := f.Separator
+		if sep == 0 {
+			sep = ','
+		}
+		return queryFormat{Type: "delimited", Delimited: &queryDelimitedText{
+			ColumnSeparator: string(sep),
+			FieldQuote:      `"`,
+			RecordSeparator: "\n",
+			HasHeaders:      f.HasHeaders,
+		}}, nil
+	case QueryJSON:
+		return queryFormat{Type: "json", JSON: &queryJSONText{RecordSeparator: "\n"}}, nil
+	}
+	return queryFormat{}, fmt.Errorf("unknown query format %q; use csv or json", f.Type)
+}
+
+// defaultQueryInput returns the format blob is assumed to be in.
+func defaultQueryInput(blob string) string {
+	switch strings.ToLower(path.Ext(blob)) {
+	case ".json", ".jsonl", ".ndjson":
+		return QueryJSON
+	}
+	return QueryCSV
+}
+
+// Query runs the SQL expression, such as
+// "SELECT * FROM BlobStorage WHERE level = 'error'", against the CSV or JSON
+// blob on the service and writes the matching records to w as they arrive,
+// so that only they cross the network. Errors in individual records are
+// logged and skipped; a fatal error ends the query. Client-side encrypted
+// or compressed blobs cannot be queried.
+func (c *AzureBlobClient) Query(ctx context.Context, blob, expression string, opts QueryOptions, w io.Writer) (*QueryStats, error) {
+	props, err := c.Stat(ctx, blob)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, ok := findEncryptionData(props.Metadata); ok || c.clientOptions().downloadCompression(props) != "" {
+		return nil, fmt.Errorf("query %q: blob is client-side encrypted or compressed", blob)
+	}
+	if opts.Input.Type == "" {
+		opts.Input.Type = defaultQueryInput(blob)
+	}
+	if opts.Output.Type == "" {
+		opts.Output.Type = opts.Input.Type
+	}
+	if opts.Output.Separator == 0 {
+		opts.Output.Separator = opts.Input.Separator
+	}
+	input, err := queryFormatFor(opts.Input)
+	if err != nil {
+		return nil, err
+	}
+	output, err := queryFormatFor(opts.Output)
+	if err != nil {
+		return nil, err
+	}
+	body, err := xml.Marshal(queryRequest{QueryType: "SQL", Expression: expression, Input: input, Output: output})
+	if err != nil {
+		return nil, err
+	}
+	var stats *QueryStats
+	err = c.withRebuild(ctx, "query", blob, func() error {
+		var err error
+		stats, err = c.query(ctx, blob, body, w)
+		return err
+	})
+	return stats, err
+}
+
+func (c *AzureBlobClient) query(ctx context.Context, blob string, body []byte, w io.Writer) (*QueryStats, error) {
+	if err := c.init(ctx); err != nil {
+		return nil, err
+	}
+	pl, err := c.metadataPipeline()
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(c.containerClient.NewBlobClient(blob).URL())
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = "comp=query"
+	req, err := azruntime.NewRequest(ctx, http.MethodPost, u.String())
+	if err != nil {
+		return nil, err
+	}
+	req.Raw().Header.Set("x-ms-version", queryVersion)
+	if err := req.SetBody(streaming.NopCloser(bytes.NewReader(body)), "application/xml"); err != nil {
+		return nil, err
+	}
+	req.SkipBodyDownload()
+	resp, err := pl.Do(req)
+	if err == nil && !azruntime.HasStatusCode(resp, http.StatusOK, http.StatusAccepted) {
+		err = azruntime.NewResponseError(errors.New(http.StatusText(resp.StatusCode)), resp)
+	}
+	if err != nil {
+		return nil, newBlobError("query", blob, err)
+	}
+	defer resp.Body.Close()
+	return c.readQueryResults(blob, resp.Body, w)
+}
+
+// readQueryResults writes the records of the Avro stream r of a query of
+// blob to w, and returns the totals its end record reports.
+func (c *AzureBlobClient) readQueryResults(blob string, r io.Reader, w io.Writer) (*QueryStats, error) {
+	a, err := newAvroReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("query %q: %w", blob, err)
+	}
+	stats := &QueryStats{}
+	for {
+		rec, err := a.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("query %q: results ended early", blob)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("query %q: %w", blob, err)
+		}
+		switch rec.Name {
+		case "resultData":
+			data, _ := rec.Fields["data"].([]byte)
+			if _, err := w.Write(data); err != nil {
+				return nil, err
+			}
+		case "progress":
+			stats.BytesScanned, _ = rec.Fields["bytesScanned"].(int64)
+		case "error":
+			name, _ := rec.Fields["name"].([]byte)
+			desc, _ := rec.Fields["description"].([]byte)
+			pos, _ := rec.Fields["position"].(int64)
+			if fatal, _ := rec.Fields["fatal"].(bool); fatal {
+				return nil, fmt.Errorf("query %q: %s: %s at byte %d", blob, name, desc, pos)
+			}
+			log.Print(c.Messages.format(MsgQueryRecordError, blob, pos, fmt.Sprintf("%s: %s", name, desc)))
+		case "end":
+			stats.BytesScanned, _ = rec.Fields["totalBytes"].(int64)
+			return stats, nil
+		}
+	}
+}
+
+func runQuery(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	format := fs.String("format", "", "format of the blob, csv or json (default: json for .json, .jsonl and .ndjson blobs, csv otherwise)")
+	outputFormat := fs.String("output-format", "", "format of the matching records, csv or json (default: that of the blob)")
+	headers := fs.Bool("headers", false, "the first line of the CSV blob names its columns; the output starts with one too")
+	separator := fs.String("separator", ",", "field `separator` of the CSV blob")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: query [flags] <blob> <expression>\n\nThe expression is SQL against the table BlobStorage, e.g.\n  query logs/app.csv \"SELECT _1, _3 FROM BlobStorage WHERE _2 = 'error'\"\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("query takes a blob and an expression")
+	}
+	if len([]rune(*separator)) != 1 {
+		return fmt.Errorf("-separator must be a single character, got %q", *separator)
+	}
+	az, blob, err := az.resolveRemote(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	sep := []rune(*separator)[0]
+	opts := QueryOptions{
+		Input:  QueryFormat{Type: *format, Separator: sep, HasHeaders: *headers},
+		Output: QueryFormat{Type: *outputFormat, Separator: sep, HasHeaders: *headers},
+	}
+	_, err = az.Query(ctx, blob, fs.Arg(1), opts, os.Stdout)
+	return err
+}
@@ -0,0 +1,85 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+// queryServer serves blob properties for any blob and answers queries with
+// the result container, recording the request.
+func queryServer(t *testing.T, result []byte, got *queryRequest) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			serveBlob(w, r, []byte("a,1\nb,2\n"))
+			return
+		}
+		if r.Method != http.MethodPost || r.URL.Query().Get("comp") != "query" {
+			t.Errorf("unexpected %s %s", r.Method, r.URL)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := xml.Unmarshal(body, got); err != nil {
+			t.Errorf("query body %s: %v", body, err)
+		}
+		w.Write(result)
+	})
+}
+
+func TestQuery(t *testing.T) {
+	rows := append(avroLong(0), avroBytes("b,2\n")...)
+	skipped := append(append(append(append(avroLong(1), 0), avroBytes("InvalidRow")...), avroBytes("too few columns")...), avroLong(4)...)
+	progress := append(append(avroLong(2), avroLong(8)...), avroLong(8)...)
+	end := append(avroLong(3), avroLong(8)...)
+	var req queryRequest
+	az := newTestClient(t, queryServer(t, avroContainer(querySchema, [][]byte{rows, skipped}, [][]byte{progress, end}), &req))
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	var out bytes.Buffer
+	opts := QueryOptions{Input: QueryFormat{Separator: ';', HasHeaders: true}, Output: QueryFormat{Type: QueryJSON}}
+	stats, err := az.Query(context.Background(), "logs/app.csv", "SELECT * FROM BlobStorage WHERE _1 = 'b'", opts, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "b,2\n" || stats.BytesScanned != 8 {
+		t.Errorf("query wrote %q, scanned %d", out.String(), stats.BytesScanned)
+	}
+	if !strings.Contains(logs.String(), "skipped a record at byte 4: InvalidRow: too few columns") {
+		t.Errorf("logged %q", logs.String())
+	}
+	if req.QueryType != "SQL" || req.Expression != "SELECT * FROM BlobStorage WHERE _1 = 'b'" {
+		t.Errorf("sent query %+v", req)
+	}
+	if in := req.Input; in.Type != "delimited" || in.Delimited == nil || in.Delimited.ColumnSeparator != ";" || !in.Delimited.HasHeaders {
+		t.Errorf("input format %+v", in)
+	}
+	if o := req.Output; o.Type != "json" || o.JSON == nil || o.Delimited != nil {
+		t.Errorf("output format %+v", o)
+	}
+}
+
+func TestQueryFatalError(t *testing.T) {
+	fatal := append(append(append(append(avroLong(1), 1), avroBytes("ParseError")...), avroBytes("unexpected token")...), avroLong(0)...)
+	var req queryRequest
+	az := newTestClient(t, queryServer(t, avroContainer(querySchema, [][]byte{fatal}), &req))
+	_, err := az.Query(context.Background(), "events.ndjson", "SELECT", QueryOptions{}, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "ParseError: unexpected token") {
+		t.Errorf("fatal error returned %v", err)
+	}
+	if req.Input.Type != "json" || req.Output.Type != "json" {
+		t.Errorf("formats of an .ndjson blob: %+v, %+v", req.Input, req.Output)
+	}
+	// A stream without its end record was cut short.
+	rows := append(avroLong(0), avroBytes("{}\n")...)
+	az = newTestClient(t, queryServer(t, avroContainer(querySchema, [][]byte{rows}), &req))
+	if _, err := az.Query(context.Background(), "events.json", "SELECT", QueryOptions{}, io.Discard); err == nil {
+		t.Error("query without an end record succeeded")
+	}
+}
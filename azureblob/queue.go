@@ -0,0 +1,418 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	azruntime "github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+)
+
+// queueVersion is the Queue service version of the queue requests, which
+// the SDK has no client for.
+const queueVersion = "2020-10-02"
+
+const (
+	// defaultQueueInterval is how long WatchQueue waits after finding its
+	// queue empty.
+	defaultQueueInterval = 5 * time.Second
+	// defaultQueueVisibility is how long a received message stays hidden
+	// from other receivers, and so how soon a failed download is retried.
+	defaultQueueVisibility = 5 * time.Minute
+	// defaultQueueMaxDequeue is how often a message is received before it
+	// is moved to the poison queue, as Azure Functions does.
+	defaultQueueMaxDequeue = 5
+	// queueBatch is the number of messages received at once, the most the
+	// service allows.
+	queueBatch = 32
+)
+
+// blobCreatedEvent is the type of the events Event Grid sends for new and
+// overwritten blobs.
+const blobCreatedEvent = "Microsoft.Storage.BlobCreated"
+
+// QueueWatchOptions configures WatchQueue.
+type QueueWatchOptions struct {
+	// Interval is the time to wait after finding the queue empty. Zero
+	// means 5 seconds.
+	Interval time.Duration
+	// VisibilityTimeout is how long a received message is hidden from
+	// other receivers. A message whose download fails is received again
+	// after it. Zero means 5 minutes.
+	VisibilityTimeout time.Duration
+	// MaxDequeue is how many times a message may be received before it is
+	// moved to the poison queue instead of being handled again. Zero
+	// means 5.
+	MaxDequeue int
+	// PoisonQueue is the queue that messages which cannot be handled are
+	// moved to. It defaults to the name of the queue with "-poison"
+	// appended, and must exist.
+	PoisonQueue string
+	// StatePath, if set, is a file recording the ETag of every blob
+	// downloaded, so that events delivered again, even across restarts,
+	// do not download a blob again.
+	StatePath string
+	// Once handles the messages until the queue is empty, then returns,
+	// instead of waiting for more until the context is done.
+	Once bool
+	// OnDownload, if set, is called with each blob downloaded and the file
+	// it was written to, as for Watch. A message whose download or
+	// OnDownload fails is received again.
+	OnDownload func(blob, path string) error
+}
+
+// queueMessage is a message received from a storage queue.
+type queueMessage struct {
+	ID           string `xml:"MessageId"`
+	PopReceipt   string `xml:"PopReceipt"`
+	DequeueCount int    `xml:"DequeueCount"`
+	Text         string `xml:"MessageText"`
+}
+
+// blobEvent is a blob event as Event Grid delivers it to a storage queue,
+// in the Event Grid schema, which names its type eventType, or the
+// CloudEvents schema, which names it type.
+type blobEvent struct {
+	EventType string `json:"eventType"`
+	Type      string `json:"type"`
+	Data      struct {
+		URL string `json:"url"`
+		// ETag is the blob's ETag, without the quotes of the header.
+		ETag string `json:"eTag"`
+	} `json:"data"`
+}
+
+// parseBlobEvent decodes the text of a queue message holding an event.
+// Event Grid encodes events in base64; plain JSON is accepted too.
+func parseBlobEvent(text string) (*blobEvent, error) {
+	b := []byte(text)
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text)); err == nil {
+		b = decoded
+	}
+	e := &blobEvent{}
+	if err := json.Unmarshal(b, e); err != nil {
+		return nil, fmt.Errorf("not a blob event: %w", err)
+	}
+	if e.EventType == "" {
+		e.EventType = e.Type
+	}
+	if e.EventType == "" {
+		return nil, errors.New("not a blob event: no event type")
+	}
+	return e, nil
+}
+
+// eventBlob returns the name of the blob of c's container that an event's
+// URL refers to, and false if it refers to another account or container.
+func (c *AzureBlobClient) eventBlob(blobURL string) (string, bool) {
+	u, err := url.Parse(blobURL)
+	if err != nil {
+		return "", false
+	}
+	account := strings.SplitN(u.Host, ".", 2)[0]
+	parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+	if account != c.StorageAccount || len(parts) != 2 || parts[0] != c.ContainerName || parts[1] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// queueURL returns the URL of queue in c's storage account, followed by
+// the path elements rest.
+func (c *AzureBlobClient) queueURL(queue string, rest ...string) string {
+	u := fmt.Sprintf("https://%s.queue.core.windows.net/%s", c.StorageAccount, url.PathEscape(queue))
+	for _, e := range rest {
+		u += "/" + url.PathEscape(e)
+	}
+	return u
+}
+
+// queueRequest sends a request to a queue through the metadata pipeline,
+// with the query q and an XML body if body is not nil, and returns the
+// response if it has one of the statuses ok.
+func (c *AzureBlobClient) queueRequest(ctx context.Context, op, queue, method, u string, q url.Values, body []byte, ok ...int) (*http.Response, error) {
+	if err := c.init(ctx); err != nil {
+		return nil, err
+	}
+	pl, err := c.metadataPipeline()
+	if err != nil {
+		return nil, err
+	}
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := azruntime.NewRequest(ctx, method, u)
+	if err != nil {
+		return nil, err
+	}
+	req.Raw().Header.Set("x-ms-version", queueVersion)
+	if body != nil {
+		if err := req.SetBody(streaming.NopCloser(bytes.NewReader(body)), "application/xml"); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := pl.Do(req)
+	if err == nil && !azruntime.HasStatusCode(resp, ok...) {
+		err = azruntime.NewResponseError(errors.New(http.StatusText(resp.StatusCode)), resp)
+	}
+	if err != nil {
+		return nil, newBlobError(op, queue, err)
+	}
+	return resp, nil
+}
+
+// receiveMessages receives up to queueBatch messages from queue, hiding
+// them from other receivers for visibility.
+func (c *AzureBlobClient) receiveMessages(ctx context.Context, queue string, visibility time.Duration) ([]queueMessage, error) {
+	ctx, cancel := c.metadataContext(ctx)
+	defer cancel()
+	q := url.Values{
+		"numofmessages":     {strconv.Itoa(queueBatch)},
+		"visibilitytimeout": {strconv.Itoa(int(visibility / time.Second))},
+	}
+	resp, err := c.queueRequest(ctx, "receive messages", queue, http.MethodGet, c.queueURL(queue, "messages"), q, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var list struct {
+		Messages []queueMessage `xml:"QueueMessage"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&list); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("receive messages from %s: %w", queue, err)
+	}
+	return list.Messages, nil
+}
+
+// deleteMessage deletes a received message from queue.
+func (c *AzureBlobClient) deleteMessage(ctx context.Context, queue string, m queueMessage) error {
+	ctx, cancel := c.metadataContext(ctx)
+	defer cancel()
+	resp, err := c.queueRequest(ctx, "delete message", queue, http.MethodDelete, c.queueURL(queue, "messages", m.ID), url.Values{"popreceipt": {m.PopReceipt}}, nil, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// sendMessage adds a message with text to queue.
+func (c *AzureBlobClient) sendMessage(ctx context.Context, queue, text string) error {
+	ctx, cancel := c.metadataContext(ctx)
+	defer cancel()
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"QueueMessage"`
+		Text    string   `xml:"MessageText"`
+	}{Text: text})
+	if err != nil {
+		return err
+	}
+	resp, err := c.queueRequest(ctx, "send message", queue, http.MethodPost, c.queueURL(queue, "messages"), nil, body, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// WatchQueue receives the messages of a storage queue subscribed through
+// Event Grid to the Blob Created events of c's account, and downloads each
+// blob of c's container under prefix that an event names into dir, under
+// its name relative to prefix, until ctx is done. Events are delivered at
+// least once, so a blob whose ETag matches the one last downloaded is not
+// downloaded again. Messages are deleted once handled; other events, and
+// events for other containers or prefixes, are handled by deleting them.
+// A message whose download fails is received again after the visibility
+// timeout, and one that cannot be parsed or has been received too often
+// is moved to the poison queue.
+func (c *AzureBlobClient) WatchQueue(ctx context.Context, queue, prefix, dir string, opts QueueWatchOptions) error {
+	if err := checkDir(dir); err != nil {
+		return err
+	}
+	state := WatchState{}
+	if opts.StatePath != "" {
+		var err error
+		if state, err = loadWatchState(opts.StatePath); err != nil {
+			return err
+		}
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultQueueInterval
+	}
+	if opts.VisibilityTimeout <= 0 {
+		opts.VisibilityTimeout = defaultQueueVisibility
+	}
+	if opts.MaxDequeue <= 0 {
+		opts.MaxDequeue = defaultQueueMaxDequeue
+	}
+	if opts.PoisonQueue == "" {
+		opts.PoisonQueue = queue + "-poison"
+	}
+	for {
+		msgs, err := c.receiveMessages(ctx, queue, opts.VisibilityTimeout)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(msgs) > 0 {
+			if err := c.handleMessages(ctx, queue, prefix, dir, msgs, state, opts); err != nil {
+				return err
+			}
+			continue
+		}
+		if opts.Once {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(opts.Interval):
+		}
+	}
+}
+
+// handleMessages handles a batch of messages from queue, downloading the
+// blobs they name concurrently, bounded by c.Pool, and updates state and
+// its file. Only failing to delete or move a message is an error.
+func (c *AzureBlobClient) handleMessages(ctx context.Context, queue, prefix, dir string, msgs []queueMessage, state WatchState, opts QueueWatchOptions) error {
+	var (
+		done    []queueMessage
+		blobs   []string
+		waiting = map[string][]queueMessage{}
+	)
+	for _, m := range msgs {
+		e, err := parseBlobEvent(m.Text)
+		if err == nil && m.DequeueCount > opts.MaxDequeue {
+			err = fmt.Errorf("received %d times", m.DequeueCount)
+		}
+		if err != nil {
+			if err := c.sendMessage(ctx, opts.PoisonQueue, m.Text); err != nil {
+				return err
+			}
+			log.Print(c.Messages.format(MsgQueuePoisoned, m.ID, opts.PoisonQueue, err))
+			done = append(done, m)
+			continue
+		}
+		blob, ok := c.eventBlob(e.Data.URL)
+		switch {
+		case e.EventType != blobCreatedEvent || !ok || !strings.HasPrefix(blob, prefix) || strings.HasSuffix(blob, "/"):
+			done = append(done, m)
+		case e.Data.ETag != "" && strings.Trim(state[blob], `"`) == e.Data.ETag:
+			log.Print(c.Messages.format(MsgQueueDuplicate, blob, e.Data.ETag))
+			done = append(done, m)
+		default:
+			if waiting[blob] == nil {
+				blobs = append(blobs, blob)
+			}
+			waiting[blob] = append(waiting[blob], m)
+		}
+	}
+	etags := make([]string, len(blobs))
+	errs := c.Pool.Run(ctx, len(blobs), func(ctx context.Context, i int) error {
+		dest := downloadDestination(dir, strings.TrimPrefix(blobs[i], prefix))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		result, err := c.Download(ctx, blobs[i], dest)
+		if err != nil {
+			return err
+		}
+		etags[i] = result.ETag
+		log.Print(c.Messages.format(MsgWatchDownloaded, blobs[i], dest))
+		return nil
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	dirty := false
+	for i, err := range errs {
+		if err == nil && opts.OnDownload != nil {
+			err = opts.OnDownload(blobs[i], downloadDestination(dir, strings.TrimPrefix(blobs[i], prefix)))
+		}
+		if isNotFound(err) {
+			// The blob was deleted since; there is nothing left to fetch.
+			err = nil
+		} else if err == nil {
+			state[blobs[i]] = etags[i]
+			dirty = true
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		done = append(done, waiting[blobs[i]]...)
+	}
+	for _, m := range done {
+		if err := c.deleteMessage(ctx, queue, m); err != nil && !isNotFound(err) {
+			return err
+		}
+	}
+	if opts.StatePath == "" || !dirty {
+		return nil
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(opts.StatePath, b)
+}
+
+func runWatchQueue(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("watch-queue", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "only download blobs whose name starts with `prefix`, relative to which they are stored")
+	interval := fs.Duration("interval", defaultQueueInterval, "time to wait after finding the queue empty")
+	visibility := fs.Duration("visibility-timeout", defaultQueueVisibility, "time a received message is hidden, after which a failed download is retried")
+	maxDequeue := fs.Int("max-dequeue", defaultQueueMaxDequeue, "move a message to the poison queue once it was received more than `n` times")
+	poison := fs.String("poison-queue", "", "queue that unusable messages are moved to (default <queue>-poison)")
+	state := fs.String("state", "", "record downloaded blobs in `file`, so events delivered again are skipped after a restart")
+	hook := fs.String("exec", "", "run `command` with each downloaded file and its blob name appended")
+	once := fs.Bool("once", false, "handle the messages until the queue is empty, then exit")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: watch-queue [flags] <queue> <directory>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("watch-queue takes a queue and a directory")
+	}
+	if *interval <= 0 || *visibility < time.Second {
+		return errors.New("-interval must be positive and -visibility-timeout at least 1s")
+	}
+	if *hook != "" && len(strings.Fields(*hook)) == 0 {
+		return errors.New("-exec needs a command")
+	}
+	statePath, err := az.clientOptions().statePath(*state)
+	if err != nil {
+		return err
+	}
+	opts := QueueWatchOptions{
+		Interval:          *interval,
+		VisibilityTimeout: *visibility,
+		MaxDequeue:        *maxDequeue,
+		PoisonQueue:       *poison,
+		StatePath:         statePath,
+		Once:              *once,
+	}
+	if *hook != "" {
+		opts.OnDownload = hookCommand(*hook)
+	}
+	return az.WatchQueue(ctx, fs.Arg(0), *prefix, fs.Arg(1), opts)
+}
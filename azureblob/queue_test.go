@@ -0,0 +1,200 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memQueues is an in-memory stand-in for the queues of an account. A
+// received message stays hidden until the test makes it visible again, as
+// the visibility timeout would.
+type memQueues struct {
+	mu     sync.Mutex
+	queues map[string][]*memMessage
+	nextID int
+}
+
+type memMessage struct {
+	id       string
+	text     string
+	dequeued int
+	hidden   bool
+}
+
+func (q *memQueues) add(queue, text string) {
+	q.nextID++
+	if q.queues == nil {
+		q.queues = map[string][]*memMessage{}
+	}
+	q.queues[queue] = append(q.queues[queue], &memMessage{id: fmt.Sprint("m", q.nextID), text: text})
+}
+
+// reveal makes the hidden messages of queue visible again.
+func (q *memQueues) reveal(queue string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, m := range q.queues[queue] {
+		m.hidden = false
+	}
+}
+
+func (q *memQueues) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	queue := parts[0]
+	switch {
+	case r.Method == http.MethodGet:
+		fmt.Fprint(w, "<QueueMessagesList>")
+		for _, m := range q.queues[queue] {
+			if !m.hidden {
+				m.hidden = true
+				m.dequeued++
+				fmt.Fprintf(w, "<QueueMessage><MessageId>%s</MessageId><PopReceipt>r-%s</PopReceipt><DequeueCount>%d</DequeueCount><MessageText>%s</MessageText></QueueMessage>", m.id, m.id, m.dequeued, m.text)
+			}
+		}
+		fmt.Fprint(w, "</QueueMessagesList>")
+	case r.Method == http.MethodPost:
+		body, _ := io.ReadAll(r.Body)
+		var msg struct {
+			Text string `xml:"MessageText"`
+		}
+		xml.Unmarshal(body, &msg)
+		q.add(queue, msg.Text)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodDelete && len(parts) == 3:
+		for i, m := range q.queues[queue] {
+			if m.id == parts[2] && r.URL.Query().Get("popreceipt") == "r-"+m.id {
+				q.queues[queue] = append(q.queues[queue][:i], q.queues[queue][i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		w.Header().Set("x-ms-error-code", "MessageNotFound")
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// createdEvent returns a queue message text for a Blob Created event of
+// blob in the test client's container, as Event Grid encodes it.
+func createdEvent(blob, etag string) string {
+	etag = strings.Trim(etag, `"`)
+	event := fmt.Sprintf(`{"id":"e","eventType":"Microsoft.Storage.BlobCreated","subject":"/blobServices/default/containers/container/blobs/%s","data":{"api":"PutBlob","url":"https://account.blob.core.windows.net/container/%s","eTag":"%s"}}`, blob, blob, etag)
+	return base64.StdEncoding.EncodeToString([]byte(event))
+}
+
+func newQueueTestClient(t *testing.T) (*AzureBlobClient, *memContainer, *memQueues) {
+	m := newMemContainer()
+	q := &memQueues{}
+	az := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Host, ".queue.") {
+			q.ServeHTTP(w, r)
+			return
+		}
+		m.ServeHTTP(w, r)
+	}))
+	return az, m, q
+}
+
+func TestParseBlobEvent(t *testing.T) {
+	e, err := parseBlobEvent(`{"specversion":"1.0","type":"Microsoft.Storage.BlobCreated","data":{"url":"https://a.blob.core.windows.net/c/b"}}`)
+	if err != nil || e.EventType != blobCreatedEvent || e.Data.URL != "https://a.blob.core.windows.net/c/b" {
+		t.Errorf("CloudEvents event parsed as %+v, %v", e, err)
+	}
+	if _, err := parseBlobEvent("bm90IGpzb24="); err == nil {
+		t.Error("parsed a message that is not an event")
+	}
+	az := &AzureBlobClient{StorageAccount: "account", ContainerName: "container"}
+	for u, want := range map[string]string{
+		"https://account.blob.core.windows.net/container/dir/a%20b": "dir/a b",
+		"https://other.blob.core.windows.net/container/a":           "",
+		"https://account.blob.core.windows.net/other/a":             "",
+	} {
+		if got, _ := az.eventBlob(u); got != want {
+			t.Errorf("eventBlob(%q) = %q, want %q", u, got, want)
+		}
+	}
+}
+
+func TestWatchQueue(t *testing.T) {
+	az, m, q := newQueueTestClient(t)
+	m.put("releases/app.pkg", []byte("v1"), nil)
+	etag := m.blobs["releases/app.pkg"].etag
+	q.add("events", createdEvent("releases/app.pkg", etag))
+	q.add("events", createdEvent("releases/app.pkg", etag))
+	q.add("events", createdEvent("other/skipped.txt", `"0x1"`))
+	q.add("events", "garbage")
+	dir := t.TempDir()
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	var hooked []string
+	opts := QueueWatchOptions{Once: true, StatePath: statePath, OnDownload: func(blob, path string) error {
+		hooked = append(hooked, blob)
+		return nil
+	}}
+
+	if err := az.WatchQueue(context.Background(), "events", "releases/", dir, opts); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "app.pkg")); err != nil || string(b) != "v1" {
+		t.Errorf("downloaded %q, %v", b, err)
+	}
+	if len(hooked) != 1 {
+		t.Errorf("hook ran for %v; want the duplicate events to download once", hooked)
+	}
+	if n := len(q.queues["events"]); n != 0 {
+		t.Errorf("%d messages left in the queue", n)
+	}
+	if p := q.queues["events-poison"]; len(p) != 1 || p[0].text != "garbage" {
+		t.Errorf("poison queue holds %v", p)
+	}
+
+	// An event delivered again after a restart is skipped by its ETag.
+	q.add("events", createdEvent("releases/app.pkg", etag))
+	hooked = nil
+	if err := az.WatchQueue(context.Background(), "events", "releases/", dir, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(hooked) != 0 || len(q.queues["events"]) != 0 {
+		t.Errorf("redelivered event ran the hook for %v, left %d messages", hooked, len(q.queues["events"]))
+	}
+}
+
+func TestWatchQueuePoisonsFailingMessages(t *testing.T) {
+	az, m, q := newQueueTestClient(t)
+	m.put("app.pkg", []byte("v1"), nil)
+	q.add("events", createdEvent("app.pkg", `"0x1"`))
+	dir := t.TempDir()
+	fail := func(blob, path string) error { return fmt.Errorf("hook failed for %s", blob) }
+	opts := QueueWatchOptions{Once: true, MaxDequeue: 2, PoisonQueue: "dead", OnDownload: fail}
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	// The message is received again after each failure, as it would be
+	// after its visibility timeout, until it was received too often.
+	for i := 0; i < 3; i++ {
+		if err := az.WatchQueue(context.Background(), "events", "", dir, opts); err != nil {
+			t.Fatal(err)
+		}
+		q.reveal("events")
+	}
+	if n := len(q.queues["events"]); n != 0 {
+		t.Errorf("%d messages left in the queue", n)
+	}
+	if len(q.queues["dead"]) != 1 || !strings.Contains(buf.String(), "moved to dead: received 3 times") {
+		t.Errorf("poison queue holds %v; log %q", q.queues["dead"], buf.String())
+	}
+}
@@ -0,0 +1,115 @@
+package azureblob
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// rateLimiter is a token bucket shared by every stream of a client, so the
+// combined throughput of parallel block transfers stays under the limit.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	// Allow roughly 100ms worth of data at once to keep the output smooth.
+	burst := float64(bytesPerSecond) / 10
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:   float64(bytesPerSecond),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// wait accounts for n transferred bytes and blocks until the bucket allows
+// them, or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	delay := l.reserve(n, time.Now())
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserve takes n bytes from the bucket at now and returns how long the
+// caller must wait before they are covered.
+func (l *rateLimiter) reserve(n int, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.After(l.last) {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+	}
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// throttledReadCloser limits the rate at which rc can be read.
+type throttledReadCloser struct {
+	ctx     context.Context
+	rc      io.ReadCloser
+	limiter *rateLimiter
+}
+
+func (t *throttledReadCloser) Read(p []byte) (int, error) {
+	if limit := int(t.limiter.burst); len(p) > limit {
+		p = p[:limit]
+	}
+	n, err := t.rc.Read(p)
+	if n > 0 {
+		if werr := t.limiter.wait(t.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (t *throttledReadCloser) Close() error {
+	return t.rc.Close()
+}
+
+// throttledTransport applies a rateLimiter to request and response bodies.
+// It sits below the azcore pipeline so retries are throttled too.
+type throttledTransport struct {
+	next    policy.Transporter
+	limiter *rateLimiter
+}
+
+func (t *throttledTransport) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &throttledReadCloser{ctx: ctx, rc: req.Body, limiter: t.limiter}
+	}
+	resp, err := t.next.Do(req)
+	if err != nil {
+		return resp, err
+	}
+	resp.Body = &throttledReadCloser{ctx: ctx, rc: resp.Body, limiter: t.limiter}
+	return resp, nil
+}
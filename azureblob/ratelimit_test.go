@@ -0,0 +1,51 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	l := newRateLimiter(1000)
+	start := l.last
+	steps := []struct {
+		n     int
+		after time.Duration
+		want  time.Duration
+	}{
+		// The bucket starts full with 100ms worth of bytes.
+		{n: 100, want: 0},
+		{n: 100, want: 100 * time.Millisecond},
+		// 300ms later the debt of 100 bytes has been repaid with 200 to
+		// spare, but the bucket holds at most 100.
+		{n: 100, after: 300 * time.Millisecond, want: 0},
+		{n: 50, after: 300 * time.Millisecond, want: 50 * time.Millisecond},
+	}
+	for i, s := range steps {
+		if got := l.reserve(s.n, start.Add(s.after)); got != s.want {
+			t.Errorf("step %d: reserve(%d) = %v, want %v", i, s.n, got, s.want)
+		}
+	}
+}
+
+func TestRateLimiterWaitCancelled(t *testing.T) {
+	l := newRateLimiter(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	// 1000 bytes at 10 B/s would block for well over a minute.
+	if err := l.wait(ctx, 1000); !errors.Is(err, context.Canceled) {
+		t.Errorf("wait = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("wait took %v after cancellation", elapsed)
+	}
+}
+
+func TestRateLimiterMinimumBurst(t *testing.T) {
+	if l := newRateLimiter(5); l.burst != 1 {
+		t.Errorf("burst = %v, want 1", l.burst)
+	}
+}
@@ -0,0 +1,105 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// isStaleClientError reports whether err suggests that the cached client,
+// rather than the request, is at fault: the service rejected its
+// authentication (a revoked credential or rotated key), the credential
+// could not get a token, or the account's host name no longer resolves or
+// accepts connections (a DNS change after failover).
+func isStaleClientError(err error) bool {
+	var be *BlobError
+	if errors.As(err, &be) && be.StatusCode != 0 {
+		return isAuthRejected(be.StatusCode, be.ErrorCode)
+	}
+	var authErr azidentity.AuthenticationFailedError
+	if errors.As(err, &authErr) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// isAuthRejected reports whether a response with status and storage error
+// code rejected the authentication of the request rather than its
+// permissions.
+func isAuthRejected(status int, code string) bool {
+	switch status {
+	case http.StatusUnauthorized:
+		return true
+	case http.StatusForbidden:
+		return code == "AuthenticationFailed" || code == "InvalidAuthenticationInfo"
+	}
+	return false
+}
+
+// withRebuild runs op, which must initialise c itself. If it fails with an
+// error for which isStaleClientError holds, the container client is
+// discarded and rebuilt, together with the credential if c built it, and op
+// is run once more. Concurrent operations that fail on the same client
+// rebuild it only once.
+func (c *AzureBlobClient) withRebuild(ctx context.Context, opName, name string, op func() error) error {
+	c.initMu.Lock()
+	generation := c.generation
+	c.initMu.Unlock()
+	err := op()
+	if err == nil || ctx.Err() != nil || !isStaleClientError(err) {
+		return err
+	}
+	log.Print(c.Messages.format(MsgRebuild, opName, name, err))
+	c.rebuild(generation)
+	transferStatsFrom(ctx).restarted()
+	return op()
+}
+
+// rebuild discards the clients of generation so the next init builds new
+// ones. It does nothing if another operation already rebuilt them.
+func (c *AzureBlobClient) rebuild(generation int) {
+	c.initMu.Lock()
+	defer c.initMu.Unlock()
+	if c.generation != generation {
+		return
+	}
+	c.generation++
+	c.containerClient = nil
+	if c.credential != nil {
+		if f, ok := (*c.credential).(tokenForgetter); ok {
+			f.forget()
+		}
+	}
+	if c.builtCredential {
+		c.credential = nil
+		c.builtCredential = false
+	}
+	// Idle connections may still point at the account's old address.
+	if c.client != nil {
+		c.client.CloseIdleConnections()
+	}
+}
+
+// HealthCheck reads the container's properties, rebuilding the client
+// first if it has become unusable. Long-running programs can call it
+// periodically to find problems before a transfer does.
+func (c *AzureBlobClient) HealthCheck(ctx context.Context) error {
+	return c.withRebuild(ctx, "health check", c.ContainerName, func() error {
+		if err := c.init(ctx); err != nil {
+			return err
+		}
+		ctx, cancel := c.metadataContext(ctx)
+		defer cancel()
+		_, err := c.containerClient.GetProperties(ctx, nil)
+		return newBlobError("get container properties", c.ContainerName, err)
+	})
+}
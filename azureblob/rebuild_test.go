@@ -0,0 +1,165 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestIsStaleClientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"unauthorized", &BlobError{StatusCode: 401, ErrorCode: "InvalidAuthenticationInfo"}, true},
+		{"authentication failed", &BlobError{StatusCode: 403, ErrorCode: "AuthenticationFailed"}, true},
+		{"permission mismatch", &BlobError{StatusCode: 403, ErrorCode: "AuthorizationPermissionMismatch"}, false},
+		{"not found", &BlobError{StatusCode: 404, ErrorCode: "BlobNotFound"}, false},
+		{"dns", &BlobError{Err: &net.DNSError{Err: "no such host", Name: "account.blob.core.windows.net"}}, true},
+		{"dial", fmt.Errorf("send: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), true},
+		{"read", &net.OpError{Op: "read", Err: errors.New("connection reset")}, false},
+		{"other", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isStaleClientError(tt.err); got != tt.want {
+				t.Errorf("isStaleClientError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// failingFirst answers the first n requests with 401 and passes the rest to
+// next.
+type failingFirst struct {
+	next     http.Handler
+	mu       sync.Mutex
+	n, calls int
+}
+
+func (h *failingFirst) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	h.calls++
+	fail := h.calls <= h.n
+	h.mu.Unlock()
+	if fail {
+		w.Header().Set("x-ms-error-code", "InvalidAuthenticationInfo")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+func TestStatRebuildsClientOnce(t *testing.T) {
+	m := newMemContainer()
+	m.put("blob", []byte("data"), nil)
+	h := &failingFirst{next: m, n: 1}
+	az := newTestClient(t, h)
+	if err := az.init(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	before, credential := az.containerClient, az.credential
+	if _, err := az.Stat(context.Background(), "blob"); err != nil {
+		t.Fatal(err)
+	}
+	if az.containerClient == before || az.generation != 1 {
+		t.Error("container client was not rebuilt")
+	}
+	if az.credential != credential {
+		t.Error("caller-supplied credential was replaced")
+	}
+
+	h.mu.Lock()
+	h.n, h.calls = 100, 0
+	h.mu.Unlock()
+	if _, err := az.Stat(context.Background(), "blob"); err == nil || !isStaleClientError(err) {
+		t.Errorf("persistent 401 = %v", err)
+	}
+	// The client's token is cached by now, so it is refreshed once before
+	// the client is rebuilt.
+	if h.calls != 3 {
+		t.Errorf("%d attempts, want the original, one with a new token and one after rebuilding", h.calls)
+	}
+}
+
+func TestRebuildNotTriggeredByOtherErrors(t *testing.T) {
+	az := newTestClient(t, newMemContainer())
+	if _, err := az.Stat(context.Background(), "missing"); !isNotFound(err) {
+		t.Fatal(err)
+	}
+	if az.generation != 0 {
+		t.Error("a missing blob rebuilt the client")
+	}
+}
+
+func TestConcurrentFailuresRebuildOnce(t *testing.T) {
+	const workers = 8
+	m := newMemContainer()
+	m.put("blob", []byte("data"), nil)
+	// The first request of every worker waits for the others and then
+	// fails, so all of them fail on the same client.
+	var (
+		arrived  sync.WaitGroup
+		mu       sync.Mutex
+		arrivals int
+	)
+	arrived.Add(workers)
+	h := &failingFirst{next: m, n: workers}
+	az := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		arrivals++
+		first := arrivals <= workers
+		mu.Unlock()
+		if first {
+			arrived.Done()
+			arrived.Wait()
+		}
+		h.ServeHTTP(w, r)
+	}))
+	if err := az.init(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := az.Stat(context.Background(), "blob"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if az.generation != 1 {
+		t.Errorf("client rebuilt %d times, want once", az.generation)
+	}
+}
+
+func TestRebuildReplacesBuiltCredential(t *testing.T) {
+	az := newTestClient(t, newMemContainer())
+	az.builtCredential = true
+	az.rebuild(0)
+	if az.credential != nil || az.containerClient != nil {
+		t.Error("rebuild kept the credential init built")
+	}
+	az.rebuild(0)
+	if az.generation != 1 {
+		t.Errorf("stale rebuild advanced the generation to %d", az.generation)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	h := &failingFirst{next: newMemContainer(), n: 1}
+	az := newTestClient(t, h)
+	if err := az.HealthCheck(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if h.calls != 2 || az.generation != 1 {
+		t.Errorf("%d requests, generation %d; want a rebuild after the 401", h.calls, az.generation)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package azureblob
+
+import "errors"
+
+// cloneFile is unsupported here, so DedupIndex falls back to hardlinks.
+func cloneFile(src, dst string) error {
+	return errors.New("copy-on-write clones are not supported on this platform")
+}
@@ -0,0 +1,139 @@
+package azureblob
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// ClientRegistry hands out clients for several storage accounts and
+// containers from one process, e.g. a bootstrap pulling from "tools",
+// "packages" and "profiles". All of them authenticate as the identity of the
+// base client through one shared credential, so the credential chain is
+// probed and signed in to once rather than per client, and its tokens are
+// reused across clients. They also share the base's configuration, HTTP
+// client and rate limiter.
+type ClientRegistry struct {
+	base *AzureBlobClient
+
+	mu      sync.Mutex
+	clients map[string]*AzureBlobClient
+}
+
+// NewClientRegistry returns a registry whose clients are configured like
+// base. Unless base was given a credential, it starts sharing the
+// registry's.
+func NewClientRegistry(base *AzureBlobClient) *ClientRegistry {
+	base.initMu.Lock()
+	defer base.initMu.Unlock()
+	if base.credential == nil {
+		cred := azcore.TokenCredential(&sharedCredential{owner: base})
+		base.credential = &cred
+	}
+	return &ClientRegistry{base: base, clients: map[string]*AzureBlobClient{}}
+}
+
+// Client returns the client for containerName in storageAccount, creating
+// it on first use. The base client itself is returned for its own
+// container.
+func (r *ClientRegistry) Client(storageAccount, containerName string) (*AzureBlobClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := storageAccount + "/" + containerName
+	if key == r.base.source() {
+		return r.base, nil
+	}
+	if c, ok := r.clients[key]; ok {
+		return c, nil
+	}
+	c, err := r.base.derive(storageAccount, containerName)
+	if err != nil {
+		return nil, err
+	}
+	r.clients[key] = c
+	return c, nil
+}
+
+// derive returns a client for containerName in storageAccount sharing the
+// identity, credential, configuration, HTTP client and rate limiter of c.
+func (c *AzureBlobClient) derive(storageAccount, containerName string) (*AzureBlobClient, error) {
+	c.initMu.Lock()
+	// Build the HTTP client and limiter now so that clients created before
+	// c's first request still share them.
+	_, err := c.blobTransporter()
+	credential, client, limiter := c.credential, c.client, c.limiter
+	c.initMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return &AzureBlobClient{
+		ClientID:                   c.ClientID,
+		TenantID:                   c.TenantID,
+		AdditionallyAllowedTenants: c.AdditionallyAllowedTenants,
+		StorageAccount:             storageAccount,
+		ContainerName:              containerName,
+		credential:                 credential,
+		client:                     client,
+		limiter:                    limiter,
+		CredentialOptions:          c.CredentialOptions,
+		ClientOptions:              c.ClientOptions,
+		Progress:                   c.Progress,
+		Pool:                       c.Pool,
+		Keys:                       c.Keys,
+		Dedup:                      c.Dedup,
+		Messages:                   c.Messages,
+	}, nil
+}
+
+// sharedCredential builds the credential chain of owner on first use and
+// caches the tokens it issues until they are about to expire.
+type sharedCredential struct {
+	owner *AzureBlobClient
+
+	mu     sync.Mutex
+	cred   azcore.TokenCredential
+	tokens map[string]*azcore.AccessToken
+}
+
+// GetToken returns a cached token for the scopes and tenant of opts, or
+// gets one.
+// Concurrent callers wait for a single sign-in.
+func (s *sharedCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (*azcore.AccessToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := strings.Join(opts.Scopes, " ") + "@" + opts.TenantID
+	if token := s.tokens[key]; token != nil && time.Until(token.ExpiresOn) >= tokenRefreshWindow {
+		return token, nil
+	}
+	if s.cred == nil {
+		cred, err := s.owner.InitCredential(ctx, s.owner.CredentialOptions)
+		if err != nil {
+			return nil, err
+		}
+		s.cred = *cred
+	}
+	token, err := s.cred.GetToken(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if s.tokens == nil {
+		s.tokens = map[string]*azcore.AccessToken{}
+	}
+	s.tokens[key] = token
+	return token, nil
+}
+
+// forget drops the cached tokens, so the next request gets a fresh one
+// after the service rejected the authentication of a client sharing it.
+func (s *sharedCredential) forget() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = nil
+	if f, ok := s.cred.(tokenForgetter); ok {
+		f.forget()
+	}
+}
@@ -0,0 +1,144 @@
+package azureblob
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// containers serves a memContainer per container name, e.g. /tools/... and
+// /packages/..., recording the account each request was addressed to.
+type containers struct {
+	mu       sync.Mutex
+	byName   map[string]*memContainer
+	accounts map[string]bool
+}
+
+func (c *containers) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+	c.mu.Lock()
+	c.accounts[strings.SplitN(r.Host, ".", 2)[0]] = true
+	m := c.byName[name]
+	c.mu.Unlock()
+	if m == nil {
+		w.Header().Set("x-ms-error-code", "ContainerNotFound")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	// memContainer expects its own name in the path.
+	r.URL.Path = "/container" + strings.TrimPrefix(r.URL.Path, "/"+name)
+	m.ServeHTTP(w, r)
+}
+
+func TestClientRegistrySharesCredential(t *testing.T) {
+	tools, packages := newMemContainer(), newMemContainer()
+	tools.put("lint", []byte("lint"), nil)
+	packages.put("app.pkg", []byte("pkg"), nil)
+	srv := &containers{byName: map[string]*memContainer{"tools": tools, "packages": packages}, accounts: map[string]bool{}}
+	base := newTestClient(t, srv)
+	base.ContainerName = "tools"
+	cred := &countingCredential{ttl: time.Hour}
+	shared := azcore.TokenCredential(&sharedCredential{owner: base, cred: cred})
+	base.credential = &shared
+	registry := NewClientRegistry(base)
+
+	if c, err := registry.Client("account", "tools"); err != nil || c != base {
+		t.Errorf("the base's own container: got %p, %v, want the base %p", c, err, base)
+	}
+	pkgs, err := registry.Client("account", "packages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := registry.Client("account", "packages"); again != pkgs {
+		t.Error("a second lookup created another client")
+	}
+	other, err := registry.Client("other", "packages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == pkgs || other.StorageAccount != "other" || other.ClientOptions != base.ClientOptions {
+		t.Errorf("client for another account: %+v", other)
+	}
+
+	dir := t.TempDir()
+	ctx := context.Background()
+	for _, tt := range []struct {
+		c    *AzureBlobClient
+		blob string
+	}{{base, "lint"}, {pkgs, "app.pkg"}, {other, "app.pkg"}} {
+		if _, err := tt.c.Download(ctx, tt.blob, filepath.Join(dir, tt.blob)); err != nil {
+			t.Fatalf("%s from %s: %v", tt.blob, tt.c.source(), err)
+		}
+	}
+	if n := atomic.LoadInt32(&cred.calls); n != 1 {
+		t.Errorf("%d tokens issued for three clients, want 1", n)
+	}
+	if !srv.accounts["other"] || !srv.accounts["account"] {
+		t.Errorf("requests went to accounts %v", srv.accounts)
+	}
+}
+
+func TestClientRegistryKeepsGivenCredential(t *testing.T) {
+	base := newTestClient(t, newMemContainer())
+	given := base.credential
+	registry := NewClientRegistry(base)
+	c, err := registry.Client("account", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base.credential != given || c.credential != given {
+		t.Error("the base's credential was replaced")
+	}
+}
+
+func TestSharedCredentialForget(t *testing.T) {
+	cred := &countingCredential{ttl: time.Hour}
+	shared := &sharedCredential{cred: cred}
+	opts := policy.TokenRequestOptions{Scopes: []string{storageScope}}
+	for i := 0; i < 2; i++ {
+		if _, err := shared.GetToken(context.Background(), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cred.calls != 1 {
+		t.Errorf("%d tokens issued, want the cached one reused", cred.calls)
+	}
+	shared.forget()
+	if _, err := shared.GetToken(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if cred.calls != 2 {
+		t.Errorf("%d tokens issued, want a new one after forget", cred.calls)
+	}
+}
+
+func TestRunManifestOtherContainer(t *testing.T) {
+	tools, packages := newMemContainer(), newMemContainer()
+	tools.put("lint", []byte("lint"), nil)
+	packages.put("app.pkg", []byte("pkg"), nil)
+	base := newTestClient(t, &containers{byName: map[string]*memContainer{"tools": tools, "packages": packages}, accounts: map[string]bool{}})
+	base.ContainerName = "tools"
+	dir := t.TempDir()
+	results := base.RunManifest(context.Background(), &Manifest{Downloads: []ManifestItem{
+		{Blob: "lint", Path: filepath.Join(dir, "lint")},
+		{Blob: "app.pkg", Path: filepath.Join(dir, "app.pkg"), Container: "packages"},
+		{Blob: "app.pkg", Path: filepath.Join(dir, "missing"), Container: "profiles"},
+	}})
+	if results[0].Error != "" || results[1].Error != "" {
+		t.Errorf("results %+v", results)
+	}
+	if !isNotFound(results[2].err) {
+		t.Errorf("download from a missing container: %v", results[2].err)
+	}
+	if got := readFile(t, filepath.Join(dir, "app.pkg")); got != "pkg" {
+		t.Errorf("app.pkg = %q", got)
+	}
+}
@@ -0,0 +1,163 @@
+package azureblob
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// checkRemote returns an error unless the remote called name in the
+// configuration file names a container and can be referred to as name:path.
+func checkRemote(name string, remote Profile) error {
+	if name == "" || strings.ContainsAny(name, ":/ \t") {
+		return fmt.Errorf("remote name %q must be non-empty, without colons, slashes or spaces", name)
+	}
+	if remote.StorageAccount == "" || remote.Container == "" {
+		return fmt.Errorf("remote %q needs a storage_account and a container", name)
+	}
+	if _, err := remote.interactive(); err != nil {
+		return fmt.Errorf("remote %q: %w", name, err)
+	}
+	return nil
+}
+
+// identity returns the settings of p that decide whom it signs in as, with
+// the credential mode spelled out.
+func (p Profile) identity() Profile {
+	id := Profile{
+		TenantID:                   p.TenantID,
+		AdditionallyAllowedTenants: p.AdditionallyAllowedTenants,
+		ClientID:                   p.ClientID,
+		ManagedIdentityID:          p.ManagedIdentityID,
+		Credential:                 credentialDefault,
+	}
+	if interactive, _ := p.interactive(); interactive {
+		id.Credential = credentialInteractive
+	}
+	return id
+}
+
+// Remotes hands out clients for the named remotes of the configuration
+// file. A remote takes the identity and encryption scope of the base
+// client's profile unless it sets its own. Remotes signing in as the base
+// share its credential, like the clients of a ClientRegistry, and remotes
+// signing in as another identity share one credential per identity, so
+// each identity signs in once. All of them share the base's configuration,
+// HTTP client and rate limiter.
+type Remotes struct {
+	base     *AzureBlobClient
+	profile  Profile
+	defined  map[string]Profile
+	registry *ClientRegistry
+
+	mu          sync.Mutex
+	clients     map[string]*AzureBlobClient
+	credentials map[Profile]*azcore.TokenCredential
+}
+
+// NewRemotes returns the remotes defined for base, whose settings are those
+// of profile.
+func NewRemotes(base *AzureBlobClient, profile Profile, defined map[string]Profile) *Remotes {
+	return &Remotes{
+		base:        base,
+		profile:     profile,
+		defined:     defined,
+		registry:    NewClientRegistry(base),
+		clients:     map[string]*AzureBlobClient{},
+		credentials: map[Profile]*azcore.TokenCredential{},
+	}
+}
+
+// Client returns the client of the remote called name, creating it on
+// first use.
+func (r *Remotes) Client(name string) (*AzureBlobClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.clients[name]; ok {
+		return c, nil
+	}
+	remote, ok := r.defined[name]
+	if !ok {
+		names := make([]string, 0, len(r.defined))
+		for n := range r.defined {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown remote %q; defined remotes: %s", name, strings.Join(names, ", "))
+	}
+	p := r.profile
+	p.merge(remote)
+	sameIdentity := p.identity() == r.profile.identity()
+	var c *AzureBlobClient
+	var err error
+	if sameIdentity && p.EncryptionScope == r.profile.EncryptionScope {
+		c, err = r.registry.Client(p.StorageAccount, p.Container)
+	} else {
+		c, err = r.base.derive(p.StorageAccount, p.Container)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !sameIdentity {
+		interactive, err := p.interactive()
+		if err != nil {
+			return nil, err
+		}
+		c.ClientID, c.TenantID = p.ClientID, p.TenantID
+		c.AdditionallyAllowedTenants = parseTenants(p.AdditionallyAllowedTenants)
+		c.CredentialOptions = &AzureBlobCredentialOptions{
+			InteractiveCredential: interactive,
+			ManagedIdentityID:     p.ManagedIdentityID,
+			DeviceCodeTimeout:     deviceCodeTimeout(r.base.CredentialOptions),
+		}
+		credential := r.credentials[p.identity()]
+		if credential == nil {
+			shared := azcore.TokenCredential(&sharedCredential{owner: c})
+			credential = &shared
+			r.credentials[p.identity()] = credential
+		}
+		c.credential = credential
+	}
+	if p.EncryptionScope != r.profile.EncryptionScope {
+		opts := *c.clientOptions()
+		opts.EncryptionScope = p.EncryptionScope
+		c.ClientOptions = &opts
+	}
+	r.clients[name] = c
+	return c, nil
+}
+
+// resolveRemote splits ref, of the form remote:path, into the client of the
+// remote and the path in its container. A ref that does not start with the
+// name of a remote of c.Remotes, such as a blob name containing a colon, is
+// a path of c itself.
+func (c *AzureBlobClient) resolveRemote(ref string) (*AzureBlobClient, string, error) {
+	if i := strings.Index(ref, ":"); i > 0 && c.Remotes != nil {
+		if _, ok := c.Remotes.defined[ref[:i]]; ok {
+			rc, err := c.Remotes.Client(ref[:i])
+			return rc, ref[i+1:], err
+		}
+	}
+	return c, ref, nil
+}
+
+// resolveRemotes resolves each of refs as resolveRemote does. They must
+// all be in the same container.
+func (c *AzureBlobClient) resolveRemotes(refs []string) (*AzureBlobClient, []string, error) {
+	client := c
+	paths := make([]string, len(refs))
+	for i, ref := range refs {
+		rc, p, err := c.resolveRemote(ref)
+		if err != nil {
+			return nil, nil, err
+		}
+		if i > 0 && rc != client {
+			return nil, nil, fmt.Errorf("%s and %s are in different containers; name blobs of one container at a time", refs[0], ref)
+		}
+		client, paths[i] = rc, p
+	}
+	return client, paths, nil
+}
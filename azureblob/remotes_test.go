@@ -0,0 +1,157 @@
+package azureblob
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRemotesConfig = `
+remotes:
+  prod-artifacts:
+    storage_account: account
+    container: packages
+  staging:
+    storage_account: staging
+    container: packages
+    encryption_scope: staging-scope
+  partner:
+    storage_account: partner
+    container: drop
+    client_id: partner-client
+  partner-logs:
+    storage_account: partner
+    container: logs
+    client_id: partner-client
+    credential: default
+`
+
+func testRemotes(t *testing.T) (*AzureBlobClient, *containers) {
+	t.Helper()
+	tools, packages := newMemContainer(), newMemContainer()
+	packages.put("app.pkg", []byte("pkg"), nil)
+	srv := &containers{byName: map[string]*memContainer{"tools": tools, "packages": packages}, accounts: map[string]bool{}}
+	base := newTestClient(t, srv)
+	base.ContainerName, base.TenantID, base.ClientID = "tools", "tenant", "client"
+	path := writeFile(t, filepath.Join(t.TempDir(), "config.yaml"), testRemotesConfig)
+	profile, cfg, err := resolveProfile(path, "", Profile{}, Profile{TenantID: "tenant", ClientID: "client", StorageAccount: "account", Container: "tools"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base.Remotes = NewRemotes(base, profile, cfg.Remotes)
+	return base, srv
+}
+
+func TestRemotesClient(t *testing.T) {
+	base, _ := testRemotes(t)
+	base.CredentialOptions = &AzureBlobCredentialOptions{DeviceCodeTimeout: time.Minute}
+	prod, err := base.Remotes.Client("prod-artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prod.ContainerName != "packages" || prod.credential != base.credential || prod.ClientOptions != base.ClientOptions {
+		t.Errorf("remote of the base's identity: %+v", prod)
+	}
+	if again, _ := base.Remotes.Client("prod-artifacts"); again != prod {
+		t.Error("a second lookup created another client")
+	}
+	staging, err := base.Remotes.Client("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if staging.StorageAccount != "staging" || staging.credential != base.credential || staging.ClientOptions.EncryptionScope != "staging-scope" || base.ClientOptions.EncryptionScope != "" {
+		t.Errorf("remote with its own encryption scope: %+v", staging)
+	}
+	if staging.ClientOptions.TransferRetry.MaxRetries != base.ClientOptions.TransferRetry.MaxRetries {
+		t.Error("remote with its own encryption scope lost the base's options")
+	}
+	partner, err := base.Remotes.Client("partner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if partner.ClientID != "partner-client" || partner.TenantID != "tenant" || partner.credential == base.credential || partner.client != base.client || partner.CredentialOptions.DeviceCodeTimeout != time.Minute {
+		t.Errorf("remote of another identity: %+v", partner)
+	}
+	logs, err := base.Remotes.Client("partner-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logs.ContainerName != "logs" || logs.credential != partner.credential {
+		t.Error("remotes of one identity do not share its credential")
+	}
+	if _, err := base.Remotes.Client("typo"); err == nil || !strings.Contains(err.Error(), "defined remotes: partner, partner-logs, prod-artifacts, staging") {
+		t.Errorf("unknown remote = %v", err)
+	}
+}
+
+func TestResolveRemote(t *testing.T) {
+	base, _ := testRemotes(t)
+	prod, _ := base.Remotes.Client("prod-artifacts")
+	for _, tt := range []struct {
+		ref, path string
+		client    *AzureBlobClient
+	}{
+		{"prod-artifacts:app/1.0.pkg", "app/1.0.pkg", prod},
+		{"prod-artifacts:", "", prod},
+		{"release:1.0.pkg", "release:1.0.pkg", base},
+		{":x", ":x", base},
+		{"plain", "plain", base},
+	} {
+		c, p, err := base.resolveRemote(tt.ref)
+		if err != nil || c != tt.client || p != tt.path {
+			t.Errorf("resolveRemote(%q) = %s, %q, %v; want %s, %q", tt.ref, c.source(), p, err, tt.client.source(), tt.path)
+		}
+	}
+	if _, _, err := base.resolveRemotes([]string{"prod-artifacts:a", "b"}); err == nil {
+		t.Error("blobs of two containers were accepted")
+	}
+	c, paths, err := base.resolveRemotes([]string{"prod-artifacts:a", "prod-artifacts:b"})
+	if err != nil || c != prod || strings.Join(paths, ",") != "a,b" {
+		t.Errorf("resolveRemotes = %s, %v, %v", c.source(), paths, err)
+	}
+	if c, p, _ := newTestClient(t, newMemContainer()).resolveRemote("prod-artifacts:a"); p != "prod-artifacts:a" || c.Remotes != nil {
+		t.Error("a client without remotes resolved one")
+	}
+}
+
+func TestRemoteCommands(t *testing.T) {
+	base, srv := testRemotes(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "app.pkg")
+	if err := runDownload(ctx, base, []string{"prod-artifacts:app.pkg", dest}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, dest); got != "pkg" {
+		t.Errorf("downloaded %q", got)
+	}
+	if err := runUpload(ctx, base, []string{dest, "prod-artifacts:copy.pkg"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := srv.byName["packages"].blobs["copy.pkg"]; !ok || len(srv.byName["tools"].blobs) != 0 {
+		t.Error("upload to the remote went elsewhere")
+	}
+	out := captureStdout(t, func() {
+		err := runStat(ctx, base, []string{"prod-artifacts:copy.pkg"})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	if !strings.Contains(out, "Name:          copy.pkg") {
+		t.Errorf("stat printed %q", out)
+	}
+	if err := runDelete(ctx, base, []string{"-prefix", "prod-artifacts:copy"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := srv.byName["packages"].blobs["copy.pkg"]; ok {
+		t.Error("delete -prefix on the remote left the blob")
+	}
+	if err := runDelete(ctx, base, []string{"-prefix", "prod-artifacts:app", "app.pkg"}); err == nil {
+		t.Error("delete of blobs in two containers succeeded")
+	}
+	if err := runDiff(ctx, base, []string{"-backend", "azure://account/packages", dir, "prod-artifacts:"}); err == nil {
+		t.Error("diff took both -backend and a remote")
+	}
+}
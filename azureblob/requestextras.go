@@ -0,0 +1,115 @@
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// requestExtras are headers and query parameters added to outgoing blob
+// requests, e.g. for traffic tagging or opting into preview API features.
+type requestExtras struct {
+	header http.Header
+	query  url.Values
+}
+
+type requestExtrasKey struct{}
+
+func requestExtrasFrom(ctx context.Context) requestExtras {
+	extras, _ := ctx.Value(requestExtrasKey{}).(requestExtras)
+	return extras
+}
+
+func (e requestExtras) clone() requestExtras {
+	clone := requestExtras{header: http.Header{}, query: url.Values{}}
+	for k, v := range e.header {
+		clone.header[k] = append([]string(nil), v...)
+	}
+	for k, v := range e.query {
+		clone.query[k] = append([]string(nil), v...)
+	}
+	return clone
+}
+
+// WithRequestHeader returns a copy of ctx under which every blob request also
+// carries the header key: value.
+func WithRequestHeader(ctx context.Context, key, value string) context.Context {
+	extras := requestExtrasFrom(ctx).clone()
+	extras.header.Add(key, value)
+	return context.WithValue(ctx, requestExtrasKey{}, extras)
+}
+
+// WithRequestQuery returns a copy of ctx under which every blob request also
+// carries the query parameter key=value.
+func WithRequestQuery(ctx context.Context, key, value string) context.Context {
+	extras := requestExtrasFrom(ctx).clone()
+	extras.query.Add(key, value)
+	return context.WithValue(ctx, requestExtrasKey{}, extras)
+}
+
+// requestExtrasPolicy applies the client-wide extras from
+// AzureBlobClientOptions, followed by any attached to the request context.
+type requestExtrasPolicy struct {
+	client requestExtras
+}
+
+func newRequestExtrasPolicy(opts *AzureBlobClientOptions) policy.Policy {
+	return requestExtrasPolicy{
+		client: requestExtras{header: opts.Headers, query: opts.Query},
+	}
+}
+
+func (p requestExtrasPolicy) Do(req *policy.Request) (*http.Response, error) {
+	raw := req.Raw()
+	for _, extras := range []requestExtras{p.client, requestExtrasFrom(raw.Context())} {
+		for k, v := range extras.header {
+			// Copy the values so later policies adding to the header cannot
+			// modify the shared client options.
+			raw.Header[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+		}
+		if len(extras.query) > 0 {
+			q := raw.URL.Query()
+			for k, v := range extras.query {
+				q[k] = append([]string(nil), v...)
+			}
+			raw.URL.RawQuery = q.Encode()
+		}
+	}
+	return req.Next()
+}
+
+// headerFlag collects repeated "Name: value" flags into an http.Header.
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	return fmt.Sprint(http.Header(h))
+}
+
+func (h headerFlag) Set(v string) error {
+	parts := strings.SplitN(v, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return fmt.Errorf("header %q is not in Name: value form", v)
+	}
+	http.Header(h).Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	return nil
+}
+
+// queryFlag collects repeated "key=value" flags into url.Values.
+type queryFlag url.Values
+
+func (q queryFlag) String() string {
+	return url.Values(q).Encode()
+}
+
+func (q queryFlag) Set(v string) error {
+	parts := strings.SplitN(v, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("query parameter %q is not in key=value form", v)
+	}
+	url.Values(q).Add(parts[0], parts[1])
+	return nil
+}
@@ -0,0 +1,123 @@
+package azureblob
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+type transporterFunc func(*http.Request) (*http.Response, error)
+
+func (f transporterFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// sendWithExtras sends a GET to endpoint through a pipeline holding the
+// extras policy for opts and returns the request that reached the transport.
+func sendWithExtras(t *testing.T, ctx context.Context, opts *AzureBlobClientOptions, endpoint string) *http.Request {
+	t.Helper()
+	var sent *http.Request
+	pl := runtime.NewPipeline("test", "v0", []policy.Policy{newRequestExtrasPolicy(opts)}, nil, &policy.ClientOptions{
+		Retry: policy.RetryOptions{MaxRetries: -1},
+		Transport: transporterFunc(func(req *http.Request) (*http.Response, error) {
+			sent = req
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody, Request: req}, nil
+		}),
+	})
+	req, err := runtime.NewRequest(ctx, http.MethodGet, endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pl.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	return sent
+}
+
+func TestRequestExtrasPolicy(t *testing.T) {
+	opts := &AzureBlobClientOptions{
+		Headers: http.Header{"X-Team": {"build"}, "X-Trace": {"client"}},
+		Query:   url.Values{"tag": {"client"}, "feature": {"preview"}},
+	}
+	ctx := WithRequestHeader(context.Background(), "x-trace", "context")
+	ctx = WithRequestQuery(ctx, "tag", "context")
+
+	sent := sendWithExtras(t, ctx, opts, "https://account.blob.core.windows.net/container/blob?comp=metadata&snapshot=s1")
+
+	wantHeader := map[string]string{"X-Team": "build", "X-Trace": "context"}
+	for k, want := range wantHeader {
+		if got := sent.Header.Values(k); len(got) != 1 || got[0] != want {
+			t.Errorf("header %s = %q, want [%q]", k, got, want)
+		}
+	}
+	wantQuery := url.Values{
+		"comp":     {"metadata"},
+		"snapshot": {"s1"},
+		"tag":      {"context"},
+		"feature":  {"preview"},
+	}
+	if got := sent.URL.Query(); !reflect.DeepEqual(got, wantQuery) {
+		t.Errorf("query = %v, want %v", got, wantQuery)
+	}
+}
+
+func TestRequestExtrasPolicyWithoutExtras(t *testing.T) {
+	const endpoint = "https://account.blob.core.windows.net/container/blob?comp=metadata"
+	sent := sendWithExtras(t, context.Background(), &AzureBlobClientOptions{}, endpoint)
+	if got := sent.URL.String(); got != endpoint {
+		t.Errorf("URL = %s, want it unchanged", got)
+	}
+}
+
+func TestWithRequestHeaderDoesNotModifyParent(t *testing.T) {
+	parent := WithRequestHeader(context.Background(), "X-A", "1")
+	WithRequestHeader(parent, "X-A", "2")
+	if got := requestExtrasFrom(parent).header.Values("X-A"); !reflect.DeepEqual(got, []string{"1"}) {
+		t.Errorf("parent header X-A = %q, want [1]", got)
+	}
+}
+
+func TestHeaderAndQueryFlags(t *testing.T) {
+	h := headerFlag{}
+	for _, v := range []string{"X-Team: build", "x-team:ci"} {
+		if err := h.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := http.Header(h).Values("X-Team"); !reflect.DeepEqual(got, []string{"build", "ci"}) {
+		t.Errorf("X-Team = %q, want [build ci]", got)
+	}
+	for _, v := range []string{"no colon", ": value"} {
+		if err := h.Set(v); err == nil {
+			t.Errorf("header %q accepted, want error", v)
+		}
+	}
+
+	q := queryFlag{}
+	if err := q.Set("sv=2020-10-02=x"); err != nil {
+		t.Fatal(err)
+	}
+	if got := url.Values(q).Get("sv"); got != "2020-10-02=x" {
+		t.Errorf("sv = %q, want 2020-10-02=x", got)
+	}
+	for _, v := range []string{"novalue", "=value"} {
+		if err := q.Set(v); err == nil {
+			t.Errorf("query parameter %q accepted, want error", v)
+		}
+	}
+}
+
+func TestRequestExtrasPolicyCopiesValues(t *testing.T) {
+	opts := &AzureBlobClientOptions{Headers: http.Header{"X-Team": make([]string, 1, 4)}}
+	opts.Headers["X-Team"][0] = "build"
+	sent := sendWithExtras(t, context.Background(), opts, "https://account.blob.core.windows.net/container/blob")
+	sent.Header.Add("X-Team", "added")
+	if got := opts.Headers["X-Team"]; !reflect.DeepEqual(got, []string{"build"}) || cap(got) != 4 || got[:2][1] != "" {
+		t.Errorf("client options changed to %q after a request header was added", got[:2])
+	}
+}
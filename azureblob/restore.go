@@ -0,0 +1,256 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	azruntime "github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+)
+
+const (
+	// managementEndpoint and managementScope are those of Azure Resource
+	// Manager, which restores run through, as they are not a data-plane
+	// operation.
+	managementEndpoint = "https://management.azure.com"
+	managementScope    = "https://management.azure.com/.default"
+	// restoreAPIVersion is the Microsoft.Storage API version of restore
+	// requests.
+	restoreAPIVersion = "2021-09-01"
+	// defaultRestorePoll is the time between checks of a restore's
+	// progress when the service does not suggest one.
+	defaultRestorePoll = 10 * time.Second
+)
+
+// RestoreOptions locates the storage account for RestorePrefix, which
+// Resource Manager addresses by subscription and resource group.
+type RestoreOptions struct {
+	SubscriptionID string
+	ResourceGroup  string
+	// PollInterval is the time between checks of the restore's progress
+	// if the service suggests none. Zero means 10 seconds.
+	PollInterval time.Duration
+}
+
+// restoreStatus is the state of a restore, as the restore request and the
+// operation it starts report it.
+type restoreStatus struct {
+	// Status is InProgress, Complete or Failed for the restore itself,
+	// and InProgress, Succeeded, Failed or Canceled for the operation.
+	Status        string `json:"status"`
+	RestoreID     string `json:"restoreId"`
+	FailureReason string `json:"failureReason"`
+	Error         *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+	Properties *restoreStatus `json:"properties"`
+}
+
+// restoreRange returns the range of blob names under prefix of container
+// in the form restores take: container/name, with the start included and
+// the end excluded.
+func restoreRange(container, prefix string) (start, end string) {
+	start = container + "/" + prefix
+	b := []byte(start)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return start, string(b[:i+1])
+		}
+	}
+	return start, ""
+}
+
+// RestorePrefix restores the blobs under prefix, or the whole container if
+// prefix is empty, to their state at at, and waits for the restore to
+// finish. Blobs created since are deleted, and deleted ones come back. The
+// account must have point-in-time restore enabled, with at inside its
+// restore window, and the identity needs permission to restore blob ranges
+// on the account, which Storage Account Contributor grants.
+func (c *AzureBlobClient) RestorePrefix(ctx context.Context, prefix string, at time.Time, opts RestoreOptions) error {
+	if opts.SubscriptionID == "" || opts.ResourceGroup == "" {
+		return errors.New("restoring needs the subscription and resource group of the storage account")
+	}
+	if err := c.init(ctx); err != nil {
+		return err
+	}
+	pl, err := c.managementPipeline()
+	if err != nil {
+		return err
+	}
+	start, end := restoreRange(c.ContainerName, prefix)
+	body, err := json.Marshal(map[string]interface{}{
+		"timeToRestore": at.UTC().Format(time.RFC3339),
+		"blobRanges":    []map[string]string{{"startRange": start, "endRange": end}},
+	})
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("%s/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Storage/storageAccounts/%s/restoreBlobRanges?api-version=%s",
+		managementEndpoint, url.PathEscape(opts.SubscriptionID), url.PathEscape(opts.ResourceGroup), url.PathEscape(c.StorageAccount), restoreAPIVersion)
+	req, err := azruntime.NewRequest(ctx, http.MethodPost, u)
+	if err != nil {
+		return err
+	}
+	if err := req.SetBody(streaming.NopCloser(bytes.NewReader(body)), "application/json"); err != nil {
+		return err
+	}
+	resp, status, err := restoreResponse(pl, req, http.StatusOK, http.StatusAccepted)
+	if err != nil {
+		return newBlobError("restore", start, err)
+	}
+	log.Print(c.Messages.format(MsgRestoreStarted, start, at.UTC().Format(time.RFC3339), status.RestoreID))
+	interval := opts.PollInterval
+	if interval <= 0 {
+		interval = defaultRestorePoll
+	}
+	poll := resp.Header.Get("Azure-AsyncOperation")
+	if poll == "" {
+		poll = resp.Header.Get("Location")
+	}
+	for !status.done() {
+		if poll == "" {
+			return fmt.Errorf("restore %s: the service gave no way to follow its progress", start)
+		}
+		wait := interval
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+			wait = time.Duration(s) * time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		req, err := azruntime.NewRequest(ctx, http.MethodGet, poll)
+		if err != nil {
+			return err
+		}
+		if resp, status, err = restoreResponse(pl, req, http.StatusOK, http.StatusAccepted); err != nil {
+			return newBlobError("restore", start, err)
+		}
+	}
+	if err := status.err(); err != nil {
+		return fmt.Errorf("restore %s: %w", start, err)
+	}
+	log.Print(c.Messages.format(MsgRestoreComplete, start))
+	return nil
+}
+
+// restoreResponse sends req through pl and decodes the restore status of
+// the response, which must have one of the statuses ok. A 202 without a
+// body is a restore still in progress.
+func restoreResponse(pl azruntime.Pipeline, req *policy.Request, ok ...int) (*http.Response, *restoreStatus, error) {
+	resp, err := pl.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !azruntime.HasStatusCode(resp, ok...) {
+		return nil, nil, azruntime.NewResponseError(errors.New(http.StatusText(resp.StatusCode)), resp)
+	}
+	defer resp.Body.Close()
+	status := &restoreStatus{}
+	if err := json.NewDecoder(resp.Body).Decode(status); err != nil && resp.StatusCode != http.StatusAccepted {
+		return nil, nil, fmt.Errorf("read restore status: %w", err)
+	}
+	if status.Properties != nil {
+		status.Status, status.FailureReason = status.Properties.Status, status.Properties.FailureReason
+	}
+	if status.Status == "" && resp.StatusCode == http.StatusOK {
+		status.Status = "Complete"
+	} else if status.Status == "" {
+		status.Status = "InProgress"
+	}
+	return resp, status, nil
+}
+
+// done reports whether the restore or its operation has finished.
+func (s *restoreStatus) done() bool {
+	return s.Status != "InProgress" && s.Status != "Running" && s.Status != "Accepted"
+}
+
+// err returns why a finished restore failed, or nil if it succeeded.
+func (s *restoreStatus) err() error {
+	switch {
+	case s.Status == "Complete" || s.Status == "Succeeded":
+		return nil
+	case s.FailureReason != "":
+		return fmt.Errorf("%s: %s", s.Status, s.FailureReason)
+	case s.Error != nil:
+		return fmt.Errorf("%s: %s: %s", s.Status, s.Error.Code, s.Error.Message)
+	}
+	return errors.New(s.Status)
+}
+
+// managementPipeline returns a pipeline for Resource Manager requests, with
+// the transport and telemetry of the container client, a token for
+// Resource Manager and the metadata retry policy. c must be initialised.
+func (c *AzureBlobClient) managementPipeline() (azruntime.Pipeline, error) {
+	transport, err := c.blobTransporter()
+	if err != nil {
+		return azruntime.Pipeline{}, err
+	}
+	telemetry, err := c.clientOptions().telemetry()
+	if err != nil {
+		return azruntime.Pipeline{}, err
+	}
+	c.initMu.Lock()
+	given := c.credential
+	c.initMu.Unlock()
+	if given == nil {
+		return azruntime.Pipeline{}, errors.New("Resource Manager takes Azure AD tokens, not the -sas blob requests are authorized by")
+	}
+	credential := *given
+	perCall := []policy.Policy{newBearerTokenPolicy(credential, []string{managementScope}, c.Messages)}
+	return azruntime.NewPipeline("bk_azureblob", "v1", perCall, nil, &policy.ClientOptions{
+		Transport: transport,
+		Retry:     c.clientOptions().MetadataRetry,
+		Telemetry: telemetry,
+	}), nil
+}
+
+func runRestore(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	to := fs.String("to", "", "restore the blobs to their state at an RFC 3339 `time`")
+	ago := fs.Duration("ago", 0, "restore the blobs to their state `duration` ago, e.g. 2h")
+	subscription := fs.String("subscription", os.Getenv("AZURE_SUBSCRIPTION_ID"), "`ID` of the subscription holding the storage account (default $AZURE_SUBSCRIPTION_ID)")
+	group := fs.String("resource-group", "", "resource `group` holding the storage account")
+	poll := fs.Duration("poll-interval", defaultRestorePoll, "time between checks of the restore's progress")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: restore (-to <time> | -ago <duration>) -resource-group <group> [flags] [prefix]\n\nWithout a prefix the whole container is restored.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 || (*to == "") == (*ago == 0) {
+		fs.Usage()
+		return errors.New("restore takes either -to or -ago, and optionally a prefix")
+	}
+	at := time.Now().Add(-*ago)
+	if *to != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, *to); err != nil {
+			return fmt.Errorf("-to: %w", err)
+		}
+	}
+	if !at.Before(time.Now()) {
+		return fmt.Errorf("cannot restore to %s, which is not in the past", at.Format(time.RFC3339))
+	}
+	return az.RestorePrefix(ctx, fs.Arg(0), at, RestoreOptions{
+		SubscriptionID: *subscription,
+		ResourceGroup:  *group,
+		PollInterval:   *poll,
+	})
+}
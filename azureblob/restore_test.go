@@ -0,0 +1,93 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestoreRange(t *testing.T) {
+	for _, tc := range []struct{ prefix, start, end string }{
+		{"", "container/", "container0"},
+		{"releases/", "container/releases/", "container/releases0"},
+		{"app-1", "container/app-1", "container/app-2"},
+	} {
+		if start, end := restoreRange("container", tc.prefix); start != tc.start || end != tc.end {
+			t.Errorf("restoreRange(%q) = %q, %q; want %q, %q", tc.prefix, start, end, tc.start, tc.end)
+		}
+	}
+}
+
+// restoreServer answers a restore request with an operation that reports
+// progress once and then final.
+func restoreServer(t *testing.T, final string, body *map[string]interface{}) http.Handler {
+	polls := 0
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "management.azure.com" {
+			t.Errorf("request to %s", r.Host)
+		}
+		switch {
+		case r.Method == http.MethodPost:
+			if r.URL.Path != "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/account/restoreBlobRanges" {
+				t.Errorf("restore posted to %s", r.URL.Path)
+			}
+			json.NewDecoder(r.Body).Decode(body)
+			w.Header().Set("Azure-AsyncOperation", "https://management.azure.com/operations/1")
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprint(w, `{"status":"InProgress","restoreId":"r1"}`)
+		case r.URL.Path == "/operations/1":
+			polls++
+			status := "InProgress"
+			if polls > 1 {
+				status = final
+			}
+			fmt.Fprintf(w, `{"status":%q,"error":{"code":"RestoreFailed","message":"blob is immutable"}}`, status)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+func TestRestorePrefix(t *testing.T) {
+	var body map[string]interface{}
+	az := newTestClient(t, restoreServer(t, "Succeeded", &body))
+	at := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	opts := RestoreOptions{SubscriptionID: "sub", ResourceGroup: "rg", PollInterval: time.Millisecond}
+	if err := az.RestorePrefix(context.Background(), "releases/", at, opts); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(body)
+	if want := `{"blobRanges":[{"endRange":"container/releases0","startRange":"container/releases/"}],"timeToRestore":"2026-01-02T15:04:05Z"}`; string(b) != want {
+		t.Errorf("restore request %s, want %s", b, want)
+	}
+}
+
+func TestRestorePrefixFailed(t *testing.T) {
+	var body map[string]interface{}
+	az := newTestClient(t, restoreServer(t, "Failed", &body))
+	opts := RestoreOptions{SubscriptionID: "sub", ResourceGroup: "rg", PollInterval: time.Millisecond}
+	err := az.RestorePrefix(context.Background(), "", time.Now().Add(-time.Hour), opts)
+	if err == nil || !strings.Contains(err.Error(), "Failed: RestoreFailed: blob is immutable") {
+		t.Errorf("failed restore returned %v", err)
+	}
+	if err := az.RestorePrefix(context.Background(), "", time.Now(), RestoreOptions{}); err == nil {
+		t.Error("restored without a resource group")
+	}
+}
+
+func TestRunRestoreChecksTime(t *testing.T) {
+	az := newTestClient(t, http.NotFoundHandler())
+	for _, args := range [][]string{
+		{"-resource-group", "rg"},
+		{"-resource-group", "rg", "-to", "2026-01-02T15:04:05Z", "-ago", "1h"},
+		{"-resource-group", "rg", "-to", time.Now().Add(time.Hour).Format(time.RFC3339)},
+	} {
+		if err := runRestore(context.Background(), az, args); err == nil {
+			t.Errorf("restore %v succeeded", args)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// defaultResumeChunkSize is the chunk size of resumable downloads and
+// uploads.
+const defaultResumeChunkSize = 8 << 20
+
+func (o *AzureBlobClientOptions) resumeChunkSize() int64 {
+	if o.ResumeChunkSize > 0 {
+		return o.ResumeChunkSize
+	}
+	return defaultResumeChunkSize
+}
+
+// DownloadState records which chunks of a resumable download are complete.
+// It identifies the blob by name, ETag and size only and holds no local
+// paths, so a state file can be copied to another machine together with the
+// partial destination file and resumed there.
+type DownloadState struct {
+	Blob      string `json:"blob"`
+	ETag      string `json:"etag"`
+	Size      int64  `json:"size"`
+	ChunkSize int64  `json:"chunkSize"`
+	// Done marks each completed chunk by index.
+	Done []bool `json:"done"`
+	// CRC32C is the CRC-32C of each completed chunk as it was written, by
+	// which a resumed download checks that the destination still holds
+	// it.
+	CRC32C []uint32 `json:"crc32c,omitempty"`
+}
+
+// crc32cTable is the Castagnoli table of DownloadState.CRC32C.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func (s *DownloadState) chunks() int {
+	return int((s.Size + s.ChunkSize - 1) / s.ChunkSize)
+}
+
+// Remaining returns the number of bytes not yet downloaded.
+func (s *DownloadState) Remaining() int64 {
+	var n int64
+	for i, done := range s.Done {
+		if !done {
+			n += s.chunkLength(i)
+		}
+	}
+	return n
+}
+
+func (s *DownloadState) chunkLength(i int) int64 {
+	if end := int64(i+1) * s.ChunkSize; end > s.Size {
+		return s.Size - int64(i)*s.ChunkSize
+	}
+	return s.ChunkSize
+}
+
+// loadDownloadState reads the state file at path, returning nil if it does
+// not exist.
+func loadDownloadState(path string) (*DownloadState, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &DownloadState{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("parse download state %s: %w", path, err)
+	}
+	if s.ChunkSize <= 0 || len(s.Done) != s.chunks() || (s.CRC32C != nil && len(s.CRC32C) != len(s.Done)) {
+		return nil, fmt.Errorf("download state %s is inconsistent", path)
+	}
+	if s.CRC32C == nil {
+		// Chunks recorded without a CRC cannot be checked, so they are
+		// fetched again.
+		s.CRC32C = make([]uint32, len(s.Done))
+		for i := range s.Done {
+			s.Done[i] = false
+		}
+	}
+	return s, nil
+}
+
+// checkDestination checks the chunks s marks as done against the file at
+// destination, marking those it no longer holds as not done. It returns
+// why the whole state cannot be trusted, if it cannot: the file is missing
+// or does not have the blob's size, as when the state file was copied
+// without it. It returns how many chunks it marked otherwise.
+func (s *DownloadState) checkDestination(destination string) (stale int, reason string, err error) {
+	f, err := os.Open(destination)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, "the destination does not exist", nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, "", err
+	}
+	if info.Size() != s.Size {
+		return 0, fmt.Sprintf("the destination has %d bytes instead of %d", info.Size(), s.Size), nil
+	}
+	for i, done := range s.Done {
+		if !done {
+			continue
+		}
+		h := crc32.New(crc32cTable)
+		if _, err := copyPooled(h, io.NewSectionReader(f, int64(i)*s.ChunkSize, s.chunkLength(i))); err != nil {
+			return 0, "", err
+		}
+		if h.Sum32() != s.CRC32C[i] {
+			s.Done[i] = false
+			stale++
+		}
+	}
+	return stale, "", nil
+}
+
+// save writes s to path atomically, so an interrupted save never leaves a
+// state file that claims chunks which were not written.
+func (s *DownloadState) save(path string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+// writeFileAtomic writes b to a temporary file next to path and renames it
+// into place.
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// DownloadResumable downloads asset to destination in chunks, fetched
+// concurrently as bounded by c.Pool, recording completed chunks and their
+// CRC-32C in the state file at statePath. If the state file exists, only
+// the chunks it does not mark as done are fetched. The state is discarded,
+// and the download started over, if the blob's ETag or size has changed or
+// the destination is missing or of another size. Chunks marked as done
+// whose CRC the destination no longer matches are fetched again. Once the
+// download is complete, a blob with a Content-MD5 is checked against it,
+// and the state file is removed. Client-side encrypted or compressed blobs
+// and fallbacks are not supported.
+func (c *AzureBlobClient) DownloadResumable(ctx context.Context, asset, destination, statePath string) error {
+	props, err := c.Stat(ctx, asset)
+	if err != nil {
+		return err
+	}
+	if target := metadataValue(props.Metadata, linkTargetMetadataKey); target != "" {
+		return c.restoreSymlink(ctx, asset, destination, target)
+	}
+	if _, _, ok := findEncryptionData(props.Metadata); ok {
+		return fmt.Errorf("download %q: resumable downloads of client-side encrypted blobs are not supported", asset)
+	}
+	if c.clientOptions().downloadCompression(props) != "" {
+		return fmt.Errorf("download %q: resumable downloads of compressed blobs are not supported", asset)
+	}
+	state, err := loadDownloadState(statePath)
+	if err != nil {
+		return err
+	}
+	if state != nil && state.Blob != asset {
+		return fmt.Errorf("download state %s is for %s, not %s; delete it or name another -state file", statePath, state.Blob, asset)
+	}
+	if state != nil && (state.ETag != props.ETag || state.Size != props.Size) {
+		log.Print(c.Messages.format(MsgResumeDiscarded, asset, statePath, fmt.Sprintf("the blob changed from ETag %s to %s", state.ETag, props.ETag)))
+		state = nil
+	}
+	if state != nil {
+		stale, reason, err := state.checkDestination(destination)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			log.Print(c.Messages.format(MsgResumeDiscarded, asset, statePath, reason))
+			state = nil
+		} else if stale > 0 {
+			log.Print(c.Messages.format(MsgResumeRefetch, asset, stale))
+		}
+	}
+	if state == nil {
+		state = &DownloadState{Blob: asset, ETag: props.ETag, Size: props.Size, ChunkSize: c.clientOptions().resumeChunkSize()}
+		state.Done = make([]bool, state.chunks())
+		state.CRC32C = make([]uint32, len(state.Done))
+	}
+	// The chunks already fetched hold their space.
+	if err := checkSpace(filepath.Dir(destination), state.Remaining()); err != nil {
+		return fmt.Errorf("download %q: %w", asset, err)
+	}
+	f, err := os.OpenFile(destination, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := c.allocate(f, state.Size); err != nil {
+		return err
+	}
+	if err := state.save(statePath); err != nil {
+		return err
+	}
+
+	var pending []int
+	for i, done := range state.Done {
+		if !done {
+			pending = append(pending, i)
+		}
+	}
+	tracker := c.beginTransfer(ctx, state.Remaining())
+	defer tracker.finish()
+	var (
+		mu          sync.Mutex
+		transferred int64
+	)
+	blob := c.containerClient.NewBlobClient(asset)
+	errs := c.Pool.Run(ctx, len(pending), func(ctx context.Context, i int) error {
+		chunk := pending[i]
+		offset, count := int64(chunk)*state.ChunkSize, state.chunkLength(chunk)
+		var sum uint32
+		err := c.withTransferDeadline(ctx, "download", asset, count, func(ctx context.Context) error {
+			var err error
+			sum, err = c.fetchRange(ctx, blob, state.ETag, offset, count, f)
+			return err
+		})
+		if err != nil {
+			return newBlobError("download", asset, err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		state.Done[chunk], state.CRC32C[chunk] = true, sum
+		transferred += count
+		if tracker != nil {
+			tracker.update(transferred)
+		}
+		return state.save(statePath)
+	})
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if len(props.ContentMD5) > 0 {
+		sums, err := fileDigests(destination, []string{"md5"})
+		if err != nil {
+			return err
+		}
+		if want := hex.EncodeToString(props.ContentMD5); sums["md5"] != want {
+			// The chunks cannot tell which of them is wrong.
+			os.Remove(statePath)
+			return &ChecksumError{Path: destination, Algorithm: "md5", Got: sums["md5"], Want: want}
+		}
+	}
+	if o := c.clientOptions(); o.PreserveAttributes {
+		if err := restoreAttributes(destination, props.Metadata, o.PreserveOwner); err != nil {
+			return err
+		}
+	}
+	return os.Remove(statePath)
+}
+
+// fetchRange writes count bytes of blob from offset into f at the same
+// offset, and returns their CRC-32C.
+func (c *AzureBlobClient) fetchRange(ctx context.Context, blob azblob.BlobClient, etag string, offset, count int64, f *os.File) (uint32, error) {
+	body, err := c.openRange(ctx, blob, etag, offset, count)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	h := crc32.New(crc32cTable)
+	n, err := copyPooled(io.MultiWriter(&offsetWriter{f: f, offset: offset}, h), io.LimitReader(body, count))
+	if err == nil && n != count {
+		err = fmt.Errorf("range at %d ended after %d of %d bytes", offset, n, count)
+	}
+	return h.Sum32(), err
+}
+
+// openRange starts a download of count bytes of blob from offset. The
+// request is conditional on etag so ranges of different versions of the
+// blob are never mixed.
+func (c *AzureBlobClient) openRange(ctx context.Context, blob azblob.BlobClient, etag string, offset, count int64) (io.ReadCloser, error) {
+	resp, err := blob.Download(ctx, &azblob.DownloadBlobOptions{
+		Offset: &offset,
+		Count:  &count,
+		BlobAccessConditions: &azblob.BlobAccessConditions{
+			ModifiedAccessConditions: &azblob.ModifiedAccessConditions{IfMatch: &etag},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body(azblob.RetryReaderOptions{MaxRetryRequests: 3}), nil
+}
+
+// offsetWriter writes sequentially to f starting at offset.
+type offsetWriter struct {
+	f      *os.File
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
@@ -0,0 +1,243 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// rangeHandler serves m, failing ranged downloads that start at or after
+// failFrom, and records the start of every range requested.
+type rangeHandler struct {
+	m        *memContainer
+	failFrom int
+	mu       sync.Mutex
+	starts   []int
+}
+
+func (h *rangeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var start, end int
+	if _, err := fmt.Sscanf(r.Header.Get("x-ms-range"), "bytes=%d-%d", &start, &end); err == nil {
+		h.mu.Lock()
+		h.starts = append(h.starts, start)
+		h.mu.Unlock()
+		if h.failFrom >= 0 && start >= h.failFrom {
+			w.Header().Set("x-ms-error-code", "ServerBusy")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}
+	h.m.ServeHTTP(w, r)
+}
+
+func newResumeClient(t *testing.T, h http.Handler) *AzureBlobClient {
+	az := newTestClient(t, h)
+	az.ClientOptions.ResumeChunkSize = 10
+	az.Pool = NewTransferPool(2, 4)
+	return az
+}
+
+func TestDownloadResumable(t *testing.T) {
+	m := newMemContainer()
+	data := bytes.Repeat([]byte("0123456789abcdefghij"), 5)[:95]
+	m.put("blob", data, nil)
+	dir := t.TempDir()
+	dest, state := filepath.Join(dir, "blob"), filepath.Join(dir, "blob.state")
+	az := newResumeClient(t, m)
+	if err := az.DownloadResumable(context.Background(), "blob", dest, state); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Errorf("downloaded %q, want %q", got, data)
+	}
+	if _, err := os.Stat(state); !os.IsNotExist(err) {
+		t.Error("state file was not removed after the download completed")
+	}
+}
+
+func TestDownloadResumableOnAnotherMachine(t *testing.T) {
+	m := newMemContainer()
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	m.put("blob", data, nil)
+
+	// The first machine gets the chunks before offset 50 and then fails.
+	first := t.TempDir()
+	dest, state := filepath.Join(first, "blob"), filepath.Join(first, "blob.state")
+	failing := &rangeHandler{m: m, failFrom: 50}
+	if err := newResumeClient(t, failing).DownloadResumable(context.Background(), "blob", dest, state); err == nil {
+		t.Fatal("download succeeded although chunks failed")
+	}
+	saved, err := loadDownloadState(state)
+	if err != nil || saved == nil {
+		t.Fatalf("state after failure: %v, %v", saved, err)
+	}
+	if got, want := saved.Remaining(), int64(50); got != want {
+		t.Errorf("%d bytes remaining, want %d", got, want)
+	}
+
+	// Copy the partial download and its state to a second machine.
+	second := t.TempDir()
+	for _, name := range []string{"blob", "blob.state"} {
+		b, err := os.ReadFile(filepath.Join(first, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(second, name), string(b))
+	}
+	healthy := &rangeHandler{m: m, failFrom: -1}
+	dest, state = filepath.Join(second, "blob"), filepath.Join(second, "blob.state")
+	if err := newResumeClient(t, healthy).DownloadResumable(context.Background(), "blob", dest, state); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Errorf("resumed download is %q, want %q", got, data)
+	}
+	for _, start := range healthy.starts {
+		if start < 50 {
+			t.Errorf("chunk at %d was fetched again", start)
+		}
+	}
+	if len(healthy.starts) != 5 {
+		t.Errorf("fetched %d chunks, want the 5 missing ones", len(healthy.starts))
+	}
+}
+
+func TestDownloadResumableRejects(t *testing.T) {
+	m := newMemContainer()
+	m.put("blob", []byte("version one"), nil)
+	r := mustKeyRing(t, "k1", map[string][]byte{"k1": testKey(1)})
+	ciphertext, metadata := encryptV2(t, r, keyWrapAES, []byte("secret"), 16)
+	m.put("secret", ciphertext, metadata)
+	az := newResumeClient(t, m)
+	dir := t.TempDir()
+
+	other := filepath.Join(dir, "other.state")
+	(&DownloadState{Blob: "other", ETag: `"old"`, Size: 11, ChunkSize: 10, Done: []bool{true, false}}).save(other)
+	inconsistent := filepath.Join(dir, "inconsistent.state")
+	writeFile(t, inconsistent, `{"blob":"blob","size":11,"chunkSize":10,"done":[true]}`)
+
+	tests := []struct {
+		name, blob, state, wantErr string
+	}{
+		{"another blob", "blob", other, "not blob"},
+		{"inconsistent state", "blob", inconsistent, "inconsistent"},
+		{"encrypted", "secret", filepath.Join(dir, "secret.state"), "encrypted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := az.DownloadResumable(context.Background(), tt.blob, filepath.Join(dir, tt.blob), tt.state)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDownloadResumableDiscardsState(t *testing.T) {
+	m := newMemContainer()
+	data := bytes.Repeat([]byte("0123456789"), 3)
+	m.put("blob", data, nil)
+	etag := m.blobs["blob"].etag
+	done := func() *DownloadState {
+		s := &DownloadState{Blob: "blob", ETag: etag, Size: 30, ChunkSize: 10, Done: []bool{true, true, false}, CRC32C: make([]uint32, 3)}
+		for i := 0; i < 2; i++ {
+			s.CRC32C[i] = crc32.Checksum(data[i*10:(i+1)*10], crc32cTable)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name string
+		// dest is the partial download, or "" for none.
+		dest  string
+		state func() *DownloadState
+		// refetched is how many chunks are fetched.
+		refetched int
+	}{
+		{"missing destination", "", done, 3},
+		{"short destination", string(data[:20]), done, 3},
+		{"changed blob", string(data), func() *DownloadState {
+			s := done()
+			s.ETag = `"old"`
+			return s
+		}, 3},
+		{"overwritten chunk", "01234xxxxx" + string(data[10:]), done, 2},
+		{"no CRCs", string(data[:20]) + strings.Repeat("\x00", 10), func() *DownloadState {
+			s := done()
+			s.CRC32C = nil
+			return s
+		}, 3},
+		{"intact", string(data[:20]) + strings.Repeat("\x00", 10), done, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			dest, state := filepath.Join(dir, "blob"), filepath.Join(dir, "blob.state")
+			if tt.dest != "" {
+				writeFile(t, dest, tt.dest)
+			}
+			if err := tt.state().save(state); err != nil {
+				t.Fatal(err)
+			}
+			h := &rangeHandler{m: m, failFrom: -1}
+			if err := newResumeClient(t, h).DownloadResumable(context.Background(), "blob", dest, state); err != nil {
+				t.Fatal(err)
+			}
+			if got := readFile(t, dest); got != string(data) {
+				t.Errorf("downloaded %q, want %q", got, data)
+			}
+			if len(h.starts) != tt.refetched {
+				t.Errorf("fetched chunks at %v, want %d chunks", h.starts, tt.refetched)
+			}
+		})
+	}
+}
+
+func TestDownloadResumableChecksMD5(t *testing.T) {
+	m := newMemContainer()
+	m.put("blob", bytes.Repeat([]byte("0123456789"), 3), nil)
+	// Serve a chunk that differs from the blob whose Content-MD5 is
+	// reported.
+	corrupt := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, r)
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(bytes.ReplaceAll(rec.Body.Bytes(), []byte("5"), []byte("x")))
+	})
+	dir := t.TempDir()
+	dest, state := filepath.Join(dir, "blob"), filepath.Join(dir, "blob.state")
+	err := newResumeClient(t, corrupt).DownloadResumable(context.Background(), "blob", dest, state)
+	var checksum *ChecksumError
+	if !errors.As(err, &checksum) || checksum.Algorithm != "md5" {
+		t.Errorf("got %v, want a checksum error", err)
+	}
+	if _, err := os.Stat(state); !os.IsNotExist(err) {
+		t.Error("the state of a corrupt download was kept")
+	}
+}
+
+func TestRunDownloadStateFlags(t *testing.T) {
+	az := newTestClient(t, newMemContainer())
+	for _, args := range [][]string{
+		{"-chunk-size", "1MiB", "a", "b"},
+		{"-state", "s", "a", "b", "c"},
+		{"-state", "s", "-fallback-container", "old", "a", "b"},
+		{"-state", "s", "-chunk-size", "0", "a", "b"},
+	} {
+		if err := runDownload(context.Background(), az, args); err == nil {
+			t.Errorf("download %q succeeded", args)
+		}
+	}
+}
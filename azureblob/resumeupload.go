@@ -0,0 +1,232 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// maxBlocks is the most blocks a block blob can be committed from.
+const maxBlocks = 50000
+
+// newUploadID returns a random prefix for the block IDs of one upload.
+// Block IDs unique to an upload keep blocks staged by another upload of the
+// same blob out of its commit.
+func newUploadID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", b), nil
+}
+
+// blockID returns the ID of block i of the upload with the given ID.
+func blockID(upload string, i int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s%08d", upload, i)))
+}
+
+// UploadState records which blocks of a resumable upload are staged. It
+// identifies the file by size and modification time only and holds no
+// local paths, like DownloadState.
+type UploadState struct {
+	Blob      string    `json:"blob"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"modTime"`
+	ChunkSize int64     `json:"chunkSize"`
+	// Upload prefixes the IDs of the blocks.
+	Upload string `json:"upload"`
+	// Staged marks each staged block by index.
+	Staged []bool `json:"staged"`
+}
+
+func (s *UploadState) chunks() int {
+	return int((s.Size + s.ChunkSize - 1) / s.ChunkSize)
+}
+
+// Remaining returns the number of bytes not yet staged.
+func (s *UploadState) Remaining() int64 {
+	var n int64
+	for i, staged := range s.Staged {
+		if !staged {
+			n += s.chunkLength(i)
+		}
+	}
+	return n
+}
+
+func (s *UploadState) chunkLength(i int) int64 {
+	if end := int64(i+1) * s.ChunkSize; end > s.Size {
+		return s.Size - int64(i)*s.ChunkSize
+	}
+	return s.ChunkSize
+}
+
+// loadUploadState reads the state file at path, returning nil if it does
+// not exist.
+func loadUploadState(path string) (*UploadState, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &UploadState{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("parse upload state %s: %w", path, err)
+	}
+	if s.ChunkSize <= 0 || s.Upload == "" || len(s.Staged) != s.chunks() {
+		return nil, fmt.Errorf("upload state %s is inconsistent", path)
+	}
+	return s, nil
+}
+
+// save writes s to path atomically, so an interrupted save never leaves a
+// state file that claims blocks which were not staged.
+func (s *UploadState) save(path string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+// UploadResumable uploads file to blobPath in blocks, staged concurrently as
+// bounded by c.Pool, recording staged blocks in the state file at
+// statePath. If the state file exists, only the blocks it does not mark as
+// staged are sent, provided it describes the same blob and the file has not
+// changed since; blocks the service no longer holds, because they expired
+// after a week or the blob was written since, are sent again. The blocks
+// are committed once all are staged, and the state file is then removed.
+// Client-side compressed or encrypted uploads are not supported.
+func (c *AzureBlobClient) UploadResumable(ctx context.Context, file *os.File, blobPath, statePath string) error {
+	if o := c.clientOptions(); o.Compression != "" || o.EncryptUploads {
+		return fmt.Errorf("upload %q: resumable uploads cannot be compressed or encrypted client-side", blobPath)
+	}
+	if err := c.init(ctx); err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	state, err := loadUploadState(statePath)
+	if err != nil {
+		return err
+	}
+	blob := c.containerClient.NewBlockBlobClient(blobPath)
+	if state == nil {
+		upload, err := newUploadID()
+		if err != nil {
+			return err
+		}
+		state = &UploadState{Blob: blobPath, Size: info.Size(), ModTime: info.ModTime(), ChunkSize: c.clientOptions().resumeChunkSize(), Upload: upload}
+		state.Staged = make([]bool, state.chunks())
+	} else if state.Blob != blobPath || state.Size != info.Size() || !state.ModTime.Equal(info.ModTime()) {
+		return fmt.Errorf("upload state %s is for %s of %d bytes modified %s, but %s is %d bytes modified %s; delete it to start over",
+			statePath, state.Blob, state.Size, state.ModTime, file.Name(), info.Size(), info.ModTime())
+	} else if err := c.checkStaged(ctx, blob, state); err != nil {
+		return err
+	}
+	if state.chunks() > maxBlocks {
+		return fmt.Errorf("upload %q: %d blocks of %s exceed the limit of %d; use a larger chunk size", blobPath, state.chunks(), formatBytes(state.ChunkSize), maxBlocks)
+	}
+	if err := state.save(statePath); err != nil {
+		return err
+	}
+
+	var pending []int
+	for i, staged := range state.Staged {
+		if !staged {
+			pending = append(pending, i)
+		}
+	}
+	tracker := c.beginTransfer(ctx, state.Remaining())
+	defer tracker.finish()
+	var (
+		mu          sync.Mutex
+		transferred int64
+	)
+	scope := c.clientOptions().cpkScopeInfo()
+	errs := c.Pool.Run(ctx, len(pending), func(ctx context.Context, i int) error {
+		chunk := pending[i]
+		pooled := getBuffer(int(state.ChunkSize))
+		defer putBuffer(pooled)
+		buf := (*pooled)[:state.chunkLength(chunk)]
+		if _, err := file.ReadAt(buf, int64(chunk)*state.ChunkSize); err != nil {
+			return err
+		}
+		err := c.withTransferDeadline(ctx, "upload", blobPath, int64(len(buf)), func(ctx context.Context) error {
+			body := streaming.NopCloser(bytes.NewReader(buf))
+			_, err := blob.StageBlock(ctx, blockID(state.Upload, chunk), body, &azblob.StageBlockOptions{CpkScopeInfo: scope})
+			return err
+		})
+		if err != nil {
+			return newBlobError("upload", blobPath, err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		state.Staged[chunk] = true
+		transferred += int64(len(buf))
+		if tracker != nil {
+			tracker.update(transferred)
+		}
+		return state.save(statePath)
+	})
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	ids := make([]string, state.chunks())
+	for i := range ids {
+		ids[i] = blockID(state.Upload, i)
+	}
+	var metadata map[string]string
+	if o := c.clientOptions(); o.PreserveAttributes {
+		metadata = fileAttributes(info, o.PreserveOwner)
+	}
+	_, err = blob.CommitBlockList(ctx, ids, &azblob.CommitBlockListOptions{Metadata: metadata, CpkScopeInfo: scope})
+	if err != nil {
+		return newBlobError("commit", blobPath, err)
+	}
+	if err := c.protectUpload(ctx, blobPath); err != nil {
+		return err
+	}
+	return os.Remove(statePath)
+}
+
+// checkStaged clears the blocks of state that blob no longer holds staged.
+func (c *AzureBlobClient) checkStaged(ctx context.Context, blob azblob.BlockBlobClient, state *UploadState) error {
+	held := map[string]int64{}
+	resp, err := blob.GetBlockList(ctx, azblob.BlockListTypeUncommitted, nil)
+	// A blob with neither committed nor staged blocks does not exist.
+	if err = newBlobError("list blocks", state.Blob, err); err != nil && !isNotFound(err) {
+		return err
+	}
+	if err == nil {
+		for _, b := range resp.UncommittedBlocks {
+			if b.Name != nil && b.Size != nil {
+				held[*b.Name] = *b.Size
+			}
+		}
+	}
+	for i, staged := range state.Staged {
+		if size, ok := held[blockID(state.Upload, i)]; staged && (!ok || size != state.chunkLength(i)) {
+			state.Staged[i] = false
+		}
+	}
+	return nil
+}
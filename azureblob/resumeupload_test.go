@@ -0,0 +1,159 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// stageHandler serves m, failing the stage requests after the first
+// allowed ones, or every one if allowed is negative.
+type stageHandler struct {
+	m       *memContainer
+	allowed int
+	mu      sync.Mutex
+	staged  int
+}
+
+func (h *stageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("comp") == "block" && h.allowed >= 0 {
+		h.mu.Lock()
+		fail := h.staged >= h.allowed
+		h.staged++
+		h.mu.Unlock()
+		if fail {
+			w.Header().Set("x-ms-error-code", "ServerBusy")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}
+	h.m.ServeHTTP(w, r)
+}
+
+func openFile(t *testing.T, path string) *os.File {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestUploadResumable(t *testing.T) {
+	m := newMemContainer()
+	data := bytes.Repeat([]byte("0123456789abcdefghij"), 5)[:95]
+	dir := t.TempDir()
+	src, state := writeFile(t, filepath.Join(dir, "src"), string(data)), filepath.Join(dir, "src.state")
+	az := newResumeClient(t, m)
+	az.ClientOptions.PreserveAttributes = true
+	if err := az.UploadResumable(context.Background(), openFile(t, src), "blob", state); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.blobs["blob"]; !bytes.Equal(got.data, data) || got.metadata[modeMetadataKey] == "" {
+		t.Errorf("uploaded %q with metadata %v", got.data, got.metadata)
+	}
+	if m.stages != 10 {
+		t.Errorf("staged %d blocks, want 10", m.stages)
+	}
+	if _, err := os.Stat(state); !os.IsNotExist(err) {
+		t.Error("state file was not removed after the upload completed")
+	}
+}
+
+func TestUploadResumableResumes(t *testing.T) {
+	m := newMemContainer()
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	dir := t.TempDir()
+	src, state := writeFile(t, filepath.Join(dir, "src"), string(data)), filepath.Join(dir, "src.state")
+	ctx := context.Background()
+
+	failing := &stageHandler{m: m, allowed: 4}
+	az := newResumeClient(t, failing)
+	az.Pool = NewTransferPool(1, 1)
+	if err := az.UploadResumable(ctx, openFile(t, src), "blob", state); err == nil {
+		t.Fatal("upload succeeded although blocks failed")
+	}
+	saved, err := loadUploadState(state)
+	if err != nil || saved == nil {
+		t.Fatalf("state after failure: %v, %v", saved, err)
+	}
+	if got := saved.Remaining(); got != 60 {
+		t.Errorf("%d bytes remaining, want 60", got)
+	}
+	if _, ok := m.blobs["blob"]; ok {
+		t.Error("a failed upload committed the blob")
+	}
+
+	// One of the staged blocks was lost, e.g. because it expired.
+	delete(m.blocks["blob"], blockID(saved.Upload, 0))
+	m.stages = 0
+	if err := newResumeClient(t, m).UploadResumable(ctx, openFile(t, src), "blob", state); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.blobs["blob"]; got == nil || !bytes.Equal(got.data, data) {
+		t.Fatalf("resumed upload is %v, want %q", got, data)
+	}
+	if m.stages != 7 {
+		t.Errorf("staged %d blocks, want the 6 missing ones and the lost one", m.stages)
+	}
+}
+
+func TestUploadResumableRejects(t *testing.T) {
+	m := newMemContainer()
+	dir := t.TempDir()
+	src := writeFile(t, filepath.Join(dir, "src"), "version two")
+	az := newResumeClient(t, m)
+
+	stale := filepath.Join(dir, "stale.state")
+	(&UploadState{Blob: "blob", Size: 11, ChunkSize: 10, Upload: "x", Staged: []bool{true, false}}).save(stale)
+	inconsistent := filepath.Join(dir, "inconsistent.state")
+	writeFile(t, inconsistent, `{"blob":"blob","size":11,"chunkSize":10,"upload":"x","staged":[true]}`)
+
+	tests := []struct {
+		name, state, wantErr string
+		compress             bool
+	}{
+		{"changed file", stale, "delete it to start over", false},
+		{"inconsistent state", inconsistent, "inconsistent", false},
+		{"compressed", filepath.Join(dir, "new.state"), "compressed", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			az.ClientOptions.Compression = ""
+			if tt.compress {
+				az.ClientOptions.Compression = compressionGzip
+			}
+			err := az.UploadResumable(context.Background(), openFile(t, src), "blob", tt.state)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunUploadStateFlags(t *testing.T) {
+	dir := t.TempDir()
+	src := writeFile(t, filepath.Join(dir, "src"), "data")
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	for _, args := range [][]string{
+		{"-chunk-size", "1MiB", src, "blob"},
+		{"-state", "s", "-chunk-size", "0", src, "blob"},
+	} {
+		if err := runUpload(context.Background(), az, args); err == nil {
+			t.Errorf("upload %q succeeded", args)
+		}
+	}
+	if err := runUpload(context.Background(), az, []string{"-state", filepath.Join(dir, "s"), "-chunk-size", "2", src, "blob"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.blobs["blob"]; got == nil || string(got.data) != "data" || m.stages != 2 {
+		t.Errorf("uploaded %v in %d blocks", got, m.stages)
+	}
+}
@@ -0,0 +1,55 @@
+package azureblob
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// Metadata requests are small, so a slow try is almost always a stuck
+// connection. These defaults fail fast instead of inheriting the much more
+// patient transfer policy.
+var defaultMetadataRetry = policy.RetryOptions{
+	MaxRetries:    3,
+	TryTimeout:    10 * time.Second,
+	RetryDelay:    500 * time.Millisecond,
+	MaxRetryDelay: 5 * time.Second,
+}
+
+const defaultMetadataTimeout = 30 * time.Second
+
+func isZeroRetry(o policy.RetryOptions) bool {
+	return o.MaxRetries == 0 && o.TryTimeout == 0 && o.RetryDelay == 0 &&
+		o.MaxRetryDelay == 0 && o.StatusCodes == nil
+}
+
+// metadataContext derives a context for a metadata operation (Stat, List
+// pages, property lookups) that carries the metadata retry policy and
+// is bounded by the metadata timeout.
+func (c *AzureBlobClient) metadataContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	opts := c.clientOptions()
+	retry := opts.MetadataRetry
+	if isZeroRetry(retry) {
+		retry = defaultMetadataRetry
+	}
+	timeout := opts.MetadataTimeout
+	if timeout == 0 {
+		timeout = defaultMetadataTimeout
+	}
+	ctx = policy.WithRetryOptions(ctx, retry)
+	if timeout < 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// retryCount converts a CLI retry count to policy.RetryOptions.MaxRetries.
+// MaxRetries treats zero as "use the default" and a negative value as "no
+// retries", so a requested count of zero maps to -1.
+func retryCount(n int) int32 {
+	if n == 0 {
+		return -1
+	}
+	return int32(n)
+}
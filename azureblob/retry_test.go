@@ -0,0 +1,119 @@
+package azureblob
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+func TestStatRetriesUnderMetadataPolicy(t *testing.T) {
+	var attempts int32
+	az := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	// The transfer policy must not apply to metadata requests.
+	az.ClientOptions.TransferRetry = policy.RetryOptions{MaxRetries: -1}
+	az.ClientOptions.MetadataRetry = policy.RetryOptions{
+		MaxRetries:    2,
+		RetryDelay:    time.Millisecond,
+		MaxRetryDelay: time.Millisecond,
+	}
+	if _, err := az.Stat(context.Background(), "blob"); err == nil {
+		t.Fatal("Stat succeeded against a failing handler")
+	}
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Errorf("got %d attempts, want 3", got)
+	}
+}
+
+func TestStatMetadataTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	az := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	az.ClientOptions.MetadataTimeout = 50 * time.Millisecond
+	start := time.Now()
+	_, err := az.Stat(context.Background(), "blob")
+	if err == nil {
+		t.Fatal("Stat succeeded against a stalled handler")
+	}
+	// The SDK's InternalError does not unwrap, so match on the message.
+	if !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Errorf("got %v, want a deadline error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Stat took %v, the metadata timeout was not applied", elapsed)
+	}
+}
+
+func TestMetadataContextDeadline(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		deadline bool
+		max      time.Duration
+	}{
+		{"default", 0, true, defaultMetadataTimeout},
+		{"explicit", time.Second, true, time.Second},
+		{"disabled", -1, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			az := &AzureBlobClient{ClientOptions: &AzureBlobClientOptions{MetadataTimeout: tt.timeout}}
+			ctx, cancel := az.metadataContext(context.Background())
+			defer cancel()
+			deadline, ok := ctx.Deadline()
+			if ok != tt.deadline {
+				t.Fatalf("has deadline = %v, want %v", ok, tt.deadline)
+			}
+			if ok && time.Until(deadline) > tt.max {
+				t.Errorf("deadline %v is further out than %v", time.Until(deadline), tt.max)
+			}
+		})
+	}
+}
+
+func TestIsZeroRetry(t *testing.T) {
+	tests := []struct {
+		opts policy.RetryOptions
+		want bool
+	}{
+		{policy.RetryOptions{}, true},
+		{policy.RetryOptions{MaxRetries: -1}, false},
+		{policy.RetryOptions{TryTimeout: time.Second}, false},
+		{policy.RetryOptions{StatusCodes: []int{}}, false},
+		{defaultMetadataRetry, false},
+	}
+	for _, tt := range tests {
+		if got := isZeroRetry(tt.opts); got != tt.want {
+			t.Errorf("isZeroRetry(%+v) = %v, want %v", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestRetryCount(t *testing.T) {
+	tests := []struct {
+		in   int
+		want int32
+	}{
+		{0, -1},
+		{1, 1},
+		{5, 5},
+		{-1, -1},
+	}
+	for _, tt := range tests {
+		if got := retryCount(tt.in); got != tt.want {
+			t.Errorf("retryCount(%d) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
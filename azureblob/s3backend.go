@@ -0,0 +1,321 @@
+package azureblob
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// s3Scheme is the URL scheme of S3 buckets, as in s3://bucket.
+const s3Scheme = "s3"
+
+// md5MetadataKey is the user metadata entry in which S3 uploads record the
+// MD5 of their content. The ETag of an S3 object is its MD5 only for some
+// uploads, so verify and diff rely on this instead, as they rely on the
+// Content-MD5 of Azure blobs.
+const md5MetadataKey = "bkcontentmd5"
+
+// S3Backend is a Backend storing blobs as the objects of an S3 bucket.
+type S3Backend struct {
+	client   *s3.Client
+	bucket   string
+	location string
+	// base supplies the progress aggregator of transfers.
+	base *AzureBlobClient
+}
+
+var _ Backend = (*S3Backend)(nil)
+
+// openS3Backend opens s3://bucket. Credentials come from the default AWS
+// chain: static keys in AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, a
+// profile of the shared config, including SSO profiles signed in to with
+// aws sso login, or the IAM role of the instance or task. Query parameters
+// choose the region, the profile, a role to assume, and another endpoint,
+// e.g. s3://bucket?region=eu-west-1&profile=ci&role=arn:aws:iam::1:role/r.
+// Requests use the proxy and TLS settings of base's HTTP client.
+func openS3Backend(base *AzureBlobClient, u *url.URL) (Backend, error) {
+	if u.Host == "" || strings.Trim(u.Path, "/") != "" {
+		return nil, fmt.Errorf("%s does not name a bucket; want s3://<bucket>", u)
+	}
+	q := u.Query()
+	for name := range q {
+		switch name {
+		case "region", "profile", "role", "endpoint":
+		default:
+			return nil, fmt.Errorf("%s: unknown parameter %q, want region, profile, role or endpoint", u, name)
+		}
+	}
+	hc, err := s3HTTPClient(base)
+	if err != nil {
+		return nil, err
+	}
+	opts := []func(*config.LoadOptions) error{config.WithHTTPClient(hc)}
+	if region := q.Get("region"); region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	if profile := q.Get("profile"); profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(profile))
+	}
+	// Loading the config reads files and the environment only; credentials
+	// are resolved by the first request.
+	cfg, err := config.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", u, err)
+	}
+	if role := q.Get("role"); role != "" {
+		cfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), role))
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, addS3Counting)
+		if endpoint := q.Get("endpoint"); endpoint != "" {
+			o.EndpointResolver = s3.EndpointResolverFromURL(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return &S3Backend{client: client, bucket: u.Host, location: u.String(), base: base}, nil
+}
+
+// s3HTTPClient returns an SDK client with the proxy, dialer and TLS settings
+// of base's HTTP client. It is built by the SDK, which only then can add the
+// certificates of AWS_CA_BUNDLE to it. A client given in
+// ClientOptions.HTTPClient is used as it is.
+func s3HTTPClient(base *AzureBlobClient) (aws.HTTPClient, error) {
+	hc, err := base.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	tr, ok := hc.Transport.(*http.Transport)
+	if !ok || base.clientOptions().HTTPClient != nil {
+		return hc, nil
+	}
+	return awshttp.NewBuildableClient().WithTransportOptions(func(t *http.Transport) {
+		t.Proxy = tr.Proxy
+		t.DialContext = tr.DialContext
+		t.IdleConnTimeout = tr.IdleConnTimeout
+		t.TLSClientConfig = tr.TLSClientConfig.Clone()
+	}), nil
+}
+
+// addS3Counting counts the requests and tries of transfers collecting
+// statistics, as countingPolicy and countingTransport do for Azure. The
+// SDK's retries are in the finalize step, so the end of it runs once per
+// try.
+func addS3Counting(stack *middleware.Stack) error {
+	err := stack.Initialize.Add(middleware.InitializeMiddlewareFunc("CountCalls",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			if stats := transferStatsFrom(ctx); stats != nil {
+				atomic.AddInt64(&stats.calls, 1)
+			}
+			return next.HandleInitialize(ctx, in)
+		}), middleware.Before)
+	if err != nil {
+		return err
+	}
+	return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("CountTries",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			if stats := transferStatsFrom(ctx); stats != nil {
+				atomic.AddInt64(&stats.tries, 1)
+			}
+			return next.HandleFinalize(ctx, in)
+		}), middleware.After)
+}
+
+// Location returns the URL the backend was opened with.
+func (b *S3Backend) Location() string {
+	return b.location
+}
+
+// newS3Error wraps err from an S3 call as a *BlobError with the response's
+// status and error code, so that callers tell missing objects, refused
+// credentials and throttling apart as they do for Azure.
+func newS3Error(op, key string, err error) error {
+	if err == nil {
+		return nil
+	}
+	be := &BlobError{Op: op, Blob: key, Err: err}
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		be.StatusCode = respErr.HTTPStatusCode()
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		be.ErrorCode = apiErr.ErrorCode()
+	}
+	return be
+}
+
+// Download downloads the object key to destination, hashing it on the way
+// for digests requested with withDigests.
+func (b *S3Backend) Download(ctx context.Context, key, destination string) (*TransferResult, error) {
+	ctx, stats := withTransferStats(ctx)
+	start := time.Now()
+	digests := digestRequestFrom(ctx)
+	h, err := digests.hash()
+	if err != nil {
+		return nil, err
+	}
+	out, err := b.client.GetObject(ctx, &s3.GetObjectInput{Bucket: &b.bucket, Key: &key})
+	if err != nil {
+		return nil, newS3Error("download", key, err)
+	}
+	defer out.Body.Close()
+	f, err := os.Create(destination)
+	if err != nil {
+		return nil, err
+	}
+	var dst io.Writer = f
+	if h != nil {
+		dst = io.MultiWriter(f, h)
+	}
+	tracker := b.base.beginTransfer(ctx, out.ContentLength)
+	defer tracker.finish()
+	n, err := copyPooled(dst, &progressReader{r: out.Body, tracker: tracker})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(destination)
+		return nil, newS3Error("download", key, err)
+	}
+	digests.answer(h)
+	props := s3Properties(key, out.ContentLength, out.ETag, out.LastModified, out.ContentType, out.ContentEncoding, out.Metadata)
+	stats.record(n, props.ETag, props.ContentMD5)
+	return stats.result(key, start), nil
+}
+
+// Upload uploads file to the object key, in parts for large files, and
+// records its MD5 in the object's metadata. The result has no ETag, which
+// the uploader does not return.
+func (b *S3Backend) Upload(ctx context.Context, file *os.File, key string) (*TransferResult, error) {
+	if file == nil {
+		return nil, errors.New("file cannot be nil")
+	}
+	ctx, stats := withTransferStats(ctx)
+	start := time.Now()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// Like the Azure upload, read the whole file whatever its offset.
+	h := md5.New()
+	if _, err := copyPooled(h, io.NewSectionReader(file, 0, info.Size())); err != nil {
+		return nil, err
+	}
+	props := &BlobProperties{Size: info.Size(), ContentMD5: h.Sum(nil)}
+	if err := b.UploadFrom(ctx, io.NewSectionReader(file, 0, info.Size()), props, key); err != nil {
+		return nil, err
+	}
+	stats.record(props.Size, "", props.ContentMD5)
+	return stats.result(key, start), nil
+}
+
+// UploadFrom uploads the props.Size bytes read from r to the object key,
+// recording props.ContentMD5, if set, in its metadata.
+func (b *S3Backend) UploadFrom(ctx context.Context, r io.Reader, props *BlobProperties, key string) error {
+	tracker := b.base.beginTransfer(ctx, props.Size)
+	defer tracker.finish()
+	input := &s3.PutObjectInput{
+		Bucket: &b.bucket,
+		Key:    &key,
+		Body:   &progressReader{r: r, tracker: tracker},
+	}
+	if props.ContentType != "" {
+		input.ContentType = &props.ContentType
+	}
+	if props.ContentEncoding != "" {
+		input.ContentEncoding = &props.ContentEncoding
+	}
+	if len(props.ContentMD5) == md5.Size {
+		input.Metadata = map[string]string{md5MetadataKey: hex.EncodeToString(props.ContentMD5)}
+	}
+	_, err := manager.NewUploader(b.client).Upload(ctx, input)
+	return newS3Error("upload", key, err)
+}
+
+// OpenBlob returns a reader of the object key and its properties.
+func (b *S3Backend) OpenBlob(ctx context.Context, key string) (io.ReadCloser, *BlobProperties, error) {
+	out, err := b.client.GetObject(ctx, &s3.GetObjectInput{Bucket: &b.bucket, Key: &key})
+	if err != nil {
+		return nil, nil, newS3Error("download", key, err)
+	}
+	props := s3Properties(key, out.ContentLength, out.ETag, out.LastModified, out.ContentType, out.ContentEncoding, out.Metadata)
+	return out.Body, props, nil
+}
+
+// List returns the objects whose keys start with prefix, in key order.
+// Like Azure listings, they carry no metadata.
+func (b *S3Backend) List(ctx context.Context, prefix string) ([]*BlobProperties, error) {
+	var blobs []*BlobProperties
+	pager := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{Bucket: &b.bucket, Prefix: &prefix})
+	for pager.HasMorePages() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, newS3Error("list", prefix, err)
+		}
+		for _, obj := range page.Contents {
+			blobs = append(blobs, &BlobProperties{
+				Name:         aws.ToString(obj.Key),
+				Size:         obj.Size,
+				ETag:         aws.ToString(obj.ETag),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+	return blobs, nil
+}
+
+// Delete deletes the object key. S3 deletes of missing objects succeed, so
+// the object is looked up first to report it missing as Azure does.
+func (b *S3Backend) Delete(ctx context.Context, key string) error {
+	if _, err := b.Stat(ctx, key); err != nil {
+		return err
+	}
+	_, err := b.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &b.bucket, Key: &key})
+	return newS3Error("delete", key, err)
+}
+
+// Stat returns the properties and metadata of the object key.
+func (b *S3Backend) Stat(ctx context.Context, key string) (*BlobProperties, error) {
+	out, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &b.bucket, Key: &key})
+	if err != nil {
+		return nil, newS3Error("stat", key, err)
+	}
+	return s3Properties(key, out.ContentLength, out.ETag, out.LastModified, out.ContentType, out.ContentEncoding, out.Metadata), nil
+}
+
+// s3Properties returns the properties of the object key from the headers
+// of a response to it. ContentMD5 is the MD5 recorded by Upload, if any.
+func s3Properties(key string, size int64, etag *string, modified *time.Time, contentType, contentEncoding *string, metadata map[string]string) *BlobProperties {
+	props := &BlobProperties{
+		Name:            key,
+		Size:            size,
+		ETag:            aws.ToString(etag),
+		LastModified:    aws.ToTime(modified),
+		ContentType:     aws.ToString(contentType),
+		ContentEncoding: aws.ToString(contentEncoding),
+		Metadata:        metadata,
+	}
+	if sum, err := hex.DecodeString(metadataValue(metadata, md5MetadataKey)); err == nil && len(sum) == md5.Size {
+		props.ContentMD5 = sum
+	}
+	return props
+}
@@ -0,0 +1,250 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// s3Object is an object held by fakeS3.
+type s3Object struct {
+	data     []byte
+	metadata map[string]string
+}
+
+// fakeS3 serves the path-style S3 requests S3Backend makes, for one bucket.
+type fakeS3 struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string]*s3Object
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	return &fakeS3{bucket: bucket, objects: map[string]*s3Object{}}
+}
+
+type s3ListResult struct {
+	XMLName  xml.Name `xml:"ListBucketResult"`
+	Name     string
+	Prefix   string
+	KeyCount int
+	Contents []s3ListEntry
+}
+
+type s3ListEntry struct {
+	Key          string
+	Size         int
+	ETag         string
+	LastModified string
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDTEST/") {
+		s.fail(w, r, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path != s.bucket && !strings.HasPrefix(path, s.bucket+"/") {
+		s.fail(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(path, s.bucket), "/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key == "" && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" {
+		prefix := r.URL.Query().Get("prefix")
+		result := s3ListResult{Name: s.bucket, Prefix: prefix}
+		for k, o := range s.objects {
+			if strings.HasPrefix(k, prefix) {
+				result.Contents = append(result.Contents, s3ListEntry{Key: k, Size: len(o.data), ETag: `"etag"`, LastModified: "2022-01-02T03:04:05.000Z"})
+			}
+		}
+		sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+		result.KeyCount = len(result.Contents)
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(result)
+		return
+	}
+	o := s.objects[key]
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.fail(w, r, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		metadata := map[string]string{}
+		for name := range r.Header {
+			if m := strings.ToLower(name); strings.HasPrefix(m, "x-amz-meta-") {
+				metadata[strings.TrimPrefix(m, "x-amz-meta-")] = r.Header.Get(name)
+			}
+		}
+		s.objects[key] = &s3Object{data: data, metadata: metadata}
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet, http.MethodHead:
+		if o == nil {
+			s.fail(w, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		for k, v := range o.metadata {
+			w.Header().Set("x-amz-meta-"+k, v)
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(o.data)))
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC).Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(o.data)
+		}
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.fail(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (s *fakeS3) fail(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+	}
+}
+
+// s3TestClient returns a client whose HTTP client reaches any host, unlike
+// that of newTestClient, which sends every request to its container.
+func s3TestClient(t *testing.T) *AzureBlobClient {
+	az := newTestClient(t, newMemContainer())
+	az.ClientOptions.HTTPClient = nil
+	return az
+}
+
+// s3TestLocation serves bucket from a fake S3 endpoint and returns its URL,
+// with static credentials in the environment and no shared AWS files.
+func s3TestLocation(t *testing.T, s *fakeS3) string {
+	t.Helper()
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	missing := filepath.Join(t.TempDir(), "missing")
+	for k, v := range map[string]string{
+		"AWS_ACCESS_KEY_ID":           "AKIDTEST",
+		"AWS_SECRET_ACCESS_KEY":       "secret",
+		"AWS_SESSION_TOKEN":           "",
+		"AWS_PROFILE":                 "",
+		"AWS_CA_BUNDLE":               "",
+		"AWS_CONFIG_FILE":             missing,
+		"AWS_SHARED_CREDENTIALS_FILE": missing,
+	} {
+		t.Setenv(k, v)
+	}
+	return "s3://" + s.bucket + "?region=us-east-1&endpoint=" + url.QueryEscape(srv.URL)
+}
+
+func TestS3Backend(t *testing.T) {
+	s := newFakeS3("artifacts")
+	location := s3TestLocation(t, s)
+	az := s3TestClient(t)
+	backends := NewBackends(az)
+	b, err := backends.Open(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Location() != location {
+		t.Errorf("location = %s", b.Location())
+	}
+	if again, _ := backends.Open(location); again != b {
+		t.Error("a second Open created another backend")
+	}
+	if other, _ := backends.Open("s3://artifacts?region=eu-west-1"); other == b {
+		t.Error("a bucket in another region shares the backend")
+	}
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	src := writeFile(t, filepath.Join(dir, "src.txt"), "to the bucket")
+	results := az.RunManifest(ctx, &Manifest{Uploads: []ManifestItem{{Blob: "p/out.txt", Path: src, Backend: location}}})
+	if err := manifestError(results); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(s.objects["p/out.txt"].data); got != "to the bucket" {
+		t.Errorf("uploaded %q", got)
+	}
+	props, err := b.Stat(ctx, "p/out.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if props.Size != 13 || len(props.ContentMD5) != 16 || props.ETag != `"etag"` {
+		t.Errorf("stat = %+v", props)
+	}
+	if err := az.verifyItems(ctx, []ManifestItem{{Blob: "p/out.txt", Path: src, Backend: location}}, true); err != nil {
+		t.Errorf("verify against the bucket: %v", err)
+	}
+
+	s.objects["p/in.txt"] = &s3Object{data: []byte("from the bucket")}
+	results = az.RunManifest(ctx, &Manifest{Downloads: []ManifestItem{{Blob: "p/in.txt", Path: filepath.Join(dir, "in.txt"), Backend: location}}})
+	if err := manifestError(results); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(dir, "in.txt")); got != "from the bucket" {
+		t.Errorf("downloaded %q", got)
+	}
+	result, err := b.Download(ctx, "p/in.txt", filepath.Join(dir, "again.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Blob != "p/in.txt" || result.Bytes != 15 || result.ETag != `"etag"` || result.Retries != 0 {
+		t.Errorf("download result = %+v", result)
+	}
+
+	blobs, err := b.List(ctx, "p/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blobs) != 2 || blobs[0].Name != "p/in.txt" || blobs[1].Name != "p/out.txt" || blobs[1].Size != 13 {
+		t.Errorf("list = %+v", blobs)
+	}
+	diffDir := t.TempDir()
+	writeFile(t, filepath.Join(diffDir, "out.txt"), "to the bucket")
+	entries, err := diffBackend(ctx, b, az.clientOptions(), diffDir, "p/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != "in.txt" || entries[0].Status != DiffMissing {
+		t.Errorf("diff = %+v", entries)
+	}
+
+	if err := b.Delete(ctx, "p/in.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(ctx, "p/in.txt"); !isNotFound(err) {
+		t.Errorf("deleting a missing object = %v", err)
+	}
+	if _, err := b.Download(ctx, "p/in.txt", filepath.Join(dir, "gone.txt")); !isNotFound(err) || !strings.Contains(err.Error(), "NoSuchKey") {
+		t.Errorf("downloading a missing object = %v", err)
+	}
+}
+
+func TestS3BackendCredentials(t *testing.T) {
+	s := newFakeS3("artifacts")
+	location := s3TestLocation(t, s)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDOTHER")
+	b, err := NewBackends(s3TestClient(t)).Open(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.List(context.Background(), "")
+	if be, ok := err.(*BlobError); !ok || be.StatusCode != http.StatusForbidden || be.ErrorCode != "AccessDenied" {
+		t.Errorf("list with refused credentials = %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package azureblob
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// ErrSASExpired is returned, wrapped, by requests made with a shared access
+// signature that has expired, instead of the service's bare 403.
+var ErrSASExpired = errors.New("the shared access signature has expired")
+
+// sasClockSkew is how far before its expiry a SAS the service rejects is
+// taken to have expired, allowing for the clocks of the host and the
+// service to disagree.
+const sasClockSkew = 5 * time.Minute
+
+// ParseSAS parses a shared access signature, the query string of an
+// account or container SAS URL with or without its leading "?", such as
+// the portal and az storage container generate-sas print. It must be
+// signed, and its expiry, if any, must be a time the service accepts.
+func ParseSAS(raw string) (url.Values, error) {
+	raw = strings.TrimPrefix(strings.TrimSpace(raw), "?")
+	sas, err := url.ParseQuery(raw)
+	if err != nil {
+		// The error quotes the query, signature and all.
+		return nil, errors.New("the shared access signature is not a URL query string")
+	}
+	if sas.Get("sig") == "" {
+		return nil, errors.New("the shared access signature has no sig parameter")
+	}
+	if _, err := sasExpiry(sas); err != nil {
+		return nil, err
+	}
+	return sas, nil
+}
+
+// sasExpiry returns the signed expiry of sas, the se parameter, or the zero
+// time if it has none, as a SAS tied to a stored access policy may not.
+func sasExpiry(sas url.Values) (time.Time, error) {
+	se := sas.Get("se")
+	if se == "" {
+		return time.Time{}, nil
+	}
+	// The service accepts the ISO 8601 forms with and without seconds.
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04Z07:00", "2006-01-02"} {
+		if t, err := time.Parse(layout, se); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("the shared access signature's expiry %q is not an ISO 8601 time", se)
+}
+
+// sasExpiredError is ErrSASExpired for a SAS expiring at expiry, marked as
+// not retriable for the retry policy.
+type sasExpiredError struct {
+	expiry time.Time
+}
+
+func (e sasExpiredError) Error() string {
+	return fmt.Sprintf("%v: it expired at %s", ErrSASExpired, e.expiry.UTC().Format(time.RFC3339))
+}
+func (sasExpiredError) Unwrap() error { return ErrSASExpired }
+func (sasExpiredError) NonRetriable() {}
+
+// sasPolicy authorizes blob requests with a shared access signature
+// instead of a bearer token, adding its parameters to the query of every
+// request, list and ranged reads included. Requests made once the SAS has
+// expired fail with ErrSASExpired without being sent, as do those the
+// service refuses for lack of authentication around its expiry.
+type sasPolicy struct {
+	sas    url.Values
+	expiry time.Time
+}
+
+// newSASPolicy returns the policy for sas, which ParseSAS has accepted.
+func newSASPolicy(sas url.Values) sasPolicy {
+	expiry, _ := sasExpiry(sas)
+	return sasPolicy{sas: sas, expiry: expiry}
+}
+
+func (p sasPolicy) Do(req *policy.Request) (*http.Response, error) {
+	if p.expired(0) {
+		return nil, sasExpiredError{p.expiry}
+	}
+	raw := req.Raw()
+	q := raw.URL.Query()
+	for k, v := range p.sas {
+		q[k] = append([]string(nil), v...)
+	}
+	raw.URL.RawQuery = q.Encode()
+	resp, err := req.Next()
+	if err != nil {
+		return nil, redactURLError(err)
+	}
+	if resp.StatusCode == http.StatusForbidden && resp.Header.Get("x-ms-error-code") == "AuthenticationFailed" && p.expired(sasClockSkew) {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return nil, sasExpiredError{p.expiry}
+	}
+	return resp, err
+}
+
+// redactURLError returns err with the signature taken out of the URL of the
+// *url.Error in it, as the transport errors of requests made with a SAS
+// quote it, so that the error can be logged and annotated. Other errors
+// are returned as they are.
+func redactURLError(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+	return &url.Error{Op: urlErr.Op, URL: redactURL(urlErr.URL), Err: urlErr.Err}
+}
+
+// redactURL returns raw without the sig parameter of its query.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		// The error would quote raw.
+		return "(invalid URL)"
+	}
+	q := u.Query()
+	if _, ok := q["sig"]; !ok {
+		return raw
+	}
+	q.Del("sig")
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// expired reports whether the SAS has expired, or will within skew.
+func (p sasPolicy) expired(skew time.Duration) bool {
+	return !p.expiry.IsZero() && !time.Now().Add(skew).Before(p.expiry)
+}
+
+// authPolicy returns the policy authorizing blob requests: the SAS of
+// ClientOptions if it has one, or else bearer tokens from cred.
+func (c *AzureBlobClient) authPolicy(cred *azcore.TokenCredential) policy.Policy {
+	if sas := c.clientOptions().SAS; sas != nil {
+		return newSASPolicy(sas)
+	}
+	return newBearerTokenPolicy(*cred, c.clientOptions().tokenScopes(), c.Messages)
+}
@@ -0,0 +1,168 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sasContainer passes the requests that carry sig on to m, failing the rest
+// as the service does, and counts those it sees.
+type sasContainer struct {
+	m   *memContainer
+	sig string
+
+	mu       sync.Mutex
+	requests int
+	unsigned []string
+}
+
+func (h *sasContainer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	h.requests++
+	ok := r.URL.Query().Get("sig") == h.sig && r.Header.Get("Authorization") == ""
+	if !ok {
+		h.unsigned = append(h.unsigned, r.Method+" "+r.URL.Path)
+	}
+	h.mu.Unlock()
+	if !ok {
+		w.Header().Set("x-ms-error-code", "AuthenticationFailed")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	h.m.ServeHTTP(w, r)
+}
+
+func newSASClient(t *testing.T, h *sasContainer, sas string) *AzureBlobClient {
+	t.Helper()
+	az := newTestClient(t, h)
+	az.credential = nil
+	var err error
+	if az.ClientOptions.SAS, err = ParseSAS(sas); err != nil {
+		t.Fatal(err)
+	}
+	return az
+}
+
+func TestSASAuthorizesEveryRequest(t *testing.T) {
+	m := newMemContainer()
+	data := bytes.Repeat([]byte("layer"), 9<<20/5)
+	m.put("images/base.tar", data, nil)
+	h := &sasContainer{m: m, sig: "c2lnbmVk/+="}
+	expiry := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	az := newSASClient(t, h, "?sv=2021-08-06&sr=c&sp=rl&se="+url.QueryEscape(expiry)+"&sig="+url.QueryEscape(h.sig))
+	ctx := context.Background()
+
+	blobs, err := az.List(ctx, "images/")
+	if err != nil || len(blobs) != 1 {
+		t.Fatalf("List = %v, %v", blobs, err)
+	}
+	if _, err := az.Stat(ctx, "images/base.tar"); err != nil {
+		t.Fatal(err)
+	}
+	// Blobs above a range are fetched in several ranged reads.
+	dest := filepath.Join(t.TempDir(), "base.tar")
+	if _, err := az.Download(ctx, "images/base.tar", dest); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, dest); got != string(data) {
+		t.Errorf("downloaded %d bytes, want %d", len(got), len(data))
+	}
+	if len(h.unsigned) != 0 || h.requests < 5 {
+		t.Errorf("%d requests, unsigned: %v", h.requests, h.unsigned)
+	}
+}
+
+func TestSASExpired(t *testing.T) {
+	h := &sasContainer{m: newMemContainer(), sig: "signed"}
+	h.m.put("report.pdf", []byte("report"), nil)
+	ctx := context.Background()
+
+	expired := newSASClient(t, h, "se=2020-01-01T00:00:00Z&sig=signed")
+	_, err := expired.Download(ctx, "report.pdf", filepath.Join(t.TempDir(), "report.pdf"))
+	if !errors.Is(err, ErrSASExpired) || !strings.Contains(err.Error(), "expired at 2020-01-01T00:00:00Z") {
+		t.Errorf("Download = %v", err)
+	}
+	if h.requests != 0 {
+		t.Errorf("%d requests made with an expired SAS", h.requests)
+	}
+	if code := exitCode(err); code != exitAuth {
+		t.Errorf("exit code %d, want %d", code, exitAuth)
+	}
+
+	// The service's clock may be ahead of the host's, so that it rejects a
+	// SAS that has not quite expired here.
+	expiry := time.Now().Add(time.Minute).UTC().Format(time.RFC3339)
+	expiring := newSASClient(t, h, "se="+expiry+"&sig=wrong")
+	if _, err := expiring.Stat(ctx, "report.pdf"); !errors.Is(err, ErrSASExpired) {
+		t.Errorf("Stat = %v", err)
+	}
+	// Far from its expiry, a rejected SAS is just rejected.
+	expiry = time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	wrong := newSASClient(t, h, "se="+expiry+"&sig=wrong")
+	_, err = wrong.Stat(ctx, "report.pdf")
+	var be *BlobError
+	if errors.Is(err, ErrSASExpired) || !errors.As(err, &be) || be.StatusCode != http.StatusForbidden {
+		t.Errorf("Stat = %v", err)
+	}
+	if strings.Contains(err.Error(), "sig=wrong") {
+		t.Errorf("the error %q shows the signature", err)
+	}
+}
+
+func TestParseSAS(t *testing.T) {
+	sas, err := ParseSAS(" ?sv=2021-08-06&ss=b&srt=sco&sp=rl&se=2026-12-31T23:59Z&sig=abc%2Bdef \n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sas.Get("sig") != "abc+def" || sas.Get("srt") != "sco" {
+		t.Errorf("ParseSAS = %v", sas)
+	}
+	if expiry, err := sasExpiry(sas); err != nil || !expiry.Equal(time.Date(2026, 12, 31, 23, 59, 0, 0, time.UTC)) {
+		t.Errorf("expiry %v, %v", expiry, err)
+	}
+	for _, bad := range []string{"sv=2021-08-06&sp=r", "se=tomorrow&sig=abc", "sig=a;b%zz"} {
+		if _, err := ParseSAS(bad); err == nil {
+			t.Errorf("ParseSAS(%q) was accepted", bad)
+		} else if strings.Contains(err.Error(), "sig=") {
+			t.Errorf("the error %q shows the signature", err)
+		}
+	}
+}
+
+func TestSASRedactedFromTransportErrors(t *testing.T) {
+	hangUp := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	})
+	az := newTestClient(t, hangUp)
+	az.credential = nil
+	var err error
+	if az.ClientOptions.SAS, err = ParseSAS("sv=2021-08-06&sr=c&sp=rl&sig=SECRETSIG"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	_, statErr := az.Stat(ctx, "x")
+	_, listErr := az.List(ctx, "")
+	_, downloadErr := az.Download(ctx, "x", filepath.Join(t.TempDir(), "x"))
+	for _, err := range []error{statErr, listErr, downloadErr} {
+		if err == nil {
+			t.Fatal("a request to a server that hangs up succeeded")
+		}
+		if text := err.Error(); strings.Contains(text, "sig=") || strings.Contains(text, "SECRETSIG") {
+			t.Errorf("the error quotes the signature: %s", text)
+		}
+	}
+	if got := redactURL("https://account.blob.core.windows.net/c/x?sig=SECRETSIG&sp=r&sr=c"); got != "https://account.blob.core.windows.net/c/x?sp=r&sr=c" {
+		t.Errorf("redactURL = %q", got)
+	}
+}
@@ -0,0 +1,348 @@
+package azureblob
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// siteContainer is the container a storage account serves its static
+// website from.
+const siteContainer = "$web"
+
+// siteTypes are the content types of files common on websites. They take
+// precedence over mime.TypeByExtension, whose answers depend on the
+// platform's MIME database and lack a charset for some text types.
+var siteTypes = map[string]string{
+	".html":        "text/html; charset=utf-8",
+	".htm":         "text/html; charset=utf-8",
+	".css":         "text/css; charset=utf-8",
+	".js":          "text/javascript; charset=utf-8",
+	".mjs":         "text/javascript; charset=utf-8",
+	".json":        "application/json",
+	".map":         "application/json",
+	".webmanifest": "application/manifest+json",
+	".xml":         "application/xml",
+	".txt":         "text/plain; charset=utf-8",
+	".md":          "text/markdown; charset=utf-8",
+	".svg":         "image/svg+xml",
+	".png":         "image/png",
+	".jpg":         "image/jpeg",
+	".jpeg":        "image/jpeg",
+	".gif":         "image/gif",
+	".webp":        "image/webp",
+	".avif":        "image/avif",
+	".ico":         "image/x-icon",
+	".woff":        "font/woff",
+	".woff2":       "font/woff2",
+	".ttf":         "font/ttf",
+	".otf":         "font/otf",
+	".wasm":        "application/wasm",
+	".pdf":         "application/pdf",
+	".mp4":         "video/mp4",
+	".webm":        "video/webm",
+}
+
+// siteContentType returns the Content-Type a website serves the file name
+// with.
+func siteContentType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if t, ok := siteTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// siteCompressible reports whether content of contentType is worth storing
+// gzip-compressed. Images other than SVG, fonts other than TTF and OTF, and
+// video are compressed already.
+func siteCompressible(contentType string) bool {
+	t, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(t, "text/"), strings.HasSuffix(t, "+json"), strings.HasSuffix(t, "+xml"):
+		return true
+	}
+	switch t {
+	case "application/json", "application/xml", "application/wasm", "font/ttf", "font/otf", "image/x-icon":
+		return true
+	}
+	return false
+}
+
+// SiteOptions says how DeploySite stores the files of a site.
+type SiteOptions struct {
+	// CacheControl is the Cache-Control of files other than HTML pages.
+	CacheControl string
+	// HTMLCacheControl is the Cache-Control of HTML pages. As pages link
+	// to the other files, they are usually revalidated so that a deploy
+	// takes effect at once.
+	HTMLCacheControl string
+	// Gzip stores files of compressible types gzip-compressed, with a
+	// Content-Encoding of gzip, which browsers decode.
+	Gzip bool
+	// Delete deletes the blobs under the prefix that have no local file.
+	// They are kept by default, since a deploy from the wrong directory
+	// would otherwise delete the site.
+	Delete bool
+}
+
+// siteFile is a local file of a site and how it is stored: its blob, the
+// headers of the blob, and the size and MD5 of the bytes stored.
+type siteFile struct {
+	path  string
+	props BlobProperties
+	md5   []byte
+}
+
+// SitePlan is what deploying a site changes. Uploads are the files whose
+// blob is missing or differs in content or headers; Unchanged counts the
+// others. The blobs under the prefix without a local file are deleted with
+// SiteOptions.Delete, and kept otherwise.
+type SitePlan struct {
+	uploads   []*siteFile
+	deletes   []string
+	kept      []string
+	listed    map[string]*BlobProperties
+	Unchanged int
+}
+
+// PlanSite compares the regular files under dir with the blobs under
+// prefix, matching each file to the blob named prefix plus its
+// slash-separated path relative to dir, and returns what DeploySite would
+// do. The bytes a file would be stored as are hashed locally and compared
+// with the blob's Content-MD5, so unchanged files are not uploaded again.
+// A dir without regular files is refused, as it is more likely a build that
+// failed, or the wrong directory, than an empty site.
+func (c *AzureBlobClient) PlanSite(ctx context.Context, dir, prefix string, opts SiteOptions) (*SitePlan, error) {
+	if o := c.clientOptions(); o.EncryptUploads || o.Compression != "" {
+		return nil, errors.New("a static website cannot serve client-side encrypted or compressed blobs; deploy it without -encrypt and -compress")
+	}
+	blobs, err := c.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	dir = localRoot(dir)
+	remote := map[string]*BlobProperties{}
+	for _, b := range blobs {
+		remote[b.Name] = b
+	}
+	var files []*siteFile
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		f := &siteFile{path: p}
+		f.props.Name = prefix + filepath.ToSlash(rel)
+		f.props.ContentType = siteContentType(rel)
+		f.props.CacheControl = opts.CacheControl
+		if strings.HasPrefix(f.props.ContentType, "text/html") {
+			f.props.CacheControl = opts.HTMLCacheControl
+		}
+		if opts.Gzip && siteCompressible(f.props.ContentType) {
+			f.props.ContentEncoding = "gzip"
+		}
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s holds no files; refusing to deploy an empty site", dir)
+	}
+	errs := hashPool.Run(ctx, len(files), func(ctx context.Context, i int) error {
+		f := files[i]
+		r, err := openSitePayload(f.path, f.props.ContentEncoding == "gzip")
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		h := md5.New()
+		if f.props.Size, err = copyPooled(h, r); err != nil {
+			return err
+		}
+		f.md5 = h.Sum(nil)
+		return nil
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	plan := &SitePlan{listed: remote}
+	changed := make([]bool, len(files))
+	errs = c.Pool.Run(ctx, len(files), func(ctx context.Context, i int) error {
+		f := files[i]
+		b, ok := remote[f.props.Name]
+		if !ok {
+			changed[i] = true
+			return nil
+		}
+		if len(b.ContentMD5) == 0 {
+			// This SDK version drops the Content-MD5 of listings.
+			var err error
+			if b, err = c.Stat(ctx, b.Name); err != nil {
+				return err
+			}
+		}
+		changed[i] = !bytes.Equal(b.ContentMD5, f.md5) ||
+			b.ContentType != f.props.ContentType ||
+			b.ContentEncoding != f.props.ContentEncoding ||
+			b.CacheControl != f.props.CacheControl
+		return nil
+	})
+	for i, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+		if changed[i] {
+			plan.uploads = append(plan.uploads, files[i])
+		} else {
+			plan.Unchanged++
+		}
+		delete(remote, files[i].props.Name)
+	}
+	var stale []string
+	for name := range remote {
+		stale = append(stale, name)
+	}
+	sort.Strings(stale)
+	if opts.Delete {
+		plan.deletes = stale
+	} else {
+		plan.kept = stale
+	}
+	return plan, nil
+}
+
+// openSitePayload opens the file at p for reading the bytes it is stored
+// as: gzip-compressed with gz. The compression is deterministic, so the
+// same file always hashes the same.
+func openSitePayload(p string, gz bool) (io.ReadCloser, error) {
+	f, err := os.Open(p)
+	if err != nil || !gz {
+		return f, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		defer f.Close()
+		zw, _ := gzip.NewWriterLevel(pw, gzip.BestCompression)
+		_, err := copyPooled(zw, f)
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// DeploySite uploads the files of plan concurrently, bounded by c.Pool,
+// then deletes the blobs it found without a local file, printing a line for
+// each. The deletions are skipped if an upload fails, so that pages still
+// being served do not lose what they link to.
+func (c *AzureBlobClient) DeploySite(ctx context.Context, plan *SitePlan) error {
+	errs := c.Pool.Run(ctx, len(plan.uploads), func(ctx context.Context, i int) error {
+		f := plan.uploads[i]
+		r, err := openSitePayload(f.path, f.props.ContentEncoding == "gzip")
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		if err := c.UploadFrom(ctx, r, &f.props, f.props.Name); err != nil {
+			return err
+		}
+		fmt.Println(c.Messages.format(MsgSiteUploaded, f.path, f.props.Name, f.props.ContentType))
+		return nil
+	})
+	failures := &transferFailures{total: len(plan.uploads), noun: "uploads"}
+	for _, err := range errs {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failures.errs = append(failures.errs, err)
+		}
+	}
+	if len(failures.errs) > 0 {
+		return failures
+	}
+	fmt.Println(c.Messages.format(MsgSiteDeployed, len(plan.uploads), plan.Unchanged, len(plan.deletes)))
+	if len(plan.deletes) == 0 {
+		return nil
+	}
+	return deleteAll(ctx, c, plan.deletes)
+}
+
+// planned returns the uploads and deletions of plan for printPlan.
+func (p *SitePlan) planned() []PlannedTransfer {
+	var plans []PlannedTransfer
+	for _, f := range p.uploads {
+		plans = append(plans, PlannedTransfer{Direction: "upload", Item: ManifestItem{Path: f.path, Blob: f.props.Name}, Size: f.props.Size})
+	}
+	for _, name := range p.deletes {
+		plans = append(plans, PlannedTransfer{Direction: "delete", Item: ManifestItem{Blob: name}, Size: p.listed[name].Size})
+	}
+	return plans
+}
+
+func runDeploySite(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("deploy-site", flag.ContinueOnError)
+	container := fs.String("container", siteContainer, "deploy to `container` of the storage account")
+	cacheControl := fs.String("cache-control", "public, max-age=3600", "Cache-Control of files other than HTML pages")
+	htmlCacheControl := fs.String("html-cache-control", "no-cache", "Cache-Control of HTML pages")
+	gz := fs.Bool("gzip", false, "store text, JSON, SVG and other compressible files gzip-compressed")
+	del := fs.Bool("delete", false, "delete blobs under the prefix whose file was removed")
+	dryRun := fs.Bool("dry-run", false, "print what would be uploaded and deleted without changing anything")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: deploy-site [flags] <directory> [prefix]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return errors.New("deploy-site takes a directory and optionally a prefix")
+	}
+	prefix := fs.Arg(1)
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	site, err := NewClientRegistry(az).Client(az.StorageAccount, *container)
+	if err != nil {
+		return err
+	}
+	plan, err := site.PlanSite(ctx, fs.Arg(0), prefix, SiteOptions{
+		CacheControl:     *cacheControl,
+		HTMLCacheControl: *htmlCacheControl,
+		Gzip:             *gz,
+		Delete:           *del,
+	})
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		for _, name := range plan.kept {
+			fmt.Println(site.Messages.format(MsgSiteKept, name))
+		}
+		return printPlan(site.Messages, plan.planned(), "planned changes")
+	}
+	return site.DeploySite(ctx, plan)
+}
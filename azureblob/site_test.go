@@ -0,0 +1,142 @@
+package azureblob
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSiteContentType(t *testing.T) {
+	for name, want := range map[string]string{
+		"index.html":       "text/html; charset=utf-8",
+		"css/Site.CSS":     "text/css; charset=utf-8",
+		"app.mjs":          "text/javascript; charset=utf-8",
+		"logo.svg":         "image/svg+xml",
+		"fonts/a.woff2":    "font/woff2",
+		"site.webmanifest": "application/manifest+json",
+		"LICENSE":          "application/octet-stream",
+	} {
+		if got := siteContentType(name); got != want {
+			t.Errorf("siteContentType(%q) = %q, want %q", name, got, want)
+		}
+	}
+	for contentType, want := range map[string]bool{
+		"text/html; charset=utf-8":  true,
+		"application/json":          true,
+		"application/manifest+json": true,
+		"image/svg+xml":             true,
+		"image/png":                 false,
+		"font/woff2":                false,
+	} {
+		if got := siteCompressible(contentType); got != want {
+			t.Errorf("siteCompressible(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+}
+
+func TestDeploySite(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "index.html"), "<h1>home</h1>")
+	writeFile(t, filepath.Join(dir, "css", "site.css"), "body{}")
+	writeFile(t, filepath.Join(dir, "logo.png"), "png")
+	m.put("site/old.html", []byte("gone"), nil)
+	m.put("elsewhere.txt", []byte("kept"), nil)
+	ctx := context.Background()
+	opts := SiteOptions{CacheControl: "max-age=60", HTMLCacheControl: "no-cache", Gzip: true, Delete: true}
+
+	plan, err := az.PlanSite(ctx, dir, "site/", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out string
+	out = captureStdout(t, func() { err = az.DeploySite(ctx, plan) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "3 files uploaded, 0 unchanged, 1 to delete") || !strings.Contains(out, "site/old.html: deleted") {
+		t.Errorf("deploy printed %q", out)
+	}
+	if m.blobs["site/old.html"] != nil || m.blobs["elsewhere.txt"] == nil {
+		t.Error("deleted the wrong blobs")
+	}
+	html := m.blobs["site/index.html"]
+	if html.contentType != "text/html; charset=utf-8" || html.cacheControl != "no-cache" || html.contentEncoding != "gzip" {
+		t.Errorf("index.html stored as %q, %q, %q", html.contentType, html.cacheControl, html.contentEncoding)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(html.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(zr); string(b) != "<h1>home</h1>" {
+		t.Errorf("index.html decompresses to %q", b)
+	}
+	png := m.blobs["site/logo.png"]
+	if png.contentType != "image/png" || png.cacheControl != "max-age=60" || png.contentEncoding != "" || string(png.data) != "png" {
+		t.Errorf("logo.png stored as %q, %q, %q: %q", png.contentType, png.cacheControl, png.contentEncoding, png.data)
+	}
+
+	// A second deploy uploads nothing; changing a header uploads again.
+	if plan, err = az.PlanSite(ctx, dir, "site/", opts); err != nil || len(plan.uploads) != 0 || plan.Unchanged != 3 {
+		t.Errorf("redeploy plans %d uploads, %d unchanged, %v", len(plan.uploads), plan.Unchanged, err)
+	}
+	opts.CacheControl = "max-age=120"
+	if plan, err = az.PlanSite(ctx, dir, "site/", opts); err != nil || len(plan.uploads) != 2 {
+		t.Errorf("new Cache-Control plans %d uploads, %v; want the 2 assets", len(plan.uploads), err)
+	}
+}
+
+func TestDeploySiteRefusesEncoding(t *testing.T) {
+	az := newTestClient(t, newMemContainer())
+	az.ClientOptions.Compression = "gzip"
+	if _, err := az.PlanSite(context.Background(), t.TempDir(), "", SiteOptions{}); err == nil {
+		t.Error("PlanSite accepted client-side compression")
+	}
+}
+
+func TestRunDeploySiteDryRun(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "index.html"), "home")
+	m.put("old.html", []byte("gone"), nil)
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "would keep old.html"},
+		{[]string{"-delete"}, "would delete old.html"},
+	} {
+		var err error
+		out := captureStdout(t, func() {
+			err = runDeploySite(context.Background(), az, append(append([]string{"-container", "container", "-dry-run"}, tt.args...), dir))
+		})
+		if err != nil || !strings.Contains(out, "would upload") || !strings.Contains(out, tt.want) {
+			t.Errorf("dry run %q printed %q, %v", tt.args, out, err)
+		}
+	}
+	if m.blobs["index.html"] != nil || m.blobs["old.html"] == nil {
+		t.Error("dry run changed the container")
+	}
+}
+
+func TestRunDeploySiteEmptyDir(t *testing.T) {
+	m := newMemContainer()
+	m.put("index.html", []byte("home"), nil)
+	az := newTestClient(t, m)
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "empty"), 0o755)
+	err := runDeploySite(context.Background(), az, []string{"-container", "container", "-delete", dir})
+	if err == nil || !strings.Contains(err.Error(), "empty site") {
+		t.Errorf("deploying an empty directory = %v", err)
+	}
+	if m.blobs["index.html"] == nil {
+		t.Error("the site was deleted")
+	}
+}
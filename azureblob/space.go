@@ -0,0 +1,49 @@
+package azureblob
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// errPreallocateUnsupported is returned by preallocate where the platform or
+// file system cannot reserve space up front.
+var errPreallocateUnsupported = errors.New("preallocation is not supported")
+
+// diskFree returns the bytes available to this process on the file system
+// holding dir, or false if that cannot be told. Tests replace it.
+var diskFree = freeSpace
+
+// checkSpace fails with a *DiskSpaceError if the file system holding dir has
+// fewer than need bytes available. Where the available space cannot be told,
+// nothing is checked and the download fails when the disk fills, as before.
+func checkSpace(dir string, need int64) error {
+	free, ok := diskFree(dir)
+	if ok && free < need {
+		return &DiskSpaceError{Dir: dir, Need: need, Free: free}
+	}
+	return nil
+}
+
+// regularSize returns the size of the regular file at path, or 0 if there
+// is none.
+func regularSize(path string) int64 {
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return 0
+	}
+	return info.Size()
+}
+
+// allocate sizes f to size bytes for a download to fill in. With
+// ClientOptions.Preallocate the space is reserved first where the platform
+// and file system can, so a disk that fills up fails the download before
+// any data is fetched. Otherwise f is sparse until written.
+func (c *AzureBlobClient) allocate(f *os.File, size int64) error {
+	if c.clientOptions().Preallocate && size > 0 {
+		if err := preallocate(f, size); err != nil && !errors.Is(err, errPreallocateUnsupported) {
+			return fmt.Errorf("preallocate %s: %w", f.Name(), err)
+		}
+	}
+	return f.Truncate(size)
+}
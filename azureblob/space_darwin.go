@@ -0,0 +1,30 @@
+package azureblob
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// freeSpace returns the bytes statfs reports available to unprivileged users
+// on the file system holding dir.
+func freeSpace(dir string) (int64, bool) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, false
+	}
+	return int64(st.Bavail) * int64(st.Bsize), true
+}
+
+// preallocate reserves the first size bytes of f with F_PREALLOCATE, which
+// unlike fallocate does not change the file's size.
+func preallocate(f *os.File, size int64) error {
+	fstore := &unix.Fstore_t{Flags: unix.F_ALLOCATEALL, Posmode: unix.F_PEOFPOSMODE, Length: size}
+	if err := unix.FcntlFstore(f.Fd(), unix.F_PREALLOCATE, fstore); err != nil {
+		if err == unix.ENOTSUP {
+			return errPreallocateUnsupported
+		}
+		return err
+	}
+	return nil
+}
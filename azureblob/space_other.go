@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package azureblob
+
+import "os"
+
+// freeSpace cannot tell the available space here, so downloads are not
+// checked.
+func freeSpace(dir string) (int64, bool) {
+	return 0, false
+}
+
+// preallocate is unsupported here, so downloads are sparse until written.
+func preallocate(f *os.File, size int64) error {
+	return errPreallocateUnsupported
+}
@@ -0,0 +1,101 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeDiskFree makes every file system report free bytes available until
+// the test ends.
+func fakeDiskFree(t *testing.T, free int64) {
+	t.Helper()
+	orig := diskFree
+	diskFree = func(string) (int64, bool) { return free, true }
+	t.Cleanup(func() { diskFree = orig })
+}
+
+func TestFreeSpace(t *testing.T) {
+	free, ok := freeSpace(t.TempDir())
+	if runtime.GOOS == "linux" || runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		if !ok || free <= 0 {
+			t.Errorf("freeSpace = %d, %v", free, ok)
+		}
+	}
+	if err := checkSpace(t.TempDir(), 1); err != nil {
+		t.Errorf("checkSpace of one byte: %v", err)
+	}
+}
+
+func TestDownloadChecksSpace(t *testing.T) {
+	m := newMemContainer()
+	m.put("blob", []byte("0123456789"), nil)
+	az := newTestClient(t, m)
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "out")
+	ctx := context.Background()
+
+	fakeDiskFree(t, 9)
+	_, err := az.Download(ctx, "blob", dest)
+	var spaceErr *DiskSpaceError
+	if !errors.As(err, &spaceErr) || spaceErr.Need != 10 || spaceErr.Free != 9 || !strings.Contains(err.Error(), dir) {
+		t.Fatalf("Download = %v, want a disk space error", err)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("a refused download created its destination: %v", err)
+	}
+	if err := az.DownloadResumable(ctx, "blob", dest, dest+".state"); !errors.As(err, &spaceErr) {
+		t.Errorf("DownloadResumable = %v, want a disk space error", err)
+	}
+
+	// The file a download replaces frees its space.
+	writeFile(t, dest, "old")
+	fakeDiskFree(t, 7)
+	if _, err := az.Download(ctx, "blob", dest); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, dest); got != "0123456789" {
+		t.Errorf("downloaded %q", got)
+	}
+}
+
+func TestDownloadChecksSpaceForStaging(t *testing.T) {
+	m := newMemContainer()
+	m.put("blob", gzipBytes(t, "compressed"), map[string]string{compressionMetadataKey: compressionGzip})
+	az := newTestClient(t, m)
+	fakeDiskFree(t, int64(len(m.blobs["blob"].data))+1)
+	_, err := az.Download(context.Background(), "blob", filepath.Join(t.TempDir(), "out"))
+	if !errors.As(err, new(*DiskSpaceError)) {
+		t.Errorf("Download = %v, want room for the staged blob required too", err)
+	}
+}
+
+func TestPreallocate(t *testing.T) {
+	m := newMemContainer()
+	m.put("blob", []byte("preallocated"), nil)
+	az := newTestClient(t, m)
+	az.ClientOptions.Preallocate = true
+	dest := filepath.Join(t.TempDir(), "out")
+	if _, err := az.Download(context.Background(), "blob", dest); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, dest); got != "preallocated" {
+		t.Errorf("downloaded %q", got)
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := az.allocate(f, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := f.Stat(); err != nil || info.Size() != 1<<20 {
+		t.Errorf("allocated file: %v, %v", info, err)
+	}
+}
@@ -0,0 +1,27 @@
+package azureblob
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// freeSpace returns the bytes GetDiskFreeSpaceEx reports available to the
+// caller, which honours quotas, on the volume holding dir.
+func freeSpace(dir string) (int64, bool) {
+	name, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, false
+	}
+	var avail, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(name, &avail, &total, &free); err != nil {
+		return 0, false
+	}
+	return int64(avail), true
+}
+
+// preallocate is unsupported here; setting the size of a file already
+// reserves its space on NTFS.
+func preallocate(f *os.File, size int64) error {
+	return errPreallocateUnsupported
+}
@@ -0,0 +1,259 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// BlobProperties are the properties of a single blob.
+type BlobProperties struct {
+	Name            string    `json:"name"`
+	Size            int64     `json:"size"`
+	ETag            string    `json:"etag"`
+	LastModified    time.Time `json:"lastModified"`
+	ContentType     string    `json:"contentType"`
+	ContentEncoding string    `json:"contentEncoding,omitempty"`
+	CacheControl    string    `json:"cacheControl,omitempty"`
+	ContentMD5      []byte    `json:"contentMD5,omitempty"`
+	AccessTier      string    `json:"accessTier,omitempty"`
+	// EncryptionScope is the server-side encryption scope the blob is
+	// encrypted with, if not the account's default.
+	EncryptionScope string            `json:"encryptionScope,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	// Tags are the blob's index tags. They are only filled in when asked
+	// for, see ListOptions and Tags, as reading them takes a permission
+	// that reading blobs does not.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// Stat returns the properties of blobPath. Like other metadata operations it
+// uses the metadata retry policy and timeout rather than the transfer ones.
+func (c *AzureBlobClient) Stat(ctx context.Context, blobPath string) (*BlobProperties, error) {
+	var props *BlobProperties
+	err := c.withRebuild(ctx, "stat", blobPath, func() error {
+		var err error
+		props, err = c.stat(ctx, blobPath)
+		return err
+	})
+	return props, err
+}
+
+func (c *AzureBlobClient) stat(ctx context.Context, blobPath string) (*BlobProperties, error) {
+	if err := c.init(ctx); err != nil {
+		return nil, err
+	}
+	ctx, cancel := c.metadataContext(ctx)
+	defer cancel()
+	blob := c.containerClient.NewBlobClient(blobPath)
+	resp, err := blob.GetProperties(ctx, &azblob.GetBlobPropertiesOptions{})
+	if err != nil {
+		return nil, newBlobError("stat", blobPath, err)
+	}
+	props := &BlobProperties{
+		Name:            blobPath,
+		ETag:            stringValue(resp.ETag),
+		ContentType:     stringValue(resp.ContentType),
+		ContentEncoding: stringValue(resp.ContentEncoding),
+		CacheControl:    stringValue(resp.CacheControl),
+		ContentMD5:      resp.ContentMD5,
+		AccessTier:      stringValue(resp.AccessTier),
+		EncryptionScope: stringValue(resp.EncryptionScope),
+		Metadata:        resp.Metadata,
+	}
+	if resp.ContentLength != nil {
+		props.Size = *resp.ContentLength
+	}
+	if resp.LastModified != nil {
+		props.LastModified = *resp.LastModified
+	}
+	return props, nil
+}
+
+// blobPropertiesFromItem converts a listing entry to BlobProperties.
+func blobPropertiesFromItem(item *azblob.BlobItemInternal) *BlobProperties {
+	props := &BlobProperties{Name: stringValue(item.Name)}
+	if p := item.Properties; p != nil {
+		props.ETag = stringValue(p.Etag)
+		props.ContentType = stringValue(p.ContentType)
+		props.ContentEncoding = stringValue(p.ContentEncoding)
+		props.CacheControl = stringValue(p.CacheControl)
+		props.EncryptionScope = stringValue(p.EncryptionScope)
+		if len(p.ContentMD5) > 0 {
+			props.ContentMD5 = p.ContentMD5
+		}
+		if p.ContentLength != nil {
+			props.Size = *p.ContentLength
+		}
+		if p.LastModified != nil {
+			props.LastModified = *p.LastModified
+		}
+		if p.AccessTier != nil {
+			props.AccessTier = string(*p.AccessTier)
+		}
+	}
+	if item.BlobTags != nil {
+		props.Tags = tagMap(item.BlobTags)
+	}
+	return props
+}
+
+// tagMap converts a set of index tags to a map.
+func tagMap(tags *azblob.BlobTags) map[string]string {
+	m := map[string]string{}
+	for _, t := range tags.BlobTagSet {
+		if t != nil {
+			m[stringValue(t.Key)] = stringValue(t.Value)
+		}
+	}
+	return m
+}
+
+// Tags returns the index tags of blobPath.
+func (c *AzureBlobClient) Tags(ctx context.Context, blobPath string) (map[string]string, error) {
+	var tags map[string]string
+	err := c.withRebuild(ctx, "get tags", blobPath, func() error {
+		if err := c.init(ctx); err != nil {
+			return err
+		}
+		ctx, cancel := c.metadataContext(ctx)
+		defer cancel()
+		resp, err := c.containerClient.NewBlobClient(blobPath).GetTags(ctx, nil)
+		if err != nil {
+			return newBlobError("get tags", blobPath, err)
+		}
+		tags = tagMap(&resp.BlobTags)
+		return nil
+	})
+	return tags, err
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func runStat(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("stat", flag.ContinueOnError)
+	output := outputFlag(fs)
+	tags := fs.Bool("tags", false, "also print the blob's index tags, which needs permission to read them")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: stat [flags] <blob>\n       stat [flags] <remote>:<blob>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("stat takes a blob name")
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	az, blob, err := az.resolveRemote(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	props, err := az.Stat(ctx, blob)
+	if err != nil {
+		return err
+	}
+	if *tags {
+		if props.Tags, err = az.Tags(ctx, blob); err != nil {
+			return err
+		}
+	}
+	switch *output {
+	case outputJSON:
+		return printJSON(props)
+	case outputCSV:
+		return printCSV(propertiesHeader, [][]string{propertiesRow(props)})
+	}
+	for _, line := range propertyLines(props) {
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// propertyLines returns the properties of a blob as stat prints them, a
+// labelled line each.
+func propertyLines(props *BlobProperties) []string {
+	lines := []string{
+		fmt.Sprintf("Name:          %s", props.Name),
+		fmt.Sprintf("Size:          %d", props.Size),
+		fmt.Sprintf("ETag:          %s", props.ETag),
+		fmt.Sprintf("Last-Modified: %s", props.LastModified.Format(time.RFC3339)),
+		fmt.Sprintf("Content-Type:  %s", props.ContentType),
+	}
+	if props.ContentEncoding != "" {
+		lines = append(lines, fmt.Sprintf("Encoding:      %s", props.ContentEncoding))
+	}
+	if props.CacheControl != "" {
+		lines = append(lines, fmt.Sprintf("Cache-Control: %s", props.CacheControl))
+	}
+	if len(props.ContentMD5) > 0 {
+		lines = append(lines, fmt.Sprintf("Content-MD5:   %s", base64.StdEncoding.EncodeToString(props.ContentMD5)))
+	}
+	if props.AccessTier != "" {
+		lines = append(lines, fmt.Sprintf("Access-Tier:   %s", props.AccessTier))
+	}
+	if props.EncryptionScope != "" {
+		lines = append(lines, fmt.Sprintf("Encryption:    %s", props.EncryptionScope))
+	}
+	keys := make([]string, 0, len(props.Metadata))
+	for k := range props.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("Metadata:      %s=%s", k, props.Metadata[k]))
+	}
+	keys = keys[:0]
+	for k := range props.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("Tag:           %s=%s", k, props.Tags[k]))
+	}
+	return lines
+}
+
+func runList(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	output := outputFlag(fs)
+	metadata := fs.Bool("metadata", false, "also look up each blob's metadata, a request per blob")
+	tags := fs.Bool("tags", false, "also list the blobs' index tags, which needs permission to read them")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: list [flags] [prefix]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("list takes at most one prefix")
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	az, prefix, err := az.resolveRemote(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	blobs, err := az.ListWith(ctx, prefix, ListOptions{Metadata: *metadata, Tags: *tags})
+	if err != nil {
+		return err
+	}
+	return printProperties(*output, blobs)
+}
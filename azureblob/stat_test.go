@@ -0,0 +1,54 @@
+package azureblob
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptionScope(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	ctx := context.Background()
+	upload := func(blob string) {
+		t.Helper()
+		f, err := os.Open(writeFile(t, filepath.Join(t.TempDir(), "f"), "data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := az.Upload(ctx, f, blob); err != nil {
+			t.Fatal(err)
+		}
+	}
+	upload("default")
+	az.ClientOptions.EncryptionScope = "tenant-a"
+	upload("scoped")
+
+	for blob, want := range map[string]string{"default": "", "scoped": "tenant-a"} {
+		if got := m.blobs[blob].encryptionScope; got != want {
+			t.Errorf("%s was uploaded with scope %q, want %q", blob, got, want)
+		}
+		props, err := az.Stat(ctx, blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if props.EncryptionScope != want {
+			t.Errorf("Stat(%s).EncryptionScope = %q, want %q", blob, props.EncryptionScope, want)
+		}
+	}
+	listed, err := az.List(ctx, "scoped")
+	if err != nil || len(listed) != 1 || listed[0].EncryptionScope != "tenant-a" {
+		t.Errorf("listing: %+v, %v", listed, err)
+	}
+	out := captureStdout(t, func() {
+		if err := runStat(ctx, az, []string{"scoped"}); err != nil {
+			t.Error(err)
+		}
+	})
+	if !strings.Contains(out, "Encryption:    tenant-a\n") {
+		t.Errorf("stat output:\n%s", out)
+	}
+}
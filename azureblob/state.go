@@ -0,0 +1,51 @@
+package azureblob
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// defaultStateDir returns the directory state files are kept in when no
+// other is configured: bk_azureblob in $XDG_STATE_HOME, or by default in
+// ~/.local/state on Unix, ~/Library/Caches on macOS and %LocalAppData% on
+// Windows.
+func defaultStateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "bk_azureblob"), nil
+	}
+	switch runtime.GOOS {
+	case "darwin", "ios", "windows", "plan9":
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "bk_azureblob"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "bk_azureblob"), nil
+}
+
+// statePath returns where the state file name is kept. A bare file name is
+// kept in o.StateDir, or in defaultStateDir if that is empty, which is
+// created if need be; a path with a directory is used as given.
+func (o *AzureBlobClientOptions) statePath(name string) (string, error) {
+	if name == "" || filepath.IsAbs(name) || strings.ContainsRune(name, '/') || strings.ContainsRune(name, filepath.Separator) {
+		return name, nil
+	}
+	dir := o.StateDir
+	if dir == "" {
+		var err error
+		if dir, err = defaultStateDir(); err != nil {
+			return "", err
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
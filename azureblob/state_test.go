@@ -0,0 +1,67 @@
+package azureblob
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestStatePath(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	o := &AzureBlobClientOptions{StateDir: dir}
+	for name, want := range map[string]string{
+		"":                                  "",
+		"watch.json":                        filepath.Join(dir, "watch.json"),
+		"./watch.json":                      "./watch.json",
+		"sub/watch.json":                    "sub/watch.json",
+		filepath.Join(dir, "abs", "a.json"): filepath.Join(dir, "abs", "a.json"),
+	} {
+		if got, err := o.statePath(name); err != nil || got != want {
+			t.Errorf("statePath(%q) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() || (runtime.GOOS != "windows" && info.Mode().Perm() != 0700) {
+		t.Errorf("state directory: %v, %v", info, err)
+	}
+}
+
+func TestDefaultStateDir(t *testing.T) {
+	state := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", state)
+	if dir, err := defaultStateDir(); err != nil || dir != filepath.Join(state, "bk_azureblob") {
+		t.Errorf("with XDG_STATE_HOME: %q, %v", dir, err)
+	}
+	if runtime.GOOS != "linux" {
+		return
+	}
+	home := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", "relative")
+	t.Setenv("HOME", home)
+	if dir, err := defaultStateDir(); err != nil || dir != filepath.Join(home, ".local", "state", "bk_azureblob") {
+		t.Errorf("without XDG_STATE_HOME: %q, %v", dir, err)
+	}
+}
+
+func TestRunWatchKeepsStateInStateDir(t *testing.T) {
+	m := newMemContainer()
+	m.put("p/a.txt", []byte("a"), nil)
+	az := newTestClient(t, m)
+	stateDir := filepath.Join(t.TempDir(), "state")
+	az.ClientOptions.StateDir = stateDir
+	if err := runWatch(context.Background(), az, []string{"-once", "-state", "p.json", "p/", t.TempDir()}); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := loadWatchState(filepath.Join(stateDir, "p.json")); err != nil || len(state) != 1 {
+		t.Errorf("watch state: %+v, %v", state, err)
+	}
+}
+
+func TestLoadConfigStateDir(t *testing.T) {
+	path := writeFile(t, filepath.Join(t.TempDir(), "config.yaml"), "state_dir: /var/lib/bk_azureblob\n")
+	cfg, err := LoadConfig(path)
+	if err != nil || cfg.StateDir != "/var/lib/bk_azureblob" {
+		t.Errorf("LoadConfig: %+v, %v", cfg, err)
+	}
+}
@@ -0,0 +1,19 @@
+package azureblob
+
+import (
+	"context"
+	"os"
+)
+
+// BlobStorage is the blob operations of AzureBlobClient. Code that accepts
+// a BlobStorage instead of a *AzureBlobClient can be tested against
+// MockBlobStorage without reaching Azure.
+type BlobStorage interface {
+	Download(ctx context.Context, asset, destination string) (*TransferResult, error)
+	Upload(ctx context.Context, file *os.File, blobPath string) (*TransferResult, error)
+	List(ctx context.Context, prefix string) ([]*BlobProperties, error)
+	Delete(ctx context.Context, blobPath string) error
+	Stat(ctx context.Context, blobPath string) (*BlobProperties, error)
+}
+
+var _ BlobStorage = (*AzureBlobClient)(nil)
@@ -0,0 +1,37 @@
+package azureblob_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/discentem/bk_azureblob/azureblob"
+)
+
+// countBlobs is code under test outside the package, as in a program
+// importing it.
+func countBlobs(ctx context.Context, s azureblob.BlobStorage, prefix string) (int, error) {
+	blobs, err := s.List(ctx, prefix)
+	return len(blobs), err
+}
+
+func TestTestDoublesOutsideThePackage(t *testing.T) {
+	ctx := context.Background()
+	fake := azureblob.NewFakeBlobStorage()
+	fake.Put("logs/a.txt", []byte("a"), nil)
+	fake.Put("logs/b.txt", []byte("b"), nil)
+	if n, err := countBlobs(ctx, fake, "logs/"); err != nil || n != 2 {
+		t.Errorf("countBlobs(fake) = %d, %v", n, err)
+	}
+
+	mock := &azureblob.MockBlobStorage{
+		ListFunc: func(ctx context.Context, prefix string) ([]*azureblob.BlobProperties, error) {
+			return []*azureblob.BlobProperties{{Name: prefix + "a.txt"}}, nil
+		},
+	}
+	if n, err := countBlobs(ctx, mock, "logs/"); err != nil || n != 1 {
+		t.Errorf("countBlobs(mock) = %d, %v", n, err)
+	}
+	if calls := mock.Calls(); len(calls) != 1 || calls[0] != (azureblob.MockCall{Method: "List", Blob: "logs/"}) {
+		t.Errorf("calls = %+v", calls)
+	}
+}
@@ -0,0 +1,163 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// Ways of uploading symlinks met by directory uploads; see
+// ClientOptions.Symlinks.
+const (
+	symlinksFollow   = "follow"
+	symlinksPreserve = "preserve"
+)
+
+// linkTargetMetadataKey is the metadata entry in which a preserved symlink
+// records its target, so that downloads can recreate the link.
+const linkTargetMetadataKey = "bklinktarget"
+
+// walkTree calls fn for root and everything under it, in lexical order like
+// filepath.WalkDir. Unless follow is set, symlinks are reported as such and
+// not descended into. With follow, the info of a symlink is that of its
+// target, and a linked directory is walked under the path of the link, so
+// its files can be opened through it. A dangling link, or a link back to a
+// directory that is being walked, is an error then.
+func walkTree(root string, follow bool, fn func(p string, info fs.FileInfo) error) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		return err
+	}
+	return walkEntry(root, info, follow, nil, fn)
+}
+
+// walkEntry walks p, whose Lstat info is info. With follow, chain holds the
+// resolved paths of the root and of the linked directories being walked,
+// which is where a loop would have to return to; it is nil for the root.
+func walkEntry(p string, info fs.FileInfo, follow bool, chain []string, fn func(string, fs.FileInfo) error) error {
+	if follow && (info.Mode()&fs.ModeSymlink != 0 || chain == nil) {
+		target, err := os.Stat(p)
+		if err != nil {
+			return fmt.Errorf("follow symlink %s: %w", p, err)
+		}
+		info = target
+		if info.IsDir() {
+			resolved, err := filepath.EvalSymlinks(p)
+			if err != nil {
+				return err
+			}
+			for _, dir := range chain {
+				if dir == resolved {
+					return fmt.Errorf("follow symlink %s: it loops back to %s", p, resolved)
+				}
+			}
+			chain = append(chain[:len(chain):len(chain)], resolved)
+		}
+	}
+	if err := fn(p, info); err != nil || !info.IsDir() {
+		return err
+	}
+	entries, err := os.ReadDir(p)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		if err := walkEntry(filepath.Join(p, e.Name()), info, follow, chain, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isSymlink reports whether the file at path is a symlink.
+func isSymlink(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode()&fs.ModeSymlink != 0
+}
+
+// UploadSymlink uploads the symlink at path to blobPath as an empty blob
+// recording the link's target, from which Download recreates the link. The
+// target is not uploaded, and need not exist.
+func (c *AzureBlobClient) UploadSymlink(ctx context.Context, path, blobPath string) error {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return err
+	}
+	return c.withRebuild(ctx, "upload", blobPath, func() error {
+		if err := c.init(ctx); err != nil {
+			return err
+		}
+		blob := c.containerClient.NewBlockBlobClient(blobPath)
+		_, err := blob.Upload(ctx, streaming.NopCloser(bytes.NewReader(nil)), &azblob.UploadBlockBlobOptions{
+			Metadata:     map[string]string{linkTargetMetadataKey: filepath.ToSlash(target)},
+			CpkScopeInfo: c.clientOptions().cpkScopeInfo(),
+		})
+		if err != nil {
+			return newBlobError("upload", blobPath, err)
+		}
+		return c.protectUpload(ctx, blobPath)
+	})
+}
+
+type downloadRootKey struct{}
+
+// withDownloadRoot returns a copy of ctx under which downloads restore
+// symlinks pointing anywhere under dir, such as the directory of a
+// multi-file download. Without it, links may only point beside or below
+// their destination.
+func withDownloadRoot(ctx context.Context, dir string) context.Context {
+	return context.WithValue(ctx, downloadRootKey{}, dir)
+}
+
+// downloadRoot returns the directory the symlinks restored at destination
+// under ctx must point into.
+func downloadRoot(ctx context.Context, destination string) string {
+	if dir, ok := ctx.Value(downloadRootKey{}).(string); ok {
+		return dir
+	}
+	return filepath.Dir(destination)
+}
+
+// restoreSymlink replaces the file at destination by a symlink to target,
+// given with / separators as UploadSymlink records it. Only the link is
+// written, never what it points to. Since anyone who can write the blob's
+// metadata chooses target, links are only restored with symlinksPreserve,
+// and never to an absolute path or one outside the download root, which
+// later downloads would write through.
+func (c *AzureBlobClient) restoreSymlink(ctx context.Context, asset, destination, target string) error {
+	if c.clientOptions().Symlinks != symlinksPreserve {
+		return fmt.Errorf("download %q: the blob is a symlink to %q; pass -preserve-symlinks to restore it", asset, target)
+	}
+	link := filepath.FromSlash(target)
+	if filepath.IsAbs(link) || filepath.VolumeName(link) != "" || strings.HasPrefix(target, "/") {
+		return fmt.Errorf("download %q: refusing to restore a symlink to the absolute path %q", asset, target)
+	}
+	root, err := filepath.Abs(downloadRoot(ctx, destination))
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(destination)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, filepath.Join(filepath.Dir(abs), link))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("download %q: refusing to restore a symlink to %q outside %s", asset, target, root)
+	}
+	if err := os.Remove(destination); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.Symlink(link, destination)
+}
@@ -0,0 +1,221 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// symlinkTree creates a directory holding a file, a link to it, a linked
+// directory and a dangling link.
+func symlinkTree(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "real", "f.txt"), "content")
+	for link, target := range map[string]string{
+		"latest":  "real/f.txt",
+		"linked":  "real",
+		"missing": "gone",
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func walkNames(t *testing.T, root string, follow bool) ([]string, error) {
+	t.Helper()
+	var names []string
+	err := walkTree(root, follow, func(p string, info fs.FileInfo) error {
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		kind := "file"
+		if info.IsDir() {
+			kind = "dir"
+		} else if info.Mode()&fs.ModeSymlink != 0 {
+			kind = "link"
+		}
+		names = append(names, filepath.ToSlash(rel)+" "+kind)
+		return nil
+	})
+	return names, err
+}
+
+func TestWalkTree(t *testing.T) {
+	dir := symlinkTree(t)
+	got, err := walkNames(t, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{". dir", "latest link", "linked link", "missing link", "real dir", "real/f.txt file"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("walk = %v, want %v", got, want)
+	}
+
+	if _, err := walkNames(t, dir, true); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("following a dangling link = %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "missing")); err != nil {
+		t.Fatal(err)
+	}
+	got, err = walkNames(t, dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{". dir", "latest file", "linked dir", "linked/f.txt file", "real dir", "real/f.txt file"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("followed walk = %v, want %v", got, want)
+	}
+
+	if err := os.Symlink("..", filepath.Join(dir, "real", "up")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := walkNames(t, dir, true); err == nil || !strings.Contains(err.Error(), "loops") {
+		t.Errorf("following a loop = %v", err)
+	}
+}
+
+func TestArtifactItemsSymlinks(t *testing.T) {
+	dir := symlinkTree(t)
+	if err := os.Remove(filepath.Join(dir, "missing")); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		symlinks string
+		want     []string
+	}{
+		{"", []string{"real/f.txt"}},
+		{symlinksFollow, []string{"latest", "linked/f.txt", "real/f.txt"}},
+		{symlinksPreserve, []string{"latest", "linked", "real/f.txt"}},
+	} {
+		items, err := artifactItems(nil, dir, "**", "", tt.symlinks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, item := range items {
+			got = append(got, item.Blob)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("symlinks=%q: got %v, want %v", tt.symlinks, got, tt.want)
+		}
+	}
+}
+
+func TestPreserveSymlinksRoundTrip(t *testing.T) {
+	dir := symlinkTree(t)
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	az.ClientOptions.Symlinks = symlinksPreserve
+	ctx := context.Background()
+	results := az.RunManifest(ctx, &Manifest{Uploads: []ManifestItem{
+		{Blob: "latest", Path: filepath.Join(dir, "latest")},
+		{Blob: "missing", Path: filepath.Join(dir, "missing")},
+		{Blob: "f.txt", Path: filepath.Join(dir, "real", "f.txt")},
+	}})
+	if err := manifestError(results); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.blobs["latest"]; len(got.data) != 0 || got.metadata[linkTargetMetadataKey] != "real/f.txt" {
+		t.Errorf("link blob = %q, %v", got.data, got.metadata)
+	}
+
+	out := t.TempDir()
+	// An existing file is replaced by the link, not written through.
+	writeFile(t, filepath.Join(out, "latest"), "old")
+	for _, name := range []string{"latest", "missing"} {
+		if _, err := az.Download(ctx, name, filepath.Join(out, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := az.DownloadResumable(ctx, "latest", filepath.Join(out, "resumed"), filepath.Join(out, "state")); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"latest": "real/f.txt", "missing": "gone", "resumed": "real/f.txt"} {
+		if got, err := os.Readlink(filepath.Join(out, name)); err != nil || got != filepath.FromSlash(want) {
+			t.Errorf("%s: link = %q, %v", name, got, err)
+		}
+	}
+	if !bytes.Equal(m.blobs["f.txt"].data, []byte("content")) {
+		t.Errorf("regular file uploaded as %q", m.blobs["f.txt"].data)
+	}
+}
+
+func TestDownloadSymlinkChecks(t *testing.T) {
+	m := newMemContainer()
+	m.put("link", nil, map[string]string{linkTargetMetadataKey: "f.txt"})
+	m.put("absolute", nil, map[string]string{linkTargetMetadataKey: "/etc"})
+	m.put("escape", nil, map[string]string{linkTargetMetadataKey: "../../etc"})
+	m.put("d/up", nil, map[string]string{linkTargetMetadataKey: "../f.txt"})
+	az := newTestClient(t, m)
+	ctx := context.Background()
+	out := t.TempDir()
+
+	// Without -preserve-symlinks no link is restored.
+	if _, err := az.Download(ctx, "link", filepath.Join(out, "link")); err == nil || !strings.Contains(err.Error(), "-preserve-symlinks") {
+		t.Errorf("download without -preserve-symlinks = %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(out, "link")); !os.IsNotExist(err) {
+		t.Errorf("a link was restored without -preserve-symlinks: %v", err)
+	}
+
+	az.ClientOptions.Symlinks = symlinksPreserve
+	for _, name := range []string{"absolute", "escape"} {
+		if _, err := az.Download(ctx, name, filepath.Join(out, name)); err == nil || !strings.Contains(err.Error(), "refusing") {
+			t.Errorf("download of %s = %v", name, err)
+		}
+		if err := az.DownloadResumable(ctx, name, filepath.Join(out, name), filepath.Join(out, "state")); err == nil {
+			t.Errorf("resumable download of %s succeeded", name)
+		}
+		if _, err := os.Lstat(filepath.Join(out, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was restored: %v", name, err)
+		}
+	}
+	// A link may point up to the root of a multi-file download, but not
+	// above a single file's directory.
+	if _, err := az.Download(ctx, "d/up", filepath.Join(out, "up")); err == nil {
+		t.Error("a link above the destination's directory was restored")
+	}
+	if err := downloadAll(ctx, az, []string{"d/up"}, out); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.Readlink(filepath.Join(out, "d", "up")); err != nil || got != filepath.FromSlash("../f.txt") {
+		t.Errorf("link = %q, %v", got, err)
+	}
+}
+
+func TestArchiveFollowSymlinks(t *testing.T) {
+	dir := symlinkTree(t)
+	if err := os.Remove(filepath.Join(dir, "missing")); err != nil {
+		t.Fatal(err)
+	}
+	az := newTestClient(t, newMemContainer())
+	az.ClientOptions.Symlinks = symlinksFollow
+	ctx := context.Background()
+	stats, err := az.UploadArchive(ctx, dir, "snap.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Files != 3 {
+		t.Errorf("archived %d files, want the file and both links to it", stats.Files)
+	}
+	out := t.TempDir()
+	if _, err := az.DownloadArchive(ctx, "snap.tar", out); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"latest", "linked/f.txt"} {
+		p := filepath.Join(out, filepath.FromSlash(name))
+		if isSymlink(p) || readFile(t, p) != "content" {
+			t.Errorf("%s was not archived as the file it links to", name)
+		}
+	}
+}
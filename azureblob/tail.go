@@ -0,0 +1,248 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+const (
+	// defaultTailInterval is how long read data waits at most before
+	// TailToBlob appends it.
+	defaultTailInterval = 5 * time.Second
+	// maxAppendBlock is the largest block the service accepts in one
+	// append.
+	maxAppendBlock = 4 << 20
+	// tailReadSize is the size of the reads TailToBlob makes from its
+	// source.
+	tailReadSize = 64 << 10
+	// followPollInterval is how often a followed file is checked for data
+	// written after its end was reached.
+	followPollInterval = 250 * time.Millisecond
+)
+
+// TailOptions configures TailToBlob.
+type TailOptions struct {
+	// Interval is how long read data waits at most before it is appended.
+	// Zero means five seconds.
+	Interval time.Duration
+	// Append keeps the content of an existing blob and appends to it,
+	// instead of replacing the blob with an empty one.
+	Append bool
+	// Stop, once closed, ends the stream as if the source were exhausted.
+	// Everything read until then is still appended.
+	Stop <-chan struct{}
+}
+
+// TailToBlob appends what src yields to the append blob blobPath until src
+// is exhausted or opts.Stop is closed, so readers of the blob see the data
+// in near real time. Data is appended at least every opts.Interval and as
+// soon as a full block is pending. It returns the number of bytes appended.
+// Client-side compression and encryption do not apply to append blobs.
+func (c *AzureBlobClient) TailToBlob(ctx context.Context, src io.Reader, blobPath string, opts TailOptions) (int64, error) {
+	if o := c.clientOptions(); o.Compression != "" || o.EncryptUploads {
+		return 0, fmt.Errorf("append %q: append blobs cannot be compressed or encrypted client-side", blobPath)
+	}
+	if err := c.init(ctx); err != nil {
+		return 0, err
+	}
+	t := &tailer{c: c, blob: c.containerClient.NewAppendBlobClient(blobPath), name: blobPath}
+	if err := t.open(ctx, opts.Append); err != nil {
+		return 0, err
+	}
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = defaultTailInterval
+	}
+
+	// The reads are pooled buffers, trimmed to what was read, which are
+	// put back once appended to pending.
+	chunks := make(chan *[]byte)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			buf := getBuffer(tailReadSize)
+			n, err := src.Read(*buf)
+			if n > 0 {
+				*buf = (*buf)[:n]
+				select {
+				case chunks <- buf:
+				case <-done:
+					return
+				}
+			} else {
+				putBuffer(buf)
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var pending []byte
+	for reading := true; reading; {
+		select {
+		case b := <-chunks:
+			pending = append(pending, *b...)
+			putBuffer(b)
+			for len(pending) >= maxAppendBlock {
+				if err := t.append(ctx, pending[:maxAppendBlock]); err != nil {
+					return t.appended(), err
+				}
+				pending = pending[maxAppendBlock:]
+			}
+		case <-ticker.C:
+			if err := t.append(ctx, pending); err != nil {
+				return t.appended(), err
+			}
+			pending = nil
+		case err := <-readErr:
+			if err != io.EOF {
+				if ferr := t.append(ctx, pending); ferr != nil {
+					return t.appended(), ferr
+				}
+				return t.appended(), err
+			}
+			reading = false
+		case <-opts.Stop:
+			reading = false
+		}
+	}
+	if err := t.append(ctx, pending); err != nil {
+		return t.appended(), err
+	}
+	return t.appended(), c.protectUpload(ctx, blobPath)
+}
+
+// tailer appends to one append blob, tracking where the next append must
+// land.
+type tailer struct {
+	c      *AzureBlobClient
+	blob   azblob.AppendBlobClient
+	name   string
+	start  int64
+	offset int64
+}
+
+// open creates the blob, or with keep finds the end of an existing one.
+func (t *tailer) open(ctx context.Context, keep bool) error {
+	if keep {
+		props, err := t.c.stat(ctx, t.name)
+		if err == nil {
+			t.start, t.offset = props.Size, props.Size
+			return nil
+		}
+		if !isNotFound(err) {
+			return err
+		}
+	}
+	contentType := "text/plain; charset=utf-8"
+	_, err := t.blob.Create(ctx, &azblob.CreateAppendBlobOptions{
+		HTTPHeaders:  &azblob.BlobHTTPHeaders{BlobContentType: &contentType},
+		CpkScopeInfo: t.c.clientOptions().cpkScopeInfo(),
+	})
+	return newBlobError("create", t.name, err)
+}
+
+// appended returns the number of bytes appended so far.
+func (t *tailer) appended() int64 {
+	return t.offset - t.start
+}
+
+// append appends data, if any, at the current offset. Each append is
+// conditional on that offset, so a retried request cannot duplicate data:
+// if the condition fails because the first attempt did land, the blob's
+// size shows it.
+func (t *tailer) append(ctx context.Context, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	pos := t.offset
+	_, err := t.blob.AppendBlock(ctx, streaming.NopCloser(bytes.NewReader(data)), &azblob.AppendBlockOptions{
+		AppendPositionAccessConditions: &azblob.AppendPositionAccessConditions{AppendPosition: &pos},
+		CpkScopeInfo:                   t.c.clientOptions().cpkScopeInfo(),
+	})
+	if err = newBlobError("append", t.name, err); err != nil {
+		var be *BlobError
+		if !errors.As(err, &be) || be.ErrorCode != "AppendPositionConditionNotMet" {
+			return err
+		}
+		props, serr := t.c.stat(ctx, t.name)
+		if serr != nil || props.Size != pos+int64(len(data)) {
+			return err
+		}
+	}
+	t.offset += int64(len(data))
+	return nil
+}
+
+// followReader reads a file like tail -f: at its end it waits for more data
+// instead of returning io.EOF, until stop is closed.
+type followReader struct {
+	f    *os.File
+	stop <-chan struct{}
+}
+
+func (r *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.f.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		select {
+		case <-r.stop:
+			return 0, io.EOF
+		case <-time.After(followPollInterval):
+		}
+	}
+}
+
+func runTailToBlob(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("tail-to-blob", flag.ContinueOnError)
+	follow := fs.String("follow", "", "follow `file` as it grows instead of reading stdin")
+	interval := fs.Duration("interval", defaultTailInterval, "append read data at least this often")
+	keep := fs.Bool("append", false, "append to an existing blob instead of replacing it")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: tail-to-blob [flags] <blob>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("tail-to-blob takes a blob name")
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("-interval must be positive, got %s", *interval)
+	}
+	// An interrupt ends the stream rather than the requests, so what was
+	// read before it still reaches the blob.
+	var src io.Reader = os.Stdin
+	if *follow != "" {
+		f, err := os.Open(*follow)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		src = &followReader{f: f, stop: ctx.Done()}
+	}
+	n, err := az.TailToBlob(context.Background(), src, fs.Arg(0), TailOptions{Interval: *interval, Append: *keep, Stop: ctx.Done()})
+	if err != nil {
+		return err
+	}
+	fmt.Println(az.Messages.format(MsgAppended, fs.Arg(0), formatBytes(n)))
+	return nil
+}
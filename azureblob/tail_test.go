@@ -0,0 +1,136 @@
+package azureblob
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTailToBlob(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	ctx := context.Background()
+	// Larger than a block, so it is appended in several.
+	log := strings.Repeat("line of build output\n", maxAppendBlock/10)
+	n, err := az.TailToBlob(ctx, strings.NewReader(log), "log.txt", TailOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(log)) || string(m.blobs["log.txt"].data) != log {
+		t.Errorf("appended %d bytes, blob holds %d, want %d", n, len(m.blobs["log.txt"].data), len(log))
+	}
+	if m.appends < 2 {
+		t.Errorf("appended in %d blocks, want several", m.appends)
+	}
+
+	// Without Append the blob is replaced; with it, extended.
+	if _, err := az.TailToBlob(ctx, strings.NewReader("first\n"), "log.txt", TailOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	n, err = az.TailToBlob(ctx, strings.NewReader("second\n"), "log.txt", TailOptions{Append: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(m.blobs["log.txt"].data); n != 7 || got != "first\nsecond\n" {
+		t.Errorf("appended %d bytes, blob holds %q", n, got)
+	}
+	if _, err := az.TailToBlob(ctx, strings.NewReader("new\n"), "new.txt", TailOptions{Append: true}); err != nil {
+		t.Errorf("Append to a missing blob: %v", err)
+	}
+}
+
+func TestTailToBlobFlushesOnInterval(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	stop := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		_, err := az.TailToBlob(context.Background(), pr, "log.txt", TailOptions{Interval: 10 * time.Millisecond, Stop: stop})
+		errc <- err
+	}()
+	pw.Write([]byte("started\n"))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m.mu.Lock()
+		b := m.blobs["log.txt"]
+		got := b != nil && string(b.data) == "started\n"
+		m.mu.Unlock()
+		if got {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("data was not appended while the source stayed open")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(stop)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTailToBlobRejectsEncoding(t *testing.T) {
+	az := newTestClient(t, newMemContainer())
+	az.ClientOptions.Compression = compressionGzip
+	if _, err := az.TailToBlob(context.Background(), strings.NewReader("x"), "log.txt", TailOptions{}); err == nil {
+		t.Error("TailToBlob compressed an append blob")
+	}
+}
+
+func TestAppendRetriedAfterLanding(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	ctx := context.Background()
+	if err := az.init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m.put("log.txt", []byte("landed"), nil)
+	tl := &tailer{c: az, blob: az.containerClient.NewAppendBlobClient("log.txt"), name: "log.txt"}
+	// As if the first attempt of this append had landed and its response
+	// was lost.
+	if err := tl.append(ctx, []byte("landed")); err != nil {
+		t.Fatal(err)
+	}
+	if string(m.blobs["log.txt"].data) != "landed" || tl.offset != 6 {
+		t.Errorf("blob holds %q at offset %d", m.blobs["log.txt"].data, tl.offset)
+	}
+	// A different append at the wrong position still fails.
+	tl.offset = 0
+	if err := tl.append(ctx, []byte("x")); err == nil {
+		t.Error("append at a stale position succeeded")
+	}
+}
+
+func TestFollowReader(t *testing.T) {
+	path := writeFile(t, filepath.Join(t.TempDir(), "log"), "one\n")
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stop := make(chan struct{})
+	r := &followReader{f: f, stop: stop}
+	buf := make([]byte, 16)
+	if n, err := r.Read(buf); err != nil || string(buf[:n]) != "one\n" {
+		t.Fatalf("Read = %q, %v", buf[:n], err)
+	}
+	go func() {
+		time.Sleep(2 * followPollInterval)
+		a, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		a.WriteString("two\n")
+		a.Close()
+	}()
+	if n, err := r.Read(buf); err != nil || string(buf[:n]) != "two\n" {
+		t.Fatalf("Read after growth = %q, %v", buf[:n], err)
+	}
+	close(stop)
+	if _, err := r.Read(buf); err != io.EOF {
+		t.Errorf("Read after stop = %v, want EOF", err)
+	}
+}
@@ -0,0 +1,121 @@
+package azureblob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// anyTenant, as an additionally allowed tenant, allows every tenant.
+const anyTenant = "*"
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx under which blob requests are authorized
+// with tokens from tenantID, which must be the client's TenantID or one of
+// its AdditionallyAllowedTenants. It overrides the tenant the client found
+// the storage account in.
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+func tenantFrom(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// parseTenants splits a list of tenant IDs separated by commas or
+// semicolons, the separator of AZURE_ADDITIONALLY_ALLOWED_TENANTS.
+func parseTenants(s string) []string {
+	var tenants []string
+	for _, t := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ';' }) {
+		if t = strings.TrimSpace(t); t != "" {
+			tenants = append(tenants, t)
+		}
+	}
+	return tenants
+}
+
+// tenantCredential gets tokens from the tenant a request names in its
+// TenantID, which the pinned azidentity credentials ignore. Tokens from the
+// home tenant, or for requests naming none, come from home; those from each
+// allowed tenant from a credential build returns for it on first use.
+type tenantCredential struct {
+	home       azcore.TokenCredential
+	homeTenant string
+	allowed    []string
+	build      func(tenantID string) (azcore.TokenCredential, error)
+
+	mu     sync.Mutex
+	others map[string]azcore.TokenCredential
+}
+
+func (t *tenantCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (*azcore.AccessToken, error) {
+	if opts.TenantID == "" || strings.EqualFold(opts.TenantID, t.homeTenant) {
+		return t.home.GetToken(ctx, opts)
+	}
+	cred, err := t.credential(opts.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	return cred.GetToken(ctx, opts)
+}
+
+// credential returns the credential of tenantID, building it on first use.
+func (t *tenantCredential) credential(tenantID string) (azcore.TokenCredential, error) {
+	if !tenantAllowed(t.allowed, tenantID) {
+		return nil, fmt.Errorf("tenant %s is not an additionally allowed tenant; add it to -additionally-allowed-tenants to get tokens from it", tenantID)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := strings.ToLower(tenantID)
+	if cred := t.others[key]; cred != nil {
+		return cred, nil
+	}
+	cred, err := t.build(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if t.others == nil {
+		t.others = map[string]azcore.TokenCredential{}
+	}
+	t.others[key] = cred
+	return cred, nil
+}
+
+func tenantAllowed(allowed []string, tenantID string) bool {
+	for _, a := range allowed {
+		if a == anyTenant || strings.EqualFold(a, tenantID) {
+			return true
+		}
+	}
+	return false
+}
+
+// challengeTenant returns the tenant named by the authorization_uri of the
+// bearer challenge of resp, which the service sends with a 401 to say which
+// tenant the storage account trusts, or "" if there is none.
+func challengeTenant(resp *http.Response) string {
+	for _, challenge := range resp.Header.Values("WWW-Authenticate") {
+		for _, field := range strings.Fields(challenge) {
+			value := strings.TrimPrefix(field, "authorization_uri=")
+			if value == field {
+				continue
+			}
+			u, err := url.Parse(strings.Trim(value, `",`))
+			if err != nil {
+				continue
+			}
+			if tenant := strings.Split(strings.Trim(u.Path, "/"), "/")[0]; tenant != "" {
+				return tenant
+			}
+		}
+	}
+	return ""
+}
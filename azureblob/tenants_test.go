@@ -0,0 +1,143 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// tenantTokenCredential issues tokens naming the tenant they were requested
+// from, "home" when none was.
+type tenantTokenCredential struct{}
+
+func (tenantTokenCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (*azcore.AccessToken, error) {
+	tenant := opts.TenantID
+	if tenant == "" {
+		tenant = "home"
+	}
+	return &azcore.AccessToken{Token: "token-" + tenant, ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestParseTenants(t *testing.T) {
+	got := parseTenants(" t1, t2;;t3 ,")
+	if strings.Join(got, " ") != "t1 t2 t3" {
+		t.Errorf("parseTenants = %q", got)
+	}
+	if parseTenants("") != nil {
+		t.Error("an empty list has tenants")
+	}
+}
+
+func TestTenantCredential(t *testing.T) {
+	var built []string
+	cred := &tenantCredential{
+		home:       staticCredential{},
+		homeTenant: "home",
+		allowed:    []string{"other"},
+		build: func(tenantID string) (azcore.TokenCredential, error) {
+			built = append(built, tenantID)
+			return tenantTokenCredential{}, nil
+		},
+	}
+	ctx := context.Background()
+	for _, tenant := range []string{"", "HOME", "other", "Other"} {
+		if _, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{storageScope}, TenantID: tenant}); err != nil {
+			t.Fatalf("tenant %q: %v", tenant, err)
+		}
+	}
+	if strings.Join(built, " ") != "other" {
+		t.Errorf("built credentials for %q, want one for the other tenant", built)
+	}
+	if _, err := cred.GetToken(ctx, policy.TokenRequestOptions{TenantID: "stranger"}); err == nil || !strings.Contains(err.Error(), "additionally allowed") {
+		t.Errorf("token from a tenant not allowed: %v", err)
+	}
+	cred.allowed = []string{anyTenant}
+	if _, err := cred.GetToken(ctx, policy.TokenRequestOptions{TenantID: "stranger"}); err != nil {
+		t.Errorf("token with any tenant allowed: %v", err)
+	}
+}
+
+func TestChallengeTenant(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set("WWW-Authenticate", `Bearer authorization_uri=https://login.microsoftonline.com/7a2b/oauth2/authorize resource_id=https://storage.azure.com`)
+	if got := challengeTenant(resp); got != "7a2b" {
+		t.Errorf("challengeTenant = %q, want 7a2b", got)
+	}
+	resp.Header.Set("WWW-Authenticate", `Basic realm="x"`)
+	if got := challengeTenant(resp); got != "" {
+		t.Errorf("challengeTenant of a basic challenge = %q", got)
+	}
+}
+
+// tenantAccount serves m to requests authorized by a token of tenant, and
+// challenges others to get one from it.
+func tenantAccount(tenant string, m http.Handler) (http.Handler, func() []string) {
+	var mu sync.Mutex
+	var seen []string
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth := r.Header.Get("Authorization")
+			mu.Lock()
+			seen = append(seen, auth)
+			mu.Unlock()
+			if auth != "Bearer token-"+tenant {
+				w.Header().Set("WWW-Authenticate", "Bearer authorization_uri=https://login.microsoftonline.com/"+tenant+"/oauth2/authorize resource_id=https://storage.azure.com")
+				w.Header().Set("x-ms-error-code", "InvalidAuthenticationInfo")
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			m.ServeHTTP(w, r)
+		}), func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string(nil), seen...)
+		}
+}
+
+func TestBearerTokenPolicyFollowsTenantChallenge(t *testing.T) {
+	m := newMemContainer()
+	m.put("blob", []byte("data"), nil)
+	h, seen := tenantAccount("other", m)
+	az := newTestClient(t, h)
+	cred := azcore.TokenCredential(tenantTokenCredential{})
+	az.credential = &cred
+	for i := 0; i < 2; i++ {
+		if _, err := az.Stat(context.Background(), "blob"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "Bearer token-home,Bearer token-other,Bearer token-other"
+	if got := strings.Join(seen(), ","); got != want {
+		t.Errorf("Authorization headers = %s, want %s", got, want)
+	}
+	if az.generation != 0 {
+		t.Error("a tenant challenge rebuilt the client")
+	}
+}
+
+func TestWithTenant(t *testing.T) {
+	m := newMemContainer()
+	m.put("blob", []byte("data"), nil)
+	h, seen := tenantAccount("other", m)
+	az := newTestClient(t, h)
+	cred := azcore.TokenCredential(tenantTokenCredential{})
+	az.credential = &cred
+	if _, err := az.Stat(WithTenant(context.Background(), "other"), "blob"); err != nil {
+		t.Fatal(err)
+	}
+	if got := seen(); len(got) != 1 || got[0] != "Bearer token-other" {
+		t.Errorf("Authorization headers = %q, want one with a token of the tenant", got)
+	}
+	// An explicit tenant is not overridden by the challenge.
+	_, err := az.Stat(WithTenant(context.Background(), "third"), "blob")
+	var be *BlobError
+	if !errors.As(err, &be) || be.StatusCode != http.StatusUnauthorized {
+		t.Errorf("request with another tenant = %v, want HTTP 401", err)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package azureblob
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// openTerminalInput returns os.Stdin, whose blocked Read cannot be
+// interrupted on this platform. Once its reader is told to stop, it ends
+// with the next key read instead.
+func openTerminalInput(fd int) (io.ReadCloser, error) {
+	return ioutil.NopCloser(os.Stdin), nil
+}
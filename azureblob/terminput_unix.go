@@ -0,0 +1,40 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package azureblob
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// terminalInput reads a terminal through a non-blocking duplicate of its
+// file descriptor, which Go's poller serves, so that closing it stops a
+// read in progress.
+type terminalInput struct {
+	*os.File
+	fd int
+}
+
+// openTerminalInput returns a reader of the terminal open as fd whose Close
+// interrupts a blocked Read, unlike os.Stdin's.
+func openTerminalInput(fd int) (io.ReadCloser, error) {
+	dup, err := syscall.Dup(fd)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.SetNonblock(dup, true); err != nil {
+		syscall.Close(dup)
+		return nil, err
+	}
+	return &terminalInput{File: os.NewFile(uintptr(dup), "/dev/stdin"), fd: fd}, nil
+}
+
+// Close closes the duplicate. As it shares the non-blocking mode with fd,
+// the mode is reset for the shell and later reads of fd.
+func (t *terminalInput) Close() error {
+	err := t.File.Close()
+	syscall.SetNonblock(t.fd, false)
+	return err
+}
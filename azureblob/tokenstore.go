@@ -0,0 +1,172 @@
+package azureblob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/zalando/go-keyring"
+)
+
+// Token cache modes of -token-cache.
+const (
+	tokenCacheMemory   = "memory"
+	tokenCacheKeychain = "keychain"
+)
+
+// keychainService is the service name under which tokens are stored in the
+// OS keychain.
+const keychainService = "bk_azureblob"
+
+// ErrTokenNotStored is returned by a TokenStore that holds no token under a
+// key.
+var ErrTokenNotStored = errors.New("no token stored")
+
+// TokenStore keeps access tokens beyond the life of the process, so that
+// short-lived jobs on one machine reuse a sign-in until its tokens expire.
+// Keys are opaque and free of secrets.
+type TokenStore interface {
+	Get(key string) (*azcore.AccessToken, error)
+	Set(key string, token *azcore.AccessToken) error
+	Delete(key string) error
+}
+
+// parseTokenCache checks the value of -token-cache and returns the store it
+// names, or nil for tokenCacheMemory.
+func parseTokenCache(s string) (TokenStore, error) {
+	switch s {
+	case "", tokenCacheMemory:
+		return nil, nil
+	case tokenCacheKeychain:
+		return KeychainTokenStore{}, nil
+	}
+	return nil, fmt.Errorf("unknown token cache %q, want %s or %s", s, tokenCacheMemory, tokenCacheKeychain)
+}
+
+// KeychainTokenStore stores tokens in the OS credential store: the Keychain
+// on macOS, the Credential Manager on Windows, and the Secret Service, such
+// as GNOME Keyring, through D-Bus elsewhere.
+type KeychainTokenStore struct{}
+
+// storedToken is the form in which KeychainTokenStore stores a token.
+type storedToken struct {
+	Token     string    `json:"token"`
+	ExpiresOn time.Time `json:"expiresOn"`
+}
+
+func (KeychainTokenStore) Get(key string) (*azcore.AccessToken, error) {
+	s, err := keyring.Get(keychainService, key)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil, ErrTokenNotStored
+	}
+	if err != nil {
+		return nil, err
+	}
+	var t storedToken
+	if err := json.Unmarshal([]byte(s), &t); err != nil {
+		return nil, fmt.Errorf("stored token %s: %w", key, err)
+	}
+	return &azcore.AccessToken{Token: t.Token, ExpiresOn: t.ExpiresOn}, nil
+}
+
+func (KeychainTokenStore) Set(key string, token *azcore.AccessToken) error {
+	b, err := json.Marshal(storedToken{Token: token.Token, ExpiresOn: token.ExpiresOn})
+	if err != nil {
+		return err
+	}
+	return keyring.Set(keychainService, key, string(b))
+}
+
+func (KeychainTokenStore) Delete(key string) error {
+	err := keyring.Delete(keychainService, key)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// storedCredential wraps a credential, keeping its tokens in store as well,
+// where later processes signing in as the same identity find them. If the
+// store fails, as when no keychain is running on a headless machine, tokens
+// are only cached in memory, and the failure is logged once.
+type storedCredential struct {
+	cred  azcore.TokenCredential
+	store TokenStore
+	// identity tells the tokens of other identities, and of other
+	// credential chains, apart.
+	identity string
+	messages Messages
+
+	mu     sync.Mutex
+	failed bool
+	// keys are the keys of the tokens saved or found, which forget
+	// deletes.
+	keys map[string]bool
+}
+
+func (s *storedCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (*azcore.AccessToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := s.key(opts)
+	if !s.failed {
+		token, err := s.store.Get(key)
+		switch {
+		case err == nil && time.Until(token.ExpiresOn) >= tokenRefreshWindow:
+			s.remember(key)
+			return token, nil
+		case err != nil && !errors.Is(err, ErrTokenNotStored):
+			s.fail(err)
+		}
+	}
+	token, err := s.cred.GetToken(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !s.failed {
+		if err := s.store.Set(key, token); err != nil {
+			s.fail(err)
+		} else {
+			s.remember(key)
+		}
+	}
+	return token, nil
+}
+
+// key returns the key of the token of opts. Tokens are stored under a hash
+// so the key does not reveal the identity or its tenant.
+func (s *storedCredential) key(opts policy.TokenRequestOptions) string {
+	sum := sha256.Sum256([]byte(s.identity + "\x00" + strings.Join(opts.Scopes, " ") + "@" + opts.TenantID))
+	return hex.EncodeToString(sum[:16])
+}
+
+func (s *storedCredential) remember(key string) {
+	if s.keys == nil {
+		s.keys = map[string]bool{}
+	}
+	s.keys[key] = true
+}
+
+func (s *storedCredential) fail(err error) {
+	s.failed = true
+	log.Print(s.messages.format(MsgTokenStoreFailed, err))
+}
+
+// forget deletes the tokens it stored or used, so that neither this
+// process nor another one presents a token the service rejected again.
+func (s *storedCredential) forget() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.keys {
+		s.store.Delete(key)
+	}
+	s.keys = nil
+}
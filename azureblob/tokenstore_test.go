@@ -0,0 +1,109 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/zalando/go-keyring"
+)
+
+// failingTokenStore is a TokenStore without a keychain behind it.
+type failingTokenStore struct{}
+
+func (failingTokenStore) Get(string) (*azcore.AccessToken, error) {
+	return nil, errors.New("no secret service")
+}
+
+func (failingTokenStore) Set(string, *azcore.AccessToken) error {
+	return errors.New("no secret service")
+}
+
+func (failingTokenStore) Delete(string) error { return errors.New("no secret service") }
+
+func TestStoredCredential(t *testing.T) {
+	keyring.MockInit()
+	opts := policy.TokenRequestOptions{Scopes: []string{storageScope}}
+	ctx := context.Background()
+
+	// A later process signing in as the same identity finds the token.
+	first := &countingCredential{ttl: time.Hour}
+	a := &storedCredential{cred: first, store: KeychainTokenStore{}, identity: "tenant\x00client"}
+	token, err := a.GetToken(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := &countingCredential{ttl: time.Hour}
+	b := &storedCredential{cred: second, store: KeychainTokenStore{}, identity: "tenant\x00client"}
+	if got, err := b.GetToken(ctx, opts); err != nil || got.Token != token.Token || second.calls != 0 {
+		t.Errorf("second process got %v, %v after %d sign-ins", got, err, second.calls)
+	}
+	other := &countingCredential{ttl: time.Hour}
+	c := &storedCredential{cred: other, store: KeychainTokenStore{}, identity: "tenant\x00other"}
+	if _, err := c.GetToken(ctx, opts); err != nil || other.calls != 1 {
+		t.Errorf("another identity used the stored token: %v", err)
+	}
+	stored, err := keyring.Get(keychainService, a.key(opts))
+	if err != nil || strings.Contains(a.key(opts), "client") {
+		t.Errorf("stored under %q: %v", a.key(opts), err)
+	}
+	if !strings.Contains(stored, token.Token) {
+		t.Errorf("stored %q", stored)
+	}
+
+	// A rejected token is deleted for every process.
+	b.forget()
+	if _, err := (KeychainTokenStore{}).Get(a.key(opts)); err != ErrTokenNotStored {
+		t.Errorf("after forget: %v", err)
+	}
+	if _, err := b.GetToken(ctx, opts); err != nil || second.calls != 1 {
+		t.Errorf("after forget: %v, %d sign-ins", err, second.calls)
+	}
+
+	// A token about to expire is replaced.
+	expiring := &countingCredential{ttl: time.Minute}
+	d := &storedCredential{cred: expiring, store: KeychainTokenStore{}, identity: "expiring"}
+	for i := 0; i < 2; i++ {
+		if _, err := d.GetToken(ctx, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expiring.calls != 2 {
+		t.Errorf("%d sign-ins, want the expiring token replaced", expiring.calls)
+	}
+}
+
+func TestStoredCredentialWithoutKeychain(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	cred := &countingCredential{ttl: time.Hour}
+	s := &storedCredential{cred: cred, store: failingTokenStore{}, identity: "id"}
+	opts := policy.TokenRequestOptions{Scopes: []string{storageScope}}
+	for i := 0; i < 2; i++ {
+		if _, err := s.GetToken(context.Background(), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := strings.Count(logs.String(), "only cached in memory"); n != 1 {
+		t.Errorf("logged the failure %d times:\n%s", n, logs.String())
+	}
+}
+
+func TestParseTokenCache(t *testing.T) {
+	for s, want := range map[string]TokenStore{"": nil, "memory": nil, "keychain": KeychainTokenStore{}} {
+		if got, err := parseTokenCache(s); err != nil || got != want {
+			t.Errorf("parseTokenCache(%q) = %v, %v", s, got, err)
+		}
+	}
+	if _, err := parseTokenCache("file"); err == nil {
+		t.Error("parseTokenCache accepted file")
+	}
+}
@@ -0,0 +1,162 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Points of a transfer at which hooks run, as -hook names them.
+const (
+	HookPreUpload    = "pre-upload"
+	HookPostUpload   = "post-upload"
+	HookPreDownload  = "pre-download"
+	HookPostDownload = "post-download"
+)
+
+// goHookPrefix marks a hook spec naming a Go callback registered with
+// RegisterTransferHook rather than a command.
+const goHookPrefix = "go:"
+
+// HookEvent describes the transfer a hook runs around.
+type HookEvent struct {
+	// Point is when the hook runs, such as HookPostDownload.
+	Point string
+	Blob  string
+	// Path is the local file uploaded, or the destination of a download.
+	Path string
+	// Result is the outcome of a successful transfer, for post hooks.
+	Result *TransferResult
+	// Err is why the transfer failed, for post hooks.
+	Err error
+}
+
+// TransferHook runs at a point of a transfer. A pre hook that fails stops
+// the transfer. A post hook runs whether or not the transfer succeeded,
+// and if it fails a successful transfer fails too, as when a virus scan
+// rejects a download. Hooks of concurrent transfers run concurrently.
+type TransferHook func(ctx context.Context, e HookEvent) error
+
+var (
+	transferHooksMu sync.Mutex
+	transferHooks   = map[string]TransferHook{}
+)
+
+// RegisterTransferHook makes hook available to -hook specs as go:name,
+// replacing any hook registered as name before. Programs embedding the
+// client call it from an init function.
+func RegisterTransferHook(name string, hook TransferHook) {
+	transferHooksMu.Lock()
+	defer transferHooksMu.Unlock()
+	transferHooks[name] = hook
+}
+
+// ParseTransferHook returns the hook spec names: go:<name> for a callback
+// registered with RegisterTransferHook, or else a command line, split on
+// spaces, that is run with the file and blob name appended as arguments.
+// The command's environment also has BK_AZUREBLOB_HOOK_POINT,
+// BK_AZUREBLOB_HOOK_BLOB and BK_AZUREBLOB_HOOK_PATH, and for post hooks
+// BK_AZUREBLOB_HOOK_RESULT, the TransferResult as JSON, or
+// BK_AZUREBLOB_HOOK_ERROR.
+func ParseTransferHook(spec string) (TransferHook, error) {
+	if name := strings.TrimPrefix(spec, goHookPrefix); name != spec {
+		transferHooksMu.Lock()
+		hook, ok := transferHooks[name]
+		transferHooksMu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("no transfer hook registered as %q", name)
+		}
+		return hook, nil
+	}
+	args := strings.Fields(spec)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("hook %q has no command", spec)
+	}
+	return func(ctx context.Context, e HookEvent) error {
+		cmd := exec.CommandContext(ctx, args[0], append(args[1:], e.Path, e.Blob)...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		cmd.Env = append(os.Environ(),
+			"BK_AZUREBLOB_HOOK_POINT="+e.Point,
+			"BK_AZUREBLOB_HOOK_BLOB="+e.Blob,
+			"BK_AZUREBLOB_HOOK_PATH="+e.Path,
+		)
+		if e.Result != nil {
+			b, err := json.Marshal(e.Result)
+			if err != nil {
+				return err
+			}
+			cmd.Env = append(cmd.Env, "BK_AZUREBLOB_HOOK_RESULT="+string(b))
+		}
+		if e.Err != nil {
+			cmd.Env = append(cmd.Env, "BK_AZUREBLOB_HOOK_ERROR="+e.Err.Error())
+		}
+		return cmd.Run()
+	}, nil
+}
+
+// withHooks runs transfer of blob to or from path between the hooks of
+// ClientOptions for the points pre and post.
+func (c *AzureBlobClient) withHooks(ctx context.Context, pre, post, blob, path string, transfer func() (*TransferResult, error)) (*TransferResult, error) {
+	hooks := c.clientOptions().Hooks
+	if len(hooks[pre]) == 0 && len(hooks[post]) == 0 {
+		return transfer()
+	}
+	if err := runHooks(ctx, hooks[pre], HookEvent{Point: pre, Blob: blob, Path: path}); err != nil {
+		return nil, err
+	}
+	result, err := transfer()
+	if herr := runHooks(ctx, hooks[post], HookEvent{Point: post, Blob: blob, Path: path, Result: result, Err: err}); herr != nil {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, herr)
+			return nil, err
+		}
+		return nil, herr
+	}
+	return result, err
+}
+
+// runHooks runs hooks in order for e, stopping at the first that fails.
+func runHooks(ctx context.Context, hooks []TransferHook, e HookEvent) error {
+	for _, hook := range hooks {
+		if err := hook(ctx, e); err != nil {
+			return fmt.Errorf("%s hook for %s: %w", e.Point, e.Blob, err)
+		}
+	}
+	return nil
+}
+
+// hookFlag collects repeated "point=spec" flags, parsing each spec with
+// ParseTransferHook.
+type hookFlag map[string][]TransferHook
+
+func (h hookFlag) String() string {
+	points := make([]string, 0, len(h))
+	for point := range h {
+		points = append(points, point)
+	}
+	sort.Strings(points)
+	return strings.Join(points, ",")
+}
+
+func (h hookFlag) Set(v string) error {
+	parts := strings.SplitN(v, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("hook %q is not in point=command form", v)
+	}
+	switch parts[0] {
+	case HookPreUpload, HookPostUpload, HookPreDownload, HookPostDownload:
+	default:
+		return fmt.Errorf("unknown hook point %q; use pre-upload, post-upload, pre-download or post-download", parts[0])
+	}
+	hook, err := ParseTransferHook(parts[1])
+	if err != nil {
+		return err
+	}
+	h[parts[0]] = append(h[parts[0]], hook)
+	return nil
+}
@@ -0,0 +1,180 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTransferHooks(t *testing.T) {
+	m := newMemContainer()
+	m.put("in/report.pdf", []byte("report"), nil)
+	az := newTestClient(t, m)
+	var mu sync.Mutex
+	var events []string
+	record := func(ctx context.Context, e HookEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		line := e.Point + " " + e.Blob + " " + filepath.Base(e.Path)
+		if e.Result != nil {
+			line += fmt.Sprintf(" %d", e.Result.Bytes)
+		}
+		if e.Err != nil {
+			line += " failed"
+		}
+		events = append(events, line)
+		return nil
+	}
+	az.ClientOptions.Hooks = map[string][]TransferHook{}
+	for _, point := range []string{HookPreUpload, HookPostUpload, HookPreDownload, HookPostDownload} {
+		az.ClientOptions.Hooks[point] = []TransferHook{record}
+	}
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	if _, err := az.Download(ctx, "in/report.pdf", filepath.Join(dir, "report.pdf")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := az.Download(ctx, "in/missing", filepath.Join(dir, "missing")); !isNotFound(err) {
+		t.Errorf("downloading a missing blob = %v", err)
+	}
+	f, err := os.Open(writeFile(t, filepath.Join(dir, "build.zip"), "build"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := az.Upload(ctx, f, "out/build.zip"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"pre-download in/report.pdf report.pdf",
+		"post-download in/report.pdf report.pdf 6",
+		"pre-download in/missing missing",
+		"post-download in/missing missing failed",
+		"pre-upload out/build.zip build.zip",
+		"post-upload out/build.zip build.zip 5",
+	}
+	if got := strings.Join(events, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("hook events:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+}
+
+func TestUploadNilFile(t *testing.T) {
+	az := newTestClient(t, newMemContainer())
+	ran := false
+	az.ClientOptions.Hooks = map[string][]TransferHook{HookPreUpload: {func(context.Context, HookEvent) error {
+		ran = true
+		return nil
+	}}}
+	for _, skip := range []bool{false, true} {
+		az.ClientOptions.SkipUnchanged = skip
+		if _, err := az.Upload(context.Background(), nil, "blob"); err == nil || err.Error() != "file cannot be nil" {
+			t.Errorf("Upload of a nil file with SkipUnchanged %v = %v", skip, err)
+		}
+	}
+	if ran {
+		t.Error("a hook ran for a nil file")
+	}
+}
+
+func TestTransferHookFailures(t *testing.T) {
+	m := newMemContainer()
+	m.put("infected.exe", []byte("virus"), nil)
+	az := newTestClient(t, m)
+	rejected := errors.New("rejected")
+	az.ClientOptions.Hooks = map[string][]TransferHook{
+		HookPreUpload: {func(ctx context.Context, e HookEvent) error { return rejected }},
+		HookPostDownload: {func(ctx context.Context, e HookEvent) error {
+			if e.Result == nil {
+				return nil
+			}
+			return rejected
+		}},
+	}
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	f, err := os.Open(writeFile(t, filepath.Join(dir, "unsigned"), "unsigned"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := az.Upload(ctx, f, "unsigned"); !errors.Is(err, rejected) || err.Error() != "pre-upload hook for unsigned: rejected" {
+		t.Errorf("Upload = %v", err)
+	}
+	if _, ok := m.blobs["unsigned"]; ok {
+		t.Error("the upload was made although its pre hook failed")
+	}
+	if r, err := az.Download(ctx, "infected.exe", filepath.Join(dir, "infected.exe")); !errors.Is(err, rejected) || r != nil {
+		t.Errorf("Download = %+v, %v", r, err)
+	}
+	// A post hook failing after a failed transfer leaves the transfer's
+	// error to be returned.
+	if _, err := az.Download(ctx, "missing", filepath.Join(dir, "missing")); !isNotFound(err) {
+		t.Errorf("downloading a missing blob = %v", err)
+	}
+}
+
+func TestHookFlag(t *testing.T) {
+	called := false
+	RegisterTransferHook("test-notarize", func(ctx context.Context, e HookEvent) error {
+		called = true
+		return nil
+	})
+	h := hookFlag{}
+	if err := h.Set("pre-upload=go:test-notarize"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h[HookPreUpload][0](context.Background(), HookEvent{}); err != nil || !called {
+		t.Errorf("registered hook called %v, %v", called, err)
+	}
+	for _, v := range []string{"pre-upload", "during-upload=scan", "post-download= ", "post-download=go:unregistered"} {
+		if err := h.Set(v); err == nil {
+			t.Errorf("-hook %q was accepted", v)
+		}
+	}
+	if h.String() != "pre-upload" {
+		t.Errorf("hookFlag = %q", h.String())
+	}
+}
+
+func TestCommandHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook is a shell script")
+	}
+	dir := t.TempDir()
+	hook := writeFile(t, filepath.Join(dir, "scan.sh"), "#!/bin/sh\n"+
+		"echo \"$@ $BK_AZUREBLOB_HOOK_POINT $BK_AZUREBLOB_HOOK_BLOB $BK_AZUREBLOB_HOOK_PATH\" >> \"$(dirname \"$0\")/log\"\n"+
+		"echo \"$BK_AZUREBLOB_HOOK_RESULT\" >> \"$(dirname \"$0\")/log\"\n"+
+		"test \"$BK_AZUREBLOB_HOOK_BLOB\" != infected\n")
+	if err := os.Chmod(hook, 0755); err != nil {
+		t.Fatal(err)
+	}
+	scan, err := ParseTransferHook(hook + " --quiet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	result := &TransferResult{Blob: "clean", Bytes: 5}
+	if err := scan(ctx, HookEvent{Point: HookPostDownload, Blob: "clean", Path: "/tmp/clean", Result: result}); err != nil {
+		t.Fatal(err)
+	}
+	if err := scan(ctx, HookEvent{Point: HookPostDownload, Blob: "infected", Path: "/tmp/infected"}); err == nil {
+		t.Error("the failing command hook succeeded")
+	}
+	lines := strings.Split(readFile(t, filepath.Join(dir, "log")), "\n")
+	if len(lines) != 5 || lines[0] != "--quiet /tmp/clean clean post-download clean /tmp/clean" || lines[2] != "--quiet /tmp/infected infected post-download infected /tmp/infected" || lines[3] != "" {
+		t.Fatalf("hook log %q", lines)
+	}
+	var got TransferResult
+	if err := json.Unmarshal([]byte(lines[1]), &got); err != nil || got.Blob != "clean" || got.Bytes != 5 {
+		t.Errorf("BK_AZUREBLOB_HOOK_RESULT = %q, %v", lines[1], err)
+	}
+}
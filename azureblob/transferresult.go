@@ -0,0 +1,181 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// TransferResult describes a finished download or upload, e.g. for logging
+// transfer performance per machine.
+type TransferResult struct {
+	// Blob is the blob downloaded or uploaded.
+	Blob string `json:"blob"`
+	// Bytes is the number of bytes sent or received, which for compressed
+	// or encrypted blobs is their size as stored. Downloads served from the
+	// dedup index or recreating a symlink transfer none.
+	Bytes    int64         `json:"bytes"`
+	Duration time.Duration `json:"duration"`
+	// Throughput is the average rate of the transfer in bytes per second.
+	Throughput float64 `json:"throughput"`
+	// Retries counts the requests the retry policy sent again, the blocks
+	// and ranges sent again on their own, the transfers restarted for
+	// missing the minimum throughput and the attempts repeated after
+	// rebuilding the client.
+	Retries int `json:"retries"`
+	// ChunkRetries lists the blocks and ranges that were sent again on
+	// their own after failing, by offset.
+	ChunkRetries []ChunkRetry `json:"chunkRetries,omitempty"`
+	// ETag and ContentMD5 are those of the blob transferred. ContentMD5 is
+	// empty if the service did not report one, as for uploads in blocks.
+	ETag       string `json:"etag"`
+	ContentMD5 []byte `json:"contentMD5,omitempty"`
+	// UpToDate is set on an upload skipped because the blob already held
+	// the file's content; see AzureBlobClientOptions.SkipUnchanged.
+	UpToDate bool `json:"upToDate,omitempty"`
+}
+
+// logTransfer logs a summary of the transfer with result r, op being
+// "download" or "upload".
+func (c *AzureBlobClient) logTransfer(op string, r *TransferResult) {
+	if r.UpToDate {
+		log.Print(c.Messages.format(MsgUpToDate, r.Blob))
+		return
+	}
+	log.Print(c.Messages.format(MsgTransferSummary, op, r.Blob, formatBytes(r.Bytes), r.Duration.Round(time.Millisecond), formatBytes(int64(r.Throughput)), r.Retries))
+}
+
+// transferStats collects the statistics of one transfer while it runs. The
+// counters are updated atomically, as block requests run concurrently.
+type transferStats struct {
+	calls, tries, restarts int64
+
+	mu         sync.Mutex
+	bytes      int64
+	etag       string
+	contentMD5 []byte
+	// chunkRetries counts the retries of each chunk by offset.
+	chunkRetries map[int64]*ChunkRetry
+}
+
+type transferStatsKey struct{}
+
+// withTransferStats returns a copy of ctx that collects the statistics of
+// a transfer in stats.
+func withTransferStats(ctx context.Context) (context.Context, *transferStats) {
+	stats := &transferStats{}
+	return context.WithValue(ctx, transferStatsKey{}, stats), stats
+}
+
+// transferStatsFrom returns the statistics collected under ctx, or nil.
+func transferStatsFrom(ctx context.Context) *transferStats {
+	stats, _ := ctx.Value(transferStatsKey{}).(*transferStats)
+	return stats
+}
+
+// record sets what was transferred. It does nothing on a nil s.
+func (s *transferStats) record(bytes int64, etag string, contentMD5 []byte) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bytes, s.etag, s.contentMD5 = bytes, etag, contentMD5
+}
+
+// restarted counts a transfer or operation started again. It does nothing
+// on a nil s.
+func (s *transferStats) restarted() {
+	if s != nil {
+		atomic.AddInt64(&s.restarts, 1)
+	}
+}
+
+// chunkRetried counts a retry of the chunk of length bytes at offset. It
+// does nothing on a nil s.
+func (s *transferStats) chunkRetried(offset, length int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.chunkRetries == nil {
+		s.chunkRetries = map[int64]*ChunkRetry{}
+	}
+	r := s.chunkRetries[offset]
+	if r == nil {
+		r = &ChunkRetry{Offset: offset, Length: length}
+		s.chunkRetries[offset] = r
+	}
+	r.Retries++
+}
+
+// result returns the result of the transfer of blob begun at start.
+func (s *transferStats) result(blob string, start time.Time) *TransferResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := &TransferResult{
+		Blob:       blob,
+		Bytes:      s.bytes,
+		Duration:   time.Since(start),
+		ETag:       s.etag,
+		ContentMD5: s.contentMD5,
+	}
+	if r.Duration > 0 {
+		r.Throughput = float64(r.Bytes) / r.Duration.Seconds()
+	}
+	// The retry policy passes each request to the transport once per try.
+	if retries := atomic.LoadInt64(&s.tries) - atomic.LoadInt64(&s.calls); retries > 0 {
+		r.Retries = int(retries)
+	}
+	r.Retries += int(atomic.LoadInt64(&s.restarts))
+	for _, cr := range s.chunkRetries {
+		r.ChunkRetries = append(r.ChunkRetries, *cr)
+		r.Retries += cr.Retries
+	}
+	sort.Slice(r.ChunkRetries, func(i, j int) bool { return r.ChunkRetries[i].Offset < r.ChunkRetries[j].Offset })
+	return r
+}
+
+// responseMD5 returns the Content-MD5 of resp, or nil if it has none.
+func responseMD5(resp *http.Response) []byte {
+	if resp == nil {
+		return nil
+	}
+	sum, err := base64.StdEncoding.DecodeString(resp.Header.Get("Content-MD5"))
+	if err != nil || len(sum) == 0 {
+		return nil
+	}
+	return sum
+}
+
+// countingPolicy counts the requests of transfers collecting statistics.
+// It runs once per request, before the retry policy.
+type countingPolicy struct{}
+
+func (countingPolicy) Do(req *policy.Request) (*http.Response, error) {
+	if stats := transferStatsFrom(req.Raw().Context()); stats != nil {
+		atomic.AddInt64(&stats.calls, 1)
+	}
+	return req.Next()
+}
+
+// countingTransport counts the tries of requests of transfers collecting
+// statistics, which the retry policy sends to it once each.
+type countingTransport struct {
+	next policy.Transporter
+}
+
+func (t countingTransport) Do(req *http.Request) (*http.Response, error) {
+	if stats := transferStatsFrom(req.Context()); stats != nil {
+		atomic.AddInt64(&stats.tries, 1)
+	}
+	return t.next.Do(req)
+}
@@ -0,0 +1,81 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+func TestTransferResult(t *testing.T) {
+	m := newMemContainer()
+	// The first GET of the blob fails, so the download is retried once.
+	var failed int32
+	az := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && atomic.CompareAndSwapInt32(&failed, 0, 1) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		m.ServeHTTP(w, r)
+	}))
+	az.ClientOptions.TransferRetry = policy.RetryOptions{MaxRetries: 2, RetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond}
+	ctx := context.Background()
+	content := []byte("transfer statistics")
+	sum := md5.Sum(content)
+
+	f, err := os.Open(writeFile(t, filepath.Join(t.TempDir(), "src"), string(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	up, err := az.Upload(ctx, f, "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up.Blob != "blob" || up.Bytes != int64(len(content)) || up.ETag != m.blobs["blob"].etag || up.Retries != 0 {
+		t.Errorf("upload result = %+v", up)
+	}
+	if !bytes.Equal(up.ContentMD5, sum[:]) {
+		t.Errorf("upload MD5 = %x, want %x", up.ContentMD5, sum)
+	}
+
+	down, err := az.Download(ctx, "blob", filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if down.Blob != "blob" || down.Bytes != int64(len(content)) || down.ETag != up.ETag || down.Retries != 1 {
+		t.Errorf("download result = %+v", down)
+	}
+	if !bytes.Equal(down.ContentMD5, sum[:]) {
+		t.Errorf("download MD5 = %x, want %x", down.ContentMD5, sum)
+	}
+	if down.Duration <= 0 || down.Throughput <= 0 {
+		t.Errorf("download took %v at %f B/s", down.Duration, down.Throughput)
+	}
+
+	if _, err := az.Download(ctx, "missing", filepath.Join(t.TempDir(), "missing")); !isNotFound(err) {
+		t.Errorf("download of a missing blob = %v", err)
+	}
+}
+
+func TestTransferStatsResult(t *testing.T) {
+	s := &transferStats{calls: 3, tries: 5, restarts: 1}
+	s.record(2048, `"etag"`, nil)
+	r := s.result("blob", time.Now().Add(-time.Second))
+	if r.Retries != 3 || r.Bytes != 2048 || r.ETag != `"etag"` {
+		t.Errorf("result = %+v", r)
+	}
+	if r.Throughput <= 0 || r.Throughput > 2048 {
+		t.Errorf("throughput = %f, want about 2048", r.Throughput)
+	}
+	// Transfers without statistics record nothing.
+	transferStatsFrom(context.Background()).record(1, "", nil)
+	transferStatsFrom(context.Background()).restarted()
+}
@@ -0,0 +1,363 @@
+package azureblob
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// AzureBlobClientOptions configures the HTTP behaviour shared by identity and
+// blob requests.
+type AzureBlobClientOptions struct {
+	// ProxyURL is an http://, https:// or socks5:// proxy used for all
+	// requests, except to hosts listed in NO_PROXY. When empty, HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY from the environment are honoured.
+	ProxyURL string
+
+	// HTTPClient, when set, is used for every request as-is. The proxy, TLS
+	// and keep-alive options below are ignored, letting callers supply their
+	// own transport entirely.
+	HTTPClient *http.Client
+
+	// CABundle is a path to a PEM file of additional root CAs to trust, e.g.
+	// the certificate of a TLS-inspecting middlebox or a private CA.
+	CABundle string
+	// TLSMinVersion is the minimum TLS version to negotiate, e.g.
+	// tls.VersionTLS13. Defaults to TLS 1.2.
+	TLSMinVersion uint16
+	// KeepAlive is the TCP keep-alive period. Defaults to 30 seconds; a
+	// negative value disables keep-alives.
+	KeepAlive time.Duration
+	// IdleConnTimeout is how long idle connections are kept in the pool.
+	// Defaults to 90 seconds.
+	IdleConnTimeout time.Duration
+
+	// Headers and Query are added to every blob request. Use
+	// WithRequestHeader and WithRequestQuery to add them per operation.
+	Headers http.Header
+	Query   url.Values
+
+	// SAS, when set, authorizes blob requests with this shared access
+	// signature, as ParseSAS returns it, instead of Azure AD tokens, so no
+	// credential is built. An account SAS serves every container of the
+	// account, a container SAS only its own.
+	SAS url.Values
+
+	// TokenScope is the OAuth scope requested for blob tokens, e.g.
+	// https://storage.azure.us/.default in Azure Government. Sovereign
+	// clouds, Azure Stack and custom audiences need their own. Defaults to
+	// https://storage.azure.com/.default.
+	TokenScope string
+
+	// ApplicationID prefixes the User-Agent of every request so storage
+	// diagnostics logs can attribute traffic to this tool, or to the pipeline
+	// invoking it. At most 24 characters without spaces; defaults to
+	// defaultApplicationID.
+	ApplicationID string
+
+	// TransferRetry configures retries of data-plane requests, i.e. uploads
+	// and downloads. The zero value uses the azcore defaults.
+	TransferRetry policy.RetryOptions
+	// MetadataRetry configures retries of metadata requests such as Stat
+	// and List. The zero value uses defaultMetadataRetry, which has
+	// much tighter timeouts than TransferRetry.
+	MetadataRetry policy.RetryOptions
+	// ChunkRetries is how many times a block of an upload or a range of a
+	// download that still fails after TransferRetry is sent again on its
+	// own, while the rest of the transfer carries on. Defaults to 3; a
+	// negative value fails the transfer at once.
+	ChunkRetries int
+	// MetadataTimeout bounds a single metadata operation including its
+	// retries. Defaults to 30 seconds; a negative value disables it.
+	MetadataTimeout time.Duration
+
+	// ResumeChunkSize is the chunk size of new resumable downloads and
+	// uploads; a resumed transfer keeps the chunk size of its state file.
+	// Defaults to 8 MiB.
+	ResumeChunkSize int64
+
+	// ReadAheadSize is how much a BlobReader fetches for a smaller read, to
+	// serve the reads that follow it. Defaults to 1 MiB.
+	ReadAheadSize int64
+
+	// MinThroughput, in bytes per second, gives each upload, download and
+	// resumable download chunk a deadline of its size divided by this rate
+	// plus ThroughputGrace. Transfers that miss it are restarted, so a
+	// single stalled connection cannot hold a batch open indefinitely. Zero
+	// disables the deadline.
+	MinThroughput int64
+	// ThroughputGrace is added to every MinThroughput deadline to cover
+	// connection setup and latency. Defaults to 30 seconds; a negative
+	// value adds none.
+	ThroughputGrace time.Duration
+
+	// LimitRate caps the combined upload and download throughput of the
+	// client in bytes per second. Zero means unlimited.
+	LimitRate int64
+
+	// EncryptUploads encrypts uploads client-side before they are sent,
+	// in the protocol 2.0 format of the Azure Storage SDKs, with a new
+	// content key per blob wrapped under the current KEK of Keys.
+	EncryptUploads bool
+	// Compression compresses uploads with the named algorithm, recorded
+	// in the blob's metadata and Content-Encoding so downloads decompress
+	// them again. Client-side encryption applies to the compressed bytes.
+	Compression string
+	// ContentEncoding says which downloads are decompressed: "auto" or
+	// "" those compressed by Compression, "decode" also any other blob
+	// with a gzip Content-Encoding, and "raw" none, writing the bytes as
+	// stored.
+	ContentEncoding string
+
+	// PreserveAttributes records the modification time and permission
+	// bits of uploaded files in their metadata, and restores them on
+	// download where recorded. PreserveOwner does the same for the uid
+	// and gid, which restoring needs the privilege to change.
+	PreserveAttributes bool
+	PreserveOwner      bool
+
+	// Symlinks decides what directory uploads do with symlinks: "follow"
+	// uploads what they point to, and "preserve" uploads each as an empty
+	// blob recording its target, which downloads recreate as a link. By
+	// default artifact uploads skip them, while archives store them as
+	// links.
+	Symlinks string
+
+	// Preallocate reserves the full size of a download on disk before it
+	// starts, with fallocate on Linux and F_PREALLOCATE on macOS, instead
+	// of leaving the file sparse until written. Elsewhere it is ignored.
+	Preallocate bool
+	// EncryptionScope names the server-side encryption scope uploads are
+	// encrypted with instead of the account's default. The scope must
+	// exist in the storage account.
+	EncryptionScope string
+
+	// ImmutableFor, when positive, gives every upload a time-based
+	// immutability policy expiring this long after the upload, locked if
+	// ImmutabilityLocked is set. LegalHold places a legal hold on every
+	// upload. Both need a container with version-level immutability
+	// support.
+	ImmutableFor       time.Duration
+	ImmutabilityLocked bool
+	LegalHold          bool
+
+	// SkipUnchanged makes Upload compare the file with the blob first, and
+	// leave a blob of the same size and Content-MD5 as it is. Uploads then
+	// store the MD5 of the file even when committed from blocks, so that
+	// later uploads can compare them. Uploads that are compressed or
+	// client-side encrypted are always made, as their stored bytes differ
+	// from the file's.
+	SkipUnchanged bool
+
+	// StateDir is where the commands keep state files named without a
+	// directory: the -state files of resumable transfers and watches and
+	// the -dedup-index. Defaults to defaultStateDir().
+	StateDir string
+
+	// BootstrapPublicKey is the minisign public key, or a file holding it,
+	// that the bootstrap command checks manifests against unless
+	// -public-key overrides it.
+	BootstrapPublicKey string
+
+	// PathTemplate, when set, names the blobs of the files the upload,
+	// download, artifact-upload and diff commands transfer or compare,
+	// instead of their arguments or the job's prefix.
+	PathTemplate *PathTemplate
+
+	// JobID names the journal of the next bulk job, such as a manifest or
+	// a multi-file upload, instead of a new ID; see JobJournal.
+	JobID string
+
+	// Hooks run around every Upload and Download, keyed by the point they
+	// run at, such as HookPostDownload, in order; see TransferHook.
+	Hooks map[string][]TransferHook
+}
+
+const defaultApplicationID = "bk_azureblob"
+
+// telemetry returns the azcore telemetry options for o.ApplicationID.
+func (o *AzureBlobClientOptions) telemetry() (policy.TelemetryOptions, error) {
+	appID := o.ApplicationID
+	if appID == "" {
+		appID = defaultApplicationID
+	}
+	// azcore silently truncates longer IDs and rewrites spaces.
+	if len(appID) > 24 || strings.ContainsAny(appID, " \t") {
+		return policy.TelemetryOptions{}, fmt.Errorf("application ID %q must be at most 24 characters without spaces", appID)
+	}
+	return policy.TelemetryOptions{ApplicationID: appID}, nil
+}
+
+// clientOptions returns c.ClientOptions, or the defaults if it is unset.
+func (c *AzureBlobClient) clientOptions() *AzureBlobClientOptions {
+	if c.ClientOptions == nil {
+		return &AzureBlobClientOptions{}
+	}
+	return c.ClientOptions
+}
+
+// httpClient returns the HTTP client used for both the identity endpoints
+// and blob transfers, building it on first use.
+func (c *AzureBlobClient) httpClient() (*http.Client, error) {
+	if c.client != nil {
+		return c.client, nil
+	}
+	opts := c.clientOptions()
+	if opts.HTTPClient != nil {
+		c.client = opts.HTTPClient
+		return c.client, nil
+	}
+	proxy, err := proxyFunc(opts.ProxyURL)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := opts.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	c.client = &http.Client{
+		// Mirrors the azcore default transport, which cannot be extended.
+		Transport: &http.Transport{
+			Proxy: proxy,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: opts.keepAlive(),
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       opts.idleConnTimeout(),
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+			TLSClientConfig:       tlsConfig,
+		},
+	}
+	return c.client, nil
+}
+
+func (o *AzureBlobClientOptions) keepAlive() time.Duration {
+	if o.KeepAlive != 0 {
+		return o.KeepAlive
+	}
+	return 30 * time.Second
+}
+
+func (o *AzureBlobClientOptions) idleConnTimeout() time.Duration {
+	if o.IdleConnTimeout != 0 {
+		return o.IdleConnTimeout
+	}
+	return 90 * time.Second
+}
+
+func (o *AzureBlobClientOptions) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if o.TLSMinVersion != 0 {
+		cfg.MinVersion = o.TLSMinVersion
+	}
+	if o.CABundle != "" {
+		pem, err := os.ReadFile(o.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			// The system pool is unavailable on some platforms, e.g. older
+			// Windows releases; trust only the bundle there.
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", o.CABundle)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// parseTLSVersion converts "1.2" or "1.3" to the matching tls constant.
+func parseTLSVersion(v string) (uint16, error) {
+	switch v {
+	case "":
+		return 0, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q, want 1.2 or 1.3", v)
+}
+
+// blobTransporter returns the transport for blob requests: the shared HTTP
+// client, throttled when a transfer rate limit is configured and bounded by
+// the client's TransferPool, if any.
+func (c *AzureBlobClient) blobTransporter() (policy.Transporter, error) {
+	hc, err := c.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	var transport policy.Transporter = budgetTransport{next: countingTransport{next: identityTransport{next: hc}}}
+	if rate := c.clientOptions().LimitRate; rate > 0 {
+		if c.limiter == nil {
+			c.limiter = newRateLimiter(rate)
+		}
+		transport = &throttledTransport{next: transport, limiter: c.limiter}
+	}
+	if c.Pool != nil {
+		transport = &pooledTransport{next: transport, pool: c.Pool}
+	}
+	return transport, nil
+}
+
+// identityTransport asks for blob content as stored. Otherwise Go's
+// transport asks for gzip and transparently decompresses responses with a
+// gzip Content-Encoding, which the service sends for such blobs whatever
+// the request accepts, so ranged downloads would get bytes they cannot
+// place.
+type identityTransport struct {
+	next policy.Transporter
+}
+
+func (t identityTransport) Do(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+	return t.next.Do(req)
+}
+
+// proxyFunc returns the proxy selection function for proxyURL, or for the
+// environment when proxyURL is empty.
+func proxyFunc(proxyURL string) (func(*http.Request) (*url.URL, error), error) {
+	cfg := httpproxy.FromEnvironment()
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %q: %w", proxyURL, err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q in %q", u.Scheme, proxyURL)
+		}
+		cfg = &httpproxy.Config{
+			HTTPProxy:  proxyURL,
+			HTTPSProxy: proxyURL,
+			NoProxy:    os.Getenv("NO_PROXY"),
+		}
+		if cfg.NoProxy == "" {
+			cfg.NoProxy = os.Getenv("no_proxy")
+		}
+	}
+	f := cfg.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return f(req.URL)
+	}, nil
+}
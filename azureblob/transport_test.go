@@ -0,0 +1,208 @@
+package azureblob
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+func TestFallbackUsesExplicitProxy(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "http://env-proxy:3128")
+	t.Setenv("NO_PROXY", "")
+	az := &AzureBlobClient{
+		StorageAccount: "account",
+		ContainerName:  "container",
+		ClientOptions:  &AzureBlobClientOptions{ProxyURL: "http://explicit-proxy:8080"},
+	}
+	az.WithFallback("oldaccount", "old")
+
+	// The fallback may build its own client before the primary has one to
+	// share, and must pick up the explicit proxy either way.
+	for _, c := range []*AzureBlobClient{az, az.Fallback} {
+		hc, err := c.httpClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(http.MethodGet, "https://"+c.StorageAccount+".blob.core.windows.net/"+c.ContainerName+"/blob", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proxy, err := hc.Transport.(*http.Transport).Proxy(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proxy == nil || proxy.Host != "explicit-proxy:8080" {
+			t.Errorf("%s: requests go through proxy %v, want explicit-proxy:8080", c.source(), proxy)
+		}
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint16
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"1.2", tls.VersionTLS12, false},
+		{"1.3", tls.VersionTLS13, false},
+		{"1.0", 0, true},
+		{"1.1", 0, true},
+		{"tls1.3", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTLSVersion(tt.in)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseTLSVersion(%q) = %d, %v; want %d, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestTLSConfigDefaults(t *testing.T) {
+	cfg, err := (&AzureBlobClientOptions{}).tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MinVersion != tls.VersionTLS12 {
+		t.Errorf("MinVersion = %x, want TLS 1.2", cfg.MinVersion)
+	}
+	if cfg.RootCAs != nil {
+		t.Error("RootCAs set without a CA bundle, want the system default")
+	}
+	cfg, err = (&AzureBlobClientOptions{TLSMinVersion: tls.VersionTLS13}).tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MinVersion != tls.VersionTLS13 {
+		t.Errorf("MinVersion = %x, want TLS 1.3", cfg.MinVersion)
+	}
+}
+
+func TestTLSConfigRejectsBundleWithoutCertificates(t *testing.T) {
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(bundle, []byte("not a certificate\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&AzureBlobClientOptions{CABundle: bundle}).tlsConfig(); err == nil {
+		t.Error("tlsConfig accepted a bundle without PEM blocks")
+	}
+	if _, err := (&AzureBlobClientOptions{CABundle: bundle + ".missing"}).tlsConfig(); err == nil {
+		t.Error("tlsConfig accepted a missing bundle")
+	}
+}
+
+func TestCABundleIsTrusted(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(bundle, pemBytes, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NO_PROXY", "*")
+
+	untrusted := &AzureBlobClient{ClientOptions: &AzureBlobClientOptions{}}
+	trusted := &AzureBlobClient{ClientOptions: &AzureBlobClientOptions{CABundle: bundle}}
+	for _, tt := range []struct {
+		c       *AzureBlobClient
+		wantErr bool
+	}{{untrusted, true}, {trusted, false}} {
+		hc, err := tt.c.httpClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := hc.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CABundle %q: request error %v, want error %v", tt.c.ClientOptions.CABundle, err, tt.wantErr)
+		}
+	}
+}
+
+func TestConnectionPoolOptions(t *testing.T) {
+	defaults := &AzureBlobClientOptions{}
+	if defaults.keepAlive() != 30*time.Second || defaults.idleConnTimeout() != 90*time.Second {
+		t.Errorf("defaults = %v, %v; want 30s, 90s", defaults.keepAlive(), defaults.idleConnTimeout())
+	}
+	opts := &AzureBlobClientOptions{KeepAlive: -1, IdleConnTimeout: 5 * time.Second}
+	if opts.keepAlive() != -1 || opts.idleConnTimeout() != 5*time.Second {
+		t.Errorf("overrides = %v, %v; want -1ns, 5s", opts.keepAlive(), opts.idleConnTimeout())
+	}
+	hc, err := (&AzureBlobClient{ClientOptions: opts}).httpClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hc.Transport.(*http.Transport).IdleConnTimeout; got != 5*time.Second {
+		t.Errorf("transport IdleConnTimeout = %v, want 5s", got)
+	}
+}
+
+func TestTelemetryApplicationID(t *testing.T) {
+	tests := []struct {
+		appID   string
+		want    string
+		wantErr bool
+	}{
+		{"", defaultApplicationID, false},
+		{"pipeline-123", "pipeline-123", false},
+		{"exactly-twenty-four-char", "exactly-twenty-four-char", false},
+		{"twenty-five-characters-xx", "", true},
+		{"has space", "", true},
+		{"has\ttab", "", true},
+	}
+	for _, tt := range tests {
+		got, err := (&AzureBlobClientOptions{ApplicationID: tt.appID}).telemetry()
+		if (err != nil) != tt.wantErr || got.ApplicationID != tt.want {
+			t.Errorf("telemetry(%q) = %q, %v; want %q, error %v", tt.appID, got.ApplicationID, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestUserAgentStartsWithApplicationID(t *testing.T) {
+	var agents []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents = append(agents, r.Header.Get("User-Agent"))
+		serveBlob(w, r, []byte("data"))
+	})
+	az := newTestClient(t, handler)
+	az.ClientOptions.ApplicationID = "pipeline-123"
+
+	if _, err := az.Stat(context.Background(), "blob"); err != nil {
+		t.Fatal(err)
+	}
+	// Identity credentials build their pipeline from these options.
+	opts, err := az.identityClientOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := runtime.NewPipeline("azidentity", "v0", nil, nil, &opts)
+	req, err := runtime.NewRequest(context.Background(), http.MethodGet, "https://login.microsoftonline.com/tenant/v2.0/.well-known/openid-configuration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := pl.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(agents) != 2 {
+		t.Fatalf("got %d requests, want a blob and an identity request", len(agents))
+	}
+	for i, agent := range agents {
+		if !strings.HasPrefix(agent, "pipeline-123 ") {
+			t.Errorf("request %d User-Agent = %q, want it to start with the application ID", i+1, agent)
+		}
+	}
+}
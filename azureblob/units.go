@@ -0,0 +1,91 @@
+package azureblob
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var byteSizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"k":   1 << 10,
+	"kb":  1000,
+	"kib": 1 << 10,
+	"m":   1 << 20,
+	"mb":  1000 * 1000,
+	"mib": 1 << 20,
+	"g":   1 << 30,
+	"gb":  1000 * 1000 * 1000,
+	"gib": 1 << 30,
+	"t":   1 << 40,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"tib": 1 << 40,
+}
+
+// parseByteSize parses sizes such as "512", "10MB", "1.5GiB" or "4k". SI
+// suffixes (KB, MB, ...) are powers of 1000, IEC suffixes (KiB, MiB, ...) and
+// bare letters (k, m, ...) are powers of 1024, as in curl.
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+	num, unit := s[:i], strings.ToLower(strings.TrimSpace(s[i:]))
+	mult, ok := byteSizeUnits[unit]
+	if !ok || num == "" {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// parseByteRate parses a rate such as "10MB/s" or "512k" into bytes per
+// second. The "/s" suffix is optional.
+func parseByteRate(s string) (int64, error) {
+	trimmed := strings.TrimSuffix(strings.TrimSpace(s), "/s")
+	n, err := parseByteSize(trimmed)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return n, nil
+}
+
+// parseAge parses an age such as "90d", "36h" or "1h30m": a whole number of
+// days, or a duration as time.ParseDuration takes it.
+func parseAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	return d, nil
+}
+
+// formatBytes renders n with an IEC suffix, e.g. "1.5 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit && n > -unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit || m <= -unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,98 @@
+package azureblob
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"512", 512},
+		{"512b", 512},
+		{"4k", 4 << 10},
+		{"4K", 4 << 10},
+		{"4KiB", 4 << 10},
+		{"4KB", 4000},
+		{"10MB", 10 * 1000 * 1000},
+		{"10MiB", 10 << 20},
+		{"1.5GiB", 3 << 29},
+		{"2 GB", 2 * 1000 * 1000 * 1000},
+		{" 1t ", 1 << 40},
+		{"0", 0},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "MB", "-5", "10XB", "1.2.3k", "ten"} {
+		if got, err := parseByteSize(in); err == nil {
+			t.Errorf("parseByteSize(%q) = %d, want error", in, got)
+		}
+	}
+}
+
+func TestParseByteRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"10MB/s", 10 * 1000 * 1000},
+		{"10MB", 10 * 1000 * 1000},
+		{"512k/s", 512 << 10},
+		{"512k", 512 << 10},
+	}
+	for _, tt := range tests {
+		got, err := parseByteRate(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseByteRate(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "/s", "10MB/min", "fast"} {
+		if got, err := parseByteRate(in); err == nil {
+			t.Errorf("parseByteRate(%q) = %d, want error", in, got)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		in   int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{10 << 20, "10.0 MiB"},
+		{3 << 29, "1.5 GiB"},
+		{-2048, "-2.0 KiB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.in); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	for in, want := range map[string]time.Duration{
+		"90d":   90 * 24 * time.Hour,
+		"0d":    0,
+		"36h":   36 * time.Hour,
+		"1h30m": 90 * time.Minute,
+	} {
+		if got, err := parseAge(in); err != nil || got != want {
+			t.Errorf("parseAge(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "d", "1.5d", "-1d", "-2h", "ninety"} {
+		if got, err := parseAge(in); err == nil {
+			t.Errorf("parseAge(%q) = %v, want error", in, got)
+		}
+	}
+}
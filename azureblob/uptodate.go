@@ -0,0 +1,40 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"os"
+)
+
+// uploadedAlready compares file with blobPath for SkipUnchanged. It returns
+// the blob's properties if the blob has the size and Content-MD5 of the
+// file, and otherwise nil and the MD5 of the file, for the upload to store.
+// Uploads that are compressed or encrypted are never compared.
+func (c *AzureBlobClient) uploadedAlready(ctx context.Context, file *os.File, blobPath string) (*BlobProperties, []byte, error) {
+	if o := c.clientOptions(); o.Compression != "" || o.EncryptUploads {
+		return nil, nil, nil
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	h := md5.New()
+	if _, err := io.Copy(h, io.NewSectionReader(file, 0, info.Size())); err != nil {
+		return nil, nil, fmt.Errorf("hash %s: %w", file.Name(), err)
+	}
+	sum := h.Sum(nil)
+	props, err := c.Stat(ctx, blobPath)
+	if isNotFound(err) {
+		return nil, sum, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if props.Size != info.Size() || !bytes.Equal(props.ContentMD5, sum) {
+		return nil, sum, nil
+	}
+	return props, nil, nil
+}
@@ -0,0 +1,65 @@
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUploadSkipUnchanged(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, m)
+	az.ClientOptions.SkipUnchanged = true
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "app.pkg")
+	upload := func(content string) *TransferResult {
+		t.Helper()
+		writeFile(t, path, content)
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		result, err := az.Upload(ctx, f, "app.pkg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	if r := upload("v1"); r.UpToDate {
+		t.Error("new blob reported up to date")
+	}
+	sum := md5.Sum([]byte("v1"))
+	if got := m.blobs["app.pkg"].contentMD5; got != base64.StdEncoding.EncodeToString(sum[:]) {
+		t.Errorf("uploaded with Content-MD5 %q", got)
+	}
+	etag := m.blobs["app.pkg"].etag
+	r := upload("v1")
+	if !r.UpToDate || r.ETag != etag || r.Bytes != 0 || m.blobs["app.pkg"].etag != etag {
+		t.Errorf("unchanged upload: %+v, blob ETag %s, was %s", r, m.blobs["app.pkg"].etag, etag)
+	}
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	az.logTransfer("upload", r)
+	if !strings.Contains(buf.String(), "app.pkg: up to date, not uploaded") {
+		t.Errorf("logged %q", buf.String())
+	}
+
+	if r := upload("v2"); r.UpToDate || string(m.blobs["app.pkg"].data) != "v2" {
+		t.Errorf("changed upload: %+v", r)
+	}
+	// Compressed uploads differ from the file, so they are always made.
+	az.ClientOptions.Compression = "gzip"
+	etag = m.blobs["app.pkg"].etag
+	if r := upload("v2"); r.UpToDate || m.blobs["app.pkg"].etag == etag {
+		t.Errorf("compressed upload skipped: %+v", r)
+	}
+}
@@ -0,0 +1,122 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// errUnverifiable is returned by VerifyFile when there is nothing to check
+// a file against.
+var errUnverifiable = errors.New("no expected digest, and the blob has no Content-MD5")
+
+// VerifyFile checks the local file of item. If item lists digests, the file
+// is checked against them without contacting the service. Otherwise it is
+// checked against the Content-MD5 of item.Blob. A mismatch is reported as a
+// *ChecksumError, and errUnverifiable is returned when neither is available,
+// including for client-side encrypted or compressed blobs, whose MD5 is that
+// of the stored bytes.
+func (c *AzureBlobClient) VerifyFile(ctx context.Context, item ManifestItem) error {
+	item, err := expectBackendDigests(ctx, c, c.clientOptions(), item)
+	if err != nil {
+		return err
+	}
+	return item.verify()
+}
+
+// expectBackendDigests returns item expecting the Content-MD5 of its blob
+// in b, which downloads decode as o asks, unless it lists digests itself.
+func expectBackendDigests(ctx context.Context, b Backend, o *AzureBlobClientOptions, item ManifestItem) (ManifestItem, error) {
+	if _, err := os.Stat(item.Path); err != nil {
+		return item, err
+	}
+	if item.SHA256 != "" || item.MD5 != "" {
+		return item, nil
+	}
+	props, err := b.Stat(ctx, item.Blob)
+	if err != nil {
+		return item, err
+	}
+	if _, _, encrypted := findEncryptionData(props.Metadata); encrypted || o.downloadCompression(props) != "" || len(props.ContentMD5) == 0 {
+		return item, errUnverifiable
+	}
+	item.MD5 = hex.EncodeToString(props.ContentMD5)
+	return item, nil
+}
+
+// verifyItems verifies items and prints a result line for each.
+// Unverifiable files are failures only with strict. The expected digests
+// are looked up concurrently, bounded by c.Pool, and the files are then
+// hashed a core each.
+func (c *AzureBlobClient) verifyItems(ctx context.Context, items []ManifestItem, strict bool) error {
+	backends := c.manifestBackends(items)
+	expected := make([]ManifestItem, len(items))
+	errs := c.Pool.Run(ctx, len(items), func(ctx context.Context, i int) error {
+		b, err := c.itemBackend(backends, items[i])
+		if err != nil {
+			return err
+		}
+		expected[i], err = expectBackendDigests(ctx, b, c.clientOptions(), items[i])
+		return err
+	})
+	var hashed []int
+	for i, err := range errs {
+		if err == nil {
+			hashed = append(hashed, i)
+		}
+	}
+	hashErrs := hashPool.Run(ctx, len(hashed), func(ctx context.Context, i int) error {
+		return expected[hashed[i]].verify()
+	})
+	for i, err := range hashErrs {
+		errs[hashed[i]] = err
+	}
+	failures := &transferFailures{total: len(items), noun: "verifications"}
+	for i, err := range errs {
+		status := c.Messages.format(MsgResultOK)
+		switch {
+		case errors.Is(err, errUnverifiable) && !strict:
+			status = c.Messages.format(MsgUnverified, err)
+		case err != nil:
+			status = c.Messages.format(MsgResultFailed, err)
+			failures.errs = append(failures.errs, err)
+		}
+		fmt.Println(c.Messages.format(MsgVerify, items[i].Path, items[i].Blob, status))
+	}
+	if len(failures.errs) > 0 {
+		return failures
+	}
+	return nil
+}
+
+func runVerify(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	manifest := fs.String("manifest", "", "verify the downloads of the manifest `file`")
+	strict := fs.Bool("strict", false, "fail for files that cannot be verified")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: verify [flags] [<file> <blob>]...\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg()%2 != 0 || (fs.NArg() == 0 && *manifest == "") {
+		fs.Usage()
+		return errors.New("verify takes pairs of a file and its blob, or -manifest")
+	}
+	var items []ManifestItem
+	if *manifest != "" {
+		m, err := LoadManifest(*manifest)
+		if err != nil {
+			return err
+		}
+		items = append(items, m.Downloads...)
+	}
+	for i := 0; i < fs.NArg(); i += 2 {
+		items = append(items, ManifestItem{Path: fs.Arg(i), Blob: fs.Arg(i + 1)})
+	}
+	return az.verifyItems(ctx, items, *strict)
+}
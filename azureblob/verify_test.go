@@ -0,0 +1,76 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyFile(t *testing.T) {
+	m := newMemContainer()
+	m.put("good", []byte("good"), nil)
+	m.put("tampered", []byte("original"), nil)
+	m.put("encrypted", []byte("ciphertext"), map[string]string{"encryptiondata": "{}"})
+	m.put("compressed", []byte("gzip stream"), map[string]string{compressionMetadataKey: compressionGzip})
+	az := newTestClient(t, m)
+	dir := t.TempDir()
+	good := writeFile(t, filepath.Join(dir, "good"), "good")
+	tampered := writeFile(t, filepath.Join(dir, "tampered"), "modified")
+	plain := writeFile(t, filepath.Join(dir, "plain"), "plain")
+	ctx := context.Background()
+
+	var checksumErr *ChecksumError
+	tests := []struct {
+		name  string
+		item  ManifestItem
+		check func(error) bool
+	}{
+		{"matches the blob", ManifestItem{Blob: "good", Path: good}, func(err error) bool { return err == nil }},
+		{"differs from the blob", ManifestItem{Blob: "tampered", Path: tampered}, func(err error) bool { return errors.As(err, &checksumErr) }},
+		{"manifest digest wins", ManifestItem{Blob: "tampered", Path: tampered, SHA256: sha256Hex([]byte("modified"))}, func(err error) bool { return err == nil }},
+		{"manifest digest differs", ManifestItem{Blob: "good", Path: good, SHA256: sha256Hex([]byte("other"))}, func(err error) bool { return errors.As(err, &checksumErr) }},
+		{"encrypted blob", ManifestItem{Blob: "encrypted", Path: plain}, func(err error) bool { return errors.Is(err, errUnverifiable) }},
+		{"compressed blob", ManifestItem{Blob: "compressed", Path: plain}, func(err error) bool { return errors.Is(err, errUnverifiable) }},
+		{"missing file", ManifestItem{Blob: "good", Path: filepath.Join(dir, "missing")}, func(err error) bool { return err != nil && !errors.As(err, &checksumErr) }},
+		{"missing blob", ManifestItem{Blob: "missing", Path: good}, isNotFound},
+	}
+	for _, tt := range tests {
+		if err := az.VerifyFile(ctx, tt.item); !tt.check(err) {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+}
+
+func TestRunVerify(t *testing.T) {
+	m := newMemContainer()
+	m.put("a", []byte("a"), nil)
+	m.put("enc", []byte("x"), map[string]string{"encryptiondata": "{}"})
+	az := newTestClient(t, m)
+	dir := t.TempDir()
+	a := writeFile(t, filepath.Join(dir, "a"), "a")
+	b := writeFile(t, filepath.Join(dir, "b"), "bad")
+	enc := writeFile(t, filepath.Join(dir, "enc"), "x")
+	manifest := writeFile(t, filepath.Join(dir, "m.yaml"), "downloads:\n- blob: b\n  path: b\n  sha256: "+sha256Hex([]byte("b"))+"\n")
+
+	var err error
+	out := captureStdout(t, func() { err = runVerify(context.Background(), az, []string{a, "a", enc, "enc"}) })
+	if err != nil {
+		t.Errorf("unverifiable files failed without -strict: %v", err)
+	}
+	if !strings.Contains(out, "verify "+a+" against a: ok") || !strings.Contains(out, "against enc: unverified") {
+		t.Errorf("output:\n%s", out)
+	}
+	out = captureStdout(t, func() { err = runVerify(context.Background(), az, []string{"-strict", enc, "enc"}) })
+	if err == nil {
+		t.Error("-strict passed an unverifiable file")
+	}
+	out = captureStdout(t, func() { err = runVerify(context.Background(), az, []string{"-manifest", manifest, a, "a"}) })
+	if err == nil || err.Error() != "1 of 2 verifications failed" || exitCode(err) != exitChecksum {
+		t.Errorf("got %v (exit code %d)", err, exitCode(err))
+	}
+	if !strings.Contains(out, "verify "+b+" against b: FAILED") {
+		t.Errorf("output:\n%s", out)
+	}
+}
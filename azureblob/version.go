@@ -0,0 +1,118 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Build metadata, set by release builds with e.g.
+//
+//	go build -ldflags "-X github.com/discentem/bk_azureblob/azureblob.version=v1.2.3 -X github.com/discentem/bk_azureblob/azureblob.commit=$(git rev-parse HEAD) -X github.com/discentem/bk_azureblob/azureblob.buildDate=$(date -u +%FT%TZ)" ./cmd/bk_azureblob
+//
+// Without it, the version falls back to the module version recorded by
+// go install.
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+// sdkModules are the dependencies whose versions are worth reporting in bug
+// reports, as their behaviour differs most between releases.
+var sdkModules = []string{
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore",
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity",
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob",
+}
+
+// VersionInfo describes the running binary.
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+	// SDKs maps each module of sdkModules linked in to its version.
+	SDKs map[string]string `json:"sdks"`
+}
+
+// collectVersion returns the version information from the build metadata
+// variables and info, the binary's build information if available.
+func collectVersion(info *debug.BuildInfo) VersionInfo {
+	v := VersionInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		SDKs:      map[string]string{},
+	}
+	if info == nil {
+		if v.Version == "" {
+			v.Version = "unknown"
+		}
+		return v
+	}
+	if v.Version == "" {
+		v.Version = info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		for _, path := range sdkModules {
+			if dep.Path == path {
+				v.SDKs[path] = dep.Version
+			}
+		}
+	}
+	return v
+}
+
+func (v VersionInfo) print(w io.Writer) {
+	fmt.Fprintf(w, "bk_azureblob %s\n", v.Version)
+	if v.Commit != "" {
+		fmt.Fprintf(w, "commit:     %s\n", v.Commit)
+	}
+	if v.BuildDate != "" {
+		fmt.Fprintf(w, "built:      %s\n", v.BuildDate)
+	}
+	fmt.Fprintf(w, "go:         %s %s\n", v.GoVersion, v.Platform)
+	for _, path := range sdkModules {
+		if sdk, ok := v.SDKs[path]; ok {
+			fmt.Fprintf(w, "%-11s %s\n", path[strings.LastIndex(path, "/")+1:]+":", sdk)
+		}
+	}
+}
+
+func runVersion(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the version information as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: version [-json]\n")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("version takes no arguments")
+	}
+	info, _ := debug.ReadBuildInfo()
+	v := collectVersion(info)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	v.print(os.Stdout)
+	return nil
+}
@@ -0,0 +1,55 @@
+package azureblob
+
+import (
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestCollectVersion(t *testing.T) {
+	info := &debug.BuildInfo{
+		Main: debug.Module{Path: "github.com/discentem/bk_azureblob", Version: "v1.0.0"},
+		Deps: []*debug.Module{
+			{Path: "github.com/Azure/azure-sdk-for-go/sdk/azcore", Version: "v0.20.0"},
+			{Path: "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob", Version: "v0.2.0",
+				Replace: &debug.Module{Path: "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob", Version: "v0.2.1"}},
+			{Path: "gopkg.in/yaml.v2", Version: "v2.4.0"},
+		},
+	}
+	v := collectVersion(info)
+	if v.Version != "v1.0.0" {
+		t.Errorf("version %q, want the module version", v.Version)
+	}
+	want := map[string]string{
+		"github.com/Azure/azure-sdk-for-go/sdk/azcore":         "v0.20.0",
+		"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob": "v0.2.1",
+	}
+	if len(v.SDKs) != len(want) {
+		t.Errorf("SDKs = %v, want %v", v.SDKs, want)
+	}
+	for path, version := range want {
+		if v.SDKs[path] != version {
+			t.Errorf("%s = %q, want %q", path, v.SDKs[path], version)
+		}
+	}
+
+	defer func(v, c, d string) { version, commit, buildDate = v, c, d }(version, commit, buildDate)
+	version, commit, buildDate = "v2.0.0", "abc123", "2024-01-02T03:04:05Z"
+	v = collectVersion(info)
+	if v.Version != "v2.0.0" || v.Commit != "abc123" || v.BuildDate != "2024-01-02T03:04:05Z" {
+		t.Errorf("ldflags metadata not used: %+v", v)
+	}
+	var out strings.Builder
+	v.print(&out)
+	for _, line := range []string{"bk_azureblob v2.0.0\n", "commit:     abc123\n", "azcore:     v0.20.0\n", "azblob:     v0.2.1\n"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("output does not contain %q:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestCollectVersionWithoutBuildInfo(t *testing.T) {
+	if v := collectVersion(nil); v.Version != "unknown" || v.GoVersion == "" {
+		t.Errorf("got %+v", v)
+	}
+}
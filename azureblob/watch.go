@@ -0,0 +1,210 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultWatchInterval is how often Watch lists its prefix.
+const defaultWatchInterval = 30 * time.Second
+
+// WatchOptions configures Watch.
+type WatchOptions struct {
+	// Interval is the time between polls. Zero means 30 seconds.
+	Interval time.Duration
+	// StatePath, if set, is a file recording the ETag of every blob
+	// downloaded, so that a restarted watch only fetches what changed
+	// meanwhile. Without it the first poll downloads every blob.
+	StatePath string
+	// Once polls a single time instead of until the context is done.
+	Once bool
+	// OnDownload, if set, is called with each blob downloaded and the file
+	// it was written to, one blob at a time. A blob whose download or
+	// OnDownload fails is tried again at the next poll.
+	OnDownload func(blob, path string) error
+}
+
+// WatchState maps the names of the blobs a watch has downloaded to their
+// ETags.
+type WatchState map[string]string
+
+// loadWatchState reads the state file at path, returning an empty state if
+// it does not exist.
+func loadWatchState(path string) (WatchState, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return WatchState{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := WatchState{}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("parse watch state %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Watch polls the blobs under prefix and downloads each new or modified one
+// into dir, under its name relative to prefix, until ctx is done. A blob is
+// modified when its ETag changes. Local files of deleted blobs are kept. A
+// failed download is logged and retried at the next poll, while a failed
+// listing ends the watch.
+func (c *AzureBlobClient) Watch(ctx context.Context, prefix, dir string, opts WatchOptions) error {
+	if err := checkDir(dir); err != nil {
+		return err
+	}
+	state := WatchState{}
+	if opts.StatePath != "" {
+		var err error
+		if state, err = loadWatchState(opts.StatePath); err != nil {
+			return err
+		}
+	}
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := c.poll(ctx, prefix, dir, state, opts); err != nil {
+			return err
+		}
+		if opts.Once {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll downloads the blobs under prefix whose ETags state does not record,
+// and updates state and its file. It returns nil once ctx is done.
+func (c *AzureBlobClient) poll(ctx context.Context, prefix, dir string, state WatchState, opts WatchOptions) error {
+	blobs, err := c.List(ctx, prefix)
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	listed := map[string]bool{}
+	var changed []*BlobProperties
+	for _, b := range blobs {
+		listed[b.Name] = true
+		name := strings.TrimPrefix(b.Name, prefix)
+		if name != "" && !strings.HasSuffix(name, "/") && state[b.Name] != b.ETag {
+			changed = append(changed, b)
+		}
+	}
+	dirty := false
+	for name := range state {
+		if !listed[name] {
+			delete(state, name)
+			dirty = true
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i].Name < changed[j].Name })
+	errs := c.Pool.Run(ctx, len(changed), func(ctx context.Context, i int) error {
+		b := changed[i]
+		dest := downloadDestination(dir, strings.TrimPrefix(b.Name, prefix))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if _, err := c.Download(ctx, b.Name, dest); err != nil {
+			return err
+		}
+		log.Print(c.Messages.format(MsgWatchDownloaded, b.Name, dest))
+		return nil
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	// Hooks run one at a time in the order of the blobs, after all
+	// downloads, so they need not be safe to run concurrently.
+	for i, err := range errs {
+		if err == nil && opts.OnDownload != nil {
+			err = opts.OnDownload(changed[i].Name, downloadDestination(dir, strings.TrimPrefix(changed[i].Name, prefix)))
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		state[changed[i].Name] = changed[i].ETag
+		dirty = true
+	}
+	if opts.StatePath == "" || !dirty {
+		return nil
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(opts.StatePath, b)
+}
+
+// hookCommand returns an OnDownload running the command line hook, split
+// on spaces, with the blob's file and name appended as arguments.
+func hookCommand(hook string) func(blob, path string) error {
+	return func(blob, path string) error {
+		args := append(strings.Fields(hook), path, blob)
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("hook for %s: %w", blob, err)
+		}
+		return nil
+	}
+}
+
+func runWatch(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	interval := fs.Duration("interval", defaultWatchInterval, "time between polls of the prefix")
+	state := fs.String("state", "", "record downloaded blobs in `file`, so a restarted watch only fetches what changed")
+	hook := fs.String("exec", "", "run `command` with each downloaded file and its blob name appended")
+	once := fs.Bool("once", false, "poll once and exit, e.g. from cron")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: watch [flags] <prefix> <directory>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("watch takes a prefix and a directory")
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("-interval must be positive, got %s", *interval)
+	}
+	if *hook != "" && len(strings.Fields(*hook)) == 0 {
+		return errors.New("-exec needs a command")
+	}
+	az, prefix, err := az.resolveRemote(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	statePath, err := az.clientOptions().statePath(*state)
+	if err != nil {
+		return err
+	}
+	opts := WatchOptions{Interval: *interval, StatePath: statePath, Once: *once}
+	if *hook != "" {
+		opts.OnDownload = hookCommand(*hook)
+	}
+	return az.Watch(ctx, prefix, fs.Arg(1), opts)
+}
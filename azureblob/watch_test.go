@@ -0,0 +1,136 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchOnce(t *testing.T) {
+	m := newMemContainer()
+	m.put("releases/a.txt", []byte("a1"), nil)
+	m.put("releases/sub/b.txt", []byte("b1"), nil)
+	m.put("other.txt", []byte("x"), nil)
+	az := newTestClient(t, m)
+	ctx := context.Background()
+	dir := t.TempDir()
+	var downloaded []string
+	opts := WatchOptions{
+		StatePath: filepath.Join(t.TempDir(), "watch.json"),
+		Once:      true,
+		OnDownload: func(blob, path string) error {
+			downloaded = append(downloaded, blob)
+			return nil
+		},
+	}
+	if err := az.Watch(ctx, "releases/", dir, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(downloaded, ","); got != "releases/a.txt,releases/sub/b.txt" {
+		t.Errorf("first poll downloaded %s", got)
+	}
+	if got := readFile(t, filepath.Join(dir, "sub", "b.txt")); got != "b1" {
+		t.Errorf("sub/b.txt = %q", got)
+	}
+
+	// A restarted watch fetches only what changed since.
+	downloaded = nil
+	m.put("releases/a.txt", []byte("a2"), nil)
+	m.put("releases/c.txt", []byte("c1"), nil)
+	delete(m.blobs, "releases/sub/b.txt")
+	if err := az.Watch(ctx, "releases/", dir, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(downloaded, ","); got != "releases/a.txt,releases/c.txt" {
+		t.Errorf("second poll downloaded %s", got)
+	}
+	if got := readFile(t, filepath.Join(dir, "a.txt")); got != "a2" {
+		t.Errorf("a.txt = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sub", "b.txt")); err != nil {
+		t.Errorf("file of a deleted blob was removed: %v", err)
+	}
+	state, err := loadWatchState(opts.StatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := state["releases/sub/b.txt"]; ok || len(state) != 2 {
+		t.Errorf("state = %v", state)
+	}
+}
+
+func TestWatchRetriesFailedHook(t *testing.T) {
+	m := newMemContainer()
+	m.put("p/a.txt", []byte("a"), nil)
+	az := newTestClient(t, m)
+	calls := 0
+	opts := WatchOptions{
+		StatePath: filepath.Join(t.TempDir(), "watch.json"),
+		Once:      true,
+		OnDownload: func(blob, path string) error {
+			calls++
+			if calls == 1 {
+				return errors.New("hook failed")
+			}
+			return nil
+		},
+	}
+	dir := t.TempDir()
+	for i := 0; i < 3; i++ {
+		if err := az.Watch(context.Background(), "p/", dir, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("hook ran %d times, want once more after failing", calls)
+	}
+}
+
+func TestWatchStopsWithContext(t *testing.T) {
+	m := newMemContainer()
+	m.put("p/a.txt", []byte("a"), nil)
+	az := newTestClient(t, m)
+	ctx, cancel := context.WithCancel(context.Background())
+	opts := WatchOptions{
+		Interval: time.Millisecond,
+		OnDownload: func(blob, path string) error {
+			cancel()
+			return nil
+		},
+	}
+	if err := az.Watch(ctx, "p/", t.TempDir(), opts); err != nil {
+		t.Errorf("cancelled watch = %v", err)
+	}
+}
+
+func TestRunWatch(t *testing.T) {
+	m := newMemContainer()
+	m.put("p/a.txt", []byte("a"), nil)
+	az := newTestClient(t, m)
+	dir := t.TempDir()
+	ctx := context.Background()
+	for _, args := range [][]string{nil, {"p/"}, {"-interval", "0s", "p/", dir}, {"-exec", " ", "p/", dir}} {
+		if err := runWatch(ctx, az, args); err == nil {
+			t.Errorf("watch %q succeeded", args)
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	hook := writeFile(t, filepath.Join(t.TempDir(), "hook.sh"), "#!/bin/sh\necho \"$@\" >> \"$(dirname \"$0\")/log\"\n")
+	if err := os.Chmod(hook, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := runWatch(ctx, az, []string{"-once", "-exec", hook, "p/", dir}); err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(dir, "a.txt") + " p/a.txt\n"
+	if got := readFile(t, filepath.Join(filepath.Dir(hook), "log")); got != want {
+		t.Errorf("hook ran with %q, want %q", got, want)
+	}
+}
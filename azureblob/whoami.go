@@ -0,0 +1,198 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// TokenIdentity is the identity an access token was issued to, read from
+// its claims. The token is not validated; this is for diagnostics only.
+type TokenIdentity struct {
+	ObjectID          string `json:"oid"`
+	TenantID          string `json:"tid"`
+	AppID             string `json:"appid"`
+	UPN               string `json:"upn"`
+	PreferredUsername string `json:"preferred_username"`
+	// IdentityType is "user" or "app" in tokens that carry it.
+	IdentityType string `json:"idtyp"`
+}
+
+// Name returns the most readable name the token carries: the user's UPN,
+// or the application ID for service principals and managed identities.
+func (id *TokenIdentity) Name() string {
+	switch {
+	case id.UPN != "":
+		return id.UPN
+	case id.PreferredUsername != "":
+		return id.PreferredUsername
+	case id.AppID != "":
+		return "application " + id.AppID
+	}
+	return "unknown"
+}
+
+// parseTokenIdentity decodes the claims of the JWT access token.
+func parseTokenIdentity(token string) (*TokenIdentity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("access token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("decode access token claims: %w", err)
+	}
+	id := &TokenIdentity{}
+	if err := json.Unmarshal(payload, id); err != nil {
+		return nil, fmt.Errorf("parse access token claims: %w", err)
+	}
+	return id, nil
+}
+
+// namedCredential is a credential whoami tries, with the name reported if
+// it is the one that succeeds.
+type namedCredential struct {
+	name string
+	cred azcore.TokenCredential
+}
+
+// whoamiCandidates returns the credentials c would use in order: the
+// credential it was given, or each method of the chain InitCredential
+// builds, so whoami can tell which one succeeds.
+func (c *AzureBlobClient) whoamiCandidates(ctx context.Context) ([]namedCredential, error) {
+	c.initMu.Lock()
+	given := c.credential
+	if c.builtCredential {
+		given = nil
+	}
+	c.initMu.Unlock()
+	if given != nil {
+		return []namedCredential{{"configured credential", *given}}, nil
+	}
+	clientOpts, err := c.identityClientOptions()
+	if err != nil {
+		return nil, err
+	}
+	chosen, _ := chooseAuth(probeAuth(ctx, true), c.CredentialOptions)
+	var creds []namedCredential
+	for _, method := range chosen {
+		cred, err := c.newCredential(method, c.TenantID, clientOpts)
+		if err != nil {
+			return nil, err
+		}
+		creds = append(creds, namedCredential{string(method), cred})
+	}
+	return creds, nil
+}
+
+// PermissionCheck is the outcome of one request of the permission probe.
+type PermissionCheck struct {
+	Name string
+	Err  error
+}
+
+// WhoAmIReport describes which credential authenticated, as whom, and what
+// it may do in the container.
+type WhoAmIReport struct {
+	Credential string
+	// Failed lists the credentials tried before, with their errors.
+	Failed   []string
+	Identity *TokenIdentity
+	Checks   []PermissionCheck
+}
+
+// whoami gets a storage token from the first of creds that can, decodes
+// its identity, and probes the container with read-only requests.
+func (c *AzureBlobClient) whoami(ctx context.Context, creds []namedCredential) (*WhoAmIReport, error) {
+	report := &WhoAmIReport{}
+	var (
+		cred  azcore.TokenCredential
+		token *azcore.AccessToken
+	)
+	for _, candidate := range creds {
+		t, err := candidate.cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: c.clientOptions().tokenScopes()})
+		if err != nil {
+			report.Failed = append(report.Failed, fmt.Sprintf("%s: %v", candidate.name, err))
+			continue
+		}
+		report.Credential, cred, token = candidate.name, candidate.cred, t
+		break
+	}
+	if token == nil {
+		return report, fmt.Errorf("no credential could get a token: %s", strings.Join(report.Failed, "; "))
+	}
+	id, err := parseTokenIdentity(token.Token)
+	if err != nil {
+		return report, err
+	}
+	report.Identity = id
+
+	container, err := c.InitContainerClient(&cred)
+	if err != nil {
+		return report, err
+	}
+	propsCtx, cancel := c.metadataContext(ctx)
+	_, err = container.GetProperties(propsCtx, nil)
+	cancel()
+	report.Checks = append(report.Checks, PermissionCheck{"read container properties", newBlobError("get container properties", c.ContainerName, err)})
+	one := int32(1)
+	pager := container.ListBlobsFlat(&azblob.ContainerListBlobFlatSegmentOptions{Maxresults: &one})
+	listCtx, cancel := c.metadataContext(ctx)
+	pager.NextPage(listCtx)
+	cancel()
+	report.Checks = append(report.Checks, PermissionCheck{"list blobs", newBlobError("list", "", pager.Err())})
+	return report, nil
+}
+
+func runWhoAmI(ctx context.Context, az *AzureBlobClient, args []string) error {
+	fs := flag.NewFlagSet("whoami", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: whoami\n")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if az.clientOptions().SAS != nil {
+		return errors.New("whoami reports Azure AD identities, and requests authorized by -sas have none")
+	}
+	creds, err := az.whoamiCandidates(ctx)
+	if err != nil {
+		return err
+	}
+	report, err := az.whoami(ctx, creds)
+	for _, failure := range report.Failed {
+		fmt.Printf("Failed:      %s\n", failure)
+	}
+	if err != nil {
+		return err
+	}
+	id := report.Identity
+	fmt.Printf("Credential:  %s\n", report.Credential)
+	fmt.Printf("Identity:    %s\n", id.Name())
+	fmt.Printf("Object ID:   %s\n", id.ObjectID)
+	fmt.Printf("Tenant:      %s\n", id.TenantID)
+	if id.IdentityType != "" {
+		fmt.Printf("Type:        %s\n", id.IdentityType)
+	}
+	fmt.Printf("Container:   %s\n", az.source())
+	var denied error
+	for _, check := range report.Checks {
+		status := "ok"
+		if check.Err != nil {
+			status = check.Err.Error()
+			if denied == nil {
+				denied = check.Err
+			}
+		}
+		fmt.Printf("  %-26s %s\n", check.Name+":", status)
+	}
+	return denied
+}
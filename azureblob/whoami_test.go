@@ -0,0 +1,123 @@
+package azureblob
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// jwtCredential hands out an unsigned JWT carrying claims.
+type jwtCredential struct {
+	claims string
+}
+
+func (c jwtCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (*azcore.AccessToken, error) {
+	token := "e30." + base64.RawURLEncoding.EncodeToString([]byte(c.claims)) + ".sig"
+	return &azcore.AccessToken{Token: token, ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+type errorCredential struct{ err error }
+
+func (c errorCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (*azcore.AccessToken, error) {
+	return nil, c.err
+}
+
+func TestParseTokenIdentity(t *testing.T) {
+	tests := []struct {
+		claims   string
+		wantName string
+	}{
+		{`{"oid":"o1","tid":"t1","upn":"alice@example.com","idtyp":"user"}`, "alice@example.com"},
+		{`{"oid":"o2","tid":"t1","preferred_username":"bob@example.com"}`, "bob@example.com"},
+		{`{"oid":"o3","tid":"t1","appid":"a1","idtyp":"app"}`, "application a1"},
+		{`{}`, "unknown"},
+	}
+	for _, tt := range tests {
+		token := "e30." + base64.RawURLEncoding.EncodeToString([]byte(tt.claims)) + ".sig"
+		id, err := parseTokenIdentity(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := id.Name(); got != tt.wantName {
+			t.Errorf("%s: Name() = %q, want %q", tt.claims, got, tt.wantName)
+		}
+	}
+	if _, err := parseTokenIdentity("opaque-token"); err == nil {
+		t.Error("a token that is not a JWT was parsed")
+	}
+}
+
+func TestWhoAmI(t *testing.T) {
+	az := newTestClient(t, newMemContainer())
+	creds := []namedCredential{
+		{"workload-identity", errorCredential{errors.New("no token file")}},
+		{"managed-identity", jwtCredential{`{"oid":"o1","tid":"t1","appid":"a1"}`}},
+		{"azure-cli", errorCredential{errors.New("must not be tried")}},
+	}
+	report, err := az.whoami(context.Background(), creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Credential != "managed-identity" || len(report.Failed) != 1 || !strings.Contains(report.Failed[0], "no token file") {
+		t.Errorf("credential %q, failed %q", report.Credential, report.Failed)
+	}
+	if report.Identity.ObjectID != "o1" || report.Identity.TenantID != "t1" {
+		t.Errorf("identity %+v", report.Identity)
+	}
+	if len(report.Checks) != 2 {
+		t.Fatalf("checks %+v", report.Checks)
+	}
+	for _, check := range report.Checks {
+		if check.Err != nil {
+			t.Errorf("%s: %v", check.Name, check.Err)
+		}
+	}
+}
+
+func TestWhoAmIPermissionDenied(t *testing.T) {
+	m := newMemContainer()
+	az := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("comp") == "list" {
+			w.Header().Set("x-ms-error-code", "AuthorizationPermissionMismatch")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		m.ServeHTTP(w, r)
+	}))
+	report, err := az.whoami(context.Background(), []namedCredential{{"configured credential", jwtCredential{`{"oid":"o1"}`}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checks[0].Err != nil {
+		t.Errorf("container properties: %v", report.Checks[0].Err)
+	}
+	if err := report.Checks[1].Err; !isAuthFailure(err) || !strings.Contains(err.Error(), "AuthorizationPermissionMismatch") {
+		t.Errorf("list: got %v, want a 403", err)
+	}
+}
+
+func TestWhoAmINoCredential(t *testing.T) {
+	az := newTestClient(t, newMemContainer())
+	_, err := az.whoami(context.Background(), []namedCredential{{"azure-cli", errorCredential{errors.New("az not logged in")}}})
+	if err == nil || !strings.Contains(err.Error(), "azure-cli: az not logged in") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestWhoAmICandidatesUsesConfiguredCredential(t *testing.T) {
+	az := newTestClient(t, newMemContainer())
+	creds, err := az.whoamiCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(creds) != 1 || creds[0].name != "configured credential" {
+		t.Errorf("got %+v", creds)
+	}
+}
@@ -0,0 +1,96 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	azruntime "github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+)
+
+const defaultAuthorityHost = "https://login.microsoftonline.com/"
+
+// workloadIdentityCredential exchanges the federated token that workload
+// identity (e.g. on AKS or in GitHub Actions) writes to
+// AZURE_FEDERATED_TOKEN_FILE for an Azure AD token. The pinned azidentity
+// predates ClientAssertionCredential, so the exchange is done here.
+type workloadIdentityCredential struct {
+	tenantID      string
+	clientID      string
+	tokenFile     string
+	authorityHost string
+	pipeline      azruntime.Pipeline
+}
+
+func newWorkloadIdentityCredential(opts azcore.ClientOptions) *workloadIdentityCredential {
+	host := os.Getenv("AZURE_AUTHORITY_HOST")
+	if host == "" {
+		host = defaultAuthorityHost
+	}
+	return &workloadIdentityCredential{
+		tenantID:      os.Getenv("AZURE_TENANT_ID"),
+		clientID:      os.Getenv("AZURE_CLIENT_ID"),
+		tokenFile:     os.Getenv("AZURE_FEDERATED_TOKEN_FILE"),
+		authorityHost: strings.TrimSuffix(host, "/") + "/",
+		pipeline:      azruntime.NewPipeline("bk_azureblob", "v1", nil, nil, &opts),
+	}
+}
+
+// GetToken exchanges the federated token for an access token, from the
+// tenant of opts if it names one. The file is read on every call because the
+// platform rotates it.
+func (c *workloadIdentityCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (*azcore.AccessToken, error) {
+	assertion, err := os.ReadFile(c.tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("workload identity: %w", err)
+	}
+	form := url.Values{
+		"client_id":             {c.clientID},
+		"scope":                 {strings.Join(opts.Scopes, " ")},
+		"grant_type":            {"client_credentials"},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {strings.TrimSpace(string(assertion))},
+	}
+	tenantID := c.tenantID
+	if opts.TenantID != "" {
+		tenantID = opts.TenantID
+	}
+	endpoint := c.authorityHost + url.PathEscape(tenantID) + "/oauth2/v2.0/token"
+	req, err := azruntime.NewRequest(ctx, http.MethodPost, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	body := streaming.NopCloser(strings.NewReader(form.Encode()))
+	if err := req.SetBody(body, "application/x-www-form-urlencoded"); err != nil {
+		return nil, err
+	}
+	resp, err := c.pipeline.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("workload identity: %w", err)
+	}
+	if !azruntime.HasStatusCode(resp, http.StatusOK) {
+		return nil, fmt.Errorf("workload identity: %w", azruntime.NewResponseError(errors.New("token exchange failed"), resp))
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := azruntime.UnmarshalAsJSON(resp, &token); err != nil {
+		return nil, fmt.Errorf("workload identity: %w", err)
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("workload identity: token response has no access_token")
+	}
+	return &azcore.AccessToken{
+		Token:     token.AccessToken,
+		ExpiresOn: time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+	}, nil
+}
@@ -0,0 +1,96 @@
+package azureblob
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+func newTestWorkloadIdentity(t *testing.T, handler http.HandlerFunc) *workloadIdentityCredential {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	token := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(token, []byte("federated-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AZURE_FEDERATED_TOKEN_FILE", token)
+	t.Setenv("AZURE_CLIENT_ID", "client")
+	t.Setenv("AZURE_TENANT_ID", "tenant")
+	t.Setenv("AZURE_AUTHORITY_HOST", srv.URL)
+	return newWorkloadIdentityCredential(azcore.ClientOptions{
+		Transport: srv.Client(),
+		Retry:     policy.RetryOptions{MaxRetries: -1},
+	})
+}
+
+func TestWorkloadIdentityCredential(t *testing.T) {
+	cred := newTestWorkloadIdentity(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/tenant/oauth2/v2.0/token" {
+			t.Errorf("got %s %s", r.Method, r.URL.Path)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		for k, want := range map[string]string{
+			"client_id":             "client",
+			"scope":                 storageScope,
+			"grant_type":            "client_credentials",
+			"client_assertion_type": "urn:ietf:params:oauth:client-assertion-type:jwt-bearer",
+			"client_assertion":      "federated-token",
+		} {
+			if got := r.PostForm.Get(k); got != want {
+				t.Errorf("%s = %q, want %q", k, got, want)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"token_type":"Bearer","expires_in":3600,"access_token":"access"}`))
+	})
+	token, err := cred.GetToken(context.Background(), policy.TokenRequestOptions{Scopes: []string{storageScope}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Token != "access" {
+		t.Errorf("token = %q, want access", token.Token)
+	}
+	if d := time.Until(token.ExpiresOn); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("token expires in %v, want an hour", d)
+	}
+}
+
+func TestWorkloadIdentityCredentialErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{"rejected", http.StatusUnauthorized, `{"error":"invalid_client"}`, "token exchange failed"},
+		{"no token", http.StatusOK, `{}`, "no access_token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := newTestWorkloadIdentity(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+			_, err := cred.GetToken(context.Background(), policy.TokenRequestOptions{Scopes: []string{storageScope}})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+	cred := newTestWorkloadIdentity(t, func(w http.ResponseWriter, r *http.Request) {})
+	cred.tokenFile = filepath.Join(t.TempDir(), "missing")
+	if _, err := cred.GetToken(context.Background(), policy.TokenRequestOptions{Scopes: []string{storageScope}}); err == nil {
+		t.Error("GetToken succeeded without a federated token file")
+	}
+}
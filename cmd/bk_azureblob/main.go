@@ -0,0 +1,10 @@
+// Command bk_azureblob transfers files to and from Azure Blob Storage. The
+// client it is built on is the package
+// github.com/discentem/bk_azureblob/azureblob.
+package main
+
+import "github.com/discentem/bk_azureblob/azureblob"
+
+func main() {
+	azureblob.Main(azureblob.Profile{TenantID: tenantID, ClientID: clientID, StorageAccount: storageAccount, Container: containerName})
+}
@@ -0,0 +1,244 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: transferpb/transfer.proto
+
+package transferpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// TransferServiceClient is the client API for TransferService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TransferServiceClient interface {
+	// Transfer downloads a blob to a file, or uploads a file to a blob, on the
+	// server's machine. It streams the progress of the transfer every second
+	// and a final message once it succeeded; a failed transfer ends the stream
+	// with an error.
+	Transfer(ctx context.Context, in *TransferRequest, opts ...grpc.CallOption) (TransferService_TransferClient, error)
+	// List streams the blobs whose names start with a prefix, in name order.
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (TransferService_ListClient, error)
+	// Stat returns the properties of one blob.
+	Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*Blob, error)
+}
+
+type transferServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTransferServiceClient(cc grpc.ClientConnInterface) TransferServiceClient {
+	return &transferServiceClient{cc}
+}
+
+func (c *transferServiceClient) Transfer(ctx context.Context, in *TransferRequest, opts ...grpc.CallOption) (TransferService_TransferClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TransferService_ServiceDesc.Streams[0], "/bk_azureblob.transfer.v1.TransferService/Transfer", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &transferServiceTransferClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TransferService_TransferClient interface {
+	Recv() (*TransferProgress, error)
+	grpc.ClientStream
+}
+
+type transferServiceTransferClient struct {
+	grpc.ClientStream
+}
+
+func (x *transferServiceTransferClient) Recv() (*TransferProgress, error) {
+	m := new(TransferProgress)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *transferServiceClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (TransferService_ListClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TransferService_ServiceDesc.Streams[1], "/bk_azureblob.transfer.v1.TransferService/List", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &transferServiceListClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TransferService_ListClient interface {
+	Recv() (*Blob, error)
+	grpc.ClientStream
+}
+
+type transferServiceListClient struct {
+	grpc.ClientStream
+}
+
+func (x *transferServiceListClient) Recv() (*Blob, error) {
+	m := new(Blob)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *transferServiceClient) Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*Blob, error) {
+	out := new(Blob)
+	err := c.cc.Invoke(ctx, "/bk_azureblob.transfer.v1.TransferService/Stat", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TransferServiceServer is the server API for TransferService service.
+// All implementations must embed UnimplementedTransferServiceServer
+// for forward compatibility
+type TransferServiceServer interface {
+	// Transfer downloads a blob to a file, or uploads a file to a blob, on the
+	// server's machine. It streams the progress of the transfer every second
+	// and a final message once it succeeded; a failed transfer ends the stream
+	// with an error.
+	Transfer(*TransferRequest, TransferService_TransferServer) error
+	// List streams the blobs whose names start with a prefix, in name order.
+	List(*ListRequest, TransferService_ListServer) error
+	// Stat returns the properties of one blob.
+	Stat(context.Context, *StatRequest) (*Blob, error)
+	mustEmbedUnimplementedTransferServiceServer()
+}
+
+// UnimplementedTransferServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedTransferServiceServer struct {
+}
+
+func (UnimplementedTransferServiceServer) Transfer(*TransferRequest, TransferService_TransferServer) error {
+	return status.Errorf(codes.Unimplemented, "method Transfer not implemented")
+}
+func (UnimplementedTransferServiceServer) List(*ListRequest, TransferService_ListServer) error {
+	return status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedTransferServiceServer) Stat(context.Context, *StatRequest) (*Blob, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stat not implemented")
+}
+func (UnimplementedTransferServiceServer) mustEmbedUnimplementedTransferServiceServer() {}
+
+// UnsafeTransferServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TransferServiceServer will
+// result in compilation errors.
+type UnsafeTransferServiceServer interface {
+	mustEmbedUnimplementedTransferServiceServer()
+}
+
+func RegisterTransferServiceServer(s grpc.ServiceRegistrar, srv TransferServiceServer) {
+	s.RegisterService(&TransferService_ServiceDesc, srv)
+}
+
+func _TransferService_Transfer_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TransferRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TransferServiceServer).Transfer(m, &transferServiceTransferServer{stream})
+}
+
+type TransferService_TransferServer interface {
+	Send(*TransferProgress) error
+	grpc.ServerStream
+}
+
+type transferServiceTransferServer struct {
+	grpc.ServerStream
+}
+
+func (x *transferServiceTransferServer) Send(m *TransferProgress) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _TransferService_List_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TransferServiceServer).List(m, &transferServiceListServer{stream})
+}
+
+type TransferService_ListServer interface {
+	Send(*Blob) error
+	grpc.ServerStream
+}
+
+type transferServiceListServer struct {
+	grpc.ServerStream
+}
+
+func (x *transferServiceListServer) Send(m *Blob) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _TransferService_Stat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TransferServiceServer).Stat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bk_azureblob.transfer.v1.TransferService/Stat",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TransferServiceServer).Stat(ctx, req.(*StatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// TransferService_ServiceDesc is the grpc.ServiceDesc for TransferService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TransferService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "bk_azureblob.transfer.v1.TransferService",
+	HandlerType: (*TransferServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Stat",
+			Handler:    _TransferService_Stat_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Transfer",
+			Handler:       _TransferService_Transfer_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "List",
+			Handler:       _TransferService_List_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "transferpb/transfer.proto",
+}